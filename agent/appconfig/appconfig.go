@@ -86,6 +86,14 @@ func (config SsmagentConfig) ProfileCredentials() (credsInConfig *credentials.Cr
 	return
 }
 
+// S3OutputKeySuffix returns the suffix of the keys of the plugin outputs uploaded to S3, empty unless they are compressed
+func (config SsmagentConfig) S3OutputKeySuffix() string {
+	if config.Mds.CompressS3Output {
+		return CompressedS3OutputSuffix
+	}
+	return ""
+}
+
 // looks for appconfig in working directory first and then the platform specific folder
 func getAppConfigPath() (path string, err error) {
 	// looking for appconfig in the platform specific folder
@@ -105,9 +113,27 @@ func DefaultConfig() SsmagentConfig {
 	}
 	var s3 S3Cfg
 	var mds = MdsCfg{
-		CommandWorkersLimit: 5,
-		StopTimeoutMillis:   20000,
-		CommandRetryLimit:   15,
+		CommandWorkersLimit:                5,
+		CancelWorkersLimit:                 DefaultCancelWorkersLimit,
+		StopTimeoutMillis:                  20000,
+		CommandRetryLimit:                  15,
+		OrchestrationRetentionDays:         DefaultOrchestrationRetentionDays,
+		OrchestrationRetentionMaxCount:     DefaultOrchestrationRetentionMaxCount,
+		OrchestrationMinFreeInodesPercent:  DefaultOrchestrationMinFreeInodesPercent,
+		OrchestrationCleanupWorkers:        DefaultOrchestrationCleanupWorkers,
+		MaxDocumentReboots:                 DefaultMaxDocumentReboots,
+		MaxPluginOutputBytes:               DefaultMaxPluginOutputBytes,
+		ParseRetryCount:                    DefaultParseRetryCount,
+		MaxDocumentRuntimeSeconds:          DefaultMaxDocumentRuntimeSeconds,
+		OutputWarnBytes:                    DefaultOutputWarnBytes,
+		MaxMessageFailures:                 DefaultMaxMessageFailures,
+		MaxMessagePayloadBytes:             DefaultMaxMessagePayloadBytes,
+		InProgressReplyJitterMillis:        DefaultInProgressReplyJitterMillis,
+		ReplyToDeleteDelayMillis:           DefaultReplyToDeleteDelayMillis,
+		CircuitBreakerFailureThreshold:     DefaultCircuitBreakerFailureThreshold,
+		CircuitBreakerCooldownSeconds:      DefaultCircuitBreakerCooldownSeconds,
+		UnsupportedDocumentsRefreshMinutes: DefaultUnsupportedDocumentsRefreshMinutes,
+		ClockSkewToleranceSeconds:          DefaultClockSkewToleranceSeconds,
 	}
 	var ssm = SsmCfg{
 		HealthFrequencyMinutes:         5,
@@ -118,19 +144,35 @@ func DefaultConfig() SsmagentConfig {
 	var agent = AgentInfo{
 		Name:                 "amazon-ssm-agent",
 		OrchestrationRootDir: defaultOrchestrationRootDirName,
+		Timezone:             DefaultTimezone,
 	}
 	var os = OsInfo{
 		Lang:    "en-US",
 		Version: "1",
 	}
+	var plugins = PluginCfg{
+		PowerShellExecutionPolicy:       DefaultPowerShellExecutionPolicy,
+		MaxConcurrentPluginsPerDocument: DefaultMaxConcurrentPluginsPerDocument,
+	}
+
+	var configurePackage = ConfigurePackageCfg{
+		MaxManifestBytes:       DefaultMaxManifestBytes,
+		MinTLSVersion:          DefaultMinTLSVersion,
+		MaxConcurrentDownloads: DefaultMaxConcurrentDownloads,
+		PackageCacheTTLMinutes: DefaultPackageCacheTTLMinutes,
+		MaxLockAgeMinutes:      DefaultMaxLockAgeMinutes,
+		DownloadTimeoutSeconds: DefaultDownloadTimeoutSeconds,
+	}
 
 	var ssmagentCfg = SsmagentConfig{
-		Profile: credsProfile,
-		Mds:     mds,
-		Ssm:     ssm,
-		Agent:   agent,
-		Os:      os,
-		S3:      s3,
+		Profile:          credsProfile,
+		Mds:              mds,
+		Ssm:              ssm,
+		Agent:            agent,
+		Os:               os,
+		S3:               s3,
+		Plugins:          plugins,
+		ConfigurePackage: configurePackage,
 	}
 
 	return ssmagentCfg
@@ -17,6 +17,9 @@ package appconfig
 
 import (
 	"log"
+	"net/url"
+	"strings"
+	"time"
 )
 
 //func parser(config *T) {
@@ -27,6 +30,7 @@ func parser(config *SsmagentConfig) {
 	config.Agent.Name = getStringValue(config.Agent.Name, DefaultAgentName)
 	config.Agent.OrchestrationRootDir = getStringValue(config.Agent.OrchestrationRootDir, defaultOrchestrationRootDirName)
 	config.Agent.Region = getStringValue(config.Agent.Region, "")
+	config.Agent.Timezone = getTimezoneValue(config.Agent.Timezone, DefaultTimezone)
 
 	// MDS config
 	config.Mds.CommandWorkersLimit = getNumericValue(
@@ -34,6 +38,11 @@ func parser(config *SsmagentConfig) {
 		DefaultCommandWorkersLimitMin,
 		DefaultCommandWorkersLimitMax,
 		DefaultCommandWorkersLimit)
+	config.Mds.CancelWorkersLimit = getNumericValue(
+		config.Mds.CancelWorkersLimit,
+		DefaultCancelWorkersLimitMin,
+		DefaultCancelWorkersLimitMax,
+		DefaultCancelWorkersLimit)
 	config.Mds.CommandRetryLimit = getNumericValue(
 		config.Mds.CommandRetryLimit,
 		DefaultCommandRetryLimitMin,
@@ -44,10 +53,100 @@ func parser(config *SsmagentConfig) {
 		DefaultStopTimeoutMillisMin,
 		DefaultStopTimeoutMillisMax,
 		DefaultStopTimeoutMillis)
-	config.Mds.Endpoint = getStringValue(config.Mds.Endpoint, "")
+	config.Mds.Endpoint = getEndpointValue(config.Mds.Endpoint, "")
+	config.Mds.OrchestrationRetentionDays = getNumericValue(
+		config.Mds.OrchestrationRetentionDays,
+		DefaultOrchestrationRetentionDaysMin,
+		DefaultOrchestrationRetentionDaysMax,
+		DefaultOrchestrationRetentionDays)
+	config.Mds.OrchestrationRetentionMaxCount = getNumericValue(
+		config.Mds.OrchestrationRetentionMaxCount,
+		DefaultOrchestrationRetentionMaxCountMin,
+		DefaultOrchestrationRetentionMaxCountMax,
+		DefaultOrchestrationRetentionMaxCount)
+	config.Mds.OrchestrationMinFreeInodesPercent = getNumericValue(
+		config.Mds.OrchestrationMinFreeInodesPercent,
+		DefaultOrchestrationMinFreeInodesPercentMin,
+		DefaultOrchestrationMinFreeInodesPercentMax,
+		DefaultOrchestrationMinFreeInodesPercent)
+	config.Mds.OrchestrationCleanupWorkers = getNumericValue(
+		config.Mds.OrchestrationCleanupWorkers,
+		DefaultOrchestrationCleanupWorkersMin,
+		DefaultOrchestrationCleanupWorkersMax,
+		DefaultOrchestrationCleanupWorkers)
+	config.Mds.MaxDocumentReboots = getNumericValue(
+		config.Mds.MaxDocumentReboots,
+		DefaultMaxDocumentRebootsMin,
+		DefaultMaxDocumentRebootsMax,
+		DefaultMaxDocumentReboots)
+	config.Mds.MaxPluginOutputBytes = getNumericValue(
+		config.Mds.MaxPluginOutputBytes,
+		DefaultMaxPluginOutputBytesMin,
+		DefaultMaxPluginOutputBytesMax,
+		DefaultMaxPluginOutputBytes)
+	config.Mds.ParseRetryCount = getNumericValue(
+		config.Mds.ParseRetryCount,
+		DefaultParseRetryCountMin,
+		DefaultParseRetryCountMax,
+		DefaultParseRetryCount)
+	config.Mds.MaxDocumentRuntimeSeconds = getNumericValue(
+		config.Mds.MaxDocumentRuntimeSeconds,
+		DefaultMaxDocumentRuntimeSecondsMin,
+		DefaultMaxDocumentRuntimeSecondsMax,
+		DefaultMaxDocumentRuntimeSeconds)
+	config.Mds.OutputWarnBytes = getNumericValue(
+		config.Mds.OutputWarnBytes,
+		DefaultOutputWarnBytesMin,
+		DefaultOutputWarnBytesMax,
+		DefaultOutputWarnBytes)
+	config.Mds.MaxMessageFailures = getNumericValue(
+		config.Mds.MaxMessageFailures,
+		DefaultMaxMessageFailuresMin,
+		DefaultMaxMessageFailuresMax,
+		DefaultMaxMessageFailures)
+	config.Mds.MaxMessagePayloadBytes = getNumericValue(
+		config.Mds.MaxMessagePayloadBytes,
+		DefaultMaxMessagePayloadBytesMin,
+		DefaultMaxMessagePayloadBytesMax,
+		DefaultMaxMessagePayloadBytes)
+	config.Mds.InProgressReplyJitterMillis = getNumericValue(
+		config.Mds.InProgressReplyJitterMillis,
+		DefaultInProgressReplyJitterMillisMin,
+		DefaultInProgressReplyJitterMillisMax,
+		DefaultInProgressReplyJitterMillis)
+	config.Mds.ReplyToDeleteDelayMillis = getNumericValue(
+		config.Mds.ReplyToDeleteDelayMillis,
+		DefaultReplyToDeleteDelayMillisMin,
+		DefaultReplyToDeleteDelayMillisMax,
+		DefaultReplyToDeleteDelayMillis)
+	config.Mds.CircuitBreakerFailureThreshold = getNumericValue(
+		config.Mds.CircuitBreakerFailureThreshold,
+		DefaultCircuitBreakerFailureThresholdMin,
+		DefaultCircuitBreakerFailureThresholdMax,
+		DefaultCircuitBreakerFailureThreshold)
+	config.Mds.CircuitBreakerCooldownSeconds = getNumericValue(
+		config.Mds.CircuitBreakerCooldownSeconds,
+		DefaultCircuitBreakerCooldownSecondsMin,
+		DefaultCircuitBreakerCooldownSecondsMax,
+		DefaultCircuitBreakerCooldownSeconds)
+	config.Mds.S3KeyPrefixTemplate = getStringValue(config.Mds.S3KeyPrefixTemplate, "")
+	config.Mds.DeadLetterS3Bucket = getStringValue(config.Mds.DeadLetterS3Bucket, "")
+	config.Mds.CompletionWebhookURL = getHTTPURLValue(config.Mds.CompletionWebhookURL, "")
+	config.Mds.UnsupportedDocumentsURL = getHTTPURLValue(config.Mds.UnsupportedDocumentsURL, "")
+	config.Mds.UnsupportedDocumentsRefreshMinutes = getNumericValue(
+		config.Mds.UnsupportedDocumentsRefreshMinutes,
+		DefaultUnsupportedDocumentsRefreshMinutesMin,
+		DefaultUnsupportedDocumentsRefreshMinutesMax,
+		DefaultUnsupportedDocumentsRefreshMinutes)
+	config.Mds.ClockSkewToleranceSeconds = getNumericValue(
+		config.Mds.ClockSkewToleranceSeconds,
+		DefaultClockSkewToleranceSecondsMin,
+		DefaultClockSkewToleranceSecondsMax,
+		DefaultClockSkewToleranceSeconds)
+	config.Mds.ProcessDocumentTypes = getDocumentTypesValue(config.Mds.ProcessDocumentTypes)
 
 	// SSM config
-	config.Ssm.Endpoint = getStringValue(config.Ssm.Endpoint, "")
+	config.Ssm.Endpoint = getEndpointValue(config.Ssm.Endpoint, "")
 	config.Ssm.HealthFrequencyMinutes = getNumericValue(
 		config.Ssm.HealthFrequencyMinutes,
 		DefaultSsmHealthFrequencyMinutesMin,
@@ -58,6 +157,48 @@ func parser(config *SsmagentConfig) {
 		DefaultSsmAssociationFrequencyMinutesMin,
 		DefaultSsmAssociationFrequencyMinutesMax,
 		DefaultSsmAssociationFrequencyMinutes)
+
+	// Plugins config
+	config.Plugins.PowerShellExecutionPolicy = getExecutionPolicyValue(
+		config.Plugins.PowerShellExecutionPolicy,
+		DefaultPowerShellExecutionPolicy)
+	config.Plugins.PackageManifestTrustAnchor = getStringValue(config.Plugins.PackageManifestTrustAnchor, "")
+	config.Plugins.MaxConcurrentPluginsPerDocument = getNumericValue(
+		config.Plugins.MaxConcurrentPluginsPerDocument,
+		DefaultMaxConcurrentPluginsPerDocumentMin,
+		DefaultMaxConcurrentPluginsPerDocumentMax,
+		DefaultMaxConcurrentPluginsPerDocument)
+
+	// Log config
+	config.Log.Levels = getLogLevelsValue(config.Log.Levels)
+
+	// ConfigurePackage config
+	config.ConfigurePackage.MaxManifestBytes = getNumeric64Value(
+		config.ConfigurePackage.MaxManifestBytes,
+		DefaultMaxManifestBytesMin,
+		DefaultMaxManifestBytesMax,
+		DefaultMaxManifestBytes)
+	config.ConfigurePackage.ProxyURL = getProxyURLValue(config.ConfigurePackage.ProxyURL, "")
+	config.ConfigurePackage.MaxConcurrentDownloads = getNumericValue(
+		config.ConfigurePackage.MaxConcurrentDownloads,
+		DefaultMaxConcurrentDownloadsMin,
+		DefaultMaxConcurrentDownloadsMax,
+		DefaultMaxConcurrentDownloads)
+	config.ConfigurePackage.PackageCacheTTLMinutes = getNumericValue(
+		config.ConfigurePackage.PackageCacheTTLMinutes,
+		DefaultPackageCacheTTLMinutesMin,
+		DefaultPackageCacheTTLMinutesMax,
+		DefaultPackageCacheTTLMinutes)
+	config.ConfigurePackage.MaxLockAgeMinutes = getNumericValue(
+		config.ConfigurePackage.MaxLockAgeMinutes,
+		DefaultMaxLockAgeMinutesMin,
+		DefaultMaxLockAgeMinutesMax,
+		DefaultMaxLockAgeMinutes)
+	config.ConfigurePackage.DownloadTimeoutSeconds = getNumericValue(
+		config.ConfigurePackage.DownloadTimeoutSeconds,
+		DefaultDownloadTimeoutSecondsMin,
+		DefaultDownloadTimeoutSecondsMax,
+		DefaultDownloadTimeoutSeconds)
 }
 
 func getStringValue(configValue string, defaultValue string) string {
@@ -67,6 +208,105 @@ func getStringValue(configValue string, defaultValue string) string {
 	return configValue
 }
 
+func getTimezoneValue(configValue string, defaultValue string) string {
+	if configValue == "" {
+		return defaultValue
+	}
+	if _, err := time.LoadLocation(configValue); err != nil {
+		log.Printf("unknown timezone %v, falling back to %v", configValue, defaultValue)
+		return defaultValue
+	}
+	return configValue
+}
+
+func getHTTPURLValue(configValue string, defaultValue string) string {
+	if configValue == "" {
+		return defaultValue
+	}
+	if webhookURL, err := url.Parse(configValue); err != nil || webhookURL.Host == "" ||
+		(webhookURL.Scheme != "http" && webhookURL.Scheme != "https") {
+		log.Printf("invalid http(s) url %v, falling back to %v", configValue, defaultValue)
+		return defaultValue
+	}
+	return configValue
+}
+
+func getProxyURLValue(configValue string, defaultValue string) string {
+	if configValue == "" {
+		return defaultValue
+	}
+	if proxyURL, err := url.Parse(configValue); err != nil || proxyURL.Host == "" ||
+		(proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") {
+		log.Printf("invalid proxy url %v, falling back to %v", configValue, defaultValue)
+		return defaultValue
+	}
+	return configValue
+}
+
+// getEndpointValue accepts a service endpoint given either as a host name or as an http(s) url
+func getEndpointValue(configValue string, defaultValue string) string {
+	if configValue == "" {
+		return defaultValue
+	}
+	endpoint := configValue
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	if endpointURL, err := url.Parse(endpoint); err != nil || endpointURL.Host == "" ||
+		(endpointURL.Scheme != "http" && endpointURL.Scheme != "https") {
+		log.Printf("invalid endpoint %v, falling back to %v", configValue, defaultValue)
+		return defaultValue
+	}
+	return configValue
+}
+
+func getLogLevelsValue(configValue map[string]string) map[string]string {
+	levels := make(map[string]string)
+	for subsystem, level := range configValue {
+		valid := false
+		for _, logLevel := range LogLevels {
+			if strings.EqualFold(level, logLevel) {
+				levels[subsystem] = logLevel
+				valid = true
+			}
+		}
+		if !valid {
+			log.Printf("unknown log level %v of subsystem %v, ignoring it", level, subsystem)
+		}
+	}
+	return levels
+}
+
+func getDocumentTypesValue(configValue []string) []string {
+	documentTypes := []string{}
+	for _, documentType := range configValue {
+		valid := false
+		for _, knownType := range DocumentTypes {
+			if strings.EqualFold(documentType, knownType) {
+				documentTypes = append(documentTypes, knownType)
+				valid = true
+			}
+		}
+		if !valid {
+			log.Printf("unknown document type %v, ignoring it", documentType)
+		}
+	}
+	return documentTypes
+}
+
+func getExecutionPolicyValue(configValue string, defaultValue string) string {
+	if configValue == "" {
+		return defaultValue
+	}
+	for _, policy := range PowerShellExecutionPolicies {
+		if strings.EqualFold(configValue, policy) {
+			return policy
+		}
+	}
+	log.Printf("unknown powershell execution policy %v, falling back to %v", configValue, defaultValue)
+	return defaultValue
+}
+
 func getNumericValue(configValue int, minValue int, maxValue int, defaultValue int) int {
 	if configValue < minValue || configValue > maxValue {
 		return defaultValue
@@ -41,6 +41,112 @@ func TestGetStringValue(t *testing.T) {
 	}
 }
 
+// getTimezoneValue Tests
+
+var (
+	getTimezoneValueTests = []GetStringValueTest{
+		{"", "UTC", "UTC"},
+		{"Asia/Tokyo", "UTC", "Asia/Tokyo"},
+		{"Not/AZone", "UTC", "UTC"},
+	}
+)
+
+func TestGetTimezoneValue(t *testing.T) {
+	for _, test := range getTimezoneValueTests {
+		output := getTimezoneValue(test.Input, test.DefaultValue)
+		assert.Equal(t, test.Output, output)
+	}
+}
+
+// getHTTPURLValue Tests
+
+var (
+	getHTTPURLValueTests = []GetStringValueTest{
+		{"", "", ""},
+		{"http://localhost:8080/completed", "", "http://localhost:8080/completed"},
+		{"https://127.0.0.1/events", "", "https://127.0.0.1/events"},
+		{"localhost:8080", "", ""},
+		{"ftp://localhost/completed", "", ""},
+	}
+)
+
+func TestGetHTTPURLValue(t *testing.T) {
+	for _, test := range getHTTPURLValueTests {
+		output := getHTTPURLValue(test.Input, test.DefaultValue)
+		assert.Equal(t, test.Output, output)
+	}
+}
+
+// getProxyURLValue Tests
+
+var (
+	getProxyURLValueTests = []GetStringValueTest{
+		{"", "", ""},
+		{"http://proxy.corp.example.com:3128", "", "http://proxy.corp.example.com:3128"},
+		{"socks5://127.0.0.1:1080", "", "socks5://127.0.0.1:1080"},
+		{"proxy.corp.example.com:3128", "", ""},
+		{"ftp://proxy.corp.example.com", "", ""},
+	}
+)
+
+func TestGetProxyURLValue(t *testing.T) {
+	for _, test := range getProxyURLValueTests {
+		output := getProxyURLValue(test.Input, test.DefaultValue)
+		assert.Equal(t, test.Output, output)
+	}
+}
+
+// getEndpointValue Tests
+
+var (
+	getEndpointValueTests = []GetStringValueTest{
+		{"", "", ""},
+		{"ssmmessages.us-gov-west-1.amazonaws.com", "", "ssmmessages.us-gov-west-1.amazonaws.com"},
+		{"https://ssm.us-iso-east-1.c2s.ic.gov", "", "https://ssm.us-iso-east-1.c2s.ic.gov"},
+		{"http://localhost:8080", "", "http://localhost:8080"},
+		{"ftp://ssm.us-east-1.amazonaws.com", "", ""},
+		{"https://", "", ""},
+		{"https://ssm host", "", ""},
+	}
+)
+
+func TestGetEndpointValue(t *testing.T) {
+	for _, test := range getEndpointValueTests {
+		output := getEndpointValue(test.Input, test.DefaultValue)
+		assert.Equal(t, test.Output, output)
+	}
+}
+
+// getExecutionPolicyValue Tests
+
+var (
+	getExecutionPolicyValueTests = []GetStringValueTest{
+		{"", DefaultPowerShellExecutionPolicy, DefaultPowerShellExecutionPolicy},
+		{"RemoteSigned", DefaultPowerShellExecutionPolicy, "RemoteSigned"},
+		{"allsigned", DefaultPowerShellExecutionPolicy, "AllSigned"},
+		{"NotAPolicy", DefaultPowerShellExecutionPolicy, DefaultPowerShellExecutionPolicy},
+	}
+)
+
+func TestGetExecutionPolicyValue(t *testing.T) {
+	for _, test := range getExecutionPolicyValueTests {
+		output := getExecutionPolicyValue(test.Input, test.DefaultValue)
+		assert.Equal(t, test.Output, output)
+	}
+}
+
+func TestGetLogLevelsValue(t *testing.T) {
+	levels := getLogLevelsValue(map[string]string{"processor": "Info", "engine": "debug", "plugins": "verbose"})
+	assert.Equal(t, map[string]string{"processor": "info", "engine": "debug"}, levels)
+	assert.Equal(t, map[string]string{}, getLogLevelsValue(nil))
+}
+
+func TestGetDocumentTypesValue(t *testing.T) {
+	documentTypes := getDocumentTypesValue([]string{"cancelcommand", "Association", "SendCommand"})
+	assert.Equal(t, []string{DocumentTypeCancelCommand, DocumentTypeSendCommand}, documentTypes)
+	assert.Equal(t, []string{}, getDocumentTypesValue(nil))
+}
+
 // getNumericValue Tests
 
 type GetNumericValueTest struct {
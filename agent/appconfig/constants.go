@@ -18,10 +18,20 @@ const (
 	// Agent defaults
 	DefaultAgentName = "amazon-ssm-agent"
 
+	// DefaultTimezone is used when no timezone or an unknown timezone is configured
+	DefaultTimezone = "UTC"
+
+	// DefaultPowerShellExecutionPolicy is the execution policy powershell scripts are run with
+	DefaultPowerShellExecutionPolicy = "Unrestricted"
+
 	DefaultCommandWorkersLimit    = 1
 	DefaultCommandWorkersLimitMin = 1
 	DefaultCommandWorkersLimitMax = 10
 
+	DefaultCancelWorkersLimit    = 3
+	DefaultCancelWorkersLimitMin = 1
+	DefaultCancelWorkersLimitMax = 10
+
 	DefaultCommandRetryLimit    = 15
 	DefaultCommandRetryLimitMin = 1
 	DefaultCommandRetryLimitMax = 100
@@ -30,6 +40,101 @@ const (
 	DefaultStopTimeoutMillisMin = 10000
 	DefaultStopTimeoutMillisMax = 1000000
 
+	DefaultOrchestrationRetentionDays    = 30
+	DefaultOrchestrationRetentionDaysMin = 1
+	DefaultOrchestrationRetentionDaysMax = 365
+
+	DefaultOrchestrationRetentionMaxCount    = 1000
+	DefaultOrchestrationRetentionMaxCountMin = 10
+	DefaultOrchestrationRetentionMaxCountMax = 100000
+
+	DefaultOrchestrationMinFreeInodesPercent    = 0
+	DefaultOrchestrationMinFreeInodesPercentMin = 0
+	DefaultOrchestrationMinFreeInodesPercentMax = 50
+
+	DefaultOrchestrationCleanupWorkers    = 4
+	DefaultOrchestrationCleanupWorkersMin = 1
+	DefaultOrchestrationCleanupWorkersMax = 32
+
+	DefaultMaxDocumentReboots    = 10
+	DefaultMaxDocumentRebootsMin = 1
+	DefaultMaxDocumentRebootsMax = 100
+
+	DefaultMaxPluginOutputBytes    = 0
+	DefaultMaxPluginOutputBytesMin = 2500
+	DefaultMaxPluginOutputBytesMax = 100000
+
+	DefaultParseRetryCount    = 3
+	DefaultParseRetryCountMin = 0
+	DefaultParseRetryCountMax = 10
+
+	DefaultMaxDocumentRuntimeSeconds    = 0
+	DefaultMaxDocumentRuntimeSecondsMin = 0
+	DefaultMaxDocumentRuntimeSecondsMax = 172800
+
+	DefaultOutputWarnBytes    = 1000000
+	DefaultOutputWarnBytesMin = 0
+	DefaultOutputWarnBytesMax = 100000000
+
+	DefaultMaxMessageFailures    = 0
+	DefaultMaxMessageFailuresMin = 0
+	DefaultMaxMessageFailuresMax = 100
+
+	DefaultMaxMessagePayloadBytes    = 32 * 1024 * 1024
+	DefaultMaxMessagePayloadBytesMin = 64 * 1024
+	DefaultMaxMessagePayloadBytesMax = 1024 * 1024 * 1024
+
+	DefaultInProgressReplyJitterMillis    = 0
+	DefaultInProgressReplyJitterMillisMin = 0
+	DefaultInProgressReplyJitterMillisMax = 60000
+
+	DefaultReplyToDeleteDelayMillis    = 0
+	DefaultReplyToDeleteDelayMillisMin = 0
+	DefaultReplyToDeleteDelayMillisMax = 60000
+
+	DefaultCircuitBreakerFailureThreshold    = 0
+	DefaultCircuitBreakerFailureThresholdMin = 0
+	DefaultCircuitBreakerFailureThresholdMax = 100
+
+	DefaultCircuitBreakerCooldownSeconds    = 30
+	DefaultCircuitBreakerCooldownSecondsMin = 1
+	DefaultCircuitBreakerCooldownSecondsMax = 3600
+
+	DefaultUnsupportedDocumentsRefreshMinutes    = 60
+	DefaultUnsupportedDocumentsRefreshMinutesMin = 5
+	DefaultUnsupportedDocumentsRefreshMinutesMax = 1440
+
+	DefaultClockSkewToleranceSeconds    = 300
+	DefaultClockSkewToleranceSecondsMin = 0
+	DefaultClockSkewToleranceSecondsMax = 3600
+
+	// Plugins defaults
+	DefaultMaxConcurrentPluginsPerDocument    = 1
+	DefaultMaxConcurrentPluginsPerDocumentMin = 1
+	DefaultMaxConcurrentPluginsPerDocumentMax = 16
+
+	// ConfigurePackage defaults
+	DefaultMaxManifestBytes    = 1024 * 1024
+	DefaultMaxManifestBytesMin = 1024
+	DefaultMaxManifestBytesMax = 64 * 1024 * 1024
+	DefaultMinTLSVersion       = "1.2"
+
+	DefaultMaxConcurrentDownloads    = 2
+	DefaultMaxConcurrentDownloadsMin = 1
+	DefaultMaxConcurrentDownloadsMax = 32
+
+	DefaultPackageCacheTTLMinutes    = 1440
+	DefaultPackageCacheTTLMinutesMin = 0
+	DefaultPackageCacheTTLMinutesMax = 30 * 1440
+
+	DefaultMaxLockAgeMinutes    = 720
+	DefaultMaxLockAgeMinutesMin = 0
+	DefaultMaxLockAgeMinutesMax = 7 * 1440
+
+	DefaultDownloadTimeoutSeconds    = 0
+	DefaultDownloadTimeoutSecondsMin = 0
+	DefaultDownloadTimeoutSecondsMax = 86400
+
 	// SSM defaults
 	DefaultSsmHealthFrequencyMinutes    = 5
 	DefaultSsmHealthFrequencyMinutesMin = 5
@@ -46,6 +151,12 @@ const (
 	DefaultLocationOfCorrupt     = "corrupt"
 	DefaultLocationOfState       = "state"
 	DefaultLocationOfAssociation = "association"
+	DefaultLocationOfFailures    = "failures"
+	DefaultLocationOfQuarantine  = "quarantine"
+
+	// PluginDownloadsFolderName is the folder of the orchestration directory of a plugin it downloads to, the downloads
+	// are left out when the orchestration directory is compacted
+	PluginDownloadsFolderName = "downloads"
 
 	//aws-ssm-agent bookkeeping constants for long running plugins
 	LongRunningPluginsLocation         = "longrunningplugins"
@@ -109,6 +220,16 @@ const (
 	AppConfigFileName    = "amazon-ssm-agent.json"
 	SeelogConfigFileName = "seelog.xml"
 
+	// DocumentTypeSendCommand and DocumentTypeCancelCommand are the document types of Mds.ProcessDocumentTypes
+	DocumentTypeSendCommand   = "SendCommand"
+	DocumentTypeCancelCommand = "CancelCommand"
+
+	// CompressedS3OutputSuffix is the suffix of the keys of the plugin outputs uploaded to S3 gzip compressed
+	CompressedS3OutputSuffix = ".gz"
+
+	// ManagedInstanceCompatibilityFileName is the file listing custom documents to rewrite for managed instances
+	ManagedInstanceCompatibilityFileName = "managed-instance-compatibility.json"
+
 	// PluginNameDomainJoin is the name of domain join plugin
 	PluginNameDomainJoin = "aws:domainJoin"
 
@@ -124,3 +245,31 @@ const (
 	// PluginNameRefreshAssociation is the name of refresh association plugin
 	PluginNameRefreshAssociation = "aws:refreshAssociation"
 )
+
+// LogLevels lists the log levels a subsystem can be configured with
+var LogLevels = []string{
+	"trace",
+	"debug",
+	"info",
+	"warn",
+	"error",
+	"critical",
+	"off",
+}
+
+// DocumentTypes lists the document types Mds.ProcessDocumentTypes can restrict the processing to
+var DocumentTypes = []string{
+	DocumentTypeSendCommand,
+	DocumentTypeCancelCommand,
+}
+
+// PowerShellExecutionPolicies lists the execution policies supported by powershell
+var PowerShellExecutionPolicies = []string{
+	"AllSigned",
+	"Bypass",
+	"Default",
+	"RemoteSigned",
+	"Restricted",
+	"Undefined",
+	"Unrestricted",
+}
@@ -23,6 +23,9 @@ const (
 	// AppConfigPath is the path of the AppConfig
 	AppConfigPath = DefaultProgramFolder + AppConfigFileName
 
+	// ManagedInstanceCompatibilityPath is the path of the custom managed instance compatibility config
+	ManagedInstanceCompatibilityPath = DefaultProgramFolder + ManagedInstanceCompatibilityFileName
+
 	// PackageRoot specifies the directory under which packages will be downloaded and installed
 	PackageRoot = "/var/lib/amazon/ssm/packages"
 
@@ -75,12 +78,34 @@ const (
 	// Used to capture and return exit code for windows powershell script execution - empty for unix shell script case
 	ExitCodeTrap = ""
 
-	// PowerShellPluginCommandArgs is the arguments of powershell.exe to be used by the runPowerShellScript plugin
-	PowerShellPluginCommandArgs = ""
-
 	// Exit Code for a command that exits before completion (generally due to timeout or cancel)
 	CommandStoppedPreemptivelyExitCode = 137 // Fatal error (128) + signal for SIGKILL (9) = 137
 
 	// RunCommandScriptName is the script name where all downloaded or provided commands will be stored
 	RunCommandScriptName = "_script.sh"
 )
+
+// PowerShellPluginCommandArgs returns the arguments of powershell.exe to be used by the runPowerShellScript plugin
+func PowerShellPluginCommandArgs(executionPolicy string) string {
+	return ""
+}
+
+// DefaultPaths returns the locations used by the agent.
+func DefaultPaths() Paths {
+	return Paths{
+		ProgramFolder:             DefaultProgramFolder,
+		AppConfig:                 AppConfigPath,
+		DataStore:                 DefaultDataStorePath,
+		PackageRoot:               PackageRoot,
+		DaemonRoot:                DaemonRoot,
+		LocalCommandRoot:          LocalCommandRoot,
+		LocalCommandRootSubmitted: LocalCommandRootSubmitted,
+		LocalCommandRootInvalid:   LocalCommandRootInvalid,
+		DownloadRoot:              DownloadRoot,
+		UpdaterArtifactsRoot:      UpdaterArtifactsRoot,
+		PluginPath:                DefaultPluginPath,
+		EC2ConfigDataStore:        EC2ConfigDataStorePath,
+		EC2ConfigSetting:          EC2ConfigSettingPath,
+		CustomInventoryFolder:     DefaultCustomInventoryFolder,
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build darwin freebsd linux netbsd openbsd
+
+// Package appconfig manages the configuration of the agent.
+package appconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDefaultPaths tests that the default paths match the package level path variables
+func TestDefaultPaths(t *testing.T) {
+	paths := DefaultPaths()
+
+	assert.Equal(t, DefaultProgramFolder, paths.ProgramFolder)
+	assert.Equal(t, AppConfigPath, paths.AppConfig)
+	assert.Equal(t, DefaultDataStorePath, paths.DataStore)
+	assert.Equal(t, PackageRoot, paths.PackageRoot)
+	assert.Equal(t, DaemonRoot, paths.DaemonRoot)
+	assert.Equal(t, LocalCommandRoot, paths.LocalCommandRoot)
+	assert.Equal(t, LocalCommandRootSubmitted, paths.LocalCommandRootSubmitted)
+	assert.Equal(t, LocalCommandRootInvalid, paths.LocalCommandRootInvalid)
+	assert.Equal(t, DownloadRoot, paths.DownloadRoot)
+	assert.Equal(t, UpdaterArtifactsRoot, paths.UpdaterArtifactsRoot)
+	assert.Equal(t, DefaultPluginPath, paths.PluginPath)
+	assert.Equal(t, EC2ConfigDataStorePath, paths.EC2ConfigDataStore)
+	assert.Equal(t, EC2ConfigSettingPath, paths.EC2ConfigSetting)
+	assert.Equal(t, DefaultCustomInventoryFolder, paths.CustomInventoryFolder)
+}
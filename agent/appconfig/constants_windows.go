@@ -17,6 +17,8 @@
 package appconfig
 
 import (
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 )
@@ -48,10 +50,10 @@ const (
 	// PackagePlatform is the platform name to use when looking for packages
 	PackagePlatform = "windows"
 
-	// PowerShellPluginCommandArgs specifies the default arguments that we pass to powershell
-	// Use Unrestricted as Execution Policy for running the script.
+	// powerShellPluginCommandArgsFormat specifies the arguments that we pass to powershell
+	// The Execution Policy for running the script comes from the Plugins config.
 	// https://technet.microsoft.com/en-us/library/hh847748.aspx
-	PowerShellPluginCommandArgs = "-InputFormat None -Noninteractive -NoProfile -ExecutionPolicy unrestricted -f"
+	powerShellPluginCommandArgsFormat = "-InputFormat None -Noninteractive -NoProfile -ExecutionPolicy %v -f"
 
 	// Currently we run powershell as powershell.exe [arguments], with this approach we are not able to get the $LASTEXITCODE value
 	// if we want to run multiple commands then we need to run them via shell and not directly the command.
@@ -63,17 +65,28 @@ const (
 
 	// RunCommandScriptName is the script name where all downloaded or provided commands will be stored
 	RunCommandScriptName = "_script.ps1"
+
+	// AppConfigPathEnvVar is the environment variable that overrides the path of the AppConfig
+	AppConfigPathEnvVar = "SSM_APPCONFIG_PATH"
 )
 
 //PowerShellPluginCommandName is the path of the powershell.exe to be used by the runPowerShellScript plugin
 var PowerShellPluginCommandName = filepath.Join(os.Getenv("SystemRoot"), "System32", "WindowsPowerShell", "v1.0", "powershell.exe")
 
+// PowerShellPluginCommandArgs returns the arguments that we pass to powershell for the given execution policy
+func PowerShellPluginCommandArgs(executionPolicy string) string {
+	return fmt.Sprintf(powerShellPluginCommandArgsFormat, executionPolicy)
+}
+
 // Program Folder
 var DefaultProgramFolder string
 
 // AppConfig Path
 var AppConfigPath string
 
+// ManagedInstanceCompatibilityPath is the path of the custom managed instance compatibility config
+var ManagedInstanceCompatibilityPath string
+
 // DefaultDataStorePath represents the directory for storing system data
 var DefaultDataStorePath string
 
@@ -134,6 +147,52 @@ var DefaultCustomInventoryFolder string
 var PluginFolder string
 
 func init() {
+	paths := DefaultPaths()
+
+	programData := getProgramData()
+	SSMDataPath = filepath.Join(programData, SSMFolder)
+
+	EnvProgramFiles = os.Getenv("ProgramFiles")
+	EnvWinDir = os.Getenv("WINDIR")
+	temp := os.Getenv("TEMP")
+
+	DefaultProgramFolder = paths.ProgramFolder
+	DefaultPluginPath = paths.PluginPath
+	AppConfigPath = getAppConfigPathOverride(paths.AppConfig)
+	ManagedInstanceCompatibilityPath = filepath.Join(paths.ProgramFolder, ManagedInstanceCompatibilityFileName)
+	DefaultDataStorePath = paths.DataStore
+	PackageRoot = paths.PackageRoot
+	DaemonRoot = paths.DaemonRoot
+	LocalCommandRoot = paths.LocalCommandRoot
+	LocalCommandRootSubmitted = paths.LocalCommandRootSubmitted
+	LocalCommandRootInvalid = paths.LocalCommandRootInvalid
+	DownloadRoot = paths.DownloadRoot
+	UpdaterArtifactsRoot = paths.UpdaterArtifactsRoot
+	DefaultCustomInventoryFolder = paths.CustomInventoryFolder
+	EC2ConfigDataStorePath = paths.EC2ConfigDataStore
+	EC2ConfigSettingPath = paths.EC2ConfigSetting
+
+	EC2UpdateArtifactsRoot = filepath.Join(EnvWinDir, EC2ConfigServiceFolder, "Update")
+	EC2UpdaterDownloadRoot = filepath.Join(temp, EC2ConfigAppDataFolder, "Download")
+	UpdateContextFilePath = filepath.Join(programData, EC2ConfigAppDataFolder, "Update\\UpdateContext.json")
+}
+
+// getAppConfigPathOverride returns the path of the AppConfig set in AppConfigPathEnvVar, or the default path when the
+// variable is unset or isn't an absolute path
+func getAppConfigPathOverride(defaultPath string) string {
+	path := os.Getenv(AppConfigPathEnvVar)
+	if path == "" {
+		return defaultPath
+	}
+	if !filepath.IsAbs(path) {
+		log.Printf("Ignoring %v=%v, the path of the AppConfig must be absolute.\n", AppConfigPathEnvVar, path)
+		return defaultPath
+	}
+	return path
+}
+
+// getProgramData returns the folder for application data shared by all users
+func getProgramData() string {
 	/*
 		System environment variable "AllUsersProfile" maps to following locations in different locations:
 
@@ -145,30 +204,32 @@ func init() {
 	if programData == "" {
 		programData = filepath.Join(os.Getenv("AllUsersProfile"), "Application Data")
 	}
-	SSMDataPath = filepath.Join(programData, SSMFolder)
+	return programData
+}
 
-	EnvProgramFiles = os.Getenv("ProgramFiles")
-	EnvWinDir = os.Getenv("WINDIR")
+// DefaultPaths computes the locations used by the agent from the environment of the current process.
+func DefaultPaths() Paths {
+	programData := getProgramData()
+	ssmData := filepath.Join(programData, SSMFolder)
+	programFiles := os.Getenv("ProgramFiles")
 	temp := os.Getenv("TEMP")
 
-	DefaultProgramFolder = filepath.Join(EnvProgramFiles, SSMFolder)
-	DefaultPluginPath = filepath.Join(EnvProgramFiles, SSMPluginFolder)
-	AppConfigPath = filepath.Join(DefaultProgramFolder, AppConfigFileName)
-	DefaultDataStorePath = filepath.Join(SSMDataPath, "InstanceData")
-	PackageRoot = filepath.Join(SSMDataPath, "Packages")
-	DaemonRoot = filepath.Join(SSMDataPath, "Daemons")
-	LocalCommandRoot = filepath.Join(SSMDataPath, "LocalCommands")
-	LocalCommandRootSubmitted = filepath.Join(LocalCommandRoot, "Submitted")
-	LocalCommandRootInvalid = filepath.Join(LocalCommandRoot, "Invalid")
-	DownloadRoot = filepath.Join(temp, SSMFolder, "Download")
-	UpdaterArtifactsRoot = filepath.Join(temp, SSMFolder, "Update")
-	EC2UpdateArtifactsRoot = filepath.Join(EnvWinDir, EC2ConfigServiceFolder, "Update")
-	EC2UpdaterDownloadRoot = filepath.Join(temp, EC2ConfigAppDataFolder, "Download")
-
-	DefaultCustomInventoryFolder = filepath.Join(SSMDataPath, "Inventory", "Custom")
-	EC2UpdateArtifactsRoot = filepath.Join(EnvWinDir, EC2ConfigServiceFolder, "Update")
-	EC2UpdaterDownloadRoot = filepath.Join(temp, EC2ConfigAppDataFolder, "Download")
-	EC2ConfigDataStorePath = filepath.Join(programData, EC2ConfigAppDataFolder, "InstanceData")
-	UpdateContextFilePath = filepath.Join(programData, EC2ConfigAppDataFolder, "Update\\UpdateContext.json")
-	EC2ConfigSettingPath = filepath.Join(EnvProgramFiles, EC2ConfigServiceFolder, "Settings")
+	programFolder := filepath.Join(programFiles, SSMFolder)
+	localCommandRoot := filepath.Join(ssmData, "LocalCommands")
+	return Paths{
+		ProgramFolder:             programFolder,
+		AppConfig:                 filepath.Join(programFolder, AppConfigFileName),
+		DataStore:                 filepath.Join(ssmData, "InstanceData"),
+		PackageRoot:               filepath.Join(ssmData, "Packages"),
+		DaemonRoot:                filepath.Join(ssmData, "Daemons"),
+		LocalCommandRoot:          localCommandRoot,
+		LocalCommandRootSubmitted: filepath.Join(localCommandRoot, "Submitted"),
+		LocalCommandRootInvalid:   filepath.Join(localCommandRoot, "Invalid"),
+		DownloadRoot:              filepath.Join(temp, SSMFolder, "Download"),
+		UpdaterArtifactsRoot:      filepath.Join(temp, SSMFolder, "Update"),
+		PluginPath:                filepath.Join(programFiles, SSMPluginFolder),
+		EC2ConfigDataStore:        filepath.Join(programData, EC2ConfigAppDataFolder, "InstanceData"),
+		EC2ConfigSetting:          filepath.Join(programFiles, EC2ConfigServiceFolder, "Settings"),
+		CustomInventoryFolder:     filepath.Join(ssmData, "Inventory", "Custom"),
+	}
 }
@@ -24,14 +24,89 @@ type CredentialProfile struct {
 
 // MdsCfg represents configuration for Message delivery service (MDS)
 type MdsCfg struct {
+	// Endpoint overrides the default MDS endpoint of the region, e.g. in isolated partitions
 	Endpoint            string
 	CommandWorkersLimit int
-	StopTimeoutMillis   int64
-	CommandRetryLimit   int
+	// CancelWorkersLimit is the number of workers processing cancel command messages
+	CancelWorkersLimit int
+	StopTimeoutMillis  int64
+	CommandRetryLimit  int
+	// OrchestrationRetentionDays is how long the orchestration directory of a document is kept
+	OrchestrationRetentionDays int
+	// OrchestrationRetentionMaxCount is the maximum number of orchestration directories kept
+	OrchestrationRetentionMaxCount int
+	// OrchestrationMinFreeInodesPercent is the percentage of free inodes below which the oldest orchestration
+	// directories are removed regardless of their age and count, 0 (the default) to not monitor the inodes
+	OrchestrationMinFreeInodesPercent int
+	// OrchestrationCleanupWorkers is the number of orchestration directories checked and removed at the same time by
+	// the cleanup of the old orchestration directories
+	OrchestrationCleanupWorkers int
+	// MaxDocumentReboots is the number of reboots a document can request before it is failed
+	MaxDocumentReboots int
+	// MaxPluginOutputBytes is the size the output of each plugin is truncated to in replies, 0 (the default) for no cap
+	MaxPluginOutputBytes int
+	// ParseRetryCount is the number of times parsing a message is retried after a transient failure
+	ParseRetryCount int
+	// ValidatePluginsBeforeAck fails send commands naming unsupported plugins before they are acknowledged
+	ValidatePluginsBeforeAck bool
+	// S3KeyPrefixTemplate overrides the S3 key prefix of command outputs when set, it can contain the placeholders
+	// {prefix}, {commandId}, {instanceId} and {region}
+	S3KeyPrefixTemplate string
+	// MaxDocumentRuntimeSeconds is how long a document can run before it is cancelled and timed out, 0 for no limit
+	MaxDocumentRuntimeSeconds int
+	// OutputWarnBytes is the total size of the plugin outputs of a document above which a warning is logged,
+	// 0 to never warn
+	OutputWarnBytes int
+	// MaxMessageFailures is the number of times a message can fail before it is quarantined, 0 (the default) to never
+	// quarantine messages
+	MaxMessageFailures int
+	// MaxMessagePayloadBytes is the size above which the payload of a message is failed without being parsed
+	MaxMessagePayloadBytes int
+	// InProgressReplyJitterMillis bounds the random delay of the InProgress reply of a document after its message is
+	// acknowledged, spreading the replies of a fleet-wide command. 0 to reply immediately
+	InProgressReplyJitterMillis int
+	// ReplyToDeleteDelayMillis is the wait between the reply of a completed document and the deletion of its message
+	ReplyToDeleteDelayMillis int
+	// CircuitBreakerFailureThreshold is the number of consecutive GetMessages calls failing because MDS throttles, fails
+	// or can't be reached after which the polling is suspended, 0 to never suspend it
+	CircuitBreakerFailureThreshold int
+	// CircuitBreakerCooldownSeconds is how long the polling is suspended before a call probes whether MDS recovered
+	CircuitBreakerCooldownSeconds int
+	// CompletionWebhookURL is the http(s) endpoint a summary of every completed document is posted to, empty to disable
+	CompletionWebhookURL string
+	// UnsupportedDocumentsURL is the http(s) url of the authoritative list of the documents incompatible with managed
+	// instances, empty to only use the local list
+	UnsupportedDocumentsURL string
+	// UnsupportedDocumentsRefreshMinutes is how often the list of UnsupportedDocumentsURL is fetched again
+	UnsupportedDocumentsRefreshMinutes int
+	// SkippedPluginsFailDocument counts the plugins skipped because they can't run on the instance as failed plugins,
+	// by default they are left out of the status of the document
+	SkippedPluginsFailDocument bool
+	// CompressStateFiles gzips the state files of the documents, files persisted uncompressed are still read
+	CompressStateFiles bool
+	// ReportCapabilities adds the plugins and the document features the agent supports to the additional info of
+	// the replies of the documents
+	ReportCapabilities bool
+	// CompressS3Output gzips the outputs the plugins upload to S3, the objects are named with the .gz suffix while the
+	// copies in the orchestration directory stay uncompressed
+	CompressS3Output bool
+	// DeadLetterS3Bucket is the bucket the quarantined messages are uploaded to along with their last error, for
+	// analysis. Empty to only quarantine them locally
+	DeadLetterS3Bucket string
+	// UseServerTimeForExpiration evaluates the expiration of the documents with the instance clock corrected by its
+	// skew from the clock of MDS, estimated from the creation date of the messages
+	UseServerTimeForExpiration bool
+	// ClockSkewToleranceSeconds is how long after its expiration a document is still started, to absorb the skew of
+	// the instance clock. A larger estimated skew is logged as a warning
+	ClockSkewToleranceSeconds int
+	// ProcessDocumentTypes are the types of the documents the agent processes, among SendCommand and CancelCommand.
+	// The documents of the other types are acknowledged and skipped. Empty to process all the documents
+	ProcessDocumentTypes []string
 }
 
 // SsmCfg represents configuration for Simple system manager (SSM)
 type SsmCfg struct {
+	// Endpoint overrides the default SSM endpoint of the region, e.g. in isolated partitions
 	Endpoint                    string
 	HealthFrequencyMinutes      int
 	AssociationFrequencyMinutes int
@@ -49,6 +124,10 @@ type AgentInfo struct {
 	Region               string
 	OrchestrationRootDir string
 	DownloadRootDir      string
+	// CompactOrchestrationDir consolidates the orchestration directory of a completed document into a single archive
+	CompactOrchestrationDir bool
+	// Timezone is the IANA name of the timezone used for schedule evaluation and human-facing timestamps
+	Timezone string
 }
 
 // OsInfo represents os related information
@@ -65,12 +144,71 @@ type S3Cfg struct {
 	LogKey    string
 }
 
+// LogCfg represents configurations related to logging
+type LogCfg struct {
+	// Levels are the log levels of the subsystems, by subsystem name, e.g. "processor": "info".
+	// Subsystems without a level log at the level of the seelog configuration.
+	Levels map[string]string
+}
+
+// PluginCfg represents configurations related to plugins
+type PluginCfg struct {
+	// PowerShellExecutionPolicy is the execution policy powershell scripts are run with
+	PowerShellExecutionPolicy string
+	// PackageManifestTrustAnchor is the path of the PEM encoded public key that package manifests must be signed with.
+	// Manifest signatures are not verified when it is empty.
+	PackageManifestTrustAnchor string
+	// GlobalEnvironment are environment variables added to the configuration of every plugin.
+	// Variables the document already sets for a plugin are not overridden.
+	GlobalEnvironment map[string]string
+	// MaxConcurrentPluginsPerDocument is the number of plugins of the same execution group of a document that run at the same time.
+	// With 1 the plugins of a document run one after the other.
+	MaxConcurrentPluginsPerDocument int
+}
+
+// ConfigurePackageCfg represents configurations related to the configure package plugin
+type ConfigurePackageCfg struct {
+	// MaxManifestBytes is the size above which a package manifest is rejected without being parsed
+	MaxManifestBytes int64
+	// ProxyURL is the proxy the package and manifest downloads go through, the proxy of the environment is used if it is empty
+	ProxyURL string
+	// NoProxy are the hosts downloaded from without ProxyURL. An entry matches the host and its subdomains, "*" matches all hosts.
+	NoProxy []string
+	// MinTLSVersion is the lowest TLS version of the package and manifest downloads, one of 1.0, 1.1, 1.2 and 1.3
+	MinTLSVersion string
+	// CABundlePath is a PEM file of the certificate authorities the download servers are verified with, in place of
+	// the ones of the system, e.g. for an internal mirror
+	CABundlePath string
+	// MaxConcurrentDownloads is the number of packages downloaded at the same time by all the documents, the other
+	// downloads wait for one of them to finish
+	MaxConcurrentDownloads int
+	// PackageCacheTTLMinutes is how long a downloaded package archive is reused instead of being downloaded again,
+	// 0 disables the cache
+	PackageCacheTTLMinutes int
+	// MaxLockAgeMinutes is how long an action can hold the lock of a package before the lock is considered abandoned
+	// and reclaimed by the next action, 0 to never reclaim locks
+	MaxLockAgeMinutes int
+	// DownloadTimeoutSeconds bounds each attempt to download a package, apart from the timeout of the plugin, so that
+	// a stalled mirror fails the download while there is time left to install. 0 for no limit
+	DownloadTimeoutSeconds int
+}
+
+// AuditCfg represents configurations related to the audit of the documents in the event log of the operating system
+type AuditCfg struct {
+	// Enabled writes an audit record of every completed document to syslog on Linux and the Event Log on Windows
+	Enabled bool
+}
+
 // SsmagentConfig stores agent configuration values.
 type SsmagentConfig struct {
-	Profile CredentialProfile
-	Mds     MdsCfg
-	Ssm     SsmCfg
-	Agent   AgentInfo
-	Os      OsInfo
-	S3      S3Cfg
+	Profile          CredentialProfile
+	Mds              MdsCfg
+	Ssm              SsmCfg
+	Agent            AgentInfo
+	Os               OsInfo
+	S3               S3Cfg
+	Plugins          PluginCfg
+	Log              LogCfg
+	ConfigurePackage ConfigurePackageCfg
+	Audit            AuditCfg
 }
@@ -0,0 +1,61 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package appconfig manages the configuration of the agent.
+package appconfig
+
+// Paths contains the locations used by the agent on the current platform.
+// The package level path variables are derived from DefaultPaths, tests can construct alternate sets instead.
+type Paths struct {
+	// ProgramFolder is the folder where the agent is installed
+	ProgramFolder string
+
+	// AppConfig is the path of the AppConfig
+	AppConfig string
+
+	// DataStore represents the directory for storing system data
+	DataStore string
+
+	// PackageRoot specifies the directory under which packages will be downloaded and installed
+	PackageRoot string
+
+	// DaemonRoot specifies the directory where daemon registration information is stored
+	DaemonRoot string
+
+	// LocalCommandRoot specifies the directory where users can submit command documents offline
+	LocalCommandRoot string
+
+	// LocalCommandRootSubmitted is the directory where locally submitted command documents are moved when picked up
+	LocalCommandRootSubmitted string
+
+	// LocalCommandRootInvalid is the directory where locally submitted command documents are moved if invalid
+	LocalCommandRootInvalid string
+
+	// DownloadRoot specifies the directory under which files will be downloaded
+	DownloadRoot string
+
+	// UpdaterArtifactsRoot represents the directory for storing update related information
+	UpdaterArtifactsRoot string
+
+	// PluginPath represents the directory for storing plugins in SSM
+	PluginPath string
+
+	// EC2ConfigDataStore represents the directory for storing ec2 config data
+	EC2ConfigDataStore string
+
+	// EC2ConfigSetting represents the directory for storing ec2 config settings
+	EC2ConfigSetting string
+
+	// CustomInventoryFolder is the folder for custom inventory data
+	CustomInventoryFolder string
+}
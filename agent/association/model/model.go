@@ -17,6 +17,7 @@ package model
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
@@ -30,6 +31,24 @@ const (
 	expressionTypeCron = "cron"
 )
 
+// scheduleLocation is the timezone schedule expressions are evaluated in
+var scheduleLocation = time.UTC
+var scheduleLocationLock sync.RWMutex
+
+// SetScheduleLocation sets the timezone schedule expressions are evaluated in, scheduled dates are still kept in UTC
+func SetScheduleLocation(loc *time.Location) {
+	scheduleLocationLock.Lock()
+	defer scheduleLocationLock.Unlock()
+	scheduleLocation = loc
+}
+
+// ScheduleLocation returns the timezone schedule expressions are evaluated in
+func ScheduleLocation() *time.Location {
+	scheduleLocationLock.RLock()
+	defer scheduleLocationLock.RUnlock()
+	return scheduleLocation
+}
+
 // InstanceAssociation represents detail information of an association
 type InstanceAssociation struct {
 	DocumentID        string
@@ -92,8 +111,8 @@ func (newAssoc *InstanceAssociation) SetNextScheduledDate(log log.T) {
 		return
 	}
 
-	// Run association according to it's schedule
-	newAssoc.NextScheduledDate = aws.Time(cronexpr.MustParse(newAssoc.Expression).Next(newAssoc.Association.LastExecutionDate.UTC()).UTC())
+	// Run association according to it's schedule, evaluated in the configured timezone
+	newAssoc.NextScheduledDate = aws.Time(cronexpr.MustParse(newAssoc.Expression).Next(newAssoc.Association.LastExecutionDate.In(ScheduleLocation())).UTC())
 }
 
 func parseExpression(log log.T, assoc *InstanceAssociation) error {
@@ -0,0 +1,76 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package model provides model definition for association
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+func createScheduledAssociation(t *testing.T, lastExecutionDate time.Time) *InstanceAssociation {
+	assoc := &InstanceAssociation{
+		Association: &ssm.InstanceAssociationSummary{
+			AssociationId:      aws.String("test-association"),
+			Name:               aws.String("test-document"),
+			ScheduleExpression: aws.String("cron(0 2 * * ? *)"),
+			LastExecutionDate:  aws.Time(lastExecutionDate),
+		},
+	}
+	assert.NoError(t, assoc.ParseExpression(log.NewMockLog()))
+	return assoc
+}
+
+func TestSetNextScheduledDate_UTC(t *testing.T) {
+	defer SetScheduleLocation(time.UTC)
+	SetScheduleLocation(time.UTC)
+
+	// 12:00 UTC, the next 02:00 UTC is on the following day
+	assoc := createScheduledAssociation(t, time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC))
+	assoc.SetNextScheduledDate(log.NewMockLog())
+
+	assert.Equal(t, time.Date(2017, 1, 2, 2, 0, 0, 0, time.UTC), *assoc.NextScheduledDate)
+}
+
+func TestSetNextScheduledDate_AcrossTimezoneBoundary(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+	defer SetScheduleLocation(time.UTC)
+	SetScheduleLocation(tokyo)
+
+	// 12:00 UTC on Jan 1 is already 21:00 on Jan 1 in Tokyo, so the next 02:00 in Tokyo is 17:00 UTC on Jan 1
+	assoc := createScheduledAssociation(t, time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC))
+	assoc.SetNextScheduledDate(log.NewMockLog())
+
+	assert.Equal(t, time.Date(2017, 1, 1, 17, 0, 0, 0, time.UTC), *assoc.NextScheduledDate)
+	assert.Equal(t, time.UTC, assoc.NextScheduledDate.Location())
+}
+
+func TestSetNextScheduledDate_AcrossDateLineWest(t *testing.T) {
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	assert.NoError(t, err)
+	defer SetScheduleLocation(time.UTC)
+	SetScheduleLocation(losAngeles)
+
+	// 01:00 UTC on Jan 2 is still 17:00 on Jan 1 in Los Angeles, so the next 02:00 there is 10:00 UTC on Jan 2
+	assoc := createScheduledAssociation(t, time.Date(2017, 1, 2, 1, 0, 0, 0, time.UTC))
+	assoc.SetNextScheduledDate(log.NewMockLog())
+
+	assert.Equal(t, time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC), *assoc.NextScheduledDate)
+}
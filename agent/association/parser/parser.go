@@ -184,6 +184,7 @@ func buildPluginsInfo(
 				PluginName:             pluginName,
 				PluginID:               pluginName,
 			}
+			config.DefaultWorkingDirectory = payload.DocumentContent.StepWorkingDirectory(nil)
 			pluginConfigurations = append(pluginConfigurations, &config)
 		}
 
@@ -219,7 +220,11 @@ func buildPluginsInfo(
 				BookKeepingFileName:    documentInfo.DocumentID,
 				PluginName:             pluginName,
 				PluginID:               instancePluginConfig.Name,
+				TimeoutSeconds:         instancePluginConfig.Timeout,
+				ExecutionGroup:         instancePluginConfig.ExecutionGroup,
+				MaxAttempts:            instancePluginConfig.MaxAttempts,
 			}
+			config.DefaultWorkingDirectory = payload.DocumentContent.StepWorkingDirectory(instancePluginConfig)
 
 			var plugin stateModel.PluginState
 			plugin.Configuration = config
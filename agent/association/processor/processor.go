@@ -65,6 +65,12 @@ func NewAssociationProcessor(context context.T, instanceID string) *Processor {
 	log := assocContext.Log()
 	config := assocContext.AppConfig()
 
+	location, err := times.LoadLocation(config.Agent.Timezone)
+	if err != nil {
+		log.Errorf("Failed to load timezone, schedules are evaluated in UTC, %v", err)
+	}
+	model.SetScheduleLocation(location)
+
 	taskPool := taskpool.NewTaskPool(log, documentWorkersLimit, cancelWaitDurationMillisecond)
 
 	agentInfo := contracts.AgentInfo{
@@ -51,7 +51,7 @@ func Refresh(log log.T, assocs []*model.InstanceAssociation) {
 	for _, assoc := range associations {
 		assoc.SetNextScheduledDate(log)
 		if assoc.NextScheduledDate != nil {
-			log.Infof("Scheduling association %v, setting next ScheduledDate to %v", *assoc.Association.AssociationId, times.ToIsoDashUTC(*assoc.NextScheduledDate))
+			log.Infof("Scheduling association %v, setting next ScheduledDate to %v", *assoc.Association.AssociationId, times.ToIso8601InLocation(*assoc.NextScheduledDate, model.ScheduleLocation()))
 		}
 
 		if assocContent, err := jsonutil.Marshal(assoc); err != nil {
@@ -130,7 +130,7 @@ func UpdateNextScheduledDate(log log.T, associationID string) {
 			assoc.Association.LastExecutionDate = aws.Time(time.Now().UTC())
 			assoc.SetNextScheduledDate(log)
 			if assoc.NextScheduledDate != nil {
-				log.Infof("Scheduling association %v, setting next ScheduledDate to %v", *assoc.Association.AssociationId, times.ToIsoDashUTC(*assoc.NextScheduledDate))
+				log.Infof("Scheduling association %v, setting next ScheduledDate to %v", *assoc.Association.AssociationId, times.ToIso8601InLocation(*assoc.NextScheduledDate, model.ScheduleLocation()))
 			}
 			break
 		}
@@ -63,3 +63,22 @@ func (c *defaultContext) AppConfig() appconfig.SsmagentConfig {
 func (c *defaultContext) CurrentContext() []string {
 	return c.context
 }
+
+// WithLogLevel returns a context whose logger, and the loggers of the contexts derived from it, drop the messages below level.
+func WithLogLevel(ctx T, level string) T {
+	return &levelContext{T: ctx, level: level}
+}
+
+// levelContext is a context that applies a log level to the loggers of the contexts it derives
+type levelContext struct {
+	T
+	level string
+}
+
+func (c *levelContext) With(logContext string) T {
+	return &levelContext{T: c.T.With(logContext), level: c.level}
+}
+
+func (c *levelContext) Log() log.T {
+	return log.WithLevel(c.T.Log(), c.level)
+}
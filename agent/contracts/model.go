@@ -29,12 +29,16 @@ const (
 	ResultStatusFailed           ResultStatus = "Failed"
 	ResultStatusCancelled        ResultStatus = "Cancelled"
 	ResultStatusTimedOut         ResultStatus = "TimedOut"
+	// ResultStatusSkipped is the terminal status of a plugin that didn't run, e.g. because it isn't supported on the
+	// platform. It is neither a success nor a failure of the document, see reply.AggregateDocumentStatus
+	ResultStatusSkipped ResultStatus = "Skipped"
 )
 
 // MergeResultStatus takes two ResultStatuses (presumably from sub-tasks) and decides what the overall task status should be
 func MergeResultStatus(current ResultStatus, new ResultStatus) (merged ResultStatus) {
 	orderedResultStatus := [...]ResultStatus{
 		ResultStatusUnknown,
+		ResultStatusSkipped,
 		ResultStatusSuccess,
 		ResultStatusSuccessAndReboot,
 		ResultStatusPassedAndReboot,
@@ -149,15 +153,49 @@ type InstancePluginConfig struct {
 	OnFailure   string      `json:"onFailure"`
 	Settings    interface{} `json:"settings"`
 	Timeout     int         `json:"timeoutSeconds"`
+	// ExecutionGroup is shared by consecutive steps that don't depend on each other and can run at the same time
+	ExecutionGroup string `json:"executionGroup"`
+	// DependsOn are the names of the steps that must complete before this step runs
+	DependsOn []string `json:"dependsOn"`
+	// WorkingDirectory overrides the working directory of the document for this step
+	WorkingDirectory string `json:"workingDirectory"`
 }
 
+const (
+	// AggregationPolicyAllMustSucceed reports a document as succeeded only if all of its plugins succeeded, it is the default
+	AggregationPolicyAllMustSucceed = "AllMustSucceed"
+
+	// AggregationPolicyAnySucceeds reports a completed document as succeeded if any of its plugins succeeded
+	AggregationPolicyAnySucceeds = "AnySucceeds"
+)
+
 // DocumentContent object which represents ssm document content.
 type DocumentContent struct {
-	SchemaVersion string                   `json:"schemaVersion"`
-	Description   string                   `json:"description"`
-	RuntimeConfig map[string]*PluginConfig `json:"runtimeConfig"`
-	MainSteps     []*InstancePluginConfig  `json:"mainSteps"`
-	Parameters    map[string]*Parameter    `json:"parameters"`
+	SchemaVersion     string                   `json:"schemaVersion"`
+	Description       string                   `json:"description"`
+	RuntimeConfig     map[string]*PluginConfig `json:"runtimeConfig"`
+	MainSteps         []*InstancePluginConfig  `json:"mainSteps"`
+	Parameters        map[string]*Parameter    `json:"parameters"`
+	AggregationPolicy string                   `json:"aggregationPolicy"`
+	// RequiredTags are the tags, and their values, an instance must have for the document to run on it.
+	// An empty value only requires the instance to have the tag.
+	RequiredTags map[string]string `json:"requiredTags"`
+	// Priority orders the pending documents, the documents with a higher priority are submitted first
+	Priority int `json:"priority"`
+	// WorkingDirectory is the directory the plugins of the document run in, it must be an absolute path and it is
+	// created if it doesn't exist
+	WorkingDirectory string `json:"workingDirectory"`
+	// ExpiresAfter is the time, in RFC 3339, after which the document is no longer started, empty if it doesn't expire
+	ExpiresAfter string `json:"expiresAfter"`
+}
+
+// StepWorkingDirectory returns the working directory of a step of the document, empty if neither the step nor the
+// document set one. A nil step returns the working directory of the document, for documents without steps.
+func (d *DocumentContent) StepWorkingDirectory(step *InstancePluginConfig) string {
+	if step != nil && step.WorkingDirectory != "" {
+		return step.WorkingDirectory
+	}
+	return d.WorkingDirectory
 }
 
 // AdditionalInfo section in agent response
@@ -166,6 +204,17 @@ type AdditionalInfo struct {
 	DateTime            string         `json:"dateTime"`
 	RunID               string         `json:"runId"`
 	RuntimeStatusCounts map[string]int `json:"runtimeStatusCounts"`
+	// Capabilities are reported only by agents configured to report them
+	Capabilities *AgentCapabilities `json:"capabilities,omitempty"`
+}
+
+// AgentCapabilities are the plugins and the document features an agent supports
+type AgentCapabilities struct {
+	AgentVersion string   `json:"agentVersion"`
+	Os           string   `json:"os"`
+	OsVersion    string   `json:"osVersion"`
+	Plugins      []string `json:"plugins"`
+	Features     []string `json:"features"`
 }
 
 // AgentInfo represents the agent response
@@ -187,6 +236,9 @@ type PluginRuntimeStatus struct {
 	EndDateTime        string       `json:"endDateTime"`
 	OutputS3BucketName string       `json:"outputS3BucketName"`
 	OutputS3KeyPrefix  string       `json:"outputS3KeyPrefix"`
+	CorrelationID      string       `json:"correlationId,omitempty"`
+	// OutputS3KeySuffix is the suffix of the keys of the outputs uploaded under OutputS3KeyPrefix, .gz when they are compressed
+	OutputS3KeySuffix string `json:"outputS3KeySuffix,omitempty"`
 }
 
 // AgentConfiguration is a struct that stores information about the agent and instance.
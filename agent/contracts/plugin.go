@@ -26,6 +26,9 @@ const (
 	//MaximumPluginOutputSize represents the maximum output size that agent supports
 	MaximumPluginOutputSize = 2400
 
+	// ErrorOutputTitle separates the standard output of a plugin from its standard error in its output
+	ErrorOutputTitle = "\n----------ERROR-------\n"
+
 	truncOut   = "\n---Output truncated---"
 	truncError = "\n---Error truncated----"
 )
@@ -48,6 +51,11 @@ type PluginResult struct {
 	Error              error        `json:"-"`
 	StandardOutput     string       `json:"standardOutput"`
 	StandardError      string       `json:"standardError"`
+	CorrelationID      string       `json:"correlationId,omitempty"`
+	// Retryable marks a failure as transient, the plugin is run again if its step has attempts left
+	Retryable bool `json:"retryable,omitempty"`
+	// OutputS3KeySuffix is the suffix of the keys of the outputs uploaded under OutputS3KeyPrefix, .gz when they are compressed
+	OutputS3KeySuffix string `json:"outputS3KeySuffix,omitempty"`
 }
 
 // IPlugin is interface for authoring a functionality of work.
@@ -83,6 +91,13 @@ type Configuration struct {
 	PluginName              string
 	PluginID                string
 	DefaultWorkingDirectory string
+	TimeoutSeconds          int
+	// Environment are variables added to the environment of the commands the plugin executes
+	Environment map[string]string
+	// ExecutionGroup is shared by consecutive plugins of a document that can run at the same time
+	ExecutionGroup string
+	// MaxAttempts is the number of times the plugin is run while it fails with a retryable result, once if below 2
+	MaxAttempts int
 }
 
 // Plugin wraps the plugin configuration and plugin result.
@@ -101,6 +116,8 @@ type PluginOutput struct {
 	Status   ResultStatus
 	Stdout   string
 	Stderr   string
+	// Retryable marks a failure as transient, it is reported in the Retryable of the plugin result
+	Retryable bool
 }
 
 func (p *PluginOutput) String() (response string) {
@@ -117,6 +134,11 @@ func (out *PluginOutput) MarkAsFailed(log log.T, err error) {
 	out.AppendError(log, err.Error())
 }
 
+// MarkAsRetryable marks the failure of the plugin as transient, so that the plugin can be run again.
+func (out *PluginOutput) MarkAsRetryable() {
+	out.Retryable = true
+}
+
 // MarkAsSucceeded marks plugin as Successful.
 func (out *PluginOutput) MarkAsSucceeded() {
 	out.ExitCode = 0
@@ -184,7 +206,7 @@ func TruncateOutput(stdout string, stderr string, capacity int) (response string
 	errorTitle := ""
 	lenErrorTitle := 0
 	if errorSize > 0 {
-		errorTitle = "\n----------ERROR-------\n"
+		errorTitle = ErrorOutputTitle
 		lenErrorTitle = len(errorTitle)
 	}
 
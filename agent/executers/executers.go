@@ -20,6 +20,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -44,6 +45,18 @@ type T interface {
 
 // ShellCommandExecuter is specially added for testing purposes
 type ShellCommandExecuter struct {
+	// Environment are variables added to the environment of the executed commands, over the environment of the agent
+	Environment map[string]string
+}
+
+// WithEnvironment returns an executer whose commands run with the variables added to their environment, or the
+// executer itself if there are no variables or it doesn't support them
+func WithEnvironment(executer T, environment map[string]string) T {
+	if shell, ok := executer.(ShellCommandExecuter); ok && len(environment) > 0 {
+		shell.Environment = environment
+		return shell
+	}
+	return executer
 }
 
 type timeoutSignal struct {
@@ -60,7 +73,7 @@ type timeoutSignal struct {
 // even though some errors are reported. For example, if the command got killed while executing,
 // the streams will have whatever data was printed up to the kill point, and the errors will
 // indicate that the process got terminated.
-func (ShellCommandExecuter) Execute(
+func (e ShellCommandExecuter) Execute(
 	log log.T,
 	workingDir string,
 	stdoutFilePath string,
@@ -72,7 +85,7 @@ func (ShellCommandExecuter) Execute(
 ) (stdout io.Reader, stderr io.Reader, exitCode int, errs []error) {
 
 	var err error
-	exitCode, err = executeCommandAndOutputToFiles(log, cancelFlag, workingDir, stdoutFilePath, stderrFilePath, executionTimeout, commandName, commandArguments)
+	exitCode, err = executeCommandAndOutputToFiles(log, cancelFlag, workingDir, stdoutFilePath, stderrFilePath, executionTimeout, commandName, commandArguments, e.Environment)
 	if err != nil {
 		errs = append(errs, err)
 	}
@@ -112,7 +125,7 @@ func (ShellCommandExecuter) Execute(
 // even though some errors are reported. For example, if the command got killed while executing,
 // the streams will have whatever data was printed up to the kill point, and the errors will
 // indicate that the process got terminated.
-func (ShellCommandExecuter) StartExe(
+func (e ShellCommandExecuter) StartExe(
 	log log.T,
 	workingDir string,
 	stdoutFilePath string,
@@ -122,7 +135,7 @@ func (ShellCommandExecuter) StartExe(
 	commandArguments []string,
 ) (process *os.Process, exitCode int, errs []error) {
 	var err error
-	process, exitCode, err = startCommandAndOutputToFiles(log, cancelFlag, workingDir, stdoutFilePath, stderrFilePath, commandName, commandArguments)
+	process, exitCode, err = startCommandAndOutputToFiles(log, cancelFlag, workingDir, stdoutFilePath, stderrFilePath, commandName, commandArguments, e.Environment)
 	if err != nil {
 		errs = append(errs, err)
 	}
@@ -158,6 +171,7 @@ func executeCommandAndOutputToFiles(
 	executionTimeout int,
 	commandName string,
 	commandArguments []string,
+	environment map[string]string,
 ) (exitCode int, err error) {
 
 	// create stdout file
@@ -178,7 +192,7 @@ func executeCommandAndOutputToFiles(
 	}
 	defer stderrWriter.Close()
 
-	return ExecuteCommand(log, cancelFlag, workingDir, stdoutWriter, stderrWriter, executionTimeout, commandName, commandArguments)
+	return executeCommand(log, cancelFlag, workingDir, stdoutWriter, stderrWriter, executionTimeout, commandName, commandArguments, environment)
 }
 
 // startCommandAndOutputToFiles starts the given commands using the given working directory.
@@ -191,6 +205,7 @@ func startCommandAndOutputToFiles(
 	stderrFilePath string,
 	commandName string,
 	commandArguments []string,
+	environment map[string]string,
 ) (process *os.Process, exitCode int, err error) {
 
 	// create stdout file
@@ -211,7 +226,7 @@ func startCommandAndOutputToFiles(
 	}
 	defer stderrWriter.Close() // Closing our instance of the file handle - the child process has its own copy
 
-	return StartCommand(log, cancelFlag, workingDir, stdoutWriter, stderrWriter, commandName, commandArguments)
+	return startCommand(log, cancelFlag, workingDir, stdoutWriter, stderrWriter, commandName, commandArguments, environment)
 }
 
 // ExecuteCommand executes the given commands using the given working directory.
@@ -225,6 +240,20 @@ func ExecuteCommand(log log.T,
 	commandName string,
 	commandArguments []string,
 ) (exitCode int, err error) {
+	return executeCommand(log, cancelFlag, workingDir, stdoutWriter, stderrWriter, executionTimeout, commandName, commandArguments, nil)
+}
+
+// executeCommand executes the given commands with the variables added to their environment
+func executeCommand(log log.T,
+	cancelFlag task.CancelFlag,
+	workingDir string,
+	stdoutWriter io.Writer,
+	stderrWriter io.Writer,
+	executionTimeout int,
+	commandName string,
+	commandArguments []string,
+	environment map[string]string,
+) (exitCode int, err error) {
 
 	command := exec.Command(commandName, commandArguments...)
 	command.Dir = workingDir
@@ -236,7 +265,7 @@ func ExecuteCommand(log log.T,
 	prepareProcess(command)
 
 	// configure environment variables
-	prepareEnvironment(command)
+	prepareEnvironment(command, environment)
 
 	log.Debug()
 	log.Debugf("Running in directory %v, command: %v %v.", workingDir, commandName, commandArguments)
@@ -313,6 +342,19 @@ func StartCommand(log log.T,
 	commandName string,
 	commandArguments []string,
 ) (process *os.Process, exitCode int, err error) {
+	return startCommand(log, cancelFlag, workingDir, stdoutWriter, stderrWriter, commandName, commandArguments, nil)
+}
+
+// startCommand starts the given commands with the variables added to their environment
+func startCommand(log log.T,
+	cancelFlag task.CancelFlag,
+	workingDir string,
+	stdoutWriter io.Writer,
+	stderrWriter io.Writer,
+	commandName string,
+	commandArguments []string,
+	environment map[string]string,
+) (process *os.Process, exitCode int, err error) {
 
 	command := exec.Command(commandName, commandArguments...)
 	command.Dir = workingDir
@@ -324,7 +366,7 @@ func StartCommand(log log.T,
 	prepareProcess(command)
 
 	// configure environment variables
-	prepareEnvironment(command)
+	prepareEnvironment(command, environment)
 
 	log.Debug()
 	log.Debugf("Running in directory %v, command: %v %v.", workingDir, commandName, commandArguments)
@@ -378,8 +420,8 @@ func killProcessOnTimeout(log log.T, command *exec.Cmd, timer *time.Timer, signa
 	log.Debug("Process stopped successfully")
 }
 
-// prepareEnvironment adds ssm agent standard environment variables to the command
-func prepareEnvironment(command *exec.Cmd) {
+// prepareEnvironment adds ssm agent standard environment variables and the given variables to the command
+func prepareEnvironment(command *exec.Cmd, environment map[string]string) {
 	env := os.Environ()
 	if instance, err := instance.InstanceID(); err == nil {
 		env = append(env, fmtEnvVariable(envVarInstanceId, instance))
@@ -387,6 +429,15 @@ func prepareEnvironment(command *exec.Cmd) {
 	if region, err := instance.Region(); err == nil {
 		env = append(env, fmtEnvVariable(envVarRegionName, region))
 	}
+	// the variables are only set for this command, the environment of the agent is left untouched
+	names := make([]string, 0, len(environment))
+	for name := range environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, fmtEnvVariable(name, environment[name]))
+	}
 	command.Env = env
 
 	// Running powershell on linux erquired the HOME env variable to be set and to remove the TERM env variable
@@ -17,6 +17,7 @@ package executers
 import (
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"testing"
@@ -72,19 +73,34 @@ func TestEnvironmentVariables_All(t *testing.T) {
 	defer func() { instance = instanceTemp }()
 
 	command := getTestCommand(t)
-	prepareEnvironment(command)
+	prepareEnvironment(command, nil)
 
 	assert.Equal(t, getEnvVariableValue(command.Env, envVarInstanceId), testInstanceId)
 	assert.Equal(t, getEnvVariableValue(command.Env, envVarRegionName), testRegionName)
 }
 
+func TestEnvironmentVariables_Additional(t *testing.T) {
+	instanceTemp := instance
+	instance = &instanceInfoStub{instanceID: testInstanceId, regionName: testRegionName}
+	defer func() { instance = instanceTemp }()
+
+	command := getTestCommand(t)
+	executer := WithEnvironment(ShellCommandExecuter{}, map[string]string{"SSM_PKG_ARG_SITE": "blue"})
+	prepareEnvironment(command, executer.(ShellCommandExecuter).Environment)
+
+	assert.Equal(t, "blue", getEnvVariableValue(command.Env, "SSM_PKG_ARG_SITE"))
+	assert.Equal(t, testInstanceId, getEnvVariableValue(command.Env, envVarInstanceId))
+	_, set := os.LookupEnv("SSM_PKG_ARG_SITE")
+	assert.False(t, set)
+}
+
 func TestEnvironmentVariables_None(t *testing.T) {
 	instanceTemp := instance
 	instance = &instanceInfoStub{"", errors.New(testError), "", errors.New(testError)}
 	defer func() { instance = instanceTemp }()
 
 	command := getTestCommand(t)
-	prepareEnvironment(command)
+	prepareEnvironment(command, nil)
 
 	assert.Empty(t, getEnvVariableValue(command.Env, envVarInstanceId))
 	assert.Empty(t, getEnvVariableValue(command.Env, envVarRegionName))
@@ -0,0 +1,288 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package fileutil contains utilities for working with the file system.
+package fileutil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+)
+
+var (
+	// zipMagic are the first bytes of a zip archive
+	zipMagic = []byte("PK\x03\x04")
+	// gzipMagic are the first bytes of a gzip stream
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// CompactDirectory archives all files under srcDir into a single zip file at archivePath.
+// Entries are named by their path relative to srcDir using forward slashes. The directories whose relative path matches
+// one of the excluded patterns, as in path.Match, are left out.
+func CompactDirectory(srcDir string, archivePath string, excluded ...string) (err error) {
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := archive.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	writer := zip.NewWriter(archive)
+	err = filepath.Walk(srcDir, func(entryPath string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		relativePath, err := filepath.Rel(srcDir, entryPath)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			for _, pattern := range excluded {
+				if matched, _ := path.Match(pattern, filepath.ToSlash(relativePath)); matched {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relativePath)
+		header.Method = zip.Deflate
+		entry, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(entryPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(entry, file)
+		return err
+	})
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// ReadArchivedFile returns the content of the file with the given relative name from an archive created by CompactDirectory.
+func ReadArchivedFile(archivePath string, name string) (content []byte, err error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	name = filepath.ToSlash(name)
+	for _, file := range reader.File {
+		if file.Name != name {
+			continue
+		}
+		entry, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer entry.Close()
+		return ioutil.ReadAll(entry)
+	}
+	return nil, fmt.Errorf("%v not found in archive %v", name, archivePath)
+}
+
+// UnsafeArchiveEntryError is returned when an entry of an archive would be extracted outside of the destination directory
+type UnsafeArchiveEntryError struct {
+	Archive string
+	Entry   string
+}
+
+func (e *UnsafeArchiveEntryError) Error() string {
+	return fmt.Sprintf("archive %v is rejected, its entry %v would be extracted outside of the destination directory",
+		filepath.Base(e.Archive), e.Entry)
+}
+
+// archiveEntryPath returns the path the entry name of the archive src is extracted to in dest. Every archive format
+// extracts its entries to the path it returns, it rejects the entries with an absolute path or a .. element, in either
+// the slash or the backslash form, so that no archive writes outside of dest.
+func archiveEntryPath(src, dest, name string) (string, error) {
+	slashed := strings.Replace(name, "\\", "/", -1)
+	if path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", &UnsafeArchiveEntryError{Archive: src, Entry: name}
+	}
+	for _, element := range strings.Split(slashed, "/") {
+		if element == ".." {
+			return "", &UnsafeArchiveEntryError{Archive: src, Entry: name}
+		}
+	}
+	entryPath := filepath.Join(dest, filepath.FromSlash(slashed))
+	if !isUnderDir(entryPath, dest) {
+		return "", &UnsafeArchiveEntryError{Archive: src, Entry: name}
+	}
+	return entryPath, nil
+}
+
+// ExtractArchive extracts a zip or a tar.gz archive in dest. The format is detected from the first bytes of the archive,
+// whatever its extension.
+func ExtractArchive(src, dest string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	magic := make([]byte, len(zipMagic))
+	n, err := io.ReadFull(file, magic)
+	file.Close()
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	switch magic = magic[:n]; {
+	case bytes.HasPrefix(magic, zipMagic):
+		return extractZip(src, dest)
+	case bytes.HasPrefix(magic, gzipMagic):
+		return extractTarGz(src, dest)
+	default:
+		return fmt.Errorf("unsupported archive format of %v, only zip and tar.gz archives can be extracted", filepath.Base(src))
+	}
+}
+
+// extractZip extracts the zip archive src in dest
+func extractZip(src, dest string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			return
+		}
+	}()
+
+	os.MkdirAll(dest, appconfig.ReadWriteExecuteAccess)
+	// Closure to address file descriptors issue with all the deferred .Close() methods
+	extractAndWriteFile := func(f *zip.File) error {
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := rc.Close(); err != nil {
+				return
+			}
+		}()
+
+		path, err := archiveEntryPath(src, dest, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(path, f.Mode())
+		} else {
+			os.MkdirAll(filepath.Dir(path), f.Mode())
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := f.Close(); err != nil {
+					return
+				}
+			}()
+
+			_, err = io.Copy(f, rc)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, f := range r.File {
+		err := extractAndWriteFile(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractTarGz extracts the tar.gz archive src in dest
+func extractTarGz(src, dest string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gr, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	os.MkdirAll(dest, appconfig.ReadWriteExecuteAccess)
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		itemPath, err := archiveEntryPath(src, dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if hdr.FileInfo().IsDir() {
+			os.MkdirAll(itemPath, hdr.FileInfo().Mode())
+			continue
+		}
+		// archives don't always have a header for the directory of a file
+		if err = os.MkdirAll(filepath.Dir(itemPath), appconfig.ReadWriteExecuteAccess); err != nil {
+			return err
+		}
+		if err = writeTarEntry(tr, itemPath, hdr.FileInfo().Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTarEntry writes the content of the current entry of the tar archive to the file at itemPath, and closes the
+// file before the next entry is extracted
+func writeTarEntry(tr *tar.Reader, itemPath string, mode os.FileMode) (err error) {
+	fw, err := os.OpenFile(itemPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(fw, tr); err != nil {
+		fw.Close()
+		return err
+	}
+	return fw.Close()
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactDirectory(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "compact")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "commandID")
+	assert.NoError(t, os.MkdirAll(filepath.Join(srcDir, "awsrunShellScript"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(srcDir, "awsrunShellScript", "stdout"), []byte("hello"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(srcDir, "awsrunShellScript", "stderr"), []byte(""), 0600))
+
+	archivePath := filepath.Join(tempDir, "commandID.zip")
+	assert.NoError(t, CompactDirectory(srcDir, archivePath))
+
+	content, err := ReadArchivedFile(archivePath, filepath.Join("awsrunShellScript", "stdout"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+
+	content, err = ReadArchivedFile(archivePath, "awsrunShellScript/stderr")
+	assert.NoError(t, err)
+	assert.Empty(t, content)
+
+	_, err = ReadArchivedFile(archivePath, "missing")
+	assert.Error(t, err)
+}
+
+func TestCompactDirectoryExcluded(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "compact")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "commandID")
+	assert.NoError(t, os.MkdirAll(filepath.Join(srcDir, "awsconfigurePackage", "downloads"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(srcDir, "awsconfigurePackage", "stdout"), []byte("installed"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(srcDir, "awsconfigurePackage", "downloads", "PVDriver.zip"), []byte("archive"), 0600))
+
+	archivePath := filepath.Join(tempDir, "commandID.zip")
+	assert.NoError(t, CompactDirectory(srcDir, archivePath, "*/downloads"))
+
+	content, err := ReadArchivedFile(archivePath, "awsconfigurePackage/stdout")
+	assert.NoError(t, err)
+	assert.Equal(t, "installed", string(content))
+	_, err = ReadArchivedFile(archivePath, "awsconfigurePackage/downloads/PVDriver.zip")
+	assert.Error(t, err)
+}
+
+func TestCompactDirectoryMissingSource(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "compact")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	err = CompactDirectory(filepath.Join(tempDir, "missing"), filepath.Join(tempDir, "missing.zip"))
+	assert.Error(t, err)
+}
+
+// writeTestArchives writes a zip and a tar.gz archive holding the same manifest and install script in dir
+func writeTestArchives(t *testing.T, dir string) (zipPath, tarGzPath string) {
+	return writeArchives(t, dir, "PVDriver", map[string]string{"PVDriver.json": `{"version": "1.0.0"}`, "install.sh": "echo installed"})
+}
+
+// writeArchives writes a zip and a tar.gz archive named name holding files, by entry name, in dir
+func writeArchives(t *testing.T, dir string, name string, files map[string]string) (zipPath, tarGzPath string) {
+	zipPath, tarGzPath, err := WriteTestArchives(dir, name, files)
+	assert.NoError(t, err)
+	return zipPath, tarGzPath
+}
+
+func TestExtractArchive(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "extract")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	zipPath, tarGzPath := writeTestArchives(t, tempDir)
+
+	for _, archivePath := range []string{zipPath, tarGzPath} {
+		dest := filepath.Join(tempDir, filepath.Base(archivePath)+"-extracted")
+		assert.NoError(t, ExtractArchive(archivePath, dest), archivePath)
+
+		content, err := ioutil.ReadFile(filepath.Join(dest, "install.sh"))
+		assert.NoError(t, err)
+		assert.Equal(t, "echo installed", string(content))
+	}
+
+	// the format doesn't depend on the extension
+	renamedPath := filepath.Join(tempDir, "PVDriver-tar.zip")
+	assert.NoError(t, os.Rename(tarGzPath, renamedPath))
+	assert.NoError(t, ExtractArchive(renamedPath, filepath.Join(tempDir, "renamed")))
+	assert.True(t, Exists(filepath.Join(tempDir, "renamed", "PVDriver.json")))
+}
+
+func TestExtractArchiveUnsupportedFormat(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "extract")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	archivePath := filepath.Join(tempDir, "PVDriver.rar")
+	assert.NoError(t, ioutil.WriteFile(archivePath, []byte("Rar!\x1a\x07"), 0600))
+	err = ExtractArchive(archivePath, filepath.Join(tempDir, "extracted"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported archive format of PVDriver.rar")
+
+	emptyPath := filepath.Join(tempDir, "empty.zip")
+	assert.NoError(t, ioutil.WriteFile(emptyPath, nil, 0600))
+	assert.Error(t, ExtractArchive(emptyPath, filepath.Join(tempDir, "extracted")))
+}
+
+func TestExtractArchiveRejectsEntriesOutsideDestination(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "extract")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// relative entries are extracted in the destination
+	zipPath, tarGzPath := writeArchives(t, tempDir, "benign", map[string]string{"install.sh": "echo installed", "./PVDriver.json": "{}"})
+	for _, archivePath := range []string{zipPath, tarGzPath} {
+		dest := filepath.Join(tempDir, filepath.Base(archivePath)+"-extracted")
+		assert.NoError(t, ExtractArchive(archivePath, dest), archivePath)
+		assert.True(t, Exists(filepath.Join(dest, "install.sh")), archivePath)
+		assert.True(t, Exists(filepath.Join(dest, "PVDriver.json")), archivePath)
+	}
+
+	// traversal and absolute entries abort the extraction before they are written
+	for _, entry := range []string{"../evil.sh", "bin/../../evil.sh", "..\\evil.sh", "/tmp/evil.sh"} {
+		zipPath, tarGzPath = writeArchives(t, tempDir, "malicious", map[string]string{entry: "echo evil"})
+		for _, archivePath := range []string{zipPath, tarGzPath} {
+			dest := filepath.Join(tempDir, "versions", "1.0.0")
+			err = ExtractArchive(archivePath, dest)
+			assert.Error(t, err, entry)
+			_, unsafe := err.(*UnsafeArchiveEntryError)
+			assert.True(t, unsafe, entry)
+			assert.False(t, Exists(filepath.Join(tempDir, "versions", "evil.sh")), entry)
+		}
+	}
+}
+
+func TestExtractArchiveWithoutDirectoryEntries(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "extract")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{"PVDriver.json": "{}", "bin/install.sh": "echo installed", "bin/lib/uninstall.sh": "echo uninstalled"}
+	zipPath, tarGzPath := writeArchives(t, tempDir, "PVDriver", files)
+	for _, archivePath := range []string{zipPath, tarGzPath} {
+		dest := filepath.Join(tempDir, filepath.Base(archivePath)+"-extracted")
+		assert.NoError(t, ExtractArchive(archivePath, dest), archivePath)
+		for name, expected := range files {
+			content, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+			assert.NoError(t, err, archivePath)
+			assert.Equal(t, expected, string(content), archivePath)
+		}
+	}
+}
@@ -18,14 +18,17 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/fileutil"
@@ -49,10 +52,74 @@ type DownloadInput struct {
 	DestinationDirectory string
 	SourceHashValue      string
 	SourceHashType       string
+	// Progress is called while the file is written, it is optional
+	Progress DownloadProgress
+	// Proxy returns the proxy the requests of the download go through, it is optional.
+	// Without it the proxy of the environment is used.
+	Proxy func(*http.Request) (*url.URL, error)
+	// TLSConfig is the TLS configuration of the requests of the download, it is optional.
+	// Without it the default TLS configuration is used.
+	TLSConfig *tls.Config
+	// Transport is the transport of the requests of the download, to share one between downloads, it is optional.
+	// Without it the transport is made of Proxy and TLSConfig.
+	Transport *http.Transport
+	// Timeout bounds each request of the download, including the transfer of the file, it is optional.
+	// Without it a request takes as long as the server takes to respond.
+	Timeout time.Duration
+}
+
+// DownloadProgress receives the number of bytes downloaded so far and the size of the file, -1 if the size is unknown.
+type DownloadProgress func(downloaded int64, total int64)
+
+// progressReader reports the bytes read from the download to a DownloadProgress
+type progressReader struct {
+	reader     io.Reader
+	downloaded int64
+	total      int64
+	progress   DownloadProgress
+}
+
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	if n > 0 {
+		r.downloaded += int64(n)
+		r.progress(r.downloaded, r.total)
+	}
+	return
+}
+
+// fileCopyWithProgress copies the content of a download to destinationPath, reporting the progress if there is a callback
+func fileCopyWithProgress(log log.T, destinationPath string, src io.Reader, total int64, progress DownloadProgress) (written int64, err error) {
+	if progress != nil {
+		src = &progressReader{reader: src, total: total, progress: progress}
+	}
+	return FileCopy(log, destinationPath, src)
+}
+
+// NewTransport returns a transport going through proxy with the TLS configuration, or nil to use the default transport
+// when both are nil. The transport has the settings of http.DefaultTransport and goes through the proxy of the
+// environment without a proxy.
+func NewTransport(proxy func(*http.Request) (*url.URL, error), tlsConfig *tls.Config) *http.Transport {
+	if proxy == nil && tlsConfig == nil {
+		return nil
+	}
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	return &http.Transport{
+		Proxy: proxy,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
 }
 
 // httpDownload attempts to download a file via http/s call
-func httpDownload(log log.T, fileURL string, destFile string) (output DownloadOutput, err error) {
+func httpDownload(log log.T, fileURL string, destFile string, progress DownloadProgress, transport *http.Transport, timeout time.Duration) (output DownloadOutput, err error) {
 	log.Debugf("attempting to download as http/https download %v", destFile)
 	eTagFile := destFile + ".etag"
 	var check http.Client
@@ -72,6 +139,10 @@ func httpDownload(log log.T, fileURL string, destFile string) (output DownloadOu
 			r.URL.Opaque = r.URL.Path
 			return nil
 		},
+		Timeout: timeout,
+	}
+	if transport != nil {
+		check.Transport = transport
 	}
 
 	var resp *http.Response
@@ -105,7 +176,7 @@ func httpDownload(log log.T, fileURL string, destFile string) (output DownloadOu
 			return
 		}
 	}
-	_, err = FileCopy(log, destFile, resp.Body)
+	_, err = fileCopyWithProgress(log, destFile, resp.Body, resp.ContentLength, progress)
 	if err == nil {
 		output.LocalFilePath = destFile
 		output.IsUpdated = true
@@ -133,10 +204,32 @@ func awsConfig(log log.T, amazonS3URL s3util.AmazonS3URL) (config *aws.Config, e
 	return config, nil
 }
 
+// remainingTimeout returns what is left of timeout since start, and false once it is over.
+// A timeout of 0 is no timeout and is never over.
+func remainingTimeout(timeout time.Duration, start time.Time) (time.Duration, bool) {
+	if timeout <= 0 {
+		return 0, true
+	}
+	remaining := timeout - time.Since(start)
+	return remaining, remaining > 0
+}
+
+// s3HTTPClient sets the http client of the S3 requests when there is a transport or a timeout
+func s3HTTPClient(config *aws.Config, transport *http.Transport, timeout time.Duration) {
+	if transport != nil || timeout > 0 {
+		config.HTTPClient = &http.Client{Timeout: timeout}
+		if transport != nil {
+			config.HTTPClient.Transport = transport
+		}
+	}
+}
+
 // ListS3Folders returns the folders under a given S3 URL where folders are keys whose prefix is the URL key
 // and contain a / after the prefix.  The folder name is the part between the prefix and the /.
-func ListS3Folders(log log.T, amazonS3URL s3util.AmazonS3URL) (folderNames []string, err error) {
+// The requests go through transport, nil to use the default transport.
+func ListS3Folders(log log.T, amazonS3URL s3util.AmazonS3URL, transport *http.Transport) (folderNames []string, err error) {
 	config, _ := awsConfig(log, amazonS3URL)
+	s3HTTPClient(config, transport, 0)
 	prefix := amazonS3URL.Key
 	if !strings.HasSuffix(prefix, "/") {
 		prefix = prefix + "/"
@@ -163,11 +256,12 @@ func ListS3Folders(log log.T, amazonS3URL s3util.AmazonS3URL) (folderNames []str
 }
 
 // s3Download attempts to download a file via the aws sdk.
-func s3Download(log log.T, amazonS3URL s3util.AmazonS3URL, destFile string) (output DownloadOutput, err error) {
+func s3Download(log log.T, amazonS3URL s3util.AmazonS3URL, destFile string, progress DownloadProgress, transport *http.Transport, timeout time.Duration) (output DownloadOutput, err error) {
 	log.Debugf("attempting to download as s3 download %v", destFile)
 	eTagFile := destFile + ".etag"
 
 	config, _ := awsConfig(log, amazonS3URL)
+	s3HTTPClient(config, transport, timeout)
 	params := &s3.GetObjectInput{
 		Bucket: aws.String(amazonS3URL.Bucket),
 		Key:    aws.String(amazonS3URL.Key),
@@ -211,7 +305,11 @@ func s3Download(log log.T, amazonS3URL s3util.AmazonS3URL, destFile string) (out
 	}
 
 	defer resp.Body.Close()
-	_, err = FileCopy(log, destFile, resp.Body)
+	total := int64(-1)
+	if resp.ContentLength != nil {
+		total = *resp.ContentLength
+	}
+	_, err = fileCopyWithProgress(log, destFile, resp.Body, total, progress)
 	if err == nil {
 		output.LocalFilePath = destFile
 		output.IsUpdated = true
@@ -281,19 +379,26 @@ func Download(log log.T, input DownloadInput) (output DownloadOutput, err error)
 		urlHash := sha1.Sum([]byte(fileURL.String()))
 		output.LocalFilePath = filepath.Join(destinationDir, fmt.Sprintf("%x", urlHash))
 
+		transport := input.Transport
+		if transport == nil {
+			transport = NewTransport(input.Proxy, input.TLSConfig)
+		}
 		amazonS3URL := s3util.ParseAmazonS3URL(log, fileURL)
 		if amazonS3URL.IsBucketAndKeyPresent() {
 			// source is s3
 			var tempOutput DownloadOutput
-			tempOutput, err = s3Download(log, amazonS3URL, output.LocalFilePath)
-			// if s3 download fails, attempt http/https download as fallback
+			start := time.Now()
+			tempOutput, err = s3Download(log, amazonS3URL, output.LocalFilePath, input.Progress, transport, input.Timeout)
+			// if s3 download fails, attempt http/https download as fallback within what is left of the timeout
 			if err != nil {
-				tempOutput, err = httpDownload(log, input.SourceURL, output.LocalFilePath)
+				if timeout, ok := remainingTimeout(input.Timeout, start); ok {
+					tempOutput, err = httpDownload(log, input.SourceURL, output.LocalFilePath, input.Progress, transport, timeout)
+				}
 			}
 			output = tempOutput
 		} else {
 			// simple http/https download
-			output, err = httpDownload(log, input.SourceURL, output.LocalFilePath)
+			output, err = httpDownload(log, input.SourceURL, output.LocalFilePath, input.Progress, transport, input.Timeout)
 		}
 
 		if err != nil {
@@ -34,6 +34,12 @@ type DiskSpaceInfo struct {
 	TotalBytes int64
 }
 
+// InodeInfo stores the free and total inodes, both are zero on file systems without a fixed number of inodes
+type InodeInfo struct {
+	FreeInodes  uint64
+	TotalInodes uint64
+}
+
 // DeleteFile deletes the specified file
 func DeleteFile(filepath string) (err error) {
 	return fs.Remove(filepath)
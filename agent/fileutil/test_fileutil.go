@@ -0,0 +1,82 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package fileutil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+)
+
+// Note: This code is used in the test files. However, this code is not in a _test.go file
+// because then we would have to copy it in every test package that needs the archives.
+
+// WriteTestArchives writes a zip and a tar.gz archive named name holding files, by entry name, in dir. The archives
+// only have entries for the files, not for their directories.
+func WriteTestArchives(dir string, name string, files map[string]string) (zipPath, tarGzPath string, err error) {
+	zipPath = filepath.Join(dir, name+".zip")
+	if err = writeTestZip(zipPath, files); err != nil {
+		return "", "", err
+	}
+	tarGzPath = filepath.Join(dir, name+".tar.gz")
+	if err = writeTestTarGz(tarGzPath, files); err != nil {
+		return "", "", err
+	}
+	return zipPath, tarGzPath, nil
+}
+
+// writeTestZip writes a zip archive holding files at archivePath
+func writeTestZip(archivePath string, files map[string]string) error {
+	zipFile, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zipFile.Close()
+	zipWriter := zip.NewWriter(zipFile)
+	for name, content := range files {
+		entry, err := zipWriter.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err = entry.Write([]byte(content)); err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+// writeTestTarGz writes a tar.gz archive holding files at archivePath
+func writeTestTarGz(archivePath string, files map[string]string) error {
+	tarGzFile, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer tarGzFile.Close()
+	gzipWriter := gzip.NewWriter(tarGzFile)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		if err = tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content))}); err != nil {
+			return err
+		}
+		if _, err = tarWriter.Write([]byte(content)); err != nil {
+			return err
+		}
+	}
+	if err = tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
@@ -105,14 +105,16 @@ func initializeBookkeepingLocations(log logger.T, instanceID string) bool {
 
 	//TODO: initializations for all state tracking folders of core plugins should be moved inside the corresponding core plugins.
 
-	//Create folders pending, current, completed, corrupt under the location DefaultLogDirPath/<instanceId>
+	//Create folders pending, current, completed, corrupt, failures, quarantine under the location DefaultLogDirPath/<instanceId>
 	log.Info("Initializing bookkeeping folders")
 	initStatus := true
 	folders := []string{
 		appconfig.DefaultLocationOfPending,
 		appconfig.DefaultLocationOfCurrent,
 		appconfig.DefaultLocationOfCompleted,
-		appconfig.DefaultLocationOfCorrupt}
+		appconfig.DefaultLocationOfCorrupt,
+		appconfig.DefaultLocationOfFailures,
+		appconfig.DefaultLocationOfQuarantine}
 
 	for _, folder := range folders {
 
@@ -16,10 +16,14 @@ package engine
 
 import (
 	"fmt"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/framework/plugin"
 	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
 	"github.com/aws/amazon-ssm-agent/agent/log"
@@ -57,99 +61,200 @@ func RunPlugins(
 		pluginOutputs[pluginState.Id] = &pluginOutput
 	}
 
-	for _, pluginState := range plugins {
-		pluginID := pluginState.Id     // the identifier of the plugin
-		pluginName := pluginState.Name // the name of the plugin
-		if pluginState.HasExecuted {
-			context.Log().Debugf(
-				"Skipping execution of Plugin - %v of document - %v since it has already executed.",
-				pluginName,
-				executionID)
+	run := &documentRun{
+		executionID:          executionID,
+		pluginRegistry:       pluginRegistry,
+		sendReply:            sendReply,
+		updateAssoc:          updateAssoc,
+		cancelFlag:           cancelFlag,
+		totalNumberOfActions: totalNumberOfActions,
+		pluginOutputs:        pluginOutputs,
+	}
+	limit := maxConcurrentPlugins(context.Log())
+	for _, group := range executionGroups(plugins) {
+		if len(group) == 1 || limit <= 1 {
+			for _, pluginState := range group {
+				run.executePlugin(context, pluginState)
+			}
 			continue
 		}
-		context.Log().Debugf("Executing plugin - %v of document - %v", pluginName, executionID)
+		// the plugins of the group don't depend on each other, run up to limit of them at the same time
+		var wg sync.WaitGroup
+		slots := make(chan bool, limit)
+		for _, pluginState := range group {
+			wg.Add(1)
+			slots <- true
+			go func(pluginState stateModel.PluginState) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				run.executePlugin(context, pluginState)
+			}(pluginState)
+		}
+		wg.Wait()
+	}
 
-		// populate plugin start time and status
-		configuration := pluginState.Configuration
+	return
+}
 
-		pluginOutputs[pluginID] = &contracts.PluginResult{
-			PluginName:    pluginName,
-			Status:        contracts.ResultStatusInProgress,
-			StartDateTime: time.Now(),
-		}
-		if configuration.OutputS3BucketName != "" {
-			pluginOutputs[pluginID].OutputS3BucketName = configuration.OutputS3BucketName
-			if configuration.OutputS3KeyPrefix != "" {
-				pluginOutputs[pluginID].OutputS3KeyPrefix = configuration.OutputS3KeyPrefix
+// maxConcurrentPlugins is the number of plugins of the same execution group that can run at the same time, the
+// default one when the agent configuration can't be loaded
+var maxConcurrentPlugins = func(log log.T) int {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		log.Errorf("Failed to load the agent configuration, running up to %v plugins at the same time: %v",
+			appconfig.DefaultMaxConcurrentPluginsPerDocument, err)
+		return appconfig.DefaultMaxConcurrentPluginsPerDocument
+	}
+	return config.Plugins.MaxConcurrentPluginsPerDocument
+}
 
-			}
-		}
-		var r contracts.PluginResult
-		pluginHandlerFound := false
-
-		//check if the said plugin is a long running plugin
-		handler, isLongRunningPlugin := plugin.RegisteredLongRunningPlugins(context)[pluginName]
-		//check if the said plugin is a worker plugin
-		p, isWorkerPlugin := pluginRegistry[pluginName]
-
-		runner := runpluginutil.PluginRunner{
-			RunPlugins:  RunPlugins,
-			Plugins:     pluginRegistry,
-			SendReply:   runpluginutil.NoReply,
-			UpdateAssoc: runpluginutil.NoUpdate,
-			CancelFlag:  cancelFlag,
-		}
+// s3OutputKeySuffix is the suffix of the keys the plugins upload their outputs to S3 with, the default one when the
+// agent configuration can't be loaded
+var s3OutputKeySuffix = func(log log.T) string {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		log.Errorf("Failed to load the agent configuration, using the default S3 output key suffix: %v", err)
+		return appconfig.DefaultConfig().S3OutputKeySuffix()
+	}
+	return config.S3OutputKeySuffix()
+}
 
-		isSupported, platformDetail := plugin.IsPluginSupportedForCurrentPlatform(context.Log(), pluginName)
-		if isSupported {
-			switch {
-			case isLongRunningPlugin:
-				pluginHandlerFound = true
-				context.Log().Infof("%s is a long running plugin", pluginName)
-				r = runPlugin(context, handler, pluginName, configuration, cancelFlag, runner)
-			case isWorkerPlugin:
-				pluginHandlerFound = true
-				context.Log().Infof("%s is a worker plugin", pluginName)
-				r = runPlugin(context, p, pluginName, configuration, cancelFlag, runner)
-			default:
-				err := fmt.Errorf("Plugin with name %s not found!", pluginName)
-				pluginOutputs[pluginID].Status = contracts.ResultStatusFailed
-				pluginOutputs[pluginID].Error = err
-				context.Log().Error(err)
-			}
-		} else {
-			err := fmt.Errorf("Plugin with name %s is not supported in current platform!\n%s", pluginName, platformDetail)
-			pluginOutputs[pluginID].Status = contracts.ResultStatusFailed
-			pluginOutputs[pluginID].Error = err
-			context.Log().Error(err)
+// executionGroups splits the plugins in the groups they run in, in order. Consecutive plugins of the same execution group
+// form a group, every plugin without an execution group is a group of its own.
+func executionGroups(plugins []stateModel.PluginState) (groups [][]stateModel.PluginState) {
+	for i, pluginState := range plugins {
+		group := pluginState.Configuration.ExecutionGroup
+		if i > 0 && group != "" && group == plugins[i-1].Configuration.ExecutionGroup {
+			groups[len(groups)-1] = append(groups[len(groups)-1], pluginState)
+			continue
 		}
+		groups = append(groups, []stateModel.PluginState{pluginState})
+	}
+	return
+}
 
-		if pluginHandlerFound {
-			pluginOutputs[pluginID].Code = r.Code
-			pluginOutputs[pluginID].Status = r.Status
-			pluginOutputs[pluginID].Error = r.Error
-			pluginOutputs[pluginID].Output = r.Output
+// documentRun is the state shared by the plugins of a document while they run
+type documentRun struct {
+	executionID          string
+	pluginRegistry       runpluginutil.PluginRegistry
+	sendReply            runpluginutil.SendResponse
+	updateAssoc          runpluginutil.UpdateAssociation
+	cancelFlag           task.CancelFlag
+	totalNumberOfActions int
+	// pluginOutputs are the results of the plugins, the lock guards them as well as the replies that read them
+	pluginOutputs map[string]*contracts.PluginResult
+	lock          sync.Mutex
+}
+
+// executePlugin runs a plugin of the document, unless it has already executed, and reports its result
+func (run *documentRun) executePlugin(context context.T, pluginState stateModel.PluginState) {
+	pluginID := pluginState.Id     // the identifier of the plugin
+	pluginName := pluginState.Name // the name of the plugin
+	if pluginState.HasExecuted {
+		context.Log().Debugf(
+			"Skipping execution of Plugin - %v of document - %v since it has already executed.",
+			pluginName,
+			run.executionID)
+		return
+	}
+	context.Log().Debugf("Executing plugin - %v of document - %v", pluginName, run.executionID)
+
+	// populate plugin start time and status
+	configuration := pluginState.Configuration
+
+	pluginOutput := &contracts.PluginResult{
+		PluginName:    pluginName,
+		Status:        contracts.ResultStatusInProgress,
+		StartDateTime: time.Now(),
+	}
+	if configuration.OutputS3BucketName != "" {
+		pluginOutput.OutputS3BucketName = configuration.OutputS3BucketName
+		if configuration.OutputS3KeyPrefix != "" {
+			pluginOutput.OutputS3KeyPrefix = configuration.OutputS3KeyPrefix
 
-			if r.Status == contracts.ResultStatusSuccessAndReboot {
-				context.Log().Debug("Requesting reboot...")
-				rebooter.RequestPendingReboot()
-			}
-		}
-		// set end time.
-		pluginOutputs[pluginID].EndDateTime = time.Now()
-		log := context.Log()
-		if sendReply != nil {
-			log.Infof("Sending response on plugin completion: %v", pluginName)
-			sendReply(executionID, pluginName, pluginOutputs)
 		}
-		if updateAssoc != nil {
-			log.Infof("Update association on plugin completion: %v", pluginID)
-			updateAssoc(log, executionID, times.ToIso8601UTC(time.Now()), pluginOutputs, totalNumberOfActions)
+		pluginOutput.OutputS3KeySuffix = s3OutputKeySuffix(context.Log())
+	}
+	run.lock.Lock()
+	run.pluginOutputs[pluginID] = pluginOutput
+	run.lock.Unlock()
+
+	var r contracts.PluginResult
+	var pluginErr error
+	pluginHandlerFound := false
+	pluginSkipped := false
+
+	//check if the said plugin is a long running plugin
+	handler, isLongRunningPlugin := plugin.RegisteredLongRunningPlugins(context)[pluginName]
+	//check if the said plugin is a worker plugin
+	p, isWorkerPlugin := run.pluginRegistry[pluginName]
+
+	runner := runpluginutil.PluginRunner{
+		RunPlugins:  RunPlugins,
+		Plugins:     run.pluginRegistry,
+		SendReply:   runpluginutil.NoReply,
+		UpdateAssoc: runpluginutil.NoUpdate,
+		CancelFlag:  run.cancelFlag,
+	}
+
+	isSupported, platformDetail := plugin.IsPluginSupportedForCurrentPlatform(context.Log(), pluginName)
+	if isSupported {
+		pluginErr = prepareWorkingDirectory(configuration.DefaultWorkingDirectory)
+		switch {
+		case pluginErr != nil:
+			context.Log().Error(pluginErr)
+		case isLongRunningPlugin:
+			pluginHandlerFound = true
+			context.Log().Infof("%s is a long running plugin", pluginName)
+			r = runPluginWithRetries(context, handler, pluginName, configuration, run.cancelFlag, runner)
+		case isWorkerPlugin:
+			pluginHandlerFound = true
+			context.Log().Infof("%s is a worker plugin", pluginName)
+			r = runPluginWithRetries(context, p, pluginName, configuration, run.cancelFlag, runner)
+		default:
+			pluginErr = fmt.Errorf("Plugin with name %s not found!", pluginName)
+			context.Log().Error(pluginErr)
 		}
+	} else {
+		pluginErr = fmt.Errorf("Plugin with name %s is not supported in current platform!\n%s", pluginName, platformDetail)
+		context.Log().Error(pluginErr)
+		pluginSkipped = true
+	}
 
+	// the results of the other plugins of the document are read by the replies, so they are updated under the lock
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	if pluginErr != nil {
+		pluginOutput.Status = contracts.ResultStatusFailed
+		pluginOutput.Error = pluginErr
+		if pluginSkipped {
+			pluginOutput.Status = contracts.ResultStatusSkipped
+		}
 	}
+	if pluginHandlerFound {
+		pluginOutput.Code = r.Code
+		pluginOutput.Status = r.Status
+		pluginOutput.Error = r.Error
+		pluginOutput.Output = r.Output
 
-	return
+		if r.Status == contracts.ResultStatusSuccessAndReboot {
+			context.Log().Debug("Requesting reboot...")
+			rebooter.RequestPendingReboot()
+		}
+	}
+	// set end time.
+	pluginOutput.EndDateTime = time.Now()
+	log := context.Log()
+	if run.sendReply != nil {
+		log.Infof("Sending response on plugin completion: %v", pluginName)
+		run.sendReply(run.executionID, pluginName, run.pluginOutputs)
+	}
+	if run.updateAssoc != nil {
+		log.Infof("Update association on plugin completion: %v", pluginID)
+		run.updateAssoc(log, run.executionID, times.ToIso8601UTC(time.Now()), run.pluginOutputs, run.totalNumberOfActions)
+	}
 }
 
 func runPlugin(
@@ -175,5 +280,138 @@ func runPlugin(
 		}
 	}()
 	log.Debugf("Running %s", pluginID)
-	return p.Execute(context, config, cancelFlag, runner)
+	if config.TimeoutSeconds <= 0 {
+		return p.Execute(context, config, cancelFlag, runner)
+	}
+	return executeWithTimeout(context, p, config, cancelFlag, runner, time.Duration(config.TimeoutSeconds)*time.Second)
+}
+
+// prepareWorkingDirectory checks that the working directory of a plugin is absolute and creates it if it doesn't
+// exist, an empty directory leaves the plugin in its default working directory
+func prepareWorkingDirectory(workingDirectory string) error {
+	if workingDirectory == "" {
+		return nil
+	}
+	if !filepath.IsAbs(workingDirectory) {
+		return fmt.Errorf("working directory %v is not an absolute path", workingDirectory)
+	}
+	if fileutil.Exists(workingDirectory) {
+		if !fileutil.IsDirectory(workingDirectory) {
+			return fmt.Errorf("working directory %v is not a directory", workingDirectory)
+		}
+		return nil
+	}
+	if err := fileutil.MakeDirsWithExecuteAccess(workingDirectory); err != nil {
+		return fmt.Errorf("working directory %v can't be created: %v", workingDirectory, err)
+	}
+	return nil
+}
+
+// pluginRetryDelay is the time waited before a plugin that failed with a retryable result is run again
+var pluginRetryDelay = 5 * time.Second
+
+// runPluginWithRetries runs a plugin up to the maximum attempts of its configuration, while it fails with a retryable
+// result. Other failures, and the failures of the last attempt, are returned as they are.
+func runPluginWithRetries(
+	context context.T,
+	p runpluginutil.T,
+	pluginID string,
+	config contracts.Configuration,
+	cancelFlag task.CancelFlag,
+	runner runpluginutil.PluginRunner,
+) (res contracts.PluginResult) {
+	log := context.Log()
+	for attempt := 1; ; attempt++ {
+		res = runPlugin(context, p, pluginID, config, cancelFlag, runner)
+		if res.Status != contracts.ResultStatusFailed || !res.Retryable || attempt >= config.MaxAttempts {
+			return
+		}
+		if cancelFlag != nil && cancelFlag.Canceled() {
+			return
+		}
+		log.Infof("%v failed with a retryable result on attempt %v of %v, retrying in %v: %v",
+			pluginID, attempt, config.MaxAttempts, pluginRetryDelay, res.Error)
+		if !waitForRetry(cancelFlag, pluginRetryDelay) {
+			log.Infof("%v is cancelled, not retrying it", pluginID)
+			return
+		}
+	}
+}
+
+// waitForRetry waits for the delay before a retry. It returns false if the document is cancelled or the agent shuts
+// down in the meantime.
+func waitForRetry(cancelFlag task.CancelFlag, delay time.Duration) bool {
+	if cancelFlag == nil {
+		time.Sleep(delay)
+		return true
+	}
+	cancelled := make(chan bool, 1)
+	go func() {
+		state := cancelFlag.Wait()
+		cancelled <- state == task.Canceled || state == task.ShutDown
+	}()
+	select {
+	case <-time.After(delay):
+		return true
+	case isCancelled := <-cancelled:
+		return !isCancelled
+	}
+}
+
+// pluginExitWait is the time a timed out plugin is given to return once it is cancelled
+var pluginExitWait = 10 * time.Second
+
+// executeWithTimeout executes a plugin and cancels it once the timeout is exceeded.
+// A timed out plugin is waited for, up to pluginExitWait, so that it stops writing its output before it is reported.
+// The output of a timed out plugin that exits in time is kept, only its status is reported as timed out.
+func executeWithTimeout(
+	context context.T,
+	p runpluginutil.T,
+	config contracts.Configuration,
+	cancelFlag task.CancelFlag,
+	runner runpluginutil.PluginRunner,
+	timeout time.Duration,
+) (res contracts.PluginResult) {
+	log := context.Log()
+
+	// the plugin gets its own flag so that it can be cancelled without cancelling the document
+	pluginCancelFlag := task.NewChanneledCancelFlag()
+	if cancelFlag != nil {
+		go func() {
+			if state := cancelFlag.Wait(); state == task.Canceled || state == task.ShutDown {
+				pluginCancelFlag.Set(state)
+			}
+		}()
+	}
+
+	results := make(chan contracts.PluginResult, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				results <- contracts.PluginResult{
+					Status: contracts.ResultStatusFailed,
+					Code:   1,
+					Error:  fmt.Errorf("Plugin crashed with message %v!", err),
+				}
+			}
+		}()
+		results <- p.Execute(context, config, pluginCancelFlag, runner)
+	}()
+
+	select {
+	case res = <-results:
+		pluginCancelFlag.Set(task.Completed)
+	case <-time.After(timeout):
+		pluginCancelFlag.Set(task.Canceled)
+		select {
+		case res = <-results:
+		case <-time.After(pluginExitWait):
+			log.Errorf("Plugin %v didn't exit %v after it was cancelled", config.PluginID, pluginExitWait)
+		}
+		res.Status = contracts.ResultStatusTimedOut
+		res.Code = 1
+		res.Error = fmt.Errorf("Plugin %v timed out after %v seconds", config.PluginID, config.TimeoutSeconds)
+		log.Error(res.Error)
+	}
+	return
 }
@@ -14,17 +14,24 @@
 package engine
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/framework/plugin"
 	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/rebooter"
 	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 // TestRunPlugins tests that RunPluginsWithRegistry calls all the expected plugins.
@@ -98,3 +105,316 @@ func TestRunPluginsWithRegistry(t *testing.T) {
 	time.Sleep(10 * time.Second)
 	assert.Equal(t, true, rebooter.RebootRequested())
 }
+
+// sleepingPlugin is a plugin that runs until it is cancelled
+type sleepingPlugin struct {
+	cancelled chan bool
+}
+
+// Execute blocks until the plugin is cancelled
+func (p *sleepingPlugin) Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag, subDocumentRunner runpluginutil.PluginRunner) contracts.PluginResult {
+	if cancelFlag.Wait() == task.Canceled {
+		p.cancelled <- true
+	}
+	return contracts.PluginResult{Status: contracts.ResultStatusSuccess}
+}
+
+// TestRunPluginsWithTimeout tests that a plugin exceeding its timeout is cancelled and the next plugins still run.
+func TestRunPluginsWithTimeout(t *testing.T) {
+	ctx := context.NewMockDefault()
+	sleeping := &sleepingPlugin{cancelled: make(chan bool, 1)}
+	next := new(plugin.Mock)
+	next.On("Execute", mock.Anything, mock.Anything, mock.Anything).Return(contracts.PluginResult{Status: contracts.ResultStatusSuccess})
+
+	pluginRegistry := runpluginutil.PluginRegistry{
+		"sleepingPlugin": sleeping,
+		"nextPlugin":     next,
+	}
+	plugins := []model.PluginState{
+		{
+			Name:          "sleepingPlugin",
+			Id:            "sleepingPlugin",
+			Configuration: contracts.Configuration{PluginID: "sleepingPlugin", TimeoutSeconds: 1},
+		},
+		{
+			Name:          "nextPlugin",
+			Id:            "nextPlugin",
+			Configuration: contracts.Configuration{PluginID: "nextPlugin"},
+		},
+	}
+
+	outputs := RunPlugins(ctx, "TestDocument", "", plugins, pluginRegistry, nil, nil, task.NewChanneledCancelFlag())
+
+	assert.Equal(t, contracts.ResultStatusTimedOut, outputs["sleepingPlugin"].Status)
+	assert.NotNil(t, outputs["sleepingPlugin"].Error)
+	assert.Equal(t, contracts.ResultStatusSuccess, outputs["nextPlugin"].Status)
+	next.AssertExpectations(t)
+
+	// the timed out plugin is told to stop, and has returned before the next plugin runs
+	select {
+	case <-sleeping.cancelled:
+	default:
+		t.Fatal("plugin was not cancelled after its timeout")
+	}
+}
+
+// stuckPlugin is a plugin that ignores its cancellation
+type stuckPlugin struct {
+	release chan bool
+}
+
+// Execute blocks until the plugin is released
+func (p *stuckPlugin) Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag, subDocumentRunner runpluginutil.PluginRunner) contracts.PluginResult {
+	<-p.release
+	return contracts.PluginResult{Status: contracts.ResultStatusSuccess}
+}
+
+// TestExecuteWithTimeoutPluginIgnoringCancel tests that a timed out plugin that doesn't exit once cancelled is only
+// waited for up to pluginExitWait.
+func TestExecuteWithTimeoutPluginIgnoringCancel(t *testing.T) {
+	pluginExitWaitOrig := pluginExitWait
+	pluginExitWait = 100 * time.Millisecond
+	defer func() { pluginExitWait = pluginExitWaitOrig }()
+
+	stuck := &stuckPlugin{release: make(chan bool)}
+	defer close(stuck.release)
+	config := contracts.Configuration{PluginID: "stuckPlugin", TimeoutSeconds: 1}
+
+	start := time.Now()
+	res := executeWithTimeout(context.NewMockDefault(), stuck, config, nil, runpluginutil.PluginRunner{}, 100*time.Millisecond)
+	assert.Equal(t, contracts.ResultStatusTimedOut, res.Status)
+	assert.NotNil(t, res.Error)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+// cancelledPlugin returns its output once cancelled
+type cancelledPlugin struct{}
+
+// Execute blocks until the plugin is cancelled
+func (p *cancelledPlugin) Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag, subDocumentRunner runpluginutil.PluginRunner) contracts.PluginResult {
+	cancelFlag.Wait()
+	return contracts.PluginResult{Status: contracts.ResultStatusCancelled, Code: 2, Output: "partial output", StandardOutput: "partial output"}
+}
+
+// TestExecuteWithTimeoutKeepsOutput tests that a timed out plugin that exits once cancelled keeps its output, with the
+// status of a timed out plugin.
+func TestExecuteWithTimeoutKeepsOutput(t *testing.T) {
+	config := contracts.Configuration{PluginID: "cancelledPlugin", TimeoutSeconds: 1}
+
+	res := executeWithTimeout(context.NewMockDefault(), &cancelledPlugin{}, config, nil, runpluginutil.PluginRunner{}, 100*time.Millisecond)
+	assert.Equal(t, contracts.ResultStatusTimedOut, res.Status)
+	assert.Equal(t, 1, res.Code)
+	assert.NotNil(t, res.Error)
+	assert.Equal(t, "partial output", res.Output)
+	assert.Equal(t, "partial output", res.StandardOutput)
+}
+
+// overlappingPlugin counts the plugins running at the same time
+type overlappingPlugin struct {
+	lock       sync.Mutex
+	running    int
+	maxRunning int
+	// finished is the number of plugins that returned, by the start of each plugin
+	finishedAtStart map[string]int
+	finished        int
+}
+
+// Execute stays running for a while so that the plugins running at the same time overlap
+func (p *overlappingPlugin) Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag, subDocumentRunner runpluginutil.PluginRunner) contracts.PluginResult {
+	p.lock.Lock()
+	p.running++
+	if p.running > p.maxRunning {
+		p.maxRunning = p.running
+	}
+	p.finishedAtStart[config.PluginID] = p.finished
+	p.lock.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+
+	p.lock.Lock()
+	p.running--
+	p.finished++
+	p.lock.Unlock()
+	return contracts.PluginResult{Status: contracts.ResultStatusSuccess}
+}
+
+// TestRunPluginsConcurrently tests that the plugins of an execution group run at the same time, up to the concurrency limit,
+// and that the plugins after the group run once the group is over.
+func TestRunPluginsConcurrently(t *testing.T) {
+	for _, tst := range []struct {
+		limit              int
+		expectedMaxRunning int
+	}{
+		// the plugins run one after the other by default
+		{1, 1},
+		{3, 3},
+	} {
+		maxConcurrentPluginsOrig := maxConcurrentPlugins
+		limit := tst.limit
+		maxConcurrentPlugins = func(log.T) int { return limit }
+
+		overlapping := &overlappingPlugin{finishedAtStart: make(map[string]int)}
+		pluginRegistry := runpluginutil.PluginRegistry{"overlappingPlugin": overlapping}
+		var plugins []model.PluginState
+		for _, pluginID := range []string{"step1", "step2", "step3", "step4"} {
+			config := contracts.Configuration{PluginID: pluginID, PluginName: "overlappingPlugin"}
+			// the last step depends on the others
+			if pluginID != "step4" {
+				config.ExecutionGroup = "independent"
+			}
+			plugins = append(plugins, model.PluginState{Name: "overlappingPlugin", Id: pluginID, Configuration: config})
+		}
+
+		var repliesLock sync.Mutex
+		replies := 0
+		sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+			repliesLock.Lock()
+			defer repliesLock.Unlock()
+			replies++
+		}
+
+		outputs := RunPlugins(context.NewMockDefault(), "TestDocument", "", plugins, pluginRegistry, sendResponse, nil, task.NewChanneledCancelFlag())
+		maxConcurrentPlugins = maxConcurrentPluginsOrig
+
+		assert.Equal(t, tst.expectedMaxRunning, overlapping.maxRunning, "limit %v", tst.limit)
+		assert.Equal(t, 3, overlapping.finishedAtStart["step4"], "limit %v", tst.limit)
+		assert.Equal(t, 4, replies)
+		for _, pluginState := range plugins {
+			assert.Equal(t, contracts.ResultStatusSuccess, outputs[pluginState.Id].Status)
+		}
+	}
+}
+
+func TestExecutionGroups(t *testing.T) {
+	plugin := func(id string, group string) model.PluginState {
+		return model.PluginState{Id: id, Configuration: contracts.Configuration{ExecutionGroup: group}}
+	}
+	groups := executionGroups([]model.PluginState{
+		plugin("a", ""), plugin("b", ""), plugin("c", "x"), plugin("d", "x"), plugin("e", "y"), plugin("f", "x"),
+	})
+
+	var groupIDs [][]string
+	for _, group := range groups {
+		var ids []string
+		for _, pluginState := range group {
+			ids = append(ids, pluginState.Id)
+		}
+		groupIDs = append(groupIDs, ids)
+	}
+	assert.Equal(t, [][]string{{"a"}, {"b"}, {"c", "d"}, {"e"}, {"f"}}, groupIDs)
+}
+
+// TestRunPluginsRetryable tests that a plugin failing with a retryable result is run again,
+// while a plugin failing with a hard failure is not.
+func TestRunPluginsRetryable(t *testing.T) {
+	pluginRetryDelayOrig := pluginRetryDelay
+	pluginRetryDelay = 0
+	defer func() { pluginRetryDelay = pluginRetryDelayOrig }()
+
+	flaky := new(plugin.Mock)
+	flaky.On("Execute", mock.Anything, mock.Anything, mock.Anything).Return(contracts.PluginResult{Status: contracts.ResultStatusFailed, Retryable: true}).Once()
+	flaky.On("Execute", mock.Anything, mock.Anything, mock.Anything).Return(contracts.PluginResult{Status: contracts.ResultStatusSuccess}).Once()
+	failing := new(plugin.Mock)
+	failing.On("Execute", mock.Anything, mock.Anything, mock.Anything).Return(contracts.PluginResult{Status: contracts.ResultStatusFailed})
+
+	pluginRegistry := runpluginutil.PluginRegistry{
+		"flakyPlugin":   flaky,
+		"failingPlugin": failing,
+	}
+	plugins := []model.PluginState{
+		{
+			Name:          "flakyPlugin",
+			Id:            "flakyPlugin",
+			Configuration: contracts.Configuration{PluginID: "flakyPlugin", MaxAttempts: 3},
+		},
+		{
+			Name:          "failingPlugin",
+			Id:            "failingPlugin",
+			Configuration: contracts.Configuration{PluginID: "failingPlugin", MaxAttempts: 3},
+		},
+	}
+
+	outputs := RunPlugins(context.NewMockDefault(), "TestDocument", "", plugins, pluginRegistry, nil, nil, task.NewChanneledCancelFlag())
+
+	assert.Equal(t, contracts.ResultStatusSuccess, outputs["flakyPlugin"].Status)
+	flaky.AssertNumberOfCalls(t, "Execute", 2)
+	assert.Equal(t, contracts.ResultStatusFailed, outputs["failingPlugin"].Status)
+	failing.AssertNumberOfCalls(t, "Execute", 1)
+}
+
+// TestRunPluginsRetryableCancelled tests that cancelling a document ends the wait before the retry of a plugin
+func TestRunPluginsRetryableCancelled(t *testing.T) {
+	pluginRetryDelayOrig := pluginRetryDelay
+	pluginRetryDelay = time.Hour
+	defer func() { pluginRetryDelay = pluginRetryDelayOrig }()
+
+	cancelFlag := task.NewChanneledCancelFlag()
+	flaky := new(plugin.Mock)
+	flaky.On("Execute", mock.Anything, mock.Anything, mock.Anything).Return(contracts.PluginResult{Status: contracts.ResultStatusFailed, Retryable: true}).Run(func(args mock.Arguments) {
+		// the document is cancelled once the plugin waits for its retry
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancelFlag.Set(task.Canceled)
+		}()
+	})
+	pluginRegistry := runpluginutil.PluginRegistry{"flakyPlugin": flaky}
+	plugins := []model.PluginState{
+		{
+			Name:          "flakyPlugin",
+			Id:            "flakyPlugin",
+			Configuration: contracts.Configuration{PluginID: "flakyPlugin", MaxAttempts: 3},
+		},
+	}
+
+	done := make(chan map[string]*contracts.PluginResult)
+	go func() {
+		done <- RunPlugins(context.NewMockDefault(), "TestDocument", "", plugins, pluginRegistry, nil, nil, cancelFlag)
+	}()
+	select {
+	case outputs := <-done:
+		flaky.AssertNumberOfCalls(t, "Execute", 1)
+		assert.Equal(t, contracts.ResultStatusFailed, outputs["flakyPlugin"].Status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the retry of a cancelled plugin wasn't abandoned")
+	}
+}
+
+// TestRunPluginsWorkingDirectory tests that a missing working directory is created before the plugin runs,
+// and that a plugin whose working directory is not absolute fails without running.
+func TestRunPluginsWorkingDirectory(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "workingdir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	workingDirectory := filepath.Join(tempDir, "scratch", "plugin")
+
+	created := new(plugin.Mock)
+	created.On("Execute", mock.Anything, mock.Anything, mock.Anything).Return(contracts.PluginResult{Status: contracts.ResultStatusSuccess})
+	relative := new(plugin.Mock)
+
+	pluginRegistry := runpluginutil.PluginRegistry{
+		"createdPlugin":  created,
+		"relativePlugin": relative,
+	}
+	plugins := []model.PluginState{
+		{
+			Name:          "createdPlugin",
+			Id:            "createdPlugin",
+			Configuration: contracts.Configuration{PluginID: "createdPlugin", DefaultWorkingDirectory: workingDirectory},
+		},
+		{
+			Name:          "relativePlugin",
+			Id:            "relativePlugin",
+			Configuration: contracts.Configuration{PluginID: "relativePlugin", DefaultWorkingDirectory: "scratch"},
+		},
+	}
+
+	outputs := RunPlugins(context.NewMockDefault(), "TestDocument", "", plugins, pluginRegistry, nil, nil, task.NewChanneledCancelFlag())
+
+	assert.Equal(t, contracts.ResultStatusSuccess, outputs["createdPlugin"].Status)
+	assert.True(t, fileutil.IsDirectory(workingDirectory))
+	assert.Equal(t, contracts.ResultStatusFailed, outputs["relativePlugin"].Status)
+	if assert.Error(t, outputs["relativePlugin"].Error) {
+		assert.Contains(t, outputs["relativePlugin"].Error.Error(), "working directory scratch is not an absolute path")
+	}
+	relative.AssertNotCalled(t, "Execute", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -126,7 +126,7 @@ func loadPlatformIndependentPlugins(context context.T) runpluginutil.PluginRegis
 	}
 
 	// registering aws:runPowerShellScript plugin
-	powershellPlugin, err := runscript.NewRunPowerShellPlugin(pluginutil.DefaultPluginConfig())
+	powershellPlugin, err := runscript.NewRunPowerShellPlugin(log, pluginutil.DefaultPluginConfig())
 	powershellPluginName := powershellPlugin.Name
 	if err != nil {
 		log.Errorf("failed to create plugin %s %v", powershellPluginName, err)
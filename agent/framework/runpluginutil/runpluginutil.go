@@ -88,6 +88,9 @@ func ParseDocument(context context.T, documentRaw []byte, orchestrationDir strin
 				PluginName:              pluginName,
 				PluginID:                pluginConfig.Name,
 				DefaultWorkingDirectory: defaultWorkingDirectory,
+				TimeoutSeconds:          pluginConfig.Timeout,
+				ExecutionGroup:          pluginConfig.ExecutionGroup,
+				MaxAttempts:             pluginConfig.MaxAttempts,
 			}
 			pluginConfigurations = append(pluginConfigurations, &config)
 		}
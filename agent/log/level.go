@@ -0,0 +1,141 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package log
+
+import (
+	"github.com/cihub/seelog"
+)
+
+// WithLevel returns a logger that drops the messages of logger below level, one of trace, debug, info, warn, error,
+// critical or off. The logger is returned as is if the level is not valid.
+func WithLevel(logger T, level string) T {
+	minLevel, ok := seelog.LogLevelFromString(level)
+	if !ok {
+		return logger
+	}
+	// the level of a wrapper is set on a copy rather than wrapping it again, so that the calling function is still
+	// found at the same stack depth
+	if wrapper, ok := logger.(*Wrapper); ok {
+		filtered := *wrapper
+		filtered.MinLevel = minLevel
+		return &filtered
+	}
+	return &levelFilter{delegate: logger, minLevel: minLevel}
+}
+
+// levelFilter drops the messages of a logger that isn't a Wrapper below a level
+type levelFilter struct {
+	delegate T
+	minLevel seelog.LogLevel
+}
+
+// Tracef writes to the delegate if the level is Trace or lower
+func (f *levelFilter) Tracef(format string, params ...interface{}) {
+	if f.minLevel <= seelog.TraceLvl {
+		f.delegate.Tracef(format, params...)
+	}
+}
+
+// Debugf writes to the delegate if the level is Debug or lower
+func (f *levelFilter) Debugf(format string, params ...interface{}) {
+	if f.minLevel <= seelog.DebugLvl {
+		f.delegate.Debugf(format, params...)
+	}
+}
+
+// Infof writes to the delegate if the level is Info or lower
+func (f *levelFilter) Infof(format string, params ...interface{}) {
+	if f.minLevel <= seelog.InfoLvl {
+		f.delegate.Infof(format, params...)
+	}
+}
+
+// Warnf writes to the delegate if the level is Warn or lower
+func (f *levelFilter) Warnf(format string, params ...interface{}) error {
+	if f.minLevel <= seelog.WarnLvl {
+		return f.delegate.Warnf(format, params...)
+	}
+	return nil
+}
+
+// Errorf writes to the delegate if the level is Error or lower
+func (f *levelFilter) Errorf(format string, params ...interface{}) error {
+	if f.minLevel <= seelog.ErrorLvl {
+		return f.delegate.Errorf(format, params...)
+	}
+	return nil
+}
+
+// Criticalf writes to the delegate if the level is Critical or lower
+func (f *levelFilter) Criticalf(format string, params ...interface{}) error {
+	if f.minLevel <= seelog.CriticalLvl {
+		return f.delegate.Criticalf(format, params...)
+	}
+	return nil
+}
+
+// Trace writes to the delegate if the level is Trace or lower
+func (f *levelFilter) Trace(v ...interface{}) {
+	if f.minLevel <= seelog.TraceLvl {
+		f.delegate.Trace(v...)
+	}
+}
+
+// Debug writes to the delegate if the level is Debug or lower
+func (f *levelFilter) Debug(v ...interface{}) {
+	if f.minLevel <= seelog.DebugLvl {
+		f.delegate.Debug(v...)
+	}
+}
+
+// Info writes to the delegate if the level is Info or lower
+func (f *levelFilter) Info(v ...interface{}) {
+	if f.minLevel <= seelog.InfoLvl {
+		f.delegate.Info(v...)
+	}
+}
+
+// Warn writes to the delegate if the level is Warn or lower
+func (f *levelFilter) Warn(v ...interface{}) error {
+	if f.minLevel <= seelog.WarnLvl {
+		return f.delegate.Warn(v...)
+	}
+	return nil
+}
+
+// Error writes to the delegate if the level is Error or lower
+func (f *levelFilter) Error(v ...interface{}) error {
+	if f.minLevel <= seelog.ErrorLvl {
+		return f.delegate.Error(v...)
+	}
+	return nil
+}
+
+// Critical writes to the delegate if the level is Critical or lower
+func (f *levelFilter) Critical(v ...interface{}) error {
+	if f.minLevel <= seelog.CriticalLvl {
+		return f.delegate.Critical(v...)
+	}
+	return nil
+}
+
+// Flush flushes the delegate
+func (f *levelFilter) Flush() {
+	f.delegate.Flush()
+}
+
+// Close closes the delegate
+func (f *levelFilter) Close() {
+	f.delegate.Close()
+}
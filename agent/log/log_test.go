@@ -113,3 +113,22 @@ func testLoggerWithContext(t *testing.T, testCase TestCase) {
 	// check result
 	assert.Equal(t, testCase.Output, out.String())
 }
+
+func TestWithLevel(t *testing.T) {
+	var out bytes.Buffer
+	seelogger, err := seelog.LoggerFromWriterWithMinLevelAndFormat(&out, seelog.TraceLvl, "[%Level] %Msg%n")
+	assert.Nil(t, err)
+
+	logger := WithLevel(withContext(seelogger, "<some context>"), "info")
+	logger.Debugf("dropped %v", "message")
+	logger.Debug("dropped message")
+	logger.Infof("kept %v", "message")
+	logger.Error("kept message")
+	logger.Flush()
+
+	assert.Equal(t, "[Info] <some context> kept message\n[Error] <some context> kept message\n", out.String())
+
+	// an invalid level leaves the logger as is
+	unfiltered := withContext(seelogger, "<some context>")
+	assert.Equal(t, unfiltered, WithLevel(unfiltered, "verbose"))
+}
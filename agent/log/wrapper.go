@@ -15,6 +15,8 @@ package log
 
 import (
 	"sync"
+
+	"github.com/cihub/seelog"
 )
 
 // Wrapper is a logger that can modify the format of a log message before delegating to another logger.
@@ -22,6 +24,8 @@ type Wrapper struct {
 	Format   FormatFilter
 	Delegate T
 	M        *sync.Mutex
+	// MinLevel is the level below which messages are dropped, the zero value lets every message through
+	MinLevel seelog.LogLevel
 }
 
 // FormatFilter can modify the format and or parameters to be passed to a logger.
@@ -37,6 +41,9 @@ type FormatFilter interface {
 // Tracef formats message according to format specifier
 // and writes to log with level = Trace.
 func (w Wrapper) Tracef(format string, params ...interface{}) {
+	if w.MinLevel > seelog.TraceLvl {
+		return
+	}
 	format, params = w.Format.Filterf(format, params...)
 
 	w.M.Lock()
@@ -47,6 +54,9 @@ func (w Wrapper) Tracef(format string, params ...interface{}) {
 // Debugf formats message according to format specifier
 // and writes to log with level = Debug.
 func (w Wrapper) Debugf(format string, params ...interface{}) {
+	if w.MinLevel > seelog.DebugLvl {
+		return
+	}
 	format, params = w.Format.Filterf(format, params...)
 
 	w.M.Lock()
@@ -57,6 +67,9 @@ func (w Wrapper) Debugf(format string, params ...interface{}) {
 // Infof formats message according to format specifier
 // and writes to log with level = Info.
 func (w Wrapper) Infof(format string, params ...interface{}) {
+	if w.MinLevel > seelog.InfoLvl {
+		return
+	}
 	format, params = w.Format.Filterf(format, params...)
 
 	w.M.Lock()
@@ -67,6 +80,9 @@ func (w Wrapper) Infof(format string, params ...interface{}) {
 // Warnf formats message according to format specifier
 // and writes to log with level = Warn.
 func (w Wrapper) Warnf(format string, params ...interface{}) error {
+	if w.MinLevel > seelog.WarnLvl {
+		return nil
+	}
 	format, params = w.Format.Filterf(format, params...)
 
 	w.M.Lock()
@@ -77,6 +93,9 @@ func (w Wrapper) Warnf(format string, params ...interface{}) error {
 // Errorf formats message according to format specifier
 // and writes to log with level = Error.
 func (w Wrapper) Errorf(format string, params ...interface{}) error {
+	if w.MinLevel > seelog.ErrorLvl {
+		return nil
+	}
 	format, params = w.Format.Filterf(format, params...)
 
 	w.M.Lock()
@@ -87,6 +106,9 @@ func (w Wrapper) Errorf(format string, params ...interface{}) error {
 // Criticalf formats message according to format specifier
 // and writes to log with level = Critical.
 func (w Wrapper) Criticalf(format string, params ...interface{}) error {
+	if w.MinLevel > seelog.CriticalLvl {
+		return nil
+	}
 	format, params = w.Format.Filterf(format, params...)
 
 	w.M.Lock()
@@ -97,6 +119,9 @@ func (w Wrapper) Criticalf(format string, params ...interface{}) error {
 // Trace formats message using the default formats for its operands
 // and writes to log with level = Trace
 func (w Wrapper) Trace(v ...interface{}) {
+	if w.MinLevel > seelog.TraceLvl {
+		return
+	}
 	v = w.Format.Filter(v...)
 
 	w.M.Lock()
@@ -107,6 +132,9 @@ func (w Wrapper) Trace(v ...interface{}) {
 // Debug formats message using the default formats for its operands
 // and writes to log with level = Debug
 func (w Wrapper) Debug(v ...interface{}) {
+	if w.MinLevel > seelog.DebugLvl {
+		return
+	}
 	v = w.Format.Filter(v...)
 
 	w.M.Lock()
@@ -117,6 +145,9 @@ func (w Wrapper) Debug(v ...interface{}) {
 // Info formats message using the default formats for its operands
 // and writes to log with level = Info
 func (w Wrapper) Info(v ...interface{}) {
+	if w.MinLevel > seelog.InfoLvl {
+		return
+	}
 	v = w.Format.Filter(v...)
 
 	w.M.Lock()
@@ -127,6 +158,9 @@ func (w Wrapper) Info(v ...interface{}) {
 // Warn formats message using the default formats for its operands
 // and writes to log with level = Warn
 func (w Wrapper) Warn(v ...interface{}) error {
+	if w.MinLevel > seelog.WarnLvl {
+		return nil
+	}
 	v = w.Format.Filter(v...)
 
 	w.M.Lock()
@@ -137,6 +171,9 @@ func (w Wrapper) Warn(v ...interface{}) error {
 // Error formats message using the default formats for its operands
 // and writes to log with level = Error
 func (w Wrapper) Error(v ...interface{}) error {
+	if w.MinLevel > seelog.ErrorLvl {
+		return nil
+	}
 	v = w.Format.Filter(v...)
 
 	w.M.Lock()
@@ -147,6 +184,9 @@ func (w Wrapper) Error(v ...interface{}) error {
 // Critical formats message using the default formats for its operands
 // and writes to log with level = Critical
 func (w Wrapper) Critical(v ...interface{}) error {
+	if w.MinLevel > seelog.CriticalLvl {
+		return nil
+	}
 	v = w.Format.Filter(v...)
 
 	w.M.Lock()
@@ -285,7 +285,7 @@ func (p *Plugin) IsCloudWatchExeRunning(log log.T, workingDirectory, orchestrati
 
 	//construct command name and arguments
 	commandName := pluginutil.GetShellCommand()
-	commandArguments := append(pluginutil.GetShellArguments(), scriptPath, appconfig.ExitCodeTrap)
+	commandArguments := append(pluginutil.GetShellArguments(log), scriptPath, appconfig.ExitCodeTrap)
 	log.Infof("check running commandName: %s", commandName)
 	log.Infof("arguments passed: %s", commandArguments)
 
@@ -362,7 +362,7 @@ func (p *Plugin) GetPidOfCloudWatchExe(log log.T, orchestrationDir, workingDirec
 
 	//construct command name and arguments
 	commandName := pluginutil.GetShellCommand()
-	commandArguments := append(pluginutil.GetShellArguments(), scriptPath, appconfig.ExitCodeTrap)
+	commandArguments := append(pluginutil.GetShellArguments(log), scriptPath, appconfig.ExitCodeTrap)
 	log.Infof("commandName: %s", commandName)
 	log.Infof("arguments passed: %s", commandArguments)
 
@@ -15,8 +15,14 @@
 package parser
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/log"
@@ -25,6 +31,39 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/parameterstore"
 )
 
+// compressedPayloadPrefix is how a base64 encoded gzip stream starts, it can't be the start of a plain JSON payload
+const compressedPayloadPrefix = "H4sI"
+
+// DecodePayload returns the JSON content of an MDS payload, which is either plain or gzip compressed and base64 encoded.
+// A compressed payload fails to decode once it decompresses to more than maxBytes, zero for no limit.
+func DecodePayload(payload string, maxBytes int) (string, error) {
+	if !strings.HasPrefix(payload, compressedPayloadPrefix) {
+		return payload, nil
+	}
+	compressed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode compressed payload, %v", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress payload, %v", err)
+	}
+	defer reader.Close()
+	var source io.Reader = reader
+	if maxBytes > 0 {
+		// one byte over the maximum is enough to tell that the payload exceeds it
+		source = io.LimitReader(reader, int64(maxBytes)+1)
+	}
+	decompressed, err := ioutil.ReadAll(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress payload, %v", err)
+	}
+	if maxBytes > 0 && len(decompressed) > maxBytes {
+		return "", fmt.Errorf("decompressed payload exceeds the maximum of %v bytes", maxBytes)
+	}
+	return string(decompressed), nil
+}
+
 // ParseMessageWithParams parses an MDS message and replaces the parameters where needed.
 func ParseMessageWithParams(log log.T, payload string) (parsedMessage messageContracts.SendCommandPayload, err error) {
 	// parse message to retrieve parameters
@@ -106,15 +145,11 @@ func ReplacePluginParameters(
 	if mainSteps != nil || len(mainSteps) != 0 {
 		updatedMainSteps := make([]*contracts.InstancePluginConfig, len(mainSteps))
 		for index, instancePluginConfig := range mainSteps {
-			updatedMainSteps[index] = &contracts.InstancePluginConfig{
-				Action:      instancePluginConfig.Action,
-				Name:        instancePluginConfig.Name,
-				MaxAttempts: instancePluginConfig.MaxAttempts,
-				OnFailure:   instancePluginConfig.OnFailure,
-				Timeout:     instancePluginConfig.Timeout,
-				Settings:    parameters.ReplaceParameters(instancePluginConfig.Settings, params, logger),
-				Inputs:      parameters.ReplaceParameters(instancePluginConfig.Inputs, params, logger),
-			}
+			// the other fields of the step, like its execution group and working directory, are kept as they are
+			updatedMainStep := *instancePluginConfig
+			updatedMainStep.Settings = parameters.ReplaceParameters(instancePluginConfig.Settings, params, logger)
+			updatedMainStep.Inputs = parameters.ReplaceParameters(instancePluginConfig.Inputs, params, logger)
+			updatedMainSteps[index] = &updatedMainStep
 
 			logger.Debug("Resolving SSM parameters")
 			// Resolves SSM parameters
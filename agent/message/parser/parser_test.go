@@ -15,8 +15,12 @@
 package parser
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	"github.com/aws/amazon-ssm-agent/agent/log"
@@ -26,10 +30,12 @@ import (
 
 var sampleMessageFiles = []string{
 	"../testdata/sampleMsg.json",
+	"../testdata/sampleMsgMainSteps.json",
 }
 
 var sampleMessageReplacedParamsFiles = []string{
 	"../testdata/sampleMsgReplacedParams.json",
+	"../testdata/sampleMsgMainStepsReplacedParams.json",
 }
 
 var logger = log.NewMockLog()
@@ -61,6 +67,70 @@ func TestParseMessageWithParams(t *testing.T) {
 	}
 }
 
+func TestParseMessageWithParamsCompressedPayload(t *testing.T) {
+	for i, msgFileName := range sampleMessageFiles {
+		plain := loadFile(t, msgFileName)
+
+		encoded := compressPayload(t, plain)
+
+		plainPayload, err := DecodePayload(string(plain), 0)
+		assert.Nil(t, err)
+		assert.Equal(t, string(plain), plainPayload)
+
+		compressedPayload, err := DecodePayload(encoded, len(plain))
+		assert.Nil(t, err)
+		assert.Equal(t, string(plain), compressedPayload)
+
+		plainMsg, err := ParseMessageWithParams(logger, plainPayload)
+		assert.Nil(t, err)
+		compressedMsg, err := ParseMessageWithParams(logger, compressedPayload)
+		assert.Nil(t, err)
+		assert.Equal(t, plainMsg, compressedMsg)
+		assert.Equal(t, loadMessageFromFile(t, sampleMessageReplacedParamsFiles[i]), compressedMsg)
+	}
+}
+
+// TestParseMessageWithParamsKeepsMainStepFields tests that replacing the parameters of the schema 2.0 steps keeps
+// the fields of the steps that don't hold parameters
+func TestParseMessageWithParamsKeepsMainStepFields(t *testing.T) {
+	parsedMsg, err := ParseMessageWithParams(logger, string(loadFile(t, "../testdata/sampleMsgMainSteps.json")))
+	assert.Nil(t, err)
+	assert.Len(t, parsedMsg.DocumentContent.MainSteps, 3)
+	for _, step := range parsedMsg.DocumentContent.MainSteps[:2] {
+		assert.Equal(t, "parallel", step.ExecutionGroup)
+	}
+	assert.Equal(t, []string{"first", "second"}, parsedMsg.DocumentContent.MainSteps[2].DependsOn)
+}
+
+func TestDecodePayloadInvalidCompressedPayload(t *testing.T) {
+	_, err := DecodePayload(compressedPayloadPrefix+"!!!", 0)
+	assert.Error(t, err)
+}
+
+func TestDecodePayloadExceedsMaximum(t *testing.T) {
+	plain := []byte(strings.Repeat(" ", 1024*1024) + "{}")
+	encoded := compressPayload(t, plain)
+
+	_, err := DecodePayload(encoded, 1024)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds the maximum of 1024 bytes")
+
+	// a payload decompressing to exactly the maximum is decoded
+	decoded, err := DecodePayload(encoded, len(plain))
+	assert.Nil(t, err)
+	assert.Equal(t, string(plain), decoded)
+}
+
+// compressPayload returns plain gzip compressed and base64 encoded, the way MDS sends a compressed payload
+func compressPayload(t *testing.T, plain []byte) string {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write(plain)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.Close())
+	return base64.StdEncoding.EncodeToString(compressed.Bytes())
+}
+
 func loadFile(t *testing.T, fileName string) (result []byte) {
 	result, err := ioutil.ReadFile(fileName)
 	if err != nil {
@@ -17,6 +17,7 @@ package processor
 import (
 	"encoding/json"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
@@ -32,9 +33,9 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/message/parser"
 	"github.com/aws/amazon-ssm-agent/agent/message/service"
 	"github.com/aws/amazon-ssm-agent/agent/platform"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/pluginutil"
 	"github.com/aws/amazon-ssm-agent/agent/reply"
 	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
-	"github.com/aws/amazon-ssm-agent/agent/statemanager"
 	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 	"github.com/aws/amazon-ssm-agent/agent/times"
@@ -57,8 +58,6 @@ const (
 	// CancelCommandTopicPrefix is the topic prefix for a cancel command MDS message received from the offline service.
 	CancelCommandTopicPrefixOffline TopicPrefix = "aws.ssm.cancelCommand.offline."
 
-	CancelWorkersLimit = 3
-
 	// mdsname is the core plugin name for the MDS processor
 	mdsName = "MessageProcessor"
 
@@ -75,6 +74,9 @@ const (
 
 	// the default stoppolicy error threshold. After 10 consecutive errors the plugin will stop for 15 minutes.
 	stopPolicyErrorThreshold = 10
+
+	// maxReplyOutputBytes bounds the outputs of all the plugins in a single reply
+	maxReplyOutputBytes = 200000
 )
 
 type replyBuilder func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload
@@ -103,12 +105,92 @@ type Processor struct {
 	processorStopPolicy  *sdkutil.StopPolicy
 	pollAssociations     bool
 	supportedDocTypes    []model.DocumentType
+	metrics              ProcessorMetrics
+	compactOrchestration bool
+	// orchestrationRetention and orchestrationRetentionMaxCount bound the orchestration directories kept on disk
+	orchestrationRetention         time.Duration
+	orchestrationRetentionMaxCount int
+	// minFreeInodesPercent triggers the removal of the oldest orchestration directories when the free inodes of the
+	// orchestration root drop below it, zero to not monitor the inodes
+	minFreeInodesPercent int
+	// orchestrationCleanupWorkers is the number of orchestration directories checked and removed at the same time
+	orchestrationCleanupWorkers int
+	orchestrationCleanupJob     *scheduler.Job
+	clock                       times.Clock
+	// maxDocumentReboots is the number of reboots a document can request before it is failed
+	maxDocumentReboots int
+	// inFlightDocuments are the documents submitted to the pools, by job id
+	inFlightDocuments map[string]*inFlightDocument
+	inFlightLock      sync.Mutex
+	// polledDocuments holds the documents of the messages of a poll until all of them are persisted, so that they are
+	// submitted by priority, nil outside of a poll
+	polledDocuments     *[]model.DocumentState
+	polledDocumentsLock sync.Mutex
+	// sendCommandWorkersLimit and cancelCommandWorkersLimit are the sizes of the pools
+	sendCommandWorkersLimit   int
+	cancelCommandWorkersLimit int
+	health                    processorHealth
+	// parseRetryCount is the number of times parsing a message is retried after a transient failure
+	parseRetryCount int
+	// validatePlugins fails send commands naming unsupported plugins before they are acknowledged
+	validatePlugins bool
+	// processDocumentTypes are the document types processed, as configured in appconfig, empty for all types
+	processDocumentTypes []string
+	// maxDocumentRuntime is how long a document can run before it is cancelled and timed out, zero for no limit
+	maxDocumentRuntime time.Duration
+	// outputWarnBytes is the total plugin output size of a document above which a warning is logged, zero to never warn
+	outputWarnBytes int
+	// maxMessageFailures is the number of times a message can fail before it is quarantined, zero to never quarantine
+	maxMessageFailures int
+	// maxMessagePayloadBytes is the size above which a message is failed without being parsed, zero for no limit
+	maxMessagePayloadBytes int
+	// globalEnvironment are environment variables added to the configuration of every plugin
+	globalEnvironment map[string]string
+	// aggregationPolicies are the aggregation policies of the documents in progress, applied to their replies
+	aggregationPolicies *aggregationPolicies
+	// skippedPluginsFailDocument counts the skipped plugins of a document as failed in its status
+	skippedPluginsFailDocument bool
+	// deadLetterUploader uploads the quarantined messages to deadLetterS3Bucket, nil to only quarantine them locally
+	deadLetterUploader pluginutil.OutputUploader
+	deadLetterS3Bucket string
+	// serverClock estimates the clock of MDS the expiration of the documents is evaluated with, nil to use the
+	// instance clock
+	serverClock *serverClock
+	// clockSkewTolerance is how long after its expiration a document is still started
+	clockSkewTolerance time.Duration
+	// completionNotifier is notified of the documents that reach a terminal state, nil for no notification
+	completionNotifier CompletionNotifier
+	// auditSink receives an audit record of the documents that reach a terminal state, nil for no audit
+	auditSink AuditSink
+	// inProgressReplyJitter bounds the random delay of the InProgress reply of a document, zero to reply immediately
+	inProgressReplyJitter time.Duration
+	// replyToDeleteDelay is the wait between the reply of a completed document and the deletion of its message
+	replyToDeleteDelay time.Duration
+	// lifecycleListeners are called as the documents go through the processor
+	lifecycleListeners []LifecycleListener
+	// unsupportedDocumentsURL is the url the list of the documents incompatible with managed instances is refreshed
+	// from every unsupportedDocumentsRefresh, empty to only use the local list
+	unsupportedDocumentsURL        string
+	unsupportedDocumentsRefresh    int
+	unsupportedDocumentsRefreshJob *scheduler.Job
 }
 
 // PluginRunner is a function that can run a set of plugins and return their outputs.
-type PluginRunner func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag) (pluginOutputs map[string]*contracts.PluginResult)
-
-var pluginRunner = func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag) (pluginOutputs map[string]*contracts.PluginResult) {
+// The plugins are interrupted when shutdownSignal is closed.
+type PluginRunner func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) (pluginOutputs map[string]*contracts.PluginResult)
+
+var pluginRunner = func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) (pluginOutputs map[string]*contracts.PluginResult) {
+	// plugins already abort on a ShutDown cancel flag, so forward the shutdown signal to the flag for as long as they run
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-shutdownSignal:
+			context.Log().Info("Agent is shutting down, interrupting running plugins")
+			cancelFlag.Set(task.ShutDown)
+		case <-done:
+		}
+	}()
 	return engine.RunPlugins(context, documentID, "", plugins, plugin.RegisteredWorkerPlugins(context), sendResponse, nil, cancelFlag)
 }
 
@@ -132,15 +214,27 @@ func NewMdsProcessor(context context.T) *Processor {
 	mdsService := newMdsService(context.AppConfig())
 	config := context.AppConfig()
 
-	return NewProcessor(messageContext, mdsName, mdsService, config.Mds.CommandWorkersLimit, CancelWorkersLimit, true, []model.DocumentType{model.SendCommand, model.CancelCommand})
+	return NewProcessor(messageContext, mdsName, mdsService, config.Mds.CommandWorkersLimit, config.Mds.CancelWorkersLimit, true, []model.DocumentType{model.SendCommand, model.CancelCommand})
+}
+
+// logSubsystem is the name of the processor in the log levels of the agent configuration
+const logSubsystem = "processor"
+
+// withSubsystemLogLevel applies the log level configured for the processor, if any, to the loggers of ctx
+func withSubsystemLogLevel(ctx context.T) context.T {
+	if level, ok := ctx.AppConfig().Log.Levels[logSubsystem]; ok {
+		return context.WithLogLevel(ctx, level)
+	}
+	return ctx
 }
 
 // NewProcessor performs common initialization for Mds and Offline processors
 func NewProcessor(context context.T, processorName string, processorService service.Service, commandWorkerLimit int, cancelWorkerLimit int, pollAssoc bool, supportedDocs []model.DocumentType) *Processor {
+	context = withSubsystemLogLevel(context)
 	log := context.Log()
 	config := context.AppConfig()
 
-	instanceID, err := platform.InstanceID()
+	instanceID, err := getInstanceID()
 	if instanceID == "" {
 		log.Errorf("no instanceID provided, %v", err)
 		return nil
@@ -163,20 +257,24 @@ func NewProcessor(context context.T, processorName string, processorService serv
 	// so we can define the number of workers per each
 	cancelWaitDuration := 10000 * time.Millisecond
 	clock := times.DefaultClock
-	sendCommandTaskPool := task.NewPool(log, commandWorkerLimit, cancelWaitDuration, clock)
-	cancelCommandTaskPool := task.NewPool(log, CancelWorkersLimit, cancelWaitDuration, clock)
+	sendCommandTaskPool := newTaskPool(log, commandWorkerLimit, cancelWaitDuration, clock)
+	cancelCommandTaskPool := newTaskPool(log, cancelWorkerLimit, cancelWaitDuration, clock)
 
 	// create new message processor
 	orchestrationRootDir := filepath.Join(appconfig.DefaultDataStorePath, instanceID, appconfig.DefaultDocumentRootDirName, config.Agent.OrchestrationRootDir)
 
-	replyBuilder := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
-		runtimeStatuses := reply.PrepareRuntimeStatuses(log, results)
-		return reply.PrepareReplyPayload(pluginID, runtimeStatuses, clock.Now(), agentConfig.AgentInfo, true)
+	// the capabilities of the agent are only added to the replies when the agent is configured to report them
+	var capabilities *contracts.AgentCapabilities
+	if config.Mds.ReportCapabilities {
+		capabilities = agentCapabilities(context, agentInfo)
 	}
 
-	statusReplyBuilder := func(agentInfo contracts.AgentInfo, resultStatus contracts.ResultStatus, documentTraceOutput string) messageContracts.SendReplyPayload {
-		return parser.PrepareReplyPayloadToUpdateDocumentStatus(agentInfo, resultStatus, documentTraceOutput)
+	replyBuilder := withCapabilities(newReplyBuilder(log, clock, agentConfig.AgentInfo, config.Mds.MaxPluginOutputBytes, config.Mds.SkippedPluginsFailDocument), capabilities)
 
+	statusReplyBuilder := func(agentInfo contracts.AgentInfo, resultStatus contracts.ResultStatus, documentTraceOutput string) messageContracts.SendReplyPayload {
+		payload := parser.PrepareReplyPayloadToUpdateDocumentStatus(agentInfo, resultStatus, documentTraceOutput)
+		payload.AdditionalInfo.Capabilities = capabilities
+		return payload
 	}
 	// create a stop policy where we will stop after 10 consecutive errors and if time period expires.
 	processorStopPolicy := newStopPolicy(processorName)
@@ -184,8 +282,11 @@ func NewProcessor(context context.T, processorName string, processorService serv
 	// SendResponse is used to send response on plugin completion.
 	// If pluginID is empty it will send responses of all plugins.
 	// If pluginID is specified, response will be sent of that particular plugin.
+	// The document status is computed with the aggregation policy of the document.
+	aggregationPolicies := newAggregationPolicies(config.Mds.SkippedPluginsFailDocument)
 	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
 		payloadDoc := replyBuilder(pluginID, results)
+		aggregationPolicies.apply(messageID, &payloadDoc)
 		processSendReply(log, messageID, processorService, payloadDoc, processorStopPolicy)
 	}
 
@@ -198,7 +299,7 @@ func NewProcessor(context context.T, processorName string, processorService serv
 
 	// PersistData is used to persist the data into a bookkeeping folder
 	persistData := func(state *model.DocumentState, bookkeeping string) {
-		statemanager.PersistData(log, state.DocumentInformation.DocumentID, state.DocumentInformation.InstanceID, bookkeeping, *state)
+		persistDocumentState(log, state.DocumentInformation.DocumentID, state.DocumentInformation.InstanceID, bookkeeping, *state)
 	}
 
 	var assocProc *processor.Processor
@@ -206,23 +307,66 @@ func NewProcessor(context context.T, processorName string, processorService serv
 		assocProc = processor.NewAssociationProcessor(context, instanceID)
 	}
 	return &Processor{
-		context:              context,
-		name:                 processorName,
-		stopSignal:           make(chan bool),
-		config:               agentConfig,
-		service:              processorService,
-		pluginRunner:         pluginRunner,
-		sendCommandPool:      sendCommandTaskPool,
-		cancelCommandPool:    cancelCommandTaskPool,
-		buildReply:           replyBuilder,
-		sendResponse:         sendResponse,
-		sendDocLevelResponse: sendDocLevelResponse,
-		orchestrationRootDir: orchestrationRootDir,
-		persistData:          persistData,
-		processorStopPolicy:  processorStopPolicy,
-		assocProcessor:       assocProc,
-		pollAssociations:     pollAssoc,
-		supportedDocTypes:    supportedDocs,
+		context:                        context,
+		name:                           processorName,
+		stopSignal:                     make(chan bool),
+		config:                         agentConfig,
+		service:                        processorService,
+		pluginRunner:                   pluginRunner,
+		sendCommandPool:                sendCommandTaskPool,
+		cancelCommandPool:              cancelCommandTaskPool,
+		buildReply:                     replyBuilder,
+		sendResponse:                   sendResponse,
+		sendDocLevelResponse:           sendDocLevelResponse,
+		orchestrationRootDir:           orchestrationRootDir,
+		persistData:                    persistData,
+		processorStopPolicy:            processorStopPolicy,
+		assocProcessor:                 assocProc,
+		pollAssociations:               pollAssoc,
+		supportedDocTypes:              supportedDocs,
+		metrics:                        noOpMetrics{},
+		compactOrchestration:           config.Agent.CompactOrchestrationDir,
+		orchestrationRetention:         newOrchestrationRetention(config.Mds.OrchestrationRetentionDays),
+		orchestrationRetentionMaxCount: config.Mds.OrchestrationRetentionMaxCount,
+		minFreeInodesPercent:           config.Mds.OrchestrationMinFreeInodesPercent,
+		orchestrationCleanupWorkers:    config.Mds.OrchestrationCleanupWorkers,
+		clock:                          clock,
+		maxDocumentReboots:             config.Mds.MaxDocumentReboots,
+		sendCommandWorkersLimit:        commandWorkerLimit,
+		cancelCommandWorkersLimit:      cancelWorkerLimit,
+		parseRetryCount:                config.Mds.ParseRetryCount,
+		validatePlugins:                config.Mds.ValidatePluginsBeforeAck,
+		processDocumentTypes:           config.Mds.ProcessDocumentTypes,
+		maxDocumentRuntime:             time.Duration(config.Mds.MaxDocumentRuntimeSeconds) * time.Second,
+		outputWarnBytes:                config.Mds.OutputWarnBytes,
+		maxMessageFailures:             config.Mds.MaxMessageFailures,
+		maxMessagePayloadBytes:         config.Mds.MaxMessagePayloadBytes,
+		globalEnvironment:              config.Plugins.GlobalEnvironment,
+		aggregationPolicies:            aggregationPolicies,
+		skippedPluginsFailDocument:     config.Mds.SkippedPluginsFailDocument,
+		completionNotifier:             newWebhookNotifier(config.Mds.CompletionWebhookURL),
+		auditSink:                      newAuditSink(log, config.Audit.Enabled),
+		deadLetterUploader:             newDeadLetterUploader(config.Mds.DeadLetterS3Bucket),
+		deadLetterS3Bucket:             config.Mds.DeadLetterS3Bucket,
+		serverClock:                    newServerClock(config.Mds.UseServerTimeForExpiration),
+		clockSkewTolerance:             time.Duration(config.Mds.ClockSkewToleranceSeconds) * time.Second,
+		inProgressReplyJitter:          time.Duration(config.Mds.InProgressReplyJitterMillis) * time.Millisecond,
+		replyToDeleteDelay:             time.Duration(config.Mds.ReplyToDeleteDelayMillis) * time.Millisecond,
+		unsupportedDocumentsURL:        config.Mds.UnsupportedDocumentsURL,
+		unsupportedDocumentsRefresh:    config.Mds.UnsupportedDocumentsRefreshMinutes,
+	}
+}
+
+// newReplyBuilder returns a replyBuilder that truncates the output of the plugins to fit in a reply, unless
+// maxPluginOutputBytes is 0. The full outputs are left untouched in the orchestration directory.
+// skippedPluginsFailDocument counts the skipped plugins as failed in the document status.
+func newReplyBuilder(log log.T, clock times.Clock, agentInfo contracts.AgentInfo, maxPluginOutputBytes int, skippedPluginsFailDocument bool) replyBuilder {
+	return func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		runtimeStatuses := reply.PrepareRuntimeStatuses(log, results)
+		if maxPluginOutputBytes > 0 {
+			reply.TruncateRuntimeStatuses(runtimeStatuses, maxPluginOutputBytes, maxReplyOutputBytes)
+		}
+		return reply.PrepareReplyPayload(pluginID, runtimeStatuses, clock.Now(), agentInfo, true, skippedPluginsFailDocument)
 	}
 }
 
@@ -239,6 +383,10 @@ func processSendReply(log log.T, messageID string, mdsService service.Service, p
 	}
 }
 
+var getInstanceID = platform.InstanceID
+
+var newTaskPool = task.NewPool
+
 var newOfflineService = func(log log.T) (service.Service, error) {
 	return service.NewOfflineService(log, string(SendCommandTopicPrefixOffline))
 }
@@ -246,12 +394,19 @@ var newOfflineService = func(log log.T) (service.Service, error) {
 var newMdsService = func(config appconfig.SsmagentConfig) service.Service {
 	connectionTimeout := time.Duration(config.Mds.StopTimeoutMillis) * time.Millisecond
 
-	return service.NewService(
+	mdsService := service.NewService(
 		config.Agent.Region,
 		config.Mds.Endpoint,
 		nil,
 		connectionTimeout,
 	)
+	if config.Mds.CircuitBreakerFailureThreshold <= 0 {
+		return mdsService
+	}
+	return service.NewCircuitBreakerService(mdsService,
+		config.Mds.CircuitBreakerFailureThreshold,
+		time.Duration(config.Mds.CircuitBreakerCooldownSeconds)*time.Second,
+		times.DefaultClock)
 }
 
 var newStopPolicy = func(name string) *sdkutil.StopPolicy {
@@ -0,0 +1,102 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_aggregation contains the policies the status of a document is computed from the statuses of its plugins with
+package processor
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	messageContracts "github.com/aws/amazon-ssm-agent/agent/message/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/reply"
+)
+
+// validateAggregationPolicy returns an error if the aggregation policy of a document is unknown
+func validateAggregationPolicy(policy string) error {
+	switch policy {
+	case "", contracts.AggregationPolicyAllMustSucceed, contracts.AggregationPolicyAnySucceeds:
+		return nil
+	}
+	return fmt.Errorf("unknown aggregation policy %v, expected %v or %v",
+		policy, contracts.AggregationPolicyAllMustSucceed, contracts.AggregationPolicyAnySucceeds)
+}
+
+// isDefaultAggregationPolicy returns true if the document status is computed the same way as without a policy
+func isDefaultAggregationPolicy(policy string) bool {
+	return policy == "" || policy == contracts.AggregationPolicyAllMustSucceed
+}
+
+// aggregateReplyStatus recomputes the document status of a reply from its plugin status counts with the policy
+func aggregateReplyStatus(policy string, skippedPluginsFailDocument bool, payload *messageContracts.SendReplyPayload) {
+	pluginCounts := 0
+	for _, count := range payload.AdditionalInfo.RuntimeStatusCounts {
+		pluginCounts += count
+	}
+	payload.DocumentStatus = reply.AggregateDocumentStatus(policy, skippedPluginsFailDocument, payload.AdditionalInfo.RuntimeStatusCounts, pluginCounts)
+}
+
+// withAggregationPolicy returns a replyBuilder that computes the document status with the aggregation policy of the document
+func withAggregationPolicy(buildReply replyBuilder, policy string, skippedPluginsFailDocument bool) replyBuilder {
+	if isDefaultAggregationPolicy(policy) {
+		return buildReply
+	}
+	return func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		payload := buildReply(pluginID, results)
+		aggregateReplyStatus(policy, skippedPluginsFailDocument, &payload)
+		return payload
+	}
+}
+
+// aggregationPolicies are the aggregation policies of the documents in progress that don't use the default one,
+// by message id. They apply to the replies sent for the documents while they execute.
+type aggregationPolicies struct {
+	lock     sync.RWMutex
+	policies map[string]string
+	// skippedPluginsFailDocument counts the skipped plugins as failed when the document status is recomputed
+	skippedPluginsFailDocument bool
+}
+
+func newAggregationPolicies(skippedPluginsFailDocument bool) *aggregationPolicies {
+	return &aggregationPolicies{policies: make(map[string]string), skippedPluginsFailDocument: skippedPluginsFailDocument}
+}
+
+// set records the aggregation policy of the document of a message, until the returned function is called
+func (a *aggregationPolicies) set(messageID string, policy string) (clear func()) {
+	if a == nil || isDefaultAggregationPolicy(policy) {
+		return func() {}
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.policies[messageID] = policy
+	return func() {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		delete(a.policies, messageID)
+	}
+}
+
+// apply recomputes the document status of a reply with the aggregation policy of the document of the message
+func (a *aggregationPolicies) apply(messageID string, payload *messageContracts.SendReplyPayload) {
+	if a == nil {
+		return
+	}
+	a.lock.RLock()
+	policy, found := a.policies[messageID]
+	a.lock.RUnlock()
+	if found {
+		aggregateReplyStatus(policy, a.skippedPluginsFailDocument, payload)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_audit contains the audit records of the completed documents
+package processor
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// auditSource is the name the audit records are written under in the event log of the operating system
+const auditSource = "amazon-ssm-agent"
+
+// AuditRecord is the record of the execution of a document written to the audit sink
+type AuditRecord struct {
+	Time         time.Time              `json:"time"`
+	InstanceID   string                 `json:"instanceId"`
+	CommandID    string                 `json:"commandId"`
+	DocumentID   string                 `json:"documentId"`
+	DocumentName string                 `json:"documentName"`
+	Status       contracts.ResultStatus `json:"status"`
+	// DurationMillis is the time the agent spent executing the document
+	DurationMillis int64               `json:"durationMillis"`
+	Plugins        []AuditPluginRecord `json:"plugins"`
+}
+
+// AuditPluginRecord is the result of one plugin of an audited document
+type AuditPluginRecord struct {
+	ID     string                 `json:"id"`
+	Name   string                 `json:"name"`
+	Status contracts.ResultStatus `json:"status"`
+	Code   int                    `json:"code"`
+}
+
+// AuditSink receives an audit record of every document that reaches a terminal state.
+// Implementations must be safe to call from multiple worker goroutines.
+type AuditSink interface {
+	WriteAuditRecord(record AuditRecord) error
+}
+
+// SetAuditSink sets the sink the processor writes the audit records of the completed documents to.
+func (p *Processor) SetAuditSink(sink AuditSink) {
+	p.auditSink = sink
+}
+
+// newAuditSink returns the audit sink of the platform, or nil if the audit is disabled or the sink can't be opened
+func newAuditSink(log log.T, enabled bool) AuditSink {
+	if !enabled {
+		return nil
+	}
+	sink, err := newPlatformAuditSink()
+	if err != nil {
+		log.Errorf("failed to open the audit sink, documents won't be audited: %v", err)
+		return nil
+	}
+	return sink
+}
+
+// auditDocumentCompleted writes the audit record of the completed document to the sink, if any.
+// Failures are logged, they never affect the processing of the document.
+func (p *Processor) auditDocumentCompleted(log log.T, docState model.DocumentState, startTime time.Time) {
+	if p.auditSink == nil {
+		return
+	}
+	docInfo := docState.DocumentInformation
+	record := AuditRecord{
+		Time:           time.Now().UTC(),
+		InstanceID:     docInfo.InstanceID,
+		CommandID:      docInfo.CommandID,
+		DocumentID:     docInfo.DocumentID,
+		DocumentName:   docInfo.DocumentName,
+		Status:         docInfo.DocumentStatus,
+		DurationMillis: int64(time.Since(startTime) / time.Millisecond),
+		Plugins:        make([]AuditPluginRecord, 0, len(docState.InstancePluginsInformation)),
+	}
+	for _, pluginState := range docState.InstancePluginsInformation {
+		record.Plugins = append(record.Plugins, AuditPluginRecord{
+			ID:     pluginState.Id,
+			Name:   pluginState.Name,
+			Status: pluginState.Result.Status,
+			Code:   pluginState.Result.Code,
+		})
+	}
+	if err := p.auditSink.WriteAuditRecord(record); err != nil {
+		log.Errorf("failed to audit the completion of document %v: %v", docInfo.DocumentID, err)
+	}
+}
+
+// formatAuditRecord returns the json of an audit record, the message written to the event log
+func formatAuditRecord(record AuditRecord) (string, error) {
+	content, err := json.Marshal(record)
+	return string(content), err
+}
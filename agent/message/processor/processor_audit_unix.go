@@ -0,0 +1,44 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build darwin || freebsd || linux || netbsd || openbsd
+// +build darwin freebsd linux netbsd openbsd
+
+// Package processor implements MDS plugin processor
+// processor_audit_unix contains the audit sink writing to syslog, which journald also collects
+package processor
+
+import "log/syslog"
+
+// syslogAuditSink writes the audit records to the local syslog
+type syslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+// newPlatformAuditSink opens the local syslog
+func newPlatformAuditSink() (AuditSink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, auditSource)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogAuditSink{writer: writer}, nil
+}
+
+// WriteAuditRecord writes the record as a json message
+func (s *syslogAuditSink) WriteAuditRecord(record AuditRecord) error {
+	message, err := formatAuditRecord(record)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(message)
+}
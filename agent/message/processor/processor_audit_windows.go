@@ -0,0 +1,47 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build windows
+// +build windows
+
+// Package processor implements MDS plugin processor
+// processor_audit_windows contains the audit sink writing to the Windows Event Log
+package processor
+
+import "golang.org/x/sys/windows/svc/eventlog"
+
+// auditEventID is the id of the audit events in the Application log
+const auditEventID = 1000
+
+// eventLogAuditSink writes the audit records to the Application log
+type eventLogAuditSink struct {
+	log *eventlog.Log
+}
+
+// newPlatformAuditSink opens the Application log under the audit source
+func newPlatformAuditSink() (AuditSink, error) {
+	log, err := eventlog.Open(auditSource)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLogAuditSink{log: log}, nil
+}
+
+// WriteAuditRecord writes the record as a json message
+func (s *eventLogAuditSink) WriteAuditRecord(record AuditRecord) error {
+	message, err := formatAuditRecord(record)
+	if err != nil {
+		return err
+	}
+	return s.log.Info(auditEventID, message)
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_cancelall contains the tracking of the documents submitted to the pools and their cancellation
+package processor
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+)
+
+// cancelAllTraceOutput is the trace output of the documents cancelled by CancelAll before they started
+const cancelAllTraceOutput = "document was cancelled before execution because all in-flight documents were cancelled"
+
+// inFlightDocument is a document submitted to one of the pools of the processor
+type inFlightDocument struct {
+	docState  *model.DocumentState
+	pool      task.Pool
+	started   bool
+	cancelled bool
+}
+
+// submitDocument submits the job of a document to the pool and tracks the document until the job returns
+func (p *Processor) submitDocument(log log.T, pool task.Pool, docState *model.DocumentState, job task.Job) error {
+	jobID := docState.DocumentInformation.MessageID
+	if !p.trackDocument(jobID, docState, pool) {
+		return fmt.Errorf("document %v is already submitted", jobID)
+	}
+	err := pool.Submit(log, jobID, func(cancelFlag task.CancelFlag) {
+		// a document cancelled by CancelAll before the job started is completed here, after the jobs of the pool
+		// that run before it
+		start, cancelled := p.startDocument(jobID)
+		if cancelled {
+			p.completeNotStartedDocument(log, docState)
+		}
+		if !start {
+			return
+		}
+		defer p.untrackDocument(jobID)
+		job(cancelFlag)
+	})
+	if err != nil {
+		p.untrackDocument(jobID)
+	}
+	return err
+}
+
+// trackDocument adds the document to the in-flight documents, returns false if a document with the same job id is already tracked
+func (p *Processor) trackDocument(jobID string, docState *model.DocumentState, pool task.Pool) bool {
+	p.inFlightLock.Lock()
+	defer p.inFlightLock.Unlock()
+	if p.inFlightDocuments == nil {
+		p.inFlightDocuments = make(map[string]*inFlightDocument)
+	}
+	if _, found := p.inFlightDocuments[jobID]; found {
+		return false
+	}
+	p.inFlightDocuments[jobID] = &inFlightDocument{docState: docState, pool: pool}
+	return true
+}
+
+// startDocument marks an in-flight document as started, returns false if the document is no longer tracked or if it
+// was cancelled before it started, cancelled is then true and the document is no longer tracked
+func (p *Processor) startDocument(jobID string) (start bool, cancelled bool) {
+	p.inFlightLock.Lock()
+	defer p.inFlightLock.Unlock()
+	doc, found := p.inFlightDocuments[jobID]
+	if !found {
+		return false, false
+	}
+	if doc.cancelled {
+		delete(p.inFlightDocuments, jobID)
+		return false, true
+	}
+	doc.started = true
+	return true, false
+}
+
+// untrackDocument removes the document from the in-flight documents
+func (p *Processor) untrackDocument(jobID string) {
+	p.inFlightLock.Lock()
+	defer p.inFlightLock.Unlock()
+	delete(p.inFlightDocuments, jobID)
+}
+
+// CancelAll cancels every document submitted to the send command and cancel command pools.
+// Running documents are cancelled through their cancel flag and complete as cancelled on their own,
+// documents that haven't started yet are marked cancelled and moved to Completed by their job, without running.
+// It is safe to call concurrently with the processing of messages, and more than once.
+func (p *Processor) CancelAll() {
+	p.inFlightLock.Lock()
+	defer p.inFlightLock.Unlock()
+	for jobID, doc := range p.inFlightDocuments {
+		// the pool discards the cancelled jobs it hasn't started, so only the running ones are cancelled in the pool
+		if doc.started && !doc.cancelled {
+			doc.pool.Cancel(jobID)
+		}
+		doc.cancelled = true
+	}
+}
+
+// completeNotStartedDocument marks a document in the Current folder that never started as cancelled and moves it to Completed
+func (p *Processor) completeNotStartedDocument(log log.T, docState *model.DocumentState) {
+	log.Debugf("Command %v was cancelled before it started, completing it", docState.DocumentInformation.CommandID)
+
+	docInfo := docState.DocumentInformation
+	docInfo.DocumentStatus = contracts.ResultStatusCancelled
+	docInfo.DocumentTraceOutput = cancelAllTraceOutput
+	persistDocumentInfo(log, docInfo, docInfo.DocumentID, docInfo.InstanceID, appconfig.DefaultLocationOfCurrent)
+	moveDocumentState(log,
+		docInfo.DocumentID,
+		docInfo.InstanceID,
+		appconfig.DefaultLocationOfCurrent,
+		appconfig.DefaultLocationOfCompleted)
+
+	// the document never ran, so the service is told it failed
+	p.sendDocLevelResponse(docInfo.MessageID, contracts.ResultStatusFailed, cancelAllTraceOutput)
+	p.getMetrics().RecordMessageFailed(string(contracts.ResultStatusCancelled))
+
+	if err := p.service.DeleteMessage(log, docInfo.MessageID); err != nil {
+		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_capabilities contains the capabilities the agent reports in the replies of the documents
+package processor
+
+import (
+	"sort"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/framework/plugin"
+	messageContracts "github.com/aws/amazon-ssm-agent/agent/message/contracts"
+)
+
+// documentFeatures are the features of the documents and of their steps the agent supports
+var documentFeatures = []string{
+	"aggregationPolicy",
+	"dependsOn",
+	"executionGroup",
+	"maxAttempts",
+	"priority",
+	"requiredTags",
+	"workingDirectory",
+}
+
+// registeredPluginNames returns the names of the worker and long running plugins registered on the agent
+var registeredPluginNames = func(context context.T) []string {
+	var names []string
+	for name := range plugin.RegisteredWorkerPlugins(context) {
+		names = append(names, name)
+	}
+	for name := range plugin.RegisteredLongRunningPlugins(context) {
+		names = append(names, name)
+	}
+	return names
+}
+
+// agentCapabilities returns the capabilities of the agent, with the registered plugins supported on the platform
+func agentCapabilities(context context.T, agentInfo contracts.AgentInfo) *contracts.AgentCapabilities {
+	plugins := []string{}
+	for _, name := range registeredPluginNames(context) {
+		if supported, _ := isPluginSupported(context, name); supported {
+			plugins = append(plugins, name)
+		}
+	}
+	sort.Strings(plugins)
+	return &contracts.AgentCapabilities{
+		AgentVersion: agentInfo.Version,
+		Os:           agentInfo.Os,
+		OsVersion:    agentInfo.OsVersion,
+		Plugins:      plugins,
+		Features:     documentFeatures,
+	}
+}
+
+// withCapabilities adds the capabilities to the replies built by buildReply, nil capabilities leave them unchanged
+func withCapabilities(buildReply replyBuilder, capabilities *contracts.AgentCapabilities) replyBuilder {
+	if capabilities == nil {
+		return buildReply
+	}
+	return func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		payload := buildReply(pluginID, results)
+		payload.AdditionalInfo.Capabilities = capabilities
+		return payload
+	}
+}
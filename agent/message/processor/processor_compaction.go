@@ -0,0 +1,62 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_compaction contains functions that consolidate the artifacts of completed documents
+package processor
+
+import (
+	"path"
+	"path/filepath"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// compactedArtifactsExtension is the extension of the archive that replaces the orchestration directory of a document
+const compactedArtifactsExtension = ".zip"
+
+// getCompactedArtifactsPath returns the archive that holds the artifacts of a compacted orchestration directory
+func getCompactedArtifactsPath(orchestrationDir string) string {
+	return filepath.Clean(orchestrationDir) + compactedArtifactsExtension
+}
+
+// compactCompletedDocument compacts the orchestration directory of a completed document if compaction is enabled
+func (p *Processor) compactCompletedDocument(log log.T, orchestrationRootDir string, docState model.DocumentState) {
+	// the updater keeps writing to the orchestration directory after the document completes
+	if !p.compactOrchestration || isMessageDeletionExternal(docState) {
+		return
+	}
+	compactDocumentArtifacts(log, filepath.Join(orchestrationRootDir, docState.DocumentInformation.CommandID))
+}
+
+// compactDocumentArtifacts replaces the orchestration directory of a document with a single archive of its content.
+// The directory is left in place if the archive can't be created.
+func compactDocumentArtifacts(log log.T, orchestrationDir string) {
+	if !fileutil.Exists(orchestrationDir) {
+		return
+	}
+	archivePath := getCompactedArtifactsPath(orchestrationDir)
+	log.Debugf("Compacting orchestration directory %v into %v", orchestrationDir, archivePath)
+	// the archives the plugins downloaded are not kept with the artifacts of the document
+	if err := fileutil.CompactDirectory(orchestrationDir, archivePath, path.Join("*", appconfig.PluginDownloadsFolderName)); err != nil {
+		log.Errorf("Failed to compact orchestration directory %v, %v", orchestrationDir, err)
+		fileutil.DeleteFile(archivePath)
+		return
+	}
+	if err := fileutil.DeleteDirectory(orchestrationDir); err != nil {
+		log.Errorf("Failed to delete compacted orchestration directory %v, %v", orchestrationDir, err)
+	}
+}
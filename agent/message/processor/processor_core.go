@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
@@ -34,17 +35,134 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/message/service"
 	"github.com/aws/amazon-ssm-agent/agent/platform"
 	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
-	commandStateHelper "github.com/aws/amazon-ssm-agent/agent/statemanager"
 	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 	"github.com/aws/aws-sdk-go/service/ssmmds"
 )
 
 var singletonMapOfUnsupportedSSMDocs map[string]bool
+var unsupportedSSMDocsLock sync.RWMutex
 var once sync.Once
 
+var listUnsupportedSSMDocs = model.ManagedInstanceIncompatibleDocuments
+
 var loadDocStateFromSendCommand = parseSendCommandMessage
 var loadDocStateFromCancelCommand = parseCancelCommandMessage
+var isManagedInstance = platform.IsManagedInstance
+
+var isCancelTargetOwned = cancelTargetOwned
+
+// Placeholders of the S3 key prefix template
+const (
+	s3KeyPrefixPlaceholderPrefix     = "{prefix}"
+	s3KeyPrefixPlaceholderCommandID  = "{commandId}"
+	s3KeyPrefixPlaceholderInstanceID = "{instanceId}"
+	s3KeyPrefixPlaceholderRegion     = "{region}"
+)
+
+var getRegion = platform.Region
+
+// buildS3KeyPrefix returns the S3 key prefix of the outputs of a command. Without a template the prefix requested
+// in the command is joined with the command id and the instance id.
+func buildS3KeyPrefix(template, outputS3KeyPrefix, commandID, instanceID string) (string, error) {
+	if template == "" {
+		return path.Join(outputS3KeyPrefix, commandID, instanceID), nil
+	}
+
+	region := ""
+	if strings.Contains(template, s3KeyPrefixPlaceholderRegion) {
+		var err error
+		if region, err = getRegion(); err != nil {
+			return "", err
+		}
+	}
+	replacer := strings.NewReplacer(
+		s3KeyPrefixPlaceholderPrefix, outputS3KeyPrefix,
+		s3KeyPrefixPlaceholderCommandID, commandID,
+		s3KeyPrefixPlaceholderInstanceID, instanceID,
+		s3KeyPrefixPlaceholderRegion, region)
+	// clean up the separators left by empty values
+	return strings.Trim(path.Clean(replacer.Replace(template)), "/"), nil
+}
+
+// ReloadUnsupportedDocuments replaces the documents incompatible with managed instances with the current list.
+// Messages being parsed during the reload see either the previous or the new list.
+func ReloadUnsupportedDocuments() {
+	loadUnsupportedDocuments()
+	// the first load is not needed anymore once the list is reloaded
+	once.Do(func() {})
+}
+
+// loadUnsupportedDocuments builds the map of the documents incompatible with managed instances and swaps it in
+func loadUnsupportedDocuments() {
+	swapUnsupportedDocuments(listUnsupportedSSMDocs())
+}
+
+// swapUnsupportedDocuments replaces the documents incompatible with managed instances with documentNames
+func swapUnsupportedDocuments(documentNames []string) {
+	unsupportedDocs := make(map[string]bool)
+	for _, documentName := range documentNames {
+		unsupportedDocs[documentName] = true
+	}
+
+	unsupportedSSMDocsLock.Lock()
+	defer unsupportedSSMDocsLock.Unlock()
+	singletonMapOfUnsupportedSSMDocs = unsupportedDocs
+}
+
+// unsupportedSSMDocuments returns the documents incompatible with managed instances, loading them on first use.
+// The returned map is never modified, a reload replaces it.
+func unsupportedSSMDocuments() map[string]bool {
+	once.Do(loadUnsupportedDocuments)
+
+	unsupportedSSMDocsLock.RLock()
+	defer unsupportedSSMDocsLock.RUnlock()
+	return singletonMapOfUnsupportedSSMDocs
+}
+
+// isUnsupportedSSMDocument returns true if the document could contain code incompatible with managed instances
+func isUnsupportedSSMDocument(documentName string) bool {
+	return unsupportedSSMDocuments()[documentName]
+}
+
+// shutdownTraceOutput is the trace of a document that was interrupted because the agent is stopping
+const shutdownTraceOutput = "agent shutting down"
+
+// isShutdownRequested returns true if the shutdown signal has been closed
+func isShutdownRequested(shutdownSignal <-chan bool) bool {
+	select {
+	case <-shutdownSignal:
+		return true
+	default:
+		return false
+	}
+}
+
+// persistInterruptedDocument marks a document interrupted by shutdown as failed and keeps it in the current folder,
+// so it is resumed when the agent restarts rather than completed
+func persistInterruptedDocument(log log.T, docInfo model.DocumentInfo) {
+	log.Infof("Agent is shutting down, keeping document %v in the current folder to resume on restart", docInfo.DocumentID)
+	docInfo.DocumentStatus = contracts.ResultStatusFailed
+	docInfo.DocumentTraceOutput = shutdownTraceOutput
+	persistDocumentInfo(log,
+		docInfo,
+		docInfo.DocumentID,
+		docInfo.InstanceID,
+		appconfig.DefaultLocationOfCurrent)
+}
+
+// withCorrelationID returns a SendResponse that sets the correlation ID of the plugin results before sending them.
+// The correlation ID of a plugin result is the ID of the MDS message of its document.
+func withCorrelationID(sendResponse runpluginutil.SendResponse) runpluginutil.SendResponse {
+	return func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		for _, result := range results {
+			if result != nil {
+				result.CorrelationID = messageID
+			}
+		}
+		sendResponse(messageID, pluginID, results)
+	}
+}
 
 // runCmdsUsingCmdState takes commandState as an input and executes only those plugins which haven't yet executed. This is functionally
 // very similar to processSendCommandMessage because everything to do with cmd execution is part of that function right now.
@@ -57,15 +175,26 @@ func (p *Processor) runCmdsUsingCmdState(context context.T,
 	docState model.DocumentState) {
 
 	log := context.Log()
+	startTime := time.Now()
+	secureValues := documentSecureValues(&docState)
+	sendResponse = withCorrelationID(p.withPluginCompleteEvents(docState.DocumentInformation.DocumentID,
+		withSecureParameterMasking(secureValues, sendResponse)))
+	defer p.aggregationPolicies.set(docState.DocumentInformation.MessageID, docState.DocumentInformation.AggregationPolicy)()
+	buildReply = withAggregationPolicy(buildReply, docState.DocumentInformation.AggregationPolicy, p.skippedPluginsFailDocument)
 
 	//Since only some plugins of a cmd gets executed here - there is no need to get output from engine & construct the sendReply output.
 	//Instead after all plugins of a command get executed, use persisted data to construct sendReply payload
-	outputs := runPlugins(context, docState.DocumentInformation.MessageID, docState.InstancePluginsInformation, sendResponse, cancelFlag)
+	applyGlobalEnvironment(docState.InstancePluginsInformation, p.globalEnvironment)
+	p.emitExecutionStart(docState.DocumentInformation.DocumentID)
+	stopDeadline := p.startDocumentDeadline(log, docState.DocumentInformation.DocumentID, cancelFlag)
+	outputs := p.runPluginsWithProgress(context, runPlugins, cancelFlag, sendResponse, &docState)
+	timedOut := stopDeadline()
+	p.checkOutputSize(log, docState.DocumentInformation.DocumentID, outputs)
 
 	payloadDoc := buildReply("", outputs)
 
 	//read from persisted file
-	newCmdState := commandStateHelper.GetDocumentInterimState(log,
+	newCmdState := getDocumentInterimState(log,
 		docState.DocumentInformation.DocumentID,
 		docState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfCurrent)
@@ -76,18 +205,32 @@ func (p *Processor) runCmdsUsingCmdState(context context.T,
 	newCmdState.DocumentInformation.DocumentTraceOutput = payloadDoc.DocumentTraceOutput
 	newCmdState.DocumentInformation.RuntimeStatus = payloadDoc.RuntimeStatus
 
+	if timedOut {
+		p.timeOutDocument(&newCmdState, outputs, buildReply)
+	}
+
+	if newCmdState.IsRebootRequired() {
+		recordRebootRequest(log, &newCmdState, outputs)
+		p.countDocumentReboot(log, &newCmdState, outputs, buildReply)
+	}
+
+	if isShutdownRequested(p.stopSignal) {
+		persistInterruptedDocument(log, newCmdState.DocumentInformation)
+		return
+	}
+
 	//persist final documentInfo.
-	commandStateHelper.PersistDocumentInfo(log,
+	persistDocumentInfo(log,
 		newCmdState.DocumentInformation,
 		newCmdState.DocumentInformation.DocumentID,
 		newCmdState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfCurrent)
 
 	pluginOutputContent, _ := jsonutil.Marshal(outputs)
-	log.Debugf("plugin outputs %v", jsonutil.Indent(pluginOutputContent))
+	log.Debugf("plugin outputs %v", maskSecureValues(jsonutil.Indent(pluginOutputContent), secureValues))
 
 	//send document level reply
-	log.Debug("sending reply on message completion ", outputs)
+	log.Debug("sending reply on message completion ", maskPluginResults(outputs, secureValues))
 	sendResponse(newCmdState.DocumentInformation.MessageID, "", outputs)
 
 	// Skip sending response when the document requires a reboot
@@ -99,15 +242,21 @@ func (p *Processor) runCmdsUsingCmdState(context context.T,
 	//persist : commands execution in completed folder (terminal state folder)
 	log.Debugf("execution of %v is over. Moving interimState file from Current to Completed folder", newCmdState.DocumentInformation.MessageID)
 
-	commandStateHelper.MoveDocumentState(log,
+	moveDocumentState(log,
 		newCmdState.DocumentInformation.DocumentID,
 		newCmdState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfCurrent,
 		appconfig.DefaultLocationOfCompleted)
 
+	p.compactCompletedDocument(log, p.orchestrationRootDir, newCmdState)
+	p.notifyDocumentCompleted(log, newCmdState.DocumentInformation, startTime)
+	p.auditDocumentCompleted(log, newCmdState, startTime)
+	p.emitDocumentComplete(newCmdState.DocumentInformation.DocumentID, newCmdState.DocumentInformation.DocumentStatus)
+
 	log.Debugf("deleting message")
 
-	if !isUpdatePlugin(newCmdState) {
+	if !isMessageDeletionExternal(newCmdState) {
+		p.waitBeforeMessageDeletion(log)
 		err := mdsService.DeleteMessage(log, newCmdState.DocumentInformation.MessageID)
 		if err != nil {
 			sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
@@ -117,6 +266,30 @@ func (p *Processor) runCmdsUsingCmdState(context context.T,
 	}
 }
 
+// parseRetryDelay is the wait before the first retry of a parse that failed with a transient error, it doubles with every retry
+var parseRetryDelay = time.Second
+
+// loadDocStateWithRetry parses a send command message, retrying up to parseRetryCount times with backoff while the parse fails
+// with a transient error. Other errors are returned right away since the payload won't parse on retry.
+func (p *Processor) loadDocStateWithRetry(context context.T, msg *ssmmds.Message) (docState *model.DocumentState, err error) {
+	delay := parseRetryDelay
+	for attempt := 0; ; attempt++ {
+		docState, err = loadDocStateFromSendCommand(context, msg, p.orchestrationRootDir)
+		if err == nil || !isTransientError(err) || attempt >= p.parseRetryCount {
+			return docState, err
+		}
+		context.Log().Debugf("Parsing message failed with transient error, retrying in %v: %v", delay, err)
+		// the retries are abandoned on shutdown, the message is delivered again once the agent is back
+		select {
+		case <-p.stopSignal:
+			context.Log().Debugf("Processor is stopping, not retrying to parse the message")
+			return docState, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
 func (p *Processor) processMessage(msg *ssmmds.Message) {
 	var (
 		docState *model.DocumentState
@@ -127,55 +300,146 @@ func (p *Processor) processMessage(msg *ssmmds.Message) {
 	context := p.context.With("[messageID=" + *msg.MessageId + "]")
 	log := context.Log()
 	log.Debug("Processing message")
+	p.getMetrics().RecordMessageReceived()
 
 	if err = validate(msg); err != nil {
 		log.Error("message not valid, ignoring: ", err)
+		p.getMetrics().RecordMessageFailed(metricsReasonInvalidMessage)
+		p.recordError(err)
+		return
+	}
+	p.emitReceived(*msg.MessageId)
+	p.observeMessageClock(log, msg)
+
+	// a huge payload is failed before it is parsed into memory
+	if p.maxMessagePayloadBytes > 0 && msg.Payload != nil && len(*msg.Payload) > p.maxMessagePayloadBytes {
+		err = fmt.Errorf("message payload of %v bytes exceeds the maximum of %v bytes", len(*msg.Payload), p.maxMessagePayloadBytes)
+		log.Error(err)
+		p.sendDocLevelResponse(*msg.MessageId, contracts.ResultStatusFailed, err.Error())
+		p.getMetrics().RecordMessageFailed(metricsReasonPayloadTooLarge)
+		p.recordError(err)
+		if err = p.service.FailMessage(log, *msg.MessageId, service.InternalHandlerException); err != nil {
+			sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+		}
+		return
+	}
+
+	if p.isMessageQuarantined(*msg.MessageId) {
+		log.Debug("message is quarantined, deleting it")
+		p.dropQuarantinedMessage(log, msg)
 		return
 	}
 
 	if strings.HasPrefix(*msg.Topic, string(SendCommandTopicPrefix)) {
-		docState, err = loadDocStateFromSendCommand(context, msg, p.orchestrationRootDir)
+		docState, err = p.loadDocStateWithRetry(context, msg)
+		if err != nil && isTransientError(err) {
+			if p.recordMessageFailure(log, msg, err) {
+				p.dropQuarantinedMessage(log, msg)
+				return
+			}
+			// leave the message unacknowledged so that it is delivered again
+			log.Error("unable to process message, it will be retried ", err)
+			p.getMetrics().RecordMessageFailed(metricsReasonTransientFailure)
+			p.recordError(err)
+			return
+		}
 		if err != nil {
 			log.Error(err)
 			p.sendDocLevelResponse(*msg.MessageId, contracts.ResultStatusFailed, err.Error())
-			return
 		}
 	} else if strings.HasPrefix(*msg.Topic, string(CancelCommandTopicPrefix)) {
 		docState, err = loadDocStateFromCancelCommand(context, msg, p.orchestrationRootDir)
+		if err != nil {
+			log.Error(err)
+			p.sendDocLevelResponse(*msg.MessageId, contracts.ResultStatusFailed, err.Error())
+		}
 	} else {
 		err = fmt.Errorf("unexpected topic name %v", *msg.Topic)
 	}
 
 	if err != nil {
 		log.Error("format of received message is invalid ", err)
+		p.getMetrics().RecordMessageFailed(metricsReasonParseFailed)
+		p.recordError(err)
+		if p.recordMessageFailure(log, msg, err) {
+			p.dropQuarantinedMessage(log, msg)
+			return
+		}
 		if err = p.service.FailMessage(log, *msg.MessageId, service.InternalHandlerException); err != nil {
 			sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
 		}
 		return
 	}
 
+	// fail fast, before reporting the document in progress, if one of its plugins can't run here
+	if p.validatePlugins && (docState.DocumentType == model.SendCommand || docState.DocumentType == model.SendCommandOffline) {
+		if err = validateDocumentPlugins(context, docState); err != nil {
+			log.Error(err)
+			p.sendDocLevelResponse(*msg.MessageId, contracts.ResultStatusFailed, err.Error())
+			p.getMetrics().RecordMessageFailed(metricsReasonUnsupportedPlugin)
+			if err = p.service.FailMessage(log, *msg.MessageId, service.InternalHandlerException); err != nil {
+				sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+			}
+			return
+		}
+	}
+
 	//persisting received msg in file-system [pending folder]
 	p.persistData(docState, appconfig.DefaultLocationOfPending)
 	if err = p.service.AcknowledgeMessage(log, *msg.MessageId); err != nil {
 		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+		p.getMetrics().RecordMessageFailed(metricsReasonAcknowledgeFailed)
+		p.recordError(err)
 		return
 	}
+	p.clearMessageFailures(log, *msg.MessageId)
+	p.emitAcked(*msg.MessageId)
 
 	log.Debugf("Ack done. Received message - messageId - %v, MessageString - %v", *msg.MessageId, msg.GoString())
 	log.Debugf("Processing to send a reply to update the document status to InProgress")
 
-	p.sendDocLevelResponse(*msg.MessageId, contracts.ResultStatusInProgress, "")
+	p.sendInProgressReply(log, *msg.MessageId, docState.DocumentInformation.DocumentID)
 
 	log.Debugf("SendReply done. Received message - messageId - %v, MessageString - %v", *msg.MessageId, msg.GoString())
 
-	p.ExecutePendingDocument(docState)
+	p.executePolledDocument(docState)
 }
 
 // submitDocForExecution moves doc to current folder and submit it for execution
 func (p *Processor) ExecutePendingDocument(docState *model.DocumentState) {
 	log := p.context.Log()
 
-	commandStateHelper.MoveDocumentState(log,
+	// the agent can be restricted to some document types, e.g. to only cancel commands during an incident
+	if reason := p.documentTypeNotProcessed(docState.DocumentType); reason != "" {
+		if docState.DocumentType == model.CancelCommand || docState.DocumentType == model.CancelCommandOffline {
+			p.completeSkippedCancelCommand(docState, reason, metricsReasonDocumentTypeNotProcessed)
+		} else {
+			p.completeSkippedDocument(docState, reason, metricsReasonDocumentTypeNotProcessed)
+		}
+		return
+	}
+
+	// a cancel may have reached the document before it was submitted
+	if (docState.DocumentType == model.SendCommand || docState.DocumentType == model.SendCommandOffline) &&
+		isPendingDocumentCancelled(log, docState) {
+		p.completeCancelledDocument(docState)
+		return
+	}
+
+	// documents restricted to instances with some tags are skipped by the other instances
+	if docState.DocumentType == model.SendCommand || docState.DocumentType == model.SendCommandOffline {
+		if reason := requiredTagsUnmet(log, docState.DocumentInformation.RequiredTags); reason != "" {
+			p.completeSkippedDocument(docState, reason, metricsReasonRequiredTagsUnmet)
+			return
+		}
+		// documents that expired while they were pending are skipped as well
+		if reason := p.documentExpired(docState.DocumentInformation.ExpiresAfter); reason != "" {
+			p.completeSkippedDocument(docState, reason, metricsReasonExpired)
+			return
+		}
+	}
+
+	moveDocumentState(log,
 		docState.DocumentInformation.DocumentID,
 		docState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfPending,
@@ -183,7 +447,7 @@ func (p *Processor) ExecutePendingDocument(docState *model.DocumentState) {
 
 	switch docState.DocumentType {
 	case model.SendCommand, model.SendCommandOffline:
-		err := p.sendCommandPool.Submit(log, docState.DocumentInformation.MessageID, func(cancelFlag task.CancelFlag) {
+		err := p.submitDocument(log, p.sendCommandPool, docState, func(cancelFlag task.CancelFlag) {
 			p.processSendCommandMessage(
 				p.context,
 				p.service,
@@ -200,7 +464,7 @@ func (p *Processor) ExecutePendingDocument(docState *model.DocumentState) {
 		}
 
 	case model.CancelCommand, model.CancelCommandOffline:
-		err := p.cancelCommandPool.Submit(log, docState.DocumentInformation.MessageID, func(cancelFlag task.CancelFlag) {
+		err := p.submitDocument(log, p.cancelCommandPool, docState, func(cancelFlag task.CancelFlag) {
 			p.processCancelCommandMessage(p.context, p.service, p.sendCommandPool, docState)
 		})
 		if err != nil {
@@ -241,16 +505,27 @@ func (p *Processor) processSendCommandMessage(context context.T,
 	docState *model.DocumentState) {
 
 	log := context.Log()
+	startTime := time.Now()
+	secureValues := documentSecureValues(docState)
+	sendResponse = withCorrelationID(p.withPluginCompleteEvents(docState.DocumentInformation.DocumentID,
+		withSecureParameterMasking(secureValues, sendResponse)))
+	defer p.aggregationPolicies.set(docState.DocumentInformation.MessageID, docState.DocumentInformation.AggregationPolicy)()
+	buildReply = withAggregationPolicy(buildReply, docState.DocumentInformation.AggregationPolicy, p.skippedPluginsFailDocument)
 
 	log.Debug("Running plugins...")
-	outputs := runPlugins(context, docState.DocumentInformation.MessageID, docState.InstancePluginsInformation, sendResponse, cancelFlag)
+	applyGlobalEnvironment(docState.InstancePluginsInformation, p.globalEnvironment)
+	p.emitExecutionStart(docState.DocumentInformation.DocumentID)
+	stopDeadline := p.startDocumentDeadline(log, docState.DocumentInformation.DocumentID, cancelFlag)
+	outputs := p.runPluginsWithProgress(context, runPlugins, cancelFlag, sendResponse, docState)
+	timedOut := stopDeadline()
+	p.checkOutputSize(log, docState.DocumentInformation.DocumentID, outputs)
 	pluginOutputContent, _ := jsonutil.Marshal(outputs)
-	log.Debugf("Plugin outputs %v", jsonutil.Indent(pluginOutputContent))
+	log.Debugf("Plugin outputs %v", maskSecureValues(jsonutil.Indent(pluginOutputContent), secureValues))
 
 	payloadDoc := buildReply("", outputs)
 
 	//update documentInfo in interim cmd state file
-	newCmdState := commandStateHelper.GetDocumentInterimState(log,
+	newCmdState := getDocumentInterimState(log,
 		docState.DocumentInformation.DocumentID,
 		docState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfCurrent)
@@ -261,14 +536,28 @@ func (p *Processor) processSendCommandMessage(context context.T,
 	newCmdState.DocumentInformation.DocumentTraceOutput = payloadDoc.DocumentTraceOutput
 	newCmdState.DocumentInformation.RuntimeStatus = payloadDoc.RuntimeStatus
 
+	if timedOut {
+		p.timeOutDocument(&newCmdState, outputs, buildReply)
+	}
+
+	if newCmdState.IsRebootRequired() {
+		recordRebootRequest(log, &newCmdState, outputs)
+		p.countDocumentReboot(log, &newCmdState, outputs, buildReply)
+	}
+
+	if isShutdownRequested(p.stopSignal) {
+		persistInterruptedDocument(log, newCmdState.DocumentInformation)
+		return
+	}
+
 	//persist final documentInfo.
-	commandStateHelper.PersistDocumentInfo(log,
+	persistDocumentInfo(log,
 		newCmdState.DocumentInformation,
 		newCmdState.DocumentInformation.DocumentID,
 		newCmdState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfCurrent)
 
-	log.Debug("Sending reply on message completion ", outputs)
+	log.Debug("Sending reply on message completion ", maskPluginResults(outputs, secureValues))
 	sendResponse(newCmdState.DocumentInformation.MessageID, "", outputs)
 
 	// Skip sending response when the document requires a reboot
@@ -280,15 +569,28 @@ func (p *Processor) processSendCommandMessage(context context.T,
 	//persist : commands execution in completed folder (terminal state folder)
 	log.Debugf("execution of %v is over. Moving interimState file from Current to Completed folder", newCmdState.DocumentInformation.MessageID)
 
-	commandStateHelper.MoveDocumentState(log,
+	moveDocumentState(log,
 		newCmdState.DocumentInformation.DocumentID,
 		newCmdState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfCurrent,
 		appconfig.DefaultLocationOfCompleted)
 
+	p.compactCompletedDocument(log, messagesOrchestrationRootDir, newCmdState)
+
+	p.getMetrics().RecordMessageProcessed(time.Since(startTime))
+	p.notifyDocumentCompleted(log, newCmdState.DocumentInformation, startTime)
+	p.auditDocumentCompleted(log, newCmdState, startTime)
+	p.emitDocumentComplete(newCmdState.DocumentInformation.DocumentID, newCmdState.DocumentInformation.DocumentStatus)
+	if status := newCmdState.DocumentInformation.DocumentStatus; status == contracts.ResultStatusFailed ||
+		status == contracts.ResultStatusTimedOut ||
+		status == contracts.ResultStatusCancelled {
+		p.getMetrics().RecordMessageFailed(string(status))
+	}
+
 	log.Debugf("Deleting message")
 
-	if !isUpdatePlugin(newCmdState) {
+	if !isMessageDeletionExternal(newCmdState) {
+		p.waitBeforeMessageDeletion(log)
 		if err := mdsService.DeleteMessage(log, newCmdState.DocumentInformation.MessageID); err != nil {
 			sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
 		}
@@ -302,18 +604,40 @@ func parseSendCommandMessage(context context.T, msg *ssmmds.Message, messagesOrc
 	commandID := getCommandID(*msg.MessageId)
 
 	log.Debug("Processing send command message ", *msg.MessageId)
-	log.Trace("Processing send command message ", jsonutil.Indent(*msg.Payload))
 
-	parsedMessage, err := parser.ParseMessageWithParams(log, *msg.Payload)
+	payload, err := parser.DecodePayload(*msg.Payload, context.AppConfig().Mds.MaxMessagePayloadBytes)
 	if err != nil {
-		return nil, err
+		return nil, &ErrMalformedPayload{Err: err}
 	}
 
+	parsedMessage, err := parser.ParseMessageWithParams(log, payload)
+	if err != nil {
+		return nil, &ErrMalformedPayload{Err: err}
+	}
+	// the payload is traced once parsed, so that the values of its secure parameters are known
+	secureValues := secureParameterValues(parsedMessage)
+	log.Trace("Processing send command message ", maskSecureValues(jsonutil.Indent(payload), secureValues))
+	if err = validateAggregationPolicy(parsedMessage.DocumentContent.AggregationPolicy); err != nil {
+		return nil, &ErrMalformedPayload{Err: err}
+	}
+	if err = validatePluginGraph(parsedMessage.DocumentContent.MainSteps); err != nil {
+		return nil, &ErrMalformedPayload{Err: err}
+	}
+	parsedMessage.DocumentContent.MainSteps = pluginExecutionOrder(parsedMessage.DocumentContent.MainSteps)
+
 	parsedMessageContent, _ := jsonutil.Marshal(parsedMessage)
-	log.Debug("ParsedMessage is ", jsonutil.Indent(parsedMessageContent))
+	log.Debug("ParsedMessage is ", maskSecureValues(jsonutil.Indent(parsedMessageContent), secureValues))
+
+	if err = validateOutputS3KeyPrefix(parsedMessage.OutputS3KeyPrefix); err != nil {
+		return nil, &ErrMalformedPayload{Err: err}
+	}
 
 	// adapt plugin configuration format from MDS to plugin expected format
-	s3KeyPrefix := path.Join(parsedMessage.OutputS3KeyPrefix, parsedMessage.CommandID, *msg.Destination)
+	s3KeyPrefix, err := buildS3KeyPrefix(context.AppConfig().Mds.S3KeyPrefixTemplate, parsedMessage.OutputS3KeyPrefix, parsedMessage.CommandID, *msg.Destination)
+	if err != nil {
+		log.Errorf("Error building the S3 key prefix. error: %v", err)
+		return nil, &ErrTransient{Err: err}
+	}
 
 	messageOrchestrationDirectory := filepath.Join(messagesOrchestrationRootDir, commandID)
 
@@ -325,22 +649,23 @@ func parseSendCommandMessage(context context.T, msg *ssmmds.Message, messagesOrc
 
 	var docStateContent string
 	if docStateContent, err = jsonutil.Marshal(docState); err != nil {
-		return nil, err
+		return nil, &ErrMalformedPayload{Err: err}
 	}
-	log.Debug("Document state is ", jsonutil.Indent(docStateContent))
+	log.Debug("Document state is ", maskSecureValues(jsonutil.Indent(docStateContent), secureValues))
 
 	// Check if it is a managed instance and its executing managed instance incompatible AWS SSM public document.
 	// A few public AWS SSM documents contain code which is not compatible when run on managed instances.
 	// isManagedInstanceIncompatibleAWSSSMDocument makes sure to find such documents at runtime and replace the incompatible code.
-	isMI, err := platform.IsManagedInstance()
+	isMI, err := isManagedInstance()
 	if err != nil {
 		log.Errorf("Error determining managed instance. error: %v", err)
+		return nil, &ErrTransient{Err: err}
 	}
 
-	if isMI && model.IsManagedInstanceIncompatibleAWSSSMDocument(docState.DocumentInformation.DocumentName) {
+	if isMI && isUnsupportedSSMDocument(docState.DocumentInformation.DocumentName) {
 		log.Debugf("Running incompatible AWS SSM Document %v on managed instance", docState.DocumentInformation.DocumentName)
 		if err = model.RemoveDependencyOnInstanceMetadata(context, &docState); err != nil {
-			return nil, err
+			return nil, &ErrMalformedPayload{Err: err}
 		}
 	}
 
@@ -354,11 +679,19 @@ func (p *Processor) processCancelCommandMessage(context context.T,
 	docState *model.DocumentState) {
 
 	log := context.Log()
+	startTime := time.Now()
 
 	log.Debugf("Canceling job with id %v...", docState.CancelInformation.CancelMessageID)
 
-	if found := sendCommandPool.Cancel(docState.CancelInformation.CancelMessageID); !found {
+	if !isCancelTargetOwned(docState.CancelInformation, docState.DocumentInformation.InstanceID) {
+		log.Errorf("Cancel target %v not found on this instance %v", docState.CancelInformation.CancelMessageID, docState.DocumentInformation.InstanceID)
+		p.getMetrics().RecordMessageFailed(metricsReasonCancelTargetNotOwned)
+		docState.CancelInformation.DebugInfo = fmt.Sprintf("Command %v target not found on this instance", docState.CancelInformation.CancelCommandID)
+		docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+	} else if found := sendCommandPool.Cancel(docState.CancelInformation.CancelMessageID); !found &&
+		!cancelPendingDocument(log, docState.CancelInformation.CancelCommandID, docState.DocumentInformation.InstanceID) {
 		log.Debugf("Job with id %v not found (possibly completed)", docState.CancelInformation.CancelMessageID)
+		p.getMetrics().RecordMessageFailed(metricsReasonCancelTargetNotFound)
 		docState.CancelInformation.DebugInfo = fmt.Sprintf("Command %v couldn't be cancelled", docState.CancelInformation.CancelCommandID)
 		docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
 	} else {
@@ -367,26 +700,92 @@ func (p *Processor) processCancelCommandMessage(context context.T,
 	}
 
 	//persist the final status of cancel-message in current folder
-	commandStateHelper.PersistData(log,
+	persistDocumentState(log,
 		docState.DocumentInformation.DocumentID,
 		docState.DocumentInformation.InstanceID,
-		appconfig.DefaultLocationOfCurrent, docState)
+		appconfig.DefaultLocationOfCurrent, *docState)
 
 	//persist : commands execution in completed folder (terminal state folder)
 	log.Debugf("Execution of %v is over. Moving interimState file from Current to Completed folder", docState.DocumentInformation.MessageID)
 
-	commandStateHelper.MoveDocumentState(log,
+	moveDocumentState(log,
 		docState.DocumentInformation.DocumentID,
 		docState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfCurrent,
 		appconfig.DefaultLocationOfCompleted)
 
+	p.getMetrics().RecordMessageProcessed(time.Since(startTime))
+	p.notifyDocumentCompleted(log, docState.DocumentInformation, startTime)
+	p.auditDocumentCompleted(log, *docState, startTime)
+	p.emitDocumentComplete(docState.DocumentInformation.DocumentID, docState.DocumentInformation.DocumentStatus)
+
 	log.Debugf("Deleting message")
 	if err := mdsService.DeleteMessage(log, docState.DocumentInformation.MessageID); err != nil {
 		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
 	}
 }
 
+// cancelTargetOwned returns true if the command to cancel was sent to this instance and its state is persisted here
+func cancelTargetOwned(cancelInfo model.CancelCommandInfo, instanceID string) bool {
+	// MdsMessageID is in the format of : aws.ssm.CommandId.InstanceId
+	if !strings.HasSuffix(cancelInfo.CancelMessageID, "."+instanceID) {
+		return false
+	}
+	for _, location := range []string{
+		appconfig.DefaultLocationOfPending,
+		appconfig.DefaultLocationOfCurrent,
+		appconfig.DefaultLocationOfCompleted} {
+		if isDocumentPersisted(cancelInfo.CancelCommandID, instanceID, location) {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelPendingDocument marks a document that is persisted in Pending but not yet submitted as cancelled,
+// it returns false if the document is not in Pending
+func cancelPendingDocument(log log.T, commandID, instanceID string) bool {
+	if !isDocumentPersisted(commandID, instanceID, appconfig.DefaultLocationOfPending) {
+		return false
+	}
+	log.Debugf("Command %v hasn't been submitted yet, marking it as cancelled", commandID)
+	docState := getDocumentInterimState(log, commandID, instanceID, appconfig.DefaultLocationOfPending)
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusCancelled
+	persistDocumentInfo(log, docState.DocumentInformation, commandID, instanceID, appconfig.DefaultLocationOfPending)
+	return true
+}
+
+// isPendingDocumentCancelled returns true if the document was cancelled while it was in Pending
+func isPendingDocumentCancelled(log log.T, docState *model.DocumentState) bool {
+	if !isDocumentPersisted(docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID, appconfig.DefaultLocationOfPending) {
+		return false
+	}
+	pendingState := getDocumentInterimState(log,
+		docState.DocumentInformation.DocumentID,
+		docState.DocumentInformation.InstanceID,
+		appconfig.DefaultLocationOfPending)
+	return pendingState.DocumentInformation.DocumentStatus == contracts.ResultStatusCancelled
+}
+
+// completeCancelledDocument reports a document cancelled before it was submitted and moves it to Completed
+func (p *Processor) completeCancelledDocument(docState *model.DocumentState) {
+	log := p.context.Log()
+	log.Debugf("Command %v was cancelled before execution, skipping it", docState.DocumentInformation.CommandID)
+
+	moveDocumentState(log,
+		docState.DocumentInformation.DocumentID,
+		docState.DocumentInformation.InstanceID,
+		appconfig.DefaultLocationOfPending,
+		appconfig.DefaultLocationOfCompleted)
+
+	p.sendDocLevelResponse(docState.DocumentInformation.MessageID, contracts.ResultStatusCancelled, "")
+	p.getMetrics().RecordMessageFailed(string(contracts.ResultStatusCancelled))
+
+	if err := p.service.DeleteMessage(log, docState.DocumentInformation.MessageID); err != nil {
+		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+	}
+}
+
 func parseCancelCommandMessage(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
 	log := context.Log()
 
@@ -395,7 +794,7 @@ func parseCancelCommandMessage(context context.T, msg *ssmmds.Message, messagesO
 	var parsedMessage messageContracts.CancelPayload
 	err := json.Unmarshal([]byte(*msg.Payload), &parsedMessage)
 	if err != nil {
-		return nil, err
+		return nil, &ErrMalformedPayload{Err: err}
 	}
 	log.Debugf("ParsedMessage is %v", parsedMessage)
 
@@ -404,9 +803,28 @@ func parseCancelCommandMessage(context context.T, msg *ssmmds.Message, messagesO
 	return &docState, nil
 }
 
-func isUpdatePlugin(pluginConfig model.DocumentState) bool {
+// externalMessageDeletionPlugins are the plugins that delete the message of their document themselves,
+// such as the update plugins that hand the document over to an external updater process
+var externalMessageDeletionPlugins = map[string]bool{
+	appconfig.PluginEC2ConfigUpdate:    true,
+	appconfig.PluginNameAwsAgentUpdate: true,
+}
+var externalMessageDeletionLock sync.RWMutex
+
+// RegisterExternalMessageDeletionPlugin registers a plugin that handles the deletion of the message of its document,
+// so that the processor doesn't delete the message or compact the orchestration directory when the document completes.
+func RegisterExternalMessageDeletionPlugin(name string) {
+	externalMessageDeletionLock.Lock()
+	defer externalMessageDeletionLock.Unlock()
+	externalMessageDeletionPlugins[name] = true
+}
+
+// isMessageDeletionExternal returns true if the document contains a plugin that handles the deletion of its message
+func isMessageDeletionExternal(pluginConfig model.DocumentState) bool {
+	externalMessageDeletionLock.RLock()
+	defer externalMessageDeletionLock.RUnlock()
 	for _, pluginState := range pluginConfig.InstancePluginsInformation {
-		if pluginState.Name == appconfig.PluginEC2ConfigUpdate || pluginState.Name == appconfig.PluginNameAwsAgentUpdate {
+		if externalMessageDeletionPlugins[pluginState.Name] {
 			return true
 		}
 	}
@@ -16,8 +16,6 @@
 package processor
 
 import (
-	"io/ioutil"
-	"os"
 	"sync"
 	"time"
 
@@ -25,9 +23,7 @@ import (
 	asocitscheduler "github.com/aws/amazon-ssm-agent/agent/association/scheduler"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
-	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/platform"
-	"github.com/aws/amazon-ssm-agent/agent/statemanager"
 	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 	"github.com/carlescere/scheduler"
@@ -58,6 +54,9 @@ func (p *Processor) Execute(context context.T) (err error) {
 		return
 	}
 
+	// documents half-moved between folders by a crash must be resolved before they are resumed
+	recoverInterruptedMoves(log, instanceID)
+
 	// InProgress documents must be resumed before Pending documents
 	// to avoid resuming same document twice in both Pending and InProgress
 	p.processInProgressDocuments(instanceID)
@@ -68,6 +67,18 @@ func (p *Processor) Execute(context context.T) (err error) {
 		context.Log().Errorf("unable to schedule message processor. %v", err)
 	}
 
+	log.Info("Starting orchestration directory cleanup")
+	if p.orchestrationCleanupJob, err = scheduler.Every(orchestrationCleanupFrequencyHours).Hours().Run(p.cleanupOrchestrationDirectories); err != nil {
+		context.Log().Errorf("unable to schedule orchestration directory cleanup. %v", err)
+	}
+
+	if p.unsupportedDocumentsURL != "" {
+		log.Infof("Starting the refresh of the unsupported documents from %v", p.unsupportedDocumentsURL)
+		if p.unsupportedDocumentsRefreshJob, err = scheduler.Every(p.unsupportedDocumentsRefresh).Minutes().Run(p.refreshUnsupportedDocuments); err != nil {
+			context.Log().Errorf("unable to schedule the refresh of the unsupported documents. %v", err)
+		}
+	}
+
 	if p.pollAssociations {
 		associationFrequenceMinutes := context.AppConfig().Ssm.AssociationFrequencyMinutes
 		log.Info("Starting association polling")
@@ -88,29 +99,29 @@ func (p *Processor) Execute(context context.T) (err error) {
 // ProcessPendingDocuments processes pending documents that have been persisted in pending folder
 func (p *Processor) processPendingDocuments(instanceID string) {
 	log := p.context.Log()
-	files := []os.FileInfo{}
-	var err error
 
-	//process older documents from PENDING folder
-	pendingDocsLocation := statemanager.DocumentStateDir(instanceID, appconfig.DefaultLocationOfPending)
-
-	if isDirectoryEmpty, _ := fileutil.IsDirEmpty(pendingDocsLocation); isDirectoryEmpty {
-		log.Debugf("No documents to process from %v", pendingDocsLocation)
+	//get all pending messages
+	documentIDs, err := listDocuments(log, instanceID, appconfig.DefaultLocationOfPending)
+	if err != nil {
+		log.Errorf("skipping reading pending documents. unexpected error encountered - %v", err)
 		return
 	}
-
-	//get all pending messages
-	if files, err = fileutil.ReadDir(pendingDocsLocation); err != nil {
-		log.Errorf("skipping reading pending documents from %v. unexpected error encountered - %v", pendingDocsLocation, err)
+	if len(documentIDs) == 0 {
+		log.Debugf("No documents to process from %v", appconfig.DefaultLocationOfPending)
 		return
 	}
 
-	//iterate through all pending messages
-	for _, f := range files {
-		log.Debugf("Processing an older document - %v", f.Name())
+	//inspect the state of all pending messages, then submit them by priority
+	docStates := make([]model.DocumentState, 0, len(documentIDs))
+	for _, documentID := range documentIDs {
+		docStates = append(docStates, getDocumentInterimState(log, documentID, instanceID, appconfig.DefaultLocationOfPending))
+	}
+	sortPendingDocuments(docStates)
 
-		//inspect document state
-		docState := statemanager.GetDocumentInterimState(log, f.Name(), instanceID, appconfig.DefaultLocationOfPending)
+	//iterate through all pending messages
+	for i := range docStates {
+		docState := docStates[i]
+		log.Debugf("Processing an older document - %v", docState.DocumentInformation.DocumentID)
 
 		if !p.isSupportedDocumentType(docState.DocumentType) && (!docState.IsAssociation() || !p.pollAssociations) {
 			continue // This is a document for a different processor to handle
@@ -131,34 +142,34 @@ func (p *Processor) processPendingDocuments(instanceID string) {
 func (p *Processor) processInProgressDocuments(instanceID string) {
 	log := p.context.Log()
 	config := p.context.AppConfig()
-	var err error
-
-	pendingDocsLocation := statemanager.DocumentStateDir(instanceID, appconfig.DefaultLocationOfCurrent)
 
-	if isDirectoryEmpty, _ := fileutil.IsDirEmpty(pendingDocsLocation); isDirectoryEmpty {
-		log.Debugf("no older document to process from %v", pendingDocsLocation)
+	documentIDs, err := listDocuments(log, instanceID, appconfig.DefaultLocationOfCurrent)
+	if err != nil {
+		log.Errorf("skipping reading inprogress document. unexpected error encountered - %v", err)
 		return
-
 	}
-
-	files := []os.FileInfo{}
-	if files, err = ioutil.ReadDir(pendingDocsLocation); err != nil {
-		log.Errorf("skipping reading inprogress document from %v. unexpected error encountered - %v", pendingDocsLocation, err)
+	if len(documentIDs) == 0 {
+		log.Debugf("no older document to process from %v", appconfig.DefaultLocationOfCurrent)
 		return
 	}
 
 	//iterate through all InProgress docs
-	for _, f := range files {
-		log.Debugf("processing previously unexecuted document - %v", f.Name())
+	for _, documentID := range documentIDs {
+		log.Debugf("processing previously unexecuted document - %v", documentID)
 
 		//inspect document state
-		docState := statemanager.GetDocumentInterimState(log, f.Name(), instanceID, appconfig.DefaultLocationOfCurrent)
+		docState := getDocumentInterimState(log, documentID, instanceID, appconfig.DefaultLocationOfCurrent)
 
 		if !p.isSupportedDocumentType(docState.DocumentType) && (!docState.IsAssociation() || !p.pollAssociations) {
 			log.Debugf("Skipping document %v type %v isaccoc %v and our pollAssociations is %v", docState.DocumentInformation.DocumentID, docState.DocumentType, docState.IsAssociation(), p.pollAssociations)
 			continue // This is a document for a different processor to handle
 		}
 
+		if isDocumentTerminal(docState) {
+			p.completeTerminalDocument(log, docState)
+			continue
+		}
+
 		retryLimit := config.Mds.CommandRetryLimit
 		if docState.IsAssociation() {
 			retryLimit = config.Ssm.AssociationRetryLimit
@@ -173,15 +184,21 @@ func (p *Processor) processInProgressDocuments(instanceID string) {
 		// increment the command run count
 		docState.DocumentInformation.RunCount++
 		// Update reboot status
+		rebooted := false
 		for index, plugin := range docState.InstancePluginsInformation {
 			if plugin.HasExecuted && plugin.Result.Status == contracts.ResultStatusSuccessAndReboot {
 				log.Debugf("plugin %v has completed a reboot. Setting status to InProgress to resume the work.", plugin.Name)
 				plugin.Result.Status = contracts.ResultStatusInProgress
 				docState.InstancePluginsInformation[index] = plugin
+				rebooted = true
 			}
 		}
+		if rebooted {
+			log.Infof("resuming document %v after the reboot requested by plugin %v",
+				docState.DocumentInformation.DocumentID, docState.DocumentInformation.RebootRequestedBy)
+		}
 
-		statemanager.PersistData(log, docState.DocumentInformation.DocumentID, instanceID, appconfig.DefaultLocationOfCurrent, docState)
+		persistDocumentState(log, docState.DocumentInformation.DocumentID, instanceID, appconfig.DefaultLocationOfCurrent, docState)
 
 		if docState.IsAssociation() && p.pollAssociations {
 			log.Debugf("processing in-progress association document: %v", docState.DocumentInformation.DocumentID)
@@ -192,18 +209,8 @@ func (p *Processor) processInProgressDocuments(instanceID string) {
 				log.Errorf("Association failed to resume previously unexecuted documents, %v", err)
 			}
 		} else if p.isSupportedDocumentType(docState.DocumentType) {
-			log.Debugf("processor %v processing in-progress document %v", p.name, docState.DocumentInformation.DocumentID)
 			//Submit the work to Job Pool so that we don't block for processing of new messages
-			err := p.sendCommandPool.Submit(log, docState.DocumentInformation.MessageID, func(cancelFlag task.CancelFlag) {
-				p.runCmdsUsingCmdState(p.context.With("[messageID="+docState.DocumentInformation.MessageID+"]"),
-					p.service,
-					p.pluginRunner,
-					cancelFlag,
-					p.buildReply,
-					p.sendResponse,
-					docState)
-			})
-			if err != nil {
+			if err = p.resumeDocument(log, &docState); err != nil {
 				log.Error("SendCommand failed for previously unexecuted commands", err)
 				break
 			}
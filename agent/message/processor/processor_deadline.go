@@ -0,0 +1,76 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_deadline contains the limit on the wall-clock time a document can run for
+package processor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+)
+
+// startDocumentDeadline cancels the document through its cancel flag once it has run for longer than maxDocumentRuntime.
+// The returned function stops the deadline and returns true if it expired. Without a maximum runtime the document never expires.
+func (p *Processor) startDocumentDeadline(log log.T, documentID string, cancelFlag task.CancelFlag) (stop func() bool) {
+	if p.maxDocumentRuntime <= 0 {
+		return func() bool { return false }
+	}
+	timer := time.AfterFunc(p.maxDocumentRuntime, func() {
+		log.Errorf("document %v ran for more than %v, cancelling it", documentID, p.maxDocumentRuntime)
+		cancelFlag.Set(task.Canceled)
+	})
+	return func() bool {
+		return !timer.Stop()
+	}
+}
+
+// timeOutDocument marks the plugins interrupted by the deadline as timed out. The results of the plugins that completed
+// before the deadline are kept, and the document status is aggregated from the results like the reply's.
+func (p *Processor) timeOutDocument(docState *model.DocumentState,
+	outputs map[string]*contracts.PluginResult,
+	buildReply replyBuilder) {
+
+	timeoutErr := fmt.Errorf("document timed out after %v", p.maxDocumentRuntime)
+	for _, output := range outputs {
+		if isInterruptedByDeadline(output.Status) {
+			output.Status = contracts.ResultStatusTimedOut
+			output.Code = 1
+			output.Error = timeoutErr
+			if output.Output == nil {
+				output.Output = timeoutErr.Error()
+			}
+		}
+	}
+
+	payloadDoc := buildReply("", outputs)
+	docState.DocumentInformation.AdditionalInfo = payloadDoc.AdditionalInfo
+	docState.DocumentInformation.DocumentStatus = payloadDoc.DocumentStatus
+	docState.DocumentInformation.DocumentTraceOutput = timeoutErr.Error()
+	docState.DocumentInformation.RuntimeStatus = payloadDoc.RuntimeStatus
+}
+
+// isInterruptedByDeadline returns true for the status of a plugin that was still running or had not run yet when the
+// document was cancelled by the deadline. A plugin without a status never started.
+func isInterruptedByDeadline(status contracts.ResultStatus) bool {
+	switch status {
+	case "", contracts.ResultStatusNotStarted, contracts.ResultStatusInProgress, contracts.ResultStatusCancelled:
+		return true
+	}
+	return false
+}
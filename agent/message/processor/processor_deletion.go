@@ -0,0 +1,34 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_deletion contains the wait before the message of a completed document is deleted
+package processor
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// waitBeforeMessageDeletion gives MDS the time to reflect the reply of a completed document before its message is
+// deleted. The wait is cut short when the agent is shutting down.
+func (p *Processor) waitBeforeMessageDeletion(log log.T) {
+	if p.replyToDeleteDelay <= 0 {
+		return
+	}
+	log.Debugf("waiting %v before deleting the message", p.replyToDeleteDelay)
+	select {
+	case <-p.getClock().After(p.replyToDeleteDelay):
+	case <-p.stopSignal:
+		log.Debugf("agent is shutting down, deleting the message without waiting")
+	}
+}
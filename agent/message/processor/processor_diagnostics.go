@@ -0,0 +1,101 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_diagnostics contains the snapshot of the persisted document states attached to support cases
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+)
+
+// redactedValue replaces the values of the sensitive fields in the state dump
+const redactedValue = "<redacted>"
+
+// sensitiveFieldPattern matches the names of the fields whose values are redacted from the state dump
+var sensitiveFieldPattern = regexp.MustCompile(`(?i)(password|secret|token|credential|privatekey|accesskey|securestring)`)
+
+// dumpedDocumentState is a document state of the dump, annotated with the folder it was read from
+type dumpedDocumentState struct {
+	Folder        string      `json:"folder"`
+	DocumentID    string      `json:"documentId"`
+	DocumentState interface{} `json:"documentState"`
+}
+
+// DumpState writes the states of the pending, current and completed documents to w as a json array, with the values of
+// the sensitive fields redacted and the secure parameter values of the documents masked. The persisted states are only read.
+func (p *Processor) DumpState(w io.Writer) error {
+	log := p.context.Log()
+	instanceID := p.config.InstanceID
+
+	dump := make([]dumpedDocumentState, 0)
+	for _, folder := range []string{appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted} {
+		documentIDs, err := listDocuments(log, instanceID, folder)
+		if err != nil {
+			return fmt.Errorf("failed to list the documents of %v: %v", folder, err)
+		}
+		for _, documentID := range documentIDs {
+			docState := getDocumentInterimState(log, documentID, instanceID, folder)
+			redacted, err := redactSensitiveFields(docState, documentSecureValues(&docState))
+			if err != nil {
+				return fmt.Errorf("failed to redact the state of document %v: %v", documentID, err)
+			}
+			dump = append(dump, dumpedDocumentState{Folder: folder, DocumentID: documentID, DocumentState: redacted})
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(dump)
+}
+
+// redactSensitiveFields returns the json tree of object with the values of the sensitive fields replaced
+// and the secure values masked in its strings
+func redactSensitiveFields(object interface{}, secureValues []string) (redacted interface{}, err error) {
+	content, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(content, &redacted); err != nil {
+		return nil, err
+	}
+	return redactValue(redacted, secureValues), nil
+}
+
+// redactValue replaces the values of the sensitive fields found anywhere in the json tree value
+// and masks the secure values in its strings
+func redactValue(value interface{}, secureValues []string) interface{} {
+	switch typed := value.(type) {
+	case string:
+		return maskSecureValues(typed, secureValues)
+	case map[string]interface{}:
+		for key, fieldValue := range typed {
+			if sensitiveFieldPattern.MatchString(key) && fieldValue != nil {
+				typed[key] = redactedValue
+			} else {
+				typed[key] = redactValue(fieldValue, secureValues)
+			}
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = redactValue(item, secureValues)
+		}
+	}
+	return value
+}
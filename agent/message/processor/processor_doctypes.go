@@ -0,0 +1,74 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_doctypes contains the restriction of the processing to some document types
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// documentTypeNotProcessed returns why the agent doesn't process the documents of a type, empty if it processes them.
+// All the documents are processed when no document type is configured.
+func (p *Processor) documentTypeNotProcessed(documentType model.DocumentType) (reason string) {
+	if len(p.processDocumentTypes) == 0 {
+		return ""
+	}
+	var configType string
+	switch documentType {
+	case model.SendCommand, model.SendCommandOffline:
+		configType = appconfig.DocumentTypeSendCommand
+	case model.CancelCommand, model.CancelCommandOffline:
+		configType = appconfig.DocumentTypeCancelCommand
+	default:
+		return ""
+	}
+	for _, processed := range p.processDocumentTypes {
+		if processed == configType {
+			return ""
+		}
+	}
+	return fmt.Sprintf("skipped, the agent is configured to only process the documents of types %v",
+		strings.Join(p.processDocumentTypes, ", "))
+}
+
+// completeSkippedCancelCommand completes a pending cancel command as failed without cancelling its target. Like a
+// cancel command that ran, it records why in its cancel information and sends no document reply.
+func (p *Processor) completeSkippedCancelCommand(docState *model.DocumentState, reason string, metricsReason string) {
+	log := p.context.Log()
+	log.Infof("Cancel of command %v %v", docState.CancelInformation.CancelCommandID, reason)
+
+	docState.CancelInformation.DebugInfo = fmt.Sprintf("Command %v %v", docState.CancelInformation.CancelCommandID, reason)
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+	persistDocumentState(log,
+		docState.DocumentInformation.DocumentID,
+		docState.DocumentInformation.InstanceID,
+		appconfig.DefaultLocationOfPending, *docState)
+	moveDocumentState(log,
+		docState.DocumentInformation.DocumentID,
+		docState.DocumentInformation.InstanceID,
+		appconfig.DefaultLocationOfPending,
+		appconfig.DefaultLocationOfCompleted)
+
+	p.getMetrics().RecordMessageFailed(metricsReason)
+	if err := p.service.DeleteMessage(log, docState.DocumentInformation.MessageID); err != nil {
+		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_environment contains the environment variables added to the configuration of every plugin
+package processor
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// applyGlobalEnvironment adds the global environment variables to the configuration of each plugin,
+// keeping the values the document already set for the plugin.
+func applyGlobalEnvironment(plugins []model.PluginState, environment map[string]string) {
+	if len(environment) == 0 {
+		return
+	}
+	for i := range plugins {
+		pluginEnvironment := make(map[string]string, len(environment)+len(plugins[i].Configuration.Environment))
+		for name, value := range environment {
+			pluginEnvironment[name] = value
+		}
+		for name, value := range plugins[i].Configuration.Environment {
+			pluginEnvironment[name] = value
+		}
+		plugins[i].Configuration.Environment = pluginEnvironment
+	}
+}
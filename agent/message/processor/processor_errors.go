@@ -0,0 +1,44 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_errors contains the errors returned while parsing messages
+package processor
+
+// ErrMalformedPayload is returned when the payload of a message can never be processed,
+// the message is failed instead of being retried.
+type ErrMalformedPayload struct {
+	Err error
+}
+
+// Error returns the message of the underlying error
+func (e *ErrMalformedPayload) Error() string {
+	return "malformed payload: " + e.Err.Error()
+}
+
+// ErrTransient is returned when a message couldn't be processed because of a temporary failure,
+// the message is left unacknowledged so that it is delivered again.
+type ErrTransient struct {
+	Err error
+}
+
+// Error returns the message of the underlying error
+func (e *ErrTransient) Error() string {
+	return "transient failure: " + e.Err.Error()
+}
+
+// isTransientError returns true if processing the message may succeed when it is delivered again
+func isTransientError(err error) bool {
+	_, ok := err.(*ErrTransient)
+	return ok
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_expiration contains the check of the expiration of the documents, tolerant of a skewed instance clock
+package processor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/times"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+)
+
+// serverClock estimates the clock of MDS from the creation date of the messages it delivers
+type serverClock struct {
+	lock sync.Mutex
+	// skew is how far the instance clock is behind the clock of MDS
+	skew time.Duration
+}
+
+// newServerClock returns the clock of MDS if the expiration of the documents is evaluated with it, nil otherwise
+func newServerClock(enabled bool) *serverClock {
+	if !enabled {
+		return nil
+	}
+	return &serverClock{}
+}
+
+// observe updates the skew from a message created at createdDate and received at receivedAt, and returns it.
+// A message can't be received before it was created, so a creation date ahead of the instance clock shows the instance
+// clock is behind by at least the difference. A creation date in the past can be a delivery delay as much as an
+// instance clock ahead, so it resets the skew and a clock ahead is left to the tolerance of the expiration.
+func (c *serverClock) observe(createdDate time.Time, receivedAt time.Time) time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.skew = 0
+	if createdDate.After(receivedAt) {
+		c.skew = createdDate.Sub(receivedAt)
+	}
+	return c.skew
+}
+
+// now returns the time of MDS for the instance time localNow
+func (c *serverClock) now(localNow time.Time) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return localNow.Add(c.skew)
+}
+
+// observeMessageClock estimates the skew of the instance clock from a message just received, when the expiration of
+// the documents is evaluated with the time of MDS. A skew beyond the tolerance is logged as a warning. A message
+// without a creation date tells nothing about the clock and is skipped.
+func (p *Processor) observeMessageClock(log log.T, msg *ssmmds.Message) {
+	if p.serverClock == nil || msg.CreatedDate == nil {
+		return
+	}
+	skew := p.serverClock.observe(times.ParseIso8601UTC(*msg.CreatedDate), p.clock.Now())
+	if skew > p.clockSkewTolerance {
+		log.Warnf("the instance clock is at least %v behind the clock of the service", skew)
+	}
+}
+
+// documentExpired returns why a document expiring at expiresAfter is no longer started, empty if it can start.
+// The document is still started for clockSkewTolerance after its expiration.
+func (p *Processor) documentExpired(expiresAfter string) (reason string) {
+	if expiresAfter == "" {
+		return ""
+	}
+	expiration, err := time.Parse(time.RFC3339, expiresAfter)
+	if err != nil {
+		return fmt.Sprintf("skipped, the expiration of the document %v is not valid: %v", expiresAfter, err)
+	}
+	now := p.clock.Now()
+	if p.serverClock != nil {
+		now = p.serverClock.now(now)
+	}
+	if now.After(expiration.Add(p.clockSkewTolerance)) {
+		return fmt.Sprintf("skipped, the document expired at %v", expiresAfter)
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_graph contains the validation and ordering of the steps of a document by their dependencies
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+)
+
+// validatePluginGraph returns an error if a step depends on a step that doesn't exist, on a step of its own execution
+// group, or on itself through a cycle of dependencies. Documents without dependencies are always valid.
+func validatePluginGraph(plugins []*contracts.InstancePluginConfig) error {
+	steps := make(map[string]*contracts.InstancePluginConfig)
+	hasDependencies := false
+	for _, plugin := range plugins {
+		hasDependencies = hasDependencies || len(plugin.DependsOn) > 0
+	}
+	if !hasDependencies {
+		return nil
+	}
+	for _, plugin := range plugins {
+		if _, found := steps[plugin.Name]; found {
+			return fmt.Errorf("step name %v is used more than once, dependencies can't refer to it", plugin.Name)
+		}
+		steps[plugin.Name] = plugin
+	}
+	for _, plugin := range plugins {
+		for _, dependency := range plugin.DependsOn {
+			dependencyStep, found := steps[dependency]
+			if !found {
+				return fmt.Errorf("step %v depends on step %v which doesn't exist", plugin.Name, dependency)
+			}
+			if plugin.ExecutionGroup != "" && dependencyStep.ExecutionGroup == plugin.ExecutionGroup && dependency != plugin.Name {
+				return fmt.Errorf("step %v depends on step %v of its own execution group %v", plugin.Name, dependency, plugin.ExecutionGroup)
+			}
+		}
+	}
+
+	// depth first search, a step found again while its dependencies are visited closes a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, step := range path {
+				if step == name {
+					return fmt.Errorf("steps depend on each other in a cycle: %v", strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dependency := range steps[name].DependsOn {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, plugin := range plugins {
+		if err := visit(plugin.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pluginExecutionOrder returns the steps in the order they run: the declared order, except that a step is moved
+// after the steps it depends on. The graph of the steps must have been validated by validatePluginGraph.
+func pluginExecutionOrder(plugins []*contracts.InstancePluginConfig) []*contracts.InstancePluginConfig {
+	ordered := make([]*contracts.InstancePluginConfig, 0, len(plugins))
+	done := make(map[string]bool)
+	emitted := make([]bool, len(plugins))
+	for len(ordered) < len(plugins) {
+		next := -1
+		for i, plugin := range plugins {
+			if !emitted[i] && dependenciesDone(plugin, done) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			// unreachable for a validated graph, the remaining steps keep their declared order
+			for i, plugin := range plugins {
+				if !emitted[i] {
+					ordered = append(ordered, plugin)
+				}
+			}
+			break
+		}
+		// the search starts over from the first step, so that the declared order is kept whenever possible
+		ordered = append(ordered, plugins[next])
+		emitted[next] = true
+		done[plugins[next].Name] = true
+	}
+	return ordered
+}
+
+// dependenciesDone returns true if all the steps the step depends on are done
+func dependenciesDone(plugin *contracts.InstancePluginConfig, done map[string]bool) bool {
+	for _, dependency := range plugin.DependsOn {
+		if !done[dependency] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_health contains the self-check a supervisor uses to tell whether the processor is draining messages
+package processor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/message/service"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+)
+
+// ProcessorHealth is a snapshot of the state of the processor.
+type ProcessorHealth struct {
+	// LastPollTime is the time of the last successful GetMessages call, zero if there was none
+	LastPollTime time.Time
+	// InFlightDocuments is the number of documents submitted to the pools that haven't completed
+	InFlightDocuments int
+	// SendCommandPoolSaturation and CancelCommandPoolSaturation are the fractions of the workers of each pool running a document
+	SendCommandPoolSaturation   float64
+	CancelCommandPoolSaturation float64
+	// ErrorCount is the number of polls and messages that failed
+	ErrorCount int
+	// LastError is the most recent of these failures, nil if there was none
+	LastError error
+	// MdsCircuitState is the state of the circuit breaker of the MDS calls, empty if there is none
+	MdsCircuitState service.CircuitState
+}
+
+// processorHealth holds the counters the health status is built from.
+type processorHealth struct {
+	lock         sync.Mutex
+	lastPollTime time.Time
+	errorCount   int
+	lastError    error
+}
+
+// recordPoll records a successful GetMessages call
+func (p *Processor) recordPoll() {
+	p.health.lock.Lock()
+	defer p.health.lock.Unlock()
+	p.health.lastPollTime = time.Now()
+}
+
+// recordError records a failure to poll or to process a message
+func (p *Processor) recordError(err error) {
+	p.health.lock.Lock()
+	defer p.health.lock.Unlock()
+	p.health.errorCount++
+	p.health.lastError = err
+}
+
+// HealthStatus returns the current health of the processor. It is safe to call from any goroutine.
+func (p *Processor) HealthStatus() ProcessorHealth {
+	p.health.lock.Lock()
+	health := ProcessorHealth{
+		LastPollTime: p.health.lastPollTime,
+		ErrorCount:   p.health.errorCount,
+		LastError:    p.health.lastError,
+	}
+	p.health.lock.Unlock()
+
+	if breaker, ok := p.service.(*service.CircuitBreakerService); ok {
+		health.MdsCircuitState = breaker.State()
+	}
+
+	p.inFlightLock.Lock()
+	defer p.inFlightLock.Unlock()
+	health.InFlightDocuments = len(p.inFlightDocuments)
+	health.SendCommandPoolSaturation = p.poolSaturation(p.sendCommandPool, p.sendCommandWorkersLimit)
+	health.CancelCommandPoolSaturation = p.poolSaturation(p.cancelCommandPool, p.cancelCommandWorkersLimit)
+	return health
+}
+
+// poolSaturation returns the fraction of the workers of the pool running a document, callers must hold inFlightLock
+func (p *Processor) poolSaturation(pool task.Pool, workersLimit int) float64 {
+	if pool == nil || workersLimit <= 0 {
+		return 0
+	}
+	running := 0
+	for _, doc := range p.inFlightDocuments {
+		if doc.started && doc.pool == pool {
+			running++
+		}
+	}
+	return float64(running) / float64(workersLimit)
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_jitter contains the random delay of the InProgress replies that spreads the replies of a fleet-wide command
+package processor
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// randomJitter returns a random delay between zero and bound included
+var randomJitter = func(bound time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(bound) + 1))
+}
+
+// sendInProgressReply reports the document of an acknowledged message as in progress. With inProgressReplyJitter the
+// reply is sent in the background after a random delay bounded by it, so that neither the polling nor the document
+// wait for it. The wait is cut short when the agent is shutting down, and the reply is dropped if the document
+// completed in the meantime so that it doesn't overwrite the final status.
+func (p *Processor) sendInProgressReply(log log.T, messageID, documentID string) {
+	if p.inProgressReplyJitter <= 0 {
+		p.sendDocLevelResponse(messageID, contracts.ResultStatusInProgress, "")
+		return
+	}
+	delay := randomJitter(p.inProgressReplyJitter)
+	log.Debugf("waiting %v before replying that the document is in progress", delay)
+	after := p.getClock().After(delay)
+	go func() {
+		select {
+		case <-after:
+		case <-p.stopSignal:
+		}
+		if !isDocumentInProgress(documentID, p.config.InstanceID) {
+			log.Debugf("document %v completed before its InProgress reply, not sending it", documentID)
+			return
+		}
+		p.sendDocLevelResponse(messageID, contracts.ResultStatusInProgress, "")
+	}()
+}
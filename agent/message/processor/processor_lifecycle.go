@@ -0,0 +1,101 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_lifecycle contains the events the processor emits as a document goes through its lifecycle
+package processor
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
+)
+
+// LifecycleListener is called as the messages and their documents go through the processor, in this order:
+// OnReceived, OnAcked, OnExecutionStart, OnPluginComplete for each plugin and OnDocumentComplete.
+// Listeners are called synchronously from the worker goroutines, so implementations must be quick and safe for concurrent use.
+type LifecycleListener interface {
+	// OnReceived is called when a valid message is received from MDS
+	OnReceived(messageID string)
+	// OnAcked is called once the message is acknowledged
+	OnAcked(messageID string)
+	// OnExecutionStart is called before the plugins of the document start running
+	OnExecutionStart(documentID string)
+	// OnPluginComplete is called when a plugin of the document returns
+	OnPluginComplete(documentID string, pluginName string, status contracts.ResultStatus)
+	// OnDocumentComplete is called when the document reaches a terminal state
+	OnDocumentComplete(documentID string, status contracts.ResultStatus)
+}
+
+// AddLifecycleListener registers a listener of the lifecycle events. Listeners must be added before the processor is started.
+func (p *Processor) AddLifecycleListener(listener LifecycleListener) {
+	p.lifecycleListeners = append(p.lifecycleListeners, listener)
+}
+
+// emitReceived calls OnReceived on the registered listeners
+func (p *Processor) emitReceived(messageID string) {
+	for _, listener := range p.lifecycleListeners {
+		listener.OnReceived(messageID)
+	}
+}
+
+// emitAcked calls OnAcked on the registered listeners
+func (p *Processor) emitAcked(messageID string) {
+	for _, listener := range p.lifecycleListeners {
+		listener.OnAcked(messageID)
+	}
+}
+
+// emitExecutionStart calls OnExecutionStart on the registered listeners
+func (p *Processor) emitExecutionStart(documentID string) {
+	for _, listener := range p.lifecycleListeners {
+		listener.OnExecutionStart(documentID)
+	}
+}
+
+// emitDocumentComplete calls OnDocumentComplete on the registered listeners
+func (p *Processor) emitDocumentComplete(documentID string, status contracts.ResultStatus) {
+	for _, listener := range p.lifecycleListeners {
+		listener.OnDocumentComplete(documentID, status)
+	}
+}
+
+// withPluginCompleteEvents returns a SendResponse that calls OnPluginComplete on the registered listeners for the replies
+// sent on the completion of a plugin, before sending them.
+func (p *Processor) withPluginCompleteEvents(documentID string, sendResponse runpluginutil.SendResponse) runpluginutil.SendResponse {
+	if len(p.lifecycleListeners) == 0 {
+		return sendResponse
+	}
+	return func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		// the document level replies are sent without a plugin
+		if pluginID != "" {
+			status := pluginCompleteStatus(pluginID, results)
+			for _, listener := range p.lifecycleListeners {
+				listener.OnPluginComplete(documentID, pluginID, status)
+			}
+		}
+		sendResponse(messageID, pluginID, results)
+	}
+}
+
+// pluginCompleteStatus returns the status of the named plugin in the results, which are keyed by plugin id
+func pluginCompleteStatus(pluginName string, results map[string]*contracts.PluginResult) contracts.ResultStatus {
+	if result, ok := results[pluginName]; ok && result != nil {
+		return result.Status
+	}
+	for _, result := range results {
+		if result != nil && result.PluginName == pluginName {
+			return result.Status
+		}
+	}
+	return ""
+}
@@ -0,0 +1,231 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_masking contains the masking of the secure parameters of a document in the logged outputs and the replies
+package processor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	messageContracts "github.com/aws/amazon-ssm-agent/agent/message/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/parameterstore"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// maskedValue replaces the values of the secure parameters
+const maskedValue = "****"
+
+// secureParameterValues returns the values of the parameters the document declares as SecureString,
+// longest first so that a value containing another one is masked as a whole
+func secureParameterValues(payload messageContracts.SendCommandPayload) (values []string) {
+	appendValue := func(value interface{}) {
+		if s, ok := value.(string); ok && s != "" {
+			values = append(values, s)
+		}
+	}
+	for name, declaration := range payload.DocumentContent.Parameters {
+		if declaration == nil || declaration.ParamType != parameterstore.ParamTypeSecureString {
+			continue
+		}
+		value, found := payload.Parameters[name]
+		if !found {
+			value = declaration.DefaultVal
+		}
+		if list, ok := value.([]interface{}); ok {
+			for _, item := range list {
+				appendValue(item)
+			}
+			continue
+		}
+		appendValue(value)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+// secureValueHashes returns the salted hashes persisted in place of the secure values
+func secureValueHashes(values []string) (hashes []model.SecureValueHash) {
+	for _, value := range values {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			// without a salt the value cannot be recovered safely, it is then only masked until the agent restarts
+			continue
+		}
+		saltValue := hex.EncodeToString(salt)
+		hashes = append(hashes, model.SecureValueHash{Salt: saltValue, Hash: hashSecureValue(saltValue, value), Length: len(value)})
+	}
+	return hashes
+}
+
+// hashSecureValue returns the hex encoded SHA-256 hash of the salted value
+func hashSecureValue(salt, value string) string {
+	sum := sha256.Sum256([]byte(salt + value))
+	return hex.EncodeToString(sum[:])
+}
+
+// documentSecureValues returns the secure values of a document, they are recovered from the configurations
+// of its plugins when the document was resumed from its state file
+func documentSecureValues(docState *model.DocumentState) []string {
+	docInfo := &docState.DocumentInformation
+	if len(docInfo.SecureParameterValues) == 0 && len(docInfo.SecureParameterHashes) > 0 {
+		docInfo.SecureParameterValues = recoverSecureValues(docInfo.SecureParameterHashes, docState.InstancePluginsInformation)
+	}
+	return docInfo.SecureParameterValues
+}
+
+// recoverSecureValues finds the substrings of the plugin configurations matching the hashes,
+// longest first like secureParameterValues
+func recoverSecureValues(hashes []model.SecureValueHash, plugins []model.PluginState) (values []string) {
+	var contents []string
+	for _, plugin := range plugins {
+		contents = append(contents, configurationStrings(plugin.Configuration.Properties)...)
+		contents = append(contents, configurationStrings(plugin.Configuration.Settings)...)
+	}
+	for _, hash := range hashes {
+		if value, found := findHashedValue(hash, contents); found {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+// findHashedValue returns the substring of contents matching the hash
+func findHashedValue(hash model.SecureValueHash, contents []string) (string, bool) {
+	if hash.Length <= 0 {
+		return "", false
+	}
+	for _, content := range contents {
+		for i := 0; i+hash.Length <= len(content); i++ {
+			if candidate := content[i : i+hash.Length]; hashSecureValue(hash.Salt, candidate) == hash.Hash {
+				return candidate, true
+			}
+		}
+	}
+	return "", false
+}
+
+// configurationStrings returns the strings of a plugin configuration, which is decoded from JSON
+func configurationStrings(configuration interface{}) (contents []string) {
+	switch value := configuration.(type) {
+	case nil:
+	case string:
+		contents = append(contents, value)
+	case []interface{}:
+		for _, item := range value {
+			contents = append(contents, configurationStrings(item)...)
+		}
+	case map[string]interface{}:
+		for _, item := range value {
+			contents = append(contents, configurationStrings(item)...)
+		}
+	case bool, float64:
+	default:
+		var decoded interface{}
+		if err := jsonutil.Remarshal(value, &decoded); err == nil {
+			switch decoded.(type) {
+			case []interface{}, map[string]interface{}, string:
+				contents = append(contents, configurationStrings(decoded)...)
+			}
+		}
+	}
+	return contents
+}
+
+// maskSecureValues replaces every occurrence of the secure values in content with maskedValue. The values are also
+// masked as they are escaped in JSON, since the logged contents are often marshalled.
+func maskSecureValues(content string, secureValues []string) string {
+	for _, value := range secureValues {
+		content = strings.Replace(content, value, maskedValue, -1)
+		if escaped, err := json.Marshal(value); err == nil && string(escaped[1:len(escaped)-1]) != value {
+			content = strings.Replace(content, string(escaped[1:len(escaped)-1]), maskedValue, -1)
+		}
+	}
+	return content
+}
+
+// maskPluginResults returns a copy of the results with the secure values masked in their outputs,
+// the results themselves are left as is since they are persisted on disk
+func maskPluginResults(results map[string]*contracts.PluginResult, secureValues []string) map[string]*contracts.PluginResult {
+	if len(secureValues) == 0 {
+		return results
+	}
+	masked := make(map[string]*contracts.PluginResult, len(results))
+	for pluginID, result := range results {
+		if result == nil {
+			masked[pluginID] = nil
+			continue
+		}
+		maskedResult := *result
+		maskedResult.Output = maskOutput(result.Output, secureValues)
+		maskedResult.StandardOutput = maskSecureValues(result.StandardOutput, secureValues)
+		maskedResult.StandardError = maskSecureValues(result.StandardError, secureValues)
+		masked[pluginID] = &maskedResult
+	}
+	return masked
+}
+
+// maskOutput masks the secure values in the output of a plugin, which is either a string or a structure.
+// The strings of a structure are masked one by one, an output that can't be decoded is replaced as a whole.
+func maskOutput(output interface{}, secureValues []string) interface{} {
+	if output == nil {
+		return nil
+	}
+	if s, ok := output.(string); ok {
+		return maskSecureValues(s, secureValues)
+	}
+	var decoded interface{}
+	if err := jsonutil.Remarshal(output, &decoded); err != nil {
+		return maskedValue
+	}
+	return maskDecodedOutput(decoded, secureValues)
+}
+
+// maskDecodedOutput masks the secure values in the strings of an output decoded from JSON, keys included
+func maskDecodedOutput(output interface{}, secureValues []string) interface{} {
+	switch value := output.(type) {
+	case string:
+		return maskSecureValues(value, secureValues)
+	case []interface{}:
+		for i, item := range value {
+			value[i] = maskDecodedOutput(item, secureValues)
+		}
+		return value
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			masked[maskSecureValues(key, secureValues)] = maskDecodedOutput(item, secureValues)
+		}
+		return masked
+	}
+	return output
+}
+
+// withSecureParameterMasking masks the secure values in the results before they are sent
+func withSecureParameterMasking(secureValues []string, sendResponse runpluginutil.SendResponse) runpluginutil.SendResponse {
+	if len(secureValues) == 0 {
+		return sendResponse
+	}
+	return func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		sendResponse(messageID, pluginID, maskPluginResults(results, secureValues))
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_metrics contains the sink the processor reports throughput, latency and failures to
+package processor
+
+import (
+	"time"
+)
+
+const (
+	// metricsReasonInvalidMessage is the failure reason for messages that fail validation
+	metricsReasonInvalidMessage = "InvalidMessage"
+
+	// metricsReasonParseFailed is the failure reason for messages whose payload can't be parsed
+	metricsReasonParseFailed = "ParseFailed"
+
+	// metricsReasonTransientFailure is the failure reason for messages left for redelivery after a temporary failure
+	metricsReasonTransientFailure = "TransientFailure"
+
+	// metricsReasonAcknowledgeFailed is the failure reason for messages that can't be acknowledged
+	metricsReasonAcknowledgeFailed = "AcknowledgeFailed"
+
+	// metricsReasonCancelTargetNotFound is the failure reason for cancel messages whose command is not running
+	metricsReasonCancelTargetNotFound = "CancelTargetNotFound"
+
+	// metricsReasonCancelTargetNotOwned is the failure reason for cancel messages whose command doesn't belong to this instance
+	metricsReasonCancelTargetNotOwned = "CancelTargetNotOwned"
+
+	// metricsReasonUnsupportedPlugin is the failure reason for send commands naming plugins that can't run on this instance
+	metricsReasonUnsupportedPlugin = "UnsupportedPlugin"
+
+	// metricsReasonQuarantined is the failure reason for messages that failed too many times and are no longer retried
+	metricsReasonQuarantined = "Quarantined"
+
+	// metricsReasonRequiredTagsUnmet is the failure reason for documents skipped because the instance lacks their required tags
+	metricsReasonRequiredTagsUnmet = "RequiredTagsUnmet"
+
+	// metricsReasonPayloadTooLarge is the failure reason for messages whose payload exceeds the maximum size
+	metricsReasonPayloadTooLarge = "PayloadTooLarge"
+
+	// metricsReasonExpired is the failure reason for documents skipped because they expired before they started
+	metricsReasonExpired = "Expired"
+
+	// metricsReasonDocumentTypeNotProcessed is the failure reason for documents skipped because the agent is configured
+	// to not process their type
+	metricsReasonDocumentTypeNotProcessed = "DocumentTypeNotProcessed"
+)
+
+// ProcessorMetrics is a sink for the events of the message processor.
+// Implementations must be safe to call from multiple worker goroutines.
+type ProcessorMetrics interface {
+	// RecordMessageReceived is called for every message handed to the processor.
+	RecordMessageReceived()
+
+	// RecordMessageProcessed is called when the execution of a message completes, with the time spent executing it.
+	RecordMessageProcessed(duration time.Duration)
+
+	// RecordMessageFailed is called when a message is rejected or its execution doesn't succeed.
+	RecordMessageFailed(reason string)
+
+	// RecordLargeOutput is called when the total size of the plugin outputs of a document exceeds the warning threshold.
+	RecordLargeOutput(bytes int)
+}
+
+// noOpMetrics is the ProcessorMetrics used when no sink is configured.
+type noOpMetrics struct{}
+
+func (noOpMetrics) RecordMessageReceived() {}
+
+func (noOpMetrics) RecordMessageProcessed(duration time.Duration) {}
+
+func (noOpMetrics) RecordMessageFailed(reason string) {}
+
+func (noOpMetrics) RecordLargeOutput(bytes int) {}
+
+// SetMetrics sets the sink the processor reports its events to.
+func (p *Processor) SetMetrics(metrics ProcessorMetrics) {
+	p.metrics = metrics
+}
+
+// getMetrics returns the configured sink, or a no-op sink if none is configured.
+func (p *Processor) getMetrics() ProcessorMetrics {
+	if p.metrics == nil {
+		return noOpMetrics{}
+	}
+	return p.metrics
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_notification contains the notification of the documents that reach a terminal state
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// webhookTimeout bounds the time a completion webhook can take to answer
+const webhookTimeout = 5 * time.Second
+
+// DocumentCompletion is the summary of a document that reached a terminal state
+type DocumentCompletion struct {
+	DocumentID string                 `json:"documentId"`
+	Status     contracts.ResultStatus `json:"status"`
+	// DurationMillis is the time the agent spent executing the document
+	DurationMillis int64 `json:"durationMillis"`
+}
+
+// CompletionNotifier is notified of the documents that reach a terminal state.
+// Implementations must be safe to call from multiple worker goroutines.
+type CompletionNotifier interface {
+	NotifyDocumentCompleted(completion DocumentCompletion) error
+}
+
+// SetCompletionNotifier sets the notifier the processor reports the completed documents to.
+func (p *Processor) SetCompletionNotifier(notifier CompletionNotifier) {
+	p.completionNotifier = notifier
+}
+
+// notifyDocumentCompleted reports the completed document to the notifier, if any.
+// Failures are logged, they never affect the processing of the document.
+func (p *Processor) notifyDocumentCompleted(log log.T, docInfo model.DocumentInfo, startTime time.Time) {
+	if p.completionNotifier == nil {
+		return
+	}
+	completion := DocumentCompletion{
+		DocumentID:     docInfo.DocumentID,
+		Status:         docInfo.DocumentStatus,
+		DurationMillis: int64(time.Since(startTime) / time.Millisecond),
+	}
+	if err := p.completionNotifier.NotifyDocumentCompleted(completion); err != nil {
+		log.Errorf("failed to notify the completion of document %v: %v", docInfo.DocumentID, err)
+	}
+}
+
+// webhookNotifier posts the completed documents as json to an http endpoint
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// newWebhookNotifier returns a notifier posting to url, or nil if url is empty
+func newWebhookNotifier(url string) CompletionNotifier {
+	if url == "" {
+		return nil
+	}
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// NotifyDocumentCompleted posts the completion to the webhook
+func (n *webhookNotifier) NotifyDocumentCompleted(completion DocumentCompletion) error {
+	body, err := json.Marshal(completion)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %v answered %v", n.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_output contains the accounting of the size of the plugin outputs of a document
+package processor
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// documentOutputBytes returns the total size of the outputs, standard outputs and standard errors of the plugins
+func documentOutputBytes(outputs map[string]*contracts.PluginResult) (total int) {
+	for _, output := range outputs {
+		if output == nil {
+			continue
+		}
+		total += len(output.StandardOutput) + len(output.StandardError)
+		switch value := output.Output.(type) {
+		case nil:
+		case string:
+			total += len(value)
+		default:
+			if content, err := jsonutil.Marshal(value); err == nil {
+				total += len(content)
+			}
+		}
+	}
+	return total
+}
+
+// checkOutputSize logs a warning and records a metric when the plugin outputs of the document exceed
+// outputWarnBytes. It only observes the outputs, their truncation is left to the reply builder.
+func (p *Processor) checkOutputSize(log log.T, documentID string, outputs map[string]*contracts.PluginResult) {
+	if p.outputWarnBytes <= 0 {
+		return
+	}
+	total := documentOutputBytes(outputs)
+	if total <= p.outputWarnBytes {
+		return
+	}
+	log.Warnf("plugins of document %v produced %v bytes of output, more than the %v bytes warning threshold",
+		documentID, total, p.outputWarnBytes)
+	p.getMetrics().RecordLargeOutput(total)
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_priority contains the ordering of the pending and the polled documents by priority
+package processor
+
+import (
+	"sort"
+
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/times"
+)
+
+// sortPendingDocuments orders the pending documents by decreasing priority, the documents of equal priority are kept
+// in the order they were created in so that they are submitted first in, first out.
+func sortPendingDocuments(docStates []model.DocumentState) {
+	sort.SliceStable(docStates, func(i, j int) bool {
+		left, right := docStates[i].DocumentInformation, docStates[j].DocumentInformation
+		if left.Priority != right.Priority {
+			return left.Priority > right.Priority
+		}
+		return times.ParseIso8601UTC(left.CreatedDate).Before(times.ParseIso8601UTC(right.CreatedDate))
+	})
+}
+
+// holdPolledDocuments starts holding the documents of the messages processed, until submitPolledDocuments submits them
+func (p *Processor) holdPolledDocuments() {
+	p.polledDocumentsLock.Lock()
+	defer p.polledDocumentsLock.Unlock()
+	p.polledDocuments = &[]model.DocumentState{}
+}
+
+// executePolledDocument holds the document of a message if the messages of a poll are being processed, so that it is
+// submitted with the other documents of the poll by priority, and executes it right away otherwise
+func (p *Processor) executePolledDocument(docState *model.DocumentState) {
+	p.polledDocumentsLock.Lock()
+	if p.polledDocuments != nil {
+		*p.polledDocuments = append(*p.polledDocuments, *docState)
+		p.polledDocumentsLock.Unlock()
+		return
+	}
+	p.polledDocumentsLock.Unlock()
+	p.ExecutePendingDocument(docState)
+}
+
+// submitPolledDocuments stops holding the documents of the messages processed and executes the documents held, by
+// decreasing priority
+func (p *Processor) submitPolledDocuments() {
+	p.polledDocumentsLock.Lock()
+	polledDocuments := p.polledDocuments
+	p.polledDocuments = nil
+	p.polledDocumentsLock.Unlock()
+	if polledDocuments == nil {
+		return
+	}
+
+	docStates := *polledDocuments
+	sortPendingDocuments(docStates)
+	for i := range docStates {
+		p.ExecutePendingDocument(&docStates[i])
+	}
+}
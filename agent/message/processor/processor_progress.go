@@ -0,0 +1,117 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_progress contains the reporting of partial document results while the plugins of a document run
+package processor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+)
+
+// progressReportInterval is the frequency at which the interim state of a running document is checked for completed plugins
+var progressReportInterval = 30 * time.Second
+
+// runPluginsWithProgress runs the plugins of the document and, until they return, periodically sends a document level
+// reply with the results of the plugins that already completed. The progress replies and the replies of the plugins
+// are sent one at a time.
+func (p *Processor) runPluginsWithProgress(context context.T,
+	runPlugins PluginRunner,
+	cancelFlag task.CancelFlag,
+	sendResponse runpluginutil.SendResponse,
+	docState *model.DocumentState) map[string]*contracts.PluginResult {
+
+	var sendLock sync.Mutex
+	sendResponse = func(sendResponse runpluginutil.SendResponse) runpluginutil.SendResponse {
+		return func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+			sendLock.Lock()
+			defer sendLock.Unlock()
+			sendResponse(messageID, pluginID, results)
+		}
+	}(sendResponse)
+
+	// the progress is reported with the ids of the document read before the plugins run
+	log := context.Log()
+	documentInfo := docState.DocumentInformation
+	done := make(chan bool)
+	reported := make(chan bool)
+	go func() {
+		defer close(reported)
+		reportProgress(log, documentInfo, sendResponse, done)
+	}()
+	defer func() {
+		close(done)
+		<-reported
+	}()
+
+	return runPlugins(context, docState.DocumentInformation.MessageID, docState.InstancePluginsInformation, sendResponse, cancelFlag, p.stopSignal)
+}
+
+// reportProgress sends the partial results of a document every progressReportInterval, as long as more plugins have completed
+// since the last reply. It returns when done is closed.
+func reportProgress(log log.T, documentInfo model.DocumentInfo, sendResponse runpluginutil.SendResponse, done <-chan bool) {
+	ticker := time.NewTicker(progressReportInterval)
+	defer ticker.Stop()
+
+	lastCompleted := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		interimState := getDocumentInterimState(log,
+			documentInfo.DocumentID,
+			documentInfo.InstanceID,
+			appconfig.DefaultLocationOfCurrent)
+		results, completed := interimPluginResults(interimState.InstancePluginsInformation)
+
+		// the final reply is sent once all the plugins returned
+		if completed <= lastCompleted || completed == len(results) {
+			continue
+		}
+		lastCompleted = completed
+
+		log.Debugf("Sending reply on document progress, %v of %v plugins completed", completed, len(results))
+		sendResponse(documentInfo.MessageID, "", results)
+	}
+}
+
+// interimPluginResults returns the results of the given plugins, with the plugins that haven't completed yet reported as not started,
+// and the number of plugins that completed.
+func interimPluginResults(plugins []model.PluginState) (results map[string]*contracts.PluginResult, completed int) {
+	results = make(map[string]*contracts.PluginResult)
+	for _, pluginState := range plugins {
+		result := pluginState.Result
+		if result.Status == "" {
+			result = contracts.PluginResult{
+				PluginName: pluginState.Name,
+				Status:     contracts.ResultStatusNotStarted,
+			}
+		} else {
+			completed++
+		}
+		results[pluginState.Id] = &result
+	}
+	return
+}
@@ -0,0 +1,182 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_quarantine contains the bookkeeping that stops the redelivery of messages that keep failing
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/message/parser"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/pluginutil"
+	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+)
+
+// deadLetterKeyPrefix is the key prefix of the quarantined messages in the dead letter bucket
+const deadLetterKeyPrefix = "quarantine"
+
+// messageFailures is the failure count of a message, persisted so that it survives agent restarts
+type messageFailures struct {
+	MessageID string
+	Failures  int
+	LastError string
+}
+
+// isMessageQuarantined returns true if the message was quarantined on a previous delivery
+func (p *Processor) isMessageQuarantined(messageID string) bool {
+	return p.maxMessageFailures > 0 && isDocumentPersisted(messageID, p.config.InstanceID, appconfig.DefaultLocationOfQuarantine)
+}
+
+// loadMessageFailures returns the persisted failure count of the message, a zero count if there is none
+func loadMessageFailures(log log.T, instanceID, messageID string) messageFailures {
+	failures := messageFailures{MessageID: messageID}
+	if !isDocumentPersisted(messageID, instanceID, appconfig.DefaultLocationOfFailures) {
+		return failures
+	}
+	if err := getDocumentData(log, messageID, instanceID, appconfig.DefaultLocationOfFailures, &failures); err != nil {
+		log.Debugf("Failed to read the failure count of the message, starting over: %v", err)
+		failures = messageFailures{MessageID: messageID}
+	}
+	return failures
+}
+
+// recordMessageFailure increments the failure count of the message and quarantines it once the count reaches
+// maxMessageFailures. It returns true if the message was quarantined.
+func (p *Processor) recordMessageFailure(log log.T, msg *ssmmds.Message, failure error) (quarantined bool) {
+	if p.maxMessageFailures <= 0 {
+		return false
+	}
+
+	failures := loadMessageFailures(log, p.config.InstanceID, *msg.MessageId)
+	failures.Failures++
+	failures.LastError = failure.Error()
+	// the count is kept until the message is dropped, it is what the quarantined message is reported with
+	persistDocumentData(log, *msg.MessageId, p.config.InstanceID, appconfig.DefaultLocationOfFailures, failures)
+	if failures.Failures < p.maxMessageFailures {
+		return false
+	}
+
+	persistDocumentData(log, *msg.MessageId, p.config.InstanceID, appconfig.DefaultLocationOfQuarantine, msg)
+	log.Errorf("message failed %v times, moved it to %v and stopped retrying it. last error: %v",
+		failures.Failures, appconfig.DefaultLocationOfQuarantine, failure)
+	return true
+}
+
+// deadLetter is the object a quarantined message is uploaded as, with the failures that got it quarantined.
+// The payload of the message is left out since it holds the parameter values of the document.
+type deadLetter struct {
+	MessageID   string
+	Topic       string
+	Destination string
+	CreatedDate string
+	Failures    int
+	LastError   string
+}
+
+// newDeadLetterUploader returns the S3 uploader if there is a dead letter bucket, nil otherwise
+func newDeadLetterUploader(bucketName string) pluginutil.OutputUploader {
+	if bucketName == "" {
+		return nil
+	}
+	return pluginutil.S3OutputUploader{}
+}
+
+// SetDeadLetterUploader sets the uploader the quarantined messages are uploaded to bucketName with, nil to only
+// quarantine them locally.
+func (p *Processor) SetDeadLetterUploader(uploader pluginutil.OutputUploader, bucketName string) {
+	p.deadLetterUploader = uploader
+	p.deadLetterS3Bucket = bucketName
+}
+
+// uploadDeadLetter uploads the metadata of a quarantined message and its last error to the dead letter bucket, keyed by
+// the instance and the message id. It returns true if the message was uploaded. The message stays quarantined locally
+// whether the upload succeeds or not.
+func (p *Processor) uploadDeadLetter(log log.T, msg *ssmmds.Message, failures messageFailures) bool {
+	if p.deadLetterUploader == nil || p.deadLetterS3Bucket == "" {
+		return false
+	}
+	content, err := jsonutil.MarshalIndent(deadLetter{
+		MessageID:   aws.StringValue(msg.MessageId),
+		Topic:       aws.StringValue(msg.Topic),
+		Destination: aws.StringValue(msg.Destination),
+		CreatedDate: aws.StringValue(msg.CreatedDate),
+		Failures:    failures.Failures,
+		LastError:   failures.LastError,
+	})
+	if err != nil {
+		log.Errorf("Failed to marshal the quarantined message: %v", err)
+		return false
+	}
+	objectKey := fileutil.BuildS3Path(deadLetterKeyPrefix, p.config.InstanceID, *msg.MessageId+".json")
+	if err = p.deadLetterUploader.UploadOutput(log, p.deadLetterS3Bucket, objectKey, strings.NewReader(content)); err != nil {
+		log.Errorf("Failed to upload the quarantined message to s3://%v/%v, it is only quarantined locally: %v",
+			p.deadLetterS3Bucket, objectKey, err)
+		return false
+	}
+	return true
+}
+
+// sendQuarantinedReply reports the document of a quarantined message failed to the service, with the failures that got
+// it quarantined. It returns true if the reply was sent.
+func (p *Processor) sendQuarantinedReply(log log.T, msg *ssmmds.Message, failures messageFailures) bool {
+	payloadDoc := parser.PrepareReplyPayloadToUpdateDocumentStatus(p.config.AgentInfo, contracts.ResultStatusFailed,
+		fmt.Sprintf("message failed %v times and was quarantined. last error: %v", failures.Failures, failures.LastError))
+	payload, err := jsonutil.Marshal(payloadDoc)
+	if err != nil {
+		log.Errorf("Failed to marshal the reply of the quarantined message: %v", err)
+		return false
+	}
+	if err = p.service.SendReply(log, *msg.MessageId, payload); err != nil {
+		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+		return false
+	}
+	return true
+}
+
+// clearMessageFailures resets the failure count of a message that was processed successfully
+func (p *Processor) clearMessageFailures(log log.T, messageID string) {
+	if p.maxMessageFailures <= 0 || !isDocumentPersisted(messageID, p.config.InstanceID, appconfig.DefaultLocationOfFailures) {
+		return
+	}
+	removeDocumentData(log, messageID, p.config.InstanceID, appconfig.DefaultLocationOfFailures)
+}
+
+// dropQuarantinedMessage acknowledges and deletes a quarantined message so that it is not delivered again. The message
+// is only deleted once it was either uploaded to the dead letter bucket or reported failed to the service, otherwise it
+// is left to be delivered again and dropped on a later delivery.
+func (p *Processor) dropQuarantinedMessage(log log.T, msg *ssmmds.Message) {
+	p.getMetrics().RecordMessageFailed(metricsReasonQuarantined)
+	failures := loadMessageFailures(log, p.config.InstanceID, *msg.MessageId)
+	if !p.uploadDeadLetter(log, msg, failures) && !p.sendQuarantinedReply(log, msg, failures) {
+		log.Errorf("quarantined message could neither be uploaded nor reported failed, keeping it until its next delivery")
+		return
+	}
+	if err := p.service.AcknowledgeMessage(log, *msg.MessageId); err != nil {
+		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+		return
+	}
+	if err := p.service.DeleteMessage(log, *msg.MessageId); err != nil {
+		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+		return
+	}
+	p.clearMessageFailures(log, *msg.MessageId)
+}
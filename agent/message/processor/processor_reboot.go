@@ -0,0 +1,78 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_reboot contains the limit on the number of reboots a document can request
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// getMaxDocumentReboots returns the number of reboots a document can request, or the default if none is configured.
+func (p *Processor) getMaxDocumentReboots() int {
+	if p.maxDocumentReboots <= 0 {
+		return appconfig.DefaultMaxDocumentReboots
+	}
+	return p.maxDocumentReboots
+}
+
+// recordRebootRequest records the plugins of the document that requested the reboot, so that they can be found after it.
+func recordRebootRequest(log log.T, docState *model.DocumentState, outputs map[string]*contracts.PluginResult) {
+	var pluginIDs []string
+	for pluginID, output := range outputs {
+		if output.Status == contracts.ResultStatusSuccessAndReboot || output.Status == contracts.ResultStatusPassedAndReboot {
+			pluginIDs = append(pluginIDs, pluginID)
+		}
+	}
+	sort.Strings(pluginIDs)
+	docState.DocumentInformation.RebootRequestedBy = strings.Join(pluginIDs, ",")
+	log.Infof("plugin %v of document %v requested a reboot", docState.DocumentInformation.RebootRequestedBy, docState.DocumentInformation.DocumentID)
+}
+
+// countDocumentReboot records a reboot requested by the document. Once the document has requested more reboots than allowed,
+// the plugins requesting the reboot are failed so that the document completes instead of resuming after every reboot.
+func (p *Processor) countDocumentReboot(log log.T,
+	docState *model.DocumentState,
+	outputs map[string]*contracts.PluginResult,
+	buildReply replyBuilder) {
+
+	docState.DocumentInformation.RebootCount++
+	maxReboots := p.getMaxDocumentReboots()
+	if docState.DocumentInformation.RebootCount <= maxReboots {
+		return
+	}
+
+	log.Errorf("document %v requested more than %v reboots, failing it", docState.DocumentInformation.DocumentID, maxReboots)
+	rebootErr := fmt.Errorf("document requested more than %v reboots", maxReboots)
+	for _, output := range outputs {
+		if output.Status == contracts.ResultStatusSuccessAndReboot {
+			output.Status = contracts.ResultStatusFailed
+			output.Error = rebootErr
+			output.Output = rebootErr.Error()
+		}
+	}
+
+	payloadDoc := buildReply("", outputs)
+	docState.DocumentInformation.AdditionalInfo = payloadDoc.AdditionalInfo
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+	docState.DocumentInformation.DocumentTraceOutput = rebootErr.Error()
+	docState.DocumentInformation.RuntimeStatus = payloadDoc.RuntimeStatus
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_resume contains the resumption of the documents left in the Current folder by an agent crash
+package processor
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+)
+
+// ResumeInflightDocuments re-drives the documents left in the Current folder, e.g. by an agent crash.
+// Documents that already reached a terminal state are moved to Completed, the others are submitted again
+// so that only the plugins that haven't executed yet run.
+func (p *Processor) ResumeInflightDocuments() {
+	log := p.context.Log()
+	instanceID, err := getInstanceID()
+	if err != nil {
+		log.Errorf("no instanceID provided, %v", err)
+		return
+	}
+	p.processInProgressDocuments(instanceID)
+}
+
+// isDocumentTerminal returns true if all the plugins of the document executed and the document reached a final status,
+// which happens when the agent stops after persisting the result of a document but before moving it to Completed
+func isDocumentTerminal(docState model.DocumentState) bool {
+	switch docState.DocumentInformation.DocumentStatus {
+	case contracts.ResultStatusSuccess, contracts.ResultStatusFailed, contracts.ResultStatusCancelled, contracts.ResultStatusTimedOut:
+	default:
+		return false
+	}
+	if docState.IsCancelCommand() {
+		return true
+	}
+	for _, plugin := range docState.InstancePluginsInformation {
+		if !plugin.HasExecuted {
+			return false
+		}
+	}
+	return true
+}
+
+// completeTerminalDocument moves a document found in Current in a terminal state to Completed
+func (p *Processor) completeTerminalDocument(log log.T, docState model.DocumentState) {
+	log.Infof("document %v found in %v is already %v, moving it to %v",
+		docState.DocumentInformation.DocumentID,
+		appconfig.DefaultLocationOfCurrent,
+		docState.DocumentInformation.DocumentStatus,
+		appconfig.DefaultLocationOfCompleted)
+	moveDocumentState(log,
+		docState.DocumentInformation.DocumentID,
+		docState.DocumentInformation.InstanceID,
+		appconfig.DefaultLocationOfCurrent,
+		appconfig.DefaultLocationOfCompleted)
+}
+
+// resumeDocument submits a document of the processor found in Current to the pool of its type
+func (p *Processor) resumeDocument(log log.T, docState *model.DocumentState) error {
+	if docState.IsCancelCommand() {
+		log.Debugf("processor %v resuming cancel document %v", p.name, docState.DocumentInformation.DocumentID)
+		return p.submitDocument(log, p.cancelCommandPool, docState, func(cancelFlag task.CancelFlag) {
+			p.processCancelCommandMessage(p.context.With("[messageID="+docState.DocumentInformation.MessageID+"]"),
+				p.service,
+				p.sendCommandPool,
+				docState)
+		})
+	}
+
+	log.Debugf("processor %v processing in-progress document %v", p.name, docState.DocumentInformation.DocumentID)
+	return p.submitDocument(log, p.sendCommandPool, docState, func(cancelFlag task.CancelFlag) {
+		p.runCmdsUsingCmdState(p.context.With("[messageID="+docState.DocumentInformation.MessageID+"]"),
+			p.service,
+			p.pluginRunner,
+			cancelFlag,
+			p.buildReply,
+			p.sendResponse,
+			*docState)
+	})
+}
@@ -0,0 +1,181 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_retention contains the janitor that removes the orchestration directories of old documents
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/times"
+)
+
+// orchestrationCleanupFrequencyHours is the frequency at which old orchestration directories are removed
+const orchestrationCleanupFrequencyHours = 6
+
+// orchestrationCleanupYieldInterval is the number of orchestration directories a cleanup worker processes before it
+// pauses for orchestrationCleanupYield, so that the cleanup doesn't starve the documents of IO
+var orchestrationCleanupYieldInterval = 100
+var orchestrationCleanupYield = 10 * time.Millisecond
+
+// removeOrchestrationPath deletes an orchestration directory or compacted archive
+var removeOrchestrationPath = fileutil.DeleteDirectory
+
+// getInodeInfo returns the free and total inodes of the file system containing the path
+var getInodeInfo = fileutil.GetInodeInfoForPath
+
+// maxLowInodesRemovals is the number of orchestration directories a cleanup removes at most because the inodes are low,
+// so that a file system exhausted by something else doesn't lose all the orchestration directories at once
+var maxLowInodesRemovals = 50
+
+// isDocumentInProgress returns true if the document is still in the Pending or Current folder
+var isDocumentInProgress = func(documentID, instanceID string) bool {
+	return isDocumentPersisted(documentID, instanceID, appconfig.DefaultLocationOfPending) ||
+		isDocumentPersisted(documentID, instanceID, appconfig.DefaultLocationOfCurrent)
+}
+
+// getClock returns the clock of the processor, or the default clock if none is configured.
+func (p *Processor) getClock() times.Clock {
+	if p.clock == nil {
+		return times.DefaultClock
+	}
+	return p.clock
+}
+
+// cleanupOrchestrationDirectories removes the orchestration directories of documents older than the retention period,
+// then the oldest ones beyond the maximum count, then up to maxLowInodesRemovals of the oldest ones until the file system
+// has the minimum of free inodes again. Directories of documents that are still executing are never removed.
+func (p *Processor) cleanupOrchestrationDirectories() {
+	log := p.context.Log()
+
+	if !fileutil.Exists(p.orchestrationRootDir) {
+		return
+	}
+	entries, err := fileutil.ReadDir(p.orchestrationRootDir)
+	if err != nil {
+		log.Errorf("Failed to read orchestration directory %v, %v", p.orchestrationRootDir, err)
+		return
+	}
+
+	now := p.getClock().Now()
+	retained := []os.FileInfo{}
+	var retainedLock sync.Mutex
+	p.forEachOrchestrationEntry(entries, func(entry os.FileInfo) {
+		// compacted documents are kept as a single archive named after the command
+		commandID := strings.TrimSuffix(entry.Name(), compactedArtifactsExtension)
+		if isDocumentInProgress(commandID, p.config.InstanceID) {
+			return
+		}
+		if p.orchestrationRetention > 0 && now.Sub(entry.ModTime()) > p.orchestrationRetention {
+			p.removeOrchestrationEntry(entry)
+			return
+		}
+		retainedLock.Lock()
+		defer retainedLock.Unlock()
+		retained = append(retained, entry)
+	})
+
+	sort.Slice(retained, func(i, j int) bool {
+		return retained[i].ModTime().After(retained[j].ModTime())
+	})
+	if p.orchestrationRetentionMaxCount > 0 && len(retained) > p.orchestrationRetentionMaxCount {
+		// remove the oldest documents beyond the maximum count
+		p.forEachOrchestrationEntry(retained[p.orchestrationRetentionMaxCount:], p.removeOrchestrationEntry)
+		retained = retained[:p.orchestrationRetentionMaxCount]
+	}
+
+	// remove the oldest documents while the inodes are low, whatever their age
+	for removed := 0; removed < maxLowInodesRemovals && len(retained) > 0 && p.isLowOnInodes(); removed++ {
+		p.removeOrchestrationEntry(retained[len(retained)-1])
+		retained = retained[:len(retained)-1]
+	}
+}
+
+// forEachOrchestrationEntry calls process for every entry on at most orchestrationCleanupWorkers goroutines and returns
+// once all the entries are processed. Each worker pauses every orchestrationCleanupYieldInterval entries.
+func (p *Processor) forEachOrchestrationEntry(entries []os.FileInfo, process func(entry os.FileInfo)) {
+	workers := p.orchestrationCleanupWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(entries) {
+		workers = len(entries)
+	}
+
+	queue := make(chan os.FileInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processed := 0
+			for entry := range queue {
+				process(entry)
+				processed++
+				if orchestrationCleanupYieldInterval > 0 && processed%orchestrationCleanupYieldInterval == 0 {
+					time.Sleep(orchestrationCleanupYield)
+				}
+			}
+		}()
+	}
+	for _, entry := range entries {
+		queue <- entry
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// isLowOnInodes returns true if the file system of the orchestration root has less free inodes than the minimum.
+// File systems without a fixed number of inodes, or whose inodes can't be read, are never low on inodes.
+func (p *Processor) isLowOnInodes() bool {
+	if p.minFreeInodesPercent <= 0 {
+		return false
+	}
+	inodeInfo, err := getInodeInfo(p.orchestrationRootDir)
+	if err != nil {
+		p.context.Log().Warnf("Failed to read the inodes of %v, %v", p.orchestrationRootDir, err)
+		return false
+	}
+	if inodeInfo.TotalInodes == 0 {
+		return false
+	}
+	low := inodeInfo.FreeInodes*100 < inodeInfo.TotalInodes*uint64(p.minFreeInodesPercent)
+	if low {
+		p.context.Log().Infof("%v of %v inodes are free, below the minimum of %v%%, removing the oldest orchestration directories",
+			inodeInfo.FreeInodes, inodeInfo.TotalInodes, p.minFreeInodesPercent)
+	}
+	return low
+}
+
+// removeOrchestrationEntry deletes an orchestration directory or compacted archive under the orchestration root
+func (p *Processor) removeOrchestrationEntry(entry os.FileInfo) {
+	log := p.context.Log()
+	path := filepath.Join(p.orchestrationRootDir, entry.Name())
+	log.Debugf("Removing orchestration directory %v last modified at %v", path, times.ToIso8601UTC(entry.ModTime()))
+	if err := removeOrchestrationPath(path); err != nil {
+		log.Errorf("Failed to remove orchestration directory %v, %v", path, err)
+	}
+}
+
+// newOrchestrationRetention returns the retention period of orchestration directories for the configured number of days
+func newOrchestrationRetention(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
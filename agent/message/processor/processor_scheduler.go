@@ -118,6 +118,14 @@ func (p *Processor) stop() {
 		p.messagePollJob.Quit <- true
 	}
 
+	if p.orchestrationCleanupJob != nil {
+		p.orchestrationCleanupJob.Quit <- true
+	}
+
+	if p.unsupportedDocumentsRefreshJob != nil {
+		p.unsupportedDocumentsRefreshJob.Quit <- true
+	}
+
 	if p.assocProcessor != nil {
 		p.assocProcessor.Stop()
 	}
@@ -143,15 +151,19 @@ func (p *Processor) pollOnce() {
 	messages, err := p.service.GetMessages(log, p.config.InstanceID)
 	if err != nil {
 		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+		p.recordError(err)
 		return
 	}
+	p.recordPoll()
 	if len(messages.Messages) > 0 {
 		log.Debugf("Got %v messages", len(messages.Messages))
 	}
 
+	p.holdPolledDocuments()
 	for _, msg := range messages.Messages {
 		processMessage(p, msg)
 	}
+	p.submitPolledDocuments()
 	if p.name == mdsName {
 		log.Debugf("Done poll once")
 	}
@@ -119,6 +119,7 @@ func initializeSendCommandStateWithRuntimeConfig(
 			PluginName:             pluginName,
 			PluginID:               pluginName,
 		}
+		config.DefaultWorkingDirectory = payload.DocumentContent.StepWorkingDirectory(nil)
 		pluginConfigurations[pluginName] = &config
 	}
 
@@ -156,7 +157,11 @@ func initializeSendCommandStateWithMainStep(
 			BookKeepingFileName:    payload.CommandID,
 			PluginName:             pluginName,
 			PluginID:               instancePluginConfig.Name,
+			TimeoutSeconds:         instancePluginConfig.Timeout,
+			ExecutionGroup:         instancePluginConfig.ExecutionGroup,
+			MaxAttempts:            instancePluginConfig.MaxAttempts,
 		}
+		config.DefaultWorkingDirectory = payload.DocumentContent.StepWorkingDirectory(instancePluginConfig)
 
 		var plugin stateModel.PluginState
 		plugin.Configuration = config
@@ -0,0 +1,62 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_status contains the query of the status of a document by the tooling on the instance
+package processor
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+)
+
+// ErrDocumentNotFound is returned when no state is persisted for the command
+type ErrDocumentNotFound struct {
+	CommandID string
+}
+
+// Error returns the message of the error
+func (e *ErrDocumentNotFound) Error() string {
+	return fmt.Sprintf("document of command %v not found", e.CommandID)
+}
+
+// DocumentStatus returns the status of the document of a command and the statuses of its plugins, by plugin id.
+// The state is read from Current, or from Completed once the document is over. Plugins that haven't run yet
+// are reported as NotStarted.
+func (p *Processor) DocumentStatus(commandID string) (contracts.ResultStatus, map[string]contracts.ResultStatus, error) {
+	log := p.context.Log()
+	instanceID := p.config.InstanceID
+
+	for _, folder := range []string{appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted} {
+		if !isDocumentPersisted(commandID, instanceID, folder) {
+			continue
+		}
+		docState := getDocumentInterimState(log, commandID, instanceID, folder)
+		pluginStatuses := make(map[string]contracts.ResultStatus, len(docState.InstancePluginsInformation))
+		for _, plugin := range docState.InstancePluginsInformation {
+			status := plugin.Result.Status
+			if status == "" {
+				status = contracts.ResultStatusNotStarted
+			}
+			pluginID := plugin.Id
+			if pluginID == "" {
+				pluginID = plugin.Name
+			}
+			pluginStatuses[pluginID] = status
+		}
+		return docState.DocumentInformation.DocumentStatus, pluginStatuses, nil
+	}
+	return "", nil, &ErrDocumentNotFound{CommandID: commandID}
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_store contains the backend the state of the documents is persisted in
+package processor
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// documentStateStore persists the state of the documents, in files of the pending, current and completed folders by default
+var documentStateStore statemanager.DocumentStateStore = statemanager.FileSystemStore{}
+
+// SetDocumentStateStore replaces the backend the state of the documents is persisted in, e.g. for agents on a read-only root.
+// It must be called before the processors are created.
+func SetDocumentStateStore(store statemanager.DocumentStateStore) {
+	documentStateStore = store
+}
+
+var getDocumentInterimState = func(log log.T, documentID, instanceID, locationFolder string) model.DocumentState {
+	return documentStateStore.GetDocumentInterimState(log, documentID, instanceID, locationFolder)
+}
+
+var persistDocumentState = func(log log.T, documentID, instanceID, locationFolder string, docState model.DocumentState) {
+	documentStateStore.PersistData(log, documentID, instanceID, locationFolder, docState)
+}
+
+var persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, documentID, instanceID, locationFolder string) {
+	documentStateStore.PersistDocumentInfo(log, docInfo, documentID, instanceID, locationFolder)
+}
+
+var moveDocumentState = func(log log.T, documentID, instanceID, srcLocationFolder, dstLocationFolder string) {
+	documentStateStore.MoveDocumentState(log, documentID, instanceID, srcLocationFolder, dstLocationFolder)
+}
+
+var persistDocumentData = func(log log.T, documentID, instanceID, locationFolder string, object interface{}) {
+	documentStateStore.PersistData(log, documentID, instanceID, locationFolder, object)
+}
+
+var getDocumentData = func(log log.T, documentID, instanceID, locationFolder string, object interface{}) error {
+	return documentStateStore.GetData(log, documentID, instanceID, locationFolder, object)
+}
+
+var removeDocumentData = func(log log.T, documentID, instanceID, locationFolder string) {
+	documentStateStore.RemoveData(log, documentID, instanceID, locationFolder)
+}
+
+var isDocumentPersisted = func(documentID, instanceID, locationFolder string) bool {
+	return documentStateStore.IsDocumentPersisted(documentID, instanceID, locationFolder)
+}
+
+var listDocuments = func(log log.T, instanceID, locationFolder string) ([]string, error) {
+	return documentStateStore.ListDocuments(log, instanceID, locationFolder)
+}
+
+// recoverInterruptedMoves resolves the documents left in several locations by a crash, if the store supports it
+var recoverInterruptedMoves = func(log log.T, instanceID string) {
+	if recoverer, ok := documentStateStore.(statemanager.InterruptedMoveRecoverer); ok {
+		recoverer.RecoverInterruptedMoves(log, instanceID)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_tags contains the check of the tags a document requires the instance to have
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/platform"
+	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+var getInstanceTags = platform.CachedInstanceTags
+
+// requiredTagsUnmet returns why the instance doesn't have the tags required by a document, empty if it has them
+func requiredTagsUnmet(log log.T, requiredTags map[string]string) (reason string) {
+	if len(requiredTags) == 0 {
+		return ""
+	}
+	tags, err := getInstanceTags(log)
+	if err != nil {
+		return fmt.Sprintf("skipped, the tags of the instance required by the document can't be looked up: %v", err)
+	}
+
+	var missing []string
+	for key, value := range requiredTags {
+		if actual, ok := tags[key]; !ok || (value != "" && actual != value) {
+			missing = append(missing, fmt.Sprintf("%v=%v", key, value))
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	sort.Strings(missing)
+	return fmt.Sprintf("skipped, the instance doesn't have the tags required by the document: %v", strings.Join(missing, ", "))
+}
+
+// completeSkippedDocument fails a pending document that doesn't run on this instance without executing its plugins
+func (p *Processor) completeSkippedDocument(docState *model.DocumentState, reason string, metricsReason string) {
+	log := p.context.Log()
+	log.Infof("Command %v %v", docState.DocumentInformation.CommandID, reason)
+
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+	docState.DocumentInformation.DocumentTraceOutput = reason
+	persistDocumentInfo(log,
+		docState.DocumentInformation,
+		docState.DocumentInformation.DocumentID,
+		docState.DocumentInformation.InstanceID,
+		appconfig.DefaultLocationOfPending)
+	moveDocumentState(log,
+		docState.DocumentInformation.DocumentID,
+		docState.DocumentInformation.InstanceID,
+		appconfig.DefaultLocationOfPending,
+		appconfig.DefaultLocationOfCompleted)
+
+	p.sendDocLevelResponse(docState.DocumentInformation.MessageID, contracts.ResultStatusFailed, reason)
+	p.getMetrics().RecordMessageFailed(metricsReason)
+
+	if err := p.service.DeleteMessage(log, docState.DocumentInformation.MessageID); err != nil {
+		sdkutil.HandleAwsError(log, err, p.processorStopPolicy)
+	}
+}
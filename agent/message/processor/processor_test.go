@@ -16,20 +16,32 @@ package processor
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
 	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
 	"github.com/aws/amazon-ssm-agent/agent/log"
 	messageContracts "github.com/aws/amazon-ssm-agent/agent/message/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/message/converter"
 	"github.com/aws/amazon-ssm-agent/agent/message/parser"
+	"github.com/aws/amazon-ssm-agent/agent/message/service"
 	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 	"github.com/aws/amazon-ssm-agent/agent/times"
@@ -251,6 +263,92 @@ func TestProcessMessageWithSendCommandTopicPrefix(t *testing.T) {
 	assert.True(t, *tc.IsDataPersisted)
 }
 
+// TestHealthStatus tests that the health status reflects a poll that processed a message without errors
+func TestHealthStatus(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	proc.sendCommandWorkersLimit = 5
+
+	processMessageOrig, loadDocStateFromSendCommandOrig := processMessage, loadDocStateFromSendCommand
+	defer func() {
+		processMessage, loadDocStateFromSendCommand = processMessageOrig, loadDocStateFromSendCommandOrig
+	}()
+	processMessage = (*Processor).processMessage
+	loadDocStateFromSendCommand = mockParseSendCommand
+
+	getMessageOutput := ssmmds.GetMessagesOutput{Messages: []*ssmmds.Message{&tc.Message}}
+	tc.MdsMock.On("GetMessages", mock.Anything, mock.Anything).Return(&getMessageOutput, nil)
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.SendCommandTaskPoolMock.On("Submit", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("task.Job")).Return(nil)
+
+	before := time.Now()
+	proc.pollOnce()
+	health := proc.HealthStatus()
+
+	tc.MdsMock.AssertExpectations(t)
+	assert.False(t, health.LastPollTime.Before(before))
+	// the mocked pool never runs the document, so it stays in flight without occupying a worker
+	assert.Equal(t, 1, health.InFlightDocuments)
+	assert.Equal(t, 0.0, health.SendCommandPoolSaturation)
+	assert.Equal(t, 0, health.ErrorCount)
+	assert.Nil(t, health.LastError)
+}
+
+// TestHealthStatusCircuitBreaker tests that the health status reports the circuit breaker of the MDS calls has opened
+func TestHealthStatusCircuitBreaker(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	assert.Equal(t, service.CircuitState(""), proc.HealthStatus().MdsCircuitState)
+
+	tc.MdsMock.On("GetMessages", mock.Anything, mock.Anything).Return(&ssmmds.GetMessagesOutput{}, fmt.Errorf("GetMessages Error: ServiceUnavailable: \n\tstatus code: 503"))
+	proc.service = service.NewCircuitBreakerService(tc.MdsMock, 2, time.Minute, times.DefaultClock)
+	assert.Equal(t, service.CircuitClosed, proc.HealthStatus().MdsCircuitState)
+
+	for i := 0; i < 3; i++ {
+		proc.pollOnce()
+	}
+	tc.MdsMock.AssertNumberOfCalls(t, "GetMessages", 2)
+	assert.Equal(t, service.CircuitOpen, proc.HealthStatus().MdsCircuitState)
+}
+
+// TestProcessMessageWithOfflineSendCommand tests that a document submitted to the local command folder is executed as an offline command
+func TestProcessMessageWithOfflineSendCommand(t *testing.T) {
+	testCase := generateTestCaseFromFiles(t, sampleMessageFiles[0], sampleMessageReplyFiles[0], testDestination)
+	proc, tc := prepareTestProcessMessage(string(SendCommandTopicPrefixOffline) + "validcommand.json")
+	tc.Message.MessageId = testCase.Msg.MessageId
+	tc.Message.Payload = testCase.Msg.Payload
+
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.SendCommandTaskPoolMock.On("Submit", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("task.Job")).Return(nil)
+
+	loadDocStateFromSendCommandOrig, isManagedInstanceOrig := loadDocStateFromSendCommand, isManagedInstance
+	isDocumentPersistedOrig, moveDocumentStateOrig := isDocumentPersisted, moveDocumentState
+	defer func() {
+		loadDocStateFromSendCommand, isManagedInstance = loadDocStateFromSendCommandOrig, isManagedInstanceOrig
+		isDocumentPersisted, moveDocumentState = isDocumentPersistedOrig, moveDocumentStateOrig
+	}()
+	var docState *model.DocumentState
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		var err error
+		docState, err = parseSendCommandMessage(context, msg, messagesOrchestrationRootDir)
+		return docState, err
+	}
+	isManagedInstance = func() (bool, error) { return false, nil }
+	isDocumentPersisted = func(commandID, instanceID, locationFolder string) bool { return false }
+	var movedTo []string
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {
+		movedTo = append(movedTo, dstLocationFolder)
+	}
+
+	proc.processMessage(&tc.Message)
+
+	assert.NotNil(t, docState)
+	assert.Equal(t, model.SendCommandOffline, docState.DocumentType)
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCurrent}, movedTo)
+	tc.MdsMock.AssertExpectations(t)
+	tc.SendCommandTaskPoolMock.AssertExpectations(t)
+	assert.True(t, *tc.IsDocLevelResponseSent)
+	assert.True(t, *tc.IsDataPersisted)
+}
+
 // TestProcessMessageWithCancelCommandTopicPrefix tests processMessage with CancelCommand topic prefix
 func TestProcessMessageWithCancelCommandTopicPrefix(t *testing.T) {
 	// CancelCommand topic prefix
@@ -324,6 +422,365 @@ func TestProcessMessageWithInvalidMessage(t *testing.T) {
 	assert.False(t, *tc.IsDataPersisted)
 }
 
+// TestProcessMessageWithMalformedPayload tests that a message with a malformed payload is failed
+func TestProcessMessageWithMalformedPayload(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+
+	tc.MdsMock.On("FailMessage", mock.Anything, *tc.Message.MessageId, mock.Anything).Return(nil)
+	loadDocStateFromSendCommandOrig := loadDocStateFromSendCommand
+	defer func() { loadDocStateFromSendCommand = loadDocStateFromSendCommandOrig }()
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return nil, &ErrMalformedPayload{Err: fmt.Errorf("invalid json")}
+	}
+
+	proc.processMessage(&tc.Message)
+
+	tc.MdsMock.AssertExpectations(t)
+	tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+	tc.SendCommandTaskPoolMock.AssertNotCalled(t, "Submit")
+	assert.True(t, *tc.IsDocLevelResponseSent)
+	assert.False(t, *tc.IsDataPersisted)
+}
+
+// TestProcessMessageWithMalformedCancelPayload tests that a cancel command that cannot be parsed is reported as failed
+func TestProcessMessageWithMalformedCancelPayload(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicCancel)
+
+	tc.MdsMock.On("FailMessage", mock.Anything, *tc.Message.MessageId, mock.Anything).Return(nil)
+	loadDocStateFromCancelCommandOrig := loadDocStateFromCancelCommand
+	defer func() { loadDocStateFromCancelCommand = loadDocStateFromCancelCommandOrig }()
+	loadDocStateFromCancelCommand = parseCancelCommandMessage
+	tc.Message.Payload = aws.String("{invalid json")
+
+	proc.processMessage(&tc.Message)
+
+	tc.MdsMock.AssertExpectations(t)
+	tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+	tc.CancelCommandTaskPoolMock.AssertNotCalled(t, "Submit")
+	assert.True(t, *tc.IsDocLevelResponseSent)
+	assert.False(t, *tc.IsDataPersisted)
+}
+
+// TestProcessMessageWithTransientError tests that a message that failed temporarily is left for redelivery
+func TestProcessMessageWithTransientError(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+
+	loadDocStateFromSendCommandOrig := loadDocStateFromSendCommand
+	defer func() { loadDocStateFromSendCommand = loadDocStateFromSendCommandOrig }()
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return nil, &ErrTransient{Err: fmt.Errorf("metadata service unavailable")}
+	}
+
+	proc.processMessage(&tc.Message)
+
+	tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+	tc.MdsMock.AssertNotCalled(t, "FailMessage", mock.Anything, mock.Anything, mock.Anything)
+	tc.SendCommandTaskPoolMock.AssertNotCalled(t, "Submit")
+	assert.False(t, *tc.IsDocLevelResponseSent)
+	assert.False(t, *tc.IsDataPersisted)
+}
+
+// TestProcessMessageRetriesTransientParseError tests that a parse failing with a transient error is retried until it succeeds
+func TestProcessMessageRetriesTransientParseError(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	proc.parseRetryCount = 2
+
+	loadDocStateFromSendCommandOrig, parseRetryDelayOrig := loadDocStateFromSendCommand, parseRetryDelay
+	defer func() {
+		loadDocStateFromSendCommand, parseRetryDelay = loadDocStateFromSendCommandOrig, parseRetryDelayOrig
+	}()
+	parseRetryDelay = time.Millisecond
+	attempts := 0
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, &ErrTransient{Err: fmt.Errorf("document unavailable")}
+		}
+		return mockParseSendCommand(context, msg, messagesOrchestrationRootDir)
+	}
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.SendCommandTaskPoolMock.On("Submit", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("task.Job")).Return(nil)
+
+	proc.processMessage(&tc.Message)
+
+	assert.Equal(t, 2, attempts)
+	tc.MdsMock.AssertExpectations(t)
+	tc.MdsMock.AssertNotCalled(t, "FailMessage", mock.Anything, mock.Anything, mock.Anything)
+	tc.SendCommandTaskPoolMock.AssertExpectations(t)
+}
+
+// TestLoadDocStateWithRetryStopsOnShutdown tests that the retries of a transient parse failure are abandoned once the
+// processor stops, without waiting for the backoff
+func TestLoadDocStateWithRetryStopsOnShutdown(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	proc.parseRetryCount = 2
+	proc.stopSignal = make(chan bool)
+	close(proc.stopSignal)
+
+	loadDocStateFromSendCommandOrig, parseRetryDelayOrig := loadDocStateFromSendCommand, parseRetryDelay
+	defer func() {
+		loadDocStateFromSendCommand, parseRetryDelay = loadDocStateFromSendCommandOrig, parseRetryDelayOrig
+	}()
+	parseRetryDelay = time.Hour
+	attempts := 0
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		attempts++
+		return nil, &ErrTransient{Err: fmt.Errorf("document unavailable")}
+	}
+
+	_, err := proc.loadDocStateWithRetry(proc.context, &tc.Message)
+
+	assert.Error(t, err)
+	assert.True(t, isTransientError(err))
+	assert.Equal(t, 1, attempts)
+}
+
+// TestProcessMessageDoesNotRetryMalformedPayload tests that a message whose payload can't be parsed is failed without retrying
+func TestProcessMessageDoesNotRetryMalformedPayload(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	proc.parseRetryCount = 2
+
+	loadDocStateFromSendCommandOrig, parseRetryDelayOrig := loadDocStateFromSendCommand, parseRetryDelay
+	defer func() {
+		loadDocStateFromSendCommand, parseRetryDelay = loadDocStateFromSendCommandOrig, parseRetryDelayOrig
+	}()
+	parseRetryDelay = time.Millisecond
+	attempts := 0
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		attempts++
+		return nil, &ErrMalformedPayload{Err: fmt.Errorf("unexpected end of JSON input")}
+	}
+	tc.MdsMock.On("FailMessage", mock.Anything, *tc.Message.MessageId, mock.Anything).Return(nil)
+
+	proc.processMessage(&tc.Message)
+
+	assert.Equal(t, 1, attempts)
+	tc.MdsMock.AssertExpectations(t)
+	tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+	tc.SendCommandTaskPoolMock.AssertNotCalled(t, "Submit")
+}
+
+// stubMessageQuarantine keeps the failure counts and the quarantine of the messages in a memory store
+func stubMessageQuarantine() (store *memoryStateStore, restore func()) {
+	documentStateStoreOrig := documentStateStore
+	store = newMemoryStateStore()
+	SetDocumentStateStore(store)
+	return store, func() { SetDocumentStateStore(documentStateStoreOrig) }
+}
+
+// TestProcessMessageQuarantinesRepeatedlyFailingMessage tests that a message stops being retried once it failed maxMessageFailures times
+func TestProcessMessageQuarantinesRepeatedlyFailingMessage(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	proc.maxMessageFailures = 3
+
+	store, restore := stubMessageQuarantine()
+	defer restore()
+	loadDocStateFromSendCommandOrig := loadDocStateFromSendCommand
+	defer func() { loadDocStateFromSendCommand = loadDocStateFromSendCommandOrig }()
+	attempts := 0
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		attempts++
+		return nil, &ErrMalformedPayload{Err: fmt.Errorf("invalid json")}
+	}
+	tc.MdsMock.On("FailMessage", mock.Anything, *tc.Message.MessageId, mock.Anything).Return(nil)
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.MdsMock.On("DeleteMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	replyCall := tc.MdsMock.On("SendReply", mock.Anything, *tc.Message.MessageId, mock.AnythingOfType("string")).Return(fmt.Errorf("503"))
+
+	for i := 0; i < proc.maxMessageFailures-1; i++ {
+		proc.processMessage(&tc.Message)
+	}
+	tc.MdsMock.AssertNumberOfCalls(t, "FailMessage", 2)
+	tc.MdsMock.AssertNotCalled(t, "DeleteMessage", mock.Anything, mock.Anything)
+	assert.False(t, store.IsDocumentPersisted(*tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfQuarantine))
+
+	// the message is quarantined once it reaches the threshold, but kept as long as it can't be reported failed
+	proc.processMessage(&tc.Message)
+	assert.True(t, store.IsDocumentPersisted(*tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfQuarantine))
+	tc.MdsMock.AssertNotCalled(t, "DeleteMessage", mock.Anything, mock.Anything)
+
+	// it is deleted without being parsed again once the failed reply is sent
+	replyCall.Return(nil)
+	proc.processMessage(&tc.Message)
+
+	assert.Equal(t, 3, attempts)
+	tc.MdsMock.AssertNumberOfCalls(t, "FailMessage", 2)
+	tc.MdsMock.AssertNumberOfCalls(t, "SendReply", 2)
+	tc.MdsMock.AssertNumberOfCalls(t, "DeleteMessage", 1)
+	assert.True(t, store.IsDocumentPersisted(*tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfQuarantine))
+	assert.False(t, store.IsDocumentPersisted(*tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfFailures))
+	tc.SendCommandTaskPoolMock.AssertNotCalled(t, "Submit")
+}
+
+// TestProcessMessageUploadsQuarantinedMessage tests that the metadata of a quarantined message is uploaded to the dead
+// letter bucket with its last error but without its payload, and is reported failed instead when the upload fails
+func TestProcessMessageUploadsQuarantinedMessage(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	proc.maxMessageFailures = 1
+	uploader := &stubOutputUploader{objects: map[string]string{}}
+	proc.SetDeadLetterUploader(uploader, "deadletters")
+	tc.Message.Payload = aws.String(`{"DocumentContent": `)
+
+	store, restore := stubMessageQuarantine()
+	defer restore()
+	loadDocStateFromSendCommandOrig := loadDocStateFromSendCommand
+	defer func() { loadDocStateFromSendCommand = loadDocStateFromSendCommandOrig }()
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return nil, &ErrMalformedPayload{Err: fmt.Errorf("invalid json")}
+	}
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.MdsMock.On("DeleteMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+
+	proc.processMessage(&tc.Message)
+
+	objectKey := "quarantine/" + proc.config.InstanceID + "/" + *tc.Message.MessageId + ".json"
+	assert.Equal(t, "deadletters", uploader.bucket)
+	assert.Contains(t, uploader.objects, objectKey)
+	var uploaded deadLetter
+	assert.NoError(t, json.Unmarshal([]byte(uploader.objects[objectKey]), &uploaded))
+	assert.Equal(t, *tc.Message.MessageId, uploaded.MessageID)
+	assert.Equal(t, *tc.Message.Topic, uploaded.Topic)
+	assert.NotContains(t, uploader.objects[objectKey], "DocumentContent")
+	assert.Contains(t, uploaded.LastError, "invalid json")
+	assert.True(t, store.IsDocumentPersisted(*tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfQuarantine))
+	tc.MdsMock.AssertNotCalled(t, "SendReply", mock.Anything, mock.Anything, mock.Anything)
+	tc.MdsMock.AssertNumberOfCalls(t, "DeleteMessage", 1)
+
+	// a failed upload leaves the message quarantined locally and reports it failed to the service
+	store.RemoveData(logger, *tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfQuarantine)
+	uploader.objects = map[string]string{}
+	uploader.failKey = objectKey
+	tc.MdsMock.On("SendReply", mock.Anything, *tc.Message.MessageId, mock.AnythingOfType("string")).Return(nil)
+	proc.processMessage(&tc.Message)
+	assert.Empty(t, uploader.objects)
+	assert.True(t, store.IsDocumentPersisted(*tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfQuarantine))
+	tc.MdsMock.AssertNumberOfCalls(t, "SendReply", 1)
+	tc.MdsMock.AssertNumberOfCalls(t, "DeleteMessage", 2)
+}
+
+// TestProcessMessageResetsFailuresOnSuccess tests that the failure count of a message is reset once it is processed
+func TestProcessMessageResetsFailuresOnSuccess(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	proc.maxMessageFailures = 2
+
+	store, restore := stubMessageQuarantine()
+	defer restore()
+	loadDocStateFromSendCommandOrig := loadDocStateFromSendCommand
+	defer func() { loadDocStateFromSendCommand = loadDocStateFromSendCommandOrig }()
+	fail := true
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		if fail {
+			return nil, &ErrMalformedPayload{Err: fmt.Errorf("invalid json")}
+		}
+		return mockParseSendCommand(context, msg, messagesOrchestrationRootDir)
+	}
+	tc.MdsMock.On("FailMessage", mock.Anything, *tc.Message.MessageId, mock.Anything).Return(nil)
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.SendCommandTaskPoolMock.On("Submit", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("task.Job")).Return(nil)
+
+	proc.processMessage(&tc.Message)
+	assert.True(t, store.IsDocumentPersisted(*tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfFailures))
+
+	fail = false
+	proc.processMessage(&tc.Message)
+	assert.False(t, store.IsDocumentPersisted(*tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfFailures))
+
+	// a new failure starts counting from scratch
+	fail = true
+	proc.processMessage(&tc.Message)
+	tc.MdsMock.AssertNumberOfCalls(t, "FailMessage", 2)
+	assert.False(t, store.IsDocumentPersisted(*tc.Message.MessageId, proc.config.InstanceID, appconfig.DefaultLocationOfQuarantine))
+}
+
+// TestProcessMessageWithUnsupportedPlugin tests that a document naming an unknown plugin is failed before it is acknowledged
+func TestProcessMessageWithUnsupportedPlugin(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	proc.validatePlugins = true
+
+	loadDocStateFromSendCommandOrig, isPluginSupportedOrig := loadDocStateFromSendCommand, isPluginSupported
+	defer func() {
+		loadDocStateFromSendCommand, isPluginSupported = loadDocStateFromSendCommandOrig, isPluginSupportedOrig
+	}()
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		docState := &model.DocumentState{DocumentType: model.SendCommand}
+		docState.InstancePluginsInformation = []model.PluginState{{Name: "aws:runShellScript"}, {Name: "aws:unknownPlugin"}}
+		return docState, nil
+	}
+	isPluginSupported = func(context context.T, pluginName string) (bool, string) {
+		return pluginName == "aws:runShellScript", "plugin is not registered"
+	}
+	var failure string
+	proc.sendDocLevelResponse = func(messageID string, resultStatus contracts.ResultStatus, documentTraceOutput string) {
+		assert.Equal(t, contracts.ResultStatusFailed, resultStatus)
+		failure = documentTraceOutput
+	}
+	tc.MdsMock.On("FailMessage", mock.Anything, *tc.Message.MessageId, mock.Anything).Return(nil)
+
+	proc.processMessage(&tc.Message)
+
+	assert.Contains(t, failure, "aws:unknownPlugin")
+	tc.MdsMock.AssertExpectations(t)
+	tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+	tc.SendCommandTaskPoolMock.AssertNotCalled(t, "Submit")
+	assert.False(t, *tc.IsDataPersisted)
+}
+
+// TestProcessMessageWithOversizedPayload tests that a message whose payload exceeds the maximum size is failed without being parsed
+func TestProcessMessageWithOversizedPayload(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	proc.maxMessagePayloadBytes = 16
+	tc.Message.Payload = aws.String(strings.Repeat("x", 17))
+
+	loadDocStateFromSendCommandOrig := loadDocStateFromSendCommand
+	defer func() { loadDocStateFromSendCommand = loadDocStateFromSendCommandOrig }()
+	parsed := false
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		parsed = true
+		return &model.DocumentState{DocumentType: model.SendCommand}, nil
+	}
+	var failure string
+	proc.sendDocLevelResponse = func(messageID string, resultStatus contracts.ResultStatus, documentTraceOutput string) {
+		assert.Equal(t, contracts.ResultStatusFailed, resultStatus)
+		failure = documentTraceOutput
+	}
+	metricsMock := new(MockedProcessorMetrics)
+	metricsMock.On("RecordMessageReceived").Return()
+	metricsMock.On("RecordMessageFailed", metricsReasonPayloadTooLarge).Return()
+	proc.SetMetrics(metricsMock)
+	tc.MdsMock.On("FailMessage", mock.Anything, *tc.Message.MessageId, mock.Anything).Return(nil)
+
+	proc.processMessage(&tc.Message)
+
+	assert.False(t, parsed)
+	assert.Contains(t, failure, "exceeds the maximum of 16 bytes")
+	metricsMock.AssertExpectations(t)
+	tc.MdsMock.AssertExpectations(t)
+	tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+	tc.SendCommandTaskPoolMock.AssertNotCalled(t, "Submit")
+	assert.False(t, *tc.IsDataPersisted)
+}
+
+// TestParseSendCommandMessageErrors tests the type of the errors returned while parsing a send command message
+func TestParseSendCommandMessageErrors(t *testing.T) {
+	testCase := generateTestCaseFromFiles(t, sampleMessageFiles[0], sampleMessageReplyFiles[0], "i-400e1090")
+
+	isManagedInstanceOrig := isManagedInstance
+	defer func() { isManagedInstance = isManagedInstanceOrig }()
+	isManagedInstance = func() (bool, error) {
+		return false, fmt.Errorf("unable to read registration")
+	}
+
+	_, err := parseSendCommandMessage(context.NewMockDefault(), &testCase.Msg, "")
+	assert.IsType(t, &ErrTransient{}, err)
+	assert.True(t, isTransientError(err))
+
+	invalidPayload := "{invalid"
+	testCase.Msg.Payload = &invalidPayload
+	_, err = parseSendCommandMessage(context.NewMockDefault(), &testCase.Msg, "")
+	assert.IsType(t, &ErrMalformedPayload{}, err)
+	assert.False(t, isTransientError(err))
+}
+
 // TestProcessMessage tests that processSendCommandMessage calls all the expected APIs
 // with the correct response.
 func TestProcessSendCommandMessage(t *testing.T) {
@@ -389,283 +846,2882 @@ func getPluginConfigurations(runtimeConfig map[string]*contracts.PluginConfig, o
 	return
 }
 
-func testProcessSendCommandMessage(t *testing.T, testCase TestCaseSendCommand) {
+// TestProcessSendCommandMessageRecordsMetrics tests that a successful send command is reported to the metrics sink
+func TestProcessSendCommandMessageRecordsMetrics(t *testing.T) {
+	testCase := generateTestCaseFromFiles(t, sampleMessageFiles[0], sampleMessageReplyFiles[0], "i-400e1090")
+	testCase.ReplyPayload.DocumentStatus = contracts.ResultStatusSuccess
 
-	cancelFlag := task.NewChanneledCancelFlag()
+	metricsMock := new(MockedProcessorMetrics)
+	metricsMock.On("RecordMessageProcessed", mock.AnythingOfType("time.Duration")).Return()
+	p := Processor{}
+	p.SetMetrics(metricsMock)
 
-	// method should call replyBuilder to format the response
-	replyBuilderMock := new(MockedReplyBuilder)
-	replyBuilderMock.On("BuildReply", mock.Anything, testCase.PluginResults).Return(testCase.ReplyPayload)
+	testProcessSendCommandMessageWithProcessor(t, testCase, &p)
 
-	// method should call the proper APIs on the MDS service
-	mdsMock := new(MockedMDS)
-	var replyPayload string
-	mdsMock.On("SendReply", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil).Run(func(args mock.Arguments) {
-		replyPayload = args.Get(2).(string)
-	})
-	mdsMock.On("DeleteMessage", mock.Anything, mock.AnythingOfType("string")).Return(nil)
+	metricsMock.AssertExpectations(t)
+	metricsMock.AssertNotCalled(t, "RecordMessageFailed", mock.Anything)
+	duration := metricsMock.Calls[0].Arguments.Get(0).(time.Duration)
+	assert.True(t, duration > 0)
+}
 
-	// create a mock sendResponse function
-	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
-		contextMock := context.NewMockDefault()
-		log := contextMock.Log()
-		payloadDoc := replyBuilderMock.BuildReply(pluginID, results)
-		payloadB, err := json.Marshal(payloadDoc)
-		if err != nil {
-			return
-		}
-		payload := string(payloadB)
-		// call the mock sendreply so that we can assert the reply sent
-		err = mdsMock.SendReply(log, messageID, payload)
-	}
+// TestProcessMessageWithInvalidMessageRecordsMetrics tests that rejected messages are reported to the metrics sink
+func TestProcessMessageWithInvalidMessageRecordsMetrics(t *testing.T) {
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	tc.Message.Topic = &testEmptyMessage
 
-	// method should call plugin runner with the given configuration
-	pluginRunnerMock := new(MockedPluginRunner)
-	// mock.AnythingOfType("func(string, string, map[string]*plugin.Result)")
+	metricsMock := new(MockedProcessorMetrics)
+	metricsMock.On("RecordMessageReceived").Return()
+	metricsMock.On("RecordMessageFailed", metricsReasonInvalidMessage).Return()
+	proc.SetMetrics(metricsMock)
 
-	pluginStates := converter.ConvertPluginState(testCase.PluginStates)
-	pluginRunnerMock.On("RunPlugins", mock.Anything, *testCase.Msg.MessageId, pluginStates, mock.Anything, cancelFlag).Return(testCase.PluginResults)
+	proc.processMessage(&tc.Message)
 
-	// call method under test
-	//orchestrationRootDir is set to empty such that it can meet the test expectation.
-	orchestrationRootDir := ""
-	p := Processor{}
-	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, orchestrationRootDir, pluginRunnerMock.RunPlugins, cancelFlag, replyBuilderMock.BuildReply, sendResponse, &testCase.DocState)
+	metricsMock.AssertExpectations(t)
+}
 
-	// assert that the expectations were met
-	pluginRunnerMock.AssertExpectations(t)
-	replyBuilderMock.AssertExpectations(t)
-	mdsMock.AssertExpectations(t)
+// TestProcessSendCommandMessageShutdown tests that a document interrupted by shutdown stays in the current folder
+func TestProcessSendCommandMessageShutdown(t *testing.T) {
+	testCase := generateTestCaseFromFiles(t, sampleMessageFiles[0], sampleMessageReplyFiles[0], "i-400e1090")
 
-	// check that the method sent the right reply
-	var parsedReply messageContracts.SendReplyPayload
-	err := json.Unmarshal([]byte(replyPayload), &parsedReply)
-	assert.Nil(t, err)
-	assert.Equal(t, testCase.ReplyPayload, parsedReply)
-}
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return testCase.DocState
+	}
+	var persistedLocations []string
+	var persistedInfo model.DocumentInfo
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {
+		persistedLocations = append(persistedLocations, locationFolder)
+		persistedInfo = docInfo
+	}
+	var movedTo []string
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {
+		movedTo = append(movedTo, dstLocationFolder)
+	}
 
-func createMDSMessage(commandID string, payload string, topic string, instanceID string) ssmmds.Message {
-	messageCreatedDate := time.Date(2015, 7, 9, 23, 22, 39, 19000000, time.UTC)
+	p := Processor{stopSignal: make(chan bool)}
 
-	c := sha256.New()
-	c.Write([]byte(payload))
-	payloadDigest := string(c.Sum(nil))
+	// the agent stops while the plugins are running
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		close(p.stopSignal)
+		<-shutdownSignal
+		return testCase.PluginResults
+	}
 
-	return ssmmds.Message{
-		CreatedDate:   aws.String(times.ToIso8601UTC(messageCreatedDate)),
-		Destination:   aws.String(instanceID),
-		MessageId:     aws.String("aws.ssm." + commandID + "." + instanceID),
-		Payload:       aws.String(payload),
-		PayloadDigest: aws.String(payloadDigest),
-		Topic:         aws.String(topic),
+	replyBuilderMock := new(MockedReplyBuilder)
+	replyBuilderMock.On("BuildReply", mock.Anything, testCase.PluginResults).Return(testCase.ReplyPayload)
+	mdsMock := new(MockedMDS)
+	isResponseSent := false
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		isResponseSent = true
 	}
+
+	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), replyBuilderMock.BuildReply, sendResponse, &testCase.DocState)
+
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCurrent}, persistedLocations)
+	assert.Equal(t, contracts.ResultStatusFailed, persistedInfo.DocumentStatus)
+	assert.Equal(t, shutdownTraceOutput, persistedInfo.DocumentTraceOutput)
+	assert.Empty(t, movedTo)
+	assert.False(t, isResponseSent)
+	mdsMock.AssertNotCalled(t, "DeleteMessage", mock.Anything, mock.Anything)
 }
 
-// getMessagesOutput wraps an MDS message into a GetMessagesOutput struct.
-func getMessagesOutput(m *ssmmds.Message) ssmmds.GetMessagesOutput {
-	uuid.SwitchFormat(uuid.CleanHyphen)
-	requestID := uuid.NewV4().String()
-	return ssmmds.GetMessagesOutput{
-		Destination:       m.Destination,
-		Messages:          []*ssmmds.Message{m},
-		MessagesRequestId: aws.String(requestID),
+// TestProcessSendCommandMessageReportsProgress tests that partial results are sent while the plugins of a document run
+func TestProcessSendCommandMessageReportsProgress(t *testing.T) {
+	var docState model.DocumentState
+	docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+	for _, name := range []string{"plugin1", "plugin2", "plugin3"} {
+		docState.InstancePluginsInformation = append(docState.InstancePluginsInformation, model.PluginState{Name: name, Id: name})
 	}
-}
 
-// TestProcessCancelCommandMessage tests that processCancelCommandMessage calls all the expected APIs
-// on receiving a cancel message.
-func TestProcessCancelCommandMessage(t *testing.T) {
-	testCase := TestCaseCancelCommand{
-		MsgToCancelID: uuid.NewV4().String(),
-		MsgID:         uuid.NewV4().String(),
-		InstanceID:    "i-400e1090",
+	var interimLock sync.Mutex
+	interimState := docState
+	interimState.InstancePluginsInformation = append([]model.PluginState{}, docState.InstancePluginsInformation...)
+
+	progressReportIntervalOrig := progressReportInterval
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		progressReportInterval = progressReportIntervalOrig
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	progressReportInterval = 5 * time.Millisecond
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		interimLock.Lock()
+		defer interimLock.Unlock()
+		state := interimState
+		state.InstancePluginsInformation = append([]model.PluginState{}, interimState.InstancePluginsInformation...)
+		return state
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {}
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {}
+
+	// each plugin persists its result into the interim state once it completes
+	pluginResults := make(map[string]*contracts.PluginResult)
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		for i, plugin := range plugins {
+			result := contracts.PluginResult{PluginName: plugin.Name, Status: contracts.ResultStatusSuccess}
+			pluginResults[plugin.Id] = &result
+			interimLock.Lock()
+			interimState.InstancePluginsInformation[i].Result = result
+			interimLock.Unlock()
+			time.Sleep(50 * time.Millisecond)
+		}
+		return pluginResults
+	}
+
+	replyBuilderMock := new(MockedReplyBuilder)
+	replyBuilderMock.On("BuildReply", mock.Anything, mock.Anything).Return(messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccess})
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, docState.DocumentInformation.MessageID).Return(nil)
+	var responseLock sync.Mutex
+	var completedCounts []int
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		responseLock.Lock()
+		defer responseLock.Unlock()
+		completed := 0
+		for _, result := range results {
+			if result.Status == contracts.ResultStatusSuccess {
+				completed++
+			}
+		}
+		completedCounts = append(completedCounts, completed)
+	}
+
+	p := Processor{stopSignal: make(chan bool)}
+	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), replyBuilderMock.BuildReply, sendResponse, &docState)
+
+	// one reply after the first and second plugins, and the final reply with all the plugins
+	assert.Equal(t, []int{1, 2, 3}, completedCounts)
+	mdsMock.AssertExpectations(t)
+}
+
+// TestProcessSendCommandMessageExternalMessageDeletion tests that the message of a document with a registered plugin is not deleted
+func TestProcessSendCommandMessageExternalMessageDeletion(t *testing.T) {
+	RegisterExternalMessageDeletionPlugin("aws:selfUpdatingPlugin")
+	defer func() {
+		externalMessageDeletionLock.Lock()
+		delete(externalMessageDeletionPlugins, "aws:selfUpdatingPlugin")
+		externalMessageDeletionLock.Unlock()
+	}()
+
+	var docState model.DocumentState
+	docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+	docState.InstancePluginsInformation = []model.PluginState{{Name: "aws:selfUpdatingPlugin", Id: "aws:selfUpdatingPlugin"}}
+
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return docState
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {}
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {}
+
+	pluginResults := map[string]*contracts.PluginResult{"aws:selfUpdatingPlugin": {Status: contracts.ResultStatusSuccess}}
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		return pluginResults
+	}
+	replyBuilderMock := new(MockedReplyBuilder)
+	replyBuilderMock.On("BuildReply", mock.Anything, pluginResults).Return(messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccess})
+	mdsMock := new(MockedMDS)
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {}
+
+	p := Processor{stopSignal: make(chan bool)}
+	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), replyBuilderMock.BuildReply, sendResponse, &docState)
+
+	mdsMock.AssertNotCalled(t, "DeleteMessage", mock.Anything, mock.Anything)
+}
+
+// TestProcessSendCommandMessageRebootLimit tests that a document that keeps requesting reboots is failed once it exceeds the limit
+func TestProcessSendCommandMessageRebootLimit(t *testing.T) {
+	var docState model.DocumentState
+	docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+	docState.InstancePluginsInformation = []model.PluginState{{Name: "aws:runShellScript", Id: "aws:runShellScript"}}
+
+	// the interim state persisted in the current folder survives the reboots
+	persisted := docState
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return persisted
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {
+		persisted.DocumentInformation = docInfo
+	}
+	var movedTo []string
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {
+		movedTo = append(movedTo, dstLocationFolder)
+	}
+
+	// the plugin requests a reboot every time it runs
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		return map[string]*contracts.PluginResult{"aws:runShellScript": {Status: contracts.ResultStatusSuccessAndReboot}}
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: results["aws:runShellScript"].Status}
+	}
+	var replied []contracts.ResultStatus
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		replied = append(replied, results["aws:runShellScript"].Status)
+	}
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, docState.DocumentInformation.MessageID).Return(nil)
+
+	p := Processor{stopSignal: make(chan bool), maxDocumentReboots: 2}
+	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, &docState)
+	for i := 0; i < 2; i++ {
+		p.runCmdsUsingCmdState(context.NewMockDefault(), mdsMock, runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, persisted)
+	}
+
+	assert.Equal(t, 3, persisted.DocumentInformation.RebootCount)
+	assert.Equal(t, contracts.ResultStatusFailed, persisted.DocumentInformation.DocumentStatus)
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCompleted}, movedTo)
+	assert.Equal(t, []contracts.ResultStatus{contracts.ResultStatusSuccessAndReboot, contracts.ResultStatusSuccessAndReboot, contracts.ResultStatusFailed}, replied)
+	mdsMock.AssertNumberOfCalls(t, "DeleteMessage", 1)
+}
+
+// TestProcessSendCommandMessageRebootRequestedBy tests that the plugin requesting a reboot is persisted with the document
+func TestProcessSendCommandMessageRebootRequestedBy(t *testing.T) {
+	var docState model.DocumentState
+	docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Name: "aws:runShellScript", Id: "plugin1"},
+		{Name: "aws:configurePackage", Id: "plugin2"},
+		{Name: "aws:runShellScript", Id: "plugin3"},
+	}
+
+	persisted := docState
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return persisted
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {
+		persisted.DocumentInformation = docInfo
+	}
+	var movedTo []string
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {
+		movedTo = append(movedTo, dstLocationFolder)
+	}
+
+	// the second plugin requests a reboot, so the third one doesn't run yet
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		return map[string]*contracts.PluginResult{
+			"plugin1": {Status: contracts.ResultStatusSuccess},
+			"plugin2": {Status: contracts.ResultStatusSuccessAndReboot},
+		}
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccessAndReboot}
+	}
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {}
+
+	p := Processor{stopSignal: make(chan bool)}
+	p.processSendCommandMessage(context.NewMockDefault(), new(MockedMDS), "", runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, &docState)
+
+	assert.Equal(t, "plugin2", persisted.DocumentInformation.RebootRequestedBy)
+	assert.Equal(t, contracts.ResultStatusSuccessAndReboot, persisted.DocumentInformation.DocumentStatus)
+	assert.Empty(t, movedTo)
+}
+
+// TestProcessSendCommandMessageDeadline tests that a document whose plugins outlast the deadline is timed out and completed,
+// keeping the results of the plugins that completed in time
+func TestProcessSendCommandMessageDeadline(t *testing.T) {
+	var docState model.DocumentState
+	docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Name: "aws:runShellScript", Id: "plugin1"},
+		{Name: "aws:runShellScript", Id: "plugin2"},
+	}
+
+	persisted := docState
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return persisted
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {
+		persisted.DocumentInformation = docInfo
+	}
+	var movedTo []string
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {
+		movedTo = append(movedTo, dstLocationFolder)
+	}
+
+	// the first plugin completes right away, the second one runs until it is cancelled
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		outputs := map[string]*contracts.PluginResult{"plugin1": {Status: contracts.ResultStatusSuccess, Output: "done"}}
+		cancelFlag.Wait()
+		outputs["plugin2"] = &contracts.PluginResult{Status: contracts.ResultStatusCancelled, Output: "partial"}
+		return outputs
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: results["plugin2"].Status}
+	}
+	var replied map[string]*contracts.PluginResult
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		replied = results
+	}
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, docState.DocumentInformation.MessageID).Return(nil)
+
+	p := Processor{stopSignal: make(chan bool), maxDocumentRuntime: 50 * time.Millisecond}
+	cancelFlag := task.NewChanneledCancelFlag()
+	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, cancelFlag, buildReply, sendResponse, &docState)
+
+	assert.True(t, cancelFlag.Canceled())
+	assert.Equal(t, contracts.ResultStatusTimedOut, persisted.DocumentInformation.DocumentStatus)
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCompleted}, movedTo)
+	assert.Equal(t, contracts.ResultStatusSuccess, replied["plugin1"].Status)
+	assert.Equal(t, "done", replied["plugin1"].Output)
+	assert.Equal(t, contracts.ResultStatusTimedOut, replied["plugin2"].Status)
+	assert.Equal(t, "partial", replied["plugin2"].Output)
+	mdsMock.AssertNumberOfCalls(t, "DeleteMessage", 1)
+}
+
+// TestTimeOutDocument tests that only the plugins interrupted by the deadline are timed out and that the document status
+// is the status the reply aggregates
+func TestTimeOutDocument(t *testing.T) {
+	outputs := map[string]*contracts.PluginResult{
+		"success":   {Status: contracts.ResultStatusSuccess},
+		"failed":    {Status: contracts.ResultStatusFailed},
+		"reboot":    {Status: contracts.ResultStatusSuccessAndReboot},
+		"passed":    {Status: contracts.ResultStatusPassedAndReboot},
+		"skipped":   {Status: contracts.ResultStatusSkipped},
+		"running":   {Status: contracts.ResultStatusInProgress},
+		"pending":   {Status: contracts.ResultStatusNotStarted},
+		"cancelled": {Status: contracts.ResultStatusCancelled, Output: "partial"},
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusFailed}
+	}
+	var docState model.DocumentState
+
+	p := Processor{maxDocumentRuntime: time.Minute}
+	p.timeOutDocument(&docState, outputs, buildReply)
+
+	for _, pluginID := range []string{"success", "failed", "reboot", "passed", "skipped"} {
+		assert.NotEqual(t, contracts.ResultStatusTimedOut, outputs[pluginID].Status, pluginID)
+	}
+	for _, pluginID := range []string{"running", "pending", "cancelled"} {
+		assert.Equal(t, contracts.ResultStatusTimedOut, outputs[pluginID].Status, pluginID)
+	}
+	assert.Equal(t, "partial", outputs["cancelled"].Output)
+	assert.Equal(t, contracts.ResultStatusFailed, docState.DocumentInformation.DocumentStatus)
+}
+
+// TestProcessSendCommandMessageGlobalEnvironment tests that the global environment is added to the configuration of the plugins
+// without overriding the variables set by the document
+func TestProcessSendCommandMessageGlobalEnvironment(t *testing.T) {
+	var docState model.DocumentState
+	docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Name: "aws:runShellScript", Id: "plugin1"},
+		{Name: "aws:runShellScript", Id: "plugin2", Configuration: contracts.Configuration{
+			Environment: map[string]string{"HTTPS_PROXY": "http://document-proxy:3128"},
+		}},
+	}
+
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return docState
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {}
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {}
+
+	environments := make(map[string]map[string]string)
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		outputs := make(map[string]*contracts.PluginResult)
+		for _, plugin := range plugins {
+			environments[plugin.Id] = plugin.Configuration.Environment
+			outputs[plugin.Id] = &contracts.PluginResult{Status: contracts.ResultStatusSuccess}
+		}
+		return outputs
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccess}
+	}
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {}
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, docState.DocumentInformation.MessageID).Return(nil)
+
+	p := Processor{stopSignal: make(chan bool), globalEnvironment: map[string]string{
+		"HTTPS_PROXY":   "http://proxy:3128",
+		"SSL_CERT_FILE": "/etc/pki/corp-ca.pem",
+	}}
+	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, &docState)
+
+	assert.Equal(t, map[string]string{"HTTPS_PROXY": "http://proxy:3128", "SSL_CERT_FILE": "/etc/pki/corp-ca.pem"}, environments["plugin1"])
+	assert.Equal(t, map[string]string{"HTTPS_PROXY": "http://document-proxy:3128", "SSL_CERT_FILE": "/etc/pki/corp-ca.pem"}, environments["plugin2"])
+}
+
+// stubCompletionNotifier records the completions it is notified of and fails with err
+type stubCompletionNotifier struct {
+	completions []DocumentCompletion
+	err         error
+}
+
+func (n *stubCompletionNotifier) NotifyDocumentCompleted(completion DocumentCompletion) error {
+	n.completions = append(n.completions, completion)
+	return n.err
+}
+
+// TestProcessSendCommandMessageNotifiesCompletion tests that the notifier is called once per completed document,
+// and that a notification failure doesn't stop the processing of the document
+func TestProcessSendCommandMessageNotifiesCompletion(t *testing.T) {
+	for _, tst := range []struct {
+		status        contracts.ResultStatus
+		notifications int
+	}{
+		{contracts.ResultStatusSuccess, 1},
+		{contracts.ResultStatusFailed, 1},
+		// the document isn't over until it is resumed after the reboot
+		{contracts.ResultStatusSuccessAndReboot, 0},
+	} {
+		var docState model.DocumentState
+		docState.DocumentInformation.DocumentID = "aws.ssm.1234.i-400e1090"
+		docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+		docState.InstancePluginsInformation = []model.PluginState{{Name: "aws:runShellScript", Id: "plugin1"}}
+
+		getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+		getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+			return docState
+		}
+		persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {}
+		moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {}
+
+		runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+			return map[string]*contracts.PluginResult{"plugin1": {Status: tst.status}}
+		}
+		status := tst.status
+		buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+			return messageContracts.SendReplyPayload{DocumentStatus: status}
+		}
+		sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {}
+		mdsMock := new(MockedMDS)
+		mdsMock.On("DeleteMessage", mock.Anything, docState.DocumentInformation.MessageID).Return(nil)
+
+		notifier := &stubCompletionNotifier{err: fmt.Errorf("connection refused")}
+		p := Processor{stopSignal: make(chan bool)}
+		p.SetCompletionNotifier(notifier)
+		p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, &docState)
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+
+		assert.Len(t, notifier.completions, tst.notifications, string(tst.status))
+		if tst.notifications > 0 {
+			assert.Equal(t, docState.DocumentInformation.DocumentID, notifier.completions[0].DocumentID)
+			assert.Equal(t, tst.status, notifier.completions[0].Status)
+			mdsMock.AssertExpectations(t)
+		}
+	}
+}
+
+// TestProcessCancelCommandMessageNotifiesCompletion tests that the notifier is called once per completed cancel document
+func TestProcessCancelCommandMessageNotifiesCompletion(t *testing.T) {
+	cancelMessagePayload := messageContracts.CancelPayload{
+		CancelMessageID: "aws.ssm." + uuid.NewV4().String() + ".i-foreign",
+	}
+	msgContent, err := jsonutil.Marshal(cancelMessagePayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdsCancelMessage := createMDSMessage(uuid.NewV4().String(), msgContent, "aws.ssm.cancelCommand.us.east.1.1", "i-400e1090")
+
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, *mdsCancelMessage.MessageId).Return(nil)
+	docState := initializeCancelCommandState(mdsCancelMessage, cancelMessagePayload)
+
+	notifier := &stubCompletionNotifier{}
+	p := Processor{}
+	p.SetCompletionNotifier(notifier)
+	p.processCancelCommandMessage(context.NewMockDefault(), mdsMock, new(task.MockedPool), &docState)
+
+	assert.Equal(t, []DocumentCompletion{{
+		DocumentID:     docState.DocumentInformation.DocumentID,
+		Status:         contracts.ResultStatusFailed,
+		DurationMillis: notifier.completions[0].DurationMillis,
+	}}, notifier.completions)
+}
+
+// stubAuditSink records the audit records written to it
+type stubAuditSink struct {
+	records []AuditRecord
+}
+
+func (s *stubAuditSink) WriteAuditRecord(record AuditRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+// TestProcessSendCommandMessageAuditsCompletion tests that one audit record is written per completed document
+func TestProcessSendCommandMessageAuditsCompletion(t *testing.T) {
+	var docState model.DocumentState
+	docState.DocumentInformation.DocumentID = "aws.ssm.1234.i-400e1090"
+	docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+	docState.DocumentInformation.CommandID = "1234"
+	docState.DocumentInformation.InstanceID = "i-400e1090"
+	docState.DocumentInformation.DocumentName = "AWS-RunShellScript"
+	docState.InstancePluginsInformation = []model.PluginState{{
+		Name:   "aws:runShellScript",
+		Id:     "plugin1",
+		Result: contracts.PluginResult{Status: contracts.ResultStatusFailed, Code: 2},
+	}}
+
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return docState
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {}
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {}
+
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		return map[string]*contracts.PluginResult{"plugin1": {Status: contracts.ResultStatusFailed, Code: 2}}
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusFailed}
+	}
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {}
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, docState.DocumentInformation.MessageID).Return(nil)
+
+	sink := &stubAuditSink{}
+	p := Processor{stopSignal: make(chan bool)}
+	p.SetAuditSink(sink)
+	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, &docState)
+
+	if assert.Len(t, sink.records, 1) {
+		record := sink.records[0]
+		assert.Equal(t, "i-400e1090", record.InstanceID)
+		assert.Equal(t, "1234", record.CommandID)
+		assert.Equal(t, docState.DocumentInformation.DocumentID, record.DocumentID)
+		assert.Equal(t, "AWS-RunShellScript", record.DocumentName)
+		assert.Equal(t, contracts.ResultStatusFailed, record.Status)
+		assert.False(t, record.Time.IsZero())
+		assert.Equal(t, []AuditPluginRecord{{ID: "plugin1", Name: "aws:runShellScript", Status: contracts.ResultStatusFailed, Code: 2}}, record.Plugins)
+	}
+	assert.Nil(t, newAuditSink(context.NewMockDefault().Log(), false))
+}
+
+// TestWebhookNotifier tests that the completions are posted as json, and that error answers are reported
+func TestWebhookNotifier(t *testing.T) {
+	var received DocumentCompletion
+	statusCode := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+
+	assert.Nil(t, newWebhookNotifier(""))
+	notifier := newWebhookNotifier(server.URL)
+	completion := DocumentCompletion{DocumentID: "aws.ssm.1234.i-400e1090", Status: contracts.ResultStatusSuccess, DurationMillis: 1500}
+	assert.NoError(t, notifier.NotifyDocumentCompleted(completion))
+	assert.Equal(t, completion, received)
+
+	statusCode = http.StatusInternalServerError
+	assert.Error(t, notifier.NotifyDocumentCompleted(completion))
+}
+
+// TestProcessSendCommandMessageReplyToDeleteDelay tests that the message of a completed document is deleted only once
+// the delay following its reply has elapsed
+func TestProcessSendCommandMessageReplyToDeleteDelay(t *testing.T) {
+	var docState model.DocumentState
+	docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+	docState.InstancePluginsInformation = []model.PluginState{{Name: "aws:runShellScript", Id: "plugin1"}}
+
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return docState
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {}
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {}
+
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		return map[string]*contracts.PluginResult{"plugin1": {Status: contracts.ResultStatusSuccess}}
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccess}
+	}
+	replied := make(chan bool, 1)
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		replied <- true
+	}
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, docState.DocumentInformation.MessageID).Return(nil)
+
+	delay := 2 * time.Second
+	delayElapsed := make(chan struct{})
+	clock := times.NewMockedClock()
+	clock.On("After", delay).Return(delayElapsed)
+	p := Processor{stopSignal: make(chan bool), clock: clock, replyToDeleteDelay: delay}
+
+	done := make(chan bool)
+	go func() {
+		p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, &docState)
+		close(done)
+	}()
+
+	<-replied
+	select {
+	case <-done:
+		t.Fatal("message deleted before the delay elapsed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	mdsMock.AssertNotCalled(t, "DeleteMessage", mock.Anything, mock.Anything)
+
+	delayElapsed <- struct{}{}
+	<-done
+	clock.AssertExpectations(t)
+	mdsMock.AssertExpectations(t)
+}
+
+// TestWaitBeforeMessageDeletionShutdown tests that the wait before the deletion of a message is cut short by shutdown
+func TestWaitBeforeMessageDeletionShutdown(t *testing.T) {
+	clock := times.NewMockedClock()
+	clock.On("After", time.Minute).Return(make(chan struct{}))
+	p := Processor{stopSignal: make(chan bool), clock: clock, replyToDeleteDelay: time.Minute}
+	close(p.stopSignal)
+
+	p.waitBeforeMessageDeletion(log.NewMockLog())
+	clock.AssertExpectations(t)
+}
+
+// TestProcessSendCommandMessageCorrelationID tests that every plugin output sent for a document carries the ID of its message
+func TestProcessSendCommandMessageCorrelationID(t *testing.T) {
+	var docState model.DocumentState
+	docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Name: "aws:runShellScript", Id: "plugin1"},
+		{Name: "aws:runPowerShellScript", Id: "plugin2"},
+	}
+
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+	}()
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return docState
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {}
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {}
+
+	// each plugin reports its own result before the document completes
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		outputs := make(map[string]*contracts.PluginResult)
+		for _, plugin := range plugins {
+			outputs[plugin.Id] = &contracts.PluginResult{PluginName: plugin.Name, Status: contracts.ResultStatusSuccess}
+			sendResponse(documentID, plugin.Id, map[string]*contracts.PluginResult{plugin.Id: outputs[plugin.Id]})
+		}
+		return outputs
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccess}
+	}
+	var sent []contracts.PluginResult
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		for _, result := range results {
+			sent = append(sent, *result)
+		}
+	}
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, docState.DocumentInformation.MessageID).Return(nil)
+
+	p := Processor{stopSignal: make(chan bool)}
+	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, &docState)
+
+	// one reply per plugin, then the final reply with both plugins
+	assert.Equal(t, 4, len(sent))
+	for _, result := range sent {
+		assert.Equal(t, docState.DocumentInformation.MessageID, result.CorrelationID)
+	}
+}
+
+// TestProcessSendCommandMessageAggregationPolicy tests that the status of a document is computed with its aggregation policy,
+// both in the persisted state and in the replies
+func TestProcessSendCommandMessageAggregationPolicy(t *testing.T) {
+	for _, tst := range []struct {
+		policy string
+		status contracts.ResultStatus
+	}{
+		{"", contracts.ResultStatusFailed},
+		{contracts.AggregationPolicyAllMustSucceed, contracts.ResultStatusFailed},
+		{contracts.AggregationPolicyAnySucceeds, contracts.ResultStatusSuccess},
+	} {
+		var docState model.DocumentState
+		docState.DocumentInformation.MessageID = "aws.ssm.1234.i-400e1090"
+		docState.DocumentInformation.AggregationPolicy = tst.policy
+		docState.InstancePluginsInformation = []model.PluginState{
+			{Name: "aws:runShellScript", Id: "plugin1"},
+			{Name: "aws:runShellScript", Id: "plugin2"},
+		}
+
+		persisted := docState
+		getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig := getDocumentInterimState, persistDocumentInfo, moveDocumentState
+		getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+			return persisted
+		}
+		persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {
+			persisted.DocumentInformation = docInfo
+		}
+		moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {}
+
+		runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+			return map[string]*contracts.PluginResult{
+				"plugin1": {PluginName: "aws:runShellScript", Status: contracts.ResultStatusSuccess},
+				"plugin2": {PluginName: "aws:runShellScript", Status: contracts.ResultStatusFailed},
+			}
+		}
+		buildReply := newReplyBuilder(logger, times.DefaultClock, contracts.AgentInfo{}, appconfig.DefaultMaxPluginOutputBytes, false)
+		p := Processor{stopSignal: make(chan bool), aggregationPolicies: newAggregationPolicies(false)}
+		var replied contracts.ResultStatus
+		sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+			payload := buildReply(pluginID, results)
+			p.aggregationPolicies.apply(messageID, &payload)
+			replied = payload.DocumentStatus
+		}
+		mdsMock := new(MockedMDS)
+		mdsMock.On("DeleteMessage", mock.Anything, docState.DocumentInformation.MessageID).Return(nil)
+
+		p.processSendCommandMessage(context.NewMockDefault(), mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, &docState)
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState = getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig
+
+		assert.Equal(t, tst.status, persisted.DocumentInformation.DocumentStatus, tst.policy)
+		assert.Equal(t, tst.status, replied, tst.policy)
+		assert.Empty(t, p.aggregationPolicies.policies)
+	}
+}
+
+// TestValidateAggregationPolicy tests that documents with an unknown aggregation policy are rejected
+func TestValidateAggregationPolicy(t *testing.T) {
+	assert.NoError(t, validateAggregationPolicy(""))
+	assert.NoError(t, validateAggregationPolicy(contracts.AggregationPolicyAllMustSucceed))
+	assert.NoError(t, validateAggregationPolicy(contracts.AggregationPolicyAnySucceeds))
+	assert.Error(t, validateAggregationPolicy("MostSucceed"))
+}
+
+// TestReplyBuilderTruncatesOutput tests that an oversized plugin output is truncated in the reply but not on disk
+func TestReplyBuilderTruncatesOutput(t *testing.T) {
+	orchestrationRootDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationRootDir)
+
+	// the plugin writes its full output to the orchestration directory
+	output := strings.Repeat("x", appconfig.DefaultMaxPluginOutputBytesMin*2)
+	stdoutPath := filepath.Join(orchestrationRootDir, "awsrunShellScript", "stdout")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(stdoutPath), 0700))
+	assert.NoError(t, ioutil.WriteFile(stdoutPath, []byte(output), 0600))
+	results := map[string]*contracts.PluginResult{
+		"aws:runShellScript": {PluginName: "aws:runShellScript", Status: contracts.ResultStatusSuccess, Output: output},
+	}
+
+	buildReply := newReplyBuilder(logger, times.DefaultClock, contracts.AgentInfo{}, appconfig.DefaultMaxPluginOutputBytesMin, false)
+	payload := buildReply("", results)
+
+	replied := payload.RuntimeStatus["aws:runShellScript"].Output
+	assert.Equal(t, output[:appconfig.DefaultMaxPluginOutputBytesMin], replied[:appconfig.DefaultMaxPluginOutputBytesMin])
+	assert.True(t, strings.HasSuffix(replied, fmt.Sprintf("[truncated %v bytes]", appconfig.DefaultMaxPluginOutputBytesMin)))
+	// neither the plugin result kept in the document state nor the file on disk is truncated
+	assert.Equal(t, output, results["aws:runShellScript"].Output)
+	onDisk, err := ioutil.ReadFile(stdoutPath)
+	assert.NoError(t, err)
+	assert.Equal(t, output, string(onDisk))
+}
+
+// TestReplyBuilderWithoutOutputCap tests that the outputs are replied whole by default
+func TestReplyBuilderWithoutOutputCap(t *testing.T) {
+	output := strings.Repeat("x", maxReplyOutputBytes*2)
+	results := map[string]*contracts.PluginResult{
+		"aws:runShellScript": {PluginName: "aws:runShellScript", Status: contracts.ResultStatusSuccess, Output: output},
+	}
+
+	buildReply := newReplyBuilder(logger, times.DefaultClock, contracts.AgentInfo{}, appconfig.DefaultMaxPluginOutputBytes, false)
+	payload := buildReply("", results)
+
+	assert.Equal(t, output, payload.RuntimeStatus["aws:runShellScript"].Output)
+}
+
+// TestBuildReplySkippedPlugins tests that a document whose plugins either succeeded or were skipped is reported as
+// succeeded, with the skipped plugins reported as such
+func TestBuildReplySkippedPlugins(t *testing.T) {
+	results := map[string]*contracts.PluginResult{
+		"aws:runShellScript":      {PluginName: "aws:runShellScript", Status: contracts.ResultStatusSuccess},
+		"aws:runPowerShellScript": {PluginName: "aws:runPowerShellScript", Status: contracts.ResultStatusSkipped},
+		"aws:configurePackage":    {PluginName: "aws:configurePackage", Status: contracts.ResultStatusSuccess},
+	}
+
+	buildReply := newReplyBuilder(log.NewMockLog(), times.DefaultClock, contracts.AgentInfo{}, appconfig.DefaultMaxPluginOutputBytes, false)
+	payload := buildReply("", results)
+
+	assert.Equal(t, contracts.ResultStatusSuccess, payload.DocumentStatus)
+	assert.Equal(t, map[string]int{string(contracts.ResultStatusSuccess): 2, string(contracts.ResultStatusSkipped): 1}, payload.AdditionalInfo.RuntimeStatusCounts)
+	assert.Equal(t, contracts.ResultStatusSkipped, payload.RuntimeStatus["aws:runPowerShellScript"].Status)
+
+	// the policies of the documents aggregate the skipped plugins alike
+	aggregateReplyStatus(contracts.AggregationPolicyAnySucceeds, false, &payload)
+	assert.Equal(t, contracts.ResultStatusSuccess, payload.DocumentStatus)
+}
+
+// TestBuildReplySkippedPluginsFailDocument tests that the skipped plugins fail the document when the agent is
+// configured to count them as failed
+func TestBuildReplySkippedPluginsFailDocument(t *testing.T) {
+	results := map[string]*contracts.PluginResult{
+		"aws:runShellScript":      {PluginName: "aws:runShellScript", Status: contracts.ResultStatusSuccess},
+		"aws:runPowerShellScript": {PluginName: "aws:runPowerShellScript", Status: contracts.ResultStatusSkipped},
+	}
+
+	buildReply := newReplyBuilder(log.NewMockLog(), times.DefaultClock, contracts.AgentInfo{}, appconfig.DefaultMaxPluginOutputBytes, true)
+	payload := buildReply("", results)
+	assert.Equal(t, contracts.ResultStatusFailed, payload.DocumentStatus)
+
+	// a policy where any plugin must succeed still succeeds
+	policies := newAggregationPolicies(true)
+	defer policies.set("messageID", contracts.AggregationPolicyAnySucceeds)()
+	policies.apply("messageID", &payload)
+	assert.Equal(t, contracts.ResultStatusSuccess, payload.DocumentStatus)
+}
+
+// TestCancelAll tests that both a running document and a document waiting for a worker end in Completed as cancelled
+func TestCancelAll(t *testing.T) {
+	getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig, isDocumentPersistedOrig :=
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState, isDocumentPersisted
+	defer func() {
+		getDocumentInterimState, persistDocumentInfo, moveDocumentState, isDocumentPersisted =
+			getDocumentInterimStateOrig, persistDocumentInfoOrig, moveDocumentStateOrig, isDocumentPersistedOrig
+	}()
+
+	var lock sync.Mutex
+	statuses := make(map[string]contracts.ResultStatus)
+	completed := make(map[string]bool)
+	isDocumentPersisted = func(commandID, instanceID, locationFolder string) bool {
+		return false
+	}
+	getDocumentInterimState = func(log log.T, commandID, instanceID, locationFolder string) model.DocumentState {
+		return model.DocumentState{DocumentInformation: model.DocumentInfo{DocumentID: commandID}}
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, commandID, instanceID, locationFolder string) {
+		lock.Lock()
+		defer lock.Unlock()
+		statuses[commandID] = docInfo.DocumentStatus
+	}
+	moveDocumentState = func(log log.T, commandID, instanceID, srcLocationFolder, dstLocationFolder string) {
+		lock.Lock()
+		defer lock.Unlock()
+		if dstLocationFolder == appconfig.DefaultLocationOfCompleted {
+			completed[commandID] = true
+		}
+	}
+
+	// the running document only returns once it is cancelled
+	started := make(chan bool)
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		close(started)
+		cancelFlag.Wait()
+		return map[string]*contracts.PluginResult{"aws:runShellScript": {Status: contracts.ResultStatusCancelled}}
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: results["aws:runShellScript"].Status}
+	}
+	var replied []contracts.ResultStatus
+	sendDocLevelResponse := func(messageID string, status contracts.ResultStatus, documentTraceOutput string) {
+		lock.Lock()
+		defer lock.Unlock()
+		replied = append(replied, status)
+	}
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, mock.Anything).Return(nil)
+
+	ctx := context.NewMockDefault()
+	p := Processor{
+		context:              ctx,
+		service:              mdsMock,
+		pluginRunner:         runPlugins,
+		buildReply:           buildReply,
+		sendResponse:         func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {},
+		sendDocLevelResponse: sendDocLevelResponse,
+		stopSignal:           make(chan bool),
+		sendCommandPool:      task.NewPool(ctx.Log(), 1, time.Second, times.DefaultClock),
+		cancelCommandPool:    task.NewPool(ctx.Log(), 1, time.Second, times.DefaultClock),
+	}
+
+	newDocState := func(commandID string) *model.DocumentState {
+		docState := &model.DocumentState{DocumentType: model.SendCommand}
+		docState.DocumentInformation.DocumentID = commandID
+		docState.DocumentInformation.MessageID = "aws.ssm." + commandID + ".i-400e1090"
+		docState.InstancePluginsInformation = []model.PluginState{{Name: "aws:runShellScript", Id: "aws:runShellScript"}}
+		return docState
+	}
+	p.ExecutePendingDocument(newDocState("running"))
+	<-started
+	// the single worker is busy, so the second document waits in the pool
+	go p.ExecutePendingDocument(newDocState("queued"))
+	assert.True(t, waitFor(func() bool {
+		p.inFlightLock.Lock()
+		defer p.inFlightLock.Unlock()
+		return len(p.inFlightDocuments) == 2
+	}))
+
+	p.CancelAll()
+	p.CancelAll()
+
+	assert.True(t, waitFor(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(completed) == 2
+	}))
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, contracts.ResultStatusCancelled, statuses["running"])
+	assert.Equal(t, contracts.ResultStatusCancelled, statuses["queued"])
+	assert.Equal(t, []contracts.ResultStatus{contracts.ResultStatusFailed}, replied)
+	mdsMock.AssertNumberOfCalls(t, "DeleteMessage", 2)
+}
+
+// waitFor polls the condition until it holds or a few seconds elapsed
+func waitFor(condition func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+// TestCompactCompletedDocument tests that the artifacts of a completed document are compacted into one readable archive
+func TestCompactCompletedDocument(t *testing.T) {
+	orchestrationRootDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationRootDir)
+
+	commandID := "2b196342-d7d4-436e-8f09-3883a1116ac3"
+	pluginDir := filepath.Join(orchestrationRootDir, commandID, "awsrunShellScript")
+	assert.NoError(t, os.MkdirAll(pluginDir, 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(pluginDir, "stdout"), []byte("hello"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(pluginDir, "stderr"), []byte("world"), 0600))
+	downloadsDir := filepath.Join(orchestrationRootDir, commandID, "awsconfigurePackage", appconfig.PluginDownloadsFolderName)
+	assert.NoError(t, os.MkdirAll(downloadsDir, 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(downloadsDir, "PVDriver.zip"), []byte("archive"), 0600))
+
+	docState := model.DocumentState{DocumentInformation: model.DocumentInfo{CommandID: commandID}}
+	p := Processor{compactOrchestration: true}
+	p.compactCompletedDocument(logger, orchestrationRootDir, docState)
+
+	// a single archive replaces the orchestration directory of the document
+	entries, err := ioutil.ReadDir(orchestrationRootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, commandID+compactedArtifactsExtension, entries[0].Name())
+
+	archivePath := getCompactedArtifactsPath(filepath.Join(orchestrationRootDir, commandID))
+	stdout, err := fileutil.ReadArchivedFile(archivePath, filepath.Join("awsrunShellScript", "stdout"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(stdout))
+	stderr, err := fileutil.ReadArchivedFile(archivePath, filepath.Join("awsrunShellScript", "stderr"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(stderr))
+	// the downloaded packages are not kept in the archive
+	_, err = fileutil.ReadArchivedFile(archivePath, filepath.Join("awsconfigurePackage", appconfig.PluginDownloadsFolderName, "PVDriver.zip"))
+	assert.Error(t, err)
+}
+
+// TestCompactCompletedDocumentDisabled tests that documents are left as is when compaction is disabled
+func TestCompactCompletedDocumentDisabled(t *testing.T) {
+	orchestrationRootDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationRootDir)
+
+	commandID := "2b196342-d7d4-436e-8f09-3883a1116ac3"
+	assert.NoError(t, os.MkdirAll(filepath.Join(orchestrationRootDir, commandID), 0700))
+
+	docState := model.DocumentState{DocumentInformation: model.DocumentInfo{CommandID: commandID}}
+	p := Processor{}
+	p.compactCompletedDocument(logger, orchestrationRootDir, docState)
+
+	assert.True(t, fileutil.IsDirectory(filepath.Join(orchestrationRootDir, commandID)))
+	assert.False(t, fileutil.Exists(getCompactedArtifactsPath(filepath.Join(orchestrationRootDir, commandID))))
+}
+
+func testProcessSendCommandMessage(t *testing.T, testCase TestCaseSendCommand) {
+	testProcessSendCommandMessageWithProcessor(t, testCase, &Processor{})
+}
+
+func testProcessSendCommandMessageWithProcessor(t *testing.T, testCase TestCaseSendCommand, p *Processor) {
+
+	cancelFlag := task.NewChanneledCancelFlag()
+
+	// method should call replyBuilder to format the response
+	replyBuilderMock := new(MockedReplyBuilder)
+	replyBuilderMock.On("BuildReply", mock.Anything, testCase.PluginResults).Return(testCase.ReplyPayload)
+
+	// method should call the proper APIs on the MDS service
+	mdsMock := new(MockedMDS)
+	var replyPayload string
+	mdsMock.On("SendReply", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil).Run(func(args mock.Arguments) {
+		replyPayload = args.Get(2).(string)
+	})
+	mdsMock.On("DeleteMessage", mock.Anything, mock.AnythingOfType("string")).Return(nil)
+
+	// create a mock sendResponse function
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		contextMock := context.NewMockDefault()
+		log := contextMock.Log()
+		payloadDoc := replyBuilderMock.BuildReply(pluginID, results)
+		payloadB, err := json.Marshal(payloadDoc)
+		if err != nil {
+			return
+		}
+		payload := string(payloadB)
+		// call the mock sendreply so that we can assert the reply sent
+		err = mdsMock.SendReply(log, messageID, payload)
+	}
+
+	// method should call plugin runner with the given configuration
+	pluginRunnerMock := new(MockedPluginRunner)
+	// mock.AnythingOfType("func(string, string, map[string]*plugin.Result)")
+
+	pluginStates := converter.ConvertPluginState(testCase.PluginStates)
+	pluginRunnerMock.On("RunPlugins", mock.Anything, *testCase.Msg.MessageId, pluginStates, mock.Anything, cancelFlag).Return(testCase.PluginResults)
+
+	// call method under test
+	//orchestrationRootDir is set to empty such that it can meet the test expectation.
+	orchestrationRootDir := ""
+	p.processSendCommandMessage(context.NewMockDefault(), mdsMock, orchestrationRootDir, pluginRunnerMock.RunPlugins, cancelFlag, replyBuilderMock.BuildReply, sendResponse, &testCase.DocState)
+
+	// assert that the expectations were met
+	pluginRunnerMock.AssertExpectations(t)
+	replyBuilderMock.AssertExpectations(t)
+	mdsMock.AssertExpectations(t)
+
+	// check that the method sent the right reply
+	var parsedReply messageContracts.SendReplyPayload
+	err := json.Unmarshal([]byte(replyPayload), &parsedReply)
+	assert.Nil(t, err)
+	assert.Equal(t, testCase.ReplyPayload, parsedReply)
+}
+
+func createMDSMessage(commandID string, payload string, topic string, instanceID string) ssmmds.Message {
+	messageCreatedDate := time.Date(2015, 7, 9, 23, 22, 39, 19000000, time.UTC)
+
+	c := sha256.New()
+	c.Write([]byte(payload))
+	payloadDigest := string(c.Sum(nil))
+
+	return ssmmds.Message{
+		CreatedDate:   aws.String(times.ToIso8601UTC(messageCreatedDate)),
+		Destination:   aws.String(instanceID),
+		MessageId:     aws.String("aws.ssm." + commandID + "." + instanceID),
+		Payload:       aws.String(payload),
+		PayloadDigest: aws.String(payloadDigest),
+		Topic:         aws.String(topic),
+	}
+}
+
+// getMessagesOutput wraps an MDS message into a GetMessagesOutput struct.
+func getMessagesOutput(m *ssmmds.Message) ssmmds.GetMessagesOutput {
+	uuid.SwitchFormat(uuid.CleanHyphen)
+	requestID := uuid.NewV4().String()
+	return ssmmds.GetMessagesOutput{
+		Destination:       m.Destination,
+		Messages:          []*ssmmds.Message{m},
+		MessagesRequestId: aws.String(requestID),
+	}
+}
+
+// TestProcessCancelCommandMessage tests that processCancelCommandMessage calls all the expected APIs
+// on receiving a cancel message.
+func TestProcessCancelCommandMessage(t *testing.T) {
+	testCase := TestCaseCancelCommand{
+		MsgToCancelID: uuid.NewV4().String(),
+		MsgID:         uuid.NewV4().String(),
+		InstanceID:    "i-400e1090",
 	}
 
 	testProcessCancelCommandMessage(t, testCase)
 }
 
-func testProcessCancelCommandMessage(t *testing.T, testCase TestCaseCancelCommand) {
-	context := context.NewMockDefault()
-	// create a cancel message
-	cancelMessagePayload := messageContracts.CancelPayload{
-		CancelMessageID: "aws.ssm" + testCase.MsgToCancelID + "." + testCase.InstanceID,
+// TestProcessCancelCommandMessageForeignTarget tests that a cancel for a command of another instance is not acted on
+func TestProcessCancelCommandMessageForeignTarget(t *testing.T) {
+	cancelMessagePayload := messageContracts.CancelPayload{
+		CancelMessageID: "aws.ssm." + uuid.NewV4().String() + ".i-foreign",
+	}
+	msgContent, err := jsonutil.Marshal(cancelMessagePayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdsCancelMessage := createMDSMessage(uuid.NewV4().String(), msgContent, "aws.ssm.cancelCommand.us.east.1.1", "i-400e1090")
+
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, *mdsCancelMessage.MessageId).Return(nil)
+	sendCommandPoolMock := new(task.MockedPool)
+
+	docState := initializeCancelCommandState(mdsCancelMessage, cancelMessagePayload)
+
+	p := Processor{}
+	p.processCancelCommandMessage(context.NewMockDefault(), mdsMock, sendCommandPoolMock, &docState)
+
+	mdsMock.AssertExpectations(t)
+	sendCommandPoolMock.AssertNotCalled(t, "Cancel", mock.Anything)
+	assert.Equal(t, contracts.ResultStatusFailed, docState.DocumentInformation.DocumentStatus)
+	assert.Contains(t, docState.CancelInformation.DebugInfo, "target not found on this instance")
+}
+
+// TestProcessCancelCommandMessagePendingTarget tests that a document cancelled in Pending is never submitted
+func TestProcessCancelCommandMessagePendingTarget(t *testing.T) {
+	commandID := uuid.NewV4().String()
+	instanceID := "i-400e1090"
+
+	// the target is persisted in Pending, but not submitted to the pool yet
+	pendingState := model.DocumentState{
+		DocumentType: model.SendCommand,
+		DocumentInformation: model.DocumentInfo{
+			DocumentID:     commandID,
+			CommandID:      commandID,
+			InstanceID:     instanceID,
+			MessageID:      "aws.ssm." + commandID + "." + instanceID,
+			DocumentStatus: contracts.ResultStatusInProgress,
+		},
+	}
+	pendingLocation := map[string]string{commandID: appconfig.DefaultLocationOfPending}
+
+	isCancelTargetOwnedOrig := isCancelTargetOwned
+	isDocumentPersistedOrig := isDocumentPersisted
+	getDocumentInterimStateOrig := getDocumentInterimState
+	persistDocumentInfoOrig := persistDocumentInfo
+	moveDocumentStateOrig := moveDocumentState
+	defer func() {
+		isCancelTargetOwned = isCancelTargetOwnedOrig
+		isDocumentPersisted = isDocumentPersistedOrig
+		getDocumentInterimState = getDocumentInterimStateOrig
+		persistDocumentInfo = persistDocumentInfoOrig
+		moveDocumentState = moveDocumentStateOrig
+	}()
+	isCancelTargetOwned = func(cancelInfo model.CancelCommandInfo, instanceID string) bool {
+		return true
+	}
+	isDocumentPersisted = func(fileName, instanceID, locationFolder string) bool {
+		return pendingLocation[fileName] == locationFolder
+	}
+	getDocumentInterimState = func(log log.T, fileName, instanceID, locationFolder string) model.DocumentState {
+		return pendingState
+	}
+	persistDocumentInfo = func(log log.T, docInfo model.DocumentInfo, fileName, instanceID, locationFolder string) {
+		if fileName == commandID {
+			pendingState.DocumentInformation = docInfo
+		}
+	}
+	moveDocumentState = func(log log.T, fileName, instanceID, srcLocationFolder, dstLocationFolder string) {
+		if pendingLocation[fileName] == srcLocationFolder {
+			pendingLocation[fileName] = dstLocationFolder
+		}
+	}
+
+	// cancel the pending document
+	cancelMessagePayload := messageContracts.CancelPayload{
+		CancelMessageID: pendingState.DocumentInformation.MessageID,
+	}
+	msgContent, err := jsonutil.Marshal(cancelMessagePayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdsCancelMessage := createMDSMessage(uuid.NewV4().String(), msgContent, "aws.ssm.cancelCommand.us.east.1.1", instanceID)
+	cancelState := initializeCancelCommandState(mdsCancelMessage, cancelMessagePayload)
+
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	tc.SendCommandTaskPoolMock.On("Cancel", cancelMessagePayload.CancelMessageID).Return(false)
+	tc.MdsMock.On("DeleteMessage", mock.Anything, *mdsCancelMessage.MessageId).Return(nil)
+	proc.processCancelCommandMessage(tc.ContextMock, tc.MdsMock, tc.SendCommandTaskPoolMock, &cancelState)
+
+	assert.Equal(t, contracts.ResultStatusSuccess, cancelState.DocumentInformation.DocumentStatus)
+	assert.Equal(t, contracts.ResultStatusCancelled, pendingState.DocumentInformation.DocumentStatus)
+
+	// the cancelled document is completed without being submitted
+	tc.MdsMock.On("DeleteMessage", mock.Anything, pendingState.DocumentInformation.MessageID).Return(nil)
+	proc.ExecutePendingDocument(&pendingState)
+
+	tc.MdsMock.AssertExpectations(t)
+	tc.SendCommandTaskPoolMock.AssertNotCalled(t, "Submit", mock.Anything, mock.Anything, mock.Anything)
+	assert.True(t, *tc.IsDocLevelResponseSent)
+	assert.Equal(t, appconfig.DefaultLocationOfCompleted, pendingLocation[commandID])
+}
+
+// TestCancelTargetOwned tests that the cancel target must be addressed to this instance
+func TestCancelTargetOwned(t *testing.T) {
+	cancelInfo := model.CancelCommandInfo{
+		CancelMessageID: "aws.ssm.2b196342-d7d4-436e-8f09-3883a1116ac3.i-foreign",
+		CancelCommandID: "2b196342-d7d4-436e-8f09-3883a1116ac3",
+	}
+	assert.False(t, cancelTargetOwned(cancelInfo, "i-400e1090"))
+}
+
+func testProcessCancelCommandMessage(t *testing.T, testCase TestCaseCancelCommand) {
+	// the target is owned by this instance
+	isCancelTargetOwnedOrig := isCancelTargetOwned
+	defer func() { isCancelTargetOwned = isCancelTargetOwnedOrig }()
+	isCancelTargetOwned = func(cancelInfo model.CancelCommandInfo, instanceID string) bool {
+		return true
+	}
+
+	context := context.NewMockDefault()
+	// create a cancel message
+	cancelMessagePayload := messageContracts.CancelPayload{
+		CancelMessageID: "aws.ssm" + testCase.MsgToCancelID + "." + testCase.InstanceID,
+	}
+	msgContent, err := jsonutil.Marshal(cancelMessagePayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdsCancelMessage := createMDSMessage(testCase.MsgID, msgContent, "aws.ssm.cancelCommand.us.east.1.1", testCase.InstanceID)
+
+	// method should call the proper APIs on the MDS service
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, *mdsCancelMessage.MessageId).Return(nil)
+
+	// method should call cancel command
+	sendCommandPoolMock := new(task.MockedPool)
+	sendCommandPoolMock.On("Cancel", cancelMessagePayload.CancelMessageID).Return(true)
+
+	docState := initializeCancelCommandState(mdsCancelMessage, cancelMessagePayload)
+
+	p := Processor{}
+	// call the code we are testing
+	p.processCancelCommandMessage(context, mdsMock, sendCommandPoolMock, &docState)
+
+	// assert that the expectations were met
+	mdsMock.AssertExpectations(t)
+	sendCommandPoolMock.AssertExpectations(t)
+}
+
+func prepareTestPollOnce() (proc Processor, testCase TestCasePollOnce) {
+
+	// create mock context and log
+	contextMock := context.NewMockDefault()
+
+	// create mocked service and set expectations
+	mdsMock := new(MockedMDS)
+
+	// create a agentConfig with dummy instanceID and agentInfo
+	agentConfig := contracts.AgentConfiguration{
+		AgentInfo: contracts.AgentInfo{
+			Name:      "EC2Config",
+			Version:   "1",
+			Lang:      "en-US",
+			Os:        "linux",
+			OsVersion: "1",
+		},
+		InstanceID: testDestination,
+	}
+
+	proc = Processor{
+		context: contextMock,
+		config:  agentConfig,
+		service: mdsMock,
+	}
+
+	testCase = TestCasePollOnce{
+		ContextMock: contextMock,
+		MdsMock:     mdsMock,
+	}
+
+	return
+}
+
+func prepareTestProcessMessage(testTopic string) (proc Processor, testCase TestCaseProcessMessage) {
+
+	// create mock context and log
+	contextMock := context.NewMockDefault()
+
+	// create dummy message that would be passed processMessage
+	message := ssmmds.Message{
+		CreatedDate: &testCreatedDate,
+		Destination: &testDestination,
+		MessageId:   &testMessageId,
+		Topic:       &testTopic,
+	}
+
+	// create a agentConfig with dummy instanceID and agentInfo
+	agentConfig := contracts.AgentConfiguration{
+		AgentInfo: contracts.AgentInfo{
+			Name:      "EC2Config",
+			Version:   "1",
+			Lang:      "en-US",
+			Os:        "linux",
+			OsVersion: "1",
+		},
+		InstanceID: *message.Destination,
+	}
+
+	// create mocked service and set expectations
+	mdsMock := new(MockedMDS)
+
+	// sendCommand and cancelCommand will be processed by separate worker pools
+	// so we can define the number of workers per each
+	sendCommandTaskPool := new(task.MockedPool)
+	cancelCommandTaskPool := new(task.MockedPool)
+
+	orchestrationRootDir := ""
+
+	// create a mock sendDocLevelResponse function
+	isDocLevelResponseSent := false
+	sendDocLevelResponse := func(messageID string, resultStatus contracts.ResultStatus, documentTraceOutput string) {
+		isDocLevelResponseSent = true
+	}
+
+	// create a mock persistData function
+	isDataPersisted := false
+	persistData := func(docState *model.DocumentState, bookkeeping string) {
+		isDataPersisted = true
+	}
+
+	// create a processor with all above
+	proc = Processor{
+		context:              contextMock,
+		config:               agentConfig,
+		service:              mdsMock,
+		pluginRunner:         pluginRunner,
+		sendCommandPool:      sendCommandTaskPool,
+		cancelCommandPool:    cancelCommandTaskPool,
+		sendDocLevelResponse: sendDocLevelResponse,
+		orchestrationRootDir: orchestrationRootDir,
+		persistData:          persistData,
+	}
+
+	testCase = TestCaseProcessMessage{
+		ContextMock:               contextMock,
+		Message:                   message,
+		MdsMock:                   mdsMock,
+		IsDocLevelResponseSent:    &isDocLevelResponseSent,
+		IsDataPersisted:           &isDataPersisted,
+		SendCommandTaskPoolMock:   sendCommandTaskPool,
+		CancelCommandTaskPoolMock: cancelCommandTaskPool,
+	}
+
+	return
+}
+
+func parsePluginResult(t *testing.T, pluginRuntimeStatus contracts.PluginRuntimeStatus) contracts.PluginResult {
+	parsedOutput := pluginRuntimeStatus.Output
+	return contracts.PluginResult{
+		Output:        parsedOutput,
+		Status:        pluginRuntimeStatus.Status,
+		StartDateTime: times.ParseIso8601UTC(pluginRuntimeStatus.StartDateTime),
+		EndDateTime:   times.ParseIso8601UTC(pluginRuntimeStatus.EndDateTime),
+	}
+}
+
+func loadFile(t *testing.T, fileName string) (result []byte) {
+	result, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func loadMessageFromFile(t *testing.T, fileName string) (message messageContracts.SendCommandPayload) {
+	b := loadFile(t, fileName)
+	err := json.Unmarshal(b, &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func loadMessageReplyFromFile(t *testing.T, fileName string) (message messageContracts.SendReplyPayload) {
+	b := loadFile(t, fileName)
+	err := json.Unmarshal(b, &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func mockParseSendCommand(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+	return &model.DocumentState{
+		DocumentType: model.SendCommand,
+	}, nil
+}
+
+func mockParseCancelCommand(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+	return &model.DocumentState{
+		DocumentType: model.CancelCommand,
+	}, nil
+}
+
+// TestCleanupOrchestrationDirectories tests that only the directories of old, completed documents are removed
+func TestCleanupOrchestrationDirectories(t *testing.T) {
+	orchestrationRootDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationRootDir)
+
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	createOrchestrationEntry(t, orchestrationRootDir, "old", now.AddDate(0, 0, -31))
+	createOrchestrationEntry(t, orchestrationRootDir, "oldInProgress", now.AddDate(0, 0, -31))
+	createOrchestrationEntry(t, orchestrationRootDir, "recent", now.AddDate(0, 0, -1))
+	createOrchestrationEntry(t, orchestrationRootDir, "oldCompacted"+compactedArtifactsExtension, now.AddDate(0, 0, -40))
+
+	isDocumentInProgressOrig := isDocumentInProgress
+	defer func() { isDocumentInProgress = isDocumentInProgressOrig }()
+	isDocumentInProgress = func(commandID, instanceID string) bool {
+		return commandID == "oldInProgress"
+	}
+
+	clock := times.NewMockedClock()
+	clock.On("Now").Return(now)
+	p := Processor{
+		context:                        context.NewMockDefault(),
+		orchestrationRootDir:           orchestrationRootDir,
+		orchestrationRetention:         newOrchestrationRetention(appconfig.DefaultOrchestrationRetentionDays),
+		orchestrationRetentionMaxCount: appconfig.DefaultOrchestrationRetentionMaxCount,
+		clock:                          clock,
+	}
+	p.cleanupOrchestrationDirectories()
+
+	assert.Equal(t, []string{"oldInProgress", "recent"}, orchestrationEntryNames(t, orchestrationRootDir))
+}
+
+// TestCleanupOrchestrationDirectoriesMaxCount tests that the oldest directories beyond the maximum count are removed
+func TestCleanupOrchestrationDirectoriesMaxCount(t *testing.T) {
+	orchestrationRootDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationRootDir)
+
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	createOrchestrationEntry(t, orchestrationRootDir, "first", now.Add(-3*time.Hour))
+	createOrchestrationEntry(t, orchestrationRootDir, "second", now.Add(-2*time.Hour))
+	createOrchestrationEntry(t, orchestrationRootDir, "inProgress", now.Add(-4*time.Hour))
+	createOrchestrationEntry(t, orchestrationRootDir, "third", now.Add(-1*time.Hour))
+
+	isDocumentInProgressOrig := isDocumentInProgress
+	defer func() { isDocumentInProgress = isDocumentInProgressOrig }()
+	isDocumentInProgress = func(commandID, instanceID string) bool {
+		return commandID == "inProgress"
+	}
+
+	clock := times.NewMockedClock()
+	clock.On("Now").Return(now)
+	p := Processor{
+		context:                        context.NewMockDefault(),
+		orchestrationRootDir:           orchestrationRootDir,
+		orchestrationRetention:         newOrchestrationRetention(appconfig.DefaultOrchestrationRetentionDays),
+		orchestrationRetentionMaxCount: 2,
+		clock:                          clock,
+	}
+	p.cleanupOrchestrationDirectories()
+
+	assert.Equal(t, []string{"inProgress", "second", "third"}, orchestrationEntryNames(t, orchestrationRootDir))
+}
+
+// stubInodeInfo returns a getInodeInfo reporting the free inodes of freeInodes in turn, then the last one
+func stubInodeInfo(freeInodes ...uint64) func(path string) (fileutil.InodeInfo, error) {
+	return func(path string) (fileutil.InodeInfo, error) {
+		free := freeInodes[0]
+		if len(freeInodes) > 1 {
+			freeInodes = freeInodes[1:]
+		}
+		return fileutil.InodeInfo{FreeInodes: free, TotalInodes: 1000}, nil
+	}
+}
+
+// TestCleanupOrchestrationDirectoriesLowInodes tests that the oldest directories are removed while the inodes are low,
+// even if they are newer than the retention period and fewer than the maximum count
+func TestCleanupOrchestrationDirectoriesLowInodes(t *testing.T) {
+	orchestrationRootDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationRootDir)
+
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	createOrchestrationEntry(t, orchestrationRootDir, "first", now.Add(-3*time.Hour))
+	createOrchestrationEntry(t, orchestrationRootDir, "second", now.Add(-2*time.Hour))
+	createOrchestrationEntry(t, orchestrationRootDir, "inProgress", now.Add(-4*time.Hour))
+	createOrchestrationEntry(t, orchestrationRootDir, "third", now.Add(-1*time.Hour))
+
+	isDocumentInProgressOrig, getInodeInfoOrig := isDocumentInProgress, getInodeInfo
+	defer func() { isDocumentInProgress, getInodeInfo = isDocumentInProgressOrig, getInodeInfoOrig }()
+	isDocumentInProgress = func(commandID, instanceID string) bool {
+		return commandID == "inProgress"
+	}
+	// the inodes are low until two directories are removed
+	getInodeInfo = stubInodeInfo(10, 30, 60)
+
+	clock := times.NewMockedClock()
+	clock.On("Now").Return(now)
+	p := Processor{
+		context:                        context.NewMockDefault(),
+		orchestrationRootDir:           orchestrationRootDir,
+		orchestrationRetention:         newOrchestrationRetention(appconfig.DefaultOrchestrationRetentionDays),
+		orchestrationRetentionMaxCount: appconfig.DefaultOrchestrationRetentionMaxCount,
+		minFreeInodesPercent:           5,
+		clock:                          clock,
+	}
+	p.cleanupOrchestrationDirectories()
+
+	assert.Equal(t, []string{"inProgress", "third"}, orchestrationEntryNames(t, orchestrationRootDir))
+}
+
+// TestCleanupOrchestrationDirectoriesLowInodesBounded tests that inodes that stay low remove at most
+// maxLowInodesRemovals directories per cleanup
+func TestCleanupOrchestrationDirectoriesLowInodesBounded(t *testing.T) {
+	orchestrationRootDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationRootDir)
+
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	createOrchestrationEntry(t, orchestrationRootDir, "first", now.Add(-3*time.Hour))
+	createOrchestrationEntry(t, orchestrationRootDir, "second", now.Add(-2*time.Hour))
+	createOrchestrationEntry(t, orchestrationRootDir, "third", now.Add(-1*time.Hour))
+
+	isDocumentInProgressOrig, getInodeInfoOrig, maxLowInodesRemovalsOrig := isDocumentInProgress, getInodeInfo, maxLowInodesRemovals
+	defer func() {
+		isDocumentInProgress, getInodeInfo, maxLowInodesRemovals = isDocumentInProgressOrig, getInodeInfoOrig, maxLowInodesRemovalsOrig
+	}()
+	isDocumentInProgress = func(commandID, instanceID string) bool { return false }
+	getInodeInfo = stubInodeInfo(10)
+	maxLowInodesRemovals = 1
+
+	clock := times.NewMockedClock()
+	clock.On("Now").Return(now)
+	p := Processor{
+		context:                        context.NewMockDefault(),
+		orchestrationRootDir:           orchestrationRootDir,
+		orchestrationRetention:         newOrchestrationRetention(appconfig.DefaultOrchestrationRetentionDays),
+		orchestrationRetentionMaxCount: appconfig.DefaultOrchestrationRetentionMaxCount,
+		minFreeInodesPercent:           5,
+		clock:                          clock,
+	}
+	p.cleanupOrchestrationDirectories()
+
+	assert.Equal(t, []string{"second", "third"}, orchestrationEntryNames(t, orchestrationRootDir))
+}
+
+// TestCleanupOrchestrationDirectoriesWorkers tests that many old directories are all removed by at most
+// orchestrationCleanupWorkers removals at the same time
+func TestCleanupOrchestrationDirectoriesWorkers(t *testing.T) {
+	orchestrationRootDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationRootDir)
+
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 500; i++ {
+		createOrchestrationEntry(t, orchestrationRootDir, fmt.Sprintf("old%03d", i), now.AddDate(0, 0, -31))
+	}
+	createOrchestrationEntry(t, orchestrationRootDir, "recent", now.AddDate(0, 0, -1))
+
+	isDocumentInProgressOrig, removeOrchestrationPathOrig := isDocumentInProgress, removeOrchestrationPath
+	yieldIntervalOrig, yieldOrig := orchestrationCleanupYieldInterval, orchestrationCleanupYield
+	defer func() {
+		isDocumentInProgress, removeOrchestrationPath = isDocumentInProgressOrig, removeOrchestrationPathOrig
+		orchestrationCleanupYieldInterval, orchestrationCleanupYield = yieldIntervalOrig, yieldOrig
+	}()
+	isDocumentInProgress = func(commandID, instanceID string) bool {
+		return false
+	}
+	orchestrationCleanupYieldInterval, orchestrationCleanupYield = 10, time.Millisecond
+	var running, maxRunning int32
+	removeOrchestrationPath = func(path string) error {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			observed := atomic.LoadInt32(&maxRunning)
+			if current <= observed || atomic.CompareAndSwapInt32(&maxRunning, observed, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return os.RemoveAll(path)
+	}
+
+	clock := times.NewMockedClock()
+	clock.On("Now").Return(now)
+	p := Processor{
+		context:                        context.NewMockDefault(),
+		orchestrationRootDir:           orchestrationRootDir,
+		orchestrationRetention:         newOrchestrationRetention(appconfig.DefaultOrchestrationRetentionDays),
+		orchestrationRetentionMaxCount: appconfig.DefaultOrchestrationRetentionMaxCount,
+		orchestrationCleanupWorkers:    4,
+		clock:                          clock,
+	}
+	p.cleanupOrchestrationDirectories()
+
+	assert.Equal(t, []string{"recent"}, orchestrationEntryNames(t, orchestrationRootDir))
+	assert.True(t, maxRunning >= 1 && maxRunning <= 4, "%v removals at the same time", maxRunning)
+}
+
+func createOrchestrationEntry(t *testing.T, orchestrationRootDir string, name string, modTime time.Time) {
+	entry := filepath.Join(orchestrationRootDir, name)
+	assert.NoError(t, os.MkdirAll(entry, 0700))
+	assert.NoError(t, os.Chtimes(entry, modTime, modTime))
+}
+
+func orchestrationEntryNames(t *testing.T, orchestrationRootDir string) (names []string) {
+	entries, err := ioutil.ReadDir(orchestrationRootDir)
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return
+}
+
+func TestNewProcessorWithCustomWorkerLimits(t *testing.T) {
+	limits := make([]int, 0)
+	savedNewTaskPool, savedGetInstanceID := newTaskPool, getInstanceID
+	newTaskPool = func(log log.T, maxParallel int, cancelWaitDuration time.Duration, clock times.Clock) task.Pool {
+		limits = append(limits, maxParallel)
+		return savedNewTaskPool(log, maxParallel, cancelWaitDuration, clock)
+	}
+	getInstanceID = func() (string, error) { return testDestination, nil }
+	defer func() { newTaskPool, getInstanceID = savedNewTaskPool, savedGetInstanceID }()
+
+	contextMock := new(context.Mock)
+	contextMock.On("Log").Return(log.NewMockLog())
+	contextMock.On("AppConfig").Return(appconfig.DefaultConfig())
+	proc := NewProcessor(contextMock, mdsName, nil, 4, 2, false, []model.DocumentType{model.SendCommand, model.CancelCommand})
+
+	assert.NotNil(t, proc)
+	assert.Equal(t, []int{4, 2}, limits)
+	proc.sendCommandPool.Shutdown()
+	proc.cancelCommandPool.Shutdown()
+}
+
+// TestBuildS3KeyPrefix tests the S3 key prefix with the default layout and with a template
+func TestBuildS3KeyPrefix(t *testing.T) {
+	getRegionOrig := getRegion
+	defer func() { getRegion = getRegionOrig }()
+	getRegion = func() (string, error) { return "us-west-2", nil }
+
+	prefix, err := buildS3KeyPrefix("", "outputs", "command-id", "i-400e1090")
+	assert.NoError(t, err)
+	assert.Equal(t, "outputs/command-id/i-400e1090", prefix)
+
+	prefix, err = buildS3KeyPrefix("{prefix}/{region}/{instanceId}/{commandId}", "outputs", "command-id", "i-400e1090")
+	assert.NoError(t, err)
+	assert.Equal(t, "outputs/us-west-2/i-400e1090/command-id", prefix)
+
+	// empty values don't leave empty path segments
+	prefix, err = buildS3KeyPrefix("{prefix}/{region}/{commandId}", "", "command-id", "i-400e1090")
+	assert.NoError(t, err)
+	assert.Equal(t, "us-west-2/command-id", prefix)
+
+	getRegion = func() (string, error) { return "", fmt.Errorf("no region") }
+	_, err = buildS3KeyPrefix("{region}/{commandId}", "outputs", "command-id", "i-400e1090")
+	assert.Error(t, err)
+}
+
+// TestValidateOutputS3KeyPrefix tests that the S3 key prefixes escaping the keys of the command are rejected
+func TestValidateOutputS3KeyPrefix(t *testing.T) {
+	for _, prefix := range []string{"", "outputs", "outputs/run-command/", "team.a/outputs..old"} {
+		assert.NoError(t, validateOutputS3KeyPrefix(prefix), prefix)
+	}
+	for _, prefix := range []string{"..", "../other-team", "outputs/../../other-team", "outputs/./logs", "outputs\\..\\other-team", "outputs\nlogs", "outputs/\x00"} {
+		assert.Error(t, validateOutputS3KeyPrefix(prefix), prefix)
+	}
+}
+
+// TestParseSendCommandMessageInvalidS3KeyPrefix tests that a command with an S3 key prefix escaping its keys is rejected permanently
+func TestParseSendCommandMessageInvalidS3KeyPrefix(t *testing.T) {
+	testCase := generateTestCaseFromFiles(t, sampleMessageFiles[0], sampleMessageReplyFiles[0], "i-400e1090")
+
+	var payload messageContracts.SendCommandPayload
+	assert.NoError(t, json.Unmarshal([]byte(*testCase.Msg.Payload), &payload))
+	payload.OutputS3KeyPrefix = "outputs/../../other-team"
+	content, err := json.Marshal(payload)
+	assert.NoError(t, err)
+	maliciousPayload := string(content)
+	testCase.Msg.Payload = &maliciousPayload
+
+	_, err = parseSendCommandMessage(context.NewMockDefault(), &testCase.Msg, "")
+	assert.IsType(t, &ErrMalformedPayload{}, err)
+	assert.False(t, isTransientError(err))
+}
+
+// TestValidatePluginGraph tests that the dependencies between the steps of a document must name existing steps and be acyclic
+func TestValidatePluginGraph(t *testing.T) {
+	step := func(name string, dependsOn ...string) *contracts.InstancePluginConfig {
+		return &contracts.InstancePluginConfig{Action: "aws:runShellScript", Name: name, DependsOn: dependsOn}
+	}
+
+	// a linear graph runs in the order of its dependencies, the declared order is kept otherwise
+	linear := []*contracts.InstancePluginConfig{step("configure", "download"), step("download"), step("report"), step("start", "configure")}
+	assert.NoError(t, validatePluginGraph(linear))
+	var order []string
+	for _, plugin := range pluginExecutionOrder(linear) {
+		order = append(order, plugin.Name)
+	}
+	assert.Equal(t, []string{"download", "configure", "report", "start"}, order)
+
+	// documents without dependencies can reuse step names
+	assert.NoError(t, validatePluginGraph([]*contracts.InstancePluginConfig{step("run"), step("run")}))
+
+	err := validatePluginGraph([]*contracts.InstancePluginConfig{step("download", "start"), step("configure", "download"), step("start", "configure")})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle: download -> start -> configure -> download")
+
+	err = validatePluginGraph([]*contracts.InstancePluginConfig{step("configure", "configure")})
+	assert.Contains(t, err.Error(), "cycle: configure -> configure")
+
+	err = validatePluginGraph([]*contracts.InstancePluginConfig{step("download"), step("configure", "install")})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "step configure depends on step install which doesn't exist")
+
+	grouped := []*contracts.InstancePluginConfig{step("download"), step("configure", "download")}
+	grouped[0].ExecutionGroup, grouped[1].ExecutionGroup = "prepare", "prepare"
+	assert.Error(t, validatePluginGraph(grouped))
+}
+
+// TestParseSendCommandMessageCyclicSteps tests that a command whose steps depend on each other in a cycle is rejected permanently
+func TestParseSendCommandMessageCyclicSteps(t *testing.T) {
+	testCase := generateTestCaseFromFiles(t, sampleMessageFiles[0], sampleMessageReplyFiles[0], "i-400e1090")
+
+	var payload messageContracts.SendCommandPayload
+	assert.NoError(t, json.Unmarshal([]byte(*testCase.Msg.Payload), &payload))
+	payload.DocumentContent.MainSteps = []*contracts.InstancePluginConfig{
+		{Action: "aws:runShellScript", Name: "first", DependsOn: []string{"second"}},
+		{Action: "aws:runShellScript", Name: "second", DependsOn: []string{"first"}},
+	}
+	content, err := json.Marshal(payload)
+	assert.NoError(t, err)
+	cyclicPayload := string(content)
+	testCase.Msg.Payload = &cyclicPayload
+
+	_, err = parseSendCommandMessage(context.NewMockDefault(), &testCase.Msg, "")
+	assert.IsType(t, &ErrMalformedPayload{}, err)
+	assert.False(t, isTransientError(err))
+}
+
+// TestReloadUnsupportedDocuments tests that messages parsed during a reload see either the previous or the new list
+func TestReloadUnsupportedDocuments(t *testing.T) {
+	listUnsupportedSSMDocsOrig := listUnsupportedSSMDocs
+	defer func() {
+		listUnsupportedSSMDocs = listUnsupportedSSMDocsOrig
+		ReloadUnsupportedDocuments()
+	}()
+	lists := [][]string{{"AWS-DocumentA", "AWS-DocumentB"}, {"AWS-DocumentC", "AWS-DocumentD"}}
+	var reloads int32
+	listUnsupportedSSMDocs = func() []string {
+		return lists[atomic.AddInt32(&reloads, 1)%2]
+	}
+	ReloadUnsupportedDocuments()
+
+	var wg sync.WaitGroup
+	done := make(chan bool)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			ReloadUnsupportedDocuments()
+		}
+		close(done)
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				unsupportedDocs := unsupportedSSMDocuments()
+				first := unsupportedDocs["AWS-DocumentA"] && unsupportedDocs["AWS-DocumentB"]
+				second := unsupportedDocs["AWS-DocumentC"] && unsupportedDocs["AWS-DocumentD"]
+				assert.True(t, first != second, "inconsistent list %v", unsupportedDocs)
+				assert.Len(t, unsupportedDocs, 2)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(101), atomic.LoadInt32(&reloads))
+	assert.True(t, isUnsupportedSSMDocument("AWS-DocumentC"))
+	assert.False(t, isUnsupportedSSMDocument("AWS-DocumentA"))
+}
+
+func TestRefreshUnsupportedDocuments(t *testing.T) {
+	fetchUnsupportedDocumentsOrig := fetchUnsupportedDocuments
+	defer func() {
+		fetchUnsupportedDocuments = fetchUnsupportedDocumentsOrig
+		ReloadUnsupportedDocuments()
+	}()
+	var fetchedURL string
+	var fetchResult []string
+	var fetchErr error
+	fetchUnsupportedDocuments = func(url string) ([]string, error) {
+		fetchedURL = url
+		return fetchResult, fetchErr
+	}
+	p := Processor{context: context.NewMockDefault(), unsupportedDocumentsURL: "https://bucket.s3.amazonaws.com/unsupported.json"}
+
+	fetchResult = []string{"AWS-RemoteDocumentA"}
+	p.refreshUnsupportedDocuments()
+	assert.Equal(t, p.unsupportedDocumentsURL, fetchedURL)
+	assert.True(t, isUnsupportedSSMDocument("AWS-RemoteDocumentA"))
+	assert.False(t, isUnsupportedSSMDocument("AWS-ListWindowsInventory"))
+
+	// a failed fetch keeps the last known good list
+	fetchResult, fetchErr = nil, errors.New("connection refused")
+	p.refreshUnsupportedDocuments()
+	assert.True(t, isUnsupportedSSMDocument("AWS-RemoteDocumentA"))
+
+	fetchResult, fetchErr = []string{"AWS-RemoteDocumentA", "AWS-RemoteDocumentB"}, nil
+	p.refreshUnsupportedDocuments()
+	assert.True(t, isUnsupportedSSMDocument("AWS-RemoteDocumentB"))
+}
+
+func TestFetchUnsupportedDocumentsFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/unsupported.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"IncompatibleDocuments": ["AWS-RemoteDocumentA", "AWS-RemoteDocumentB"]}`))
+	}))
+	defer server.Close()
+
+	documentNames, err := fetchUnsupportedDocumentsFromURL(server.URL + "/unsupported.json")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AWS-RemoteDocumentA", "AWS-RemoteDocumentB"}, documentNames)
+
+	_, err = fetchUnsupportedDocumentsFromURL(server.URL + "/missing.json")
+	assert.Error(t, err)
+}
+
+// memoryStateStore keeps the documents in memory as their json content, by location folder then document id
+type memoryStateStore struct {
+	lock      sync.Mutex
+	documents map[string]map[string][]byte
+}
+
+func newMemoryStateStore() *memoryStateStore {
+	return &memoryStateStore{documents: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStateStore) location(instanceID, locationFolder string) map[string][]byte {
+	key := path.Join(instanceID, locationFolder)
+	if s.documents[key] == nil {
+		s.documents[key] = make(map[string][]byte)
+	}
+	return s.documents[key]
+}
+
+func (s *memoryStateStore) GetDocumentInterimState(log log.T, documentID, instanceID, locationFolder string) (docState model.DocumentState) {
+	s.GetData(log, documentID, instanceID, locationFolder, &docState)
+	return docState
+}
+
+func (s *memoryStateStore) GetData(log log.T, documentID, instanceID, locationFolder string, object interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	content, found := s.location(instanceID, locationFolder)[documentID]
+	if !found {
+		return fmt.Errorf("document %v not found in %v", documentID, locationFolder)
+	}
+	return json.Unmarshal(content, object)
+}
+
+func (s *memoryStateStore) PersistData(log log.T, documentID, instanceID, locationFolder string, object interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	content, _ := json.Marshal(object)
+	s.location(instanceID, locationFolder)[documentID] = content
+}
+
+func (s *memoryStateStore) PersistDocumentInfo(log log.T, docInfo model.DocumentInfo, documentID, instanceID, locationFolder string) {
+	docState := s.GetDocumentInterimState(log, documentID, instanceID, locationFolder)
+	docState.DocumentInformation = docInfo
+	s.PersistData(log, documentID, instanceID, locationFolder, docState)
+}
+
+func (s *memoryStateStore) MoveDocumentState(log log.T, documentID, instanceID, srcLocationFolder, dstLocationFolder string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if content, found := s.location(instanceID, srcLocationFolder)[documentID]; found {
+		s.location(instanceID, dstLocationFolder)[documentID] = content
+		delete(s.location(instanceID, srcLocationFolder), documentID)
+	}
+}
+
+func (s *memoryStateStore) RemoveData(log log.T, documentID, instanceID, locationFolder string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.location(instanceID, locationFolder), documentID)
+}
+
+func (s *memoryStateStore) IsDocumentPersisted(documentID, instanceID, locationFolder string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, found := s.location(instanceID, locationFolder)[documentID]
+	return found
+}
+
+func (s *memoryStateStore) ListDocuments(log log.T, instanceID, locationFolder string) (documentIDs []string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for documentID := range s.location(instanceID, locationFolder) {
+		documentIDs = append(documentIDs, documentID)
 	}
-	msgContent, err := jsonutil.Marshal(cancelMessagePayload)
-	if err != nil {
-		t.Fatal(err)
+	return documentIDs, nil
+}
+
+// TestProcessMessageWithMemoryStateStore tests that a send command is executed and completed with its state in a custom store
+func TestProcessMessageWithMemoryStateStore(t *testing.T) {
+	testCase := generateTestCaseFromFiles(t, sampleMessageFiles[0], sampleMessageReplyFiles[0], testDestination)
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	tc.Message.MessageId = testCase.Msg.MessageId
+	tc.Message.Payload = testCase.Msg.Payload
+
+	store := newMemoryStateStore()
+	documentStateStoreOrig := documentStateStore
+	loadDocStateFromSendCommandOrig, isManagedInstanceOrig := loadDocStateFromSendCommand, isManagedInstance
+	defer func() {
+		SetDocumentStateStore(documentStateStoreOrig)
+		loadDocStateFromSendCommand, isManagedInstance = loadDocStateFromSendCommandOrig, isManagedInstanceOrig
+	}()
+	SetDocumentStateStore(store)
+	loadDocStateFromSendCommand = parseSendCommandMessage
+	isManagedInstance = func() (bool, error) { return false, nil }
+
+	proc.persistData = func(docState *model.DocumentState, bookkeeping string) {
+		persistDocumentState(logger, docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID, bookkeeping, *docState)
 	}
-	mdsCancelMessage := createMDSMessage(testCase.MsgID, msgContent, "aws.ssm.cancelCommand.us.east.1.1", testCase.InstanceID)
+	proc.stopSignal = make(chan bool)
+	proc.pluginRunner = func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		outputs := make(map[string]*contracts.PluginResult)
+		for _, plugin := range plugins {
+			outputs[plugin.Id] = &contracts.PluginResult{PluginName: plugin.Name, Status: contracts.ResultStatusSuccess}
+		}
+		return outputs
+	}
+	proc.buildReply = func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccess}
+	}
+	proc.sendResponse = func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {}
 
-	// method should call the proper APIs on the MDS service
-	mdsMock := new(MockedMDS)
-	mdsMock.On("DeleteMessage", mock.Anything, *mdsCancelMessage.MessageId).Return(nil)
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.MdsMock.On("DeleteMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	// run the document right away
+	tc.SendCommandTaskPoolMock.On("Submit", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("task.Job")).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(2).(task.Job)(task.NewChanneledCancelFlag())
+	})
 
-	// method should call cancel command
-	sendCommandPoolMock := new(task.MockedPool)
-	sendCommandPoolMock.On("Cancel", cancelMessagePayload.CancelMessageID).Return(true)
+	proc.processMessage(&tc.Message)
 
-	docState := initializeCancelCommandState(mdsCancelMessage, cancelMessagePayload)
+	commandID := getCommandID(*tc.Message.MessageId)
+	completed, _ := store.ListDocuments(logger, testDestination, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, []string{commandID}, completed)
+	for _, location := range []string{appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCurrent} {
+		documentIDs, _ := store.ListDocuments(logger, testDestination, location)
+		assert.Empty(t, documentIDs, location)
+	}
+	docState := store.GetDocumentInterimState(logger, commandID, testDestination, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, contracts.ResultStatusSuccess, docState.DocumentInformation.DocumentStatus)
+	tc.MdsMock.AssertExpectations(t)
+}
 
-	p := Processor{}
-	// call the code we are testing
-	p.processCancelCommandMessage(context, mdsMock, sendCommandPoolMock, &docState)
+// recordingListener records the lifecycle events it receives, in order
+type recordingListener struct {
+	events []string
+}
 
-	// assert that the expectations were met
-	mdsMock.AssertExpectations(t)
-	sendCommandPoolMock.AssertExpectations(t)
+func (l *recordingListener) OnReceived(messageID string) {
+	l.events = append(l.events, "OnReceived "+messageID)
 }
 
-func prepareTestPollOnce() (proc Processor, testCase TestCasePollOnce) {
+func (l *recordingListener) OnAcked(messageID string) {
+	l.events = append(l.events, "OnAcked "+messageID)
+}
 
-	// create mock context and log
-	contextMock := context.NewMockDefault()
+func (l *recordingListener) OnExecutionStart(documentID string) {
+	l.events = append(l.events, "OnExecutionStart "+documentID)
+}
 
-	// create mocked service and set expectations
+func (l *recordingListener) OnPluginComplete(documentID string, pluginName string, status contracts.ResultStatus) {
+	l.events = append(l.events, fmt.Sprintf("OnPluginComplete %v %v %v", documentID, pluginName, status))
+}
+
+func (l *recordingListener) OnDocumentComplete(documentID string, status contracts.ResultStatus) {
+	l.events = append(l.events, fmt.Sprintf("OnDocumentComplete %v %v", documentID, status))
+}
+
+// TestProcessMessageLifecycleEvents tests that the lifecycle events of a processed document are emitted in order
+func TestProcessMessageLifecycleEvents(t *testing.T) {
+	testCase := generateTestCaseFromFiles(t, sampleMessageFiles[0], sampleMessageReplyFiles[0], testDestination)
+	proc, tc := prepareTestProcessMessage(testTopicSend)
+	tc.Message.MessageId = testCase.Msg.MessageId
+	tc.Message.Payload = testCase.Msg.Payload
+
+	documentStateStoreOrig := documentStateStore
+	loadDocStateFromSendCommandOrig, isManagedInstanceOrig := loadDocStateFromSendCommand, isManagedInstance
+	defer func() {
+		SetDocumentStateStore(documentStateStoreOrig)
+		loadDocStateFromSendCommand, isManagedInstance = loadDocStateFromSendCommandOrig, isManagedInstanceOrig
+	}()
+	SetDocumentStateStore(newMemoryStateStore())
+	loadDocStateFromSendCommand = parseSendCommandMessage
+	isManagedInstance = func() (bool, error) { return false, nil }
+
+	proc.persistData = func(docState *model.DocumentState, bookkeeping string) {
+		persistDocumentState(logger, docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID, bookkeeping, *docState)
+	}
+	proc.stopSignal = make(chan bool)
+	var pluginNames []string
+	proc.pluginRunner = func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		// like the engine, reply on the completion of each plugin
+		outputs := make(map[string]*contracts.PluginResult)
+		for _, plugin := range plugins {
+			outputs[plugin.Id] = &contracts.PluginResult{PluginName: plugin.Name, Status: contracts.ResultStatusSuccess}
+			pluginNames = append(pluginNames, plugin.Name)
+			sendResponse(documentID, plugin.Name, outputs)
+		}
+		return outputs
+	}
+	proc.buildReply = func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccess}
+	}
+	proc.sendResponse = func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {}
+
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.MdsMock.On("DeleteMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.SendCommandTaskPoolMock.On("Submit", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("task.Job")).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(2).(task.Job)(task.NewChanneledCancelFlag())
+	})
+
+	listener := &recordingListener{}
+	proc.AddLifecycleListener(listener)
+	proc.processMessage(&tc.Message)
+
+	documentID := getCommandID(*tc.Message.MessageId)
+	expected := []string{
+		"OnReceived " + *tc.Message.MessageId,
+		"OnAcked " + *tc.Message.MessageId,
+		"OnExecutionStart " + documentID,
+	}
+	assert.NotEmpty(t, pluginNames)
+	for _, pluginName := range pluginNames {
+		expected = append(expected, fmt.Sprintf("OnPluginComplete %v %v %v", documentID, pluginName, contracts.ResultStatusSuccess))
+	}
+	expected = append(expected, fmt.Sprintf("OnDocumentComplete %v %v", documentID, contracts.ResultStatusSuccess))
+	assert.Equal(t, expected, listener.events)
+}
+
+// TestDumpState tests that the dump lists the documents of every folder with their sensitive values redacted
+// and their secure parameter values masked
+func TestDumpState(t *testing.T) {
+	store := newMemoryStateStore()
+	documentStateStoreOrig := documentStateStore
+	SetDocumentStateStore(store)
+	defer SetDocumentStateStore(documentStateStoreOrig)
+
+	newDocState := func(documentID string, properties interface{}) model.DocumentState {
+		var docState model.DocumentState
+		docState.DocumentInformation.DocumentID = documentID
+		docState.DocumentInformation.InstanceID = testDestination
+		docState.InstancePluginsInformation = []model.PluginState{{
+			Name:          "aws:runShellScript",
+			Id:            "plugin1",
+			Configuration: contracts.Configuration{Properties: properties},
+		}}
+		return docState
+	}
+	pendingDocState := newDocState("pendingDocument", map[string]interface{}{"commands": []string{"echo hello", "login s3cr3t-token"}})
+	pendingDocState.DocumentInformation.SecureParameterHashes = secureValueHashes([]string{"s3cr3t-token"})
+	completedDocState := newDocState("completedDocument", []interface{}{
+		map[string]interface{}{"runCommand": "mount", "Password": "hunter2", "credentials": map[string]interface{}{"user": "admin"}},
+	})
+	store.PersistData(logger, "pendingDocument", testDestination, appconfig.DefaultLocationOfPending, pendingDocState)
+	store.PersistData(logger, "completedDocument", testDestination, appconfig.DefaultLocationOfCompleted, completedDocState)
+
+	p := Processor{context: context.NewMockDefault(), config: contracts.AgentConfiguration{InstanceID: testDestination}}
+	var dump strings.Builder
+	assert.NoError(t, p.DumpState(&dump))
+
+	var documents []map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(dump.String()), &documents))
+	if assert.Len(t, documents, 2) {
+		assert.Equal(t, appconfig.DefaultLocationOfPending, documents[0]["folder"])
+		assert.Equal(t, "pendingDocument", documents[0]["documentId"])
+		assert.Equal(t, appconfig.DefaultLocationOfCompleted, documents[1]["folder"])
+		assert.Equal(t, "completedDocument", documents[1]["documentId"])
+	}
+	assert.Contains(t, dump.String(), "echo hello")
+	assert.Contains(t, dump.String(), "mount")
+	assert.NotContains(t, dump.String(), "hunter2")
+	assert.NotContains(t, dump.String(), "admin")
+	assert.Contains(t, dump.String(), redactedValue)
+	assert.NotContains(t, dump.String(), "s3cr3t-token")
+	assert.Contains(t, dump.String(), "login "+maskedValue)
+
+	// the persisted states aren't changed by the dump
+	assert.Equal(t, completedDocState, store.GetDocumentInterimState(logger, "completedDocument", testDestination, appconfig.DefaultLocationOfCompleted))
+	assert.False(t, store.IsDocumentPersisted("completedDocument", testDestination, appconfig.DefaultLocationOfPending))
+}
+
+// TestWithSubsystemLogLevel tests that the processor loggers drop the messages below the level configured for the processor
+func TestWithSubsystemLogLevel(t *testing.T) {
+	logMock := log.NewMockLog()
+	config := appconfig.DefaultConfig()
+	config.Log.Levels = map[string]string{logSubsystem: "info", "engine": "trace"}
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(logMock)
+	ctx.On("AppConfig").Return(config)
+	ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+
+	processorContext := withSubsystemLogLevel(ctx)
+	processorContext.Log().Debugf("polling %v", "mds")
+	// the level applies to the contexts derived from the processor context
+	messageContext := processorContext.With("[messageID=" + testMessageId + "]")
+	messageContext.Log().Debug("processing message")
+	messageContext.Log().Infof("acknowledged %v", testMessageId)
+	messageContext.Log().Errorf("failed %v", testMessageId)
+
+	logMock.AssertNotCalled(t, "Debugf", mock.Anything, mock.Anything)
+	logMock.AssertNotCalled(t, "Debug", mock.Anything)
+	logMock.AssertCalled(t, "Infof", "acknowledged %v", []interface{}{testMessageId})
+	logMock.AssertCalled(t, "Errorf", "failed %v", []interface{}{testMessageId})
+
+	// without a configured level the context is left as is
+	config.Log.Levels = map[string]string{}
+	ctx = new(context.Mock)
+	ctx.On("AppConfig").Return(config)
+	assert.Equal(t, ctx, withSubsystemLogLevel(ctx))
+}
+
+// TestResumeInflightDocuments tests that only the plugins that haven't executed run when a document is resumed from Current,
+// and that a document found in Current in a terminal state is moved to Completed without running again
+func TestResumeInflightDocuments(t *testing.T) {
+	store := newMemoryStateStore()
+	documentStateStoreOrig, getInstanceIDOrig := documentStateStore, getInstanceID
+	SetDocumentStateStore(store)
+	getInstanceID = func() (string, error) { return testDestination, nil }
+	defer func() {
+		SetDocumentStateStore(documentStateStoreOrig)
+		getInstanceID = getInstanceIDOrig
+	}()
+	logger := log.NewMockLog()
+
+	newDocState := func(documentID string, status contracts.ResultStatus, executed ...bool) model.DocumentState {
+		docState := model.DocumentState{
+			DocumentType: model.SendCommand,
+			DocumentInformation: model.DocumentInfo{
+				DocumentID:     documentID,
+				CommandID:      documentID,
+				InstanceID:     testDestination,
+				MessageID:      "aws.ssm." + documentID + "." + testDestination,
+				DocumentStatus: status,
+			},
+		}
+		for i, hasExecuted := range executed {
+			plugin := model.PluginState{Name: "aws:runShellScript", Id: fmt.Sprintf("%v-plugin%v", documentID, i+1), HasExecuted: hasExecuted}
+			plugin.Configuration.PluginID = plugin.Id
+			if hasExecuted {
+				plugin.Result.Status = contracts.ResultStatusSuccess
+			}
+			docState.InstancePluginsInformation = append(docState.InstancePluginsInformation, plugin)
+		}
+		return docState
+	}
+	store.PersistData(logger, "partialDocument", testDestination, appconfig.DefaultLocationOfCurrent,
+		newDocState("partialDocument", contracts.ResultStatusInProgress, true, false, false))
+	store.PersistData(logger, "finishedDocument", testDestination, appconfig.DefaultLocationOfCurrent,
+		newDocState("finishedDocument", contracts.ResultStatusSuccess, true, true))
+
+	// like the engine, the runner skips the plugins that already executed
+	var executed []string
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		results := make(map[string]*contracts.PluginResult)
+		for _, plugin := range plugins {
+			if !plugin.HasExecuted {
+				executed = append(executed, plugin.Id)
+			}
+			results[plugin.Id] = &contracts.PluginResult{Status: contracts.ResultStatusSuccess}
+		}
+		return results
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccess}
+	}
 	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, "aws.ssm.partialDocument."+testDestination).Return(nil)
+	sendCommandPool := new(task.MockedPool)
+	sendCommandPool.On("Submit", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("task.Job")).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(2).(task.Job)(task.NewChanneledCancelFlag())
+	})
 
-	// create a agentConfig with dummy instanceID and agentInfo
-	agentConfig := contracts.AgentConfiguration{
-		AgentInfo: contracts.AgentInfo{
-			Name:      "EC2Config",
-			Version:   "1",
-			Lang:      "en-US",
-			Os:        "linux",
-			OsVersion: "1",
-		},
-		InstanceID: testDestination,
+	p := Processor{
+		context:           context.NewMockDefault(),
+		stopSignal:        make(chan bool),
+		service:           mdsMock,
+		pluginRunner:      runPlugins,
+		sendCommandPool:   sendCommandPool,
+		buildReply:        buildReply,
+		sendResponse:      func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {},
+		supportedDocTypes: []model.DocumentType{model.SendCommand},
 	}
+	p.ResumeInflightDocuments()
 
-	proc = Processor{
-		context: contextMock,
-		config:  agentConfig,
-		service: mdsMock,
+	assert.Equal(t, []string{"partialDocument-plugin2", "partialDocument-plugin3"}, executed)
+	sendCommandPool.AssertNumberOfCalls(t, "Submit", 1)
+	mdsMock.AssertExpectations(t)
+	for _, documentID := range []string{"partialDocument", "finishedDocument"} {
+		assert.False(t, store.IsDocumentPersisted(documentID, testDestination, appconfig.DefaultLocationOfCurrent))
+		assert.True(t, store.IsDocumentPersisted(documentID, testDestination, appconfig.DefaultLocationOfCompleted))
 	}
+}
 
-	testCase = TestCasePollOnce{
-		ContextMock: contextMock,
-		MdsMock:     mdsMock,
+// TestSecureParameterMasking tests that the values of the secure parameters of a document are masked in the logged outputs
+// and in the replies, and are not persisted on disk
+func TestSecureParameterMasking(t *testing.T) {
+	store := newMemoryStateStore()
+	documentStateStoreOrig := documentStateStore
+	SetDocumentStateStore(store)
+	defer SetDocumentStateStore(documentStateStoreOrig)
+
+	payload := messageContracts.SendCommandPayload{
+		CommandID: testMessageId,
+		Parameters: map[string]interface{}{
+			"password": "hunter2",
+			"user":     "admin",
+		},
+		DocumentContent: contracts.DocumentContent{
+			SchemaVersion: "2.0",
+			Parameters: map[string]*contracts.Parameter{
+				"password": {ParamType: "SecureString"},
+				"user":     {ParamType: "String"},
+				"token":    {ParamType: "SecureString", DefaultVal: "s3cr3t-token"},
+			},
+			MainSteps: []*contracts.InstancePluginConfig{{Action: "aws:runShellScript", Name: "connect"}},
+		},
+	}
+	msg := createMDSMessage(testMessageId, "{}", testTopicSend, testDestination)
+	docState := initializeSendCommandState(payload, "", "", msg)
+	assert.Equal(t, []string{"s3cr3t-token", "hunter2"}, docState.DocumentInformation.SecureParameterValues)
+
+	logMock := log.NewMockLog()
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(logMock)
+	ctx.On("AppConfig").Return(appconfig.DefaultConfig())
+	ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+
+	documentID := docState.DocumentInformation.DocumentID
+	persistDocumentState(logMock, documentID, testDestination, appconfig.DefaultLocationOfCurrent, docState)
+
+	runPlugins := func(context context.T, documentID string, plugins []model.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) map[string]*contracts.PluginResult {
+		return map[string]*contracts.PluginResult{"connect": {
+			Status:         contracts.ResultStatusSuccess,
+			Output:         "connected as admin with hunter2",
+			StandardOutput: "using s3cr3t-token",
+		}}
+	}
+	buildReply := func(pluginID string, results map[string]*contracts.PluginResult) messageContracts.SendReplyPayload {
+		return messageContracts.SendReplyPayload{DocumentStatus: contracts.ResultStatusSuccess, DocumentTraceOutput: results["connect"].Output.(string)}
+	}
+	var replies []map[string]*contracts.PluginResult
+	sendResponse := func(messageID string, pluginID string, results map[string]*contracts.PluginResult) {
+		replies = append(replies, results)
 	}
+	mdsMock := new(MockedMDS)
+	mdsMock.On("DeleteMessage", mock.Anything, mock.AnythingOfType("string")).Return(nil)
 
-	return
+	p := Processor{stopSignal: make(chan bool)}
+	p.processSendCommandMessage(ctx, mdsMock, "", runPlugins, task.NewChanneledCancelFlag(), buildReply, sendResponse, &docState)
+
+	if assert.Len(t, replies, 1) {
+		assert.Equal(t, "connected as admin with ****", replies[0]["connect"].Output)
+		assert.Equal(t, "using ****", replies[0]["connect"].StandardOutput)
+	}
+	for _, call := range logMock.Calls {
+		logged := fmt.Sprint(call.Arguments...)
+		assert.NotContains(t, logged, "hunter2")
+		assert.NotContains(t, logged, "s3cr3t-token")
+	}
+	logMock.AssertCalled(t, "Debugf", "Plugin outputs %v", mock.Anything)
+
+	// only the hashes of the values are kept on disk
+	completed := store.GetDocumentInterimState(logMock, documentID, testDestination, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, "connected as admin with hunter2", completed.DocumentInformation.DocumentTraceOutput)
+	assert.Empty(t, completed.DocumentInformation.SecureParameterValues)
+	assert.Len(t, completed.DocumentInformation.SecureParameterHashes, 2)
+	content, _ := jsonutil.Marshal(completed)
+	assert.NotContains(t, content, "s3cr3t-token")
 }
 
-func prepareTestProcessMessage(testTopic string) (proc Processor, testCase TestCaseProcessMessage) {
+// TestMaskOutput tests that the secure values are masked in structured outputs whatever their JSON encoding, and that
+// an output that can't be masked is not returned as is
+func TestMaskOutput(t *testing.T) {
+	secureValues := []string{"line1\nline2", `pa"ss\word`}
+	output := map[string]interface{}{
+		"steps":        []interface{}{"login with pa\"ss\\word", float64(1)},
+		"script":       "echo line1\nline2",
+		"pa\"ss\\word": true,
+	}
 
-	// create mock context and log
-	contextMock := context.NewMockDefault()
+	masked := maskOutput(output, secureValues)
+	assert.Equal(t, map[string]interface{}{
+		"steps":  []interface{}{"login with ****", float64(1)},
+		"script": "echo ****",
+		"****":   true,
+	}, masked)
+	assert.Equal(t, "echo line1\nline2", output["script"])
 
-	// create dummy message that would be passed processMessage
-	message := ssmmds.Message{
-		CreatedDate: &testCreatedDate,
-		Destination: &testDestination,
-		MessageId:   &testMessageId,
-		Topic:       &testTopic,
+	// the logged JSON contents are masked as well
+	content, err := jsonutil.Marshal(output)
+	assert.NoError(t, err)
+	assert.NotContains(t, maskSecureValues(content, secureValues), "word")
+
+	assert.Equal(t, maskedValue, maskOutput(struct{ C chan int }{make(chan int)}, secureValues))
+}
+
+// TestSecureParameterMaskingResumed tests that the secure values of a document resumed from its state file
+// are recovered from the configurations of its plugins
+func TestSecureParameterMaskingResumed(t *testing.T) {
+	docState := model.DocumentState{
+		DocumentInformation: model.DocumentInfo{
+			SecureParameterHashes: secureValueHashes([]string{"s3cr3t-token", "hunter2", "missing"}),
+		},
+		InstancePluginsInformation: []model.PluginState{{
+			Configuration: contracts.Configuration{
+				Properties: map[string]interface{}{
+					"runCommand":       []interface{}{"login admin hunter2", "echo done"},
+					"timeoutSeconds":   float64(30),
+					"workingDirectory": "",
+				},
+				Settings: struct{ Token string }{Token: "s3cr3t-token"},
+			},
+		}},
 	}
+	var resumed model.DocumentState
+	content, err := jsonutil.Marshal(docState)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal([]byte(content), &resumed))
 
-	// create a agentConfig with dummy instanceID and agentInfo
-	agentConfig := contracts.AgentConfiguration{
-		AgentInfo: contracts.AgentInfo{
-			Name:      "EC2Config",
-			Version:   "1",
-			Lang:      "en-US",
-			Os:        "linux",
-			OsVersion: "1",
+	assert.Equal(t, []string{"s3cr3t-token", "hunter2"}, documentSecureValues(&resumed))
+	assert.Equal(t, []string{"s3cr3t-token", "hunter2"}, resumed.DocumentInformation.SecureParameterValues)
+}
+
+// TestDocumentStatus tests that the status of a document is read from Current, then from Completed
+func TestDocumentStatus(t *testing.T) {
+	store := newMemoryStateStore()
+	documentStateStoreOrig := documentStateStore
+	SetDocumentStateStore(store)
+	defer SetDocumentStateStore(documentStateStoreOrig)
+	logger := log.NewMockLog()
+
+	inProgress := model.DocumentState{
+		DocumentInformation: model.DocumentInfo{DocumentID: "runningCommand", DocumentStatus: contracts.ResultStatusInProgress},
+		InstancePluginsInformation: []model.PluginState{
+			{Name: "aws:runShellScript", Id: "first", HasExecuted: true, Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess}},
+			{Name: "aws:runShellScript", Id: "second"},
 		},
-		InstanceID: *message.Destination,
 	}
+	completed := model.DocumentState{
+		DocumentInformation: model.DocumentInfo{DocumentID: "completedCommand", DocumentStatus: contracts.ResultStatusFailed},
+		InstancePluginsInformation: []model.PluginState{
+			{Name: "aws:runPowerShellScript", HasExecuted: true, Result: contracts.PluginResult{Status: contracts.ResultStatusFailed}},
+		},
+	}
+	store.PersistData(logger, "runningCommand", testDestination, appconfig.DefaultLocationOfCurrent, inProgress)
+	store.PersistData(logger, "completedCommand", testDestination, appconfig.DefaultLocationOfCompleted, completed)
+
+	p := Processor{context: context.NewMockDefault(), config: contracts.AgentConfiguration{InstanceID: testDestination}}
+
+	status, pluginStatuses, err := p.DocumentStatus("runningCommand")
+	assert.NoError(t, err)
+	assert.Equal(t, contracts.ResultStatusInProgress, status)
+	assert.Equal(t, map[string]contracts.ResultStatus{
+		"first":  contracts.ResultStatusSuccess,
+		"second": contracts.ResultStatusNotStarted,
+	}, pluginStatuses)
+
+	status, pluginStatuses, err = p.DocumentStatus("completedCommand")
+	assert.NoError(t, err)
+	assert.Equal(t, contracts.ResultStatusFailed, status)
+	assert.Equal(t, map[string]contracts.ResultStatus{"aws:runPowerShellScript": contracts.ResultStatusFailed}, pluginStatuses)
+
+	_, _, err = p.DocumentStatus("unknownCommand")
+	assert.IsType(t, &ErrDocumentNotFound{}, err)
+}
 
-	// create mocked service and set expectations
-	mdsMock := new(MockedMDS)
+func TestCheckOutputSize(t *testing.T) {
+	metrics := new(MockedProcessorMetrics)
+	metrics.On("RecordLargeOutput", mock.Anything).Return()
+	p := Processor{outputWarnBytes: 100}
+	p.SetMetrics(metrics)
 
-	// sendCommand and cancelCommand will be processed by separate worker pools
-	// so we can define the number of workers per each
-	sendCommandTaskPool := new(task.MockedPool)
-	cancelCommandTaskPool := new(task.MockedPool)
+	small := map[string]*contracts.PluginResult{
+		"aws:runScript": {Output: "done", StandardOutput: "done", StandardError: ""},
+	}
+	logger := log.NewMockLog()
+	logger.On("Warnf", mock.Anything, mock.Anything).Return(nil)
+	p.checkOutputSize(logger, "smallCommand", small)
+	logger.AssertNotCalled(t, "Warnf", mock.Anything, mock.Anything)
+	metrics.AssertNotCalled(t, "RecordLargeOutput", mock.Anything)
+
+	oversized := map[string]*contracts.PluginResult{
+		"aws:runScript":   {Output: strings.Repeat("o", 60), StandardOutput: strings.Repeat("o", 30)},
+		"aws:runScript.2": {StandardError: strings.Repeat("e", 20)},
+	}
+	logger = log.NewMockLog()
+	logger.On("Warnf", mock.Anything, mock.Anything).Return(nil)
+	p.checkOutputSize(logger, "oversizedCommand", oversized)
+	logger.AssertCalled(t, "Warnf", mock.Anything, mock.Anything)
+	metrics.AssertCalled(t, "RecordLargeOutput", 110)
+}
 
-	orchestrationRootDir := ""
+// pendingDocumentFixture is a processor whose document states are kept in memory, recording the document level
+// responses it sends
+type pendingDocumentFixture struct {
+	store             *memoryStateStore
+	logger            log.T
+	mdsMock           *MockedMDS
+	sendCommandPool   *task.MockedPool
+	cancelCommandPool *task.MockedPool
+	docLevelResponses map[string]string
+	processor         *Processor
+}
 
-	// create a mock sendDocLevelResponse function
-	isDocLevelResponseSent := false
-	sendDocLevelResponse := func(messageID string, resultStatus contracts.ResultStatus, documentTraceOutput string) {
-		isDocLevelResponseSent = true
+// newPendingDocumentFixture returns a pendingDocumentFixture and the function restoring the document state store
+func newPendingDocumentFixture() (fixture *pendingDocumentFixture, restore func()) {
+	documentStateStoreOrig := documentStateStore
+	fixture = &pendingDocumentFixture{
+		store:             newMemoryStateStore(),
+		logger:            log.NewMockLog(),
+		mdsMock:           new(MockedMDS),
+		sendCommandPool:   new(task.MockedPool),
+		cancelCommandPool: new(task.MockedPool),
+		docLevelResponses: make(map[string]string),
+	}
+	SetDocumentStateStore(fixture.store)
+	fixture.processor = &Processor{
+		context:           context.NewMockDefault(),
+		stopSignal:        make(chan bool),
+		service:           fixture.mdsMock,
+		sendCommandPool:   fixture.sendCommandPool,
+		cancelCommandPool: fixture.cancelCommandPool,
+		sendDocLevelResponse: func(messageID string, resultStatus contracts.ResultStatus, documentTraceOutput string) {
+			fixture.docLevelResponses[messageID] = string(resultStatus) + ": " + documentTraceOutput
+		},
 	}
+	return fixture, func() { SetDocumentStateStore(documentStateStoreOrig) }
+}
 
-	// create a mock persistData function
-	isDataPersisted := false
-	persistData := func(docState *model.DocumentState, bookkeeping string) {
-		isDataPersisted = true
+// newDocState persists a pending document of the type and returns its state
+func (fixture *pendingDocumentFixture) newDocState(documentID string, documentType model.DocumentType, requiredTags map[string]string) *model.DocumentState {
+	docState := &model.DocumentState{
+		DocumentType: documentType,
+		DocumentInformation: model.DocumentInfo{
+			DocumentID:   documentID,
+			CommandID:    documentID,
+			InstanceID:   testDestination,
+			MessageID:    "aws.ssm." + documentID + "." + testDestination,
+			RequiredTags: requiredTags,
+		},
 	}
+	fixture.store.PersistData(fixture.logger, documentID, testDestination, appconfig.DefaultLocationOfPending, *docState)
+	return docState
+}
 
-	// create a processor with all above
-	proc = Processor{
-		context:              contextMock,
-		config:               agentConfig,
-		service:              mdsMock,
-		pluginRunner:         pluginRunner,
-		sendCommandPool:      sendCommandTaskPool,
-		cancelCommandPool:    cancelCommandTaskPool,
-		sendDocLevelResponse: sendDocLevelResponse,
-		orchestrationRootDir: orchestrationRootDir,
-		persistData:          persistData,
+// completedDocState returns the state of a document in the completed folder
+func (fixture *pendingDocumentFixture) completedDocState(documentID string) model.DocumentState {
+	return fixture.store.GetDocumentInterimState(fixture.logger, documentID, testDestination, appconfig.DefaultLocationOfCompleted)
+}
+
+// TestRequiredTags tests that a document requiring tags the instance has is submitted, and that a document requiring
+// tags the instance doesn't have is completed as failed without running
+func TestRequiredTags(t *testing.T) {
+	fixture, restore := newPendingDocumentFixture()
+	defer restore()
+	getInstanceTagsOrig := getInstanceTags
+	getInstanceTags = func(log log.T) (map[string]string, error) {
+		return map[string]string{"Environment": "production", "team": "payments"}, nil
 	}
+	defer func() { getInstanceTags = getInstanceTagsOrig }()
+
+	matching := fixture.newDocState("matchingDocument", model.SendCommand, map[string]string{"Environment": "production", "team": ""})
+	notMatching := fixture.newDocState("notMatchingDocument", model.SendCommand, map[string]string{"Environment": "staging"})
+	fixture.mdsMock.On("DeleteMessage", mock.Anything, notMatching.DocumentInformation.MessageID).Return(nil)
+	fixture.sendCommandPool.On("Submit", mock.Anything, matching.DocumentInformation.MessageID, mock.AnythingOfType("task.Job")).Return(nil)
+
+	fixture.processor.ExecutePendingDocument(matching)
+	fixture.processor.ExecutePendingDocument(notMatching)
+
+	fixture.sendCommandPool.AssertNumberOfCalls(t, "Submit", 1)
+	fixture.mdsMock.AssertExpectations(t)
+	assert.True(t, fixture.store.IsDocumentPersisted("matchingDocument", testDestination, appconfig.DefaultLocationOfCurrent))
+	assert.True(t, fixture.store.IsDocumentPersisted("notMatchingDocument", testDestination, appconfig.DefaultLocationOfCompleted))
+	skipped := fixture.completedDocState("notMatchingDocument")
+	assert.Equal(t, contracts.ResultStatusFailed, skipped.DocumentInformation.DocumentStatus)
+	assert.Contains(t, skipped.DocumentInformation.DocumentTraceOutput, "Environment=staging")
+	assert.Equal(t, map[string]string{notMatching.DocumentInformation.MessageID: string(skipped.DocumentInformation.DocumentStatus) + ": " + skipped.DocumentInformation.DocumentTraceOutput}, fixture.docLevelResponses)
+}
 
-	testCase = TestCaseProcessMessage{
-		ContextMock:               contextMock,
-		Message:                   message,
-		MdsMock:                   mdsMock,
-		IsDocLevelResponseSent:    &isDocLevelResponseSent,
-		IsDataPersisted:           &isDataPersisted,
-		SendCommandTaskPoolMock:   sendCommandTaskPool,
-		CancelCommandTaskPoolMock: cancelCommandTaskPool,
+// TestProcessDocumentTypes tests that an agent configured to only process cancel commands submits the cancel commands,
+// and completes the send commands as failed without running them
+func TestProcessDocumentTypes(t *testing.T) {
+	fixture, restore := newPendingDocumentFixture()
+	defer restore()
+	fixture.processor.processDocumentTypes = []string{appconfig.DocumentTypeCancelCommand}
+
+	sendCommand := fixture.newDocState("sendCommand", model.SendCommand, nil)
+	cancelCommand := fixture.newDocState("cancelCommand", model.CancelCommand, nil)
+	fixture.mdsMock.On("DeleteMessage", mock.Anything, sendCommand.DocumentInformation.MessageID).Return(nil)
+	fixture.cancelCommandPool.On("Submit", mock.Anything, cancelCommand.DocumentInformation.MessageID, mock.AnythingOfType("task.Job")).Return(nil)
+
+	fixture.processor.ExecutePendingDocument(sendCommand)
+	fixture.processor.ExecutePendingDocument(cancelCommand)
+
+	fixture.sendCommandPool.AssertNotCalled(t, "Submit", mock.Anything, mock.Anything, mock.Anything)
+	fixture.cancelCommandPool.AssertNumberOfCalls(t, "Submit", 1)
+	fixture.mdsMock.AssertExpectations(t)
+	assert.True(t, fixture.store.IsDocumentPersisted("cancelCommand", testDestination, appconfig.DefaultLocationOfCurrent))
+	assert.True(t, fixture.store.IsDocumentPersisted("sendCommand", testDestination, appconfig.DefaultLocationOfCompleted))
+	skipped := fixture.completedDocState("sendCommand")
+	assert.Equal(t, contracts.ResultStatusFailed, skipped.DocumentInformation.DocumentStatus)
+	assert.Contains(t, skipped.DocumentInformation.DocumentTraceOutput, "only process the documents of types CancelCommand")
+	assert.Equal(t, map[string]string{sendCommand.DocumentInformation.MessageID: string(skipped.DocumentInformation.DocumentStatus) + ": " + skipped.DocumentInformation.DocumentTraceOutput}, fixture.docLevelResponses)
+}
+
+// TestProcessDocumentTypesSkippedCancelCommand tests that an agent configured to only process send commands completes
+// the cancel commands without cancelling their target, and without a document reply
+func TestProcessDocumentTypesSkippedCancelCommand(t *testing.T) {
+	fixture, restore := newPendingDocumentFixture()
+	defer restore()
+	fixture.processor.processDocumentTypes = []string{appconfig.DocumentTypeSendCommand}
+
+	cancelCommand := fixture.newDocState("cancelCommand", model.CancelCommand, nil)
+	cancelCommand.CancelInformation.CancelCommandID = "targetCommand"
+	fixture.mdsMock.On("DeleteMessage", mock.Anything, cancelCommand.DocumentInformation.MessageID).Return(nil)
+
+	fixture.processor.ExecutePendingDocument(cancelCommand)
+
+	fixture.cancelCommandPool.AssertNotCalled(t, "Submit", mock.Anything, mock.Anything, mock.Anything)
+	fixture.mdsMock.AssertExpectations(t)
+	assert.Empty(t, fixture.docLevelResponses)
+	skipped := fixture.completedDocState("cancelCommand")
+	assert.Equal(t, contracts.ResultStatusFailed, skipped.DocumentInformation.DocumentStatus)
+	assert.Contains(t, skipped.CancelInformation.DebugInfo, "Command targetCommand skipped")
+}
+
+// TestProcessPendingDocumentsPriority tests that the pending documents are submitted by decreasing priority, and in the
+// order they were created in when their priority is equal
+func TestProcessPendingDocumentsPriority(t *testing.T) {
+	store := newMemoryStateStore()
+	documentStateStoreOrig := documentStateStore
+	SetDocumentStateStore(store)
+	defer SetDocumentStateStore(documentStateStoreOrig)
+	logger := log.NewMockLog()
+
+	newDocState := func(documentID string, createdDate string, priority int) {
+		docState := model.DocumentState{
+			DocumentType: model.SendCommand,
+			DocumentInformation: model.DocumentInfo{
+				DocumentID:  documentID,
+				CommandID:   documentID,
+				InstanceID:  testDestination,
+				MessageID:   documentID,
+				CreatedDate: createdDate,
+				Priority:    priority,
+			},
+		}
+		store.PersistData(logger, documentID, testDestination, appconfig.DefaultLocationOfPending, docState)
+	}
+	newDocState("routine", "2017-03-01T10:00:00.000Z", 0)
+	newDocState("laterRoutine", "2017-03-01T10:02:00.000Z", 0)
+	newDocState("urgent", "2017-03-01T10:05:00.000Z", 10)
+	newDocState("earlierRoutine", "2017-03-01T09:58:00.000Z", 0)
+
+	var submitted []string
+	sendCommandPool := new(task.MockedPool)
+	sendCommandPool.On("Submit", mock.Anything, mock.Anything, mock.AnythingOfType("task.Job")).Return(nil).Run(func(args mock.Arguments) {
+		submitted = append(submitted, args.String(1))
+	})
+	p := Processor{
+		context:           context.NewMockDefault(),
+		stopSignal:        make(chan bool),
+		sendCommandPool:   sendCommandPool,
+		supportedDocTypes: []model.DocumentType{model.SendCommand},
 	}
+	p.processPendingDocuments(testDestination)
 
-	return
+	assert.Equal(t, []string{"urgent", "earlierRoutine", "routine", "laterRoutine"}, submitted)
 }
 
-func parsePluginResult(t *testing.T, pluginRuntimeStatus contracts.PluginRuntimeStatus) contracts.PluginResult {
-	parsedOutput := pluginRuntimeStatus.Output
-	return contracts.PluginResult{
-		Output:        parsedOutput,
-		Status:        pluginRuntimeStatus.Status,
-		StartDateTime: times.ParseIso8601UTC(pluginRuntimeStatus.StartDateTime),
-		EndDateTime:   times.ParseIso8601UTC(pluginRuntimeStatus.EndDateTime),
+// TestPollOnceSubmitsPolledDocumentsByPriority tests that the documents of the messages of a poll are submitted by
+// decreasing priority once all the messages are processed, and in the order they were created in when their priority is equal
+func TestPollOnceSubmitsPolledDocumentsByPriority(t *testing.T) {
+	store := newMemoryStateStore()
+	documentStateStoreOrig, processMessageOrig := documentStateStore, processMessage
+	defer func() {
+		SetDocumentStateStore(documentStateStoreOrig)
+		processMessage = processMessageOrig
+	}()
+	SetDocumentStateStore(store)
+
+	proc, tc := prepareTestPollOnce()
+	var submitted []string
+	sendCommandPool := new(task.MockedPool)
+	sendCommandPool.On("Submit", mock.Anything, mock.Anything, mock.AnythingOfType("task.Job")).Return(nil).Run(func(args mock.Arguments) {
+		submitted = append(submitted, args.String(1))
+	})
+	proc.sendCommandPool = sendCommandPool
+	proc.stopSignal = make(chan bool)
+
+	priorities := map[string]int{"routine": 0, "laterRoutine": 0, "urgent": 10}
+	var messages []*ssmmds.Message
+	for i, messageID := range []string{"routine", "urgent", "laterRoutine"} {
+		messages = append(messages, &ssmmds.Message{
+			MessageId:   aws.String(messageID),
+			CreatedDate: aws.String(fmt.Sprintf("2017-03-01T10:0%v:00.000Z", i)),
+		})
+	}
+	tc.MdsMock.On("GetMessages", mock.AnythingOfType("*log.Mock"), mock.AnythingOfType("string")).Return(&ssmmds.GetMessagesOutput{
+		Destination:       &testDestination,
+		Messages:          messages,
+		MessagesRequestId: &testMessageId,
+	}, nil)
+
+	// the documents are only submitted once every message of the poll is processed
+	processMessage = func(proc *Processor, msg *ssmmds.Message) {
+		assert.Empty(t, submitted)
+		docState := model.DocumentState{
+			DocumentType: model.SendCommand,
+			DocumentInformation: model.DocumentInfo{
+				DocumentID:  *msg.MessageId,
+				CommandID:   *msg.MessageId,
+				InstanceID:  testDestination,
+				MessageID:   *msg.MessageId,
+				CreatedDate: *msg.CreatedDate,
+				Priority:    priorities[*msg.MessageId],
+			},
+		}
+		store.PersistData(logger, *msg.MessageId, testDestination, appconfig.DefaultLocationOfPending, docState)
+		proc.executePolledDocument(&docState)
+	}
+	proc.pollOnce()
+
+	assert.Equal(t, []string{"urgent", "routine", "laterRoutine"}, submitted)
+
+	// outside of a poll the document is executed right away
+	docState := model.DocumentState{
+		DocumentType:        model.SendCommand,
+		DocumentInformation: model.DocumentInfo{DocumentID: "direct", CommandID: "direct", InstanceID: testDestination, MessageID: "direct"},
 	}
+	proc.executePolledDocument(&docState)
+	assert.Equal(t, []string{"urgent", "routine", "laterRoutine", "direct"}, submitted)
 }
 
-func loadFile(t *testing.T, fileName string) (result []byte) {
-	result, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		t.Fatal(err)
+// TestSendInProgressReplyJitter tests that the InProgress reply is sent in the background once a random delay within
+// the bound has elapsed, unless the document completed in the meantime
+func TestSendInProgressReplyJitter(t *testing.T) {
+	bound := 500 * time.Millisecond
+	for i := 0; i < 100; i++ {
+		delay := randomJitter(bound)
+		assert.True(t, delay >= 0 && delay <= bound, "delay %v out of bound", delay)
 	}
-	return
+
+	isDocumentInProgressOrig := isDocumentInProgress
+	defer func() { isDocumentInProgress = isDocumentInProgressOrig }()
+	inProgress := make(chan bool, 1)
+	isDocumentInProgress = func(documentID, instanceID string) bool { return <-inProgress }
+
+	var delay time.Duration
+	delayElapsed := make(chan struct{})
+	clock := times.NewMockedClock()
+	clock.On("After", mock.AnythingOfType("time.Duration")).Return(delayElapsed).Run(func(args mock.Arguments) {
+		delay = args.Get(0).(time.Duration)
+	})
+	replied := make(chan contracts.ResultStatus, 1)
+	p := Processor{
+		stopSignal:            make(chan bool),
+		clock:                 clock,
+		inProgressReplyJitter: bound,
+		sendDocLevelResponse: func(messageID string, resultStatus contracts.ResultStatus, documentTraceOutput string) {
+			replied <- resultStatus
+		},
+	}
+
+	// the reply doesn't block the caller
+	p.sendInProgressReply(log.NewMockLog(), testMessageId, testMessageId)
+	select {
+	case <-replied:
+		t.Fatal("replied before the delay elapsed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	inProgress <- true
+	delayElapsed <- struct{}{}
+	assert.Equal(t, contracts.ResultStatusInProgress, <-replied)
+	assert.True(t, delay >= 0 && delay <= bound, "delay %v out of bound", delay)
+
+	// a document that completed during the delay isn't reported in progress again
+	p.sendInProgressReply(log.NewMockLog(), testMessageId, testMessageId)
+	inProgress <- false
+	delayElapsed <- struct{}{}
+	select {
+	case <-replied:
+		t.Fatal("replied for a completed document")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the shutdown cuts the delay short
+	p.sendInProgressReply(log.NewMockLog(), testMessageId, testMessageId)
+	inProgress <- true
+	close(p.stopSignal)
+	assert.Equal(t, contracts.ResultStatusInProgress, <-replied)
+
+	// without jitter the reply is immediate
+	p.inProgressReplyJitter = 0
+	p.sendInProgressReply(log.NewMockLog(), testMessageId, testMessageId)
+	assert.Equal(t, contracts.ResultStatusInProgress, <-replied)
+	clock.AssertNumberOfCalls(t, "After", 3)
 }
 
-func loadMessageFromFile(t *testing.T, fileName string) (message messageContracts.SendCommandPayload) {
-	b := loadFile(t, fileName)
-	err := json.Unmarshal(b, &message)
+// stubOutputUploader records the objects uploaded to it and fails the uploads of failKey
+type stubOutputUploader struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string]string
+	failKey string
+}
+
+func (u *stubOutputUploader) UploadOutput(log log.T, bucketName string, objectKey string, content io.ReadSeeker) error {
+	if objectKey == u.failKey {
+		return errors.New("upload failed")
+	}
+	data, err := ioutil.ReadAll(content)
 	if err != nil {
-		t.Fatal(err)
+		return err
 	}
-	return message
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bucket = bucketName
+	u.objects[objectKey] = string(data)
+	return nil
 }
 
-func loadMessageReplyFromFile(t *testing.T, fileName string) (message messageContracts.SendReplyPayload) {
-	b := loadFile(t, fileName)
-	err := json.Unmarshal(b, &message)
-	if err != nil {
-		t.Fatal(err)
+// TestInitializeSendCommandStateWorkingDirectory tests that the working directory of the document, or the one its steps
+// override it with, reaches the configuration of the plugins
+func TestInitializeSendCommandStateWorkingDirectory(t *testing.T) {
+	payload := messageContracts.SendCommandPayload{
+		CommandID: testMessageId,
+		DocumentContent: contracts.DocumentContent{
+			SchemaVersion:    "2.0",
+			WorkingDirectory: "/var/scratch",
+			MainSteps: []*contracts.InstancePluginConfig{
+				{Action: "aws:runShellScript", Name: "document"},
+				{Action: "aws:runShellScript", Name: "step", WorkingDirectory: "/opt/step"},
+			},
+		},
 	}
-	return message
+	msg := createMDSMessage(testMessageId, "{}", testTopicSend, testDestination)
+
+	docState := initializeSendCommandState(payload, "", "", msg)
+
+	assert.Equal(t, "/var/scratch", docState.InstancePluginsInformation[0].Configuration.DefaultWorkingDirectory)
+	assert.Equal(t, "/opt/step", docState.InstancePluginsInformation[1].Configuration.DefaultWorkingDirectory)
 }
 
-func mockParseSendCommand(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
-	return &model.DocumentState{
-		DocumentType: model.SendCommand,
-	}, nil
+// TestReplyBuilderCapabilities tests that the capabilities of the agent are in the replies only when they are reported
+func TestReplyBuilderCapabilities(t *testing.T) {
+	registeredPluginNamesOrig, isPluginSupportedOrig := registeredPluginNames, isPluginSupported
+	defer func() {
+		registeredPluginNames, isPluginSupported = registeredPluginNamesOrig, isPluginSupportedOrig
+	}()
+	registeredPluginNames = func(context context.T) []string {
+		return []string{"aws:runShellScript", "aws:runPowerShellScript", "aws:configurePackage"}
+	}
+	isPluginSupported = func(context context.T, pluginName string) (bool, string) {
+		return pluginName != "aws:runPowerShellScript", "plugin is not supported on linux"
+	}
+	agentInfo := contracts.AgentInfo{Name: "amazon-ssm-agent", Version: "2.0.0", Os: "linux", OsVersion: "1"}
+	results := map[string]*contracts.PluginResult{
+		"aws:runShellScript": {PluginName: "aws:runShellScript", Status: contracts.ResultStatusSuccess},
+	}
+	buildReply := newReplyBuilder(logger, times.DefaultClock, agentInfo, appconfig.DefaultMaxPluginOutputBytes, false)
+
+	// reported capabilities list the supported plugins, sorted
+	payload := withCapabilities(buildReply, agentCapabilities(context.NewMockDefault(), agentInfo))("", results)
+	capabilities := payload.AdditionalInfo.Capabilities
+	assert.NotNil(t, capabilities)
+	assert.Equal(t, "2.0.0", capabilities.AgentVersion)
+	assert.Equal(t, "linux", capabilities.Os)
+	assert.Equal(t, "1", capabilities.OsVersion)
+	assert.Equal(t, []string{"aws:configurePackage", "aws:runShellScript"}, capabilities.Plugins)
+	assert.Contains(t, capabilities.Features, "executionGroup")
+	replied, err := json.Marshal(payload)
+	assert.NoError(t, err)
+	assert.Contains(t, string(replied), `"capabilities":{"agentVersion":"2.0.0"`)
+
+	// without capabilities the replies don't have the block
+	payload = withCapabilities(buildReply, nil)("", results)
+	assert.Nil(t, payload.AdditionalInfo.Capabilities)
+	replied, err = json.Marshal(payload)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(replied), "capabilities")
 }
 
-func mockParseCancelCommand(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
-	return &model.DocumentState{
-		DocumentType: model.CancelCommand,
-	}, nil
+// TestDocumentExpiredClockSkew tests the run or skip decision of a document close to its expiration when the instance
+// clock is behind or ahead of the clock of MDS
+func TestDocumentExpiredClockSkew(t *testing.T) {
+	serverNow := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name         string
+		localSkew    time.Duration
+		expiresAfter time.Duration
+		useServer    bool
+		expired      bool
+	}{
+		// the instance clock is 10 minutes behind and the document expired 2 minutes ago
+		{"behind with the time of the service", -10 * time.Minute, -2 * time.Minute, true, true},
+		{"behind with the instance time", -10 * time.Minute, -2 * time.Minute, false, false},
+		// the instance clock is 30 seconds ahead and the document expires in 10 seconds
+		{"ahead within the tolerance", 30 * time.Second, 10 * time.Second, true, false},
+		// the instance clock is 5 minutes ahead and the document expires in 2 minutes
+		{"ahead beyond the tolerance", 5 * time.Minute, 2 * time.Minute, true, true},
+	}
+	for _, testCase := range testCases {
+		localNow := serverNow.Add(testCase.localSkew)
+		clock := times.NewMockedClock()
+		clock.On("Now").Return(localNow)
+		logger := log.NewMockLog()
+		logger.On("Warnf", mock.Anything, mock.Anything).Return(nil)
+		p := Processor{clock: clock, serverClock: newServerClock(testCase.useServer), clockSkewTolerance: time.Minute}
+
+		// the message of the document was created by the service right before it was received
+		createdDate := times.ToIso8601UTC(serverNow)
+		p.observeMessageClock(logger, &ssmmds.Message{CreatedDate: &createdDate})
+		reason := p.documentExpired(serverNow.Add(testCase.expiresAfter).Format(time.RFC3339))
+
+		assert.Equal(t, testCase.expired, reason != "", testCase.name)
+		if testCase.useServer && testCase.localSkew < -p.clockSkewTolerance {
+			logger.AssertCalled(t, "Warnf", mock.Anything, mock.Anything)
+		} else {
+			logger.AssertNotCalled(t, "Warnf", mock.Anything, mock.Anything)
+		}
+	}
+
+	// documents without an expiration never expire, invalid expirations are skipped
+	p := Processor{clock: times.DefaultClock}
+	assert.Empty(t, p.documentExpired(""))
+	assert.Contains(t, p.documentExpired("tomorrow"), "not valid")
+
+	// messages without a creation date leave the skew unchanged
+	p = Processor{clock: times.DefaultClock, serverClock: &serverClock{skew: time.Minute}}
+	assert.NotPanics(t, func() { p.observeMessageClock(log.NewMockLog(), &ssmmds.Message{}) })
+	assert.Equal(t, time.Minute, p.serverClock.skew)
 }
@@ -0,0 +1,66 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_unsupported contains the refresh of the documents incompatible with managed instances from a remote list
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// unsupportedDocumentsTimeout bounds the time the remote list of unsupported documents can take to download
+const unsupportedDocumentsTimeout = 30 * time.Second
+
+// unsupportedDocumentsList is the format of the remote list, the same as the managed instance compatibility config
+type unsupportedDocumentsList struct {
+	IncompatibleDocuments []string
+}
+
+var fetchUnsupportedDocuments = fetchUnsupportedDocumentsFromURL
+
+// fetchUnsupportedDocumentsFromURL downloads the names of the unsupported documents from an http(s) url
+func fetchUnsupportedDocumentsFromURL(url string) (documentNames []string, err error) {
+	client := &http.Client{Timeout: unsupportedDocumentsTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%v answered with status %v", url, resp.Status)
+	}
+	var list unsupportedDocumentsList
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid list of unsupported documents at %v, %v", url, err)
+	}
+	return list.IncompatibleDocuments, nil
+}
+
+// refreshUnsupportedDocuments replaces the documents incompatible with managed instances with the remote list.
+// The last list is kept when the remote list can't be fetched.
+func (p *Processor) refreshUnsupportedDocuments() {
+	log := p.context.Log()
+	documentNames, err := fetchUnsupportedDocuments(p.unsupportedDocumentsURL)
+	if err != nil {
+		log.Errorf("failed to refresh the unsupported documents, keeping the last list: %v", err)
+		return
+	}
+	swapUnsupportedDocuments(documentNames)
+	// the remote list is authoritative, it must not be overwritten by the first load of the local list
+	once.Do(func() {})
+	log.Debugf("refreshed the unsupported documents from %v: %v", p.unsupportedDocumentsURL, documentNames)
+}
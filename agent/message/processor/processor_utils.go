@@ -71,6 +71,12 @@ func newDocumentInfo(msg ssmmds.Message, parsedMsg messageContracts.SendCommandP
 	documentInfo.RunID = times.ToIsoDashUTC(times.DefaultClock.Now())
 	documentInfo.CreatedDate = *msg.CreatedDate
 	documentInfo.DocumentName = parsedMsg.DocumentName
+	documentInfo.AggregationPolicy = parsedMsg.DocumentContent.AggregationPolicy
+	documentInfo.RequiredTags = parsedMsg.DocumentContent.RequiredTags
+	documentInfo.ExpiresAfter = parsedMsg.DocumentContent.ExpiresAfter
+	documentInfo.Priority = parsedMsg.DocumentContent.Priority
+	documentInfo.SecureParameterValues = secureParameterValues(parsedMsg)
+	documentInfo.SecureParameterHashes = secureValueHashes(documentInfo.SecureParameterValues)
 	documentInfo.IsCommand = true
 	documentInfo.DocumentStatus = contracts.ResultStatusInProgress
 	documentInfo.DocumentTraceOutput = ""
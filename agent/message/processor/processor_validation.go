@@ -0,0 +1,66 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor implements MDS plugin processor
+// processor_validation contains the checks run on a document before it is acknowledged
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/framework/plugin"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// isPluginSupported returns true if a worker or long running plugin with that name is registered and supported on
+// the current platform, otherwise false with the reason.
+var isPluginSupported = func(context context.T, pluginName string) (bool, string) {
+	_, isWorkerPlugin := plugin.RegisteredWorkerPlugins(context)[pluginName]
+	_, isLongRunningPlugin := plugin.RegisteredLongRunningPlugins(context)[pluginName]
+	if !isWorkerPlugin && !isLongRunningPlugin {
+		return false, "plugin is not registered"
+	}
+	if supported, platformDetail := plugin.IsPluginSupportedForCurrentPlatform(context.Log(), pluginName); !supported {
+		return false, fmt.Sprintf("plugin is not supported on %v", platformDetail)
+	}
+	return true, ""
+}
+
+// validateDocumentPlugins returns an error naming the first plugin of the document that can't run on this instance
+func validateDocumentPlugins(context context.T, docState *model.DocumentState) error {
+	for _, pluginState := range docState.InstancePluginsInformation {
+		if supported, reason := isPluginSupported(context, pluginState.Name); !supported {
+			return fmt.Errorf("document %v can't be executed, %v: %v", docState.DocumentInformation.DocumentName, pluginState.Name, reason)
+		}
+	}
+	return nil
+}
+
+// validateOutputS3KeyPrefix returns an error if the S3 key prefix requested by a command contains control characters
+// or relative path segments that would place the outputs outside of the keys of the command
+func validateOutputS3KeyPrefix(outputS3KeyPrefix string) error {
+	for _, r := range outputS3KeyPrefix {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("output S3 key prefix %q contains control characters", outputS3KeyPrefix)
+		}
+	}
+	for _, segment := range strings.FieldsFunc(outputS3KeyPrefix, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("output S3 key prefix %q contains relative path segments", outputS3KeyPrefix)
+		}
+	}
+	return nil
+}
@@ -14,6 +14,8 @@
 package processor
 
 import (
+	"time"
+
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
@@ -45,7 +47,7 @@ type MockedPluginRunner struct {
 }
 
 // RunPlugins mocks a PluginRunner (which is a func).
-func (runnerMock *MockedPluginRunner) RunPlugins(context context.T, documentID string, plugins []stateModel.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag) (pluginOutputs map[string]*contracts.PluginResult) {
+func (runnerMock *MockedPluginRunner) RunPlugins(context context.T, documentID string, plugins []stateModel.PluginState, sendResponse runpluginutil.SendResponse, cancelFlag task.CancelFlag, shutdownSignal <-chan bool) (pluginOutputs map[string]*contracts.PluginResult) {
 	args := runnerMock.Called(context, documentID, plugins, sendResponse, cancelFlag)
 	return args.Get(0).(map[string]*contracts.PluginResult)
 }
@@ -61,6 +63,31 @@ func (replyBuilderMock *MockedReplyBuilder) BuildReply(pluginID string, pluginRe
 	return args.Get(0).(model.SendReplyPayload)
 }
 
+// MockedProcessorMetrics stands for a mock metrics sink.
+type MockedProcessorMetrics struct {
+	mock.Mock
+}
+
+// RecordMessageReceived mocks the ProcessorMetrics function with the same name.
+func (metricsMock *MockedProcessorMetrics) RecordMessageReceived() {
+	metricsMock.Called()
+}
+
+// RecordMessageProcessed mocks the ProcessorMetrics function with the same name.
+func (metricsMock *MockedProcessorMetrics) RecordMessageProcessed(duration time.Duration) {
+	metricsMock.Called(duration)
+}
+
+// RecordMessageFailed mocks the ProcessorMetrics function with the same name.
+func (metricsMock *MockedProcessorMetrics) RecordMessageFailed(reason string) {
+	metricsMock.Called(reason)
+}
+
+// RecordLargeOutput mocks the ProcessorMetrics function with the same name.
+func (metricsMock *MockedProcessorMetrics) RecordLargeOutput(bytes int) {
+	metricsMock.Called(bytes)
+}
+
 // MockedMDS stands for a mock MDS service.
 type MockedMDS struct {
 	mock.Mock
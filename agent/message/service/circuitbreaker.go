@@ -0,0 +1,170 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package service is a wrapper for the SSM Message Delivery Service
+package service
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/times"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState string
+
+const (
+	// CircuitClosed lets the calls through
+	CircuitClosed CircuitState = "Closed"
+
+	// CircuitOpen fails the calls without making them until the cooldown has elapsed
+	CircuitOpen CircuitState = "Open"
+
+	// CircuitHalfOpen lets a single call through to probe whether the service has recovered
+	CircuitHalfOpen CircuitState = "HalfOpen"
+)
+
+// ErrCircuitOpen is returned instead of calling the service while the circuit breaker is open
+var ErrCircuitOpen = errors.New("MDS calls are suspended after consecutive failures")
+
+// throttlingErrorMessages are the messages of the errors returned when the calls are throttled
+var throttlingErrorMessages = []string{"Throttling", "RequestLimitExceeded", "TooManyRequests", "status code: 429"}
+
+// serverErrorPattern matches the status code of the errors returned by a failing service
+var serverErrorPattern = regexp.MustCompile(`status code: 5\d\d`)
+
+// networkErrorMessages are the messages of the errors returned when the service can't be reached
+var networkErrorMessages = []string{"RequestError", "connection refused", "no such host", "network is unreachable", "connection reset by peer"}
+
+// CircuitBreakerService is a Service that stops polling the underlying service after failureThreshold consecutive
+// GetMessages calls failed because the service was throttling, failing or unreachable. GetMessages fails with
+// ErrCircuitOpen for the cooldown, then a single call probes the service: the circuit closes if it succeeds, and opens
+// again if it fails. The other calls always go through.
+type CircuitBreakerService struct {
+	Service
+	failureThreshold int
+	cooldown         time.Duration
+	clock            times.Clock
+
+	lock                sync.Mutex
+	state               CircuitState
+	consecutiveFailures int
+	openedAt            time.Time
+}
+
+// NewCircuitBreakerService wraps service in a circuit breaker.
+func NewCircuitBreakerService(service Service, failureThreshold int, cooldown time.Duration, clock times.Clock) *CircuitBreakerService {
+	return &CircuitBreakerService{
+		Service:          service,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		clock:            clock,
+		state:            CircuitClosed,
+	}
+}
+
+// State returns the current state of the circuit breaker.
+func (s *CircuitBreakerService) State() CircuitState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state == CircuitOpen && s.clock.Now().Sub(s.openedAt) >= s.cooldown {
+		return CircuitHalfOpen
+	}
+	return s.state
+}
+
+// GetMessages calls GetMessages of the service unless the circuit is open
+func (s *CircuitBreakerService) GetMessages(log log.T, instanceID string) (messages *ssmmds.GetMessagesOutput, err error) {
+	err = s.call(log, func() error {
+		messages, err = s.Service.GetMessages(log, instanceID)
+		return err
+	})
+	return messages, err
+}
+
+// call makes the call if the circuit allows it and records its outcome
+func (s *CircuitBreakerService) call(log log.T, serviceCall func() error) error {
+	if !s.allow() {
+		return ErrCircuitOpen
+	}
+	err := serviceCall()
+	s.record(log, err)
+	return err
+}
+
+// allow returns true if the call can be made, moving an open circuit whose cooldown elapsed to half-open
+func (s *CircuitBreakerService) allow() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch s.state {
+	case CircuitOpen:
+		if s.clock.Now().Sub(s.openedAt) < s.cooldown {
+			return false
+		}
+		// this call is the probe, the other ones are short-circuited until it completes
+		s.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the circuit with the outcome of a call
+func (s *CircuitBreakerService) record(log log.T, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// the other errors, like a rejected request or a timed out long poll, show that the service is reachable
+	if err == nil || !isUnavailableError(err) {
+		if s.state != CircuitClosed {
+			log.Infof("MDS calls succeed again, closing the circuit breaker")
+		}
+		s.state = CircuitClosed
+		s.consecutiveFailures = 0
+		return
+	}
+
+	s.consecutiveFailures++
+	if s.state == CircuitHalfOpen || s.consecutiveFailures >= s.failureThreshold {
+		if s.state != CircuitOpen {
+			log.Errorf("%v consecutive MDS calls failed, suspending the calls for %v", s.consecutiveFailures, s.cooldown)
+		}
+		s.state = CircuitOpen
+		s.openedAt = s.clock.Now()
+	}
+}
+
+// isUnavailableError returns true if the error shows the service throttling, failing or unreachable. Timeouts don't,
+// since the long polls of GetMessages time out when there is no message.
+func isUnavailableError(err error) bool {
+	message := err.Error()
+	if strings.Contains(strings.ToLower(message), "timeout") {
+		return false
+	}
+	if serverErrorPattern.MatchString(message) {
+		return true
+	}
+	for _, known := range append(throttlingErrorMessages, networkErrorMessages...) {
+		if strings.Contains(message, known) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package service is a wrapper for the SSM Message Delivery Service
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingService is a Service whose calls fail with err, it counts the calls it receives
+type failingService struct {
+	err   error
+	calls int
+}
+
+func (s *failingService) GetMessages(log log.T, instanceID string) (*ssmmds.GetMessagesOutput, error) {
+	s.calls++
+	return &ssmmds.GetMessagesOutput{}, s.err
+}
+
+func (s *failingService) AcknowledgeMessage(log log.T, messageID string) error {
+	s.calls++
+	return s.err
+}
+
+func (s *failingService) SendReply(log log.T, messageID string, payload string) error {
+	s.calls++
+	return s.err
+}
+
+func (s *failingService) FailMessage(log log.T, messageID string, failureType FailureType) error {
+	s.calls++
+	return s.err
+}
+
+func (s *failingService) DeleteMessage(log log.T, messageID string) error {
+	s.calls++
+	return s.err
+}
+
+func (s *failingService) Stop() {}
+
+// fakeClock is a clock moved forward by the tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) chan struct{} {
+	return make(chan struct{})
+}
+
+// errServiceUnavailable is the error of a GetMessages call MDS failed
+var errServiceUnavailable = errors.New("GetMessages Error: ServiceUnavailable: Service Unavailable\n\tstatus code: 503, request id: 1")
+
+func TestCircuitBreakerServiceTransitions(t *testing.T) {
+	logger := log.NewMockLog()
+	mds := &failingService{err: errServiceUnavailable}
+	clock := &fakeClock{now: time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)}
+	breaker := NewCircuitBreakerService(mds, 3, time.Minute, clock)
+	assert.Equal(t, CircuitClosed, breaker.State())
+
+	// opens after 3 consecutive failures
+	for i := 0; i < 2; i++ {
+		_, err := breaker.GetMessages(logger, "i-400e1090")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, CircuitClosed, breaker.State())
+	_, err := breaker.GetMessages(logger, "i-400e1090")
+	assert.Error(t, err)
+	assert.Equal(t, CircuitOpen, breaker.State())
+	assert.Equal(t, 3, mds.calls)
+
+	// short-circuits the polling during the cooldown, the other calls still go through
+	_, err = breaker.GetMessages(logger, "i-400e1090")
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 3, mds.calls)
+	assert.Equal(t, errServiceUnavailable, breaker.DeleteMessage(logger, "message"))
+	assert.Equal(t, 4, mds.calls)
+
+	// half-opens after the cooldown, a failed probe opens the circuit again
+	clock.now = clock.now.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	_, err = breaker.GetMessages(logger, "i-400e1090")
+	assert.Equal(t, errServiceUnavailable, err)
+	assert.Equal(t, 5, mds.calls)
+	assert.Equal(t, CircuitOpen, breaker.State())
+	_, err = breaker.GetMessages(logger, "i-400e1090")
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 5, mds.calls)
+
+	// a successful probe closes the circuit
+	clock.now = clock.now.Add(time.Minute)
+	mds.err = nil
+	_, err = breaker.GetMessages(logger, "i-400e1090")
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Equal(t, 6, mds.calls)
+}
+
+func TestCircuitBreakerServiceResetsOnSuccess(t *testing.T) {
+	logger := log.NewMockLog()
+	mds := &failingService{err: errServiceUnavailable}
+	breaker := NewCircuitBreakerService(mds, 2, time.Minute, &fakeClock{now: time.Now()})
+
+	// failures separated by a success aren't consecutive
+	breaker.GetMessages(logger, "i-400e1090")
+	mds.err = nil
+	breaker.GetMessages(logger, "i-400e1090")
+	mds.err = errServiceUnavailable
+	breaker.GetMessages(logger, "i-400e1090")
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
+
+func TestCircuitBreakerServiceCountsUnavailableErrorsOnly(t *testing.T) {
+	logger := log.NewMockLog()
+	for _, err := range []error{
+		errors.New("GetMessages Error: AccessDeniedException: not authorized\n\tstatus code: 400, request id: 1"),
+		errors.New("GetMessages Error: RequestError: send request failed\ncaused by: net/http: request canceled (Client.Timeout exceeded while awaiting headers)"),
+	} {
+		breaker := NewCircuitBreakerService(&failingService{err: err}, 1, time.Minute, &fakeClock{now: time.Now()})
+		breaker.GetMessages(logger, "i-400e1090")
+		assert.Equal(t, CircuitClosed, breaker.State(), err.Error())
+	}
+	for _, err := range []error{
+		errServiceUnavailable,
+		errors.New("GetMessages Error: ThrottlingException: Rate exceeded\n\tstatus code: 400, request id: 1"),
+		errors.New("GetMessages Error: RequestError: send request failed\ncaused by: dial tcp: lookup ec2messages.us-east-1.amazonaws.com: no such host"),
+	} {
+		breaker := NewCircuitBreakerService(&failingService{err: err}, 1, time.Minute, &fakeClock{now: time.Now()})
+		breaker.GetMessages(logger, "i-400e1090")
+		assert.Equal(t, CircuitOpen, breaker.State(), err.Error())
+	}
+}
+
+func TestCircuitBreakerServiceHalfOpenAllowsSingleProbe(t *testing.T) {
+	logger := log.NewMockLog()
+	clock := &fakeClock{now: time.Now()}
+	probing := make(chan bool)
+	release := make(chan bool)
+	mds := &blockingService{failingService: failingService{err: errServiceUnavailable}}
+	breaker := NewCircuitBreakerService(mds, 1, time.Minute, clock)
+	breaker.GetMessages(logger, "i-400e1090")
+
+	clock.now = clock.now.Add(time.Minute)
+	mds.err = nil
+	mds.probing, mds.release = probing, release
+	done := make(chan error)
+	go func() {
+		_, err := breaker.GetMessages(logger, "i-400e1090")
+		done <- err
+	}()
+
+	<-probing
+	_, err := breaker.GetMessages(logger, "i-400e1090")
+	assert.Equal(t, ErrCircuitOpen, err)
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
+
+// blockingService is a failingService whose GetMessages waits for release once it signalled probing
+type blockingService struct {
+	failingService
+	probing chan bool
+	release chan bool
+}
+
+func (s *blockingService) GetMessages(log log.T, instanceID string) (*ssmmds.GetMessagesOutput, error) {
+	if s.probing != nil {
+		s.probing <- true
+		<-s.release
+	}
+	return s.failingService.GetMessages(log, instanceID)
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package service is a wrapper for the SSM Message Delivery Service
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewServiceWithEndpointOverride(t *testing.T) {
+	svc := NewService("us-iso-east-1", "https://ec2messages.us-iso-east-1.c2s.ic.gov", nil, time.Second)
+
+	sdkSvc, ok := svc.(*sdkService)
+	assert.True(t, ok)
+	assert.Equal(t, "https://ec2messages.us-iso-east-1.c2s.ic.gov", sdkSvc.sdk.Endpoint)
+	assert.Equal(t, "us-iso-east-1", *sdkSvc.sdk.Config.Region)
+}
+
+func TestNewServiceWithoutEndpointOverride(t *testing.T) {
+	svc := NewService("us-east-1", "", nil, time.Second)
+
+	sdkSvc, ok := svc.(*sdkService)
+	assert.True(t, ok)
+	assert.Contains(t, sdkSvc.sdk.Endpoint, "us-east-1.amazonaws.com")
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the AWS Customer Agreement (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/agreement/
+
+// Package platform provides instance information
+package platform
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// InstanceContext holds the instance information used while processing documents.
+type InstanceContext struct {
+	InstanceID        string
+	Region            string
+	PlatformName      string
+	PlatformVersion   string
+	IsManagedInstance bool
+}
+
+// instanceContextTTL is how long a looked up instance context is served from the cache
+var instanceContextTTL = 5 * time.Minute
+
+var lookupInstanceContext = fetchInstanceContext
+var timeNow = time.Now
+
+var cachedInstanceContext struct {
+	lock      sync.Mutex
+	context   InstanceContext
+	fetchedAt time.Time
+	valid     bool
+}
+
+// CachedInstanceContext returns the instance context, looking it up again once it is older than the TTL.
+// If a lookup fails after a previous one succeeded, the last good context is returned.
+func CachedInstanceContext(log log.T) (InstanceContext, error) {
+	cache := &cachedInstanceContext
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.valid && timeNow().Sub(cache.fetchedAt) < instanceContextTTL {
+		return cache.context, nil
+	}
+
+	instanceContext, err := lookupInstanceContext(log)
+	if err != nil {
+		if cache.valid {
+			log.Warnf("Failed to refresh the instance context, using the one fetched at %v. error: %v", cache.fetchedAt, err)
+			return cache.context, nil
+		}
+		return InstanceContext{}, err
+	}
+
+	cache.context = instanceContext
+	cache.fetchedAt = timeNow()
+	cache.valid = true
+	return instanceContext, nil
+}
+
+// fetchInstanceContext looks up each part of the instance context
+func fetchInstanceContext(log log.T) (instanceContext InstanceContext, err error) {
+	if instanceContext.InstanceID, err = InstanceID(); err != nil {
+		return
+	}
+	if instanceContext.Region, err = Region(); err != nil {
+		return
+	}
+	if instanceContext.PlatformName, err = PlatformName(log); err != nil {
+		return
+	}
+	if instanceContext.PlatformVersion, err = PlatformVersion(log); err != nil {
+		return
+	}
+	instanceContext.IsManagedInstance = strings.Contains(instanceContext.InstanceID, "mi-")
+	return
+}
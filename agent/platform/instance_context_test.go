@@ -0,0 +1,83 @@
+package platform
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// stubInstanceContextLookup replaces the instance context lookup and clock, and clears the cache
+func stubInstanceContextLookup(lookup func(log log.T) (InstanceContext, error), now *time.Time) (restore func()) {
+	lookupOrig, timeNowOrig := lookupInstanceContext, timeNow
+	lookupInstanceContext = lookup
+	timeNow = func() time.Time { return *now }
+	cachedInstanceContext.valid = false
+	return func() {
+		lookupInstanceContext, timeNow = lookupOrig, timeNowOrig
+		cachedInstanceContext.valid = false
+	}
+}
+
+func TestCachedInstanceContextLooksUpOnceWithinTTL(t *testing.T) {
+	logger := log.NewMockLog()
+	now := time.Now()
+	lookups := 0
+	defer stubInstanceContextLookup(func(log log.T) (InstanceContext, error) {
+		lookups++
+		return InstanceContext{InstanceID: sampleManagedInstID, IsManagedInstance: true}, nil
+	}, &now)()
+
+	for i := 0; i < 3; i++ {
+		instanceContext, err := CachedInstanceContext(logger)
+		assert.NoError(t, err)
+		assert.Equal(t, sampleManagedInstID, instanceContext.InstanceID)
+		assert.True(t, instanceContext.IsManagedInstance)
+		now = now.Add(instanceContextTTL / 4)
+	}
+	assert.Equal(t, 1, lookups)
+
+	// looked up again once the TTL has expired
+	now = now.Add(instanceContextTTL)
+	_, err := CachedInstanceContext(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, lookups)
+}
+
+func TestCachedInstanceContextReturnsStaleOnError(t *testing.T) {
+	logger := log.NewMockLog()
+	logger.On("Warnf", mock.Anything, mock.Anything).Return(nil)
+	now := time.Now()
+	var lookupErr error
+	defer stubInstanceContextLookup(func(log log.T) (InstanceContext, error) {
+		if lookupErr != nil {
+			return InstanceContext{}, lookupErr
+		}
+		return InstanceContext{InstanceID: sampleInstanceID, Region: sampleInstanceRegion}, nil
+	}, &now)()
+
+	_, err := CachedInstanceContext(logger)
+	assert.NoError(t, err)
+
+	lookupErr = errors.New(sampleInstanceError)
+	now = now.Add(2 * instanceContextTTL)
+	instanceContext, err := CachedInstanceContext(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, sampleInstanceID, instanceContext.InstanceID)
+	assert.Equal(t, sampleInstanceRegion, instanceContext.Region)
+	logger.AssertCalled(t, "Warnf", mock.Anything, mock.Anything)
+}
+
+func TestCachedInstanceContextFailsWithoutPreviousLookup(t *testing.T) {
+	logger := log.NewMockLog()
+	now := time.Now()
+	defer stubInstanceContextLookup(func(log log.T) (InstanceContext, error) {
+		return InstanceContext{}, errors.New(sampleInstanceError)
+	}, &now)()
+
+	_, err := CachedInstanceContext(logger)
+	assert.Error(t, err)
+}
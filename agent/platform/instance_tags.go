@@ -0,0 +1,80 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the AWS Customer Agreement (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/agreement/
+
+// Package platform provides instance information
+package platform
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// instanceTagsResource lists the keys of the tags of the instance, when the tags are allowed in the instance metadata
+const instanceTagsResource = "tags/instance"
+
+// instanceTagsTTL is how long looked up instance tags are served from the cache
+var instanceTagsTTL = 5 * time.Minute
+
+var lookupInstanceTags = fetchInstanceTags
+
+var cachedInstanceTags struct {
+	lock      sync.Mutex
+	tags      map[string]string
+	fetchedAt time.Time
+	valid     bool
+}
+
+// CachedInstanceTags returns the tags of the instance, looking them up again once they are older than the TTL.
+// If a lookup fails after a previous one succeeded, the last good tags are returned. The returned map must not be
+// modified.
+func CachedInstanceTags(log log.T) (map[string]string, error) {
+	cache := &cachedInstanceTags
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.valid && timeNow().Sub(cache.fetchedAt) < instanceTagsTTL {
+		return cache.tags, nil
+	}
+
+	tags, err := lookupInstanceTags(log)
+	if err != nil {
+		if cache.valid {
+			log.Warnf("Failed to refresh the instance tags, using the ones fetched at %v. error: %v", cache.fetchedAt, err)
+			return cache.tags, nil
+		}
+		return nil, err
+	}
+
+	cache.tags = tags
+	cache.fetchedAt = timeNow()
+	cache.valid = true
+	return tags, nil
+}
+
+// fetchInstanceTags reads the tags of the instance from the instance metadata. Managed instances have no instance
+// metadata, and EC2 instances only expose their tags in it when allowed by their metadata options.
+func fetchInstanceTags(log log.T) (map[string]string, error) {
+	keys, err := metadata.GetMetadata(instanceTagsResource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the instance tags from the instance metadata, %v", err)
+	}
+	tags := make(map[string]string)
+	for _, key := range strings.Fields(keys) {
+		value, err := metadata.GetMetadata(instanceTagsResource + "/" + key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch the instance tag %v from the instance metadata, %v", key, err)
+		}
+		tags[key] = value
+	}
+	log.Debugf("Instance tags are %v", tags)
+	return tags, nil
+}
@@ -0,0 +1,69 @@
+package platform
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// tagsMetadataStub answers the instance metadata paths from a map
+type tagsMetadataStub map[string]string
+
+func (c tagsMetadataStub) GetMetadata(p string) (string, error) {
+	if value, ok := c[p]; ok {
+		return value, nil
+	}
+	return "", errors.New("404 - Not Found")
+}
+
+func (c tagsMetadataStub) Region() (string, error) { return sampleInstanceRegion, nil }
+
+func TestFetchInstanceTags(t *testing.T) {
+	metadataOrig := metadata
+	defer func() { metadata = metadataOrig }()
+	metadata = tagsMetadataStub{
+		"tags/instance":             "Environment\nteam",
+		"tags/instance/Environment": "production",
+		"tags/instance/team":        "payments",
+	}
+
+	tags, err := fetchInstanceTags(log.NewMockLog())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"Environment": "production", "team": "payments"}, tags)
+
+	// the tags are not in the instance metadata of managed instances, nor allowed there by default
+	metadata = tagsMetadataStub{}
+	_, err = fetchInstanceTags(log.NewMockLog())
+	assert.Error(t, err)
+}
+
+func TestCachedInstanceTagsLooksUpOnceWithinTTL(t *testing.T) {
+	lookupOrig, timeNowOrig := lookupInstanceTags, timeNow
+	now := time.Now()
+	lookups := 0
+	lookupInstanceTags = func(log log.T) (map[string]string, error) {
+		lookups++
+		return map[string]string{"Environment": "production"}, nil
+	}
+	timeNow = func() time.Time { return now }
+	cachedInstanceTags.valid = false
+	defer func() {
+		lookupInstanceTags, timeNow = lookupOrig, timeNowOrig
+		cachedInstanceTags.valid = false
+	}()
+
+	for i := 0; i < 3; i++ {
+		tags, err := CachedInstanceTags(log.NewMockLog())
+		assert.NoError(t, err)
+		assert.Equal(t, "production", tags["Environment"])
+	}
+	assert.Equal(t, 1, lookups)
+
+	now = now.Add(instanceTagsTTL)
+	_, err := CachedInstanceTags(log.NewMockLog())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, lookups)
+}
@@ -26,10 +26,12 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
 	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
 	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
 	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/platform"
 	"github.com/aws/amazon-ssm-agent/agent/plugins/pluginutil"
 	"github.com/aws/amazon-ssm-agent/agent/rebooter"
 	"github.com/aws/amazon-ssm-agent/agent/task"
@@ -37,6 +39,9 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/updateutil"
 )
 
+// downloadFolderName is the folder under the orchestration directory of a command the packages are downloaded to
+const downloadFolderName = appconfig.PluginDownloadsFolderName
+
 // Plugin is the type for the configurepackage plugin.
 type Plugin struct {
 	pluginutil.DefaultPlugin
@@ -50,6 +55,21 @@ type ConfigurePackagePluginInput struct {
 	Action     string `json:"action"`
 	Source     string `json:"source"`
 	Repository string `json:"repository"`
+	// AllowSideBySide installs the version alongside the installed versions instead of replacing them
+	AllowSideBySide bool `json:"allowSideBySide"`
+	// DownloadRetryLimit is the maximum number of attempts to download the package, the default is used when not set
+	DownloadRetryLimit int `json:"downloadRetryLimit"`
+	// DownloadRetryDelaySeconds is the wait after the first failed download attempt, the default is used when not set
+	DownloadRetryDelaySeconds int `json:"downloadRetryDelaySeconds"`
+	// DownloadTimeoutSeconds bounds each download attempt, apart from the timeout of the plugin, appconfig is used when not set
+	DownloadTimeoutSeconds int `json:"downloadTimeoutSeconds"`
+	// AdditionalArguments are exposed to the install and uninstall scripts as SSM_PKG_ARG_<KEY> environment variables
+	AdditionalArguments map[string]string `json:"additionalArguments"`
+	// JoinInProgress waits for and adopts the result of the same action on the same version of the package when it is
+	// already in progress, instead of failing
+	JoinInProgress bool `json:"joinInProgress"`
+	// Force reinstalls the version to install even if it is already installed
+	Force bool `json:"force"`
 }
 
 // NewPlugin returns a new instance of the plugin.
@@ -68,7 +88,8 @@ func NewPlugin(pluginConfig pluginutil.PluginConfig) (*Plugin, error) {
 
 type configurePackage struct {
 	contracts.Configuration
-	runner runpluginutil.PluginRunner
+	runner     runpluginutil.PluginRunner
+	cancelFlag task.CancelFlag
 }
 
 type configurePackageManager interface {
@@ -76,32 +97,43 @@ type configurePackageManager interface {
 		util configureUtil,
 		packageName string,
 		version string,
+		retry downloadRetryPolicy,
+		destination string,
 		output *contracts.PluginOutput) (filePath string, err error)
 
 	validateInput(context context.T, input *ConfigurePackagePluginInput) (valid bool, err error)
 
-	getVersionToInstall(context context.T, input *ConfigurePackagePluginInput, util configureUtil) (version string, installedVersion string, err error)
+	getVersionToInstall(context context.T, input *ConfigurePackagePluginInput, util configureUtil, instanceContext *updateutil.InstanceContext) (version string, installedVersion string, err error)
 
 	getVersionToUninstall(context context.T, input *ConfigurePackagePluginInput, util configureUtil) (version string, err error)
 
 	setMark(context context.T, packageName string, version string) error
 
+	getMark(context context.T, packageName string) string
+
 	clearMark(context context.T, packageName string)
 
+	setVersionMark(context context.T, packageName string, version string) error
+
+	clearVersionMark(context context.T, packageName string, version string)
+
 	ensurePackage(context context.T,
 		util configureUtil,
 		packageName string,
 		version string,
+		retry downloadRetryPolicy,
 		output *contracts.PluginOutput) (manifest *PackageManifest, err error)
 
 	runUninstallPackagePre(context context.T,
 		packageName string,
 		version string,
+		arguments map[string]string,
 		output *contracts.PluginOutput) (status contracts.ResultStatus, err error)
 
 	runInstallPackage(context context.T,
 		packageName string,
 		version string,
+		arguments map[string]string,
 		output *contracts.PluginOutput) (status contracts.ResultStatus, err error)
 
 	runUninstallPackagePost(context context.T,
@@ -131,42 +163,84 @@ func runConfigurePackage(
 		return
 	}
 
+	// an action that never returned doesn't block the package forever
+	reclaimStalePackageLocks(log, input.Name)
+
 	// do not allow multiple actions to be performed at the same time for the same package
 	// this is possible with multiple concurrent runcommand documents
-	if err := lockPackage(input.Name, input.Action); err != nil {
-		output.MarkAsFailed(log, err)
-		return
+	// side-by-side actions only need to exclude actions on the same version
+	if input.AllowSideBySide {
+		lock, err := acquirePackageVersion(input.Name, input.Version, input.Action)
+		if err != nil {
+			output.MarkAsFailed(log, err)
+			return
+		}
+		defer releasePackageVersion(input.Name, input.Version, lock)
+	} else {
+		lock, joined, err := lockOrJoinPackage(input.Name, input.Version, input.Action, input.JoinInProgress)
+		if err != nil {
+			output.MarkAsFailed(log, err)
+			return
+		}
+		if joined {
+			log.Infof("%v of %v %v is already in progress, waiting for its result", input.Action, input.Name, input.Version)
+			return lock.wait()
+		}
+		defer func() { unlockPackageWithResult(input.Name, lock, output) }()
 	}
-	defer unlockPackage(input.Name)
 
 	configUtil := NewUtil(instanceContext, input.Repository)
+	retryPolicy := newDownloadRetryPolicy(log, &input)
 
 	switch input.Action {
 	case InstallAction:
+		// the install is recorded in the history of the package however it ends, unless there was nothing to install
+		// or it continues after a reboot
+		previousVersion, version, recordHistory := "", input.Version, true
+		defer func() {
+			if recordHistory {
+				recordPackageHistory(log, input.Name, input.Action, previousVersion, version, output.Status)
+			}
+		}()
+
 		// get version information
-		version, installedVersion, versionErr := manager.getVersionToInstall(context, &input, configUtil)
+		targetVersion, installedVersion, versionErr := manager.getVersionToInstall(context, &input, configUtil, instanceContext)
 		if versionErr != nil {
 			output.MarkAsFailed(log, fmt.Errorf("unable to determine version to install: %v", versionErr))
 			return
 		}
+		version = targetVersion
 
 		// if already installed, exit
+		previousVersion = installedVersion
 		if version == installedVersion {
-			// TODO:MF: validate that installed version is basically valid - has manifest and at least one other non-etag file or folder?
-			output.AppendInfof(log, "%v %v is already installed", input.Name, version)
-			output.MarkAsSucceeded()
-			return
+			if !input.Force && isInstalledVersionHealthy(input.Name, version) {
+				output.AppendInfof(log, "%v %v is already installed", input.Name, version)
+				output.MarkAsSucceeded()
+				recordHistory = false
+				return
+			}
+			// the version is installed again over itself, there is no other version to uninstall
+			log.Infof("reinstalling %v %v, forced: %v", input.Name, version, input.Force)
+			installedVersion = ""
 		}
 
 		// ensure manifest file and package
-		_, ensureErr := manager.ensurePackage(context, configUtil, input.Name, version, &output)
+		manifest, ensureErr := manager.ensurePackage(context, configUtil, input.Name, version, retryPolicy, &output)
 		if ensureErr != nil {
 			output.MarkAsFailed(log, fmt.Errorf("unable to obtain package: %v", ensureErr))
 			return
 		}
 
-		// set installing flag for version
-		if markErr := manager.setMark(context, input.Name, version); markErr != nil {
+		// installed versions are left in place when installing side-by-side, so there is nothing to uninstall - and
+		// the version gets a mark of its own, which concurrent installs of other versions don't overwrite or read
+		if input.AllowSideBySide {
+			installedVersion = ""
+			if markErr := manager.setVersionMark(context, input.Name, version); markErr != nil {
+				output.MarkAsFailed(log, fmt.Errorf("unable to mark package version installing: %v", markErr))
+				return
+			}
+		} else if markErr := manager.setMark(context, input.Name, version); markErr != nil {
 			output.MarkAsFailed(log, fmt.Errorf("unable to mark package installing: %v", markErr))
 			return
 		}
@@ -174,16 +248,23 @@ func runConfigurePackage(
 		// NOTE: do not return before clearing installing mark after this point unless you want it to remain set - once we defer the unmark it is OK to return again
 		// if different version is installed, uninstall
 		if installedVersion != "" {
+			// a downgrade runs the uninstall document of the target version if its manifest asks to
+			uninstallVersion := installedVersion
+			if useTargetUninstall(manifest, version, installedVersion) {
+				log.Infof("downgrading %v %v to %v with the uninstall of %v", input.Name, installedVersion, version, version)
+				uninstallVersion = version
+			}
 			// NOTE: if source is specified on an install and we need to redownload the package for the
 			// currently installed version because it isn't valid on disk, we will pull from the source URI
 			// even though that may or may not be the package that installed it - it is our only decent option
-			_, ensureErr := manager.ensurePackage(context, configUtil, input.Name, installedVersion, &output)
+			_, ensureErr := manager.ensurePackage(context, configUtil, input.Name, uninstallVersion, retryPolicy, &output)
 			if ensureErr != nil {
 				output.AppendErrorf(log, "unable to obtain package: %v", ensureErr)
 			} else {
 				result, err := manager.runUninstallPackagePre(context,
 					input.Name,
-					installedVersion,
+					uninstallVersion,
+					input.AdditionalArguments,
 					&output)
 				if err != nil {
 					output.AppendErrorf(log, "failed to uninstall currently installed version of package: %v", err)
@@ -191,6 +272,7 @@ func runConfigurePackage(
 					if result == contracts.ResultStatusSuccessAndReboot || result == contracts.ResultStatusPassedAndReboot {
 						// Reboot before continuing
 						output.MarkAsSuccessWithReboot()
+						recordHistory = false
 						return
 					}
 				}
@@ -198,15 +280,25 @@ func runConfigurePackage(
 		}
 
 		// defer clearing installing
-		defer manager.clearMark(context, input.Name)
+		if input.AllowSideBySide {
+			defer manager.clearVersionMark(context, input.Name, version)
+		} else {
+			defer manager.clearMark(context, input.Name)
+		}
 
 		// install version
 		result, err := manager.runInstallPackage(context,
 			input.Name,
 			version,
+			input.AdditionalArguments,
 			&output)
 		if err != nil {
 			output.MarkAsFailed(log, fmt.Errorf("failed to install package: %v", err))
+			// a version that can't be verified is replaced by the version it was installed over, which is kept then
+			if _, unverified := err.(*packageVerificationError); unverified && installedVersion != "" &&
+				restorePreviousVersion(context, manager, input.Name, version, installedVersion, input.AdditionalArguments, &output) {
+				installedVersion = ""
+			}
 		} else if result == contracts.ResultStatusSuccessAndReboot || result == contracts.ResultStatusPassedAndReboot {
 			output.AppendInfof(log, "Successfully installed %v %v", input.Name, version)
 			output.MarkAsSuccessWithReboot()
@@ -229,6 +321,12 @@ func runConfigurePackage(
 		}
 
 	case UninstallAction:
+		// every installed version is only uninstalled when asked for explicitly
+		if input.Version == AllVersions {
+			uninstallAllVersions(context, manager, configUtil, &input, retryPolicy, &output)
+			return
+		}
+
 		// get version information
 		version, versionErr := manager.getVersionToUninstall(context, &input, configUtil)
 		if versionErr != nil || version == "" {
@@ -237,7 +335,7 @@ func runConfigurePackage(
 		}
 
 		// ensure manifest file and package
-		_, ensureErr := manager.ensurePackage(context, configUtil, input.Name, version, &output)
+		_, ensureErr := manager.ensurePackage(context, configUtil, input.Name, version, retryPolicy, &output)
 		if ensureErr != nil {
 			output.MarkAsFailed(log, fmt.Errorf("unable to obtain package: %v", ensureErr))
 			return
@@ -246,9 +344,11 @@ func runConfigurePackage(
 		resultPre, err = manager.runUninstallPackagePre(context,
 			input.Name,
 			version,
+			input.AdditionalArguments,
 			&output)
 		if err != nil {
 			output.MarkAsFailed(log, fmt.Errorf("failed to uninstall package: %v", err))
+			recordPackageHistory(log, input.Name, input.Action, version, "", output.Status)
 			return
 		}
 		resultPost, err = manager.runUninstallPackagePost(context,
@@ -257,6 +357,7 @@ func runConfigurePackage(
 			&output)
 		if err != nil {
 			output.MarkAsFailed(log, fmt.Errorf("failed to uninstall package: %v", err))
+			recordPackageHistory(log, input.Name, input.Action, version, "", output.Status)
 			return
 		}
 
@@ -270,6 +371,11 @@ func runConfigurePackage(
 			output.AppendInfof(log, "Successfully uninstalled %v %v", input.Name, version)
 			output.MarkAsSucceeded()
 		}
+		recordPackageHistory(log, input.Name, input.Action, version, "", output.Status)
+
+	case ReinstallAction:
+		reinstallPackage(context, manager, configUtil, instanceContext, &input, retryPolicy, &output)
+
 	default:
 		output.MarkAsFailed(log, fmt.Errorf("unsupported action: %v", input.Action))
 	}
@@ -277,11 +383,81 @@ func runConfigurePackage(
 	return
 }
 
+// uninstallAllVersions uninstalls every installed version of a package. A version that fails to uninstall is reported
+// in the output and doesn't stop the uninstall of the remaining versions.
+func uninstallAllVersions(context context.T,
+	manager configurePackageManager,
+	util configureUtil,
+	input *ConfigurePackagePluginInput,
+	retry downloadRetryPolicy,
+	output *contracts.PluginOutput) {
+
+	log := context.Log()
+	versions := util.GetInstalledVersions(input.Name)
+	if len(versions) == 0 {
+		output.MarkAsFailed(log, fmt.Errorf("unable to determine version to uninstall: no version of %v is installed", input.Name))
+		return
+	}
+
+	var failed int
+	var reboot bool
+	for _, version := range versions {
+		result, err := uninstallVersion(context, manager, util, input, version, retry, output)
+		if err != nil {
+			failed++
+			output.AppendErrorf(log, "failed to uninstall %v %v: %v", input.Name, version, err)
+			recordPackageHistory(log, input.Name, input.Action, version, "", contracts.ResultStatusFailed)
+			continue
+		}
+		if result == contracts.ResultStatusSuccessAndReboot || result == contracts.ResultStatusPassedAndReboot {
+			reboot = true
+		}
+		output.AppendInfof(log, "Successfully uninstalled %v %v", input.Name, version)
+		recordPackageHistory(log, input.Name, input.Action, version, "", result)
+	}
+
+	if failed > 0 {
+		output.MarkAsFailed(log, fmt.Errorf("failed to uninstall %v of %v versions of %v", failed, len(versions), input.Name))
+	} else if reboot {
+		output.MarkAsSuccessWithReboot()
+	} else {
+		output.MarkAsSucceeded()
+	}
+}
+
+// uninstallVersion runs the uninstall actions of a version of a package and returns their merged result
+func uninstallVersion(context context.T,
+	manager configurePackageManager,
+	util configureUtil,
+	input *ConfigurePackagePluginInput,
+	version string,
+	retry downloadRetryPolicy,
+	output *contracts.PluginOutput) (result contracts.ResultStatus, err error) {
+
+	if _, err = manager.ensurePackage(context, util, input.Name, version, retry, output); err != nil {
+		return "", fmt.Errorf("unable to obtain package: %v", err)
+	}
+	resultPre, err := manager.runUninstallPackagePre(context, input.Name, version, input.AdditionalArguments, output)
+	if err != nil {
+		return "", err
+	}
+	resultPost, err := manager.runUninstallPackagePost(context, input.Name, version, output)
+	if err != nil {
+		return "", err
+	}
+	result = contracts.MergeResultStatus(resultPre, resultPost)
+	if result != contracts.ResultStatusSuccess && result != contracts.ResultStatusSuccessAndReboot && result != contracts.ResultStatusPassedAndReboot {
+		return result, fmt.Errorf("uninstall action state was %v and not %v", result, contracts.ResultStatusSuccess)
+	}
+	return result, nil
+}
+
 // ensurePackage validates local copy of the manifest and package and downloads if needed
 func (m *configurePackage) ensurePackage(context context.T,
 	util configureUtil,
 	packageName string,
 	version string,
+	retry downloadRetryPolicy,
 	output *contracts.PluginOutput) (manifest *PackageManifest, err error) {
 
 	// manifest to download
@@ -292,7 +468,7 @@ func (m *configurePackage) ensurePackage(context context.T,
 
 	// if we already have a valid manifest, return it
 	if exist := filesysdep.Exists(localManifestName); exist {
-		if manifest, err = parsePackageManifest(context.Log(), localManifestName); err == nil {
+		if manifest, err = parseTrustedPackageManifest(context.Log(), localManifestName); err == nil {
 			// TODO:MF: consider verifying name, version, platform, arch in parsed manifest
 			// TODO:MF: ensure the local package is valid before we return
 			return
@@ -303,12 +479,19 @@ func (m *configurePackage) ensurePackage(context context.T,
 
 	// download package
 	var filePath string
-	if filePath, err = m.downloadPackage(context, util, packageName, version, output); err != nil {
+	if filePath, err = m.downloadPackage(context, util, packageName, version, retry, m.downloadDestination(), output); err != nil {
 		return
 	}
 
 	packageDestination := filepath.Join(appconfig.PackageRoot, packageName, version)
 	if uncompressErr := filesysdep.Uncompress(filePath, packageDestination); uncompressErr != nil {
+		// an archive writing outside of its version directory is not trusted, none of its files are kept
+		if _, unsafe := uncompressErr.(*fileutil.UnsafeArchiveEntryError); unsafe {
+			filesysdep.RemoveAll(packageDestination)
+			filesysdep.RemoveAll(filePath)
+			err = fmt.Errorf("security error, package %v %v is not installed: %v", packageName, version, uncompressErr.Error())
+			return
+		}
 		err = fmt.Errorf("failed to extract package installer package %v from %v, %v", filePath, packageDestination, uncompressErr.Error())
 		return
 	}
@@ -320,7 +503,13 @@ func (m *configurePackage) ensurePackage(context context.T,
 		return
 	}
 
-	manifest, manifestErr := parsePackageManifest(context.Log(), localManifestName)
+	manifest, manifestErr := parseTrustedPackageManifest(context.Log(), localManifestName)
+	if _, untrusted := manifestErr.(*manifestSignatureError); untrusted {
+		// a package whose manifest isn't signed by the trust anchor is not installed, none of its files are kept
+		filesysdep.RemoveAll(packageDestination)
+		err = fmt.Errorf("security error, package %v %v is not installed: %v", packageName, version, manifestErr.Error())
+		return
+	}
 	if manifestErr != nil {
 		err = fmt.Errorf("manifest is not valid for package %v, %v", filePath, manifestErr.Error())
 		return
@@ -345,13 +534,43 @@ func (m *configurePackage) validateInput(context context.T, input *ConfigurePack
 		return false, errors.New("invalid name, must start with letter or _; end with letter, number, or _; and contain only letters, numbers, -, _, or single . characters")
 	}
 
-	if version := input.Version; version != "" {
+	// side-by-side actions are scoped to the version they act on
+	if input.AllowSideBySide && input.Version == "" {
+		return false, errors.New("version is required when allowSideBySide is set")
+	}
+	// a reinstall replaces the installed version, which side-by-side versions don't have
+	if input.AllowSideBySide && input.Action == ReinstallAction {
+		return false, fmt.Errorf("the %v action is not supported with allowSideBySide", ReinstallAction)
+	}
+
+	if input.Version == AllVersions {
+		// all versions are only removed together, by an uninstall holding the lock of the whole package
+		if input.Action != UninstallAction || input.AllowSideBySide {
+			return false, fmt.Errorf("version %v is only supported by the %v action without allowSideBySide", AllVersions, UninstallAction)
+		}
+	} else if version := input.Version; isVersionRange(version) {
+		// ranges are resolved to the version to install, other actions act on the versions that are installed
+		if input.Action != InstallAction || input.AllowSideBySide {
+			return false, fmt.Errorf("version range %v is only supported by the %v action without allowSideBySide", version, InstallAction)
+		}
+		if _, err := parseVersionRange(version); err != nil {
+			return false, fmt.Errorf("invalid version range %v - %v, should be comma separated constraints like >=1.2,<2.0", version, err)
+		}
+	} else if version != "" {
 		// ensure version follows format <major>.<minor>.<build>
 		if matched, err := regexp.MatchString(PatternVersion, version); matched == false || err != nil {
 			return false, errors.New("invalid version - should be in format major.minor.build")
 		}
 	}
 
+	if err := validateDownloadRetryInput(input); err != nil {
+		return false, err
+	}
+
+	if err := validateAdditionalArguments(input.AdditionalArguments); err != nil {
+		return false, err
+	}
+
 	// dump any unsupported value for Repository
 	if input.Repository != "beta" && input.Repository != "gamma" {
 		input.Repository = ""
@@ -361,17 +580,74 @@ func (m *configurePackage) validateInput(context context.T, input *ConfigurePack
 }
 
 // getVersionToInstall decides which version to install and whether there is an existing version (that is not in the process of installing)
+// if the package manifest lists the available versions, only versions compatible with the instance platform and architecture are considered
 func (m *configurePackage) getVersionToInstall(context context.T,
 	input *ConfigurePackagePluginInput,
-	util configureUtil) (version string, installedVersion string, err error) {
+	util configureUtil,
+	instanceContext *updateutil.InstanceContext) (version string, installedVersion string, err error) {
+	log := context.Log()
 	installedVersion = util.GetCurrentVersion(input.Name)
 
+	// the version installed was compatible with the instance when it was installed, there is no need for the
+	// manifest of the package to install it again
+	if input.Version != "" && input.Version == installedVersion {
+		return input.Version, installedVersion, nil
+	}
+
+	manifest, manifestErr := util.GetPackageManifest(log, input.Name)
+	if _, ok := manifestErr.(*manifestSignatureError); ok {
+		return "", installedVersion, manifestErr
+	}
+	// when a trust anchor is configured, the versions are only taken from a verified manifest
+	trustAnchor, trustErr := getManifestTrustAnchor(log)
+	if trustErr != nil {
+		return "", installedVersion, trustErr
+	}
+	if trustAnchor != "" && (manifestErr != nil || manifest == nil) {
+		return "", installedVersion, &manifestSignatureError{err: fmt.Errorf("no verified manifest for package %v, %v", input.Name, manifestErr)}
+	}
+	if manifestErr != nil || manifest == nil || len(manifest.Versions) == 0 {
+		log.Debugf("No list of versions available for package %v, %v", input.Name, manifestErr)
+		if input.Version != "" && !isVersionRange(input.Version) {
+			version = input.Version
+		} else {
+			if version, err = util.GetLatestVersion(log, input.Name); err != nil {
+				return
+			}
+		}
+		// without a list of versions only the latest version is known, it is installed if it is in the range
+		if isVersionRange(input.Version) {
+			if versionRange, rangeErr := parseVersionRange(input.Version); rangeErr != nil || !versionRange.contains(version) {
+				return "", installedVersion, fmt.Errorf("latest version %v of package %v doesn't satisfy %v", version, input.Name, input.Version)
+			}
+		}
+		return version, installedVersion, nil
+	}
+
+	compatibleVersions := getCompatibleVersions(manifest, instanceContext)
+	if isVersionRange(input.Version) {
+		versionRange, rangeErr := parseVersionRange(input.Version)
+		if rangeErr != nil {
+			return "", installedVersion, rangeErr
+		}
+		if version = getLatestVersionInRange(compatibleVersions, versionRange); version == "" {
+			return "", installedVersion, fmt.Errorf("no version of package %v satisfying %v is compatible with platform %v and architecture %v",
+				input.Name, input.Version, instanceContext.Platform, instanceContext.Arch)
+		}
+		return version, installedVersion, nil
+	}
 	if input.Version != "" {
-		version = input.Version
-	} else {
-		if version, err = util.GetLatestVersion(context.Log(), input.Name); err != nil {
-			return
+		for _, compatibleVersion := range compatibleVersions {
+			if compatibleVersion == input.Version {
+				return input.Version, installedVersion, nil
+			}
 		}
+		return "", installedVersion, fmt.Errorf("version %v of package %v is not compatible with platform %v and architecture %v",
+			input.Version, input.Name, instanceContext.Platform, instanceContext.Arch)
+	}
+	if version = getLatestVersion(compatibleVersions, ""); version == "" {
+		return "", installedVersion, fmt.Errorf("no version of package %v is compatible with platform %v and architecture %v",
+			input.Name, instanceContext.Platform, instanceContext.Arch)
 	}
 	return version, installedVersion, nil
 }
@@ -396,16 +672,34 @@ func (configurePackage) setMark(context context.T, packageName string, version s
 	return markInstallingPackage(packageName, version)
 }
 
+// getMark returns the version marked as installing, empty if the package isn't marked
+func (configurePackage) getMark(context context.T, packageName string) string {
+	return getInstallingPackageVersion(packageName)
+}
+
 // clearMark removes the file marking a package as being in the process of installation
 func (configurePackage) clearMark(context context.T, packageName string) {
 	unmarkInstallingPackage(packageName)
 }
 
-// downloadPackage downloads the installation package from s3 bucket or source URI and uncompresses it
+// setVersionMark marks a version installed side-by-side as installing, without marking the other versions
+func (configurePackage) setVersionMark(context context.T, packageName string, version string) error {
+	return markInstallingPackageVersion(packageName, version)
+}
+
+// clearVersionMark removes the file marking a version installed side-by-side as being in the process of installation
+func (configurePackage) clearVersionMark(context context.T, packageName string, version string) {
+	unmarkInstallingPackageVersion(packageName, version)
+}
+
+// downloadPackage downloads the installation package from s3 bucket or source URI into destination,
+// appconfig.DownloadRoot if destination is empty
 func (m *configurePackage) downloadPackage(context context.T,
 	util configureUtil,
 	packageName string,
 	version string,
+	retry downloadRetryPolicy,
+	destination string,
 	output *contracts.PluginOutput) (filePath string, err error) {
 
 	log := context.Log()
@@ -413,25 +707,77 @@ func (m *configurePackage) downloadPackage(context context.T,
 	//   We should probably support both a URI to a "folder" that gets a filename tacked onto the end
 	//   and a full path to a compressed package file
 
-	// path to package
-	packageLocation := util.GetS3Location(packageName, version)
+	// path to package, in the bucket of the region first
+	packageLocations := []string{util.GetS3Location(packageName, version)}
+	if fallbackLocation := util.GetS3FallbackLocation(packageName, version); fallbackLocation != "" {
+		packageLocations = append(packageLocations, fallbackLocation)
+	}
+	// the manifest may name the archive of the version, which is checked before anything is downloaded
+	archiveFile, err := getArchiveFile(log, util, packageName, version)
+	if err != nil {
+		return "", err
+	}
+	if archiveFile != "" {
+		for i, packageLocation := range packageLocations {
+			packageLocations[i] = withArchiveFile(packageLocation, archiveFile)
+		}
+	}
 
-	// path to download destination
+	// path the package is extracted to
 	packageDestination, createErr := util.CreatePackageFolder(packageName, version)
 	if createErr != nil {
 		return "", fmt.Errorf("failed to create local package repository, %v", createErr.Error())
 	}
 
-	downloadInput := artifact.DownloadInput{
-		SourceURL:            packageLocation,
-		DestinationDirectory: packageDestination}
+	// path to download destination
+	if destination == "" {
+		destination = appconfig.DownloadRoot
+	}
+	if createErr = filesysdep.MakeDirExecute(destination); createErr != nil {
+		return "", fmt.Errorf("failed to create download folder %v, %v", destination, createErr.Error())
+	}
 
-	// download package
-	downloadOutput, downloadErr := networkdep.Download(log, downloadInput)
+	// reuse the archive of a recent download of the same version
+	if cachedPath, found := getCachedPackage(log, packageDestination, destination); found {
+		output.AppendInfof(log, "Using the cached installation package of %v %v", packageName, version)
+		return cachedPath, nil
+	}
+
+	// fail fast instead of filling the disk with a partial download
+	if err = checkDiskSpaceForDownload(log, util, packageName, version, destination); err != nil {
+		return "", err
+	}
+
+	downloadInput := artifact.DownloadInput{
+		DestinationDirectory: destination,
+		Progress:             newDownloadProgress(log, output),
+		Timeout:              retry.timeout}
+
+	// the downloads of all the documents share the bandwidth, they queue beyond the maximum
+	release := packageDownloads.acquire(log, output, packageName, version)
+	defer release()
+
+	// download package, moving on to the next location only if the package isn't found
+	var downloadOutput artifact.DownloadOutput
+	var downloadErr error
+	var category downloadErrorCategory
+	var attempt int
+	for i, packageLocation := range packageLocations {
+		downloadInput.SourceURL = packageLocation
+		downloadOutput, category, attempt, downloadErr = downloadWithRetry(log, m.cancelFlag, downloadInput, retry, output)
+		if !isNotFoundError(downloadErr) || i == len(packageLocations)-1 {
+			break
+		}
+		output.AppendInfof(log, "%v not found, falling back to %v", packageLocation, packageLocations[i+1])
+	}
 	if downloadErr != nil || downloadOutput.LocalFilePath == "" {
-		errMessage := fmt.Sprintf("failed to download installation package reliably, %v", downloadInput.SourceURL)
+		errMessage := fmt.Sprintf("failed to download installation package reliably after %v attempt(s), %v", attempt, downloadInput.SourceURL)
 		if downloadErr != nil {
-			errMessage = fmt.Sprintf("%v, %v", errMessage, downloadErr.Error())
+			errMessage = fmt.Sprintf("%v, %v error: %v", errMessage, category, downloadErr.Error())
+		}
+		// a download that kept failing with a transient error may succeed when the plugin runs again
+		if category == downloadErrorRetriable {
+			output.MarkAsRetryable()
 		}
 		// attempt to clean up failed download folder
 		if errCleanup := filesysdep.RemoveAll(packageDestination); errCleanup != nil {
@@ -442,31 +788,98 @@ func (m *configurePackage) downloadPackage(context context.T,
 	}
 
 	output.AppendInfof(log, "Successfully downloaded %v", downloadInput.SourceURL)
+	cachePackage(log, packageDestination, downloadOutput.LocalFilePath)
 
 	return downloadOutput.LocalFilePath, nil
 }
 
+// downloadDestination returns the folder the packages of the command are downloaded to, under the orchestration
+// directory of the command so that the downloads are cleaned up with it, empty if the command doesn't have one
+func (m *configurePackage) downloadDestination() string {
+	if m.OrchestrationDirectory == "" {
+		return ""
+	}
+	return filepath.Join(m.OrchestrationDirectory, downloadFolderName)
+}
+
+// downloadWithRetry downloads the package, retrying only failures that are classified as retriable.
+// The retries stop when the document is cancelled or the agent shuts down while waiting for the next attempt.
+func downloadWithRetry(log log.T,
+	cancelFlag task.CancelFlag,
+	downloadInput artifact.DownloadInput,
+	retry downloadRetryPolicy,
+	output *contracts.PluginOutput) (downloadOutput artifact.DownloadOutput, category downloadErrorCategory, attempt int, downloadErr error) {
+
+	for attempt = 1; attempt <= retry.limit; attempt++ {
+		downloadOutput, downloadErr = networkdep.Download(log, downloadInput)
+		if downloadErr == nil && downloadOutput.LocalFilePath != "" {
+			break
+		}
+		category = classifyDownloadError(downloadErr)
+		if downloadErr == nil || category != downloadErrorRetriable || attempt == retry.limit {
+			break
+		}
+		backoff := retry.backoff(attempt)
+		output.AppendInfof(log, "Download attempt %v of %v failed with %v error, retrying in %v: %v", attempt, retry.limit, category, backoff, downloadErr)
+		if !waitForDownloadRetry(cancelFlag, backoff) {
+			output.AppendInfof(log, "Download of %v cancelled before attempt %v", downloadInput.SourceURL, attempt+1)
+			category = downloadErrorTerminal
+			break
+		}
+	}
+	return
+}
+
 // runInstallPackage executes the install script for the specific version of a package.
 func (m *configurePackage) runInstallPackage(context context.T,
 	packageName string,
 	version string,
+	arguments map[string]string,
 	output *contracts.PluginOutput) (status contracts.ResultStatus, err error) {
 	status = contracts.ResultStatusSuccess
+	manifest := getLocalManifest(context, packageName, version)
 
-	directory := filepath.Join(appconfig.PackageRoot, packageName, version)
-	if _, status, err = m.executeAction(context, "install", packageName, version, output, directory); err != nil {
-		return status, err
+	// packages that bundle components install each of them in the order declared by the manifest
+	if manifest != nil && len(manifest.Components) > 0 {
+		if status, err = m.executeComponentActions(context, "install", "uninstall", packageName, version, arguments, manifest.Components, manifest.Rollback, output); err != nil {
+			return status, err
+		}
+	} else {
+		directory := filepath.Join(appconfig.PackageRoot, packageName, version)
+		if _, status, err = m.executeAction(context, "install", packageName, version, arguments, output, directory); err != nil {
+			return status, err
+		}
+	}
+
+	if manifest != nil && manifest.VerifyCommand != "" && isActionSucceeded(status) {
+		return m.verifyInstalledPackage(context, packageName, version, arguments, manifest, status, output)
 	}
 	return
 }
 
+// useTargetUninstall returns true if the installed version is downgraded to a version whose manifest asks to run its
+// own uninstall document instead of the installed version's
+func useTargetUninstall(manifest *PackageManifest, version string, installedVersion string) bool {
+	if manifest == nil || !manifest.UseTargetUninstall {
+		return false
+	}
+	compare, err := updateutil.VersionCompare(version, installedVersion)
+	return err == nil && compare < 0
+}
+
 // runUninstallPackagePre executes the uninstall script for the specific version of a package.
 func (m *configurePackage) runUninstallPackagePre(context context.T,
 	packageName string,
 	version string,
+	arguments map[string]string,
 	output *contracts.PluginOutput) (status contracts.ResultStatus, err error) {
+	// bundled components are uninstalled in the reverse of their install order
+	if manifest := getLocalManifest(context, packageName, version); manifest != nil && len(manifest.Components) > 0 {
+		return m.executeComponentActions(context, "uninstall", "install", packageName, version, arguments, reverseComponents(manifest.Components), manifest.Rollback, output)
+	}
+
 	directory := filepath.Join(appconfig.PackageRoot, packageName, version)
-	if _, status, err = m.executeAction(context, "uninstall", packageName, version, output, directory); err != nil {
+	if _, status, err = m.executeAction(context, "uninstall", packageName, version, arguments, output, directory); err != nil {
 		return status, err
 	}
 	return contracts.ResultStatusSuccess, nil
@@ -484,11 +897,13 @@ func (m *configurePackage) runUninstallPackagePost(context context.T,
 	return contracts.ResultStatusSuccess, nil
 }
 
-// executeAction executes a command document as a sub-document of the current command and returns the result
+// executeAction executes a command document as a sub-document of the current command and returns the result.
+// The additional arguments of the action are added to the environment of the plugins of the sub-document.
 func (m *configurePackage) executeAction(context context.T,
 	actionName string,
 	packageName string,
 	version string,
+	arguments map[string]string,
 	output *contracts.PluginOutput,
 	executeDirectory string) (actionExists bool, status contracts.ResultStatus, err error) {
 	status = contracts.ResultStatusSuccess
@@ -511,7 +926,7 @@ func (m *configurePackage) executeAction(context context.T,
 		if len(pluginsInfo) == 0 {
 			return true, contracts.ResultStatusFailed, fmt.Errorf("%v contained no work and may be malformed", fileName)
 		}
-		pluginOutputs := execdep.ExecuteDocument(m.runner, context, pluginsInfo, m.BookKeepingFileName, times.ToIso8601UTC(time.Now()))
+		pluginOutputs := execdep.ExecuteDocument(m.runner, context, pluginsInfo, m.BookKeepingFileName, times.ToIso8601UTC(time.Now()), getArgumentEnvironment(arguments))
 		if pluginOutputs == nil {
 			return true, contracts.ResultStatusFailed, errors.New("No output from executing install document (install.json)")
 		}
@@ -534,10 +949,13 @@ func (m *configurePackage) executeAction(context context.T,
 	return
 }
 
-// getInstanceContext uses the updateUtil to return an instance context
+// getInstanceContext returns an instance context built from the cached platform information
 func getInstanceContext(log log.T) (instanceContext *updateutil.InstanceContext, err error) {
-	updateUtil := new(updateutil.Utility)
-	return updateUtil.CreateInstanceContext(log)
+	platformContext, err := platform.CachedInstanceContext(log)
+	if err != nil {
+		return nil, err
+	}
+	return updateutil.NewInstanceContext(log, platformContext.Region, platformContext.PlatformName, platformContext.PlatformVersion), nil
 }
 
 var getContext = getInstanceContext
@@ -570,7 +988,7 @@ func (p *Plugin) Execute(context context.T, config contracts.Configuration, canc
 		}
 		return
 	}
-	manager := &configurePackage{Configuration: config, runner: subDocumentRunner}
+	manager := &configurePackage{Configuration: config, runner: subDocumentRunner, cancelFlag: cancelFlag}
 
 	for i, prop := range properties {
 		// check if a reboot has been requested
@@ -601,6 +1019,7 @@ func (p *Plugin) Execute(context context.T, config contracts.Configuration, canc
 		res.Code = out[0].ExitCode
 		res.Status = out[0].Status
 		res.Output = out[0].String()
+		res.Retryable = out[0].Retryable && res.Status == contracts.ResultStatusFailed
 		if config.OrchestrationDirectory != "" {
 			useTemp := false
 			outFile := filepath.Join(config.OrchestrationDirectory, p.StdoutFileName)
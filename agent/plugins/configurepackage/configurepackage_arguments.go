@@ -0,0 +1,84 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_arguments contains the additional arguments passed to the install and uninstall scripts of a package
+package configurepackage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// AdditionalArgumentEnvPrefix is prepended to the upper cased key of an additional argument to get its environment variable
+const AdditionalArgumentEnvPrefix = "SSM_PKG_ARG_"
+
+// validArgumentKey restricts the keys of additional arguments to characters that are safe in environment variable names
+var validArgumentKey = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// validateAdditionalArguments returns an error if a key is not safe to use in an environment variable name,
+// or if two keys map to the same environment variable
+func validateAdditionalArguments(arguments map[string]string) error {
+	names := make(map[string]string)
+	for key := range arguments {
+		if !validArgumentKey.MatchString(key) {
+			return fmt.Errorf("invalid additional argument %q, must contain only letters, numbers, or _", key)
+		}
+		name := getArgumentEnvName(key)
+		if other, found := names[name]; found {
+			return fmt.Errorf("additional arguments %q and %q map to the same environment variable %v", other, key, name)
+		}
+		names[name] = key
+	}
+	return nil
+}
+
+// getArgumentEnvName returns the name of the environment variable of an additional argument
+func getArgumentEnvName(key string) string {
+	return AdditionalArgumentEnvPrefix + strings.ToUpper(key)
+}
+
+// getArgumentEnvironment returns the environment variables of the additional arguments
+func getArgumentEnvironment(arguments map[string]string) map[string]string {
+	if len(arguments) == 0 {
+		return nil
+	}
+	environment := make(map[string]string)
+	for key, value := range arguments {
+		environment[getArgumentEnvName(key)] = value
+	}
+	return environment
+}
+
+// withArgumentEnvironment adds the variables to the environment of every plugin of the sub-document, so that they are
+// only set for the commands of the package scripts and not in the environment of the agent
+func withArgumentEnvironment(plugins []model.PluginState, environment map[string]string) []model.PluginState {
+	if len(environment) == 0 {
+		return plugins
+	}
+	for i := range plugins {
+		pluginEnvironment := make(map[string]string, len(plugins[i].Configuration.Environment)+len(environment))
+		for name, value := range plugins[i].Configuration.Environment {
+			pluginEnvironment[name] = value
+		}
+		for name, value := range environment {
+			pluginEnvironment[name] = value
+		}
+		plugins[i].Configuration.Environment = pluginEnvironment
+	}
+	return plugins
+}
@@ -0,0 +1,129 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_cache contains the cache of the downloaded package archives
+package configurepackage
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+const (
+	// packageCacheFolderName is the folder of the package folder the downloaded archive is kept in
+	packageCacheFolderName = "cache"
+
+	// packageCacheInfoFileName is the file of the cache folder describing the cached archive
+	packageCacheInfoFileName = "cache.json"
+)
+
+// packageCacheInfo describes the archive cached for a version of a package
+type packageCacheInfo struct {
+	FileName string    `json:"fileName"`
+	Checksum string    `json:"checksum"`
+	CachedAt time.Time `json:"cachedAt"`
+}
+
+// packageCacheTTL returns how long a cached archive is valid, 0 if the cache is disabled
+func packageCacheTTL(log log.T) time.Duration {
+	return time.Duration(getPackageDownloadConfig(log).PackageCacheTTLMinutes) * time.Minute
+}
+
+// getCachedPackage copies the cached archive of a package into destination and returns its path. The cache is
+// ignored if its archive is older than the TTL or doesn't match its checksum, and it is removed so that the archive
+// is downloaded again.
+func getCachedPackage(log log.T, packageFolder string, destination string) (filePath string, found bool) {
+	ttl := packageCacheTTL(log)
+	if ttl <= 0 {
+		return "", false
+	}
+
+	cacheFolder := filepath.Join(packageFolder, packageCacheFolderName)
+	content, err := filesysdep.ReadFile(filepath.Join(cacheFolder, packageCacheInfoFileName))
+	if err != nil {
+		return "", false
+	}
+	var info packageCacheInfo
+	if err = json.Unmarshal(content, &info); err != nil || info.FileName == "" {
+		log.Debugf("Ignoring invalid package cache in %v", cacheFolder)
+		removeCachedPackage(log, cacheFolder)
+		return "", false
+	}
+
+	if age := time.Since(info.CachedAt); age > ttl {
+		log.Debugf("Cached %v expired %v ago", info.FileName, age-ttl)
+		removeCachedPackage(log, cacheFolder)
+		return "", false
+	}
+
+	cachedPath := filepath.Join(cacheFolder, info.FileName)
+	if checksum, err := filesysdep.Checksum(cachedPath); err != nil || !strings.EqualFold(checksum, info.Checksum) {
+		log.Infof("Cached %v doesn't match its checksum, downloading it again", cachedPath)
+		removeCachedPackage(log, cacheFolder)
+		return "", false
+	}
+
+	filePath = filepath.Join(destination, info.FileName)
+	if err = filesysdep.CopyFile(cachedPath, filePath); err != nil {
+		log.Errorf("Failed to copy cached %v to %v: %v", cachedPath, destination, err)
+		return "", false
+	}
+	return filePath, true
+}
+
+// cachePackage keeps a copy of a downloaded archive in the package folder. A package that can't be cached is
+// downloaded again the next time, so failures are only logged.
+func cachePackage(log log.T, packageFolder string, filePath string) {
+	if packageCacheTTL(log) <= 0 {
+		return
+	}
+
+	cacheFolder := filepath.Join(packageFolder, packageCacheFolderName)
+	if err := writePackageCache(cacheFolder, filePath); err != nil {
+		log.Errorf("Failed to cache %v in %v: %v", filePath, cacheFolder, err)
+		removeCachedPackage(log, cacheFolder)
+	}
+}
+
+// writePackageCache copies an archive into the cache folder along with its checksum and the time it was cached
+func writePackageCache(cacheFolder string, filePath string) (err error) {
+	if err = filesysdep.MakeDirExecute(cacheFolder); err != nil {
+		return
+	}
+	info := packageCacheInfo{FileName: filepath.Base(filePath), CachedAt: time.Now()}
+	cachedPath := filepath.Join(cacheFolder, info.FileName)
+	if err = filesysdep.CopyFile(filePath, cachedPath); err != nil {
+		return
+	}
+	if info.Checksum, err = filesysdep.Checksum(cachedPath); err != nil {
+		return
+	}
+	content, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	return filesysdep.WriteFile(filepath.Join(cacheFolder, packageCacheInfoFileName), string(content))
+}
+
+// removeCachedPackage removes the cache folder of a package
+func removeCachedPackage(log log.T, cacheFolder string) {
+	if err := filesysdep.RemoveAll(cacheFolder); err != nil {
+		log.Errorf("Failed to remove package cache %v: %v", cacheFolder, err)
+	}
+}
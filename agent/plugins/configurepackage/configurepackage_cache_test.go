@@ -0,0 +1,137 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
+	"github.com/stretchr/testify/assert"
+)
+
+// cacheInfoContent returns the content of the cache info of PVDriver.zip, cached at cachedAt
+func cacheInfoContent(t *testing.T, cachedAt time.Time) []byte {
+	content, err := json.Marshal(packageCacheInfo{FileName: "PVDriver.zip", Checksum: "abc123", CachedAt: cachedAt})
+	assert.NoError(t, err)
+	return content
+}
+
+func TestDownloadPackage_CacheHit(t *testing.T) {
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{PackageCacheTTLMinutes: 60})()
+
+	output := contracts.PluginOutput{}
+	manager := createInstance()
+	util := mockConfigureUtility{packageFolder: filepath.Join("packages", "PVDriver", "9000.0.0")}
+
+	fileSysStub := &FileSysDepStub{readResult: cacheInfoContent(t, time.Now().Add(-time.Minute)), checksumResult: "ABC123"}
+	networkStub := &NetworkDepStub{downloadResultDefault: artifact.DownloadOutput{LocalFilePath: "downloads/PVDriver.zip"}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "downloads", &output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("downloads", "PVDriver.zip"), fileName)
+	assert.Equal(t, []string{filepath.Join("downloads", "PVDriver.zip")}, fileSysStub.copiedFiles)
+	assert.Equal(t, 0, networkStub.downloadCount)
+	assert.Contains(t, output.Stdout, "Using the cached installation package of PVDriver 9000.0.0")
+}
+
+func TestDownloadPackage_CacheExpired(t *testing.T) {
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{PackageCacheTTLMinutes: 60})()
+
+	output := contracts.PluginOutput{}
+	manager := createInstance()
+	util := mockConfigureUtility{packageFolder: filepath.Join("packages", "PVDriver", "9000.0.0")}
+
+	fileSysStub := &FileSysDepStub{readResult: cacheInfoContent(t, time.Now().Add(-2*time.Hour)), checksumResult: "abc123"}
+	networkStub := &NetworkDepStub{downloadResultDefault: artifact.DownloadOutput{LocalFilePath: "downloads/PVDriver.zip"}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "downloads", &output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "downloads/PVDriver.zip", fileName)
+	assert.Equal(t, 1, networkStub.downloadCount)
+	// the new download replaces the expired archive in the cache
+	assert.Equal(t, []string{filepath.Join("packages", "PVDriver", "9000.0.0", packageCacheFolderName, "PVDriver.zip")}, fileSysStub.copiedFiles)
+}
+
+func TestDownloadPackage_CacheChecksumMismatch(t *testing.T) {
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{PackageCacheTTLMinutes: 60})()
+
+	output := contracts.PluginOutput{}
+	manager := createInstance()
+	util := mockConfigureUtility{packageFolder: filepath.Join("packages", "PVDriver", "9000.0.0")}
+
+	fileSysStub := &FileSysDepStub{readResult: cacheInfoContent(t, time.Now().Add(-time.Minute)), checksumResult: "def456"}
+	networkStub := &NetworkDepStub{downloadResultDefault: artifact.DownloadOutput{LocalFilePath: "downloads/PVDriver.zip"}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "downloads", &output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "downloads/PVDriver.zip", fileName)
+	assert.Equal(t, 1, networkStub.downloadCount)
+	assert.NotContains(t, output.Stdout, "Using the cached installation package")
+}
+
+func TestDownloadPackage_CacheDisabled(t *testing.T) {
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{PackageCacheTTLMinutes: 0})()
+
+	output := contracts.PluginOutput{}
+	manager := createInstance()
+	util := mockConfigureUtility{packageFolder: filepath.Join("packages", "PVDriver", "9000.0.0")}
+
+	fileSysStub := &FileSysDepStub{readResult: cacheInfoContent(t, time.Now()), checksumResult: "abc123"}
+	networkStub := &NetworkDepStub{downloadResultDefault: artifact.DownloadOutput{LocalFilePath: "downloads/PVDriver.zip"}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	_, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "downloads", &output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, networkStub.downloadCount)
+	assert.Empty(t, fileSysStub.copiedFiles)
+}
+
+func TestHasValidPackage_IgnoresCache(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/sampleManifest.json")
+	assert.NoError(t, err)
+	util := configureUtilImp{}
+
+	// a folder holding only the manifest and the cached archive has no unpacked package
+	fileSysStub := &FileSysDepStub{readResult: manifest, existsResultDefault: true, filesResult: []string{"PVDriver.json"}, directoriesResult: []string{packageCacheFolderName}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub}
+	stubs.Set()
+	defer stubs.Clear()
+	assert.False(t, util.HasValidPackage("PVDriver", "9000.0.0"))
+
+	fileSysStub.directoriesResult = []string{packageCacheFolderName, "driver"}
+	assert.True(t, util.HasValidPackage("PVDriver", "9000.0.0"))
+}
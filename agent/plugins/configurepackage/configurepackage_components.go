@@ -0,0 +1,108 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_components contains the ordered execution of the components bundled in a package
+package configurepackage
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+)
+
+// getLocalManifest returns the manifest of a version of a package on disk, or nil if it cannot be read
+func getLocalManifest(context context.T, packageName string, version string) *PackageManifest {
+	manifestPath := filepath.Join(getPackageFolder(packageName, version), getManifestName(packageName))
+	if !filesysdep.Exists(manifestPath) {
+		return nil
+	}
+	manifest, err := parseTrustedPackageManifest(context.Log(), manifestPath)
+	if err != nil {
+		return nil
+	}
+	return manifest
+}
+
+// reverseComponents returns the components in the opposite order
+func reverseComponents(components []PackageComponent) []PackageComponent {
+	reversed := make([]PackageComponent, len(components))
+	for i, component := range components {
+		reversed[len(components)-1-i] = component
+	}
+	return reversed
+}
+
+// isActionSucceeded returns true if the status of an action allows the next action to run
+func isActionSucceeded(status contracts.ResultStatus) bool {
+	return status == contracts.ResultStatusSuccess ||
+		status == contracts.ResultStatusSuccessAndReboot ||
+		status == contracts.ResultStatusPassedAndReboot
+}
+
+// executeComponentActions executes an action for each component in the given order, stopping at the first failure.
+// If rollback is enabled, rollbackActionName is executed for the components that already completed, in reverse order.
+func (m *configurePackage) executeComponentActions(context context.T,
+	actionName string,
+	rollbackActionName string,
+	packageName string,
+	version string,
+	arguments map[string]string,
+	components []PackageComponent,
+	rollback bool,
+	output *contracts.PluginOutput) (status contracts.ResultStatus, err error) {
+	status = contracts.ResultStatusSuccess
+	directory := getPackageFolder(packageName, version)
+
+	for i, component := range components {
+		_, componentStatus, componentErr := m.executeAction(context, actionName, packageName, version, arguments, output, filepath.Join(directory, component.Name))
+		if componentErr == nil && !isActionSucceeded(componentStatus) {
+			componentErr = fmt.Errorf("%v action state was %v and not %v", actionName, componentStatus, contracts.ResultStatusSuccess)
+		}
+		if componentErr != nil {
+			if rollback {
+				m.rollbackComponentActions(context, rollbackActionName, packageName, version, arguments, components[:i], output)
+			}
+			return contracts.ResultStatusFailed, fmt.Errorf("failed to %v component %v: %v", actionName, component.Name, componentErr)
+		}
+		status = contracts.MergeResultStatus(status, componentStatus)
+	}
+	return
+}
+
+// rollbackComponentActions executes the rollback action for each completed component in reverse order.
+// failures are recorded in the output but do not stop the remaining components from being rolled back
+func (m *configurePackage) rollbackComponentActions(context context.T,
+	rollbackActionName string,
+	packageName string,
+	version string,
+	arguments map[string]string,
+	completed []PackageComponent,
+	output *contracts.PluginOutput) {
+	log := context.Log()
+	directory := getPackageFolder(packageName, version)
+
+	for _, component := range reverseComponents(completed) {
+		output.AppendInfof(log, "Rolling back component %v of %v %v", component.Name, packageName, version)
+		_, status, err := m.executeAction(context, rollbackActionName, packageName, version, arguments, output, filepath.Join(directory, component.Name))
+		if err == nil && !isActionSucceeded(status) {
+			err = fmt.Errorf("%v action state was %v and not %v", rollbackActionName, status, contracts.ResultStatusSuccess)
+		}
+		if err != nil {
+			output.AppendErrorf(log, "failed to roll back component %v: %v", component.Name, err)
+		}
+	}
+}
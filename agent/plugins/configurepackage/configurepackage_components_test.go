@@ -0,0 +1,139 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadComponentsManifest(t *testing.T, rollback bool) []byte {
+	content, err := ioutil.ReadFile("testdata/sampleManifestComponents.json")
+	assert.NoError(t, err)
+	var manifest PackageManifest
+	assert.NoError(t, json.Unmarshal(content, &manifest))
+	manifest.Rollback = rollback
+	result, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+	return result
+}
+
+func componentFolder(name string) string {
+	return filepath.Join(getPackageFolder("PVDriver", "9000.0.0"), name)
+}
+
+func TestInstallPackage_ComponentsInOrder(t *testing.T) {
+	pluginInformation := createStubPluginInputInstall()
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	execStub := &ExecDepStub{pluginInput: &model.PluginState{}, pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: loadComponentsManifest(t, true)}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	status, err := manager.runInstallPackage(contextMock, pluginInformation.Name, pluginInformation.Version, nil, output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, contracts.ResultStatusSuccess, status)
+	assert.Equal(t, []string{componentFolder("Base"), componentFolder("Driver"), componentFolder("Service")}, execStub.parsedDirectories)
+}
+
+func TestInstallPackage_ComponentFailureRollsBack(t *testing.T) {
+	pluginInformation := createStubPluginInputInstall()
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	execStub := &ExecDepStub{
+		pluginInput:  &model.PluginState{},
+		pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess},
+		pluginOutputSequence: []*contracts.PluginResult{
+			{Status: contracts.ResultStatusSuccess},
+			{Status: contracts.ResultStatusFailed},
+		},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: loadComponentsManifest(t, true)}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	status, err := manager.runInstallPackage(contextMock, pluginInformation.Name, pluginInformation.Version, nil, output)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Driver")
+	assert.Equal(t, contracts.ResultStatusFailed, status)
+	// Service is never installed and Base is uninstalled again
+	assert.Equal(t, []string{componentFolder("Base"), componentFolder("Driver"), componentFolder("Base")}, execStub.parsedDirectories)
+	assert.Contains(t, output.Stdout, "Rolling back component Base")
+}
+
+func TestInstallPackage_ComponentFailureWithoutRollback(t *testing.T) {
+	pluginInformation := createStubPluginInputInstall()
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	execStub := &ExecDepStub{
+		pluginInput:  &model.PluginState{},
+		pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess},
+		pluginOutputSequence: []*contracts.PluginResult{
+			{Status: contracts.ResultStatusSuccess},
+			{Status: contracts.ResultStatusFailed},
+		},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: loadComponentsManifest(t, false)}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	_, err := manager.runInstallPackage(contextMock, pluginInformation.Name, pluginInformation.Version, nil, output)
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{componentFolder("Base"), componentFolder("Driver")}, execStub.parsedDirectories)
+}
+
+func TestUninstallPackage_ComponentsInReverseOrder(t *testing.T) {
+	pluginInformation := createStubPluginInputUninstall()
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	execStub := &ExecDepStub{pluginInput: &model.PluginState{}, pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: loadComponentsManifest(t, true)}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	_, err := manager.runUninstallPackagePre(contextMock, pluginInformation.Name, pluginInformation.Version, nil, output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{componentFolder("Service"), componentFolder("Driver"), componentFolder("Base")}, execStub.parsedDirectories)
+}
+
+func TestValidateManifest_DuplicateComponent(t *testing.T) {
+	manifest := &PackageManifest{
+		Name:       "PVDriver",
+		Version:    "1.0.0",
+		Components: []PackageComponent{{Name: "Base"}, {Name: "Base"}},
+	}
+
+	err := validateManifest(manifest)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "components[1].name")
+	assert.Contains(t, err.Error(), "duplicate component name")
+}
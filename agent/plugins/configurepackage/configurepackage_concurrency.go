@@ -0,0 +1,53 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_concurrency contains the limit of the package downloads running at the same time
+package configurepackage
+
+import (
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// packageDownloads limits the package downloads of all the documents
+var packageDownloads = &downloadLimiter{}
+
+// downloadLimiter is a semaphore of download slots, sized from the configuration the first time a slot is acquired
+type downloadLimiter struct {
+	once  sync.Once
+	slots chan struct{}
+}
+
+// acquire waits for a download slot, the returned function releases it
+func (l *downloadLimiter) acquire(log log.T, output *contracts.PluginOutput, packageName string, version string) (release func()) {
+	l.once.Do(func() {
+		size := getPackageDownloadConfig(log).MaxConcurrentDownloads
+		if size < 1 {
+			size = appconfig.DefaultMaxConcurrentDownloads
+		}
+		l.slots = make(chan struct{}, size)
+	})
+
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		output.AppendInfof(log, "Waiting for %v other package download(s) to finish before downloading %v %v", cap(l.slots), packageName, version)
+		l.slots <- struct{}{}
+	}
+	return func() { <-l.slots }
+}
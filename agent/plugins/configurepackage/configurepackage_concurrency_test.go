@@ -0,0 +1,85 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/s3util"
+	"github.com/stretchr/testify/assert"
+)
+
+// concurrentDownloadStub records the number of downloads running at the same time
+type concurrentDownloadStub struct {
+	running    int32
+	maxRunning int32
+	downloads  int32
+}
+
+func (m *concurrentDownloadStub) ListS3Folders(log log.T, amazonS3URL s3util.AmazonS3URL) (folderNames []string, err error) {
+	return nil, nil
+}
+
+func (m *concurrentDownloadStub) Download(log log.T, input artifact.DownloadInput) (output artifact.DownloadOutput, err error) {
+	running := atomic.AddInt32(&m.running, 1)
+	defer atomic.AddInt32(&m.running, -1)
+	for {
+		maxRunning := atomic.LoadInt32(&m.maxRunning)
+		if running <= maxRunning || atomic.CompareAndSwapInt32(&m.maxRunning, maxRunning, running) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	atomic.AddInt32(&m.downloads, 1)
+	return artifact.DownloadOutput{LocalFilePath: "packages/PVDriver/9000.0.0/PVDriver.zip"}, nil
+}
+
+func TestDownloadPackage_MaxConcurrentDownloads(t *testing.T) {
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{MaxConcurrentDownloads: 2})()
+	packageDownloadsOrig := packageDownloads
+	packageDownloads = &downloadLimiter{}
+	defer func() { packageDownloads = packageDownloadsOrig }()
+
+	networkStub := &concurrentDownloadStub{}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	pluginInformation := createStubPluginInputInstall()
+	manager := createInstance()
+	var wait sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			output := contracts.PluginOutput{}
+			util := mockConfigureUtility{}
+			_, err := manager.downloadPackage(contextMock, &util, pluginInformation.Name, pluginInformation.Version, defaultDownloadRetryPolicy(loggerMock), "", &output)
+			assert.NoError(t, err)
+		}()
+	}
+	wait.Wait()
+
+	assert.Equal(t, int32(6), networkStub.downloads)
+	assert.Equal(t, int32(2), networkStub.maxRunning)
+}
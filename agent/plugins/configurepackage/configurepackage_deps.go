@@ -17,9 +17,13 @@
 package configurepackage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"io/ioutil"
 	"os"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/fileutil"
@@ -43,7 +47,14 @@ type fileSysDep interface {
 	RemoveAll(path string) error
 	Rename(oldpath, newpath string) error
 	ReadFile(filename string) ([]byte, error)
+	// ReadFileLimit reads at most limit+1 bytes of the file, so that a file larger than limit is detected without reading it whole
+	ReadFileLimit(filename string, limit int64) ([]byte, error)
 	WriteFile(filename string, content string) error
+	AppendFile(filename string, content string) error
+	FreeDiskSpace(path string) (int64, error)
+	CopyFile(src, dst string) error
+	// Checksum returns the hex encoded sha256 of the file
+	Checksum(filename string) (string, error)
 }
 
 type fileSysDepImp struct{}
@@ -65,7 +76,7 @@ func (fileSysDepImp) Exists(filePath string) bool {
 }
 
 func (fileSysDepImp) Uncompress(src, dest string) error {
-	return fileutil.Uncompress(src, dest)
+	return fileutil.ExtractArchive(src, dest)
 }
 
 func (fileSysDepImp) RemoveAll(path string) error {
@@ -80,10 +91,64 @@ func (fileSysDepImp) ReadFile(filename string) ([]byte, error) {
 	return ioutil.ReadFile(filename)
 }
 
+func (fileSysDepImp) ReadFileLimit(filename string, limit int64) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(io.LimitReader(file, limit+1))
+}
+
 func (fileSysDepImp) WriteFile(filename string, content string) error {
 	return fileutil.WriteAllText(filename, content)
 }
 
+func (fileSysDepImp) AppendFile(filename string, content string) error {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, appconfig.ReadWriteAccess)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(content)
+	return err
+}
+
+func (fileSysDepImp) FreeDiskSpace(path string) (int64, error) {
+	diskSpaceInfo, err := fileutil.GetDiskSpaceInfoForPath(path)
+	return diskSpaceInfo.AvailBytes, err
+}
+
+func (fileSysDepImp) CopyFile(src, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, appconfig.ReadWriteAccess)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
+}
+
+func (fileSysDepImp) Checksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
 var networkdep networkDep = &networkDepImp{}
 
 // dependency on S3 and downloaded artifacts
@@ -95,10 +160,19 @@ type networkDep interface {
 type networkDepImp struct{}
 
 func (networkDepImp) ListS3Folders(log log.T, amazonS3URL s3util.AmazonS3URL) (folderNames []string, err error) {
-	return artifact.ListS3Folders(log, amazonS3URL)
+	transport, err := getPackageDownloadTransport(log)
+	if err != nil {
+		return nil, err
+	}
+	return artifact.ListS3Folders(log, amazonS3URL, transport)
 }
 
 func (networkDepImp) Download(log log.T, input artifact.DownloadInput) (output artifact.DownloadOutput, err error) {
+	if input.Transport == nil && input.Proxy == nil && input.TLSConfig == nil {
+		if input.Transport, err = getPackageDownloadTransport(log); err != nil {
+			return output, err
+		}
+	}
 	return artifact.Download(log, input)
 }
 
@@ -108,7 +182,7 @@ var execdep execDep = &execDepImp{util: new(updateutil.Utility)}
 type execDep interface {
 	ExeCommand(log log.T, cmd string, workingDir string, updaterRoot string, stdOut string, stdErr string, isAsync bool) (err error)
 	ParseDocument(context context.T, documentRaw []byte, orchestrationDir string, s3Bucket string, s3KeyPrefix string, messageID string, documentID string, defaultWorkingDirectory string) (pluginsInfo []model.PluginState, err error)
-	ExecuteDocument(runner runpluginutil.PluginRunner, context context.T, pluginInput []model.PluginState, documentID string, documentCreatedDate string) (pluginOutputs map[string]*contracts.PluginResult)
+	ExecuteDocument(runner runpluginutil.PluginRunner, context context.T, pluginInput []model.PluginState, documentID string, documentCreatedDate string, environment map[string]string) (pluginOutputs map[string]*contracts.PluginResult)
 }
 
 type execDepImp struct {
@@ -123,8 +197,8 @@ func (m *execDepImp) ParseDocument(context context.T, documentRaw []byte, orches
 	return runpluginutil.ParseDocument(context, documentRaw, orchestrationDir, s3Bucket, s3KeyPrefix, messageID, documentID, defaultWorkingDirectory)
 }
 
-func (m *execDepImp) ExecuteDocument(runner runpluginutil.PluginRunner, context context.T, pluginInput []model.PluginState, documentID string, documentCreatedDate string) (pluginOutputs map[string]*contracts.PluginResult) {
+func (m *execDepImp) ExecuteDocument(runner runpluginutil.PluginRunner, context context.T, pluginInput []model.PluginState, documentID string, documentCreatedDate string, environment map[string]string) (pluginOutputs map[string]*contracts.PluginResult) {
 	log := context.Log()
 	log.Debugf("Running subcommand")
-	return runner.ExecuteDocument(context, pluginInput, documentID, documentCreatedDate)
+	return runner.ExecuteDocument(context, withArgumentEnvironment(pluginInput, environment), documentID, documentCreatedDate)
 }
@@ -0,0 +1,50 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_diskspace contains the check for disk space before a package is downloaded
+package configurepackage
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// getArchiveSize returns the archive size the manifest declares for a version, or 0 if it is not declared
+func getArchiveSize(log log.T, util configureUtil, packageName string, version string) int64 {
+	if versionManifest := getVersionManifest(log, util, packageName, version); versionManifest != nil {
+		return versionManifest.ArchiveSize
+	}
+	return 0
+}
+
+// checkDiskSpaceForDownload returns an error if the volume of the download destination doesn't have room for the
+// package archive. The check is skipped when the manifest doesn't declare the size of the archive.
+func checkDiskSpaceForDownload(log log.T, util configureUtil, packageName string, version string, destination string) error {
+	archiveSize := getArchiveSize(log, util, packageName, version)
+	if archiveSize <= 0 {
+		return nil
+	}
+	freeSpace, err := filesysdep.FreeDiskSpace(destination)
+	if err != nil {
+		log.Errorf("Failed to determine free disk space for %v, skipping disk space check: %v", destination, err)
+		return nil
+	}
+	if freeSpace < archiveSize {
+		return fmt.Errorf("insufficient disk space to download %v %v, %v bytes required but %v bytes available in %v",
+			packageName, version, archiveSize, freeSpace, destination)
+	}
+	return nil
+}
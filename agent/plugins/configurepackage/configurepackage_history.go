@@ -0,0 +1,98 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_history contains the durable record of actions taken on each package
+package configurepackage
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/times"
+)
+
+// HistoryFileName is the name of the file in the package root that records the actions taken on the package
+const HistoryFileName = "history.json"
+
+// PackageHistoryEntry represents one terminal action taken on a package.
+type PackageHistoryEntry struct {
+	Timestamp   string                 `json:"timestamp"`
+	PackageName string                 `json:"packageName"`
+	Action      string                 `json:"action"`
+	FromVersion string                 `json:"fromVersion"`
+	ToVersion   string                 `json:"toVersion"`
+	Result      contracts.ResultStatus `json:"result"`
+}
+
+// getHistoryFile returns the location of the history file for a package
+func getHistoryFile(packageName string) string {
+	return filepath.Join(getPackageRoot(packageName), HistoryFileName)
+}
+
+// recordPackageHistory appends an entry for a terminal action to the history file of the package
+// failure to record history is logged but never fails the action itself
+func recordPackageHistory(log log.T, packageName string, action string, fromVersion string, toVersion string, result contracts.ResultStatus) {
+	entry := PackageHistoryEntry{
+		Timestamp:   times.ToIso8601UTC(time.Now()),
+		PackageName: packageName,
+		Action:      action,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Result:      result,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("Failed to marshal history entry for package %v: %v", packageName, err)
+		return
+	}
+	if err = filesysdep.AppendFile(getHistoryFile(packageName), string(line)+"\n"); err != nil {
+		log.Errorf("Failed to record history for package %v: %v", packageName, err)
+	}
+}
+
+// readPackageHistory returns the recorded history of a package, an empty history is returned if the file is missing
+func readPackageHistory(log log.T, packageName string) (history []PackageHistoryEntry) {
+	historyFile := getHistoryFile(packageName)
+	if !filesysdep.Exists(historyFile) {
+		return []PackageHistoryEntry{}
+	}
+	content, err := filesysdep.ReadFile(historyFile)
+	if err != nil {
+		log.Errorf("Failed to read history for package %v: %v", packageName, err)
+		return []PackageHistoryEntry{}
+	}
+	return parsePackageHistory(log, content)
+}
+
+// parsePackageHistory parses one history entry per line, skipping lines that are not valid entries
+func parsePackageHistory(log log.T, content []byte) (history []PackageHistoryEntry) {
+	history = []PackageHistoryEntry{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var entry PackageHistoryEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			log.Debugf("Skipping corrupt package history entry %v: %v", line, err)
+			continue
+		}
+		history = append(history, entry)
+	}
+	return history
+}
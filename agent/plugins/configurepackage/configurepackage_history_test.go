@@ -0,0 +1,133 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPackageHistory_InstallThenUninstall(t *testing.T) {
+	fileSysStub := &FileSysDepStub{}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+
+	installMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	installOutput := runConfigurePackage(plugin, contextMock, installMock, instanceContext, createStubPluginInputInstall())
+	assert.Equal(t, 0, installOutput.ExitCode)
+
+	uninstallMock := ConfigPackageSuccessMock("/foo", "1.0.0", "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	uninstallOutput := runConfigurePackage(plugin, contextMock, uninstallMock, instanceContext, createStubPluginInputUninstall())
+	assert.Equal(t, 0, uninstallOutput.ExitCode)
+
+	history := parsePackageHistory(loggerMock, []byte(fileSysStub.appendContent))
+	assert.Equal(t, 2, len(history))
+
+	assert.Equal(t, "PVDriver", history[0].PackageName)
+	assert.Equal(t, InstallAction, history[0].Action)
+	assert.Equal(t, "0.5.6", history[0].FromVersion)
+	assert.Equal(t, "1.0.0", history[0].ToVersion)
+	assert.Equal(t, contracts.ResultStatusSuccess, history[0].Result)
+	assert.NotEmpty(t, history[0].Timestamp)
+
+	assert.Equal(t, "PVDriver", history[1].PackageName)
+	assert.Equal(t, UninstallAction, history[1].Action)
+	assert.Equal(t, "1.0.0", history[1].FromVersion)
+	assert.Equal(t, "", history[1].ToVersion)
+	assert.Equal(t, contracts.ResultStatusSuccess, history[1].Result)
+}
+
+func TestPackageHistory_InstallFailures(t *testing.T) {
+	fileSysStub := &FileSysDepStub{}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+
+	// the version to install can't be determined
+	versionMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	versionMock.ExpectedCalls = removeExpectedCall(versionMock.ExpectedCalls, "getVersionToInstall")
+	versionMock.On("getVersionToInstall", mock.Anything, mock.Anything, mock.Anything).Return("", "", errors.New("manifest unavailable"))
+	runConfigurePackage(plugin, contextMock, versionMock, instanceContext, createStubPluginInputInstall())
+
+	// the package can't be obtained
+	ensureMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	ensureMock.ExpectedCalls = removeExpectedCall(ensureMock.ExpectedCalls, "ensurePackage")
+	ensureMock.On("ensurePackage", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return((*PackageManifest)(nil), errors.New("download failed"))
+	runConfigurePackage(plugin, contextMock, ensureMock, instanceContext, createStubPluginInputInstall())
+
+	history := parsePackageHistory(loggerMock, []byte(fileSysStub.appendContent))
+	assert.Equal(t, 2, len(history))
+	for _, entry := range history {
+		assert.Equal(t, InstallAction, entry.Action)
+		assert.Equal(t, contracts.ResultStatusFailed, entry.Result)
+	}
+	assert.Equal(t, "", history[0].FromVersion)
+	assert.Equal(t, "0.5.6", history[1].FromVersion)
+	assert.Equal(t, "1.0.0", history[1].ToVersion)
+}
+
+func TestReadPackageHistory_Missing(t *testing.T) {
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: false}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	assert.Equal(t, 0, len(readPackageHistory(loggerMock, "PVDriver")))
+}
+
+func TestReadPackageHistory_Unreadable(t *testing.T) {
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readError: errors.New("read failed")}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	assert.Equal(t, 0, len(readPackageHistory(loggerMock, "PVDriver")))
+}
+
+func TestReadPackageHistory_Corrupt(t *testing.T) {
+	content := `{"timestamp":"2017-01-01T00:00:00.000Z","packageName":"PVDriver","action":"Install","fromVersion":"","toVersion":"1.0.0","result":"Success"}
+{"timestamp":"2017-01-02T00:0
+FOO
+{"timestamp":"2017-01-03T00:00:00.000Z","packageName":"PVDriver","action":"Uninstall","fromVersion":"1.0.0","toVersion":"","result":"Success"}
+`
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: []byte(content)}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	history := readPackageHistory(loggerMock, "PVDriver")
+
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, "1.0.0", history[0].ToVersion)
+	assert.Equal(t, "1.0.0", history[1].FromVersion)
+}
+
+func TestRecordPackageHistory_AppendFailed(t *testing.T) {
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{appendError: errors.New("disk full")}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	// failure to record history must not panic or fail the action
+	recordPackageHistory(loggerMock, "PVDriver", InstallAction, "", "1.0.0", contracts.ResultStatusSuccess)
+}
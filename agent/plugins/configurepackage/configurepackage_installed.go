@@ -0,0 +1,84 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_installed contains the enumeration of the packages installed on the instance
+package configurepackage
+
+import (
+	"path/filepath"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+)
+
+// InstalledPackage represents a package installed on the instance and its installed version.
+type InstalledPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// ListInstalledPackages returns the installed version of each package in the package root.
+// Packages with an install in progress are skipped, since their installed version isn't settled yet.
+func ListInstalledPackages() (packages []InstalledPackage, err error) {
+	packages = make([]InstalledPackage, 0)
+	if !filesysdep.Exists(appconfig.PackageRoot) {
+		return packages, nil
+	}
+
+	var packageNames []string
+	if packageNames, err = filesysdep.GetDirectoryNames(appconfig.PackageRoot); err != nil {
+		return nil, err
+	}
+	for _, packageName := range packageNames {
+		if isPackageInstallInProgress(packageName) {
+			continue
+		}
+		versions, dirErr := filesysdep.GetDirectoryNames(getPackageRoot(packageName))
+		if dirErr != nil {
+			return nil, dirErr
+		}
+		if version := getLatestVersion(getSettledVersions(packageName, getUnmarkedVersions(packageName, versions)), ""); version != "" {
+			packages = append(packages, InstalledPackage{Name: packageName, Version: version})
+		}
+	}
+	return packages, nil
+}
+
+// isPackageInstallInProgress returns true if the package is marked as installing or an action holds the lock of the whole package
+func isPackageInstallInProgress(packageName string) bool {
+	if getInstallingPackageVersion(packageName) != "" {
+		return true
+	}
+	lockPackageAction.Lock()
+	defer lockPackageAction.Unlock()
+	_, locked := mapPackageAction[packageName]
+	return locked
+}
+
+// getSettledVersions returns the versions of a package that no side-by-side action is currently acting on
+func getSettledVersions(packageName string, versions []string) (settled []string) {
+	lockPackageAction.Lock()
+	defer lockPackageAction.Unlock()
+	for _, version := range versions {
+		if _, locked := mapPackageVersionAction[packageName][version]; !locked {
+			settled = append(settled, version)
+		}
+	}
+	return settled
+}
+
+// isInstalledVersionHealthy returns true if the manifest of the installed version is still in its package folder
+func isInstalledVersionHealthy(packageName string, version string) bool {
+	return filesysdep.Exists(filepath.Join(getPackageFolder(packageName, version), getManifestName(packageName)))
+}
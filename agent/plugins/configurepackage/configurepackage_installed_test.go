@@ -0,0 +1,150 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+// packageRootStub is a file system stub holding the folders and files of a package root
+type packageRootStub struct {
+	FileSysDepStub
+	directories map[string][]string
+	files       map[string]string
+}
+
+func (m *packageRootStub) Exists(filePath string) bool {
+	if _, ok := m.directories[filePath]; ok {
+		return true
+	}
+	_, ok := m.files[filePath]
+	return ok
+}
+
+func (m *packageRootStub) GetDirectoryNames(srcPath string) (directories []string, err error) {
+	if directories, ok := m.directories[srcPath]; ok {
+		return directories, nil
+	}
+	return nil, errors.New("directory not found")
+}
+
+func (m *packageRootStub) GetFileNames(srcPath string) (files []string, err error) {
+	for filePath := range m.files {
+		if filepath.Dir(filePath) == srcPath {
+			files = append(files, filepath.Base(filePath))
+		}
+	}
+	return files, nil
+}
+
+func (m *packageRootStub) ReadFile(filename string) ([]byte, error) {
+	if content, ok := m.files[filename]; ok {
+		return []byte(content), nil
+	}
+	return nil, errors.New("file not found")
+}
+
+func (m *packageRootStub) ReadFileLimit(filename string, limit int64) ([]byte, error) {
+	return m.ReadFile(filename)
+}
+
+func TestListInstalledPackages(t *testing.T) {
+	fileSysStub := &packageRootStub{
+		directories: map[string][]string{
+			appconfig.PackageRoot:                                       {"PVDriver", "AwsEnaNetworkDriver", "AWSNVMe"},
+			filepath.Join(appconfig.PackageRoot, "PVDriver"):            {"1.0.0", "1.1.0"},
+			filepath.Join(appconfig.PackageRoot, "AwsEnaNetworkDriver"): {"2.0.1"},
+			filepath.Join(appconfig.PackageRoot, "AWSNVMe"):             {"1.0.0", "1.2.0"},
+		},
+		files: map[string]string{
+			// AWSNVMe is in the middle of installing 1.2.0
+			getMarkFile("AWSNVMe"): "1.2.0",
+		},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	packages, err := ListInstalledPackages()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []InstalledPackage{
+		{Name: "PVDriver", Version: "1.1.0"},
+		{Name: "AwsEnaNetworkDriver", Version: "2.0.1"},
+	}, packages)
+}
+
+func TestListInstalledPackages_SkipsVersionInstallingSideBySide(t *testing.T) {
+	fileSysStub := &packageRootStub{
+		directories: map[string][]string{
+			appconfig.PackageRoot:                            {"PVDriver"},
+			filepath.Join(appconfig.PackageRoot, "PVDriver"): {"1.0.0", "1.1.0", "2.0.0"},
+		},
+		files: map[string]string{
+			// 2.0.0 is in the middle of installing side-by-side, the other versions stay listed
+			getVersionMarkFile("PVDriver", "2.0.0"): "2.0.0",
+		},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	packages, err := ListInstalledPackages()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []InstalledPackage{{Name: "PVDriver", Version: "1.1.0"}}, packages)
+	assert.Equal(t, "1.1.0", (&configureUtilImp{}).GetCurrentVersion("PVDriver"))
+
+	// once installed the version is the current one
+	delete(fileSysStub.files, getVersionMarkFile("PVDriver", "2.0.0"))
+	assert.Equal(t, "2.0.0", (&configureUtilImp{}).GetCurrentVersion("PVDriver"))
+}
+
+func TestListInstalledPackages_SkipsLockedPackage(t *testing.T) {
+	fileSysStub := &packageRootStub{
+		directories: map[string][]string{
+			appconfig.PackageRoot:                            {"PVDriver"},
+			filepath.Join(appconfig.PackageRoot, "PVDriver"): {"1.0.0"},
+		},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	assert.NoError(t, lockPackage("PVDriver", InstallAction))
+	defer unlockPackage("PVDriver")
+
+	packages, err := ListInstalledPackages()
+
+	assert.NoError(t, err)
+	assert.Empty(t, packages)
+}
+
+func TestListInstalledPackages_NoPackageRoot(t *testing.T) {
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &packageRootStub{}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	packages, err := ListInstalledPackages()
+
+	assert.NoError(t, err)
+	assert.Empty(t, packages)
+}
@@ -19,30 +19,180 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
 )
 
-// Prevent multiple actions for the same package at the same time
+// Prevent multiple actions for the same package at the same time.
+// Actions normally lock the whole package, since installing a version replaces the version that is active.
+// Side-by-side actions lock a single version instead: they can run at the same time as side-by-side actions
+// on other versions of the package, but never with an action on the same version or one locking the whole package.
+// The locks live in the memory of the agent and don't survive it, a lock is only left behind by an action that never
+// returns, e.g. one stuck in an install that timed out. Such locks are reclaimed once they are older than the maximum age.
 var lockPackageAction = &sync.Mutex{}
-var mapPackageAction = make(map[string]string)
+var mapPackageAction = make(map[string]*packageAction)
+var mapPackageVersionAction = make(map[string]map[string]*packageAction)
+
+// maxPackageLockAge returns the age above which a lock is considered abandoned, 0 to never reclaim locks
+var maxPackageLockAge = func(log log.T) time.Duration {
+	return time.Duration(getPackageDownloadConfig(log).MaxLockAgeMinutes) * time.Minute
+}
+
+// packageAction is an action in progress on a whole package or on a version of a package
+type packageAction struct {
+	action  string
+	version string
+	// acquiredAt is when the action locked the package
+	acquiredAt time.Time
+	// done is closed when the action completes, output is then the result of the action
+	done   chan struct{}
+	output contracts.PluginOutput
+}
+
+// newPackageAction returns an action on a version of a package that locks it now
+func newPackageAction(action string, version string) *packageAction {
+	return &packageAction{action: action, version: version, acquiredAt: time.Now(), done: make(chan struct{})}
+}
+
+// wait blocks until the action completes and returns its result
+func (a *packageAction) wait() contracts.PluginOutput {
+	<-a.done
+	return a.output
+}
+
+// isStale returns true if the action has held its lock for longer than the maximum age
+func (a *packageAction) isStale(log log.T) bool {
+	maxAge := maxPackageLockAge(log)
+	return maxAge > 0 && time.Since(a.acquiredAt) > maxAge
+}
+
+// complete hands the result of the action to the callers that joined it
+func (a *packageAction) complete(output contracts.PluginOutput) {
+	a.output = output
+	close(a.done)
+}
+
+// reclaimStalePackageLocks removes the locks of a package whose actions are older than the maximum age, so that
+// an action that never returned doesn't block the package forever. The callers waiting for a reclaimed action are
+// given a failed result.
+func reclaimStalePackageLocks(log log.T, packageName string) {
+	lockPackageAction.Lock()
+	defer lockPackageAction.Unlock()
+	if val, ok := mapPackageAction[packageName]; ok && val.isStale(log) {
+		log.Warnf("reclaiming the lock of package %v held by action %v since %v", packageName, val.action, val.acquiredAt)
+		var output contracts.PluginOutput
+		output.MarkAsFailed(log, fmt.Errorf("action %v of package %v was abandoned after holding its lock since %v", val.action, packageName, val.acquiredAt))
+		val.complete(output)
+		delete(mapPackageAction, packageName)
+	}
+	for version, val := range mapPackageVersionAction[packageName] {
+		if val.isStale(log) {
+			log.Warnf("reclaiming the lock of package %v version %v held by action %v since %v", packageName, version, val.action, val.acquiredAt)
+			removePackageVersionLock(packageName, version)
+		}
+	}
+}
 
 // lockPackage adds the package name to the list of packages currently being acted on in a threadsafe way
 func lockPackage(packageName string, action string) error {
+	_, _, err := lockOrJoinPackage(packageName, "", action, false)
+	return err
+}
+
+// lockOrJoinPackage locks the whole package for an action on a version like lockPackage and returns the lock to
+// release. If join is set and the exact same action on the same version is already in progress, the package is not
+// locked and the action in progress is returned instead with joined set, so that its result can be waited for and adopted.
+func lockOrJoinPackage(packageName string, version string, action string, join bool) (lock *packageAction, joined bool, err error) {
 	lockPackageAction.Lock()
 	defer lockPackageAction.Unlock()
 	if val, ok := mapPackageAction[packageName]; ok {
-		return errors.New(fmt.Sprintf(`Package "%v" is already in the process of action "%v"`, packageName, val))
+		if join && val.action == action && val.version == version {
+			return val, true, nil
+		}
+		return nil, false, errors.New(fmt.Sprintf(`Package "%v" is already in the process of action "%v"`, packageName, val.action))
+	}
+	for version, val := range mapPackageVersionAction[packageName] {
+		return nil, false, errors.New(fmt.Sprintf(`Package "%v" version "%v" is already in the process of action "%v"`, packageName, version, val.action))
 	}
-	mapPackageAction[packageName] = action
+	lock = newPackageAction(action, version)
+	mapPackageAction[packageName] = lock
 
-	return nil
+	return lock, false, nil
+}
+
+// lockPackageVersion adds a version of a package to the list of package versions currently being acted on in a threadsafe way
+func lockPackageVersion(packageName string, version string, action string) error {
+	_, err := acquirePackageVersion(packageName, version, action)
+	return err
+}
+
+// acquirePackageVersion locks a version of a package like lockPackageVersion and returns the lock to release
+func acquirePackageVersion(packageName string, version string, action string) (lock *packageAction, err error) {
+	lockPackageAction.Lock()
+	defer lockPackageAction.Unlock()
+	if val, ok := mapPackageAction[packageName]; ok {
+		return nil, errors.New(fmt.Sprintf(`Package "%v" is already in the process of action "%v"`, packageName, val.action))
+	}
+	if val, ok := mapPackageVersionAction[packageName][version]; ok {
+		return nil, errors.New(fmt.Sprintf(`Package "%v" version "%v" is already in the process of action "%v"`, packageName, version, val.action))
+	}
+	if _, ok := mapPackageVersionAction[packageName]; !ok {
+		mapPackageVersionAction[packageName] = make(map[string]*packageAction)
+	}
+	lock = newPackageAction(action, version)
+	mapPackageVersionAction[packageName][version] = lock
+
+	return lock, nil
+}
+
+// unlockPackageVersion removes a version of a package from the list of package versions currently being acted on in a threadsafe way
+func unlockPackageVersion(packageName string, version string) {
+	lockPackageAction.Lock()
+	defer lockPackageAction.Unlock()
+	removePackageVersionLock(packageName, version)
+}
+
+// releasePackageVersion unlocks a version of a package locked by acquirePackageVersion, unless its lock was
+// reclaimed and the version is now locked by another action
+func releasePackageVersion(packageName string, version string, lock *packageAction) {
+	lockPackageAction.Lock()
+	defer lockPackageAction.Unlock()
+	if mapPackageVersionAction[packageName][version] == lock {
+		removePackageVersionLock(packageName, version)
+	}
+}
+
+// removePackageVersionLock removes the lock of a version of a package, lockPackageAction must be held
+func removePackageVersionLock(packageName string, version string) {
+	if versions, ok := mapPackageVersionAction[packageName]; ok {
+		delete(versions, version)
+		if len(versions) == 0 {
+			delete(mapPackageVersionAction, packageName)
+		}
+	}
 }
 
 // unlockPackage removes the package name from the list of packages currently being acted on in a threadsafe way
 func unlockPackage(packageName string) {
 	lockPackageAction.Lock()
 	defer lockPackageAction.Unlock()
-	if _, ok := mapPackageAction[packageName]; ok {
+	if val, ok := mapPackageAction[packageName]; ok {
+		val.complete(contracts.PluginOutput{})
+		delete(mapPackageAction, packageName)
+	}
+}
+
+// unlockPackageWithResult unlocks the package locked by lockOrJoinPackage and hands the result of the action to the
+// callers that joined it. A lock that was reclaimed has already been completed and the package is left as it is.
+func unlockPackageWithResult(packageName string, lock *packageAction, output contracts.PluginOutput) {
+	lockPackageAction.Lock()
+	defer lockPackageAction.Unlock()
+	if val, ok := mapPackageAction[packageName]; ok && val == lock {
+		val.complete(output)
 		delete(mapPackageAction, packageName)
 	}
 }
@@ -76,3 +226,53 @@ func getInstallingPackageVersion(packageName string) string {
 func unmarkInstallingPackage(packageName string) error {
 	return filesysdep.RemoveAll(getMarkFile(packageName))
 }
+
+// versionMarkPrefix is the prefix of the names of the mark files of the versions installed side-by-side, the version
+// follows it
+const versionMarkPrefix = "installing-"
+
+// getVersionMarkFile builds the name of the mark file of a version installed side-by-side
+func getVersionMarkFile(packageName string, version string) string {
+	return filepath.Join(getPackageRoot(packageName), versionMarkPrefix+version)
+}
+
+// markInstallingPackageVersion writes a file marking a version installed side-by-side as downloaded but not yet
+// installed. Unlike the mark of the package, it only concerns that version, so that the other versions of the
+// package stay visible while it installs.
+func markInstallingPackageVersion(packageName string, version string) error {
+	return filesysdep.WriteFile(getVersionMarkFile(packageName, version), version)
+}
+
+// getInstallingPackageVersions returns the versions of a package marked as installing side-by-side
+func getInstallingPackageVersions(packageName string) (versions []string) {
+	files, err := filesysdep.GetFileNames(getPackageRoot(packageName))
+	if err != nil {
+		return nil
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file, versionMarkPrefix) {
+			versions = append(versions, strings.TrimPrefix(file, versionMarkPrefix))
+		}
+	}
+	return versions
+}
+
+// unmarkInstallingPackageVersion removes the file marking a version installed side-by-side as not yet installed
+func unmarkInstallingPackageVersion(packageName string, version string) error {
+	return filesysdep.RemoveAll(getVersionMarkFile(packageName, version))
+}
+
+// getUnmarkedVersions returns the versions of a package that are neither marked as installing by the mark of the
+// package nor by their own mark
+func getUnmarkedVersions(packageName string, versions []string) (unmarked []string) {
+	marked := map[string]bool{getInstallingPackageVersion(packageName): true}
+	for _, version := range getInstallingPackageVersions(packageName) {
+		marked[version] = true
+	}
+	for _, version := range versions {
+		if !marked[version] {
+			unmarked = append(unmarked, version)
+		}
+	}
+	return unmarked
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"sync"
+	"time"
+)
+
+// manifestCacheTTL is how long a downloaded package manifest is reused by the installs referencing the same location
+var manifestCacheTTL = 5 * time.Minute
+
+// maxManifestCacheEntries is the number of manifests kept, the oldest downloads are dropped first
+var maxManifestCacheEntries = 64
+
+// manifestCacheEntry is a manifest downloaded or being downloaded, done is closed once the download is over
+type manifestCacheEntry struct {
+	done         chan struct{}
+	manifest     *PackageManifest
+	err          error
+	downloadedAt time.Time
+}
+
+// manifestCache holds the manifests downloaded by all the packages, by source url
+var manifestCache = struct {
+	sync.Mutex
+	entries map[string]*manifestCacheEntry
+}{entries: make(map[string]*manifestCacheEntry)}
+
+// getCachedManifest returns a copy of the manifest downloaded from sourceURL within manifestCacheTTL, otherwise
+// downloads it with download, which is given the manifest downloaded before so that it can reuse it if it didn't change.
+// Concurrent calls for the same sourceURL wait for a single download. Failed downloads are not kept.
+func getCachedManifest(sourceURL string, download func(previous *PackageManifest) (*PackageManifest, error)) (*PackageManifest, error) {
+	manifestCache.Lock()
+	entry, found := manifestCache.entries[sourceURL]
+	var previous *PackageManifest
+	if found && !isManifestDownloading(entry) && (entry.err != nil || time.Since(entry.downloadedAt) >= manifestCacheTTL) {
+		previous = entry.manifest
+		found = false
+	}
+	if found {
+		manifestCache.Unlock()
+		<-entry.done
+		return copyManifest(entry.manifest), entry.err
+	}
+	delete(manifestCache.entries, sourceURL)
+	evictManifests()
+	entry = &manifestCacheEntry{done: make(chan struct{})}
+	manifestCache.entries[sourceURL] = entry
+	manifestCache.Unlock()
+
+	entry.manifest, entry.err = download(previous)
+	entry.downloadedAt = time.Now()
+	close(entry.done)
+	return copyManifest(entry.manifest), entry.err
+}
+
+// evictManifests drops the downloaded manifests until there is room for one more, the oldest first.
+// Manifests being downloaded are kept. The caller holds the lock of the cache.
+func evictManifests() {
+	for len(manifestCache.entries) >= maxManifestCacheEntries {
+		var oldestURL string
+		var oldest *manifestCacheEntry
+		for sourceURL, entry := range manifestCache.entries {
+			if !isManifestDownloading(entry) && (oldest == nil || entry.downloadedAt.Before(oldest.downloadedAt)) {
+				oldestURL, oldest = sourceURL, entry
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(manifestCache.entries, oldestURL)
+	}
+}
+
+// copyManifest returns a copy of manifest that can be changed without changing the cached manifest
+func copyManifest(manifest *PackageManifest) *PackageManifest {
+	if manifest == nil {
+		return nil
+	}
+	manifestCopy := *manifest
+	if manifest.Versions != nil {
+		manifestCopy.Versions = append([]PackageVersionManifest(nil), manifest.Versions...)
+	}
+	if manifest.Components != nil {
+		manifestCopy.Components = append([]PackageComponent(nil), manifest.Components...)
+	}
+	return &manifestCopy
+}
+
+// isManifestDownloading returns true while the manifest of entry is being downloaded
+func isManifestDownloading(entry *manifestCacheEntry) bool {
+	select {
+	case <-entry.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// resetManifestCache drops all the cached manifests
+func resetManifestCache() {
+	manifestCache.Lock()
+	defer manifestCache.Unlock()
+	manifestCache.entries = make(map[string]*manifestCacheEntry)
+}
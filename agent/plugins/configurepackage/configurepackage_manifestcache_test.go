@@ -0,0 +1,155 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPackageManifest_Cached(t *testing.T) {
+	fileSysStub := &packageRootStub{files: map[string]string{testManifestPath: testManifest}}
+	networkStub := &NetworkDepStub{downloadResultDefault: artifact.DownloadOutput{LocalFilePath: testManifestPath}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+	defer resetManifestCache()
+
+	// two installs referencing the same manifest within the ttl download it once
+	util := &configureUtilImp{packageUrl: "https://amazon-ssm-packages-us-east-1.s3.amazonaws.com/Packages/{PackageName}/windows/amd64"}
+	first, err := util.GetPackageManifest(loggerMock, "PVDriver")
+	assert.NoError(t, err)
+	second, err := util.GetPackageManifest(loggerMock, "PVDriver")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, networkStub.downloadCount)
+	assert.Equal(t, first, second)
+	assert.Equal(t, "1.0.0", second.Versions[0].Version)
+
+	// once the ttl is over the manifest is downloaded again
+	manifestCacheTTLOrig := manifestCacheTTL
+	manifestCacheTTL = 0
+	defer func() { manifestCacheTTL = manifestCacheTTLOrig }()
+	_, err = util.GetPackageManifest(loggerMock, "PVDriver")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, networkStub.downloadCount)
+}
+
+func TestGetPackageManifest_Unchanged(t *testing.T) {
+	fileSysStub := &packageRootStub{files: map[string]string{testManifestPath: testManifest}}
+	networkStub := &NetworkDepStub{downloadResultDefault: artifact.DownloadOutput{LocalFilePath: testManifestPath, IsUpdated: true}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+	defer resetManifestCache()
+	manifestCacheTTLOrig := manifestCacheTTL
+	manifestCacheTTL = 0
+	defer func() { manifestCacheTTL = manifestCacheTTLOrig }()
+
+	util := &configureUtilImp{packageUrl: "https://amazon-ssm-packages-us-east-1.s3.amazonaws.com/Packages/{PackageName}/windows/amd64"}
+	first, err := util.GetPackageManifest(loggerMock, "PVDriver")
+	assert.NoError(t, err)
+
+	// a manifest whose ETag matches isn't parsed again, even if the local copy is gone
+	delete(fileSysStub.files, testManifestPath)
+	networkStub.downloadResultDefault.IsUpdated = false
+	second, err := util.GetPackageManifest(loggerMock, "PVDriver")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, networkStub.downloadCount)
+	assert.Equal(t, first, second)
+
+	// a changed manifest is parsed again
+	fileSysStub.files[testManifestPath] = testManifest
+	networkStub.downloadResultDefault.IsUpdated = true
+	third, err := util.GetPackageManifest(loggerMock, "PVDriver")
+	assert.NoError(t, err)
+	assert.Equal(t, first, third)
+}
+
+func TestGetCachedManifest_ReturnsCopies(t *testing.T) {
+	defer resetManifestCache()
+
+	download := func(previous *PackageManifest) (*PackageManifest, error) {
+		return &PackageManifest{Name: "PVDriver", Versions: []PackageVersionManifest{{Version: "1.0.0"}}}, nil
+	}
+	first, err := getCachedManifest("https://example.com/PVDriver.json", download)
+	assert.NoError(t, err)
+
+	// changing the manifest of one install doesn't change the manifest of the next ones
+	first.Name = "changed"
+	first.Versions[0].Version = "2.0.0"
+	second, err := getCachedManifest("https://example.com/PVDriver.json", download)
+	assert.NoError(t, err)
+	assert.Equal(t, "PVDriver", second.Name)
+	assert.Equal(t, "1.0.0", second.Versions[0].Version)
+}
+
+func TestGetCachedManifest_Bounded(t *testing.T) {
+	defer resetManifestCache()
+	maxManifestCacheEntriesOrig := maxManifestCacheEntries
+	maxManifestCacheEntries = 2
+	defer func() { maxManifestCacheEntries = maxManifestCacheEntriesOrig }()
+
+	downloads := 0
+	download := func(previous *PackageManifest) (*PackageManifest, error) {
+		downloads++
+		return &PackageManifest{Name: "PVDriver"}, nil
+	}
+	for _, sourceURL := range []string{"https://example.com/1.json", "https://example.com/2.json", "https://example.com/3.json"} {
+		_, err := getCachedManifest(sourceURL, download)
+		assert.NoError(t, err)
+	}
+
+	// the oldest manifest is dropped to keep the newest ones
+	assert.Len(t, manifestCache.entries, 2)
+	assert.NotContains(t, manifestCache.entries, "https://example.com/1.json")
+	_, err := getCachedManifest("https://example.com/3.json", download)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, downloads)
+}
+
+func TestGetCachedManifest_CoalescesDownloads(t *testing.T) {
+	defer resetManifestCache()
+
+	// the calls made while a manifest is downloading wait for that download
+	release := make(chan struct{})
+	downloads := 0
+	download := func(previous *PackageManifest) (*PackageManifest, error) {
+		downloads++
+		<-release
+		return &PackageManifest{Name: "PVDriver"}, nil
+	}
+	results := make(chan *PackageManifest)
+	go func() {
+		manifest, _ := getCachedManifest("https://example.com/PVDriver.json", download)
+		results <- manifest
+	}()
+	for isDownloading := false; !isDownloading; {
+		manifestCache.Lock()
+		entry, found := manifestCache.entries["https://example.com/PVDriver.json"]
+		isDownloading = found && isManifestDownloading(entry)
+		manifestCache.Unlock()
+	}
+	go func() {
+		manifest, _ := getCachedManifest("https://example.com/PVDriver.json", download)
+		results <- manifest
+	}()
+	close(release)
+	assert.Equal(t, "PVDriver", (<-results).Name)
+	assert.Equal(t, "PVDriver", (<-results).Name)
+	assert.Equal(t, 1, downloads)
+}
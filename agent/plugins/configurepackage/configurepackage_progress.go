@@ -0,0 +1,46 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_progress contains the progress of package downloads reported in the plugin output
+package configurepackage
+
+import (
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// downloadProgressInterval is the minimum time between two progress lines of a download
+var downloadProgressInterval = 10 * time.Second
+
+// newDownloadProgress returns a DownloadProgress that appends the progress of a download to the output,
+// at most once per downloadProgressInterval and once the download is complete
+func newDownloadProgress(log log.T, output *contracts.PluginOutput) artifact.DownloadProgress {
+	var lastReport time.Time
+	return func(downloaded int64, total int64) {
+		complete := total > 0 && downloaded >= total
+		if !complete && !lastReport.IsZero() && time.Since(lastReport) < downloadProgressInterval {
+			return
+		}
+		lastReport = time.Now()
+		if total > 0 {
+			output.AppendInfof(log, "downloaded %v of %v bytes", downloaded, total)
+		} else {
+			output.AppendInfof(log, "downloaded %v bytes", downloaded)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_proxy contains the proxy configuration of the package and manifest downloads
+package configurepackage
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// getPackageDownloadConfig returns the configuration of the package downloads, the default one when the agent
+// configuration can't be loaded
+var getPackageDownloadConfig = func(log log.T) appconfig.ConfigurePackageCfg {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		log.Errorf("Failed to load the agent configuration, using the default package download configuration: %v", err)
+		return appconfig.DefaultConfig().ConfigurePackage
+	}
+	return config.ConfigurePackage
+}
+
+// packageDownloadProxy returns the proxy of the package downloads, or nil to use the proxy of the environment
+func packageDownloadProxy(log log.T) func(*http.Request) (*url.URL, error) {
+	config := getPackageDownloadConfig(log)
+	if config.ProxyURL == "" {
+		return nil
+	}
+	proxyURL, err := url.Parse(config.ProxyURL)
+	if err != nil {
+		log.Errorf("invalid package download proxy %v, using the proxy of the environment: %v", config.ProxyURL, err)
+		return nil
+	}
+	noProxy := config.NoProxy
+	return func(request *http.Request) (*url.URL, error) {
+		if isNoProxyHost(request.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+}
+
+// isNoProxyHost returns true if the host is one of the no proxy entries or a subdomain of one of them
+func isNoProxyHost(host string, noProxy []string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(entry), "."))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" || host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
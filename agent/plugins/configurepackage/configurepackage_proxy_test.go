@@ -0,0 +1,119 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func setPackageDownloadConfig(config appconfig.ConfigurePackageCfg) (restore func()) {
+	getPackageDownloadConfigOrig := getPackageDownloadConfig
+	getPackageDownloadConfig = func(log.T) appconfig.ConfigurePackageCfg { return config }
+	return func() { getPackageDownloadConfig = getPackageDownloadConfigOrig }
+}
+
+func TestDownload_ThroughConfiguredProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxied request carries the absolute url of the download
+		proxied = append(proxied, r.URL.String())
+		w.Write([]byte("package content"))
+	}))
+	defer proxy.Close()
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{ProxyURL: proxy.URL, NoProxy: []string{"internal.example.com"}})()
+
+	destination, err := ioutil.TempDir("", "proxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(destination)
+
+	output, err := networkDepImp{}.Download(loggerMock, artifact.DownloadInput{
+		SourceURL:            "http://packages.example.com/PVDriver/PVDriver.zip",
+		DestinationDirectory: destination,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://packages.example.com/PVDriver/PVDriver.zip"}, proxied)
+	content, err := ioutil.ReadFile(output.LocalFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "package content", string(content))
+}
+
+func TestPackageDownloadProxy_NoProxy(t *testing.T) {
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{
+		ProxyURL: "http://proxy.corp.example.com:3128",
+		NoProxy:  []string{".internal.example.com", "s3.amazonaws.com"},
+	})()
+
+	proxy := packageDownloadProxy(loggerMock)
+	for host, proxied := range map[string]bool{
+		"packages.example.com":                 true,
+		"internal.example.com":                 false,
+		"repo.internal.example.com":            false,
+		"s3.amazonaws.com":                     false,
+		"amazon-ssm-packages.s3.amazonaws.com": false,
+		"nots3.amazonaws.com":                  true,
+	} {
+		proxyURL, err := proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: host + ":443"}})
+		assert.NoError(t, err)
+		if proxied {
+			assert.Equal(t, "proxy.corp.example.com:3128", proxyURL.Host, host)
+		} else {
+			assert.Nil(t, proxyURL, host)
+		}
+	}
+
+	assert.True(t, isNoProxyHost("anything.example.org", []string{"*"}))
+}
+
+func TestPackageDownloadProxy_Unset(t *testing.T) {
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{})()
+
+	// the downloads keep using the proxy of the environment
+	assert.Nil(t, packageDownloadProxy(loggerMock))
+}
+
+func TestGetPackageDownloadTransport_Shared(t *testing.T) {
+	restore := setPackageDownloadConfig(appconfig.ConfigurePackageCfg{ProxyURL: "http://proxy.corp.example.com:3128"})
+	defer restore()
+
+	transport, err := getPackageDownloadTransport(loggerMock)
+	assert.NoError(t, err)
+	again, err := getPackageDownloadTransport(loggerMock)
+	assert.NoError(t, err)
+	// the downloads and the listings share the transport, which goes through the configured proxy
+	assert.True(t, transport == again)
+	proxyURL, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "amazon-ssm-packages.s3.amazonaws.com"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "proxy.corp.example.com:3128", proxyURL.Host)
+	assert.NotNil(t, transport.TLSClientConfig)
+
+	// a change of the configuration makes a new transport
+	restore()
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{})()
+	other, err := getPackageDownloadTransport(loggerMock)
+	assert.NoError(t, err)
+	assert.False(t, transport == other)
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_reinstall contains the reinstall of a package, to repair a damaged install
+package configurepackage
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/updateutil"
+)
+
+// reinstallPackage uninstalls the installed version of a package, then installs the version of the input, or the
+// installed version again without one. A package that isn't installed is installed. The version is marked as
+// installing before the uninstall, so that a reinstall resumed after the uninstall rebooted installs it again
+// rather than the latest version.
+func reinstallPackage(context context.T,
+	manager configurePackageManager,
+	util configureUtil,
+	instanceContext *updateutil.InstanceContext,
+	input *ConfigurePackagePluginInput,
+	retry downloadRetryPolicy,
+	output *contracts.PluginOutput) {
+	log := context.Log()
+
+	version, installedVersion, versionErr := manager.getVersionToInstall(context, input, util, instanceContext)
+	if versionErr != nil {
+		output.MarkAsFailed(log, fmt.Errorf("unable to determine version to reinstall: %v", versionErr))
+		return
+	}
+	if markedVersion := manager.getMark(context, input.Name); markedVersion != "" {
+		// the uninstall of a previous run rebooted, the version it marked is installed
+		output.AppendInfof(log, "Resuming the reinstall of %v %v", input.Name, markedVersion)
+		version = markedVersion
+		installedVersion = ""
+	} else {
+		if input.Version == "" && installedVersion != "" {
+			version = installedVersion
+		}
+		if err := manager.setMark(context, input.Name, version); err != nil {
+			output.MarkAsFailed(log, fmt.Errorf("unable to mark package installing: %v", err))
+			return
+		}
+		if installedVersion == "" {
+			output.AppendInfof(log, "%v is not installed, installing %v", input.Name, version)
+		}
+	}
+
+	if installedVersion != "" {
+		output.AppendInfof(log, "Uninstalling %v %v", input.Name, installedVersion)
+		result, err := uninstallVersion(context, manager, util, input, installedVersion, retry, output)
+		if err != nil {
+			manager.clearMark(context, input.Name)
+			output.MarkAsFailed(log, fmt.Errorf("failed to uninstall %v %v: %v", input.Name, installedVersion, err))
+			recordPackageHistory(log, input.Name, input.Action, installedVersion, "", output.Status)
+			return
+		}
+		output.AppendInfof(log, "Successfully uninstalled %v %v", input.Name, installedVersion)
+		if result == contracts.ResultStatusSuccessAndReboot || result == contracts.ResultStatusPassedAndReboot {
+			// the mark keeps the version to install once the plugin runs again after the reboot
+			output.MarkAsSuccessWithReboot()
+			return
+		}
+	}
+	defer manager.clearMark(context, input.Name)
+
+	output.AppendInfof(log, "Installing %v %v", input.Name, version)
+	if _, err := manager.ensurePackage(context, util, input.Name, version, retry, output); err != nil {
+		output.MarkAsFailed(log, fmt.Errorf("unable to obtain package: %v", err))
+		recordPackageHistory(log, input.Name, input.Action, installedVersion, version, output.Status)
+		return
+	}
+
+	result, err := manager.runInstallPackage(context, input.Name, version, input.AdditionalArguments, output)
+	if err != nil {
+		output.MarkAsFailed(log, fmt.Errorf("failed to install package: %v", err))
+	} else if result == contracts.ResultStatusSuccessAndReboot || result == contracts.ResultStatusPassedAndReboot {
+		output.AppendInfof(log, "Successfully reinstalled %v %v", input.Name, version)
+		output.MarkAsSuccessWithReboot()
+	} else if result != contracts.ResultStatusSuccess {
+		output.MarkAsFailed(log, fmt.Errorf("install action state was %v and not %v", result, contracts.ResultStatusSuccess))
+	} else {
+		output.AppendInfof(log, "Successfully reinstalled %v %v", input.Name, version)
+		output.MarkAsSucceeded()
+	}
+	recordPackageHistory(log, input.Name, input.Action, installedVersion, version, output.Status)
+}
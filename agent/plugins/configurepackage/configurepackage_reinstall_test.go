@@ -0,0 +1,168 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// packageSteps returns the steps the manager was called for, with the version they acted on
+func packageSteps(managerMock *MockedConfigurePackageManager) (steps []string) {
+	for _, call := range managerMock.Calls {
+		switch call.Method {
+		case "ensurePackage":
+			steps = append(steps, call.Method+" "+call.Arguments.String(2))
+		case "runUninstallPackagePre", "runUninstallPackagePost", "runInstallPackage", "setMark":
+			steps = append(steps, call.Method+" "+call.Arguments.String(1))
+		case "clearMark":
+			steps = append(steps, call.Method)
+		}
+	}
+	return
+}
+
+func TestRunReinstall(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.Action = ReinstallAction
+	pluginInformation.Version = ""
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// the installed version is uninstalled, then installed again rather than upgraded
+	assert.Equal(t, 0, output.ExitCode)
+	assert.Contains(t, output.Stdout, "Successfully uninstalled PVDriver 0.5.6")
+	assert.Contains(t, output.Stdout, "Successfully reinstalled PVDriver 0.5.6")
+	assert.Equal(t, []string{
+		"setMark 0.5.6",
+		"ensurePackage 0.5.6",
+		"runUninstallPackagePre 0.5.6",
+		"runUninstallPackagePost 0.5.6",
+		"ensurePackage 0.5.6",
+		"runInstallPackage 0.5.6",
+		"clearMark",
+	}, packageSteps(managerMock))
+}
+
+func TestRunReinstallUninstallReboot(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.Action = ReinstallAction
+	pluginInformation.Version = ""
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccessAndReboot, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// the mark of the installed version is kept across the reboot
+	assert.Equal(t, contracts.ResultStatusSuccessAndReboot, output.Status)
+	assert.Equal(t, []string{
+		"setMark 0.5.6",
+		"ensurePackage 0.5.6",
+		"runUninstallPackagePre 0.5.6",
+		"runUninstallPackagePost 0.5.6",
+	}, packageSteps(managerMock))
+}
+
+func TestRunReinstallResumedAfterReboot(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.Action = ReinstallAction
+	pluginInformation.Version = ""
+
+	// the uninstalled version is no longer installed, the latest version is 1.0.0
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	managerMock.ExpectedCalls = removeExpectedCall(managerMock.ExpectedCalls, "getMark")
+	managerMock.On("getMark", "PVDriver").Return("0.5.6")
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// the marked version is installed rather than the latest one
+	assert.Equal(t, 0, output.ExitCode)
+	assert.Contains(t, output.Stdout, "Successfully reinstalled PVDriver 0.5.6")
+	assert.Equal(t, []string{
+		"ensurePackage 0.5.6",
+		"runInstallPackage 0.5.6",
+		"clearMark",
+	}, packageSteps(managerMock))
+}
+
+func TestRunReinstallSpecifiedVersion(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.Action = ReinstallAction
+	pluginInformation.Version = "1.0.0"
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	assert.Equal(t, 0, output.ExitCode)
+	managerMock.AssertCalled(t, "runUninstallPackagePost", "PVDriver", "0.5.6", mock.Anything)
+	managerMock.AssertCalled(t, "runInstallPackage", "PVDriver", "1.0.0", mock.Anything)
+}
+
+// removeExpectedCall returns the expected calls without the ones of method
+func removeExpectedCall(calls []*mock.Call, method string) (remaining []*mock.Call) {
+	for _, call := range calls {
+		if call.Method != method {
+			remaining = append(remaining, call)
+		}
+	}
+	return
+}
+
+func TestRunReinstallNotInstalled(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.Action = ReinstallAction
+	pluginInformation.Version = ""
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// without an installed version the reinstall is an install
+	assert.Equal(t, 0, output.ExitCode)
+	assert.Contains(t, output.Stdout, "PVDriver is not installed, installing 1.0.0")
+	assert.Equal(t, []string{
+		"setMark 1.0.0",
+		"ensurePackage 1.0.0",
+		"runInstallPackage 1.0.0",
+		"clearMark",
+	}, packageSteps(managerMock))
+}
+
+func TestRunReinstallUninstallFailed(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.Action = ReinstallAction
+	pluginInformation.Version = ""
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusFailed, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	assert.Equal(t, 1, output.ExitCode)
+	assert.Contains(t, output.Stderr, "failed to uninstall PVDriver 0.5.6")
+	managerMock.AssertNotCalled(t, "runInstallPackage", mock.Anything, mock.Anything, mock.Anything)
+}
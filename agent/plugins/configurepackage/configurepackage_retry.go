@@ -0,0 +1,219 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_retry contains the retry policy for package downloads and the classification of download errors
+// used to decide whether to retry
+package configurepackage
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// downloadErrorCategory describes whether a failed download is worth retrying
+type downloadErrorCategory string
+
+const (
+	// downloadErrorRetriable is a failure that may succeed on a later attempt (DNS, dropped connections, timeouts, 5xx)
+	downloadErrorRetriable downloadErrorCategory = "retriable"
+
+	// downloadErrorTerminal is a failure that will not change by retrying (4xx, invalid certificates, malformed requests)
+	downloadErrorTerminal downloadErrorCategory = "terminal"
+)
+
+const (
+	// maxDownloadRetryLimit is the largest number of download attempts the plugin input may request
+	maxDownloadRetryLimit = 10
+
+	// maxDownloadRetryDelaySeconds is the largest initial backoff the plugin input may request
+	maxDownloadRetryDelaySeconds = 60
+
+	// maxDownloadTimeoutSeconds is the largest download timeout the plugin input may request
+	maxDownloadTimeoutSeconds = 86400
+
+	// maxDownloadRetryBackoff is the longest wait between two download attempts, however many attempts failed
+	maxDownloadRetryBackoff = maxDownloadRetryDelaySeconds * time.Second
+)
+
+// downloadRetryLimit is the default maximum number of download attempts for a retriable failure
+var downloadRetryLimit = 3
+
+// downloadRetryDelay is the default time to wait after the first failed download attempt
+var downloadRetryDelay = 2 * time.Second
+
+// downloadRetryPolicy describes how many times a package download is attempted, how long to wait between attempts and
+// how long an attempt can take, 0 for no limit
+type downloadRetryPolicy struct {
+	limit   int
+	delay   time.Duration
+	timeout time.Duration
+}
+
+// newDownloadRetryPolicy returns the retry policy requested by the plugin input, using the defaults for missing values
+func newDownloadRetryPolicy(log log.T, input *ConfigurePackagePluginInput) downloadRetryPolicy {
+	policy := defaultDownloadRetryPolicy(log)
+	if input.DownloadRetryLimit > 0 {
+		policy.limit = input.DownloadRetryLimit
+	}
+	if input.DownloadRetryDelaySeconds > 0 {
+		policy.delay = time.Duration(input.DownloadRetryDelaySeconds) * time.Second
+	}
+	if input.DownloadTimeoutSeconds > 0 {
+		policy.timeout = time.Duration(input.DownloadTimeoutSeconds) * time.Second
+	}
+	return policy
+}
+
+// defaultDownloadRetryPolicy returns the retry policy used when the plugin input doesn't specify one, with the download
+// timeout of appconfig
+func defaultDownloadRetryPolicy(log log.T) downloadRetryPolicy {
+	return downloadRetryPolicy{
+		limit:   downloadRetryLimit,
+		delay:   downloadRetryDelay,
+		timeout: time.Duration(getPackageDownloadConfig(log).DownloadTimeoutSeconds) * time.Second,
+	}
+}
+
+// validateDownloadRetryInput ensures the retry settings of the plugin input are within the supported range
+func validateDownloadRetryInput(input *ConfigurePackagePluginInput) error {
+	if input.DownloadRetryLimit < 0 || input.DownloadRetryLimit > maxDownloadRetryLimit {
+		return fmt.Errorf("downloadRetryLimit must be between 0 and %v", maxDownloadRetryLimit)
+	}
+	if input.DownloadRetryDelaySeconds < 0 || input.DownloadRetryDelaySeconds > maxDownloadRetryDelaySeconds {
+		return fmt.Errorf("downloadRetryDelaySeconds must be between 0 and %v", maxDownloadRetryDelaySeconds)
+	}
+	if input.DownloadTimeoutSeconds < 0 || input.DownloadTimeoutSeconds > maxDownloadTimeoutSeconds {
+		return fmt.Errorf("downloadTimeoutSeconds must be between 0 and %v", maxDownloadTimeoutSeconds)
+	}
+	return nil
+}
+
+// backoff returns the time to wait after the given failed attempt.
+// The delay doubles with each attempt up to maxDownloadRetryBackoff and up to half of it is added at random so that
+// instances retrying against the same mirror don't do so in lockstep. Past the cap the jitter is taken off the cap.
+func (policy downloadRetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.delay
+	for i := 1; i < attempt && delay < maxDownloadRetryBackoff; i++ {
+		delay *= 2
+	}
+	if jitter := int64(delay / 2); jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+	if delay > maxDownloadRetryBackoff {
+		delay = maxDownloadRetryBackoff - time.Duration(rand.Int63n(int64(maxDownloadRetryBackoff/2)))
+	}
+	return delay
+}
+
+// waitForDownloadRetry waits for delay before the next download attempt, it returns false without waiting for the
+// whole delay if the document is cancelled or the agent shuts down in the meantime
+func waitForDownloadRetry(cancelFlag task.CancelFlag, delay time.Duration) bool {
+	if cancelFlag == nil {
+		time.Sleep(delay)
+		return true
+	}
+	cancelled := make(chan bool, 1)
+	go func() {
+		state := cancelFlag.Wait()
+		cancelled <- state == task.Canceled || state == task.ShutDown
+	}()
+	select {
+	case <-time.After(delay):
+		return true
+	case isCancelled := <-cancelled:
+		return !isCancelled
+	}
+}
+
+// classifyDownloadError can be replaced to change which download errors are retried
+var classifyDownloadError = defaultClassifyDownloadError
+
+// httpStatusCodePattern extracts the status code from errors returned by artifact.Download for http downloads
+var httpStatusCodePattern = regexp.MustCompile(`statuscode:(\d{3})`)
+
+// defaultClassifyDownloadError decides whether a download error is retriable or terminal
+func defaultClassifyDownloadError(err error) downloadErrorCategory {
+	if err == nil {
+		return downloadErrorTerminal
+	}
+
+	// errors from the aws sdk carry the status code of the response
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() != 0 {
+		return classifyStatusCode(reqErr.StatusCode())
+	}
+
+	// errors from the http client are wrapped in url and net errors
+	cause := err
+	if urlErr, ok := cause.(*url.Error); ok {
+		cause = urlErr.Err
+	}
+	if netErr, ok := cause.(net.Error); ok && netErr.Timeout() {
+		return downloadErrorRetriable
+	}
+	if opErr, ok := cause.(*net.OpError); ok {
+		cause = opErr.Err
+	}
+	if syscallErr, ok := cause.(*os.SyscallError); ok {
+		cause = syscallErr.Err
+	}
+	switch cause {
+	case syscall.ECONNREFUSED, syscall.ECONNRESET, io.EOF, io.ErrUnexpectedEOF:
+		return downloadErrorRetriable
+	}
+	if _, ok := cause.(*net.DNSError); ok {
+		return downloadErrorRetriable
+	}
+
+	// errors from a completed http request only report the status code in the message
+	if match := httpStatusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		if statusCode, convErr := strconv.Atoi(match[1]); convErr == nil {
+			return classifyStatusCode(statusCode)
+		}
+	}
+
+	return downloadErrorTerminal
+}
+
+// isNotFoundError returns true if the download failed because the file doesn't exist at its url
+func isNotFoundError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() != 0 {
+		return reqErr.StatusCode() == 404
+	}
+	match := httpStatusCodePattern.FindStringSubmatch(err.Error())
+	return match != nil && match[1] == "404"
+}
+
+// classifyStatusCode treats server errors and throttling as retriable and all other codes as terminal
+func classifyStatusCode(statusCode int) downloadErrorCategory {
+	if statusCode >= 500 || statusCode == 429 {
+		return downloadErrorRetriable
+	}
+	return downloadErrorTerminal
+}
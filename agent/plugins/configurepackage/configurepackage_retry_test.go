@@ -0,0 +1,229 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+)
+
+func dnsError() error {
+	return &url.Error{Op: "Get", URL: "https://s3.amazonaws.com/foo", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "s3.amazonaws.com"}}}
+}
+
+func TestClassifyDownloadError_DNS(t *testing.T) {
+	assert.Equal(t, downloadErrorRetriable, classifyDownloadError(dnsError()))
+}
+
+func TestClassifyDownloadError_Connection(t *testing.T) {
+	reset := &url.Error{Op: "Get", URL: "https://s3.amazonaws.com/foo", Err: &net.OpError{Op: "read", Net: "tcp", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}}
+	assert.Equal(t, downloadErrorRetriable, classifyDownloadError(reset))
+	assert.Equal(t, downloadErrorRetriable, classifyDownloadError(&url.Error{Op: "Get", URL: "https://s3.amazonaws.com/foo", Err: io.ErrUnexpectedEOF}))
+
+	// a certificate that isn't trusted stays untrusted on the next attempt
+	untrusted := &url.Error{Op: "Get", URL: "https://s3.amazonaws.com/foo", Err: x509.UnknownAuthorityError{}}
+	assert.Equal(t, downloadErrorTerminal, classifyDownloadError(untrusted))
+}
+
+func TestClassifyDownloadError_HttpStatus(t *testing.T) {
+	assert.Equal(t, downloadErrorRetriable, classifyDownloadError(errors.New("http request failed. status:503 Service Unavailable statuscode:503")))
+	assert.Equal(t, downloadErrorTerminal, classifyDownloadError(errors.New("http request failed. status:403 Forbidden statuscode:403")))
+	assert.Equal(t, downloadErrorTerminal, classifyDownloadError(errors.New("http request failed. status:404 Not Found statuscode:404")))
+}
+
+func TestClassifyDownloadError_AwsRequestFailure(t *testing.T) {
+	assert.Equal(t, downloadErrorRetriable, classifyDownloadError(awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "unavailable", nil), 503, "")))
+	assert.Equal(t, downloadErrorTerminal, classifyDownloadError(awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), 403, "")))
+}
+
+func TestDownloadPackage_RetriesDNSFailure(t *testing.T) {
+	testDownloadPackageRetry(t, dnsError(), true, 2)
+}
+
+func TestDownloadPackage_Retries503(t *testing.T) {
+	testDownloadPackageRetry(t, errors.New("http request failed. status:503 Service Unavailable statuscode:503"), true, 2)
+}
+
+func TestDownloadPackage_DoesNotRetry403(t *testing.T) {
+	testDownloadPackageRetry(t, errors.New("http request failed. status:403 Forbidden statuscode:403"), false, 1)
+}
+
+func TestDownloadPackage_RetryLimit(t *testing.T) {
+	downloadRetryDelayOrig := downloadRetryDelay
+	downloadRetryDelay = 0
+	defer func() { downloadRetryDelay = downloadRetryDelayOrig }()
+
+	output := contracts.PluginOutput{}
+	manager := createInstance()
+	util := mockConfigureUtility{}
+	networkStub := &NetworkDepStub{downloadErrorDefault: dnsError()}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "", &output)
+
+	assert.Empty(t, fileName)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), string(downloadErrorRetriable))
+	assert.Equal(t, downloadRetryLimit, networkStub.downloadCount)
+	// the plugin can be run again once the transient failure is over
+	assert.True(t, output.Retryable)
+}
+
+func TestDownloadPackage_RetryCancelled(t *testing.T) {
+	downloadRetryDelayOrig := downloadRetryDelay
+	downloadRetryDelay = time.Hour
+	defer func() { downloadRetryDelay = downloadRetryDelayOrig }()
+
+	output := contracts.PluginOutput{}
+	cancelFlag := task.NewChanneledCancelFlag()
+	manager := &configurePackage{cancelFlag: cancelFlag}
+	util := mockConfigureUtility{}
+	networkStub := &NetworkDepStub{downloadErrorDefault: dnsError()}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	// the document is cancelled while the download waits for its next attempt
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancelFlag.Set(task.Canceled)
+	}()
+	fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "", &output)
+
+	assert.Empty(t, fileName)
+	assert.Error(t, err)
+	assert.Equal(t, 1, networkStub.downloadCount)
+	assert.False(t, output.Retryable)
+}
+
+// testDownloadPackageRetry fails the first download with firstErr and succeeds on the second attempt
+func testDownloadPackageRetry(t *testing.T, firstErr error, expectSuccess bool, expectedAttempts int) {
+	downloadRetryDelayOrig := downloadRetryDelay
+	downloadRetryDelay = 0
+	defer func() { downloadRetryDelay = downloadRetryDelayOrig }()
+
+	output := contracts.PluginOutput{}
+	manager := createInstance()
+	util := mockConfigureUtility{}
+	networkStub := &NetworkDepStub{
+		downloadResultSequence: []artifact.DownloadOutput{{}, {LocalFilePath: "packages/PVDriver/9000.0.0/PVDriver.zip"}},
+		downloadErrorSequence:  []error{firstErr, nil},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "", &output)
+
+	assert.Equal(t, expectedAttempts, networkStub.downloadCount)
+	if expectSuccess {
+		assert.NoError(t, err)
+		assert.Equal(t, "packages/PVDriver/9000.0.0/PVDriver.zip", fileName)
+		assert.Contains(t, output.Stdout, string(downloadErrorRetriable))
+	} else {
+		assert.Error(t, err)
+		assert.Empty(t, fileName)
+		assert.Contains(t, err.Error(), string(downloadErrorTerminal))
+		assert.False(t, output.Retryable)
+	}
+}
+
+func TestDownloadRetryPolicy_Defaults(t *testing.T) {
+	policy := newDownloadRetryPolicy(loggerMock, &ConfigurePackagePluginInput{})
+
+	assert.Equal(t, downloadRetryLimit, policy.limit)
+	assert.Equal(t, downloadRetryDelay, policy.delay)
+}
+
+func TestDownloadRetryPolicy_Timeout(t *testing.T) {
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{DownloadTimeoutSeconds: 600})()
+
+	assert.Equal(t, 600*time.Second, newDownloadRetryPolicy(loggerMock, &ConfigurePackagePluginInput{}).timeout)
+	assert.Equal(t, 30*time.Second, newDownloadRetryPolicy(loggerMock, &ConfigurePackagePluginInput{DownloadTimeoutSeconds: 30}).timeout)
+	assert.Error(t, validateDownloadRetryInput(&ConfigurePackagePluginInput{DownloadTimeoutSeconds: maxDownloadTimeoutSeconds + 1}))
+}
+
+func TestDownload_StallsPastDownloadTimeout(t *testing.T) {
+	// the mirror sends the headers then stalls in the middle of the package
+	stalled := make(chan struct{})
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1048576")
+		w.Write([]byte("partial package content"))
+		w.(http.Flusher).Flush()
+		<-stalled
+	}))
+	defer mirror.Close()
+	defer close(stalled)
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{})()
+
+	destination, err := ioutil.TempDir("", "timeout")
+	assert.NoError(t, err)
+	defer os.RemoveAll(destination)
+
+	start := time.Now()
+	_, err = networkDepImp{}.Download(loggerMock, artifact.DownloadInput{
+		SourceURL:            mirror.URL + "/PVDriver/PVDriver.zip",
+		DestinationDirectory: destination,
+		Timeout:              200 * time.Millisecond,
+	})
+
+	assert.Error(t, err)
+	netErr, ok := err.(net.Error)
+	assert.True(t, ok && netErr.Timeout(), "%v is not a timeout", err)
+	assert.True(t, time.Since(start) < 5*time.Second, "the download failed after %v", time.Since(start))
+	assert.Equal(t, downloadErrorRetriable, classifyDownloadError(err))
+}
+
+func TestDownloadRetryPolicy_Backoff(t *testing.T) {
+	policy := newDownloadRetryPolicy(loggerMock, &ConfigurePackagePluginInput{DownloadRetryLimit: 5, DownloadRetryDelaySeconds: 2})
+
+	assert.Equal(t, 5, policy.limit)
+	for attempt, base := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		backoff := policy.backoff(attempt + 1)
+		assert.True(t, backoff >= base, "backoff %v is shorter than %v", backoff, base)
+		assert.True(t, backoff < base+base/2, "backoff %v has more than %v of jitter", backoff, base/2)
+	}
+
+	// the delay stops doubling at the cap, and is still jittered there
+	policy = newDownloadRetryPolicy(loggerMock, &ConfigurePackagePluginInput{DownloadRetryLimit: 10, DownloadRetryDelaySeconds: maxDownloadRetryDelaySeconds})
+	backoffs := map[time.Duration]bool{}
+	for attempt := 1; attempt <= 100; attempt++ {
+		backoff := policy.backoff(attempt)
+		assert.True(t, backoff <= maxDownloadRetryBackoff, "backoff %v is longer than %v", backoff, maxDownloadRetryBackoff)
+		assert.True(t, backoff >= maxDownloadRetryBackoff/2, "backoff %v has more than %v of jitter", backoff, maxDownloadRetryBackoff/2)
+		backoffs[backoff] = true
+	}
+	assert.True(t, len(backoffs) > 1)
+}
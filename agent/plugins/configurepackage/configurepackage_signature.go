@@ -0,0 +1,134 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_signature contains the verification of the detached signatures of package manifests
+package configurepackage
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// ManifestSignatureSuffix is appended to the location of a package manifest to get the location of its detached signature
+const ManifestSignatureSuffix = ".sig"
+
+// manifestVerifier verifies the detached signature of the content of a package manifest
+type manifestVerifier interface {
+	Verify(manifest []byte, signature []byte) error
+}
+
+// publicKeyVerifier verifies RSA (PKCS #1 v1.5 over SHA-256) signatures with a public key, and ed25519 signatures
+// when the agent is built with Go 1.13 or later
+type publicKeyVerifier struct {
+	key crypto.PublicKey
+}
+
+// Verify returns an error if the signature of the manifest wasn't made with the private key matching the verifier's public key
+func (v publicKeyVerifier) Verify(manifest []byte, signature []byte) error {
+	switch key := v.key.(type) {
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(manifest)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	default:
+		if supported, err := verifyEd25519Signature(v.key, manifest, signature); supported {
+			return err
+		}
+		return fmt.Errorf("unsupported public key type %T", v.key)
+	}
+}
+
+// manifestSignatureError is returned when a trust anchor is configured and the manifest signature can't be verified.
+// Unlike other manifest errors, it aborts the action instead of falling back to installing without the manifest.
+type manifestSignatureError struct {
+	err error
+}
+
+func (e *manifestSignatureError) Error() string {
+	return fmt.Sprintf("package manifest signature verification failed: %v", e.err)
+}
+
+// getManifestTrustAnchor returns the path of the public key manifests must be signed with, or an empty string if
+// signatures aren't verified. Without the agent configuration it can't tell whether signatures are required, so it
+// fails with a manifestSignatureError and no package is installed.
+var getManifestTrustAnchor = func(log log.T) (string, error) {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		log.Errorf("Failed to load the agent configuration, packages are not installed without their trust anchor: %v", err)
+		return "", &manifestSignatureError{err: fmt.Errorf("failed to load the trust anchor from the agent configuration, %v", err)}
+	}
+	return config.Plugins.PackageManifestTrustAnchor, nil
+}
+
+// newManifestVerifier can be replaced to verify manifest signatures with a different scheme
+var newManifestVerifier = newPublicKeyVerifier
+
+// newPublicKeyVerifier loads the PEM encoded public key of the trust anchor
+func newPublicKeyVerifier(trustAnchor string) (manifestVerifier, error) {
+	content, err := filesysdep.ReadFile(trustAnchor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust anchor %v, %v", trustAnchor, err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("trust anchor %v is not PEM encoded", trustAnchor)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trust anchor %v, %v", trustAnchor, err)
+	}
+	return publicKeyVerifier{key: key}, nil
+}
+
+// parseTrustedPackageManifest parses the manifest of a package archive, when a trust anchor is configured the manifest
+// must come with a valid detached signature next to it in the archive
+func parseTrustedPackageManifest(log log.T, manifestPath string) (*PackageManifest, error) {
+	trustAnchor, err := getManifestTrustAnchor(log)
+	if err != nil {
+		return nil, err
+	}
+	if trustAnchor != "" {
+		if err := verifyManifestSignature(log, trustAnchor, manifestPath, manifestPath+ManifestSignatureSuffix); err != nil {
+			return nil, err
+		}
+	}
+	return parsePackageManifest(log, manifestPath)
+}
+
+// verifyManifestSignature verifies the manifest file against its signature file with the given trust anchor
+func verifyManifestSignature(log log.T, trustAnchor string, manifestPath string, signaturePath string) error {
+	verifier, err := newManifestVerifier(trustAnchor)
+	if err != nil {
+		return &manifestSignatureError{err: err}
+	}
+	manifest, err := readPackageManifest(log, manifestPath)
+	if err != nil {
+		return &manifestSignatureError{err: err}
+	}
+	signature, err := filesysdep.ReadFile(signaturePath)
+	if err != nil {
+		return &manifestSignatureError{err: err}
+	}
+	if err = verifier.Verify(manifest, signature); err != nil {
+		return &manifestSignatureError{err: err}
+	}
+	return nil
+}
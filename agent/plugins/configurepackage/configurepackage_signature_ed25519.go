@@ -0,0 +1,39 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_signature_ed25519 contains the verification of the ed25519 signatures, which crypto/ed25519 only
+// provides from Go 1.13
+//
+// +build go1.13
+
+package configurepackage
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"fmt"
+)
+
+// verifyEd25519Signature verifies the signature if the key is an ed25519 key, supported is false otherwise
+func verifyEd25519Signature(key crypto.PublicKey, manifest []byte, signature []byte) (supported bool, err error) {
+	ed25519Key, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return false, nil
+	}
+	if !ed25519.Verify(ed25519Key, manifest, signature) {
+		return true, fmt.Errorf("ed25519 verification failure")
+	}
+	return true, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+//
+// +build go1.13
+
+package configurepackage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPackageManifest_ValidEd25519Signature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signature := ed25519.Sign(privateKey, []byte(testManifest))
+
+	manifest, _, err := testGetPackageManifestSigned(t, testTrustAnchor, encodePublicKey(t, publicKey), signature)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "PVDriver", manifest.Name)
+}
+
+func TestPublicKeyVerifier_Ed25519(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	verifier := publicKeyVerifier{key: publicKey}
+
+	assert.NoError(t, verifier.Verify([]byte(testManifest), ed25519.Sign(privateKey, []byte(testManifest))))
+	assert.Error(t, verifier.Verify([]byte(testManifest+" "), ed25519.Sign(privateKey, []byte(testManifest))))
+}
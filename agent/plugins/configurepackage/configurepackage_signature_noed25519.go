@@ -0,0 +1,29 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_signature_noed25519 leaves the ed25519 signatures unsupported before Go 1.13
+//
+// +build !go1.13
+
+package configurepackage
+
+import (
+	"crypto"
+)
+
+// verifyEd25519Signature doesn't support any key, ed25519 keys can't be parsed before Go 1.13
+func verifyEd25519Signature(key crypto.PublicKey, manifest []byte, signature []byte) (supported bool, err error) {
+	return false, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testTrustAnchor   = "trustanchor.pem"
+	testManifestPath  = "packages/PVDriver/PVDriver.json"
+	testSignaturePath = "packages/PVDriver/PVDriver.json.sig"
+	testManifest      = `{"name": "PVDriver", "versions": [{"version": "1.0.0"}]}`
+)
+
+// encodePublicKey returns the PEM encoding of a public key
+func encodePublicKey(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// signRSA returns a new RSA public key and the signature of the content made with its private key
+func signRSA(t *testing.T, content string) (*rsa.PublicKey, []byte) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(content))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &privateKey.PublicKey, signature
+}
+
+// testGetPackageManifestSigned downloads a manifest with the given signature and returns the result of GetPackageManifest
+func testGetPackageManifestSigned(t *testing.T, trustAnchor string, publicKey string, signature []byte) (*PackageManifest, *NetworkDepStub, error) {
+	getManifestTrustAnchorOrig := getManifestTrustAnchor
+	getManifestTrustAnchor = func(log.T) (string, error) { return trustAnchor, nil }
+	defer func() { getManifestTrustAnchor = getManifestTrustAnchorOrig }()
+
+	fileSysStub := &packageRootStub{files: map[string]string{
+		testTrustAnchor:   publicKey,
+		testManifestPath:  testManifest,
+		testSignaturePath: string(signature),
+	}}
+	networkStub := &NetworkDepStub{
+		downloadResultSequence: []artifact.DownloadOutput{{LocalFilePath: testManifestPath}, {LocalFilePath: testSignaturePath}},
+		downloadErrorSequence:  []error{nil, nil},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	util := &configureUtilImp{packageUrl: "https://amazon-ssm-packages-us-east-1.s3.amazonaws.com/Packages/{PackageName}/windows/amd64"}
+	manifest, err := util.GetPackageManifest(loggerMock, "PVDriver")
+	return manifest, networkStub, err
+}
+
+func TestGetPackageManifest_ValidSignature(t *testing.T) {
+	publicKey, signature := signRSA(t, testManifest)
+
+	manifest, networkStub, err := testGetPackageManifestSigned(t, testTrustAnchor, encodePublicKey(t, publicKey), signature)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "PVDriver", manifest.Name)
+	assert.Equal(t, 2, networkStub.downloadCount)
+}
+
+func TestGetPackageManifest_InvalidSignature(t *testing.T) {
+	publicKey, _ := signRSA(t, testManifest)
+	_, signature := signRSA(t, testManifest)
+
+	manifest, _, err := testGetPackageManifestSigned(t, testTrustAnchor, encodePublicKey(t, publicKey), signature)
+
+	assert.Nil(t, manifest)
+	assert.Error(t, err)
+	assert.IsType(t, &manifestSignatureError{}, err)
+}
+
+func TestGetPackageManifest_NoTrustAnchor(t *testing.T) {
+	manifest, networkStub, err := testGetPackageManifestSigned(t, "", "", []byte("not a signature"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "PVDriver", manifest.Name)
+	assert.Equal(t, 1, networkStub.downloadCount)
+}
+
+func TestPublicKeyVerifier_RSA(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	digest := sha256.Sum256([]byte(testManifest))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+	verifier := publicKeyVerifier{key: &privateKey.PublicKey}
+
+	assert.NoError(t, verifier.Verify([]byte(testManifest), signature))
+	assert.Error(t, verifier.Verify([]byte(testManifest+" "), signature))
+}
+
+func TestGetVersionToInstall_InvalidSignature(t *testing.T) {
+	util := mockConfigureUtility{manifestError: &manifestSignatureError{err: errors.New("ed25519 verification failure")}}
+	manager := createInstance()
+
+	version, _, err := manager.getVersionToInstall(contextMock, createStubPluginInputInstall(), &util, createStubInstanceContext())
+
+	assert.Error(t, err)
+	assert.Empty(t, version)
+	assert.IsType(t, &manifestSignatureError{}, err)
+}
+
+func TestGetVersionToInstall_NoManifestWithTrustAnchor(t *testing.T) {
+	getManifestTrustAnchorOrig := getManifestTrustAnchor
+	getManifestTrustAnchor = func(log.T) (string, error) { return testTrustAnchor, nil }
+	defer func() { getManifestTrustAnchor = getManifestTrustAnchorOrig }()
+	util := mockConfigureUtility{manifestError: errors.New("failed to download package manifest")}
+	manager := createInstance()
+
+	version, _, err := manager.getVersionToInstall(contextMock, createStubPluginInputInstall(), &util, createStubInstanceContext())
+
+	assert.Empty(t, version)
+	assert.IsType(t, &manifestSignatureError{}, err)
+}
+
+func TestTrustAnchorUnavailable(t *testing.T) {
+	getManifestTrustAnchorOrig := getManifestTrustAnchor
+	getManifestTrustAnchor = func(log.T) (string, error) {
+		return "", &manifestSignatureError{err: errors.New("failed to load the agent configuration")}
+	}
+	defer func() { getManifestTrustAnchor = getManifestTrustAnchorOrig }()
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &packageRootStub{files: map[string]string{testManifestPath: testManifest}}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	// without the configuration the packages are not trusted, whether a trust anchor is configured or not
+	manifest, err := parseTrustedPackageManifest(loggerMock, testManifestPath)
+	assert.Nil(t, manifest)
+	assert.IsType(t, &manifestSignatureError{}, err)
+
+	util := mockConfigureUtility{manifestError: errors.New("failed to download package manifest")}
+	version, _, err := createInstance().getVersionToInstall(contextMock, createStubPluginInputInstall(), &util, createStubInstanceContext())
+	assert.Empty(t, version)
+	assert.IsType(t, &manifestSignatureError{}, err)
+}
+
+// testParseTrustedPackageManifest parses the manifest of a package archive containing the given signature
+func testParseTrustedPackageManifest(trustAnchor string, publicKey string, signature []byte) (*PackageManifest, error) {
+	getManifestTrustAnchorOrig := getManifestTrustAnchor
+	getManifestTrustAnchor = func(log.T) (string, error) { return trustAnchor, nil }
+	defer func() { getManifestTrustAnchor = getManifestTrustAnchorOrig }()
+
+	files := map[string]string{testTrustAnchor: publicKey, testManifestPath: testManifest}
+	if signature != nil {
+		files[testSignaturePath] = string(signature)
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &packageRootStub{files: files}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	return parseTrustedPackageManifest(loggerMock, testManifestPath)
+}
+
+func TestParseTrustedPackageManifest(t *testing.T) {
+	publicKey, signature := signRSA(t, testManifest)
+	_, otherSignature := signRSA(t, testManifest)
+
+	manifest, err := testParseTrustedPackageManifest(testTrustAnchor, encodePublicKey(t, publicKey), signature)
+	assert.NoError(t, err)
+	assert.Equal(t, "PVDriver", manifest.Name)
+
+	// the manifest of the archive is rejected when it isn't signed with the trust anchor
+	_, err = testParseTrustedPackageManifest(testTrustAnchor, encodePublicKey(t, publicKey), otherSignature)
+	assert.IsType(t, &manifestSignatureError{}, err)
+	_, err = testParseTrustedPackageManifest(testTrustAnchor, encodePublicKey(t, publicKey), nil)
+	assert.IsType(t, &manifestSignatureError{}, err)
+
+	// without a trust anchor the archive doesn't need a signature
+	manifest, err = testParseTrustedPackageManifest("", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "PVDriver", manifest.Name)
+}
@@ -17,14 +17,19 @@ package configurepackage
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
 	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
 	"github.com/aws/amazon-ssm-agent/agent/log"
@@ -73,6 +78,64 @@ func TestRunUpgrade(t *testing.T) {
 	managerMock.AssertCalled(t, "clearMark", "PVDriver")
 }
 
+func TestRunInstallAlreadyInstalled(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "1.0.0", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	assert.Equal(t, 0, output.ExitCode)
+	assert.Contains(t, output.Stdout, "PVDriver 1.0.0 is already installed")
+	managerMock.AssertNotCalled(t, "ensurePackage", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	managerMock.AssertNotCalled(t, "runInstallPackage", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRunInstallAlreadyInstalledForce(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.Force = true
+
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "1.0.0", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// the version is installed again without being uninstalled first
+	assert.Equal(t, 0, output.ExitCode)
+	assert.Contains(t, output.Stdout, "Successfully installed")
+	managerMock.AssertCalled(t, "runInstallPackage", "PVDriver", "1.0.0", mock.Anything, mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePre", mock.Anything, mock.Anything, mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePost", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRunInstallAlreadyInstalledWithoutManifest(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: false}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "1.0.0", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// a damaged install is repaired
+	assert.Equal(t, 0, output.ExitCode)
+	managerMock.AssertCalled(t, "ensurePackage", mock.Anything, "PVDriver", "1.0.0", mock.Anything, mock.Anything)
+	managerMock.AssertCalled(t, "runInstallPackage", "PVDriver", "1.0.0", mock.Anything, mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePre", mock.Anything, mock.Anything, mock.Anything)
+}
+
 func TestRunUpgradeUninstallReboot(t *testing.T) {
 	plugin := &Plugin{}
 	instanceContext := createStubInstanceContext()
@@ -89,6 +152,172 @@ func TestRunUpgradeUninstallReboot(t *testing.T) {
 	managerMock.AssertNotCalled(t, "clearMark")
 }
 
+func TestRunDowngrade(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+
+	managerMock := ConfigPackageSuccessMock("/foo", "0.5.6", "1.0.0", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// the installed version is uninstalled with its own uninstall document
+	assert.Equal(t, output.ExitCode, 0)
+	managerMock.AssertCalled(t, "runUninstallPackagePre", "PVDriver", "1.0.0", mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePre", "PVDriver", "0.5.6", mock.Anything)
+	managerMock.AssertCalled(t, "runInstallPackage", "PVDriver", "0.5.6", mock.Anything, mock.Anything)
+	managerMock.AssertCalled(t, "runUninstallPackagePost", "PVDriver", "1.0.0", mock.Anything, mock.Anything)
+}
+
+func TestRunDowngradeUseTargetUninstall(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+
+	managerMock := ConfigPackageSuccessMock("/foo", "0.5.6", "1.0.0", &PackageManifest{UseTargetUninstall: true}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// the target version migrates the installed version back, the folder of the installed version is still removed
+	assert.Equal(t, output.ExitCode, 0)
+	managerMock.AssertCalled(t, "runUninstallPackagePre", "PVDriver", "0.5.6", mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePre", "PVDriver", "1.0.0", mock.Anything)
+	managerMock.AssertCalled(t, "runInstallPackage", "PVDriver", "0.5.6", mock.Anything, mock.Anything)
+	managerMock.AssertCalled(t, "runUninstallPackagePost", "PVDriver", "1.0.0", mock.Anything, mock.Anything)
+}
+
+func TestRunUpgradeUseTargetUninstall(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{UseTargetUninstall: true}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// the flag only applies to downgrades
+	assert.Equal(t, output.ExitCode, 0)
+	managerMock.AssertCalled(t, "runUninstallPackagePre", "PVDriver", "0.5.6", mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePre", "PVDriver", "1.0.0", mock.Anything)
+}
+
+func TestRunUninstallAllVersions(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputUninstall()
+	pluginInformation.Version = AllVersions
+
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{directoriesResult: []string{"1.0.0", "2.0.0"}}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	managerMock := ConfigPackageSuccessMock("/foo", "", "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	assert.Equal(t, 0, output.ExitCode)
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		managerMock.AssertCalled(t, "runUninstallPackagePre", "PVDriver", version, mock.Anything)
+		managerMock.AssertCalled(t, "runUninstallPackagePost", "PVDriver", version, mock.Anything)
+		assert.Contains(t, output.Stdout, "Successfully uninstalled PVDriver "+version)
+	}
+	managerMock.AssertNotCalled(t, "getVersionToUninstall", mock.Anything, mock.Anything)
+}
+
+func TestRunUninstallAllVersions_PartialFailure(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputUninstallLatest()
+	pluginInformation.Version = AllVersions
+
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{directoriesResult: []string{"1.0.0", "2.0.0"}}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	managerMock := MockedConfigurePackageManager{}
+	managerMock.On("validateInput", mock.Anything, mock.Anything).Return(true, nil)
+	managerMock.On("ensurePackage", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&PackageManifest{}, nil)
+	managerMock.On("runUninstallPackagePre", "PVDriver", "1.0.0", mock.Anything).Return(contracts.ResultStatusFailed, errors.New("uninstall script failed"))
+	managerMock.On("runUninstallPackagePre", "PVDriver", "2.0.0", mock.Anything).Return(contracts.ResultStatusSuccess, nil)
+	managerMock.On("runUninstallPackagePost", "PVDriver", "2.0.0", mock.Anything).Return(contracts.ResultStatusSuccess, nil)
+	output := runConfigurePackage(plugin, contextMock, &managerMock, instanceContext, pluginInformation)
+
+	// the failure of the first version doesn't stop the uninstall of the second one
+	assert.Equal(t, 1, output.ExitCode)
+	managerMock.AssertCalled(t, "runUninstallPackagePost", "PVDriver", "2.0.0", mock.Anything)
+	assert.Contains(t, output.Stderr, "failed to uninstall PVDriver 1.0.0: uninstall script failed")
+	assert.Contains(t, output.Stderr, "failed to uninstall 1 of 2 versions of PVDriver")
+	assert.Contains(t, output.Stdout, "Successfully uninstalled PVDriver 2.0.0")
+}
+
+func TestRunUninstallWithoutVersion(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputUninstallLatest()
+
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{directoriesResult: []string{"1.0.0", "2.0.0"}}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	managerMock := ConfigPackageSuccessMock("/foo", "2.0.0", "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// only the installed version is uninstalled
+	assert.Equal(t, 0, output.ExitCode)
+	managerMock.AssertCalled(t, "getVersionToUninstall", mock.Anything, mock.Anything, mock.Anything)
+	managerMock.AssertCalled(t, "runUninstallPackagePre", "PVDriver", "2.0.0", mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePre", "PVDriver", "1.0.0", mock.Anything)
+}
+
+func TestRunParallelIdenticalInstallsJoin(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.JoinInProgress = true
+
+	managerMockFirst := ConfigPackageSuccessMock("/foo", "Wait9000.0.0", "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	managerMockSecond := ConfigPackageSuccessMock("/foo", "9000.0.0", "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+
+	var outputFirst contracts.PluginOutput
+	var outputSecond contracts.PluginOutput
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		outputFirst = runConfigurePackage(plugin, contextMock, managerMockFirst, instanceContext, pluginInformation)
+	}()
+	// wait until first call is at getVersionToInstall
+	_ = <-managerMockFirst.waitChan
+	// start second call, which waits for the first one
+	go func() {
+		defer wg.Done()
+		outputSecond = runConfigurePackage(plugin, contextMock, managerMockSecond, instanceContext, pluginInformation)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// allow first call to continue
+	managerMockFirst.waitChan <- true
+	// wait until both calls are complete
+	wg.Wait()
+
+	assert.Equal(t, 0, outputFirst.ExitCode)
+	assert.Equal(t, 0, outputSecond.ExitCode)
+	assert.Equal(t, outputFirst, outputSecond)
+	managerMockFirst.AssertNumberOfCalls(t, "runInstallPackage", 1)
+	managerMockSecond.AssertNotCalled(t, "runInstallPackage", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRunParallelDifferentVersionsDoNotJoin(t *testing.T) {
+	// lock the package for the install of another version
+	_, _, err := lockOrJoinPackage("PVDriver", "1.0.0", InstallAction, true)
+	assert.NoError(t, err)
+	defer unlockPackage("PVDriver")
+
+	plugin := &Plugin{}
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.JoinInProgress = true
+	managerMock := ConfigPackageSuccessMock("/foo", "9000.0.0", "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, createStubInstanceContext(), pluginInformation)
+
+	assert.Equal(t, 1, output.ExitCode)
+	assert.True(t, strings.Contains(output.Stderr, `Package "PVDriver" is already in the process of action "Install"`))
+}
+
 func TestRunParallelSamePackage(t *testing.T) {
 	plugin := &Plugin{}
 	instanceContext := createStubInstanceContext()
@@ -119,6 +348,116 @@ func TestRunParallelSamePackage(t *testing.T) {
 	assert.True(t, strings.Contains(outputSecond.Stderr, `Package "PVDriver" is already in the process of action "Install"`))
 }
 
+func TestRunUpgradeSideBySide(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.AllowSideBySide = true
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// the installed version is left in place
+	assert.Equal(t, output.ExitCode, 0)
+	assert.Contains(t, output.Stdout, "Successfully installed")
+	managerMock.AssertCalled(t, "runInstallPackage", "PVDriver", "1.0.0", mock.Anything, mock.Anything)
+	managerMock.AssertNotCalled(t, "setMark", mock.Anything, mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePre", mock.Anything, mock.Anything, mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePost", mock.Anything, mock.Anything, mock.Anything)
+	managerMock.AssertNotCalled(t, "clearMark", mock.Anything)
+	// only the installed version is marked
+	managerMock.AssertCalled(t, "setVersionMark", "PVDriver", "1.0.0")
+	managerMock.AssertCalled(t, "clearVersionMark", "PVDriver", "1.0.0")
+}
+
+// runParallelSideBySide installs two versions side-by-side, the second starting while the first is in progress
+func runParallelSideBySide(firstVersion string, secondVersion string) (outputFirst contracts.PluginOutput, outputSecond contracts.PluginOutput) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	inputFirst := createStubPluginInputInstall()
+	inputFirst.Version = firstVersion
+	inputFirst.AllowSideBySide = true
+	inputSecond := createStubPluginInputInstall()
+	inputSecond.Version = secondVersion
+	inputSecond.AllowSideBySide = true
+
+	managerMockFirst := ConfigPackageSuccessMock("/foo", "Wait"+firstVersion, "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	managerMockSecond := ConfigPackageSuccessMock("/foo", secondVersion, "", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		outputFirst = runConfigurePackage(plugin, contextMock, managerMockFirst, instanceContext, inputFirst)
+	}()
+	// wait until first call is at getVersionToInstall
+	_ = <-managerMockFirst.waitChan
+	outputSecond = runConfigurePackage(plugin, contextMock, managerMockSecond, instanceContext, inputSecond)
+	// after second call completes, allow first call to continue
+	managerMockFirst.waitChan <- true
+	wg.Wait()
+	return
+}
+
+func TestRunParallelSideBySideSameVersion(t *testing.T) {
+	outputFirst, outputSecond := runParallelSideBySide("1.0.0", "1.0.0")
+
+	assert.Equal(t, outputFirst.ExitCode, 0)
+	assert.Equal(t, outputSecond.ExitCode, 1)
+	assert.True(t, strings.Contains(outputSecond.Stderr, `Package "PVDriver" version "1.0.0" is already in the process of action "Install"`))
+}
+
+func TestRunParallelSideBySideDifferentVersions(t *testing.T) {
+	outputFirst, outputSecond := runParallelSideBySide("1.0.0", "2.0.0")
+
+	assert.Equal(t, outputFirst.ExitCode, 0)
+	assert.Equal(t, outputSecond.ExitCode, 0)
+	assert.Contains(t, outputFirst.Stdout, "Successfully installed PVDriver 1.0.0")
+	assert.Contains(t, outputSecond.Stdout, "Successfully installed PVDriver 2.0.0")
+}
+
+func TestValidateInput_SideBySideRequiresVersion(t *testing.T) {
+	manager := createInstance()
+	input := createStubPluginInputInstallLatest()
+	input.AllowSideBySide = true
+
+	valid, err := manager.validateInput(contextMock, input)
+
+	assert.False(t, valid)
+	assert.Error(t, err)
+}
+
+func TestValidateInput_DownloadRetryLimit(t *testing.T) {
+	manager := createInstance()
+	input := createStubPluginInputInstall()
+	input.DownloadRetryLimit = maxDownloadRetryLimit + 1
+
+	valid, err := manager.validateInput(contextMock, input)
+
+	assert.False(t, valid)
+	assert.Error(t, err)
+}
+
+func TestValidateInput_AdditionalArguments(t *testing.T) {
+	manager := createInstance()
+	input := createStubPluginInputInstall()
+
+	input.AdditionalArguments = map[string]string{"license_key": "ABCD-1234"}
+	valid, err := manager.validateInput(contextMock, input)
+	assert.True(t, valid)
+	assert.NoError(t, err)
+
+	input.AdditionalArguments = map[string]string{"license-key": "ABCD-1234"}
+	valid, err = manager.validateInput(contextMock, input)
+	assert.False(t, valid)
+	assert.Error(t, err)
+
+	input.AdditionalArguments = map[string]string{"key": "a", "KEY": "b"}
+	valid, err = manager.validateInput(contextMock, input)
+	assert.False(t, valid)
+	assert.Error(t, err)
+}
+
 func TestExecute(t *testing.T) {
 	pluginInformation := createStubPluginInputInstall()
 	config := contracts.Configuration{}
@@ -178,11 +517,47 @@ func TestInstallPackage(t *testing.T) {
 	_, err := manager.runInstallPackage(contextMock,
 		pluginInformation.Name,
 		pluginInformation.Version,
+		nil,
 		output)
 
 	assert.NoError(t, err)
 }
 
+func TestInstallPackage_AdditionalArguments(t *testing.T) {
+	pluginInformation := createStubPluginInputInstall()
+
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	execStub := &ExecDepStub{pluginInput: &model.PluginState{}, pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true}, networkDepStub: &NetworkDepStub{}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	_, err := manager.runInstallPackage(contextMock,
+		pluginInformation.Name,
+		pluginInformation.Version,
+		map[string]string{"licenseKey": "ABCD-1234", "edition": "enterprise"},
+		output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"SSM_PKG_ARG_LICENSEKEY": "ABCD-1234", "SSM_PKG_ARG_EDITION": "enterprise"}}, execStub.executedEnvironments)
+}
+
+func TestWithArgumentEnvironment(t *testing.T) {
+	plugins := []model.PluginState{
+		{Configuration: contracts.Configuration{Environment: map[string]string{"DEPLOYMENT": "blue"}}},
+		{},
+	}
+
+	plugins = withArgumentEnvironment(plugins, map[string]string{"SSM_PKG_ARG_EDITION": "enterprise"})
+
+	assert.Equal(t, map[string]string{"DEPLOYMENT": "blue", "SSM_PKG_ARG_EDITION": "enterprise"}, plugins[0].Configuration.Environment)
+	assert.Equal(t, map[string]string{"SSM_PKG_ARG_EDITION": "enterprise"}, plugins[1].Configuration.Environment)
+	_, set := os.LookupEnv("SSM_PKG_ARG_EDITION")
+	assert.False(t, set)
+}
+
 func TestUninstallPackage(t *testing.T) {
 	manager := createInstance()
 	pluginInformation := createStubPluginInputUninstall()
@@ -196,6 +571,7 @@ func TestUninstallPackage(t *testing.T) {
 	_, errPre := manager.runUninstallPackagePre(contextMock,
 		pluginInformation.Name,
 		pluginInformation.Version,
+		nil,
 		output)
 
 	assert.NoError(t, errPre)
@@ -208,6 +584,140 @@ func TestUninstallPackage(t *testing.T) {
 	assert.NoError(t, errPost)
 }
 
+func TestGetVersionToInstall_MultiArchAmd64(t *testing.T) {
+	manifest := loadManifestFromFile(t, "testdata/sampleManifestMultiArch.json")
+	input := createStubPluginInputInstallLatest()
+	util := mockConfigureUtility{manifest: manifest, currentVersion: "1.0.0"}
+	manager := createInstance()
+
+	version, installedVersion, err := manager.getVersionToInstall(contextMock, input, &util, createStubInstanceContext())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2.0.0", version)
+	assert.Equal(t, "1.0.0", installedVersion)
+}
+
+func TestGetVersionToInstall_MultiArchArm64(t *testing.T) {
+	manifest := loadManifestFromFile(t, "testdata/sampleManifestMultiArch.json")
+	input := createStubPluginInputInstallLatest()
+	util := mockConfigureUtility{manifest: manifest}
+	instanceContext := createStubInstanceContext()
+	instanceContext.Arch = "arm64"
+	manager := createInstance()
+
+	version, _, err := manager.getVersionToInstall(contextMock, input, &util, instanceContext)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2.1.0", version)
+}
+
+func TestGetVersionToInstall_MultiArchRequestedVersionIncompatible(t *testing.T) {
+	manifest := loadManifestFromFile(t, "testdata/sampleManifestMultiArch.json")
+	input := createStubPluginInputInstallLatest()
+	input.Version = "2.0.0"
+	util := mockConfigureUtility{manifest: manifest}
+	instanceContext := createStubInstanceContext()
+	instanceContext.Arch = "arm64"
+	manager := createInstance()
+
+	version, _, err := manager.getVersionToInstall(contextMock, input, &util, instanceContext)
+
+	assert.Error(t, err)
+	assert.Empty(t, version)
+	assert.Contains(t, err.Error(), "windows")
+	assert.Contains(t, err.Error(), "arm64")
+}
+
+func TestGetVersionToInstall_InstalledVersionSkipsManifest(t *testing.T) {
+	input := createStubPluginInputInstallLatest()
+	input.Version = "1.0.0"
+	// the manifest of the package, which no longer lists the version for the instance, is not used for the version
+	// that is installed
+	manifest := &PackageManifest{Name: "PVDriver", Versions: []PackageVersionManifest{{Version: "1.0.0", Architecture: "arm64"}}}
+	util := mockConfigureUtility{manifest: manifest, currentVersion: "1.0.0"}
+	manager := createInstance()
+
+	version, installedVersion, err := manager.getVersionToInstall(contextMock, input, &util, createStubInstanceContext())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0.0", version)
+	assert.Equal(t, "1.0.0", installedVersion)
+}
+
+func TestGetVersionToInstall_MultiArchNoneCompatible(t *testing.T) {
+	manifest := &PackageManifest{
+		Name:     "PVDriver",
+		Versions: []PackageVersionManifest{{Version: "2.0.0", Platform: "windows", Architecture: "amd64"}},
+	}
+	input := createStubPluginInputInstallLatest()
+	util := mockConfigureUtility{manifest: manifest}
+	instanceContext := createStubInstanceContext()
+	instanceContext.Arch = "arm64"
+	manager := createInstance()
+
+	version, _, err := manager.getVersionToInstall(contextMock, input, &util, instanceContext)
+
+	assert.Error(t, err)
+	assert.Empty(t, version)
+	assert.Contains(t, err.Error(), "no version of package PVDriver is compatible with platform windows and architecture arm64")
+}
+
+func TestGetVersionToInstall_NoManifest(t *testing.T) {
+	input := createStubPluginInputInstallLatest()
+	util := mockConfigureUtility{manifestError: errors.New("404"), latestVersion: "5.0.0"}
+	manager := createInstance()
+
+	version, _, err := manager.getVersionToInstall(contextMock, input, &util, createStubInstanceContext())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "5.0.0", version)
+}
+
+func TestGetVersionToInstall_VersionRange(t *testing.T) {
+	manifest := loadManifestFromFile(t, "testdata/sampleManifestMultiArch.json")
+	input := createStubPluginInputInstallLatest()
+	input.Version = ">=1.0, <2.0"
+	util := mockConfigureUtility{manifest: manifest}
+	manager := createInstance()
+
+	version, _, err := manager.getVersionToInstall(contextMock, input, &util, createStubInstanceContext())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0.0", version)
+
+	input.Version = ">1.0.0,<=2.1"
+	version, _, err = manager.getVersionToInstall(contextMock, input, &util, createStubInstanceContext())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2.0.0", version)
+}
+
+func TestGetVersionToInstall_VersionRangeUnsatisfiable(t *testing.T) {
+	manifest := loadManifestFromFile(t, "testdata/sampleManifestMultiArch.json")
+	input := createStubPluginInputInstallLatest()
+	input.Version = ">=3.0"
+	util := mockConfigureUtility{manifest: manifest}
+	manager := createInstance()
+
+	version, _, err := manager.getVersionToInstall(contextMock, input, &util, createStubInstanceContext())
+
+	assert.Error(t, err)
+	assert.Empty(t, version)
+	assert.Contains(t, err.Error(), "no version of package PVDriver satisfying >=3.0")
+}
+
+func TestGetVersionToInstall_VersionRangeNoManifest(t *testing.T) {
+	input := createStubPluginInputInstallLatest()
+	input.Version = "<5.0"
+	util := mockConfigureUtility{manifestError: errors.New("404"), latestVersion: "5.0.0"}
+	manager := createInstance()
+
+	version, _, err := manager.getVersionToInstall(contextMock, input, &util, createStubInstanceContext())
+
+	assert.Error(t, err)
+	assert.Empty(t, version)
+}
+
 // TO DO: Uninstall test for exe command
 
 func TestValidateInput(t *testing.T) {
@@ -315,6 +825,29 @@ func TestValidateInput_Version(t *testing.T) {
 	assert.Contains(t, err.Error(), "invalid version")
 }
 
+func TestValidateInput_VersionRange(t *testing.T) {
+	input := ConfigurePackagePluginInput{Name: "PVDriver", Action: "Install", Version: ">=1.2,<2.0"}
+	manager := createInstance()
+
+	result, err := manager.validateInput(contextMock, &input)
+	assert.True(t, result)
+	assert.NoError(t, err)
+
+	for _, malformed := range []string{"=>1.2", ">=1.x", ">=1.2,", "<1.2.3.4"} {
+		input.Version = malformed
+		result, err = manager.validateInput(contextMock, &input)
+		assert.False(t, result, malformed)
+		assert.Error(t, err, malformed)
+		assert.Contains(t, err.Error(), "invalid version range", malformed)
+	}
+
+	input.Version = ">=1.2"
+	input.Action = "Uninstall"
+	result, err = manager.validateInput(contextMock, &input)
+	assert.False(t, result)
+	assert.Error(t, err)
+}
+
 func TestValidateInput_EmptyVersionWithInstall(t *testing.T) {
 	input := ConfigurePackagePluginInput{}
 
@@ -330,6 +863,25 @@ func TestValidateInput_EmptyVersionWithInstall(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestValidateInput_AllVersions(t *testing.T) {
+	manager := createInstance()
+
+	input := ConfigurePackagePluginInput{Name: "PVDriver", Action: UninstallAction, Version: AllVersions}
+	result, err := manager.validateInput(contextMock, &input)
+	assert.True(t, result)
+	assert.NoError(t, err)
+
+	input = ConfigurePackagePluginInput{Name: "PVDriver", Action: InstallAction, Version: AllVersions}
+	result, err = manager.validateInput(contextMock, &input)
+	assert.False(t, result)
+	assert.Error(t, err)
+
+	input = ConfigurePackagePluginInput{Name: "PVDriver", Action: UninstallAction, Version: AllVersions, AllowSideBySide: true}
+	result, err = manager.validateInput(contextMock, &input)
+	assert.False(t, result)
+	assert.Error(t, err)
+}
+
 func TestValidateInput_EmptyVersionWithUninstall(t *testing.T) {
 	input := ConfigurePackagePluginInput{}
 
@@ -359,14 +911,84 @@ func TestDownloadPackage(t *testing.T) {
 	stubs.Set()
 	defer stubs.Clear()
 
-	fileName, err := manager.downloadPackage(contextMock, &util, pluginInformation.Name, pluginInformation.Version, &output)
+	fileName, err := manager.downloadPackage(contextMock, &util, pluginInformation.Name, pluginInformation.Version, defaultDownloadRetryPolicy(loggerMock), "", &output)
 
 	assert.Equal(t, "packages/PVDriver/9000.0.0/PVDriver.zip", fileName)
 	assert.NoError(t, err)
 }
 
+func TestDownloadPackage_Destination(t *testing.T) {
+	pluginInformation := createStubPluginInputInstall()
+
+	output := contracts.PluginOutput{}
+	manager := &configurePackage{Configuration: contracts.Configuration{
+		OrchestrationDirectory: filepath.Join("orchestration", "command", "awsconfigurePackage")}}
+	util := mockConfigureUtility{}
+
+	result := artifact.DownloadOutput{}
+	result.LocalFilePath = "orchestration/command/awsconfigurePackage/downloads/PVDriver.zip"
+
+	networkStub := &NetworkDepStub{downloadResultDefault: result}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	_, err := manager.downloadPackage(contextMock, &util, pluginInformation.Name, pluginInformation.Version, defaultDownloadRetryPolicy(loggerMock), manager.downloadDestination(), &output)
+	assert.NoError(t, err)
+
+	_, err = manager.downloadPackage(contextMock, &util, pluginInformation.Name, pluginInformation.Version, defaultDownloadRetryPolicy(loggerMock), "", &output)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		filepath.Join("orchestration", "command", "awsconfigurePackage", downloadFolderName),
+		appconfig.DownloadRoot,
+	}, networkStub.downloadDestinations)
+}
+
+func TestDownloadPackage_Progress(t *testing.T) {
+	downloadProgressIntervalOrig := downloadProgressInterval
+	downloadProgressInterval = time.Hour
+	defer func() { downloadProgressInterval = downloadProgressIntervalOrig }()
+
+	pluginInformation := createStubPluginInputInstall()
+	manager := createInstance()
+	util := mockConfigureUtility{}
+
+	result := artifact.DownloadOutput{}
+	result.LocalFilePath = "packages/PVDriver/9000.0.0/PVDriver.zip"
+
+	// intermediate progress is throttled, the first and the last lines are reported
+	output := contracts.PluginOutput{}
+	networkStub := &NetworkDepStub{downloadResultDefault: result, progressSequence: []int64{100, 200, 300}, progressTotal: 300}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+	stubs.Set()
+	_, err := manager.downloadPackage(contextMock, &util, pluginInformation.Name, pluginInformation.Version, defaultDownloadRetryPolicy(loggerMock), "", &output)
+	stubs.Clear()
+
+	assert.NoError(t, err)
+	assert.Contains(t, output.Stdout, "downloaded 100 of 300 bytes")
+	assert.NotContains(t, output.Stdout, "downloaded 200 of 300 bytes")
+	assert.Contains(t, output.Stdout, "downloaded 300 of 300 bytes")
+
+	// without a size only the bytes downloaded are reported
+	output = contracts.PluginOutput{}
+	networkStub = &NetworkDepStub{downloadResultDefault: result, progressSequence: []int64{100}, progressTotal: -1}
+	stubs = &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+	_, err = manager.downloadPackage(contextMock, &util, pluginInformation.Name, pluginInformation.Version, defaultDownloadRetryPolicy(loggerMock), "", &output)
+
+	assert.NoError(t, err)
+	assert.Contains(t, output.Stdout, "downloaded 100 bytes")
+}
+
 func TestDownloadPackage_Failed(t *testing.T) {
+	downloadRetryDelayOrig := downloadRetryDelay
+	downloadRetryDelay = 0
+	defer func() { downloadRetryDelay = downloadRetryDelayOrig }()
+
 	pluginInformation := createStubPluginInputInstall()
+	pluginInformation.DownloadRetryLimit = 4
 
 	output := contracts.PluginOutput{}
 	manager := createInstance()
@@ -376,16 +998,161 @@ func TestDownloadPackage_Failed(t *testing.T) {
 	result := artifact.DownloadOutput{}
 	result.LocalFilePath = ""
 
-	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: &NetworkDepStub{downloadResultDefault: result, downloadErrorDefault: errors.New("404")}}
+	networkStub := &NetworkDepStub{downloadResultDefault: result, downloadErrorDefault: errors.New("http request failed. status:503 Service Unavailable statuscode:503")}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
 	stubs.Set()
 	defer stubs.Clear()
 
-	fileName, err := manager.downloadPackage(contextMock, &util, pluginInformation.Name, pluginInformation.Version, &output)
+	fileName, err := manager.downloadPackage(contextMock, &util, pluginInformation.Name, pluginInformation.Version, newDownloadRetryPolicy(loggerMock, pluginInformation), "", &output)
 
 	assert.Empty(t, fileName)
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "failed to download installation package reliably")
-	assert.Contains(t, err.Error(), "404")
+	assert.Contains(t, err.Error(), "failed to download installation package reliably after 4 attempt(s)")
+	assert.Contains(t, err.Error(), "503")
+	assert.Equal(t, 4, networkStub.downloadCount)
+}
+
+func TestDownloadPackage_InsufficientDiskSpace(t *testing.T) {
+	output := contracts.PluginOutput{}
+	manager := createInstance()
+	util := mockConfigureUtility{
+		manifest: &PackageManifest{Versions: []PackageVersionManifest{{Version: "9000.0.0", ArchiveSize: 2048}}},
+	}
+
+	networkStub := &NetworkDepStub{downloadResultDefault: artifact.DownloadOutput{LocalFilePath: "packages/PVDriver/9000.0.0/PVDriver.zip"}}
+	fileSysStub := &FileSysDepStub{freeDiskSpaceResult: 1024}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: fileSysStub, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "orchestration/downloads", &output)
+
+	assert.Empty(t, fileName)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "insufficient disk space")
+	assert.Equal(t, 0, networkStub.downloadCount)
+	// the space is checked on the volume the archive is downloaded to
+	assert.Equal(t, "orchestration/downloads", fileSysStub.freeDiskSpacePath)
+}
+
+func TestDownloadPackage_SufficientDiskSpace(t *testing.T) {
+	output := contracts.PluginOutput{}
+	manager := createInstance()
+	util := mockConfigureUtility{
+		manifest: &PackageManifest{Versions: []PackageVersionManifest{{Version: "9000.0.0", ArchiveSize: 2048}}},
+	}
+
+	networkStub := &NetworkDepStub{downloadResultDefault: artifact.DownloadOutput{LocalFilePath: "packages/PVDriver/9000.0.0/PVDriver.zip"}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{freeDiskSpaceResult: 4096}, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "", &output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "packages/PVDriver/9000.0.0/PVDriver.zip", fileName)
+	assert.Equal(t, 1, networkStub.downloadCount)
+}
+
+func TestDownloadPackage_ArchiveFile(t *testing.T) {
+	output := contracts.PluginOutput{}
+	manager := createInstance()
+	util := mockConfigureUtility{
+		s3Location:         "https://s3.amazonaws.com/bucket/PVDriver/9000.0.0/PVDriver.zip",
+		s3FallbackLocation: "https://s3.amazonaws.com/global/PVDriver/9000.0.0/PVDriver.zip",
+		manifest:           &PackageManifest{Versions: []PackageVersionManifest{{Version: "9000.0.0", File: `windows\PVDriver-9000.zip`}}},
+	}
+
+	notFoundErr := errors.New("http request failed. status:404 Not Found statuscode:404")
+	networkStub := &NetworkDepStub{
+		downloadResultSequence: []artifact.DownloadOutput{{}, {LocalFilePath: "downloads/PVDriver-9000.zip"}},
+		downloadErrorSequence:  []error{notFoundErr, nil},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "", &output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "downloads/PVDriver-9000.zip", fileName)
+	assert.Equal(t, []string{
+		"https://s3.amazonaws.com/bucket/PVDriver/9000.0.0/windows/PVDriver-9000.zip",
+		"https://s3.amazonaws.com/global/PVDriver/9000.0.0/windows/PVDriver-9000.zip",
+	}, networkStub.downloadSources)
+}
+
+func TestDownloadPackage_ArchiveFileOutsideVersion(t *testing.T) {
+	for _, file := range []string{"../PVDriver.zip", "/etc/PVDriver.zip", `C:\PVDriver.zip`} {
+		output := contracts.PluginOutput{}
+		manager := createInstance()
+		util := mockConfigureUtility{
+			s3Location: "https://s3.amazonaws.com/bucket/PVDriver/9000.0.0/PVDriver.zip",
+			manifest:   &PackageManifest{Versions: []PackageVersionManifest{{Version: "9000.0.0", File: file}}},
+		}
+
+		networkStub := &NetworkDepStub{downloadResultDefault: artifact.DownloadOutput{LocalFilePath: "downloads/PVDriver.zip"}}
+		stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+		stubs.Set()
+
+		fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "", &output)
+		stubs.Clear()
+
+		assert.Empty(t, fileName, file)
+		assert.Error(t, err, file)
+		assert.Contains(t, err.Error(), "invalid archive file", file)
+		assert.Equal(t, 0, networkStub.downloadCount, file)
+	}
+}
+
+func TestDownloadPackage_RegionalSource(t *testing.T) {
+	notFoundErr := errors.New("http request failed. status:404 Not Found statuscode:404")
+	localFilePath := "packages/PVDriver/9000.0.0/PVDriver.zip"
+	for _, tst := range []struct {
+		region          string
+		results         []artifact.DownloadOutput
+		errors          []error
+		expectedSources []string
+		expectSuccess   bool
+	}{
+		// found in the bucket of the region
+		{"eu-west-1", []artifact.DownloadOutput{{LocalFilePath: localFilePath}}, []error{nil},
+			[]string{"https://s3.eu-west-1.amazonaws.com/amazon-ssm-packages-eu-west-1"}, true},
+		// not found in the bucket of the region, found in the global bucket
+		{"us-west-2", []artifact.DownloadOutput{{}, {LocalFilePath: localFilePath}}, []error{notFoundErr, nil},
+			[]string{"https://s3.us-west-2.amazonaws.com/amazon-ssm-packages-us-west-2", "https://s3.amazonaws.com/amazon-ssm-packages-us-east-1"}, true},
+		// no global bucket to fall back to
+		{"cn-north-1", []artifact.DownloadOutput{{}}, []error{notFoundErr},
+			[]string{"https://s3.cn-north-1.amazonaws.com.cn/amazon-ssm-packages-cn-north-1"}, false},
+	} {
+		instanceContext := createStubInstanceContext()
+		instanceContext.Region = tst.region
+		regionalUtil := NewUtil(instanceContext, "")
+		util := mockConfigureUtility{
+			s3Location:         regionalUtil.GetS3Location("PVDriver", "9000.0.0"),
+			s3FallbackLocation: regionalUtil.GetS3FallbackLocation("PVDriver", "9000.0.0"),
+		}
+		networkStub := &NetworkDepStub{downloadResultSequence: tst.results, downloadErrorSequence: tst.errors}
+		stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{}, networkDepStub: networkStub}
+		stubs.Set()
+
+		output := contracts.PluginOutput{}
+		manager := createInstance()
+		fileName, err := manager.downloadPackage(contextMock, &util, "PVDriver", "9000.0.0", defaultDownloadRetryPolicy(loggerMock), "", &output)
+		stubs.Clear()
+
+		if assert.Len(t, networkStub.downloadSources, len(tst.expectedSources), tst.region) {
+			for i, expectedSource := range tst.expectedSources {
+				assert.True(t, strings.HasPrefix(networkStub.downloadSources[i], expectedSource+"/Packages/PVDriver/"), networkStub.downloadSources[i])
+			}
+		}
+		if tst.expectSuccess {
+			assert.NoError(t, err, tst.region)
+			assert.Equal(t, localFilePath, fileName)
+		} else {
+			assert.Error(t, err, tst.region)
+		}
+	}
 }
 
 func TestPackageLock(t *testing.T) {
@@ -422,6 +1189,128 @@ func TestPackageLock(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestPackageVersionLock_SameVersion(t *testing.T) {
+	err := lockPackageVersion("Foo", "1.0.0", "Install")
+	assert.Nil(t, err)
+	defer unlockPackageVersion("Foo", "1.0.0")
+
+	// shouldn't be able to act on the same version, even for a different action
+	err = lockPackageVersion("Foo", "1.0.0", "Uninstall")
+	assert.NotNil(t, err)
+
+	// shouldn't be able to lock the whole package while a version is locked
+	err = lockPackage("Foo", "Install")
+	assert.NotNil(t, err)
+}
+
+func TestPackageVersionLock_DifferentVersions(t *testing.T) {
+	err := lockPackageVersion("Foo", "1.0.0", "Install")
+	assert.Nil(t, err)
+	defer unlockPackageVersion("Foo", "1.0.0")
+
+	// other versions can be acted on at the same time
+	errorChan := make(chan error)
+	go func() {
+		lockErr := lockPackageVersion("Foo", "2.0.0", "Install")
+		if lockErr == nil {
+			defer unlockPackageVersion("Foo", "2.0.0")
+		}
+		errorChan <- lockErr
+	}()
+	assert.Nil(t, <-errorChan)
+
+	// the version can be locked again once unlocked
+	unlockPackageVersion("Foo", "1.0.0")
+	err = lockPackageVersion("Foo", "1.0.0", "Uninstall")
+	assert.Nil(t, err)
+}
+
+func TestPackageVersionLock_PackageLocked(t *testing.T) {
+	err := lockPackage("Foo", "Install")
+	assert.Nil(t, err)
+	defer unlockPackage("Foo")
+
+	// no version can be acted on while the whole package is locked
+	err = lockPackageVersion("Foo", "1.0.0", "Install")
+	assert.NotNil(t, err)
+}
+
+func setMaxPackageLockAge(maxAge time.Duration) (restore func()) {
+	maxPackageLockAgeOrig := maxPackageLockAge
+	maxPackageLockAge = func(log.T) time.Duration { return maxAge }
+	return func() { maxPackageLockAge = maxPackageLockAgeOrig }
+}
+
+func TestPackageLock_ReclaimStale(t *testing.T) {
+	defer setMaxPackageLockAge(time.Minute)()
+	logger := log.NewMockLog()
+	logger.On("Warnf", mock.Anything, mock.Anything).Return(nil)
+
+	// an install that never returned, and a caller waiting for its result
+	staleLock, _, err := lockOrJoinPackage("Stale", "1.0.0", InstallAction, false)
+	assert.NoError(t, err)
+	staleLock.acquiredAt = time.Now().Add(-2 * time.Minute)
+	joinedLock, joined, err := lockOrJoinPackage("Stale", "1.0.0", InstallAction, true)
+	assert.NoError(t, err)
+	assert.True(t, joined)
+
+	reclaimStalePackageLocks(logger, "Stale")
+	logger.AssertCalled(t, "Warnf", mock.Anything, mock.Anything)
+
+	// the waiting caller is told the action was abandoned, and the package can be locked again
+	assert.Equal(t, 1, joinedLock.wait().ExitCode)
+	lock, _, err := lockOrJoinPackage("Stale", "1.0.0", UninstallAction, false)
+	assert.NoError(t, err)
+	defer unlockPackage("Stale")
+
+	// the abandoned action returning late doesn't release the lock of the new one
+	unlockPackageWithResult("Stale", staleLock, contracts.PluginOutput{})
+	assert.Error(t, lockPackage("Stale", InstallAction))
+	assert.Equal(t, lock, mapPackageAction["Stale"])
+}
+
+func TestPackageVersionLock_ReclaimStale(t *testing.T) {
+	defer setMaxPackageLockAge(time.Minute)()
+	logger := log.NewMockLog()
+	logger.On("Warnf", mock.Anything, mock.Anything).Return(nil)
+
+	staleLock, err := acquirePackageVersion("Stale", "1.0.0", InstallAction)
+	assert.NoError(t, err)
+	staleLock.acquiredAt = time.Now().Add(-2 * time.Minute)
+
+	reclaimStalePackageLocks(logger, "Stale")
+
+	lock, err := acquirePackageVersion("Stale", "1.0.0", UninstallAction)
+	assert.NoError(t, err)
+	defer releasePackageVersion("Stale", "1.0.0", lock)
+	releasePackageVersion("Stale", "1.0.0", staleLock)
+	assert.Error(t, lockPackageVersion("Stale", "1.0.0", InstallAction))
+}
+
+func TestPackageLock_FreshNotReclaimed(t *testing.T) {
+	defer setMaxPackageLockAge(time.Minute)()
+	logger := log.NewMockLog()
+	logger.On("Warnf", mock.Anything, mock.Anything).Return(nil)
+
+	assert.NoError(t, lockPackage("Fresh", InstallAction))
+	defer unlockPackage("Fresh")
+	assert.NoError(t, lockPackageVersion("FreshVersion", "1.0.0", InstallAction))
+	defer unlockPackageVersion("FreshVersion", "1.0.0")
+
+	reclaimStalePackageLocks(logger, "Fresh")
+	reclaimStalePackageLocks(logger, "FreshVersion")
+
+	logger.AssertNotCalled(t, "Warnf", mock.Anything, mock.Anything)
+	assert.Error(t, lockPackage("Fresh", UninstallAction))
+	assert.Error(t, lockPackageVersion("FreshVersion", "1.0.0", UninstallAction))
+
+	// without a maximum age, even old locks are kept
+	defer setMaxPackageLockAge(0)()
+	mapPackageAction["Fresh"].acquiredAt = time.Now().Add(-time.Hour)
+	reclaimStalePackageLocks(logger, "Fresh")
+	assert.Error(t, lockPackage("Fresh", UninstallAction))
+}
+
 func TestPackageMark(t *testing.T) {
 	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: false}}
 	stubs.Set()
@@ -463,6 +1352,31 @@ func TestUnmarkPackage(t *testing.T) {
 	assert.Nil(t, unmarkInstallingPackage("Foo"))
 }
 
+func TestFileSysDepUncompressPackageFormats(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "packages")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	manifest := `{"name": "PVDriver", "version": "1.0.0"}`
+
+	zipPath, tarGzPath, err := fileutil.WriteTestArchives(tempDir, "PVDriver", map[string]string{"PVDriver.json": manifest})
+	assert.NoError(t, err)
+
+	for i, archivePath := range []string{zipPath, tarGzPath} {
+		versionDir := filepath.Join(tempDir, fmt.Sprintf("root%v", i), "PVDriver", "1.0.0")
+		assert.NoError(t, fileSysDepImp{}.Uncompress(archivePath, versionDir), archivePath)
+
+		content, err := ioutil.ReadFile(filepath.Join(versionDir, "PVDriver.json"))
+		assert.NoError(t, err, archivePath)
+		assert.Equal(t, manifest, string(content))
+	}
+
+	unknownPath := filepath.Join(tempDir, "PVDriver.7z")
+	assert.NoError(t, ioutil.WriteFile(unknownPath, []byte("7z\xbc\xaf\x27\x1c"), 0600))
+	err = fileSysDepImp{}.Uncompress(unknownPath, filepath.Join(tempDir, "unknown", "PVDriver", "1.0.0"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported archive format")
+}
+
 func lockAndUnlockGo(packageName string, channel chan error) {
 	err := lockPackage(packageName, "Install")
 	channel <- err
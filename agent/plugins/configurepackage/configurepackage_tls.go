@@ -0,0 +1,62 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_tls contains the TLS configuration of the package and manifest downloads
+package configurepackage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// tlsVersions are the TLS versions MinTLSVersion can be set to
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// packageDownloadTLSConfig returns the TLS configuration of the package downloads.
+// An unknown minimum version falls back to the default one, a CA bundle that can't be loaded fails the download.
+func packageDownloadTLSConfig(log log.T) (*tls.Config, error) {
+	config := getPackageDownloadConfig(log)
+	minVersion, known := tlsVersions[config.MinTLSVersion]
+	if !known {
+		if config.MinTLSVersion != "" {
+			log.Warnf("invalid package download TLS version %v, using %v", config.MinTLSVersion, appconfig.DefaultMinTLSVersion)
+		}
+		minVersion = tlsVersions[appconfig.DefaultMinTLSVersion]
+	}
+	tlsConfig := &tls.Config{MinVersion: minVersion}
+	if config.CABundlePath == "" {
+		return tlsConfig, nil
+	}
+
+	bundle, err := filesysdep.ReadFile(config.CABundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the CA bundle %v of the package downloads: %v", config.CABundlePath, err)
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("the CA bundle %v of the package downloads has no PEM certificate", config.CABundlePath)
+	}
+	tlsConfig.RootCAs = rootCAs
+	return tlsConfig, nil
+}
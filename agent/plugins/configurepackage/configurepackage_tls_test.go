@@ -0,0 +1,110 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPackageDownloadTLSConfig_MinVersion(t *testing.T) {
+	for configured, expected := range map[string]uint16{
+		"":    tls.VersionTLS12,
+		"1.1": tls.VersionTLS11,
+		"1.3": tls.VersionTLS13,
+		"1.5": tls.VersionTLS12,
+		"ssl": tls.VersionTLS12,
+	} {
+		restore := setPackageDownloadConfig(appconfig.ConfigurePackageCfg{MinTLSVersion: configured})
+		logger := log.NewMockLog()
+		logger.On("Warnf", mock.Anything, mock.Anything).Return(nil)
+
+		tlsConfig, err := packageDownloadTLSConfig(logger)
+		restore()
+
+		assert.NoError(t, err)
+		assert.Equal(t, expected, tlsConfig.MinVersion, configured)
+		assert.Nil(t, tlsConfig.RootCAs)
+		if configured == "1.5" || configured == "ssl" {
+			logger.AssertCalled(t, "Warnf", mock.Anything, mock.Anything)
+		} else {
+			logger.AssertNotCalled(t, "Warnf", mock.Anything, mock.Anything)
+		}
+	}
+}
+
+func TestPackageDownloadTLSConfig_MissingCABundle(t *testing.T) {
+	defer setPackageDownloadConfig(appconfig.ConfigurePackageCfg{CABundlePath: "/missing/ca-bundle.pem"})()
+
+	_, err := networkDepImp{}.Download(loggerMock, artifact.DownloadInput{
+		SourceURL:            "https://packages.example.com/PVDriver/PVDriver.zip",
+		DestinationDirectory: os.TempDir(),
+	})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "/missing/ca-bundle.pem")
+}
+
+func TestDownload_WithTLSSettings(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("package content"))
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	directory, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(directory)
+	// the certificate of the test server is only trusted through the CA bundle
+	caBundlePath := filepath.Join(directory, "ca-bundle.pem")
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caBundlePath, caBundle, 0600))
+
+	download := func(config appconfig.ConfigurePackageCfg) (artifact.DownloadOutput, error) {
+		defer setPackageDownloadConfig(config)()
+		return networkDepImp{}.Download(loggerMock, artifact.DownloadInput{
+			SourceURL:            server.URL + "/PVDriver/PVDriver.zip",
+			DestinationDirectory: filepath.Join(directory, "downloads"),
+		})
+	}
+
+	output, err := download(appconfig.ConfigurePackageCfg{MinTLSVersion: "1.2", CABundlePath: caBundlePath})
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(output.LocalFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "package content", string(content))
+
+	// the server doesn't go beyond TLS 1.2
+	_, err = download(appconfig.ConfigurePackageCfg{MinTLSVersion: "1.3", CABundlePath: caBundlePath})
+	assert.Error(t, err)
+
+	// the server isn't trusted without the CA bundle
+	_, err = download(appconfig.ConfigurePackageCfg{MinTLSVersion: "1.2"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_transport contains the transport shared by the package downloads and the listings of the versions
+package configurepackage
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// packageDownloadTransport is the transport of the package downloads, made again when their configuration changes
+var packageDownloadTransport struct {
+	sync.Mutex
+	key       string
+	transport *http.Transport
+}
+
+// getPackageDownloadTransport returns the transport of the package and manifest downloads and the listings of the
+// package versions, with the proxy and the TLS configuration of the package downloads
+func getPackageDownloadTransport(log log.T) (*http.Transport, error) {
+	config := getPackageDownloadConfig(log)
+	key := strings.Join([]string{config.ProxyURL, strings.Join(config.NoProxy, ","), config.MinTLSVersion, config.CABundlePath}, "|")
+
+	packageDownloadTransport.Lock()
+	defer packageDownloadTransport.Unlock()
+	if packageDownloadTransport.transport != nil && packageDownloadTransport.key == key {
+		return packageDownloadTransport.transport, nil
+	}
+	tlsConfig, err := packageDownloadTLSConfig(log)
+	if err != nil {
+		return nil, err
+	}
+	packageDownloadTransport.key = key
+	packageDownloadTransport.transport = artifact.NewTransport(packageDownloadProxy(log), tlsConfig)
+	return packageDownloadTransport.transport, nil
+}
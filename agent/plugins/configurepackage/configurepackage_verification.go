@@ -0,0 +1,126 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_verification contains the verification of a package after it is installed
+package configurepackage
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+)
+
+const (
+	// verifyStdoutFileName is the file the standard output of the verification command is written to
+	verifyStdoutFileName = "verifyStdout"
+
+	// verifyStderrFileName is the file the standard error of the verification command is written to
+	verifyStderrFileName = "verifyStderr"
+)
+
+// packageVerificationError is returned when the verification command of an installed package fails, the version it
+// was installed over is installed again then
+type packageVerificationError struct {
+	err error
+}
+
+func (e *packageVerificationError) Error() string {
+	return e.err.Error()
+}
+
+// verifyInstalledPackage runs the verification command of the manifest in the package folder.
+// If the command fails, the install is marked as failed and the installed version is rolled back.
+// The command is only run from a manifest whose signature was verified against the trust anchor.
+func (m *configurePackage) verifyInstalledPackage(context context.T,
+	packageName string,
+	version string,
+	arguments map[string]string,
+	manifest *PackageManifest,
+	installStatus contracts.ResultStatus,
+	output *contracts.PluginOutput) (status contracts.ResultStatus, err error) {
+	log := context.Log()
+	directory := getPackageFolder(packageName, version)
+
+	trustAnchor, err := getManifestTrustAnchor(log)
+	if err != nil {
+		output.AppendErrorf(log, "unable to verify %v %v, rolling back the install: %v", packageName, version, err)
+		m.rollbackInstall(context, packageName, version, arguments, manifest, output)
+		return contracts.ResultStatusFailed, err
+	}
+	if trustAnchor == "" {
+		output.AppendInfof(log, "Not running the verification command of %v %v, its manifest isn't signed without a trust anchor", packageName, version)
+		return installStatus, nil
+	}
+
+	output.AppendInfof(log, "Verifying %v %v", packageName, version)
+	verifyErr := execdep.ExeCommand(log, manifest.VerifyCommand, directory, m.OrchestrationDirectory, verifyStdoutFileName, verifyStderrFileName, false)
+	if verifyErr == nil {
+		return installStatus, nil
+	}
+
+	output.AppendErrorf(log, "verification of %v %v failed, rolling back the install: %v", packageName, version, verifyErr)
+	m.rollbackInstall(context, packageName, version, arguments, manifest, output)
+	return contracts.ResultStatusFailed, &packageVerificationError{err: fmt.Errorf("verification of %v %v failed: %v", packageName, version, verifyErr)}
+}
+
+// rollbackInstall uninstalls a version of a package whose install could not be verified.
+// failures are recorded in the output, the install has failed either way.
+func (m *configurePackage) rollbackInstall(context context.T,
+	packageName string,
+	version string,
+	arguments map[string]string,
+	manifest *PackageManifest,
+	output *contracts.PluginOutput) {
+	if len(manifest.Components) > 0 {
+		m.rollbackComponentActions(context, "uninstall", packageName, version, arguments, manifest.Components, output)
+		return
+	}
+
+	log := context.Log()
+	output.AppendInfof(log, "Rolling back %v %v", packageName, version)
+	_, status, err := m.executeAction(context, "uninstall", packageName, version, arguments, output, getPackageFolder(packageName, version))
+	if err == nil && !isActionSucceeded(status) {
+		err = fmt.Errorf("uninstall action state was %v and not %v", status, contracts.ResultStatusSuccess)
+	}
+	if err != nil {
+		output.AppendErrorf(log, "failed to roll back %v %v: %v", packageName, version, err)
+	}
+}
+
+// restorePreviousVersion installs again the version an unverified install was made over, and removes the version that
+// couldn't be verified. It returns true if the previous version is installed again.
+func restorePreviousVersion(context context.T,
+	manager configurePackageManager,
+	packageName string,
+	version string,
+	previousVersion string,
+	arguments map[string]string,
+	output *contracts.PluginOutput) bool {
+	log := context.Log()
+	output.AppendInfof(log, "Installing %v %v again", packageName, previousVersion)
+	status, err := manager.runInstallPackage(context, packageName, previousVersion, arguments, output)
+	if err == nil && !isActionSucceeded(status) {
+		err = fmt.Errorf("install action state was %v and not %v", status, contracts.ResultStatusSuccess)
+	}
+	if err != nil {
+		output.AppendErrorf(log, "failed to install %v %v again: %v", packageName, previousVersion, err)
+		return false
+	}
+	if _, err = manager.runUninstallPackagePost(context, packageName, version, output); err != nil {
+		output.AppendErrorf(log, "failed to clean up %v %v: %v", packageName, version, err)
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+package configurepackage
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func loadVerifiedManifest(t *testing.T, verifyCommand string) []byte {
+	manifest := PackageManifest{Name: "PVDriver", Platform: "Windows", Architecture: "amd64", Version: "9000.0.0", VerifyCommand: verifyCommand}
+	result, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+	return result
+}
+
+// acceptingVerifier accepts the signature of every manifest
+type acceptingVerifier struct{}
+
+func (acceptingVerifier) Verify(manifest []byte, signature []byte) error {
+	return nil
+}
+
+// trustManifests configures a trust anchor every manifest is verified against, and returns the function restoring the
+// original trust anchor
+func trustManifests() (restore func()) {
+	getManifestTrustAnchorOrig, newManifestVerifierOrig := getManifestTrustAnchor, newManifestVerifier
+	getManifestTrustAnchor = func(log.T) (string, error) { return testTrustAnchor, nil }
+	newManifestVerifier = func(trustAnchor string) (manifestVerifier, error) { return acceptingVerifier{}, nil }
+	return func() {
+		getManifestTrustAnchor, newManifestVerifier = getManifestTrustAnchorOrig, newManifestVerifierOrig
+	}
+}
+
+func TestInstallPackage_VerificationSucceeds(t *testing.T) {
+	defer trustManifests()()
+	pluginInformation := createStubPluginInputInstall()
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	execStub := &ExecDepStub{pluginInput: &model.PluginState{}, pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: loadVerifiedManifest(t, "verify.sh --quick")}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	status, err := manager.runInstallPackage(contextMock, pluginInformation.Name, pluginInformation.Version, nil, output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, contracts.ResultStatusSuccess, status)
+	assert.Equal(t, []string{"verify.sh --quick"}, execStub.executedCommands)
+	// only the install document is executed
+	assert.Equal(t, []string{getPackageFolder("PVDriver", "9000.0.0")}, execStub.parsedDirectories)
+}
+
+func TestInstallPackage_VerificationFailureRollsBack(t *testing.T) {
+	defer trustManifests()()
+	pluginInformation := createStubPluginInputInstall()
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	execStub := &ExecDepStub{
+		execError:    errors.New("exit status 1"),
+		pluginInput:  &model.PluginState{},
+		pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: loadVerifiedManifest(t, "verify.sh")}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	status, err := manager.runInstallPackage(contextMock, pluginInformation.Name, pluginInformation.Version, nil, output)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "verification of PVDriver 9000.0.0 failed")
+	assert.Equal(t, contracts.ResultStatusFailed, status)
+	// the install document is followed by the uninstall document of the same version
+	folder := getPackageFolder("PVDriver", "9000.0.0")
+	assert.Equal(t, []string{folder, folder}, execStub.parsedDirectories)
+	assert.Contains(t, output.Stdout, "Rolling back PVDriver 9000.0.0")
+}
+
+func TestInstallPackage_VerificationFailureRollsBackComponents(t *testing.T) {
+	defer trustManifests()()
+	pluginInformation := createStubPluginInputInstall()
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	var manifest PackageManifest
+	assert.NoError(t, json.Unmarshal(loadComponentsManifest(t, false), &manifest))
+	manifest.VerifyCommand = "verify.sh"
+	content, _ := json.Marshal(manifest)
+
+	execStub := &ExecDepStub{
+		execError:    errors.New("exit status 1"),
+		pluginInput:  &model.PluginState{},
+		pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess},
+	}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: content}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	status, err := manager.runInstallPackage(contextMock, pluginInformation.Name, pluginInformation.Version, nil, output)
+
+	assert.Error(t, err)
+	assert.Equal(t, contracts.ResultStatusFailed, status)
+	assert.Equal(t, []string{
+		componentFolder("Base"), componentFolder("Driver"), componentFolder("Service"),
+		componentFolder("Service"), componentFolder("Driver"), componentFolder("Base"),
+	}, execStub.parsedDirectories)
+}
+
+func TestInstallPackage_WithoutVerifyCommand(t *testing.T) {
+	pluginInformation := createStubPluginInputInstall()
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	execStub := &ExecDepStub{execError: errors.New("not expected"), pluginInput: &model.PluginState{}, pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: loadVerifiedManifest(t, "")}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	status, err := manager.runInstallPackage(contextMock, pluginInformation.Name, pluginInformation.Version, nil, output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, contracts.ResultStatusSuccess, status)
+	assert.Empty(t, execStub.executedCommands)
+}
+
+func TestInstallPackage_VerificationWithoutTrustAnchor(t *testing.T) {
+	pluginInformation := createStubPluginInputInstall()
+	output := &contracts.PluginOutput{}
+	manager := createInstance()
+
+	execStub := &ExecDepStub{execError: errors.New("not expected"), pluginInput: &model.PluginState{}, pluginOutput: &contracts.PluginResult{Status: contracts.ResultStatusSuccess}}
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{existsResultDefault: true, readResult: loadVerifiedManifest(t, "verify.sh")}, execDepStub: execStub}
+	stubs.Set()
+	defer stubs.Clear()
+
+	status, err := manager.runInstallPackage(contextMock, pluginInformation.Name, pluginInformation.Version, nil, output)
+
+	// the command of a manifest that isn't signed is not run
+	assert.NoError(t, err)
+	assert.Equal(t, contracts.ResultStatusSuccess, status)
+	assert.Empty(t, execStub.executedCommands)
+}
+
+func TestRunInstall_VerificationFailureRestoresPreviousVersion(t *testing.T) {
+	plugin := &Plugin{}
+	instanceContext := createStubInstanceContext()
+	pluginInformation := createStubPluginInputInstall()
+
+	managerMock := ConfigPackageSuccessMock("/foo", "1.0.0", "0.5.6", &PackageManifest{}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess)
+	managerMock.ExpectedCalls = removeExpectedCall(managerMock.ExpectedCalls, "runInstallPackage")
+	managerMock.ExpectedCalls = removeExpectedCall(managerMock.ExpectedCalls, "runUninstallPackagePost")
+	managerMock.On("runInstallPackage", "PVDriver", "1.0.0", mock.Anything).Return(contracts.ResultStatusFailed, &packageVerificationError{err: errors.New("verification of PVDriver 1.0.0 failed")})
+	managerMock.On("runInstallPackage", "PVDriver", "0.5.6", mock.Anything).Return(contracts.ResultStatusSuccess, nil)
+	managerMock.On("runUninstallPackagePost", "PVDriver", "1.0.0", mock.Anything).Return(contracts.ResultStatusSuccess, nil)
+
+	output := runConfigurePackage(plugin, contextMock, managerMock, instanceContext, pluginInformation)
+
+	// the install failed, the previous version is installed again and the unverified version removed
+	assert.Equal(t, 1, output.ExitCode)
+	managerMock.AssertCalled(t, "runInstallPackage", "PVDriver", "0.5.6", mock.Anything)
+	managerMock.AssertCalled(t, "runUninstallPackagePost", "PVDriver", "1.0.0", mock.Anything)
+	managerMock.AssertNotCalled(t, "runUninstallPackagePost", "PVDriver", "0.5.6", mock.Anything)
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package configurepackage implements the ConfigurePackage plugin.
+// configurepackage_versionrange contains the parsing and matching of version ranges such as >=1.2,<2.0
+package configurepackage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// versionRangeOperators are the comparison operators of a version constraint, two character operators first
+var versionRangeOperators = []string{">=", "<=", "==", ">", "<", "="}
+
+// versionConstraint is a single comparison of a version range, like >=1.2
+type versionConstraint struct {
+	operator string
+	version  [3]int
+}
+
+// versionRange is a list of constraints that a version must all satisfy
+type versionRange []versionConstraint
+
+// isVersionRange returns true if the version is a range of versions rather than an exact version
+func isVersionRange(version string) bool {
+	return strings.ContainsAny(version, "<>=")
+}
+
+// parseVersionRange parses a comma separated list of constraints, each an operator followed by a version of the
+// format major[.minor[.build]], the omitted parts being 0
+func parseVersionRange(expression string) (versionRange, error) {
+	var result versionRange
+	for _, part := range strings.Split(expression, ",") {
+		part = strings.TrimSpace(part)
+		constraint := versionConstraint{}
+		for _, operator := range versionRangeOperators {
+			if strings.HasPrefix(part, operator) {
+				constraint.operator = operator
+				break
+			}
+		}
+		if constraint.operator == "" {
+			return nil, fmt.Errorf("constraint %q doesn't start with one of %v", part, strings.Join(versionRangeOperators, " "))
+		}
+		numbers := strings.Split(strings.TrimSpace(strings.TrimPrefix(part, constraint.operator)), ".")
+		if len(numbers) > len(constraint.version) {
+			return nil, fmt.Errorf("constraint %q should compare to a version in format major[.minor[.build]]", part)
+		}
+		for i, number := range numbers {
+			value, err := strconv.Atoi(number)
+			if err != nil || value < 0 {
+				return nil, fmt.Errorf("constraint %q should compare to a version in format major[.minor[.build]]", part)
+			}
+			constraint.version[i] = value
+		}
+		result = append(result, constraint)
+	}
+	return result, nil
+}
+
+// contains returns true if the version, in format major.minor.build, satisfies every constraint of the range
+func (r versionRange) contains(version string) bool {
+	major, minor, build, err := parseVersion(version)
+	if err != nil {
+		return false
+	}
+	parts := [3]int{major, minor, build}
+	for _, constraint := range r {
+		comparison := compareVersionParts(parts, constraint.version)
+		var satisfied bool
+		switch constraint.operator {
+		case ">=":
+			satisfied = comparison >= 0
+		case "<=":
+			satisfied = comparison <= 0
+		case ">":
+			satisfied = comparison > 0
+		case "<":
+			satisfied = comparison < 0
+		default:
+			satisfied = comparison == 0
+		}
+		if !satisfied {
+			return false
+		}
+	}
+	return true
+}
+
+// compareVersionParts returns -1, 0 or 1 when a is lower than, equal to or greater than b
+func compareVersionParts(a, b [3]int) int {
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// getLatestVersionInRange returns the latest of the versions that satisfy the range, empty if none does
+func getLatestVersionInRange(versions []string, r versionRange) string {
+	var inRange []string
+	for _, version := range versions {
+		if r.contains(version) {
+			inRange = append(inRange, version)
+		}
+	}
+	return getLatestVersion(inRange, "")
+}
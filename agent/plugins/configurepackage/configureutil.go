@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil/artifact"
 	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/s3util"
 	"github.com/aws/amazon-ssm-agent/agent/updateutil"
@@ -43,6 +44,12 @@ const (
 	// the url to a specific package has a format like https://s3.us-east-1.amazonaws.com/amazon-ssm-packages-us-east-1/Packages/Test/windows/amd64/1.0.0/Test.zip
 	PackageUrlStandard = "https://s3.{Region}.amazonaws.com/amazon-ssm-packages-{Region}/Packages/{PackageName}/{Platform}/{Arch}"
 
+	// PackageUrlGlobal is the s3 folder of the global bucket, the fallback when a package isn't hosted in the bucket of the region
+	PackageUrlGlobal = "https://s3.amazonaws.com/amazon-ssm-packages-" + PackageGlobalRegion + "/Packages/{PackageName}/{Platform}/{Arch}"
+
+	// PackageGlobalRegion is the region of the global bucket
+	PackageGlobalRegion = "us-east-1"
+
 	// PackageUrlBeta is the s3 location for ad-hoc testing by package developers
 	PackageUrlBeta = "https://s3.us-east-1.amazonaws.com/amazon-ssm-packages-beta/Packages/{PackageName}/{Platform}/{Arch}"
 
@@ -55,31 +62,49 @@ const (
 	// PackageNameSuffix represents (when concatenated with the correct package url) the s3 location of a specific version of a package
 	PackageNameSuffix = "/{PackageVersion}/" + PackageNameFormat
 
+	// PackageManifestSuffix represents (when concatenated with the correct package url) the s3 location of the manifest
+	// that lists the available versions of a package
+	PackageManifestSuffix = "/" + ManifestNameFormat
+
 	// InstallAction represents the json command to install package
 	InstallAction = "Install"
 
 	// UninstallAction represents the json command to uninstall package
 	UninstallAction = "Uninstall"
 
+	// ReinstallAction represents the json command to uninstall then install the same version of a package
+	ReinstallAction = "Reinstall"
+
+	// AllVersions is the version of an uninstall that removes every installed version of the package
+	AllVersions = "*"
+
 	// PatternVersion represents the regular expression for validating version
 	PatternVersion = "^(?:(\\d+)\\.)(?:(\\d+)\\.)(\\d+)$"
 )
 
+// nonGlobalPartitionRegionPrefixes are the region prefixes of the partitions the global bucket can't be reached from
+var nonGlobalPartitionRegionPrefixes = []string{"cn-", "us-gov-", "us-iso-", "us-isob-"}
+
 type configureUtil interface {
 	CreatePackageFolder(name string, version string) (folder string, err error)
 	HasValidPackage(name string, version string) bool
 	GetCurrentVersion(name string) (installedVersion string)
+	GetInstalledVersions(name string) (installedVersions []string)
 	GetLatestVersion(log log.T, name string) (latestVersion string, err error)
 	GetS3Location(packageName string, version string) (s3Location string)
+	GetS3FallbackLocation(packageName string, version string) (s3Location string)
+	GetPackageManifest(log log.T, name string) (manifest *PackageManifest, err error)
 }
 
 type configureUtilImp struct {
-	packageUrl     string
-	compressFormat string
+	packageUrl string
+	// fallbackPackageUrl is tried when a package isn't found at packageUrl, empty for no fallback
+	fallbackPackageUrl string
+	compressFormat     string
 }
 
 func NewUtil(instanceContext *updateutil.InstanceContext, repository string) configureUtil {
-	var packageUrl string
+	var packageUrl, fallbackPackageUrl string
 	if repository == "beta" {
 		packageUrl = PackageUrlBeta
 	} else if repository == "gamma" {
@@ -88,11 +113,35 @@ func NewUtil(instanceContext *updateutil.InstanceContext, repository string) con
 		packageUrl = PackageUrlBjs
 	} else {
 		packageUrl = PackageUrlStandard
+		// packages that aren't hosted in the bucket of the region are fetched from the global bucket, which is only
+		// reachable from the regions of its own partition
+		if instanceContext.Region != PackageGlobalRegion && isGlobalPartitionRegion(instanceContext.Region) {
+			fallbackPackageUrl = replacePackageUrlHolders(PackageUrlGlobal, instanceContext)
+		}
 	}
+	return &configureUtilImp{
+		packageUrl:         replacePackageUrlHolders(packageUrl, instanceContext),
+		fallbackPackageUrl: fallbackPackageUrl,
+		compressFormat:     instanceContext.CompressFormat}
+}
+
+// isGlobalPartitionRegion returns true if the region is in the same partition as the global bucket, i.e. not in the
+// China, GovCloud or isolated partitions
+func isGlobalPartitionRegion(region string) bool {
+	for _, prefix := range nonGlobalPartitionRegionPrefixes {
+		if strings.HasPrefix(region, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// replacePackageUrlHolders fills the region, platform and architecture of the instance in a package url
+func replacePackageUrlHolders(packageUrl string, instanceContext *updateutil.InstanceContext) string {
 	packageUrl = strings.Replace(packageUrl, updateutil.RegionHolder, instanceContext.Region, -1)
 	packageUrl = strings.Replace(packageUrl, updateutil.PlatformHolder, appconfig.PackagePlatform, -1)
 	packageUrl = strings.Replace(packageUrl, updateutil.ArchHolder, instanceContext.Arch, -1)
-	return &configureUtilImp{packageUrl: packageUrl, compressFormat: instanceContext.CompressFormat}
+	return packageUrl
 }
 
 // getPackageFilename constructs the package name to locate in the s3 bucket or on disk after download
@@ -115,7 +164,21 @@ func getManifestName(packageName string) (manifestName string) {
 
 // getS3Location constructs the s3 url to locate the package for downloading
 func (util *configureUtilImp) GetS3Location(packageName string, version string) (s3Location string) {
-	s3Location = util.packageUrl
+	return util.packageLocation(util.packageUrl, packageName, version)
+}
+
+// GetS3FallbackLocation constructs the s3 url to download the package from when it isn't found at its s3 location,
+// or returns an empty string if there is no fallback
+func (util *configureUtilImp) GetS3FallbackLocation(packageName string, version string) (s3Location string) {
+	if util.fallbackPackageUrl == "" {
+		return ""
+	}
+	return util.packageLocation(util.fallbackPackageUrl, packageName, version)
+}
+
+// packageLocation constructs the url of a version of a package in the s3 folder packageUrl
+func (util *configureUtilImp) packageLocation(packageUrl string, packageName string, version string) (s3Location string) {
+	s3Location = packageUrl
 	s3Location += PackageNameSuffix
 
 	s3Location = strings.Replace(s3Location, updateutil.PackageNameHolder, packageName, -1)
@@ -168,7 +231,14 @@ func (util *configureUtilImp) HasValidPackage(name string, version string) bool
 	}
 	files, _ := filesysdep.GetFileNames(packageFolder)
 	directories, _ := filesysdep.GetDirectoryNames(packageFolder)
-	if len(files) <= 1 && len(directories) == 0 {
+	// the cache of the downloaded archive is not part of the unpacked package
+	var packageDirectories []string
+	for _, directory := range directories {
+		if directory != packageCacheFolderName {
+			packageDirectories = append(packageDirectories, directory)
+		}
+	}
+	if len(files) <= 1 && len(packageDirectories) == 0 {
 		return false
 	}
 	return true
@@ -216,7 +286,21 @@ func (util *configureUtilImp) GetCurrentVersion(name string) (installedVersion s
 	if err != nil {
 		return ""
 	}
-	return getLatestVersion(directories[:], getInstallingPackageVersion(name))
+	return getLatestVersion(getUnmarkedVersions(name, directories), "")
+}
+
+// GetInstalledVersions finds all the versions of a package in the package root
+func (util *configureUtilImp) GetInstalledVersions(name string) (installedVersions []string) {
+	directories, err := filesysdep.GetDirectoryNames(filepath.Join(appconfig.PackageRoot, name))
+	if err != nil {
+		return nil
+	}
+	for _, directory := range directories {
+		if _, _, _, err := parseVersion(directory); err == nil {
+			installedVersions = append(installedVersions, directory)
+		}
+	}
+	return installedVersions
 }
 
 // parseVersion returns the major, minor, and build parts of a valid version string and an error if the string is not valid
@@ -248,3 +332,117 @@ func (util *configureUtilImp) GetLatestVersion(log log.T, name string) (latestVe
 	}
 	return latestVersion, err
 }
+
+// GetPackageManifest downloads and parses the manifest listing the available versions of a package, a manifest
+// downloaded from the same location a short while ago is reused
+func (util *configureUtilImp) GetPackageManifest(log log.T, name string) (manifest *PackageManifest, err error) {
+	manifestLocation := strings.Replace(util.packageUrl+PackageManifestSuffix, updateutil.PackageNameHolder, name, -1)
+	return getCachedManifest(manifestLocation, func(previous *PackageManifest) (*PackageManifest, error) {
+		return util.downloadPackageManifest(log, name, manifestLocation, previous)
+	})
+}
+
+// downloadPackageManifest downloads the manifest of a package from manifestLocation, or from the global bucket if it
+// isn't there, verifies its signature and parses it. The previous manifest, if any, is returned as it is when the
+// ETag of the download shows that the manifest didn't change.
+func (util *configureUtilImp) downloadPackageManifest(log log.T, name string, manifestLocation string, previous *PackageManifest) (manifest *PackageManifest, err error) {
+	packageRoot := getPackageRoot(name)
+	if err = filesysdep.MakeDirExecute(packageRoot); err != nil {
+		return nil, err
+	}
+
+	downloadInput := artifact.DownloadInput{
+		SourceURL:            manifestLocation,
+		DestinationDirectory: packageRoot}
+	downloadOutput, err := networkdep.Download(log, downloadInput)
+	if isNotFoundError(err) && util.fallbackPackageUrl != "" {
+		log.Debugf("package manifest %v not found, falling back to the global bucket", manifestLocation)
+		manifestLocation = strings.Replace(util.fallbackPackageUrl+PackageManifestSuffix, updateutil.PackageNameHolder, name, -1)
+		downloadInput.SourceURL = manifestLocation
+		downloadOutput, err = networkdep.Download(log, downloadInput)
+	}
+	if err != nil || downloadOutput.LocalFilePath == "" {
+		return nil, fmt.Errorf("failed to download package manifest %v, %v", manifestLocation, err)
+	}
+	// the manifest was verified and parsed when it was downloaded before
+	if !downloadOutput.IsUpdated && previous != nil {
+		log.Debugf("package manifest %v is unchanged, reusing it", manifestLocation)
+		return previous, nil
+	}
+
+	// when a trust anchor is configured, the manifest must come with a valid detached signature
+	trustAnchor, err := getManifestTrustAnchor(log)
+	if err != nil {
+		return nil, err
+	}
+	if trustAnchor != "" {
+		signatureInput := artifact.DownloadInput{
+			SourceURL:            manifestLocation + ManifestSignatureSuffix,
+			DestinationDirectory: packageRoot}
+		signatureOutput, signatureErr := networkdep.Download(log, signatureInput)
+		if signatureErr != nil || signatureOutput.LocalFilePath == "" {
+			return nil, &manifestSignatureError{err: fmt.Errorf("failed to download signature %v, %v", signatureInput.SourceURL, signatureErr)}
+		}
+		if err = verifyManifestSignature(log, trustAnchor, downloadOutput.LocalFilePath, signatureOutput.LocalFilePath); err != nil {
+			return nil, err
+		}
+	}
+	return parsePackageManifest(log, downloadOutput.LocalFilePath)
+}
+
+// getVersionManifest returns the entry of a version in the manifest of a package, nil if the manifest can't be
+// obtained or doesn't list the version
+func getVersionManifest(log log.T, util configureUtil, packageName string, version string) *PackageVersionManifest {
+	manifest, err := util.GetPackageManifest(log, packageName)
+	if err != nil || manifest == nil {
+		return nil
+	}
+	for i := range manifest.Versions {
+		if manifest.Versions[i].Version == version {
+			return &manifest.Versions[i]
+		}
+	}
+	return nil
+}
+
+// getArchiveFile returns the archive file the manifest declares for a version, empty if it is not declared. A file
+// that isn't within the folder of the version is an error, so that it is never downloaded or extracted.
+func getArchiveFile(log log.T, util configureUtil, packageName string, version string) (string, error) {
+	versionManifest := getVersionManifest(log, util, packageName, version)
+	if versionManifest == nil || versionManifest.File == "" {
+		return "", nil
+	}
+	if err := validateFileReference(versionManifest.File); err != nil {
+		return "", fmt.Errorf("invalid archive file of %v %v, %v", packageName, version, err)
+	}
+	return versionManifest.File, nil
+}
+
+// withArchiveFile replaces the archive name at the end of the location of a package with file
+func withArchiveFile(location string, file string) string {
+	return location[:strings.LastIndex(location, "/")+1] + strings.Replace(file, `\`, "/", -1)
+}
+
+// isCompatibleVersion determines if a version in the package manifest can be installed on the instance
+func isCompatibleVersion(versionManifest PackageVersionManifest, instanceContext *updateutil.InstanceContext) bool {
+	if versionManifest.Platform != "" &&
+		!strings.EqualFold(versionManifest.Platform, instanceContext.Platform) &&
+		!strings.EqualFold(versionManifest.Platform, appconfig.PackagePlatform) {
+		return false
+	}
+	if versionManifest.Architecture != "" && !strings.EqualFold(versionManifest.Architecture, instanceContext.Arch) {
+		return false
+	}
+	return true
+}
+
+// getCompatibleVersions returns the versions in the package manifest that can be installed on the instance
+func getCompatibleVersions(manifest *PackageManifest, instanceContext *updateutil.InstanceContext) (versions []string) {
+	versions = make([]string, 0)
+	for _, versionManifest := range manifest.Versions {
+		if isCompatibleVersion(versionManifest, instanceContext) {
+			versions = append(versions, versionManifest.Version)
+		}
+	}
+	return versions
+}
@@ -64,6 +64,33 @@ func TestGetS3Location_Bjs(t *testing.T) {
 	assert.Equal(t, packageLocation, result)
 }
 
+func TestGetS3FallbackLocation(t *testing.T) {
+	pluginInformation := createStubPluginInputInstall()
+
+	// outside of the global region the global bucket is the fallback
+	util := NewUtil(createStubInstanceContext(), "")
+	packageLocation := "https://s3.amazonaws.com/amazon-ssm-packages-us-east-1/Packages/PVDriver/" + appconfig.PackagePlatform + "/amd64/9000.0.0/PVDriver.zip"
+	assert.Equal(t, packageLocation, util.GetS3FallbackLocation(pluginInformation.Name, pluginInformation.Version))
+
+	// the global bucket isn't available in BJS
+	util = NewUtil(createStubInstanceContextBjs(), "")
+	assert.Equal(t, "", util.GetS3FallbackLocation(pluginInformation.Name, pluginInformation.Version))
+
+	// the global bucket is in another partition than the China and GovCloud regions
+	for _, region := range []string{"cn-northwest-1", "us-gov-west-1"} {
+		instanceContext := createStubInstanceContext()
+		instanceContext.Region = region
+		util = NewUtil(instanceContext, "")
+		assert.Equal(t, "", util.GetS3FallbackLocation(pluginInformation.Name, pluginInformation.Version))
+	}
+
+	// the bucket of the global region is the global bucket
+	instanceContext := createStubInstanceContext()
+	instanceContext.Region = PackageGlobalRegion
+	util = NewUtil(instanceContext, "")
+	assert.Equal(t, "", util.GetS3FallbackLocation(pluginInformation.Name, pluginInformation.Version))
+}
+
 func TestCreatePackageFolderSucceeded(t *testing.T) {
 	pluginInformation := createStubPluginInputInstall()
 	util := configureUtilImp{}
@@ -196,9 +223,13 @@ type mockConfigureUtility struct {
 	packageFolder            string
 	createPackageFolderError error
 	currentVersion           string
+	installedVersions        []string
 	latestVersion            string
 	getLatestVersionError    error
 	s3Location               string
+	s3FallbackLocation       string
+	manifest                 *PackageManifest
+	manifestError            error
 }
 
 func (u *mockConfigureUtility) CreatePackageFolder(name string, version string) (folder string, err error) {
@@ -213,6 +244,10 @@ func (u *mockConfigureUtility) GetCurrentVersion(name string) (installedVersion
 	return u.currentVersion
 }
 
+func (u *mockConfigureUtility) GetInstalledVersions(name string) (installedVersions []string) {
+	return u.installedVersions
+}
+
 func (u *mockConfigureUtility) GetLatestVersion(log log.T, name string) (latestVersion string, err error) {
 	return u.latestVersion, u.getLatestVersionError
 }
@@ -220,3 +255,11 @@ func (u *mockConfigureUtility) GetLatestVersion(log log.T, name string) (latestV
 func (u *mockConfigureUtility) GetS3Location(packageName string, version string) (s3Location string) {
 	return u.s3Location
 }
+
+func (u *mockConfigureUtility) GetS3FallbackLocation(packageName string, version string) (s3Location string) {
+	return u.s3FallbackLocation
+}
+
+func (u *mockConfigureUtility) GetPackageManifest(log log.T, name string) (manifest *PackageManifest, err error) {
+	return u.manifest, u.manifestError
+}
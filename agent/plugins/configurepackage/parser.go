@@ -18,24 +18,78 @@ package configurepackage
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"regexp"
+	"strings"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/log"
 )
 
+// getMaxManifestBytes returns the size above which a package manifest is rejected, the default one when the agent
+// configuration can't be loaded
+var getMaxManifestBytes = func(log log.T) int64 {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		if log != nil {
+			log.Errorf("Failed to load the agent configuration, rejecting manifests larger than %v bytes: %v", appconfig.DefaultMaxManifestBytes, err)
+		}
+		return appconfig.DefaultMaxManifestBytes
+	}
+	return config.ConfigurePackage.MaxManifestBytes
+}
+
+// readPackageManifest reads a manifest file, failing without reading it whole if it is larger than the maximum manifest size
+func readPackageManifest(log log.T, fileName string) (content []byte, err error) {
+	maxBytes := getMaxManifestBytes(log)
+	if content, err = filesysdep.ReadFileLimit(fileName, maxBytes); err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxBytes {
+		return nil, fmt.Errorf("package's JSON configuration file %v is larger than the maximum of %v bytes", fileName, maxBytes)
+	}
+	return content, nil
+}
+
 // PackageManifest represents json structure of package's online configuration file.
 type PackageManifest struct {
-	Name         string `json:"name"`
+	Name         string                   `json:"name"`
+	Platform     string                   `json:"platform"`
+	Architecture string                   `json:"architecture"`
+	Version      string                   `json:"version"`
+	Versions     []PackageVersionManifest `json:"versions,omitempty"`
+	Components   []PackageComponent       `json:"components,omitempty"`
+	Rollback     bool                     `json:"rollback,omitempty"`
+	// VerifyCommand is run in the package folder after the install, a non-zero exit fails the install
+	VerifyCommand string `json:"verifyCommand,omitempty"`
+	// UseTargetUninstall runs the uninstall document of this version instead of the installed one's when the installed
+	// version is downgraded to this version, for packages whose older versions migrate the newer ones back
+	UseTargetUninstall bool `json:"useTargetUninstall,omitempty"`
+}
+
+// PackageVersionManifest represents one available version of a package and the instances it can be installed on.
+// An empty Platform or Architecture means the version is compatible with any platform or architecture.
+type PackageVersionManifest struct {
+	Version      string `json:"version"`
 	Platform     string `json:"platform"`
 	Architecture string `json:"architecture"`
-	Version      string `json:"version"`
+	ArchiveSize  int64  `json:"archiveSize,omitempty"`
+	// File is the name of the archive of the version, relative to the folder of the version in the repository.
+	// The archive is named after the package when it is empty.
+	File string `json:"file,omitempty"`
+}
+
+// PackageComponent represents one component bundled in a package.
+// Each component has its own folder in the package containing its install and uninstall documents.
+type PackageComponent struct {
+	Name string `json:"name"`
 }
 
 // parsePackageManifest parses the manifest to provide install/uninstall information.
 func parsePackageManifest(log log.T, fileName string) (parsedManifest *PackageManifest, err error) {
 	// load specified file from file system
 	var result = []byte{}
-	if result, err = filesysdep.ReadFile(fileName); err != nil {
+	if result, err = readPackageManifest(log, fileName); err != nil {
 		if log != nil {
 			log.Errorf("Failed to read package's JSON configuration file: %v", err)
 		}
@@ -51,7 +105,7 @@ func parsePackageManifest(log log.T, fileName string) (parsedManifest *PackageMa
 	}
 
 	// ensure manifest conforms to defined schema
-	if err = validatePackageManifest(log, parsedManifest); err != nil {
+	if err = validateManifest(parsedManifest); err != nil {
 		if log != nil {
 			log.Errorf("Invalid JSON configuration file due to %v", err)
 		}
@@ -60,39 +114,94 @@ func parsePackageManifest(log log.T, fileName string) (parsedManifest *PackageMa
 	return
 }
 
-// TODO:MF: better descriptions of validity requirements when validation fails
-// validateManifest ensures all the fields are provided.
-func validatePackageManifest(log log.T, parsedManifest *PackageManifest) error {
+// validateManifest ensures all the required fields of a package manifest are provided and well formed.
+// Errors name the offending field so a malformed manifest can be fixed without reading the agent source.
+func validateManifest(parsedManifest *PackageManifest) error {
 	// ensure non-empty struct
-	if parsedManifest == (&PackageManifest{}) {
-		return fmt.Errorf("empty package manifest file") //TODO:MF: This isn't triggering when the manifest is empty per coverage.html - but it will get caught in the next validation case - is this necessary?
+	if parsedManifest == nil {
+		return fmt.Errorf("empty package manifest file")
 	}
 
 	// ensure non-empty and properly formatted required fields
 	if parsedManifest.Name == "" {
-		return fmt.Errorf("empty package name")
-	} else {
-		name := parsedManifest.Name
-		if err := validatePathPackage(log, name); err != nil {
-			return fmt.Errorf("invalid package name %v", name)
+		return fmt.Errorf("manifest field \"name\" is empty")
+	}
+	if err := validatePathPackage(parsedManifest.Name); err != nil {
+		return fmt.Errorf("manifest field \"name\" is invalid: %v", parsedManifest.Name)
+	}
+	// a manifest describing the available versions of a package doesn't have a version of its own
+	if parsedManifest.Version == "" && len(parsedManifest.Versions) == 0 {
+		return fmt.Errorf("manifest field \"version\" is empty and field \"versions\" has no entries")
+	}
+	// ensure versions follow format <major>.<minor>.<build>
+	if parsedManifest.Version != "" && !isValidManifestVersion(parsedManifest.Version) {
+		return fmt.Errorf("manifest field \"version\" is not a valid version string: %v", parsedManifest.Version)
+	}
+	for i, versionManifest := range parsedManifest.Versions {
+		if !isValidManifestVersion(versionManifest.Version) {
+			return fmt.Errorf("manifest field \"versions[%v].version\" is not a valid version string: %v", i, versionManifest.Version)
+		}
+		// the archive is downloaded and extracted under the folder of the version, it can't name a file outside of it
+		if versionManifest.File != "" {
+			if err := validateFileReference(versionManifest.File); err != nil {
+				return fmt.Errorf("manifest field \"versions[%v].file\" is invalid: %v", i, err)
+			}
 		}
 	}
-	if parsedManifest.Version == "" {
-		return fmt.Errorf("empty package version")
-	} else {
-		// ensure version follows format <major>.<minor>.<build>
-		version := parsedManifest.Version
-		if matched, err := regexp.MatchString(PatternVersion, version); matched == false || err != nil {
-			return fmt.Errorf("invalid version string %v", version)
+	// components are installed from a folder named after the component, so names must be unique and path safe
+	componentNames := make(map[string]bool)
+	for i, component := range parsedManifest.Components {
+		if component.Name == "" {
+			return fmt.Errorf("manifest field \"components[%v].name\" is empty", i)
+		}
+		if err := validatePathPackage(component.Name); err != nil {
+			return fmt.Errorf("manifest field \"components[%v].name\" is invalid: %v", i, component.Name)
+		}
+		if componentNames[component.Name] {
+			return fmt.Errorf("manifest field \"components[%v].name\" is a duplicate component name: %v", i, component.Name)
 		}
+		componentNames[component.Name] = true
 	}
 	// TODO:MF: validate platform and arch against this instance's platform and arch?  We don't really use them...
 
 	return nil
 }
 
-// validatePathPackage ensures that a given name is a valid part of a folder path or S3 bucket URI
-func validatePathPackage(log log.T, name string) error {
-	// TODO:MF: Validate
+// isValidManifestVersion returns true if the version matches PatternVersion
+func isValidManifestVersion(version string) bool {
+	matched, err := regexp.MatchString(PatternVersion, version)
+	return matched && err == nil
+}
+
+// validatePathPackage ensures that a given name is a valid part of a folder path or S3 bucket URI.
+// The name is a single folder, so it can't name a path outside of the folder it is joined to.
+func validatePathPackage(name string) error {
+	if name == "" || name == "." || strings.Contains(name, "..") || strings.ContainsAny(name, `/\:`) {
+		return fmt.Errorf("%v is not a single folder name", name)
+	}
+	if !isPathWithin(appconfig.PackageRoot, filepath.Join(appconfig.PackageRoot, name)) {
+		return fmt.Errorf("%v is outside of the package root", name)
+	}
 	return nil
 }
+
+// validateFileReference ensures that a file name of a manifest is relative and stays within the folder it is
+// relative to, whatever the separator of the platform
+func validateFileReference(name string) error {
+	isDriveLetterPath := len(name) >= 2 && name[1] == ':'
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || isDriveLetterPath || filepath.IsAbs(name) {
+		return fmt.Errorf("%v is an absolute path", name)
+	}
+	for _, element := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if element == ".." {
+			return fmt.Errorf("%v refers to a parent folder", name)
+		}
+	}
+	return nil
+}
+
+// isPathWithin returns true if the cleaned path is root or one of its descendants
+func isPathWithin(root string, path string) bool {
+	relativePath, err := filepath.Rel(filepath.Clean(root), filepath.Clean(path))
+	return err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
@@ -17,7 +17,9 @@ package configurepackage
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	"github.com/aws/amazon-ssm-agent/agent/log"
@@ -27,6 +29,8 @@ import (
 // Valid manifest files
 var sampleManifests = []string{
 	"testdata/sampleManifest.json",
+	"testdata/sampleManifestMultiArch.json",
+	"testdata/sampleManifestComponents.json",
 }
 
 // Invalid manifest files
@@ -113,6 +117,75 @@ func TestParseMalformedManifest(t *testing.T) {
 	}
 }
 
+// TestParseManifestTooLarge tests that a manifest larger than the maximum size is rejected before it is parsed
+func TestParseManifestTooLarge(t *testing.T) {
+	getMaxManifestBytesOrig := getMaxManifestBytes
+	getMaxManifestBytes = func(log.T) int64 { return 1024 }
+	defer func() { getMaxManifestBytes = getMaxManifestBytesOrig }()
+
+	// a valid manifest padded with a large description
+	manifest := fmt.Sprintf(`{"name": "PVDriver", "platform": "Windows", "architecture": "amd64", "version": "1.0.0", "description": "%v"}`,
+		strings.Repeat("x", 4096))
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{readResult: []byte(manifest), existsResultDefault: true}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	result, err := parsePackageManifest(log.NewMockLog(), "testdata/sampleManifest.json")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "larger than the maximum of 1024 bytes")
+	assert.Nil(t, result)
+
+	// the same manifest under the limit is parsed
+	getMaxManifestBytes = func(log.T) int64 { return 8192 }
+	result, err = parsePackageManifest(log.NewMockLog(), "testdata/sampleManifest.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "PVDriver", result.Name)
+}
+
+// TestValidateManifest tests that valid manifests pass validation
+func TestValidateManifest(t *testing.T) {
+	manifests := []*PackageManifest{
+		{Name: "PVDriver", Version: "1.0.0"},
+		{Name: "PVDriver", Versions: []PackageVersionManifest{{Version: "1.0.0"}, {Version: "1.0.1", Platform: "windows"}}},
+		{Name: "PVDriver", Version: "1.0.0", Components: []PackageComponent{{Name: "Base"}, {Name: "Driver"}}},
+		{Name: "PVDriver", Versions: []PackageVersionManifest{{Version: "1.0.0", File: "PVDriver-1.0.0.zip"}, {Version: "1.0.1", File: "windows/PVDriver.zip"}}},
+	}
+
+	for _, manifest := range manifests {
+		assert.NoError(t, validateManifest(manifest))
+	}
+}
+
+// TestValidateManifestWithError tests that malformed manifests fail validation with an error naming the field
+func TestValidateManifestWithError(t *testing.T) {
+	type validateTestCase struct {
+		Manifest *PackageManifest
+		Field    string
+	}
+	testCases := []validateTestCase{
+		{&PackageManifest{Version: "1.0.0"}, `"name"`},
+		{&PackageManifest{Name: "PVDriver"}, `"versions"`},
+		{&PackageManifest{Name: "PVDriver", Version: "1.0"}, `"version"`},
+		{&PackageManifest{Name: "PVDriver", Versions: []PackageVersionManifest{{Version: "1.0.0"}, {Version: "1.0.0-beta"}}}, `"versions[1].version"`},
+		{&PackageManifest{Name: "PVDriver", Version: "1.0.0", Components: []PackageComponent{{Name: "Base"}, {Name: ""}}}, `"components[1].name"`},
+	}
+	// archive files outside of the folder of the version
+	for _, file := range []string{"/tmp/PVDriver.zip", `\\server\PVDriver.zip`, `C:\PVDriver.zip`, "../PVDriver.zip", "bin/../../PVDriver.zip", `..\PVDriver.zip`} {
+		testCases = append(testCases, validateTestCase{
+			&PackageManifest{Name: "PVDriver", Versions: []PackageVersionManifest{{Version: "1.0.0"}, {Version: "1.0.1", File: file}}},
+			`"versions[1].file"`})
+	}
+
+	assert.Error(t, validateManifest(nil))
+	for _, test := range testCases {
+		err := validateManifest(test.Manifest)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), test.Field)
+	}
+}
+
 // Load specified file from file system
 func loadFile(t *testing.T, fileName string) (result []byte) {
 	var err error
@@ -131,3 +204,26 @@ func loadManifestFromFile(t *testing.T, fileName string) (manifest *PackageManif
 
 	return manifest
 }
+
+// TestValidatePathPackage tests that the names of packages and components can't name a folder outside of their parent
+func TestValidatePathPackage(t *testing.T) {
+	for _, name := range []string{"PVDriver", "AWS.PVDriver", "driver-1.0", "_component"} {
+		assert.NoError(t, validatePathPackage(name), name)
+	}
+	for _, name := range []string{"", ".", "..", "../../x", "a/b", `a\b`, "/etc", `C:\Windows`, "a..b"} {
+		assert.Error(t, validatePathPackage(name), name)
+	}
+}
+
+// TestParseManifestComponentOutsidePackage tests that a manifest with a component escaping the package folder is rejected
+func TestParseManifestComponentOutsidePackage(t *testing.T) {
+	manifest := `{"name": "PVDriver", "version": "1.0.0", "components": [{"name": "../../x"}]}`
+	stubs := &ConfigurePackageStubs{fileSysDepStub: &FileSysDepStub{readResult: []byte(manifest), existsResultDefault: true}}
+	stubs.Set()
+	defer stubs.Clear()
+
+	_, err := parsePackageManifest(log.NewMockLog(), "testdata/sampleManifest.json")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "components[0].name")
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/s3util"
 	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/updateutil"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -49,6 +50,8 @@ func (m *ConfigurePackageStubs) Set() {
 	if m.networkDepStub != nil {
 		m.networkDepOrig = networkdep
 		networkdep = m.networkDepStub
+		// the manifests downloaded with the previous network must be downloaded again from the stub
+		resetManifestCache()
 	}
 	if m.execDepStub != nil {
 		m.execDepOrig = execdep
@@ -83,6 +86,15 @@ type FileSysDepStub struct {
 	readResult           []byte
 	readError            error
 	writeError           error
+	appendContent        string
+	appendError          error
+	freeDiskSpaceResult  int64
+	freeDiskSpaceError   error
+	freeDiskSpacePath    string
+	copyError            error
+	copiedFiles          []string
+	checksumResult       string
+	checksumError        error
 }
 
 func (m *FileSysDepStub) MakeDirExecute(destinationDir string) (err error) {
@@ -126,10 +138,40 @@ func (m *FileSysDepStub) ReadFile(filename string) ([]byte, error) {
 	return m.readResult, m.readError
 }
 
+func (m *FileSysDepStub) ReadFileLimit(filename string, limit int64) ([]byte, error) {
+	if int64(len(m.readResult)) > limit+1 {
+		return m.readResult[:limit+1], m.readError
+	}
+	return m.readResult, m.readError
+}
+
 func (m *FileSysDepStub) WriteFile(filename string, content string) error {
 	return m.writeError
 }
 
+func (m *FileSysDepStub) AppendFile(filename string, content string) error {
+	if m.appendError == nil {
+		m.appendContent += content
+	}
+	return m.appendError
+}
+
+func (m *FileSysDepStub) FreeDiskSpace(path string) (int64, error) {
+	m.freeDiskSpacePath = path
+	return m.freeDiskSpaceResult, m.freeDiskSpaceError
+}
+
+func (m *FileSysDepStub) CopyFile(src, dst string) error {
+	if m.copyError == nil {
+		m.copiedFiles = append(m.copiedFiles, dst)
+	}
+	return m.copyError
+}
+
+func (m *FileSysDepStub) Checksum(filename string) (string, error) {
+	return m.checksumResult, m.checksumError
+}
+
 type NetworkDepStub struct {
 	foldersResult          []string
 	foldersError           error
@@ -137,6 +179,14 @@ type NetworkDepStub struct {
 	downloadErrorDefault   error
 	downloadResultSequence []artifact.DownloadOutput
 	downloadErrorSequence  []error
+	downloadCount          int
+	// downloadSources are the urls of the downloads, in order
+	downloadSources []string
+	// downloadDestinations are the destination directories of the downloads, in order
+	downloadDestinations []string
+	// progressSequence are the bytes downloaded reported to the progress callback, out of progressTotal
+	progressSequence []int64
+	progressTotal    int64
 }
 
 func (m *NetworkDepStub) ListS3Folders(log log.T, amazonS3URL s3util.AmazonS3URL) (folderNames []string, err error) {
@@ -144,6 +194,14 @@ func (m *NetworkDepStub) ListS3Folders(log log.T, amazonS3URL s3util.AmazonS3URL
 }
 
 func (m *NetworkDepStub) Download(log log.T, input artifact.DownloadInput) (output artifact.DownloadOutput, err error) {
+	m.downloadCount++
+	m.downloadSources = append(m.downloadSources, input.SourceURL)
+	m.downloadDestinations = append(m.downloadDestinations, input.DestinationDirectory)
+	if input.Progress != nil {
+		for _, downloaded := range m.progressSequence {
+			input.Progress(downloaded, m.progressTotal)
+		}
+	}
 	if len(m.downloadResultSequence) > 0 {
 		result := m.downloadResultSequence[0]
 		error := m.downloadErrorSequence[0]
@@ -160,17 +218,23 @@ func (m *NetworkDepStub) Download(log log.T, input artifact.DownloadInput) (outp
 }
 
 type ExecDepStub struct {
-	execError    error
-	pluginInput  *model.PluginState
-	parseError   error
-	pluginOutput *contracts.PluginResult
+	execError            error
+	pluginInput          *model.PluginState
+	parseError           error
+	pluginOutput         *contracts.PluginResult
+	pluginOutputSequence []*contracts.PluginResult
+	parsedDirectories    []string
+	executedEnvironments []map[string]string
+	executedCommands     []string
 }
 
 func (m *ExecDepStub) ExeCommand(log log.T, cmd string, workingDir string, updaterRoot string, stdOut string, stdErr string, isAsync bool) (err error) {
+	m.executedCommands = append(m.executedCommands, cmd)
 	return m.execError
 }
 
 func (m *ExecDepStub) ParseDocument(context context.T, documentRaw []byte, orchestrationDir string, s3Bucket string, s3KeyPrefix string, messageID string, documentID string, defaultWorkingDirectory string) (pluginsInfo []model.PluginState, err error) {
+	m.parsedDirectories = append(m.parsedDirectories, defaultWorkingDirectory)
 	pluginsInfo = make([]model.PluginState, 0, 1)
 	if m.pluginInput != nil {
 		pluginsInfo = append(pluginsInfo, *m.pluginInput)
@@ -178,8 +242,14 @@ func (m *ExecDepStub) ParseDocument(context context.T, documentRaw []byte, orche
 	return pluginsInfo, m.parseError
 }
 
-func (m *ExecDepStub) ExecuteDocument(runner runpluginutil.PluginRunner, context context.T, pluginInput []model.PluginState, documentID string, documentCreatedDate string) (pluginOutputs map[string]*contracts.PluginResult) {
+func (m *ExecDepStub) ExecuteDocument(runner runpluginutil.PluginRunner, context context.T, pluginInput []model.PluginState, documentID string, documentCreatedDate string, environment map[string]string) (pluginOutputs map[string]*contracts.PluginResult) {
+	m.executedEnvironments = append(m.executedEnvironments, environment)
 	pluginOutputs = make(map[string]*contracts.PluginResult)
+	if len(m.pluginOutputSequence) > 0 {
+		pluginOutputs["test"] = m.pluginOutputSequence[0]
+		m.pluginOutputSequence = m.pluginOutputSequence[1:]
+		return
+	}
 	if m.pluginOutput != nil {
 		pluginOutputs["test"] = m.pluginOutput
 	}
@@ -195,8 +265,10 @@ func (configMock *MockedConfigurePackageManager) downloadPackage(context context
 	util configureUtil,
 	packageName string,
 	version string,
+	retry downloadRetryPolicy,
+	destination string,
 	output *contracts.PluginOutput) (filePath string, err error) {
-	args := configMock.Called(util, packageName, version, output)
+	args := configMock.Called(util, packageName, version, retry, destination, output)
 	return args.String(0), args.Error(1)
 }
 
@@ -208,7 +280,8 @@ func (configMock *MockedConfigurePackageManager) validateInput(context context.T
 
 func (configMock *MockedConfigurePackageManager) getVersionToInstall(context context.T,
 	input *ConfigurePackagePluginInput,
-	util configureUtil) (version string, installedVersion string, err error) {
+	util configureUtil,
+	instanceContext *updateutil.InstanceContext) (version string, installedVersion string, err error) {
 	args := configMock.Called(input, util)
 	ver := args.String(0)
 	if strings.HasPrefix(ver, "Wait") {
@@ -237,22 +310,38 @@ func (configMock *MockedConfigurePackageManager) setMark(context context.T, pack
 	return args.Error(0)
 }
 
+func (configMock *MockedConfigurePackageManager) getMark(context context.T, packageName string) string {
+	args := configMock.Called(packageName)
+	return args.String(0)
+}
+
 func (configMock *MockedConfigurePackageManager) clearMark(context context.T, packageName string) {
 	configMock.Called(packageName)
 }
 
+func (configMock *MockedConfigurePackageManager) setVersionMark(context context.T, packageName string, version string) error {
+	args := configMock.Called(packageName, version)
+	return args.Error(0)
+}
+
+func (configMock *MockedConfigurePackageManager) clearVersionMark(context context.T, packageName string, version string) {
+	configMock.Called(packageName, version)
+}
+
 func (configMock *MockedConfigurePackageManager) ensurePackage(context context.T,
 	util configureUtil,
 	packageName string,
 	version string,
+	retry downloadRetryPolicy,
 	output *contracts.PluginOutput) (manifest *PackageManifest, err error) {
-	args := configMock.Called(util, packageName, version, output)
+	args := configMock.Called(util, packageName, version, retry, output)
 	return args.Get(0).(*PackageManifest), args.Error(1)
 }
 
 func (configMock *MockedConfigurePackageManager) runUninstallPackagePre(context context.T,
 	packageName string,
 	version string,
+	arguments map[string]string,
 	output *contracts.PluginOutput) (status contracts.ResultStatus, err error) {
 	args := configMock.Called(packageName, version, output)
 	return args.Get(0).(contracts.ResultStatus), args.Error(1)
@@ -261,6 +350,7 @@ func (configMock *MockedConfigurePackageManager) runUninstallPackagePre(context
 func (configMock *MockedConfigurePackageManager) runInstallPackage(context context.T,
 	packageName string,
 	version string,
+	arguments map[string]string,
 	output *contracts.PluginOutput) (status contracts.ResultStatus, err error) {
 	args := configMock.Called(packageName, version, output)
 	return args.Get(0).(contracts.ResultStatus), args.Error(1)
@@ -282,12 +372,15 @@ func ConfigPackageSuccessMock(downloadFilePath string,
 	uninstallPreResult contracts.ResultStatus,
 	uninstallPostResult contracts.ResultStatus) *MockedConfigurePackageManager {
 	mockConfig := MockedConfigurePackageManager{}
-	mockConfig.On("downloadPackage", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(downloadFilePath, nil)
+	mockConfig.On("downloadPackage", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(downloadFilePath, nil)
 	mockConfig.On("validateInput", mock.Anything, mock.Anything).Return(true, nil)
 	mockConfig.On("getVersionToInstall", mock.Anything, mock.Anything, mock.Anything).Return(versionToActOn, versionCurrentlyInstalled, nil)
 	mockConfig.On("getVersionToUninstall", mock.Anything, mock.Anything, mock.Anything).Return(versionToActOn, nil)
 	mockConfig.On("setMark", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockConfig.On("getMark", mock.Anything).Return("")
 	mockConfig.On("clearMark", mock.Anything, mock.Anything)
+	mockConfig.On("setVersionMark", mock.Anything, mock.Anything).Return(nil)
+	mockConfig.On("clearVersionMark", mock.Anything, mock.Anything)
 	mockConfig.On("ensurePackage", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(packageManifest, nil)
 	mockConfig.On("runUninstallPackagePre", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(uninstallPreResult, nil)
 	mockConfig.On("runInstallPackage", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(installResult, nil)
@@ -10,12 +10,15 @@
 package pluginutil
 
 import (
+	"compress/gzip"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
@@ -61,6 +64,50 @@ type S3Uploader interface {
 	SetS3ClientRegion(region string)
 }
 
+// OutputUploader stores the output files of the plugins in place of their S3 uploader, to redirect the outputs to
+// another store. Implementations must be safe to call from multiple goroutines.
+type OutputUploader interface {
+	// UploadOutput stores content as the object objectKey of the bucket bucketName.
+	UploadOutput(log log.T, bucketName string, objectKey string, content io.ReadSeeker) error
+}
+
+var outputUploaderLock sync.RWMutex
+
+// outputUploader is the uploader set with SetOutputUploader, nil for the S3 uploader of the plugins
+var outputUploader OutputUploader
+
+// SetOutputUploader sets the uploader the plugins upload their outputs with, nil to upload them to S3.
+func SetOutputUploader(uploader OutputUploader) {
+	outputUploaderLock.Lock()
+	defer outputUploaderLock.Unlock()
+	outputUploader = uploader
+}
+
+// getOutputUploader returns the uploader set with SetOutputUploader
+func getOutputUploader() OutputUploader {
+	outputUploaderLock.RLock()
+	defer outputUploaderLock.RUnlock()
+	return outputUploader
+}
+
+// S3OutputUploader is the OutputUploader of S3, it finds the region of each bucket like the plugins uploading their
+// outputs do
+type S3OutputUploader struct{}
+
+// UploadOutput uploads content to S3, retrying in the region of the bucket if the bucket is in another region
+func (S3OutputUploader) UploadOutput(log log.T, bucketName string, objectKey string, content io.ReadSeeker) error {
+	manager := GetS3Config()
+	err := manager.S3UploadFromReader(bucketName, objectKey, content)
+	if err == nil || !manager.IsS3ErrorRelatedToWrongBucketRegion(err.Error()) {
+		return err
+	}
+	manager.SetS3ClientRegion(manager.GetS3BucketRegionFromErrorMsg(log, err.Error()))
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return manager.S3UploadFromReader(bucketName, objectKey, content)
+}
+
 // DefaultPlugin is the type for the default plugin.
 type DefaultPlugin struct {
 	// ExecuteCommand is an object that can execute commands.
@@ -184,47 +231,11 @@ func (p *DefaultPlugin) UploadOutputToS3Bucket(log log.T, pluginID string, orche
 	if outputS3BucketName != "" {
 		uploadOutputsToS3 := func() {
 			uploadToS3 := true
-			var testUploadError error
-
-			if region, err := platform.Region(); err == nil && region != s3Bjs {
-				p.Uploader.SetS3ClientRegion(S3RegionUSStandard)
-			}
-
-			log.Infof("uploading a test file to s3 bucket - %v , s3 key - %v with S3Client using region endpoint - %v",
-				outputS3BucketName,
-				outputS3KeyPrefix,
-				p.Uploader.GetS3ClientRegion())
-
-			testUploadError = p.Uploader.UploadS3TestFile(log, outputS3BucketName, outputS3KeyPrefix)
-
-			if testUploadError != nil {
-				//Check if the error is related to Access Denied - i.e missing permissions
-				if p.Uploader.IsS3ErrorRelatedToAccessDenied(testUploadError.Error()) {
-					log.Debugf("encountered access denied related error - can't upload to S3 due to missing permissions -%v", testUploadError.Error())
-					uploadToS3 = false
-					//since we don't have permissions - no S3 calls will go through no matter what
-				} else if p.Uploader.IsS3ErrorRelatedToWrongBucketRegion(testUploadError.Error()) { //check if error is related to different bucket region
-
-					log.Debugf("encountered error related to wrong bucket region while uploading test file to S3 - %v. parsing the message to get expected region",
-						testUploadError.Error())
-
-					expectedBucketRegion := p.Uploader.GetS3BucketRegionFromErrorMsg(log, testUploadError.Error())
-
-					//set the region to expectedBucketRegion
-					p.Uploader.SetS3ClientRegion(expectedBucketRegion)
-				} else {
-					log.Debugf("encountered unexpected error while uploading test file to S3 - %v, no need to modify s3client", testUploadError.Error())
-				}
-			} else { //there were no errors while uploading a test file to S3 - our s3client should continue to use "us-east-1"
-
-				log.Debugf("there were no errors while uploading a test file to S3 in region - %v. S3 client will continue to use region - %v",
-					S3RegionUSStandard,
-					p.Uploader.GetS3ClientRegion())
+			if getOutputUploader() == nil {
+				uploadToS3 = p.checkS3Upload(log, outputS3BucketName, outputS3KeyPrefix)
 			}
 
 			if uploadToS3 {
-				log.Infof("uploading logs to S3 with client configured to use region - %v", p.Uploader.GetS3ClientRegion())
-
 				if useTempDirectory {
 					// delete temp directory once we're done
 					defer func() {
@@ -238,8 +249,7 @@ func (p *DefaultPlugin) UploadOutputToS3Bucket(log log.T, pluginID string, orche
 					localPath := filepath.Join(orchestrationDir, p.StdoutFileName)
 
 					s3Key := fileutil.BuildS3Path(outputS3KeyPrefix, pluginID, p.StdoutFileName)
-					log.Debugf("Uploading %v to s3://%v/%v", localPath, outputS3BucketName, s3Key)
-					err := p.Uploader.S3Upload(outputS3BucketName, s3Key, localPath)
+					err := p.uploadOutputFile(log, outputS3BucketName, s3Key, localPath)
 					if err != nil {
 
 						log.Errorf("failed uploading %v to s3://%v/%v err:%v", localPath, outputS3BucketName, s3Key, err)
@@ -254,8 +264,7 @@ func (p *DefaultPlugin) UploadOutputToS3Bucket(log log.T, pluginID string, orche
 					localPath := filepath.Join(orchestrationDir, p.StderrFileName)
 
 					s3Key := fileutil.BuildS3Path(outputS3KeyPrefix, pluginID, p.StderrFileName)
-					log.Debugf("Uploading %v to s3://%v/%v", localPath, outputS3BucketName, s3Key)
-					err := p.Uploader.S3Upload(outputS3BucketName, s3Key, localPath)
+					err := p.uploadOutputFile(log, outputS3BucketName, s3Key, localPath)
 					if err != nil {
 						log.Errorf("failed uploading %v to s3://%v/%v err:%v", localPath, outputS3BucketName, s3Key, err)
 						if p.UploadToS3Sync {
@@ -280,6 +289,133 @@ func (p *DefaultPlugin) UploadOutputToS3Bucket(log log.T, pluginID string, orche
 	return uploadOutputToS3BucketErrors
 }
 
+// checkS3Upload uploads a test file to the bucket, switching the S3 client of the plugin to the region of the bucket
+// if needed. It returns false if the outputs can't be uploaded to the bucket.
+func (p *DefaultPlugin) checkS3Upload(log log.T, outputS3BucketName string, outputS3KeyPrefix string) (uploadToS3 bool) {
+	uploadToS3 = true
+	var testUploadError error
+
+	if region, err := platform.Region(); err == nil && region != s3Bjs {
+		p.Uploader.SetS3ClientRegion(S3RegionUSStandard)
+	}
+
+	log.Infof("uploading a test file to s3 bucket - %v , s3 key - %v with S3Client using region endpoint - %v",
+		outputS3BucketName,
+		outputS3KeyPrefix,
+		p.Uploader.GetS3ClientRegion())
+
+	testUploadError = p.Uploader.UploadS3TestFile(log, outputS3BucketName, outputS3KeyPrefix)
+
+	if testUploadError != nil {
+		//Check if the error is related to Access Denied - i.e missing permissions
+		if p.Uploader.IsS3ErrorRelatedToAccessDenied(testUploadError.Error()) {
+			log.Debugf("encountered access denied related error - can't upload to S3 due to missing permissions -%v", testUploadError.Error())
+			uploadToS3 = false
+			//since we don't have permissions - no S3 calls will go through no matter what
+		} else if p.Uploader.IsS3ErrorRelatedToWrongBucketRegion(testUploadError.Error()) { //check if error is related to different bucket region
+
+			log.Debugf("encountered error related to wrong bucket region while uploading test file to S3 - %v. parsing the message to get expected region",
+				testUploadError.Error())
+
+			expectedBucketRegion := p.Uploader.GetS3BucketRegionFromErrorMsg(log, testUploadError.Error())
+
+			//set the region to expectedBucketRegion
+			p.Uploader.SetS3ClientRegion(expectedBucketRegion)
+		} else {
+			log.Debugf("encountered unexpected error while uploading test file to S3 - %v, no need to modify s3client", testUploadError.Error())
+		}
+	} else { //there were no errors while uploading a test file to S3 - our s3client should continue to use "us-east-1"
+
+		log.Debugf("there were no errors while uploading a test file to S3 in region - %v. S3 client will continue to use region - %v",
+			S3RegionUSStandard,
+			p.Uploader.GetS3ClientRegion())
+	}
+	if uploadToS3 {
+		log.Infof("uploading logs to S3 with client configured to use region - %v", p.Uploader.GetS3ClientRegion())
+	}
+	return uploadToS3
+}
+
+// PowerShellExecutionPolicy returns the execution policy powershell scripts are run with, the default one when the
+// agent configuration can't be loaded
+func PowerShellExecutionPolicy(log log.T) string {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		log.Errorf("Failed to load the agent configuration, using the %v PowerShell execution policy: %v",
+			appconfig.DefaultPowerShellExecutionPolicy, err)
+		return appconfig.DefaultPowerShellExecutionPolicy
+	}
+	return config.Plugins.PowerShellExecutionPolicy
+}
+
+// s3OutputKeySuffix is the suffix of the keys the outputs are uploaded to S3 with, empty unless they are compressed.
+// The default suffix is used when the agent configuration can't be loaded.
+var s3OutputKeySuffix = func(log log.T) string {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		log.Errorf("Failed to load the agent configuration, using the default S3 output key suffix: %v", err)
+		return appconfig.DefaultConfig().S3OutputKeySuffix()
+	}
+	return config.S3OutputKeySuffix()
+}
+
+// uploadOutputFile uploads the file at localPath to s3Key. When the outputs are compressed a gzip copy of the file is
+// uploaded instead, to s3Key with the suffix of the compressed outputs, and the file itself stays uncompressed.
+func (p *DefaultPlugin) uploadOutputFile(log log.T, outputS3BucketName string, s3Key string, localPath string) error {
+	suffix := s3OutputKeySuffix(log)
+	if suffix == "" {
+		log.Debugf("Uploading %v to s3://%v/%v", localPath, outputS3BucketName, s3Key)
+		return p.uploadFile(log, outputS3BucketName, s3Key, localPath)
+	}
+	compressedPath, err := compressOutputFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to compress %v, %v", localPath, err)
+	}
+	defer os.Remove(compressedPath)
+	log.Debugf("Uploading %v compressed to s3://%v/%v%v", localPath, outputS3BucketName, s3Key, suffix)
+	return p.uploadFile(log, outputS3BucketName, s3Key+suffix, compressedPath)
+}
+
+// uploadFile uploads the file at localPath with the output uploader if one is set, with the S3 uploader of the plugin
+// otherwise
+func (p *DefaultPlugin) uploadFile(log log.T, outputS3BucketName string, s3Key string, localPath string) error {
+	uploader := getOutputUploader()
+	if uploader == nil {
+		return p.Uploader.S3Upload(outputS3BucketName, s3Key, localPath)
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return uploader.UploadOutput(log, outputS3BucketName, s3Key, file)
+}
+
+// compressOutputFile writes a gzip compressed copy of the file at filePath to a temporary file and returns its path
+func compressOutputFile(filePath string) (compressedPath string, err error) {
+	source, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+	compressed, err := ioutil.TempFile("", "output")
+	if err != nil {
+		return "", err
+	}
+	writer := gzip.NewWriter(compressed)
+	if _, err = io.Copy(writer, source); err == nil {
+		err = writer.Close()
+	}
+	if closeErr := compressed.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(compressed.Name())
+		return "", err
+	}
+	return compressed.Name(), nil
+}
+
 // CreateScriptFile creates a script containing the given commands.
 func CreateScriptFile(log log.T, scriptPath string, runCommand []string) (err error) {
 	var sourceFile *os.File
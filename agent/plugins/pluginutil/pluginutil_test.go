@@ -11,12 +11,20 @@ package pluginutil
 
 import (
 	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/platform"
+	"github.com/aws/amazon-ssm-agent/agent/s3util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -119,3 +127,90 @@ func TestValidateExecutionTimeout(t *testing.T) {
 	num = ValidateExecutionTimeout(logger, input)
 	assert.Equal(t, defaultExecutionTimeoutInSeconds, num)
 }
+
+func TestUploadOutputToS3BucketCompressed(t *testing.T) {
+	s3OutputKeySuffixOrig := s3OutputKeySuffix
+	defer func() { s3OutputKeySuffix = s3OutputKeySuffixOrig }()
+	s3OutputKeySuffix = func(log.T) string { return appconfig.CompressedS3OutputSuffix }
+	platform.SetRegion("us-west-2")
+
+	orchestrationDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationDir)
+	stdout := strings.Repeat("standard output of test case\n", 100)
+	stdoutPath := filepath.Join(orchestrationDir, "stdout")
+	assert.NoError(t, ioutil.WriteFile(stdoutPath, []byte(stdout), 0600))
+
+	logger := log.NewMockLog()
+	uploader := new(s3util.MockS3Uploader)
+	uploader.On("SetS3ClientRegion", mock.Anything).Return()
+	uploader.On("GetS3ClientRegion").Return("us-east-1")
+	uploader.On("UploadS3TestFile", logger, "bucket", "prefix").Return(nil)
+	var uploaded []byte
+	uploader.On("S3Upload", "bucket", "prefix/0.awsrunShellScript/stdout.gz", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		reader, err := os.Open(args.String(2))
+		assert.NoError(t, err)
+		defer reader.Close()
+		gzipReader, err := gzip.NewReader(reader)
+		assert.NoError(t, err)
+		uploaded, err = ioutil.ReadAll(gzipReader)
+		assert.NoError(t, err)
+	})
+	p := DefaultPlugin{Uploader: uploader, UploadToS3Sync: true, StdoutFileName: "stdout", StderrFileName: "stderr"}
+
+	errs := p.UploadOutputToS3Bucket(logger, "0.aws:runShellScript", orchestrationDir, "bucket", "prefix", false, "", stdout, "")
+
+	assert.Empty(t, errs)
+	uploader.AssertNumberOfCalls(t, "S3Upload", 1)
+	// the uploaded object is the compressed output, the output on disk stays uncompressed
+	assert.Equal(t, stdout, string(uploaded))
+	onDisk, err := ioutil.ReadFile(stdoutPath)
+	assert.NoError(t, err)
+	assert.Equal(t, stdout, string(onDisk))
+}
+
+// stubOutputUploader records the objects uploaded to it
+type stubOutputUploader struct {
+	bucket  string
+	objects map[string]string
+}
+
+func (u *stubOutputUploader) UploadOutput(log log.T, bucketName string, objectKey string, content io.ReadSeeker) error {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	u.bucket = bucketName
+	u.objects[objectKey] = string(data)
+	return nil
+}
+
+func TestUploadOutputToS3BucketOutputUploader(t *testing.T) {
+	s3OutputKeySuffixOrig := s3OutputKeySuffix
+	defer func() { s3OutputKeySuffix = s3OutputKeySuffixOrig }()
+	s3OutputKeySuffix = func(log.T) string { return "" }
+	outputUploader := &stubOutputUploader{objects: map[string]string{}}
+	SetOutputUploader(outputUploader)
+	defer SetOutputUploader(nil)
+
+	orchestrationDir, err := ioutil.TempDir("", "orchestration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(orchestrationDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(orchestrationDir, "stdout"), []byte("hello"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(orchestrationDir, "stderr"), []byte("oops"), 0600))
+
+	// the S3 uploader of the plugin is not called, not even for the test file
+	uploader := new(s3util.MockS3Uploader)
+	p := DefaultPlugin{Uploader: uploader, UploadToS3Sync: true, StdoutFileName: "stdout", StderrFileName: "stderr"}
+
+	errs := p.UploadOutputToS3Bucket(log.NewMockLog(), "0.aws:runShellScript", orchestrationDir, "bucket", "prefix", false, "", "hello", "oops")
+
+	assert.Empty(t, errs)
+	assert.Equal(t, "bucket", outputUploader.bucket)
+	assert.Equal(t, map[string]string{
+		"prefix/0.awsrunShellScript/stdout": "hello",
+		"prefix/0.awsrunShellScript/stderr": "oops",
+	}, outputUploader.objects)
+	uploader.AssertNotCalled(t, "UploadS3TestFile", mock.Anything, mock.Anything, mock.Anything)
+	uploader.AssertNotCalled(t, "S3Upload", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -16,6 +16,7 @@ package pluginutil
 import (
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 )
 
@@ -46,6 +47,6 @@ func GetShellCommand() string {
 	return ShellCommand
 }
 
-func GetShellArguments() []string {
+func GetShellArguments(log log.T) []string {
 	return ShellArgs
 }
@@ -20,6 +20,7 @@ import (
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 )
 
@@ -49,6 +50,6 @@ func GetShellCommand() string {
 	return PowerShellCommand
 }
 
-func GetShellArguments() []string {
-	return strings.Split(appconfig.PowerShellPluginCommandArgs, " ")
+func GetShellArguments(log log.T) []string {
+	return strings.Split(appconfig.PowerShellPluginCommandArgs(PowerShellExecutionPolicy(log)), " ")
 }
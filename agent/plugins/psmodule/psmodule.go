@@ -191,7 +191,7 @@ func (p *Plugin) runCommands(log log.T, pluginInput PSModulePluginInput, orchest
 
 	// Construct Command Name and Arguments
 	commandName := pluginutil.GetShellCommand()
-	commandArguments := append(pluginutil.GetShellArguments(), scriptPath, appconfig.ExitCodeTrap)
+	commandArguments := append(pluginutil.GetShellArguments(log), scriptPath, appconfig.ExitCodeTrap)
 
 	// Execute Command
 	stdout, stderr, exitCode, errs := p.CommandExecuter.Execute(log, pluginInput.WorkingDirectory, stdoutFilePath, stderrFilePath, cancelFlag, executionTimeout, commandName, commandArguments)
@@ -19,6 +19,7 @@ import (
 	"strings"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/plugins/pluginutil"
 )
 
@@ -31,13 +32,13 @@ type runPowerShellPlugin struct {
 }
 
 // NewRunPowerShellPlugin returns a new instance of the PSPlugin.
-func NewRunPowerShellPlugin(pluginConfig pluginutil.PluginConfig) (*runPowerShellPlugin, error) {
+func NewRunPowerShellPlugin(log log.T, pluginConfig pluginutil.PluginConfig) (*runPowerShellPlugin, error) {
 	psplugin := runPowerShellPlugin{
 		Plugin{
 			Name:           appconfig.PluginNameAwsRunPowerShellScript,
 			ScriptName:     powerShellScriptName,
 			ShellCommand:   appconfig.PowerShellPluginCommandName,
-			ShellArguments: strings.Split(appconfig.PowerShellPluginCommandArgs, " "),
+			ShellArguments: strings.Split(appconfig.PowerShellPluginCommandArgs(pluginutil.PowerShellExecutionPolicy(log)), " "),
 		},
 	}
 
@@ -135,9 +135,9 @@ func testRunScripts(t *testing.T, testCase TestCase, rawInput bool) {
 			err := jsonutil.Remarshal(testCase.Input, &rawPluginInput)
 			assert.Nil(t, err)
 
-			res = p.runCommandsRawInput(logger, rawPluginInput, orchestrationDirectory, mockCancelFlag, s3BucketName, s3KeyPrefix)
+			res = p.runCommandsRawInput(logger, rawPluginInput, orchestrationDirectory, mockCancelFlag, s3BucketName, s3KeyPrefix, nil)
 		} else {
-			res = p.runCommands(logger, testCase.Input, orchestrationDirectory, mockCancelFlag, s3BucketName, s3KeyPrefix)
+			res = p.runCommands(logger, testCase.Input, orchestrationDirectory, mockCancelFlag, s3BucketName, s3KeyPrefix, nil)
 		}
 
 		// assert output is correct (mocked object expectations are tested automatically by testExecution)
@@ -166,7 +166,7 @@ func testBucketsInDifferentRegions(t *testing.T, testCase TestCase, testingBucke
 
 		// call method under test
 		var res contracts.PluginOutput
-		res = p.runCommands(logger, testCase.Input, orchestrationDirectory, mockCancelFlag, s3BucketName, s3KeyPrefix)
+		res = p.runCommands(logger, testCase.Input, orchestrationDirectory, mockCancelFlag, s3BucketName, s3KeyPrefix, nil)
 
 		// assert output is correct (mocked object expectations are tested automatically by testExecution)
 		assert.Equal(t, testCase.Output, res)
@@ -16,7 +16,10 @@ package reply
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/log"
@@ -25,47 +28,26 @@ import (
 )
 
 // PrepareReplyPayload creates the payload object for SendReply based on plugin outputs.
+// skippedPluginsFailDocument counts the skipped plugins as failed in the document status.
 func PrepareReplyPayload(pluginID string,
 	runtimeStatuses map[string]*contracts.PluginRuntimeStatus,
 	dateTime time.Time,
 	agentInfo contracts.AgentInfo,
-	buildPayloadWithPluginName bool) (payload messageContracts.SendReplyPayload) {
+	buildPayloadWithPluginName bool,
+	skippedPluginsFailDocument bool) (payload messageContracts.SendReplyPayload) {
 
 	// TODO instance this needs to be revised to be in parity with ec2config
-	documentStatus := contracts.ResultStatusSuccess
 	var runtimeStatusCounts = map[string]int{}
 	pluginCounts := len(runtimeStatuses)
 
 	for _, pluginResult := range runtimeStatuses {
-		if pluginResult.Status == contracts.ResultStatusFailed {
-			documentStatus = contracts.ResultStatusFailed
-		}
 		runtimeStatusCounts[string(pluginResult.Status)]++
 	}
-
-	//	  New precedence order of plugin states
-	//	  Failed > TimedOut > Cancelled > Success > Cancelling > InProgress > Pending
-	//	  The above order is a contract between SSM service and agent and hence for the calculation of aggregate
-	//	  status of a (command) document, we follow the above precedence order.
-	//
-	//	  Note:
-	//	  A command could have been failed/cancelled even before a plugin started executing, during which pendingItems > 0
-	//	  but overallResult.Status would be Failed/Cancelled. That's the reason we check for OverallResult status along
-	//	  with number of failed/cancelled items.
-	//    TODO : We need to handle above to be able to send document traceoutput in case of document level errors.
-
-	if runtimeStatusCounts[string(contracts.ResultStatusSuccessAndReboot)] > 0 {
-		documentStatus = contracts.ResultStatusSuccessAndReboot
-	} else if runtimeStatusCounts[string(contracts.ResultStatusFailed)] > 0 {
-		documentStatus = contracts.ResultStatusFailed
-	} else if runtimeStatusCounts[string(contracts.ResultStatusTimedOut)] > 0 {
-		documentStatus = contracts.ResultStatusTimedOut
-	} else if runtimeStatusCounts[string(contracts.ResultStatusCancelled)] > 0 {
-		documentStatus = contracts.ResultStatusCancelled
-	} else if runtimeStatusCounts[string(contracts.ResultStatusSuccess)] == pluginCounts {
-		documentStatus = contracts.ResultStatusSuccess
-	} else {
-		documentStatus = contracts.ResultStatusInProgress
+	documentStatus := AggregateDocumentStatus(contracts.AggregationPolicyAllMustSucceed, skippedPluginsFailDocument, runtimeStatusCounts, pluginCounts)
+	documentTraceOutput := ""
+	if documentStatus == contracts.ResultStatusFailed {
+		// summarized before the plugins are renamed, to name the failed plugin by its ID
+		documentTraceOutput = failedPluginTraceOutput(runtimeStatuses)
 	}
 
 	// RunCommand still requires to use plugin name as the Id, this will be cleaned during next release
@@ -96,12 +78,107 @@ func PrepareReplyPayload(pluginID string,
 			RuntimeStatusCounts: runtimeStatusCounts,
 		},
 		DocumentStatus:      documentStatus,
-		DocumentTraceOutput: "", // TODO: Fill me appropriately
+		DocumentTraceOutput: documentTraceOutput,
 		RuntimeStatus:       runtimeStatusesFiltered,
 	}
 	return
 }
 
+// maxDocumentTraceOutputBytes bounds the summary of the failed plugin in the trace output of a document
+const maxDocumentTraceOutputBytes = 500
+
+// failedPluginTraceOutput summarizes the first plugin that failed, by start time then plugin ID, with the first line of
+// its standard error, or of its output if it has no standard error. It is empty if no plugin failed.
+func failedPluginTraceOutput(runtimeStatuses map[string]*contracts.PluginRuntimeStatus) string {
+	failedPluginID := ""
+	var failed *contracts.PluginRuntimeStatus
+	for pluginID, runtimeStatus := range runtimeStatuses {
+		if runtimeStatus == nil || runtimeStatus.Status != contracts.ResultStatusFailed {
+			continue
+		}
+		if failed == nil || runtimeStatus.StartDateTime < failed.StartDateTime ||
+			(runtimeStatus.StartDateTime == failed.StartDateTime && pluginID < failedPluginID) {
+			failedPluginID, failed = pluginID, runtimeStatus
+		}
+	}
+	if failed == nil {
+		return ""
+	}
+
+	output := failed.Output
+	if i := strings.Index(output, contracts.ErrorOutputTitle); i >= 0 {
+		output = output[i+len(contracts.ErrorOutputTitle):]
+	}
+	output = strings.TrimSpace(output)
+	if i := strings.Index(output, "\n"); i >= 0 {
+		output = strings.TrimSpace(output[:i])
+	}
+
+	traceOutput := fmt.Sprintf("plugin %v failed", failedPluginID)
+	if output != "" {
+		traceOutput += ": " + output
+	}
+	return truncateOutput(traceOutput, maxDocumentTraceOutputBytes)
+}
+
+// AggregateDocumentStatus computes the status of a document from the number of its plugins in each status, following
+// the aggregation policy of the document. AggregationPolicyAllMustSucceed is used for unknown policies.
+// Skipped plugins are left out, so a document whose other plugins succeeded succeeds, unless skippedPluginsFailDocument
+// counts them as failed.
+func AggregateDocumentStatus(policy string, skippedPluginsFailDocument bool, runtimeStatusCounts map[string]int, pluginCounts int) contracts.ResultStatus {
+	if skipped := runtimeStatusCounts[string(contracts.ResultStatusSkipped)]; skipped > 0 {
+		// the counts are reported as they are, they are only changed for the aggregation
+		counts := make(map[string]int, len(runtimeStatusCounts))
+		for status, count := range runtimeStatusCounts {
+			counts[status] = count
+		}
+		delete(counts, string(contracts.ResultStatusSkipped))
+		if skippedPluginsFailDocument {
+			counts[string(contracts.ResultStatusFailed)] += skipped
+		} else {
+			pluginCounts -= skipped
+		}
+		runtimeStatusCounts = counts
+	}
+
+	if policy == contracts.AggregationPolicyAnySucceeds {
+		completed := runtimeStatusCounts[string(contracts.ResultStatusSuccess)] +
+			runtimeStatusCounts[string(contracts.ResultStatusFailed)] +
+			runtimeStatusCounts[string(contracts.ResultStatusTimedOut)] +
+			runtimeStatusCounts[string(contracts.ResultStatusCancelled)]
+		if runtimeStatusCounts[string(contracts.ResultStatusSuccessAndReboot)] == 0 &&
+			runtimeStatusCounts[string(contracts.ResultStatusSuccess)] > 0 &&
+			completed == pluginCounts {
+			return contracts.ResultStatusSuccess
+		}
+	}
+
+	//	  New precedence order of plugin states
+	//	  Failed > TimedOut > Cancelled > Success > Cancelling > InProgress > Pending
+	//	  The above order is a contract between SSM service and agent and hence for the calculation of aggregate
+	//	  status of a (command) document, we follow the above precedence order.
+	//
+	//	  Note:
+	//	  A command could have been failed/cancelled even before a plugin started executing, during which pendingItems > 0
+	//	  but overallResult.Status would be Failed/Cancelled. That's the reason we check for OverallResult status along
+	//	  with number of failed/cancelled items.
+
+	switch {
+	case runtimeStatusCounts[string(contracts.ResultStatusSuccessAndReboot)] > 0:
+		return contracts.ResultStatusSuccessAndReboot
+	case runtimeStatusCounts[string(contracts.ResultStatusFailed)] > 0:
+		return contracts.ResultStatusFailed
+	case runtimeStatusCounts[string(contracts.ResultStatusTimedOut)] > 0:
+		return contracts.ResultStatusTimedOut
+	case runtimeStatusCounts[string(contracts.ResultStatusCancelled)] > 0:
+		return contracts.ResultStatusCancelled
+	case runtimeStatusCounts[string(contracts.ResultStatusSuccess)] == pluginCounts:
+		return contracts.ResultStatusSuccess
+	default:
+		return contracts.ResultStatusInProgress
+	}
+}
+
 // PrepareRuntimeStatuses creates runtime statuses from plugin outputs.
 // contracts.PluginResult and contracts.PluginRuntimeStatus are mostly same.
 // however they are decoupled on purpose so that we can do any special handling / serializing when sending response to server side.
@@ -132,6 +209,7 @@ func prepareRuntimeStatus(log log.T, pluginResult contracts.PluginResult) contra
 		Output:        resultAsString,
 		StartDateTime: times.ToIso8601UTC(pluginResult.StartDateTime),
 		EndDateTime:   times.ToIso8601UTC(pluginResult.EndDateTime),
+		CorrelationID: pluginResult.CorrelationID,
 	}
 
 	if pluginResult.OutputS3BucketName != "" {
@@ -139,6 +217,7 @@ func prepareRuntimeStatus(log log.T, pluginResult contracts.PluginResult) contra
 		if pluginResult.OutputS3KeyPrefix != "" {
 			runtimeStatus.OutputS3KeyPrefix = pluginResult.OutputS3KeyPrefix
 		}
+		runtimeStatus.OutputS3KeySuffix = pluginResult.OutputS3KeySuffix
 	}
 
 	if runtimeStatus.Status == contracts.ResultStatusFailed && runtimeStatus.Code == 0 {
@@ -147,3 +226,48 @@ func prepareRuntimeStatus(log log.T, pluginResult contracts.PluginResult) contra
 
 	return runtimeStatus
 }
+
+// truncatedOutputMarker replaces the end of an output cut to fit in a reply, with the number of bytes removed
+const truncatedOutputMarker = "\n[truncated %v bytes]"
+
+// TruncateRuntimeStatuses cuts the output of each runtime status to maxPluginOutputBytes, then cuts the outputs further,
+// in the order of their plugin IDs, so that together they fit in maxPayloadOutputBytes.
+// The truncation markers are not counted against the limits.
+func TruncateRuntimeStatuses(runtimeStatuses map[string]*contracts.PluginRuntimeStatus, maxPluginOutputBytes int, maxPayloadOutputBytes int) {
+	pluginIDs := make([]string, 0, len(runtimeStatuses))
+	for pluginID, runtimeStatus := range runtimeStatuses {
+		if runtimeStatus != nil {
+			pluginIDs = append(pluginIDs, pluginID)
+		}
+	}
+	sort.Strings(pluginIDs)
+
+	remaining := maxPayloadOutputBytes
+	for _, pluginID := range pluginIDs {
+		runtimeStatus := runtimeStatuses[pluginID]
+		limit := maxPluginOutputBytes
+		if remaining < limit {
+			limit = remaining
+		}
+		if len(runtimeStatus.Output) < limit {
+			limit = len(runtimeStatus.Output)
+		}
+		runtimeStatus.Output = truncateOutput(runtimeStatus.Output, limit)
+		remaining -= limit
+	}
+}
+
+// truncateOutput cuts the output to at most maxBytes, without splitting a character, and appends the truncation marker
+func truncateOutput(output string, maxBytes int) string {
+	if len(output) <= maxBytes {
+		return output
+	}
+	cut := maxBytes
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return output[:cut] + fmt.Sprintf(truncatedOutputMarker, len(output)-cut)
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,13 +59,56 @@ func TestPrepareReplyPayload(t *testing.T) {
 	// run test cases
 	for _, tst := range testCases {
 		// call our method under test
-		docResult := PrepareReplyPayload("", tst.PluginRuntimeStatuses, tst.DateTime, tst.Agent, true)
+		docResult := PrepareReplyPayload("", tst.PluginRuntimeStatuses, tst.DateTime, tst.Agent, true, false)
 
 		// check result
 		assert.Equal(t, tst.Result, docResult)
 	}
 }
 
+func TestPrepareReplyPayloadFailedPluginTraceOutput(t *testing.T) {
+	runtimeStatuses := map[string]*contracts.PluginRuntimeStatus{
+		"configure": {
+			Name:          "aws:runShellScript",
+			Status:        contracts.ResultStatusSuccess,
+			Output:        "configured",
+			StartDateTime: "2017-03-01T00:00:00.000Z",
+		},
+		"install": {
+			Name:          "aws:runShellScript",
+			Status:        contracts.ResultStatusFailed,
+			Code:          127,
+			Output:        "installing" + contracts.ErrorOutputTitle + "yum-install: command not found\nfailed to run commands: exit status 127",
+			StartDateTime: "2017-03-01T00:00:01.000Z",
+		},
+		"verify": {
+			Name:          "aws:runShellScript",
+			Status:        contracts.ResultStatusFailed,
+			Output:        contracts.ErrorOutputTitle + "not installed",
+			StartDateTime: "2017-03-01T00:00:02.000Z",
+		},
+	}
+
+	payload := PrepareReplyPayload("", runtimeStatuses, time.Now(), contracts.AgentInfo{}, true, false)
+
+	assert.Equal(t, contracts.ResultStatusFailed, payload.DocumentStatus)
+	assert.Equal(t, "plugin install failed: yum-install: command not found", payload.DocumentTraceOutput)
+
+	// the summary is bounded
+	runtimeStatuses = map[string]*contracts.PluginRuntimeStatus{
+		"install": {
+			Name:   "aws:runShellScript",
+			Status: contracts.ResultStatusFailed,
+			Output: contracts.ErrorOutputTitle + strings.Repeat("e", 2*maxDocumentTraceOutputBytes),
+		},
+	}
+
+	payload = PrepareReplyPayload("", runtimeStatuses, time.Now(), contracts.AgentInfo{}, true, false)
+
+	assert.True(t, strings.HasPrefix(payload.DocumentTraceOutput, "plugin install failed: eee"))
+	assert.True(t, len(payload.DocumentTraceOutput) < maxDocumentTraceOutputBytes+len("\n[truncated 1000 bytes]"))
+}
+
 func TestPrepareRuntimeStatus(t *testing.T) {
 	type testCase struct {
 		Input  contracts.PluginResult
@@ -102,6 +146,56 @@ func TestPrepareRuntimeStatus(t *testing.T) {
 	return
 }
 
+func TestPrepareRuntimeStatusCorrelationID(t *testing.T) {
+	// the correlation ID is sent along with the plugin runtime status
+	runtimeStatus := prepareRuntimeStatus(logger, contracts.PluginResult{CorrelationID: "aws.ssm.1234.i-400e1090"})
+	assert.Equal(t, "aws.ssm.1234.i-400e1090", runtimeStatus.CorrelationID)
+	payload, err := json.Marshal(runtimeStatus)
+	assert.NoError(t, err)
+	assert.Contains(t, string(payload), `"correlationId":"aws.ssm.1234.i-400e1090"`)
+
+	// and left out of the payload when there is none
+	payload, err = json.Marshal(prepareRuntimeStatus(logger, contracts.PluginResult{}))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(payload), "correlationId")
+}
+
+func TestPrepareRuntimeStatusOutputS3KeySuffix(t *testing.T) {
+	// compressed outputs are referenced with the suffix of their keys
+	runtimeStatus := prepareRuntimeStatus(logger, contracts.PluginResult{
+		OutputS3BucketName: "bucket",
+		OutputS3KeyPrefix:  "prefix/aws:runShellScript",
+		OutputS3KeySuffix:  ".gz",
+	})
+	assert.Equal(t, "prefix/aws:runShellScript", runtimeStatus.OutputS3KeyPrefix)
+	assert.Equal(t, ".gz", runtimeStatus.OutputS3KeySuffix)
+
+	// and uncompressed ones without it
+	payload, err := json.Marshal(prepareRuntimeStatus(logger, contracts.PluginResult{OutputS3BucketName: "bucket"}))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(payload), "outputS3KeySuffix")
+}
+
+func TestTruncateRuntimeStatuses(t *testing.T) {
+	runtimeStatuses := map[string]*contracts.PluginRuntimeStatus{
+		"plugin1": {Output: strings.Repeat("a", 100)},
+		"plugin2": {Output: strings.Repeat("b", 100)},
+		"plugin3": {Output: "short"},
+	}
+
+	// each output is cut to 60 bytes, and the last plugins share what is left of the 100 bytes of the payload
+	TruncateRuntimeStatuses(runtimeStatuses, 60, 100)
+	assert.Equal(t, strings.Repeat("a", 60)+"\n[truncated 40 bytes]", runtimeStatuses["plugin1"].Output)
+	assert.Equal(t, strings.Repeat("b", 40)+"\n[truncated 60 bytes]", runtimeStatuses["plugin2"].Output)
+	assert.Equal(t, "\n[truncated 5 bytes]", runtimeStatuses["plugin3"].Output)
+}
+
+func TestTruncateOutputKeepsCharacters(t *testing.T) {
+	// a multi-byte character is dropped rather than split
+	assert.Equal(t, "ab\n[truncated 3 bytes]", truncateOutput("abéc", 3))
+	assert.Equal(t, "abéc", truncateOutput("abéc", 5))
+}
+
 func parsePluginResult(t *testing.T, pluginRuntimeStatus contracts.PluginRuntimeStatus) contracts.PluginResult {
 	parsedOutput := pluginRuntimeStatus.Output
 	return contracts.PluginResult{
@@ -129,3 +223,44 @@ func loadMessageReplyFromFile(t *testing.T, fileName string) (message messageCon
 	}
 	return message
 }
+
+func TestAggregateDocumentStatus(t *testing.T) {
+	type testCase struct {
+		Statuses       []contracts.ResultStatus
+		AllMustSucceed contracts.ResultStatus
+		AnySucceeds    contracts.ResultStatus
+	}
+	testCases := []testCase{
+		{[]contracts.ResultStatus{contracts.ResultStatusSuccess, contracts.ResultStatusSuccess}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess},
+		{[]contracts.ResultStatus{contracts.ResultStatusSuccess, contracts.ResultStatusFailed}, contracts.ResultStatusFailed, contracts.ResultStatusSuccess},
+		{[]contracts.ResultStatus{contracts.ResultStatusFailed, contracts.ResultStatusTimedOut, contracts.ResultStatusSuccess}, contracts.ResultStatusFailed, contracts.ResultStatusSuccess},
+		{[]contracts.ResultStatus{contracts.ResultStatusFailed, contracts.ResultStatusFailed}, contracts.ResultStatusFailed, contracts.ResultStatusFailed},
+		{[]contracts.ResultStatus{contracts.ResultStatusCancelled, contracts.ResultStatusTimedOut}, contracts.ResultStatusTimedOut, contracts.ResultStatusTimedOut},
+		// a document isn't reported as succeeded before all of its plugins completed
+		{[]contracts.ResultStatus{contracts.ResultStatusSuccess, contracts.ResultStatusInProgress}, contracts.ResultStatusInProgress, contracts.ResultStatusInProgress},
+		{[]contracts.ResultStatus{contracts.ResultStatusSuccess, contracts.ResultStatusSuccessAndReboot}, contracts.ResultStatusSuccessAndReboot, contracts.ResultStatusSuccessAndReboot},
+		// skipped plugins are neither successes nor failures
+		{[]contracts.ResultStatus{contracts.ResultStatusSuccess, contracts.ResultStatusSkipped}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess},
+		{[]contracts.ResultStatus{contracts.ResultStatusFailed, contracts.ResultStatusSkipped}, contracts.ResultStatusFailed, contracts.ResultStatusFailed},
+		{[]contracts.ResultStatus{contracts.ResultStatusSkipped, contracts.ResultStatusSkipped}, contracts.ResultStatusSuccess, contracts.ResultStatusSuccess},
+		{[]contracts.ResultStatus{contracts.ResultStatusSkipped, contracts.ResultStatusInProgress}, contracts.ResultStatusInProgress, contracts.ResultStatusInProgress},
+	}
+
+	for _, tst := range testCases {
+		runtimeStatusCounts := map[string]int{}
+		for _, status := range tst.Statuses {
+			runtimeStatusCounts[string(status)]++
+		}
+		assert.Equal(t, tst.AllMustSucceed, AggregateDocumentStatus(contracts.AggregationPolicyAllMustSucceed, false, runtimeStatusCounts, len(tst.Statuses)), "%v", tst.Statuses)
+		assert.Equal(t, tst.AllMustSucceed, AggregateDocumentStatus("", false, runtimeStatusCounts, len(tst.Statuses)), "%v", tst.Statuses)
+		assert.Equal(t, tst.AnySucceeds, AggregateDocumentStatus(contracts.AggregationPolicyAnySucceeds, false, runtimeStatusCounts, len(tst.Statuses)), "%v", tst.Statuses)
+	}
+}
+
+func TestAggregateDocumentStatusSkippedPluginsFailDocument(t *testing.T) {
+	runtimeStatusCounts := map[string]int{string(contracts.ResultStatusSuccess): 2, string(contracts.ResultStatusSkipped): 1}
+	assert.Equal(t, contracts.ResultStatusFailed, AggregateDocumentStatus(contracts.AggregationPolicyAllMustSucceed, true, runtimeStatusCounts, 3))
+	assert.Equal(t, contracts.ResultStatusSuccess, AggregateDocumentStatus(contracts.AggregationPolicyAnySucceeds, true, runtimeStatusCounts, 3))
+	// the reported counts are left untouched
+	assert.Equal(t, map[string]int{string(contracts.ResultStatusSuccess): 2, string(contracts.ResultStatusSkipped): 1}, runtimeStatusCounts)
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package statemanager helps persist documents state to disk
+package statemanager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// gzipMagic are the first bytes of a gzip stream, used to detect the compressed state files
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// stateFilesCompression is whether the state files are compressed, read from the configuration by the first write
+var stateFilesCompression struct {
+	once     sync.Once
+	compress bool
+}
+
+// compressStateFiles returns true if the state files are persisted gzipped, the default when the agent configuration
+// can't be loaded
+var compressStateFiles = func(log log.T) bool {
+	stateFilesCompression.once.Do(func() {
+		config, err := appconfig.Config(false)
+		if err != nil {
+			log.Errorf("Failed to load the agent configuration, using the default compression of the state files: %v", err)
+			config = appconfig.DefaultConfig()
+		}
+		stateFilesCompression.compress = config.Mds.CompressStateFiles
+	})
+	return stateFilesCompression.compress
+}
+
+// writeDocStateFile writes the json content of a state file, gzipped if the state files are compressed
+func writeDocStateFile(log log.T, absoluteFileName, content string) (result bool, err error) {
+	if compressStateFiles(log) {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err = writer.Write([]byte(content)); err != nil {
+			return false, err
+		}
+		if err = writer.Close(); err != nil {
+			return false, err
+		}
+		content = compressed.String()
+	}
+	return fileutil.WriteIntoFileWithPermissions(absoluteFileName, content, os.FileMode(int(appconfig.ReadWriteAccess)))
+}
+
+// readDocStateFile unmarshals the state file in dest. The file is decompressed if it is gzipped, whether or not the
+// state files are currently compressed, so that the files persisted before a change of the setting are still read.
+func readDocStateFile(absoluteFileName string, dest interface{}) (err error) {
+	content, err := ioutil.ReadFile(absoluteFileName)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(content, gzipMagic) {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		if content, err = ioutil.ReadAll(reader); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(content, dest)
+}
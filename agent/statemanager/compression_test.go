@@ -0,0 +1,82 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package statemanager helps persist documents state to disk
+package statemanager
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// sampleDocumentState returns a document state with a plugin
+func sampleDocumentState() model.DocumentState {
+	var docState model.DocumentState
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.InstanceID = testInstanceID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSuccess
+	docState.InstancePluginsInformation = []model.PluginState{{Name: "aws:runShellScript", Id: "plugin1"}}
+	return docState
+}
+
+func TestPersistDataCompressedRoundTrip(t *testing.T) {
+	defer setupDocumentStateDirs(t)()
+	compressStateFilesOrig := compressStateFiles
+	defer func() { compressStateFiles = compressStateFilesOrig }()
+	compressStateFiles = func(log.T) bool { return true }
+
+	docState := sampleDocumentState()
+	PersistData(logger, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	content, err := ioutil.ReadFile(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(content, gzipMagic))
+	assert.Equal(t, docState, GetDocumentInterimState(logger, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+
+	// compressed files are still read once the compression is disabled
+	compressStateFiles = func(log.T) bool { return false }
+	assert.Equal(t, docState, GetDocumentInterimState(logger, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+}
+
+func TestGetDocumentInterimStateUncompressed(t *testing.T) {
+	defer setupDocumentStateDirs(t)()
+	compressStateFilesOrig := compressStateFiles
+	defer func() { compressStateFiles = compressStateFilesOrig }()
+	compressStateFiles = func(log.T) bool { return true }
+
+	// a state file persisted by an agent that didn't compress them
+	legacyState := `{
+  "DocumentInformation": {
+    "DocumentID": "aws.ssm.1234.i-400e1090",
+    "InstanceID": "i-400e1090",
+    "DocumentStatus": "Success"
+  },
+  "InstancePluginsInformation": [
+    {
+      "Name": "aws:runShellScript",
+      "Id": "plugin1"
+    }
+  ]
+}`
+	assert.NoError(t, fileutil.WriteAllText(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent), legacyState))
+
+	assert.Equal(t, sampleDocumentState(), GetDocumentInterimState(logger, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+}
@@ -15,6 +15,8 @@
 package model
 
 import (
+	"fmt"
+	"os"
 	"strings"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
@@ -28,19 +30,68 @@ type managedInstanceDocumentProperties struct {
 	ID         string
 }
 
-type documentListType map[string]bool
+// RegionPlaceholder is replaced with the region of the instance in the replacement of a command rewrite
+const RegionPlaceholder = "{{region}}"
 
-var managedInstanceIncompatibleAWSSSMDocuments documentListType
+// ManagedInstanceCompatibility lists the documents that contain code incompatible with managed instances
+// and the rewrites applied to the commands of these documents.
+type ManagedInstanceCompatibility struct {
+	IncompatibleDocuments []string
+	Rewrites              []CommandRewrite
+}
+
+// CommandRewrite replaces the first occurrence of Match in a command with Replacement.
+type CommandRewrite struct {
+	Match       string
+	Replacement string
+}
+
+// builtInCompatibility covers the AWS SSM public documents that read the region from the instance metadata
+var builtInCompatibility = ManagedInstanceCompatibility{
+	IncompatibleDocuments: []string{
+		"AWS-ConfigureWindowsUpdate",
+		"AWS-FindWindowsUpdates",
+		"AWS-InstallMissingWindowsUpdates",
+		"AWS-InstallSpecificWindowsUpdates",
+		"AWS-ListWindowsInventory",
+	},
+	Rewrites: []CommandRewrite{
+		// remove the call to metadata service to retrieve the region for onprem instances
+		{
+			Match:       "$metadataLocation = 'http://169.254.169.254/latest/dynamic/instance-identity/document/region'",
+			Replacement: "# $metadataLocation = 'http://169.254.169.254/latest/dynamic/instance-identity/document/region' (This is done to make it managed instance compatible)",
+		},
+		{
+			Match:       "$metadata = (New-Object Net.WebClient).DownloadString($metadataLocation)",
+			Replacement: "# $metadata = (New-Object Net.WebClient).DownloadString($metadataLocation) (This is done to make it managed instance compatible)",
+		},
+		{
+			Match:       "$region = (ConvertFrom-JSON $metadata).region",
+			Replacement: "$region = '" + RegionPlaceholder + "'",
+		},
+	},
+}
 
-func init() {
-	var documentList = documentListType{}
-	documentList["AWS-ConfigureWindowsUpdate"] = true
-	documentList["AWS-FindWindowsUpdates"] = true
-	documentList["AWS-InstallMissingWindowsUpdates"] = true
-	documentList["AWS-InstallSpecificWindowsUpdates"] = true
-	documentList["AWS-ListWindowsInventory"] = true
+var getRegion = platform.Region
 
-	managedInstanceIncompatibleAWSSSMDocuments = documentList
+// managedInstanceCompatibilityPath is the custom config merged with the built-in compatibility
+var managedInstanceCompatibilityPath = appconfig.ManagedInstanceCompatibilityPath
+
+// getManagedInstanceCompatibility returns the built-in compatibility, extended with the documents and rewrites of the
+// custom config if there is one. The built-in compatibility is returned along with the error if the config can't be read.
+func getManagedInstanceCompatibility() (compatibility ManagedInstanceCompatibility, err error) {
+	compatibility = builtInCompatibility
+	if _, err = os.Stat(managedInstanceCompatibilityPath); os.IsNotExist(err) {
+		return compatibility, nil
+	}
+
+	var custom ManagedInstanceCompatibility
+	if err = jsonutil.UnmarshalFile(managedInstanceCompatibilityPath, &custom); err != nil {
+		return compatibility, fmt.Errorf("failed to load managed instance compatibility config %v, %v", managedInstanceCompatibilityPath, err)
+	}
+	compatibility.IncompatibleDocuments = append(append([]string{}, builtInCompatibility.IncompatibleDocuments...), custom.IncompatibleDocuments...)
+	compatibility.Rewrites = append(append([]CommandRewrite{}, builtInCompatibility.Rewrites...), custom.Rewrites...)
+	return compatibility, nil
 }
 
 // RemoveDependencyOnInstanceMetadata looks for array of commands which will be executed as a part of this document and replace the incompatible code.
@@ -49,6 +100,11 @@ func RemoveDependencyOnInstanceMetadata(context context.T, docState *DocumentSta
 	var properties []interface{}
 	var parsedDocumentProperties managedInstanceDocumentProperties
 
+	compatibility, err := getManagedInstanceCompatibility()
+	if err != nil {
+		log.Errorf("Using the built-in managed instance compatibility rewrites. error: %v", err)
+	}
+
 	for index, pluginState := range docState.InstancePluginsInformation {
 		if pluginState.Name == appconfig.PluginNameAwsRunPowerShellScript {
 			err := jsonutil.Remarshal(pluginState.Configuration.Properties, &properties)
@@ -64,7 +120,7 @@ func RemoveDependencyOnInstanceMetadata(context context.T, docState *DocumentSta
 				return err
 			}
 
-			region, err := platform.Region()
+			region, err := getRegion()
 			if err != nil {
 				log.Errorf("Error retrieving agent region. error: %v", err)
 				return err
@@ -72,18 +128,12 @@ func RemoveDependencyOnInstanceMetadata(context context.T, docState *DocumentSta
 
 			// Comment or replace the incompatible code from this document.
 			log.Info("Replacing managed instance incompatible code for AWS SSM Document.")
-			for i, command := range parsedDocumentProperties.RunCommand {
-				// remove the call to metadata service to retrieve the region for onprem instances
-				if strings.Contains(command, "$metadataLocation = 'http://169.254.169.254/latest/dynamic/instance-identity/document/region'") {
-					parsedDocumentProperties.RunCommand[i] = strings.Replace(command, "$metadataLocation = 'http://169.254.169.254/latest/dynamic/instance-identity/document/region'", "# $metadataLocation = 'http://169.254.169.254/latest/dynamic/instance-identity/document/region' (This is done to make it managed instance compatible)", 1)
-				}
-
-				if strings.Contains(command, "$metadata = (New-Object Net.WebClient).DownloadString($metadataLocation)") {
-					parsedDocumentProperties.RunCommand[i] = strings.Replace(command, "$metadata = (New-Object Net.WebClient).DownloadString($metadataLocation)", "# $metadata = (New-Object Net.WebClient).DownloadString($metadataLocation) (This is done to make it managed instance compatible)", 1)
-				}
-
-				if strings.Contains(command, "$region = (ConvertFrom-JSON $metadata).region") {
-					parsedDocumentProperties.RunCommand[i] = strings.Replace(command, "$region = (ConvertFrom-JSON $metadata).region", "$region = '"+region+"'", 1)
+			for i := range parsedDocumentProperties.RunCommand {
+				for _, rewrite := range compatibility.Rewrites {
+					if rewrite.Match != "" && strings.Contains(parsedDocumentProperties.RunCommand[i], rewrite.Match) {
+						replacement := strings.Replace(rewrite.Replacement, RegionPlaceholder, region, -1)
+						parsedDocumentProperties.RunCommand[i] = strings.Replace(parsedDocumentProperties.RunCommand[i], rewrite.Match, replacement, 1)
+					}
 				}
 			}
 
@@ -99,7 +149,21 @@ func RemoveDependencyOnInstanceMetadata(context context.T, docState *DocumentSta
 	return nil
 }
 
+// ManagedInstanceIncompatibleDocuments returns the names of the documents that could contain incompatible code for managed instance
+func ManagedInstanceIncompatibleDocuments() []string {
+	// an unreadable custom config still leaves the built-in documents
+	compatibility, _ := getManagedInstanceCompatibility()
+	return compatibility.IncompatibleDocuments
+}
+
 // IsManagedInstanceIncompatibleAWSSSMDocument checks if doc could contain incompatible code for managed instance
 func IsManagedInstanceIncompatibleAWSSSMDocument(documentName string) bool {
-	return managedInstanceIncompatibleAWSSSMDocuments[documentName]
+	// an unreadable custom config still leaves the built-in documents to match
+	compatibility, _ := getManagedInstanceCompatibility()
+	for _, incompatibleDocument := range compatibility.IncompatibleDocuments {
+		if incompatibleDocument == documentName {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,100 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package model provides model definitions for document state
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+const customCompatibility = `{
+	"IncompatibleDocuments": ["Custom-ConfigureRegion"],
+	"Rewrites": [{"Match": "Get-EC2InstanceRegion", "Replacement": "'{{region}}'"}]
+}`
+
+// useCompatibilityConfig points the custom config at a file with the given content, and returns a function restoring it
+func useCompatibilityConfig(t *testing.T, content string) (restore func()) {
+	dir, err := ioutil.TempDir("", "compatibility")
+	assert.NoError(t, err)
+	pathOrig := managedInstanceCompatibilityPath
+	managedInstanceCompatibilityPath = filepath.Join(dir, appconfig.ManagedInstanceCompatibilityFileName)
+	assert.NoError(t, ioutil.WriteFile(managedInstanceCompatibilityPath, []byte(content), 0600))
+	return func() {
+		managedInstanceCompatibilityPath = pathOrig
+		os.RemoveAll(dir)
+	}
+}
+
+func TestIsManagedInstanceIncompatibleAWSSSMDocument_BuiltIn(t *testing.T) {
+	pathOrig := managedInstanceCompatibilityPath
+	defer func() { managedInstanceCompatibilityPath = pathOrig }()
+	managedInstanceCompatibilityPath = filepath.Join(os.TempDir(), "missing", appconfig.ManagedInstanceCompatibilityFileName)
+
+	assert.True(t, IsManagedInstanceIncompatibleAWSSSMDocument("AWS-FindWindowsUpdates"))
+	assert.False(t, IsManagedInstanceIncompatibleAWSSSMDocument("Custom-ConfigureRegion"))
+}
+
+func TestIsManagedInstanceIncompatibleAWSSSMDocument_Custom(t *testing.T) {
+	defer useCompatibilityConfig(t, customCompatibility)()
+
+	// the custom documents are matched along with the built-in ones
+	assert.True(t, IsManagedInstanceIncompatibleAWSSSMDocument("Custom-ConfigureRegion"))
+	assert.True(t, IsManagedInstanceIncompatibleAWSSSMDocument("AWS-FindWindowsUpdates"))
+	assert.False(t, IsManagedInstanceIncompatibleAWSSSMDocument("Custom-Other"))
+}
+
+func TestIsManagedInstanceIncompatibleAWSSSMDocument_InvalidCustom(t *testing.T) {
+	defer useCompatibilityConfig(t, "not json")()
+
+	// the built-in documents are still matched
+	assert.True(t, IsManagedInstanceIncompatibleAWSSSMDocument("AWS-FindWindowsUpdates"))
+	assert.False(t, IsManagedInstanceIncompatibleAWSSSMDocument("Custom-ConfigureRegion"))
+}
+
+func TestRemoveDependencyOnInstanceMetadata_CustomRewrite(t *testing.T) {
+	defer useCompatibilityConfig(t, customCompatibility)()
+	getRegionOrig := getRegion
+	defer func() { getRegion = getRegionOrig }()
+	getRegion = func() (string, error) {
+		return "us-west-2", nil
+	}
+
+	commands := []string{
+		"$region = Get-EC2InstanceRegion",
+		"$region = (ConvertFrom-JSON $metadata).region",
+	}
+	docState := DocumentState{
+		DocumentInformation: DocumentInfo{DocumentName: "Custom-ConfigureRegion"},
+		InstancePluginsInformation: []PluginState{{
+			Name: appconfig.PluginNameAwsRunPowerShellScript,
+			Configuration: contracts.Configuration{
+				Properties: []interface{}{map[string]interface{}{"runCommand": commands}},
+			},
+		}},
+	}
+
+	assert.NoError(t, RemoveDependencyOnInstanceMetadata(context.NewMockDefault(), &docState))
+
+	properties := docState.InstancePluginsInformation[0].Configuration.Properties.([]interface{})
+	rewritten := properties[0].(managedInstanceDocumentProperties).RunCommand
+	assert.Equal(t, []string{"$region = 'us-west-2'", "$region = 'us-west-2'"}, rewritten)
+}
@@ -62,6 +62,30 @@ type DocumentInfo struct {
 	DocumentTraceOutput string
 	RuntimeStatus       map[string]*contracts.PluginRuntimeStatus
 	RunCount            int
+	// RebootCount is the number of reboots requested by the document that have been honored
+	RebootCount int
+	// RebootRequestedBy is the id of the plugin that requested the last reboot of the document
+	RebootRequestedBy string
+	// AggregationPolicy is the rule the status of the document is computed from the statuses of its plugins with
+	AggregationPolicy string
+	// Priority orders the pending documents, see contracts.DocumentContent
+	Priority int
+	// RequiredTags are the tags an instance must have for the document to run on it, see contracts.DocumentContent
+	RequiredTags map[string]string
+	// ExpiresAfter is the time after which the document is skipped instead of started, see contracts.DocumentContent
+	ExpiresAfter string
+	// SecureParameterValues are the values of the parameters the document declares as SecureString,
+	// they are masked in the logged outputs and the replies of the document and are never persisted
+	SecureParameterValues []string `json:"-"`
+	// SecureParameterHashes are persisted in place of SecureParameterValues to recover them when the document is resumed
+	SecureParameterHashes []SecureValueHash
+}
+
+// SecureValueHash is the salted SHA-256 hash of a secure parameter value
+type SecureValueHash struct {
+	Salt   string
+	Hash   string
+	Length int
 }
 
 // DocumentState represents information relevant to a command that gets executed by agent
@@ -83,6 +107,11 @@ func (c *DocumentState) IsAssociation() bool {
 	return c.DocumentType == Association
 }
 
+// IsCancelCommand returns if documentType is a cancel command, received from MDS or the offline service
+func (c *DocumentState) IsCancelCommand() bool {
+	return c.DocumentType == CancelCommand || c.DocumentType == CancelCommandOffline
+}
+
 // CancelCommandInfo represents information relevant to a cancel-command that agent receives
 // TODO  This might be revisited when Agent-cli is written to list previously executed commands
 type CancelCommandInfo struct {
@@ -0,0 +1,117 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package statemanager helps persist documents state to disk
+package statemanager
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// movingFileSuffix is the suffix of the file a document state is written to before it is renamed in the target location
+const movingFileSuffix = ".moving"
+
+// documentStateFolders are the folders a document moves through, from the first one to the terminal one
+var documentStateFolders = []string{
+	appconfig.DefaultLocationOfPending,
+	appconfig.DefaultLocationOfCurrent,
+	appconfig.DefaultLocationOfCompleted,
+}
+
+// removeDocumentState removes the source of a moved document state
+var removeDocumentState = os.Remove
+
+// InterruptedMoveRecoverer is implemented by the stores that can resolve the documents left in several locations
+// by a move interrupted by a crash.
+type InterruptedMoveRecoverer interface {
+	RecoverInterruptedMoves(log log.T, instanceID string)
+}
+
+// RecoverInterruptedMoves wraps statemanager RecoverInterruptedMoves
+func (FileSystemStore) RecoverInterruptedMoves(log log.T, instanceID string) {
+	RecoverInterruptedMoves(log, instanceID)
+}
+
+// copyDocumentState writes the content of the source file to the destination file. The content is written to a
+// temporary file renamed to the destination, so the destination is either missing or complete.
+func copyDocumentState(absoluteSource, absoluteDestination string) (err error) {
+	content, err := fileutil.ReadAllText(absoluteSource)
+	if err != nil {
+		return err
+	}
+	movingFile := absoluteDestination + movingFileSuffix
+	if _, err = fileutil.WriteIntoFileWithPermissions(movingFile, content, os.FileMode(int(appconfig.ReadWriteAccess))); err != nil {
+		return err
+	}
+	if err = os.Rename(movingFile, absoluteDestination); err != nil {
+		fileutil.DeleteFile(movingFile)
+	}
+	return err
+}
+
+// RecoverInterruptedMoves resolves the documents a crash left in more than one of the pending, current and completed
+// folders. The copy in the folder closest to completed is kept if it is complete, the others are removed.
+// It must be called at startup, before the documents are resumed.
+func RecoverInterruptedMoves(log log.T, instanceID string) {
+	locations := make(map[string][]string)
+	for _, locationFolder := range documentStateFolders {
+		files, err := fileutil.GetFileNames(DocumentStateDir(instanceID, locationFolder))
+		if err != nil {
+			continue
+		}
+		for _, fileName := range files {
+			if strings.HasSuffix(fileName, movingFileSuffix) {
+				// the move didn't complete, the source is still in place
+				log.Infof("removing the incomplete copy %v of %v", fileName, locationFolder)
+				RemoveData(log, fileName, instanceID, locationFolder)
+				continue
+			}
+			locations[fileName] = append(locations[fileName], locationFolder)
+		}
+	}
+
+	for fileName, locationFolders := range locations {
+		if len(locationFolders) > 1 {
+			resolveInterruptedMove(log, fileName, instanceID, locationFolders)
+		}
+	}
+}
+
+// resolveInterruptedMove keeps the last complete copy of the document in the order of locationFolders and removes the
+// other ones. If no copy is complete, the last one is kept.
+func resolveInterruptedMove(log log.T, fileName, instanceID string, locationFolders []string) {
+	lockDocument(fileName)
+	defer unlockDocument(fileName)
+
+	kept := len(locationFolders) - 1
+	for i := len(locationFolders) - 1; i >= 0; i-- {
+		var docState model.DocumentState
+		if err := readDocStateFile(docStateFileName(fileName, instanceID, locationFolders[i]), &docState); err == nil {
+			kept = i
+			break
+		}
+	}
+
+	log.Infof("document %v was found in %v, keeping the copy in %v", fileName, locationFolders, locationFolders[kept])
+	for i, locationFolder := range locationFolders {
+		if i != kept {
+			RemoveData(log, fileName, instanceID, locationFolder)
+		}
+	}
+}
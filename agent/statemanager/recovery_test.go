@@ -0,0 +1,111 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package statemanager helps persist documents state to disk
+package statemanager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testInstanceID = "i-400e1090"
+	testDocumentID = "aws.ssm.1234.i-400e1090"
+)
+
+var logger = log.NewMockLog()
+
+// setupDocumentStateDirs persists the document in the current folder of a temporary data store
+func setupDocumentStateDirs(t *testing.T) (restore func()) {
+	dir, err := ioutil.TempDir("", "statemanager")
+	assert.NoError(t, err)
+	dataStorePathOrig := dataStorePath
+	dataStorePath = dir
+	for _, locationFolder := range documentStateFolders {
+		assert.NoError(t, fileutil.MakeDirs(DocumentStateDir(testInstanceID, locationFolder)))
+	}
+
+	var docState model.DocumentState
+	docState.DocumentInformation.DocumentID = testDocumentID
+	PersistData(logger, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+	return func() {
+		dataStorePath = dataStorePathOrig
+		os.RemoveAll(dir)
+	}
+}
+
+// persistedLocations returns the folders the document is persisted in
+func persistedLocations() (locationFolders []string) {
+	for _, locationFolder := range documentStateFolders {
+		if IsDocumentPersisted(testDocumentID, testInstanceID, locationFolder) {
+			locationFolders = append(locationFolders, locationFolder)
+		}
+	}
+	return locationFolders
+}
+
+func TestMoveDocumentState(t *testing.T) {
+	defer setupDocumentStateDirs(t)()
+
+	MoveDocumentState(logger, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCompleted}, persistedLocations())
+	docInfo := GetDocumentInfo(logger, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, testDocumentID, docInfo.DocumentID)
+}
+
+func TestRecoverInterruptedMovesKeepsCompleteTerminalCopy(t *testing.T) {
+	defer setupDocumentStateDirs(t)()
+
+	// simulates a crash after the document is written to completed and before it is removed from current
+	removeDocumentStateOrig := removeDocumentState
+	removeDocumentState = func(name string) error { return errors.New("crashed") }
+	MoveDocumentState(logger, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+	removeDocumentState = removeDocumentStateOrig
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted}, persistedLocations())
+
+	RecoverInterruptedMoves(logger, testInstanceID)
+
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCompleted}, persistedLocations())
+	docInfo := GetDocumentInfo(logger, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, testDocumentID, docInfo.DocumentID)
+}
+
+func TestRecoverInterruptedMovesKeepsSourceOfIncompleteCopy(t *testing.T) {
+	defer setupDocumentStateDirs(t)()
+
+	// simulates a crash in the middle of the writing of the document to completed
+	absoluteDestination := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.NoError(t, fileutil.WriteAllText(absoluteDestination+movingFileSuffix, `{"DocumentInformation": {`))
+
+	RecoverInterruptedMoves(logger, testInstanceID)
+
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCurrent}, persistedLocations())
+	assert.False(t, fileutil.Exists(absoluteDestination+movingFileSuffix))
+
+	// a truncated copy in the terminal folder is discarded as well
+	assert.NoError(t, fileutil.WriteAllText(absoluteDestination, `{"DocumentInformation": {`))
+
+	RecoverInterruptedMoves(logger, testInstanceID)
+
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCurrent}, persistedLocations())
+}
@@ -15,7 +15,6 @@
 package statemanager
 
 import (
-	"os"
 	"path"
 	"sync"
 
@@ -33,6 +32,9 @@ import (
 var lock sync.RWMutex
 var docLock = make(map[string]*sync.RWMutex)
 
+// dataStorePath is the directory the document states are persisted under
+var dataStorePath = appconfig.DefaultDataStorePath
+
 // GetDocumentInterimState returns CommandState object after reading file <fileName> from locationFolder
 // under defaultLogDir/instanceID
 func GetDocumentInterimState(log log.T, fileName, instanceID, locationFolder string) model.DocumentState {
@@ -47,6 +49,15 @@ func GetDocumentInterimState(log log.T, fileName, instanceID, locationFolder str
 	return docState
 }
 
+// GetData reads the object stored by PersistData in file <fileName> of locationFolder under defaultLogDir/instanceID
+func GetData(log log.T, fileName, instanceID, locationFolder string, object interface{}) error {
+
+	rLockDocument(fileName)
+	defer rUnlockDocument(fileName)
+
+	return readDocStateFile(docStateFileName(fileName, instanceID, locationFolder), object)
+}
+
 // PersistData stores the given object in the file-system in pretty Json indented format
 // This will override the contents of an already existing file
 func PersistData(log log.T, fileName, instanceID, locationFolder string, object interface{}) {
@@ -64,7 +75,7 @@ func PersistData(log log.T, fileName, instanceID, locationFolder string, object
 			log.Debugf("overwriting contents of %v", absoluteFileName)
 		}
 		log.Tracef("persisting interim state %v in file %v", jsonutil.Indent(content), absoluteFileName)
-		if s, err := fileutil.WriteIntoFileWithPermissions(absoluteFileName, jsonutil.Indent(content), os.FileMode(int(appconfig.ReadWriteAccess))); s && err == nil {
+		if s, err := writeDocStateFile(log, absoluteFileName, jsonutil.Indent(content)); s && err == nil {
 			log.Debugf("successfully persisted interim state in %v", locationFolder)
 		} else {
 			log.Debugf("persisting interim state in %v failed with error %v", locationFolder, err)
@@ -90,6 +101,18 @@ func IsDocumentCurrentlyExecuting(fileName, instanceID string) bool {
 	return fileutil.Exists(absoluteFileName)
 }
 
+// IsDocumentPersisted checks if document is present in the given location folder
+func IsDocumentPersisted(fileName, instanceID, locationFolder string) bool {
+	if len(fileName) == 0 {
+		return false
+	}
+
+	rLockDocument(fileName)
+	defer rUnlockDocument(fileName)
+
+	return fileutil.Exists(docStateFileName(fileName, instanceID, locationFolder))
+}
+
 // RemoveData deletes the fileName from locationFolder under defaultLogDir/instanceID
 func RemoveData(log log.T, commandID, instanceID, locationFolder string) {
 
@@ -103,28 +126,23 @@ func RemoveData(log log.T, commandID, instanceID, locationFolder string) {
 	}
 }
 
-// MoveDocumentState moves the document file to target location
+// MoveDocumentState moves the document file to target location.
+// The file is first written to the target location and only then removed from the source location,
+// so that a crash during the move leaves at least one complete copy that RecoverInterruptedMoves resolves.
 func MoveDocumentState(log log.T, fileName, instanceID, srcLocationFolder, dstLocationFolder string) {
 
 	//get a lock for documentID specific lock
 	lockDocument(fileName)
 
-	absoluteSource := path.Join(appconfig.DefaultDataStorePath,
-		instanceID,
-		appconfig.DefaultDocumentRootDirName,
-		appconfig.DefaultLocationOfState,
-		srcLocationFolder)
+	absoluteSource := docStateFileName(fileName, instanceID, srcLocationFolder)
+	absoluteDestination := docStateFileName(fileName, instanceID, dstLocationFolder)
 
-	absoluteDestination := path.Join(appconfig.DefaultDataStorePath,
-		instanceID,
-		appconfig.DefaultDocumentRootDirName,
-		appconfig.DefaultLocationOfState,
-		dstLocationFolder)
-
-	if s, err := fileutil.MoveFile(fileName, absoluteSource, absoluteDestination); s && err == nil {
-		log.Debugf("moved file %v from %v to %v successfully", fileName, srcLocationFolder, dstLocationFolder)
-	} else {
+	if err := copyDocumentState(absoluteSource, absoluteDestination); err != nil {
 		log.Debugf("moving file %v from %v to %v failed with error %v", fileName, srcLocationFolder, dstLocationFolder, err)
+	} else if err = removeDocumentState(absoluteSource); err != nil {
+		log.Debugf("moving file %v from %v to %v failed to remove the source with error %v", fileName, srcLocationFolder, dstLocationFolder, err)
+	} else {
+		log.Debugf("moved file %v from %v to %v successfully", fileName, srcLocationFolder, dstLocationFolder)
 	}
 
 	//release documentID specific lock - before deleting the entry from the map
@@ -222,7 +240,7 @@ func PersistPluginState(log log.T, pluginState model.PluginState, pluginID, comm
 
 // DocumentStateDir returns absolute filename where command states are persisted
 func DocumentStateDir(instanceID, locationFolder string) string {
-	return filepath.Join(appconfig.DefaultDataStorePath,
+	return filepath.Join(dataStorePath,
 		instanceID,
 		appconfig.DefaultDocumentRootDirName,
 		appconfig.DefaultLocationOfState,
@@ -233,7 +251,7 @@ func DocumentStateDir(instanceID, locationFolder string) string {
 func getDocState(log log.T, fileName string) model.DocumentState {
 
 	var commandState model.DocumentState
-	err := jsonutil.UnmarshalFile(fileName, &commandState)
+	err := readDocStateFile(fileName, &commandState)
 	if err != nil {
 		log.Errorf("encountered error with message %v while reading Interim state of command from file - %v", err, fileName)
 	} else {
@@ -260,7 +278,7 @@ func setDocState(log log.T, commandState model.DocumentState, absoluteFileName,
 			log.Debugf("overwriting contents of %v", absoluteFileName)
 		}
 		log.Tracef("persisting interim state %v in file %v", jsonutil.Indent(content), absoluteFileName)
-		if s, err := fileutil.WriteIntoFileWithPermissions(absoluteFileName, jsonutil.Indent(content), os.FileMode(int(appconfig.ReadWriteAccess))); s && err == nil {
+		if s, err := writeDocStateFile(log, absoluteFileName, jsonutil.Indent(content)); s && err == nil {
 			log.Debugf("successfully persisted interim state in %v", locationFolder)
 		} else {
 			log.Debugf("persisting interim state in %v failed with error %v", locationFolder, err)
@@ -0,0 +1,89 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package statemanager helps persist documents state to disk
+package statemanager
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/statemanager/model"
+)
+
+// DocumentStateStore persists the state of the documents in the pending, current and completed locations, and the
+// other objects the processor keeps about the documents and messages in their own locations.
+type DocumentStateStore interface {
+	GetDocumentInterimState(log log.T, documentID, instanceID, locationFolder string) model.DocumentState
+	GetData(log log.T, documentID, instanceID, locationFolder string, object interface{}) error
+	PersistData(log log.T, documentID, instanceID, locationFolder string, object interface{})
+	RemoveData(log log.T, documentID, instanceID, locationFolder string)
+	PersistDocumentInfo(log log.T, docInfo model.DocumentInfo, documentID, instanceID, locationFolder string)
+	MoveDocumentState(log log.T, documentID, instanceID, srcLocationFolder, dstLocationFolder string)
+	IsDocumentPersisted(documentID, instanceID, locationFolder string) bool
+	ListDocuments(log log.T, instanceID, locationFolder string) (documentIDs []string, err error)
+}
+
+// FileSystemStore keeps the state of each document in a file of the location folder under defaultLogDir/instanceID.
+type FileSystemStore struct{}
+
+// GetDocumentInterimState wraps statemanager GetDocumentInterimState
+func (FileSystemStore) GetDocumentInterimState(log log.T, documentID, instanceID, locationFolder string) model.DocumentState {
+	return GetDocumentInterimState(log, documentID, instanceID, locationFolder)
+}
+
+// GetData wraps statemanager GetData
+func (FileSystemStore) GetData(log log.T, documentID, instanceID, locationFolder string, object interface{}) error {
+	return GetData(log, documentID, instanceID, locationFolder, object)
+}
+
+// RemoveData wraps statemanager RemoveData
+func (FileSystemStore) RemoveData(log log.T, documentID, instanceID, locationFolder string) {
+	RemoveData(log, documentID, instanceID, locationFolder)
+}
+
+// PersistData wraps statemanager PersistData
+func (FileSystemStore) PersistData(log log.T, documentID, instanceID, locationFolder string, object interface{}) {
+	PersistData(log, documentID, instanceID, locationFolder, object)
+}
+
+// PersistDocumentInfo wraps statemanager PersistDocumentInfo
+func (FileSystemStore) PersistDocumentInfo(log log.T, docInfo model.DocumentInfo, documentID, instanceID, locationFolder string) {
+	PersistDocumentInfo(log, docInfo, documentID, instanceID, locationFolder)
+}
+
+// MoveDocumentState wraps statemanager MoveDocumentState
+func (FileSystemStore) MoveDocumentState(log log.T, documentID, instanceID, srcLocationFolder, dstLocationFolder string) {
+	MoveDocumentState(log, documentID, instanceID, srcLocationFolder, dstLocationFolder)
+}
+
+// IsDocumentPersisted wraps statemanager IsDocumentPersisted
+func (FileSystemStore) IsDocumentPersisted(documentID, instanceID, locationFolder string) bool {
+	return IsDocumentPersisted(documentID, instanceID, locationFolder)
+}
+
+// ListDocuments returns the ids of the documents persisted in the location folder
+func (FileSystemStore) ListDocuments(log log.T, instanceID, locationFolder string) (documentIDs []string, err error) {
+	location := DocumentStateDir(instanceID, locationFolder)
+	if isDirectoryEmpty, _ := fileutil.IsDirEmpty(location); isDirectoryEmpty {
+		return nil, nil
+	}
+
+	files, err := fileutil.ReadDir(location)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		documentIDs = append(documentIDs, f.Name())
+	}
+	return documentIDs, nil
+}
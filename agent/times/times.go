@@ -60,6 +60,25 @@ func ToIsoDashUTC(t time.Time) string {
 	return fmt.Sprintf("%04d-%02d-%02dT%02d-%02d-%02d.%03dZ", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1000000)
 }
 
+// ToIso8601InLocation converts a time into a string in Iso8601 format in the given timezone (yyyy-MM-ddTHH:mm:ss.fff±hh:mm).
+// It is meant for human-facing output; times that are persisted or compared should stay in UTC.
+func ToIso8601InLocation(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// LoadLocation returns the location for a timezone name.
+// UTC is returned when the name is empty, and along with an error when the name is unknown.
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC, fmt.Errorf("unknown timezone %v, %v", name, err)
+	}
+	return loc, nil
+}
+
 // ParseIso8601UTC parses a time in Iso8601 format and UTC timezone (yyyy-MM-ddTHH:mm:ss.fffZ).
 func ParseIso8601UTC(t string) time.Time {
 	var y int
@@ -37,6 +37,26 @@ func ExampleToIsoDashUTC() {
 	// Output: 2015-06-30T00-29-03.148Z
 }
 
+func ExampleToIso8601InLocation() {
+	tokyo := time.FixedZone("JST", 9*3600)
+	fmt.Println(ToIso8601InLocation(time.Date(2015, 6, 30, 20, 29, 4, 569000000, time.UTC), tokyo))
+	// Output: 2015-07-01T05:29:04.569+09:00
+}
+
+func TestLoadLocation(t *testing.T) {
+	loc, err := LoadLocation("")
+	assert.NoError(t, err)
+	assert.Equal(t, time.UTC, loc)
+
+	loc, err = LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", loc.String())
+
+	loc, err = LoadLocation("Not/AZone")
+	assert.Error(t, err)
+	assert.Equal(t, time.UTC, loc)
+}
+
 func TestParseIso8601UTC(t *testing.T) {
 	date := ParseIso8601UTC("2015-06-30T00:29:04.569Z")
 	assert.Equal(t, date, time.Date(2015, 6, 30, 0, 29, 4, 569000000, time.UTC))
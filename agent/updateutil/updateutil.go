@@ -209,10 +209,18 @@ func (util *Utility) CreateInstanceContext(log log.T) (context *InstanceContext,
 	}
 	platformName := ""
 	platformVersion := ""
-	installerName := ""
 	if platformName, err = getPlatformName(log); err != nil {
 		return
 	}
+	if platformVersion, err = getPlatformVersion(log); err != nil {
+		return
+	}
+	return NewInstanceContext(log, region, platformName, platformVersion), nil
+}
+
+// NewInstanceContext maps the platform name reported by the OS to the platform and installer names used for updates
+func NewInstanceContext(log log.T, region string, platformName string, platformVersion string) *InstanceContext {
+	installerName := ""
 	platformName = strings.ToLower(platformName)
 	if strings.Contains(platformName, PlatformAmazonLinux) {
 		platformName = PlatformLinux
@@ -235,10 +243,7 @@ func (util *Utility) CreateInstanceContext(log log.T) (context *InstanceContext,
 		installerName = PlatformWindows
 	}
 
-	if platformVersion, err = getPlatformVersion(log); err != nil {
-		return
-	}
-	context = &InstanceContext{
+	return &InstanceContext{
 		Region:          region,
 		Platform:        platformName,
 		PlatformVersion: platformVersion,
@@ -246,8 +251,6 @@ func (util *Utility) CreateInstanceContext(log log.T) (context *InstanceContext,
 		Arch:            runtime.GOARCH,
 		CompressFormat:  CompressFormat,
 	}
-
-	return context, nil
 }
 
 // CreateUpdateDownloadFolder creates folder for storing update downloads